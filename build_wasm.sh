@@ -0,0 +1,18 @@
+#!/bin/bash
+set -e
+
+echo "Building Noxy for WebAssembly..."
+
+# 1. Compile the VM with the browser entry point
+rm -rf wasm_dist
+mkdir -p wasm_dist
+GOOS=js GOARCH=wasm go build -o wasm_dist/noxy.wasm ./cmd/noxy-wasm
+
+# 2. Add the Go JS support file and the playground page
+cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" wasm_dist/
+cp cmd/noxy-wasm/playground/index.html wasm_dist/
+
+echo "--------------------------------------------------"
+echo "Playground ready in wasm_dist/"
+echo "Serve it with e.g. 'python3 -m http.server -d wasm_dist' and open http://localhost:8000"
+echo "--------------------------------------------------"
@@ -0,0 +1,4 @@
+Write-Host "Building Messaging Plugin..."
+go mod tidy
+go build -o noxy-plugin-messaging.exe .
+Write-Host "Done. Created noxy-plugin-messaging.exe"
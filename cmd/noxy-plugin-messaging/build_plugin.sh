@@ -0,0 +1,5 @@
+#!/bin/bash
+echo "Building Messaging Plugin..."
+go mod tidy
+go build -o noxy-plugin-messaging .
+echo "Done. Created ./noxy-plugin-messaging"
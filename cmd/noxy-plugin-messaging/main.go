@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+)
+
+// RPC Types (Must match internal/plugin/plugin.go)
+type PluginRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type PluginResponse struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Client bundles the SQS and SNS clients created from one connect call.
+type Client struct {
+	SQS *sqs.Client
+	SNS *sns.Client
+}
+
+// Global State
+var (
+	Clients     = make(map[string]*Client)
+	ClientsLock sync.Mutex
+
+	// LastError describes the most recent failed request, or is nil if the
+	// last request succeeded. Requests are handled one at a time.
+	LastError map[string]interface{}
+)
+
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+	// SQS messages can be up to 256KB, above the scanner's default limit.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	encoder := json.NewEncoder(os.Stdout)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var req PluginRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			sendError(encoder, fmt.Sprintf("Parse error: %v", err))
+			continue
+		}
+
+		res, err := handleRequest(req)
+		response := PluginResponse{Result: res}
+		if err != nil {
+			response.Error = err.Error()
+		}
+		if req.Method != "last_error" {
+			LastError = describeError(err)
+		}
+
+		if err := encoder.Encode(response); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode response: %v\n", err)
+		}
+	}
+}
+
+func sendError(enc *json.Encoder, msg string) {
+	enc.Encode(PluginResponse{Error: msg})
+}
+
+func handleRequest(req PluginRequest) (interface{}, error) {
+	switch req.Method {
+	case "connect":
+		return handleConnect(req.Params)
+	case "last_error":
+		return LastError, nil
+	case "get_queue_url":
+		return handleGetQueueUrl(req.Params)
+	case "send_message":
+		return handleSendMessage(req.Params)
+	case "receive_message":
+		return handleReceiveMessage(req.Params)
+	case "delete_message":
+		return handleDeleteMessage(req.Params)
+	case "publish":
+		return handlePublish(req.Params)
+	default:
+		return nil, fmt.Errorf("unknown method: %s", req.Method)
+	}
+}
+
+func handleConnect(params []interface{}) (interface{}, error) {
+	// Params: [options_map]
+	// options: {region, endpoint_url, profile, access_key, secret_key,
+	//           session_token, max_attempts, max_backoff_ms}
+	if len(params) < 1 {
+		return nil, fmt.Errorf("expected options map")
+	}
+
+	options, ok := params[0].(map[string]interface{})
+	if !ok {
+		options = make(map[string]interface{})
+	}
+
+	region := "us-east-1"
+	if r, ok := options["region"].(string); ok {
+		region = r
+	}
+
+	loadOpts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+
+	if profile, ok := options["profile"].(string); ok && profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(profile))
+	}
+
+	accessKey, _ := options["access_key"].(string)
+	secretKey, _ := options["secret_key"].(string)
+	sessionToken, _ := options["session_token"].(string)
+	if accessKey != "" || secretKey != "" {
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("access_key and secret_key must be given together")
+		}
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(accessKey, secretKey, sessionToken),
+		))
+	}
+
+	maxAttempts, _ := options["max_attempts"].(float64)
+	maxBackoff, _ := options["max_backoff_ms"].(float64)
+	if maxAttempts > 0 || maxBackoff > 0 {
+		loadOpts = append(loadOpts, config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				if maxAttempts > 0 {
+					o.MaxAttempts = int(maxAttempts)
+				}
+				if maxBackoff > 0 {
+					o.MaxBackoff = time.Duration(maxBackoff) * time.Millisecond
+				}
+			})
+		}))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %v", err)
+	}
+
+	endpoint, _ := options["endpoint_url"].(string)
+	client := &Client{
+		SQS: sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}),
+		SNS: sns.NewFromConfig(cfg, func(o *sns.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}),
+	}
+	clientId := uuid.New().String()
+
+	ClientsLock.Lock()
+	Clients[clientId] = client
+	ClientsLock.Unlock()
+
+	return clientId, nil
+}
+
+func handleGetQueueUrl(params []interface{}) (interface{}, error) {
+	// Params: [clientId, queueName]
+	if len(params) < 2 {
+		return nil, fmt.Errorf("expected client_id, queue_name")
+	}
+
+	clientId, _ := params[0].(string)
+	name, _ := params[1].(string)
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	out, err := client.SQS.GetQueueUrl(context.TODO(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aws.ToString(out.QueueUrl), nil
+}
+
+func handleSendMessage(params []interface{}) (interface{}, error) {
+	// Params: [clientId, queueUrl, body, options?]
+	// options: {delay_seconds, attributes: {name: string}, group_id, deduplication_id}
+	if len(params) < 3 {
+		return nil, fmt.Errorf("expected client_id, queue_url, body")
+	}
+
+	clientId, _ := params[0].(string)
+	queueUrl, _ := params[1].(string)
+	body, ok := params[2].(string)
+	if !ok {
+		return nil, fmt.Errorf("body must be a string")
+	}
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	opts := optionsAt(params, 3)
+	in := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueUrl),
+		MessageBody: aws.String(body),
+	}
+	if v, ok := opts["delay_seconds"].(float64); ok && v > 0 {
+		in.DelaySeconds = int32(v)
+	}
+	if v, ok := opts["group_id"].(string); ok && v != "" {
+		in.MessageGroupId = aws.String(v)
+	}
+	if v, ok := opts["deduplication_id"].(string); ok && v != "" {
+		in.MessageDeduplicationId = aws.String(v)
+	}
+	if attrs, ok := opts["attributes"].(map[string]interface{}); ok {
+		in.MessageAttributes = make(map[string]sqstypes.MessageAttributeValue, len(attrs))
+		for k, v := range attrs {
+			in.MessageAttributes[k] = sqstypes.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(fmt.Sprintf("%v", v)),
+			}
+		}
+	}
+
+	out, err := client.SQS.SendMessage(context.TODO(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	return aws.ToString(out.MessageId), nil
+}
+
+func handleReceiveMessage(params []interface{}) (interface{}, error) {
+	// Params: [clientId, queueUrl, options?]
+	// options: {max_messages (1-10), wait_seconds (0-20, long polling),
+	//           visibility_timeout}
+	if len(params) < 2 {
+		return nil, fmt.Errorf("expected client_id, queue_url")
+	}
+
+	clientId, _ := params[0].(string)
+	queueUrl, _ := params[1].(string)
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	opts := optionsAt(params, 2)
+	in := &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueUrl),
+		MaxNumberOfMessages:   1,
+		MessageAttributeNames: []string{"All"},
+	}
+	if v, ok := opts["max_messages"].(float64); ok && v > 0 {
+		in.MaxNumberOfMessages = int32(v)
+	}
+	if v, ok := opts["wait_seconds"].(float64); ok && v > 0 {
+		in.WaitTimeSeconds = int32(v)
+	}
+	if v, ok := opts["visibility_timeout"].(float64); ok && v > 0 {
+		in.VisibilityTimeout = int32(v)
+	}
+
+	out, err := client.SQS.ReceiveMessage(context.TODO(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]interface{}, 0, len(out.Messages))
+	for _, m := range out.Messages {
+		attrs := make(map[string]interface{}, len(m.MessageAttributes))
+		for k, v := range m.MessageAttributes {
+			attrs[k] = aws.ToString(v.StringValue)
+		}
+		messages = append(messages, map[string]interface{}{
+			"id":             aws.ToString(m.MessageId),
+			"receipt_handle": aws.ToString(m.ReceiptHandle),
+			"body":           aws.ToString(m.Body),
+			"attributes":     attrs,
+		})
+	}
+
+	return messages, nil
+}
+
+func handleDeleteMessage(params []interface{}) (interface{}, error) {
+	// Params: [clientId, queueUrl, receiptHandle]
+	if len(params) < 3 {
+		return nil, fmt.Errorf("expected client_id, queue_url, receipt_handle")
+	}
+
+	clientId, _ := params[0].(string)
+	queueUrl, _ := params[1].(string)
+	handle, _ := params[2].(string)
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	_, err := client.SQS.DeleteMessage(context.TODO(), &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueUrl),
+		ReceiptHandle: aws.String(handle),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func handlePublish(params []interface{}) (interface{}, error) {
+	// Params: [clientId, topicArn, message, options?]
+	// options: {subject, attributes: {name: string}, group_id, deduplication_id}
+	if len(params) < 3 {
+		return nil, fmt.Errorf("expected client_id, topic_arn, message")
+	}
+
+	clientId, _ := params[0].(string)
+	topicArn, _ := params[1].(string)
+	message, ok := params[2].(string)
+	if !ok {
+		return nil, fmt.Errorf("message must be a string")
+	}
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	opts := optionsAt(params, 3)
+	in := &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Message:  aws.String(message),
+	}
+	if v, ok := opts["subject"].(string); ok && v != "" {
+		in.Subject = aws.String(v)
+	}
+	if v, ok := opts["group_id"].(string); ok && v != "" {
+		in.MessageGroupId = aws.String(v)
+	}
+	if v, ok := opts["deduplication_id"].(string); ok && v != "" {
+		in.MessageDeduplicationId = aws.String(v)
+	}
+	if attrs, ok := opts["attributes"].(map[string]interface{}); ok {
+		in.MessageAttributes = make(map[string]snstypes.MessageAttributeValue, len(attrs))
+		for k, v := range attrs {
+			in.MessageAttributes[k] = snstypes.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(fmt.Sprintf("%v", v)),
+			}
+		}
+	}
+
+	out, err := client.SNS.Publish(context.TODO(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	return aws.ToString(out.MessageId), nil
+}
+
+// optionsAt returns the options map at params[pos], or an empty map.
+func optionsAt(params []interface{}, pos int) map[string]interface{} {
+	if len(params) > pos {
+		if m, ok := params[pos].(map[string]interface{}); ok {
+			return m
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// describeError converts an error into the {code, message, retryable} map
+// returned by last_error.
+func describeError(err error) map[string]interface{} {
+	if err == nil {
+		return nil
+	}
+
+	code := "PluginError"
+	message := err.Error()
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+		message = apiErr.ErrorMessage()
+	}
+
+	retryable := retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+	if _, ok := retry.DefaultThrottleErrorCodes[code]; ok {
+		retryable = true
+	}
+
+	return map[string]interface{}{
+		"code":      code,
+		"message":   message,
+		"retryable": retryable,
+	}
+}
+
+func getClient(id string) *Client {
+	ClientsLock.Lock()
+	defer ClientsLock.Unlock()
+	return Clients[id]
+}
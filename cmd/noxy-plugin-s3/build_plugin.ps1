@@ -0,0 +1,4 @@
+Write-Host "Building S3 Plugin..."
+go mod tidy
+go build -o noxy-plugin-s3.exe .
+Write-Host "Done. Created noxy-plugin-s3.exe"
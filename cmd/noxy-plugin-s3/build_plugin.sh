@@ -0,0 +1,5 @@
+#!/bin/bash
+echo "Building S3 Plugin..."
+go mod tidy
+go build -o noxy-plugin-s3 .
+echo "Done. Created ./noxy-plugin-s3"
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+)
+
+// RPC Types (Must match internal/plugin/plugin.go)
+type PluginRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type PluginResponse struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Global State
+var (
+	Clients     = make(map[string]*s3.Client)
+	ClientsLock sync.Mutex
+
+	// LastError describes the most recent failed request, or is nil if the
+	// last request succeeded. Requests are handled one at a time.
+	LastError map[string]interface{}
+)
+
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+	// Object bodies travel inline, so allow lines well beyond the 64KB default.
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+	encoder := json.NewEncoder(os.Stdout)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var req PluginRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			sendError(encoder, fmt.Sprintf("Parse error: %v", err))
+			continue
+		}
+
+		res, err := handleRequest(req)
+		response := PluginResponse{Result: res}
+		if err != nil {
+			response.Error = err.Error()
+		}
+		if req.Method != "last_error" {
+			LastError = describeError(err)
+		}
+
+		if err := encoder.Encode(response); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode response: %v\n", err)
+		}
+	}
+}
+
+func sendError(enc *json.Encoder, msg string) {
+	enc.Encode(PluginResponse{Error: msg})
+}
+
+func handleRequest(req PluginRequest) (interface{}, error) {
+	switch req.Method {
+	case "connect":
+		return handleConnect(req.Params)
+	case "last_error":
+		return LastError, nil
+	case "put_object":
+		return handlePutObject(req.Params)
+	case "get_object":
+		return handleGetObject(req.Params)
+	case "list_objects":
+		return handleListObjects(req.Params)
+	case "delete_object":
+		return handleDeleteObject(req.Params)
+	case "presign":
+		return handlePresign(req.Params)
+	case "create_multipart_upload":
+		return handleCreateMultipartUpload(req.Params)
+	case "upload_part":
+		return handleUploadPart(req.Params)
+	case "complete_multipart_upload":
+		return handleCompleteMultipartUpload(req.Params)
+	case "abort_multipart_upload":
+		return handleAbortMultipartUpload(req.Params)
+	default:
+		return nil, fmt.Errorf("unknown method: %s", req.Method)
+	}
+}
+
+func handleConnect(params []interface{}) (interface{}, error) {
+	// Params: [options_map]
+	// options: {region, endpoint_url, profile, access_key, secret_key,
+	//           session_token, path_style, max_attempts, max_backoff_ms}
+	if len(params) < 1 {
+		return nil, fmt.Errorf("expected options map")
+	}
+
+	options, ok := params[0].(map[string]interface{})
+	if !ok {
+		options = make(map[string]interface{})
+	}
+
+	region := "us-east-1"
+	if r, ok := options["region"].(string); ok {
+		region = r
+	}
+
+	loadOpts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+
+	if profile, ok := options["profile"].(string); ok && profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(profile))
+	}
+
+	accessKey, _ := options["access_key"].(string)
+	secretKey, _ := options["secret_key"].(string)
+	sessionToken, _ := options["session_token"].(string)
+	if accessKey != "" || secretKey != "" {
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("access_key and secret_key must be given together")
+		}
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(accessKey, secretKey, sessionToken),
+		))
+	}
+
+	maxAttempts, _ := options["max_attempts"].(float64)
+	maxBackoff, _ := options["max_backoff_ms"].(float64)
+	if maxAttempts > 0 || maxBackoff > 0 {
+		loadOpts = append(loadOpts, config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				if maxAttempts > 0 {
+					o.MaxAttempts = int(maxAttempts)
+				}
+				if maxBackoff > 0 {
+					o.MaxBackoff = time.Duration(maxBackoff) * time.Millisecond
+				}
+			})
+		}))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %v", err)
+	}
+
+	// endpoint_url + path_style make MinIO and LocalStack usable.
+	endpoint, _ := options["endpoint_url"].(string)
+	pathStyle, _ := options["path_style"].(bool)
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = pathStyle
+	})
+	clientId := uuid.New().String()
+
+	ClientsLock.Lock()
+	Clients[clientId] = client
+	ClientsLock.Unlock()
+
+	return clientId, nil
+}
+
+func handlePutObject(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, key, body, options?]
+	// options: {content_type, encoding: "base64"}
+	if len(params) < 4 {
+		return nil, fmt.Errorf("expected client_id, bucket, key, body")
+	}
+
+	client, bucket, key, err := objectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := optionsAt(params, 4)
+	body, err := decodeBody(params[3], opts)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	}
+	if ct, ok := opts["content_type"].(string); ok && ct != "" {
+		in.ContentType = aws.String(ct)
+	}
+
+	out, err := client.PutObject(context.TODO(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"etag": aws.ToString(out.ETag),
+	}, nil
+}
+
+func handleGetObject(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, key, options?]
+	// options: {encoding: "base64"}
+	if len(params) < 3 {
+		return nil, fmt.Errorf("expected client_id, bucket, key")
+	}
+
+	client, bucket, key, err := objectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, nil // Not found = null
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object body: %v", err)
+	}
+
+	body := string(data)
+	if enc, _ := optionsAt(params, 3)["encoding"].(string); enc == "base64" {
+		body = base64.StdEncoding.EncodeToString(data)
+	}
+
+	lastModified := ""
+	if out.LastModified != nil {
+		lastModified = out.LastModified.UTC().Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"body":          body,
+		"content_type":  aws.ToString(out.ContentType),
+		"size":          len(data),
+		"etag":          aws.ToString(out.ETag),
+		"last_modified": lastModified,
+	}, nil
+}
+
+func handleListObjects(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, options?]
+	// options: {prefix, delimiter, max_keys, continuation_token}
+	if len(params) < 2 {
+		return nil, fmt.Errorf("expected client_id, bucket")
+	}
+
+	clientId, _ := params[0].(string)
+	bucket, _ := params[1].(string)
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	opts := optionsAt(params, 2)
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	}
+	if v, ok := opts["prefix"].(string); ok && v != "" {
+		in.Prefix = aws.String(v)
+	}
+	if v, ok := opts["delimiter"].(string); ok && v != "" {
+		in.Delimiter = aws.String(v)
+	}
+	if v, ok := opts["continuation_token"].(string); ok && v != "" {
+		in.ContinuationToken = aws.String(v)
+	}
+	if v, ok := opts["max_keys"].(float64); ok && v > 0 {
+		in.MaxKeys = aws.Int32(int32(v))
+	}
+
+	out, err := client.ListObjectsV2(context.TODO(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]interface{}, 0, len(out.Contents))
+	for _, o := range out.Contents {
+		lastModified := ""
+		if o.LastModified != nil {
+			lastModified = o.LastModified.UTC().Format(time.RFC3339)
+		}
+		objects = append(objects, map[string]interface{}{
+			"key":           aws.ToString(o.Key),
+			"size":          aws.ToInt64(o.Size),
+			"etag":          aws.ToString(o.ETag),
+			"last_modified": lastModified,
+		})
+	}
+
+	prefixes := make([]interface{}, 0, len(out.CommonPrefixes))
+	for _, p := range out.CommonPrefixes {
+		prefixes = append(prefixes, aws.ToString(p.Prefix))
+	}
+
+	// next_token is "" once the listing is exhausted.
+	return map[string]interface{}{
+		"objects":    objects,
+		"prefixes":   prefixes,
+		"next_token": aws.ToString(out.NextContinuationToken),
+	}, nil
+}
+
+func handleDeleteObject(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, key]
+	if len(params) < 3 {
+		return nil, fmt.Errorf("expected client_id, bucket, key")
+	}
+
+	client, bucket, key, err := objectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func handlePresign(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, key, method ("GET"|"PUT"), expires_seconds]
+	if len(params) < 5 {
+		return nil, fmt.Errorf("expected client_id, bucket, key, method, expires_seconds")
+	}
+
+	client, bucket, key, err := objectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	method, _ := params[3].(string)
+	expires, _ := params[4].(float64)
+	if expires <= 0 {
+		expires = 900
+	}
+
+	presigner := s3.NewPresignClient(client, s3.WithPresignExpires(time.Duration(expires)*time.Second))
+
+	switch method {
+	case "GET", "get":
+		req, err := presigner.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return req.URL, nil
+	case "PUT", "put":
+		req, err := presigner.PresignPutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return req.URL, nil
+	default:
+		return nil, fmt.Errorf("unsupported presign method: %s", method)
+	}
+}
+
+func handleCreateMultipartUpload(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, key, options?]
+	// options: {content_type}
+	if len(params) < 3 {
+		return nil, fmt.Errorf("expected client_id, bucket, key")
+	}
+
+	client, bucket, key, err := objectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if ct, ok := optionsAt(params, 3)["content_type"].(string); ok && ct != "" {
+		in.ContentType = aws.String(ct)
+	}
+
+	out, err := client.CreateMultipartUpload(context.TODO(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	return aws.ToString(out.UploadId), nil
+}
+
+func handleUploadPart(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, key, uploadId, partNumber, body, options?]
+	// options: {encoding: "base64"}
+	if len(params) < 6 {
+		return nil, fmt.Errorf("expected client_id, bucket, key, upload_id, part_number, body")
+	}
+
+	client, bucket, key, err := objectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadId, _ := params[3].(string)
+	partNumber, _ := params[4].(float64)
+	if partNumber < 1 {
+		return nil, fmt.Errorf("part_number must be >= 1")
+	}
+
+	body, err := decodeBody(params[5], optionsAt(params, 6))
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := client.UploadPart(context.TODO(), &s3.UploadPartInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadId),
+		PartNumber: aws.Int32(int32(partNumber)),
+		Body:       bytes.NewReader(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aws.ToString(out.ETag), nil
+}
+
+func handleCompleteMultipartUpload(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, key, uploadId, parts]
+	// parts: [{part_number, etag}, ...]
+	if len(params) < 5 {
+		return nil, fmt.Errorf("expected client_id, bucket, key, upload_id, parts")
+	}
+
+	client, bucket, key, err := objectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadId, _ := params[3].(string)
+	rawParts, ok := params[4].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("parts must be an array")
+	}
+
+	parts := make([]types.CompletedPart, 0, len(rawParts))
+	for i, raw := range rawParts {
+		p, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("part %d must be a map", i)
+		}
+		num, _ := p["part_number"].(float64)
+		etag, _ := p["etag"].(string)
+		parts = append(parts, types.CompletedPart{
+			PartNumber: aws.Int32(int32(num)),
+			ETag:       aws.String(etag),
+		})
+	}
+
+	out, err := client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"etag":     aws.ToString(out.ETag),
+		"location": aws.ToString(out.Location),
+	}, nil
+}
+
+func handleAbortMultipartUpload(params []interface{}) (interface{}, error) {
+	// Params: [clientId, bucket, key, uploadId]
+	if len(params) < 4 {
+		return nil, fmt.Errorf("expected client_id, bucket, key, upload_id")
+	}
+
+	client, bucket, key, err := objectParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadId, _ := params[3].(string)
+	_, err = client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+// objectParams extracts the [clientId, bucket, key] prefix shared by the
+// object-level methods.
+func objectParams(params []interface{}) (*s3.Client, string, string, error) {
+	clientId, _ := params[0].(string)
+	bucket, _ := params[1].(string)
+	key, _ := params[2].(string)
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, "", "", fmt.Errorf("client not found: %s", clientId)
+	}
+	if bucket == "" || key == "" {
+		return nil, "", "", fmt.Errorf("bucket and key are required")
+	}
+	return client, bucket, key, nil
+}
+
+// optionsAt returns the options map at params[pos], or an empty map.
+func optionsAt(params []interface{}, pos int) map[string]interface{} {
+	if len(params) > pos {
+		if m, ok := params[pos].(map[string]interface{}); ok {
+			return m
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// decodeBody turns a body parameter into raw bytes. Bodies are strings;
+// binary data is sent base64-encoded with options.encoding = "base64".
+func decodeBody(raw interface{}, opts map[string]interface{}) ([]byte, error) {
+	body, ok := raw.(string)
+	if !ok {
+		return nil, fmt.Errorf("body must be a string")
+	}
+	if enc, _ := opts["encoding"].(string); enc == "base64" {
+		data, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 body: %v", err)
+		}
+		return data, nil
+	}
+	return []byte(body), nil
+}
+
+// describeError converts an error into the {code, message, retryable} map
+// returned by last_error.
+func describeError(err error) map[string]interface{} {
+	if err == nil {
+		return nil
+	}
+
+	code := "PluginError"
+	message := err.Error()
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+		message = apiErr.ErrorMessage()
+	}
+
+	retryable := retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+	if _, ok := retry.DefaultThrottleErrorCodes[code]; ok {
+		retryable = true
+	}
+
+	return map[string]interface{}{
+		"code":      code,
+		"message":   message,
+		"retryable": retryable,
+	}
+}
+
+func getClient(id string) *s3.Client {
+	ClientsLock.Lock()
+	defer ClientsLock.Unlock()
+	return Clients[id]
+}
@@ -0,0 +1,65 @@
+//go:build js && wasm
+
+// Command noxy-wasm runs Noxy programs in the browser. It registers a
+// global noxyRun(source) function that compiles and runs source and
+// returns {output, error}; see playground/ for a page that uses it.
+//
+// SQLite natives are not available in this build, and the os, io and net
+// natives only reach what the JavaScript host provides.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"noxy-vm/internal/ast"
+	"noxy-vm/internal/compiler"
+	"noxy-vm/internal/lexer"
+	"noxy-vm/internal/parser"
+	"noxy-vm/internal/vm"
+	"strings"
+	"syscall/js"
+)
+
+func main() {
+	js.Global().Set("noxyRun", js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 1 {
+			return result("", "noxyRun expects the program source")
+		}
+		output, err := run(args[0].String())
+		if err != nil {
+			return result(output, err.Error())
+		}
+		return result(output, "")
+	}))
+	// Keep the Go runtime alive so noxyRun stays callable
+	select {}
+}
+
+// run compiles and runs one program on a fresh VM, capturing everything it
+// prints on stdout and stderr.
+func run(source string) (string, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return "", fmt.Errorf("%s", strings.Join(p.Errors(), "\n"))
+	}
+
+	c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), "playground")
+	chunk, _, err := c.Compile(program)
+	if err != nil {
+		return "", fmt.Errorf("Compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	machine := vm.NewWithConfig(vm.VMConfig{RootPath: ".", Stdout: &out, Stderr: &out})
+	err = machine.Interpret(chunk)
+	machine.Close()
+	if err != nil {
+		return out.String(), fmt.Errorf("Runtime error: %s", err)
+	}
+	return out.String(), nil
+}
+
+func result(output, errMsg string) map[string]any {
+	return map[string]any{"output": output, "error": errMsg}
+}
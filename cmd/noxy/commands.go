@@ -0,0 +1,361 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"noxy-vm/internal/format"
+	"noxy-vm/internal/pkgmanager"
+	"noxy-vm/internal/version"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// commands are the subcommands of noxy, in the order usage lists them.
+var commands = []struct {
+	name    string
+	args    string // what follows the name in a usage line
+	summary string
+	help    string // shown by `noxy help <name>` above the options
+}{
+	{"run", "[options] file [args...]", "Run a program",
+		"Runs a script, or a program compiled by 'noxy build', passing it the arguments\nafter the file. 'noxy file' is short for 'noxy run file'."},
+	{"build", "[-o file] file", "Compile a program to a .nxc file",
+		"Compiles a script to a .nxc file that 'noxy run' runs without parsing or\ncompiling it again."},
+	{"test", "[options] [paths...]", "Run the tests in *_test.nx files",
+		"Runs every test_ function in the *_test.nx files under paths (the current\ndirectory by default) and reports which failed."},
+	{"get", "[options] [packages...]", "Install or update packages",
+		"Installs packages (github.com/user/repo[@version]) and adds them to noxy.mod.\nPinned versions are imported from the package cache, or from noxy_libs once\nthe project is vendored. With -u, upgrades them, or every direct dependency\nwhen none are given, to the latest compatible version."},
+	{"vendor", "[options]", "Copy dependencies into noxy_libs",
+		"Copies every package required by noxy.mod, and their dependencies, into\nnoxy_libs and marks the project as vendored: from then on its imports are\nresolved from noxy_libs only, never from the package cache."},
+	{"list", "", "List dependencies and their versions",
+		"Lists every package required by noxy.mod, directly or by another package,\nwith its version. Indirect dependencies are marked // indirect."},
+	{"remove", "package", "Remove a dependency",
+		"Removes a direct dependency from noxy.mod, and from noxy_libs along with any\nof its dependencies that nothing else needs."},
+	{"why", "package", "Show why a package is required",
+		"Prints the shortest chain of requirements from the project to a package."},
+	{"update", "[options] [packages...]", "Upgrade dependencies",
+		"Upgrades packages, or every direct dependency when none are given, to the\nlatest version with the same major version. Same as 'noxy get -u'."},
+	{"publish", "version", "Publish a version of the package",
+		"Tags the current commit with version, pushes the tag and adds the release to\nthe package index named by NOXY_REGISTRY."},
+	{"search", "words...", "Search the package index",
+		"Lists the packages in the index named by NOXY_REGISTRY whose path or\ndescription contains every word."},
+	{"repl", "[options]", "Start the interactive prompt",
+		"Starts the interactive prompt, as running noxy without arguments does."},
+	{"fmt", "[-check] [paths...]", "Format source files",
+		"Reindents .nx files in place: files given by name, and those under directories\n(the current directory by default), skipping hidden directories and noxy_libs."},
+	{"version", "", "Print the version", "Prints the version of noxy."},
+	{"bench-vm", "[options] [paths...]", "Benchmark the VM on a set of programs",
+		"Times each program under paths and compares the medians with a baseline."},
+	{"help", "[command]", "Show help for a command", "Shows the usage and options of a command."},
+}
+
+// usage prints the top-level help.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: noxy <command> [arguments]\n       noxy [options] file [args...]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nOptions before a command or file are those of run ('noxy help run').\nRun 'noxy help <command>' for the options of a command.\n")
+}
+
+// newFlagSet returns the flag set of a subcommand, whose -h prints its
+// usage line, help text and options.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name != name {
+				continue
+			}
+			fmt.Fprintf(fs.Output(), "Usage: noxy %s %s\n\n%s\n", name, cmd.args, cmd.help)
+		}
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\nOptions:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// addRunFlags adds the options that configure how programs run to fs.
+// They set the same variables whichever flag set parses them, so
+// `noxy --strict run x.nx` and `noxy run --strict x.nx` agree.
+func addRunFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&showDisassembly, "disassembly", showDisassembly, "Show bytecode disassembly")
+	fs.BoolVar(&noModuleCache, "no-cache", noModuleCache, "Do not cache compiled modules in .noxy-cache")
+	fs.BoolVar(&checkedArithmetic, "checked", checkedArithmetic, "Raise runtime errors on integer overflow, truncating negative integer division and NaN/Inf float results")
+	fs.BoolVar(&strictTypes, "strict", strictTypes, "Check declared types of variables, parameters and return values at runtime")
+	fs.BoolVar(&preloadModules, "preload", preloadModules, "Load every imported module before the program starts instead of on first use")
+	fs.BoolVar(&leakReport, "report-leaks", leakReport, "List files, databases and sockets still open when the program exits")
+	fs.BoolVar(&scriptWorkDir, "script-dir", scriptWorkDir, "Resolve relative file paths against the program's directory instead of the current directory")
+	fs.BoolVar(&traceLines, "trace", traceLines, "Print each executed source line to stderr")
+	fs.BoolVar(&traceOps, "trace-ops", traceOps, "Print each executed instruction with the top of the stack to stderr")
+	fs.StringVar(&traceFilter, "trace-func", traceFilter, "Only trace inside the function with this name")
+	fs.IntVar(&traceMax, "trace-limit", traceMax, "Stop tracing after this many events (0 for no limit)")
+}
+
+// runREPL implements `noxy repl [options]`.
+func runREPL(args []string) {
+	fs := newFlagSet("repl")
+	addRunFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	startREPL(showDisassembly)
+}
+
+// runGet implements `noxy get [options] [packages...]`.
+func runGet(args []string) {
+	fs := newFlagSet("get")
+	upgrade := fs.Bool("u", false, "Upgrade the packages, or every direct dependency, to the latest compatible version")
+	vendor := fs.Bool("vendor", false, "Vendor the project, as 'noxy vendor' does, after installing any given")
+	fs.BoolVar(&pkgmanager.Offline, "offline", pkgmanager.Offline, "Never access the network; install packages from the cache only")
+	fs.BoolVar(&pkgmanager.AllowBuild, "allow-build", pkgmanager.AllowBuild, "Run the build commands declared by installed packages, unsandboxed (only for packages you trust)")
+	fs.Parse(args)
+	if fs.NArg() == 0 && !*upgrade && !*vendor {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *upgrade && fs.NArg() == 0 {
+		if err := pkgmanager.Update(""); err != nil {
+			fmt.Printf("Error updating packages: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, pkg := range fs.Args() {
+		if *upgrade {
+			if err := pkgmanager.Update(pkg); err != nil {
+				fmt.Printf("Error updating packages: %s\n", err)
+				os.Exit(1)
+			}
+			continue
+		}
+		if err := pkgmanager.Get(pkg); err != nil {
+			fmt.Printf("Error getting package: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if *vendor {
+		if err := pkgmanager.Vendor(); err != nil {
+			fmt.Printf("Error vendoring packages: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runVendor implements `noxy vendor [options]`.
+func runVendor(args []string) {
+	fs := newFlagSet("vendor")
+	fs.BoolVar(&pkgmanager.Offline, "offline", pkgmanager.Offline, "Never access the network; install packages from the cache only")
+	fs.BoolVar(&pkgmanager.AllowBuild, "allow-build", pkgmanager.AllowBuild, "Run the build commands declared by installed packages, unsandboxed (only for packages you trust)")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := pkgmanager.Vendor(); err != nil {
+		fmt.Printf("Error vendoring packages: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runList implements `noxy list`.
+func runList(args []string) {
+	fs := newFlagSet("list")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := pkgmanager.List(); err != nil {
+		fmt.Printf("Error listing packages: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runRemove implements `noxy remove package`.
+func runRemove(args []string) {
+	fs := newFlagSet("remove")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := pkgmanager.Remove(fs.Arg(0)); err != nil {
+		fmt.Printf("Error removing package: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runWhy implements `noxy why package`.
+func runWhy(args []string) {
+	fs := newFlagSet("why")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := pkgmanager.Why(fs.Arg(0)); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runUpdate implements `noxy update [options] [packages...]`.
+func runUpdate(args []string) {
+	fs := newFlagSet("update")
+	fs.BoolVar(&pkgmanager.Offline, "offline", pkgmanager.Offline, "Never access the network; install packages from the cache only")
+	fs.BoolVar(&pkgmanager.AllowBuild, "allow-build", pkgmanager.AllowBuild, "Run the build commands declared by installed packages, unsandboxed (only for packages you trust)")
+	fs.Parse(args)
+
+	pkgs := fs.Args()
+	if len(pkgs) == 0 {
+		pkgs = []string{""}
+	}
+	for _, pkg := range pkgs {
+		if err := pkgmanager.Update(pkg); err != nil {
+			fmt.Printf("Error updating packages: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runPublish implements `noxy publish version`.
+func runPublish(args []string) {
+	fs := newFlagSet("publish")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := pkgmanager.Publish(fs.Arg(0)); err != nil {
+		fmt.Printf("Error publishing package: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runSearch implements `noxy search words...`.
+func runSearch(args []string) {
+	fs := newFlagSet("search")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := pkgmanager.Search(strings.Join(fs.Args(), " ")); err != nil {
+		fmt.Printf("Error searching packages: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runFmt implements `noxy fmt [-check] [paths...]`.
+func runFmt(args []string) {
+	fs := newFlagSet("fmt")
+	check := fs.Bool("check", false, "List the files that are not formatted instead of rewriting them, and fail if there are any")
+	fs.Parse(args)
+
+	files, err := sourceFiles(fs.Args())
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Printf("Error reading file: %s\n", err)
+			failed = true
+			continue
+		}
+		formatted, err := format.Source(string(content))
+		if err != nil {
+			fmt.Printf("%s:\n%s\n", file, err)
+			failed = true
+			continue
+		}
+		if formatted == string(content) {
+			continue
+		}
+		if *check {
+			fmt.Println(file)
+			failed = true
+			continue
+		}
+		if err := os.WriteFile(file, []byte(formatted), 0644); err != nil {
+			fmt.Printf("Error writing file: %s\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// sourceFiles expands paths into the .nx files to format: files named
+// directly, and those under directories, skipping hidden ones and
+// noxy_libs.
+func sourceFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if p != path && (strings.HasPrefix(name, ".") || name == "noxy_libs") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(p, ".nx") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// runVersion implements `noxy version`.
+func runVersion(args []string) {
+	fs := newFlagSet("version")
+	fs.Parse(args)
+	fmt.Printf("Noxy %s\n", version.Version)
+}
+
+// runHelp implements `noxy help [command]`.
+func runHelp(args []string) {
+	fs := newFlagSet("help")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+		return
+	}
+	// A command's -h prints its help and exits
+	if !runSubcommand(fs.Arg(0), []string{"-h"}) {
+		fmt.Fprintf(os.Stderr, "Unknown command %q. Run 'noxy help' for the list of commands.\n", fs.Arg(0))
+		os.Exit(2)
+	}
+}
@@ -2,45 +2,42 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"noxy-vm/internal/ast"
+	"noxy-vm/internal/benchrunner"
+	"noxy-vm/internal/chunk"
 	"noxy-vm/internal/compiler"
 	"noxy-vm/internal/lexer"
 	"noxy-vm/internal/parser"
 	"noxy-vm/internal/pkgmanager"
+	"noxy-vm/internal/testrunner"
 	"noxy-vm/internal/token"
+	"noxy-vm/internal/value"
 	"noxy-vm/internal/version"
 	"noxy-vm/internal/vm"
 	"os"
 	"path/filepath"
-	"runtime/debug"
+	"strconv"
 	"strings"
 )
 
 func main() {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered from panic:", r)
-			debug.PrintStack()
-		}
-	}()
-
-	// Parse flags
-	showDisassembly := flag.Bool("disassembly", false, "Show bytecode disassembly")
+	addRunFlags(flag.CommandLine)
 	showVersion := flag.Bool("version", false, "Show version information")
 	showHelp := flag.Bool("help", false, "Show help message")
-
-	// Custom Usage to show double dashes
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: noxy [options] [file]\n\nOptions:\n")
-		flag.VisitAll(func(f *flag.Flag) {
-			fmt.Fprintf(os.Stderr, "  --%s\n\t%s\n", f.Name, f.Usage)
-		})
-	}
-
-	getPkg := flag.String("get", "", "Download and install a package (e.g. github.com/user/repo@version)")
+	flag.Usage = usage
+
+	// Package manager options from before `noxy get`; each stands for a
+	// subcommand
+	getPkg := flag.String("get", "", "Same as 'noxy get package'")
+	vendor := flag.Bool("vendor", false, "Same as 'noxy vendor'")
+	update := flag.Bool("update", false, "Same as 'noxy update [packages...]'")
+	flag.BoolVar(&pkgmanager.Offline, "offline", false, "Never access the network; install packages from the cache only")
+	flag.BoolVar(&pkgmanager.AllowBuild, "allow-build", false, "Run the build commands declared by installed packages, unsandboxed (only for packages you trust)")
 	flag.Parse()
 
 	if *showHelp {
@@ -49,15 +46,19 @@ func main() {
 	}
 
 	if *showVersion {
-		fmt.Printf("Noxy %s\n", version.Version)
+		runVersion(nil)
 		return
 	}
 
-	if *getPkg != "" {
-		if err := pkgmanager.Get(*getPkg); err != nil {
-			fmt.Printf("Error getting package: %s\n", err)
-			os.Exit(1)
-		}
+	switch {
+	case *getPkg != "":
+		runSubcommand("get", []string{*getPkg})
+		return
+	case *vendor:
+		runSubcommand("vendor", nil)
+		return
+	case *update:
+		runSubcommand("update", flag.Args())
 		return
 	}
 
@@ -65,49 +66,315 @@ func main() {
 	args := flag.Args()
 
 	if len(args) < 1 {
-		startREPL(*showDisassembly)
+		startREPL(showDisassembly)
 		return
 	}
 
+	if !runSubcommand(args[0], args[1:]) {
+		// `noxy file` is short for `noxy run file`
+		runProgram(args)
+	}
+}
+
+// runSubcommand runs the subcommand name, reporting false when there is
+// no such command.
+func runSubcommand(name string, args []string) bool {
+	switch name {
+	case "run":
+		runCommand(args)
+	case "build":
+		runBuild(args)
+	case "test":
+		runTests(args)
+	case "get":
+		runGet(args)
+	case "vendor":
+		runVendor(args)
+	case "list":
+		runList(args)
+	case "remove":
+		runRemove(args)
+	case "why":
+		runWhy(args)
+	case "update":
+		runUpdate(args)
+	case "publish":
+		runPublish(args)
+	case "search":
+		runSearch(args)
+	case "repl":
+		runREPL(args)
+	case "fmt":
+		runFmt(args)
+	case "version":
+		runVersion(args)
+	case "bench-vm":
+		runBenchVM(args)
+	case "help":
+		runHelp(args)
+	default:
+		return false
+	}
+	return true
+}
+
+// runCommand implements `noxy run [options] file [args...]`.
+func runCommand(args []string) {
+	fs := newFlagSet("run")
+	addRunFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	runProgram(fs.Args())
+}
+
+// runProgram runs the program args[0], a script or a file written by
+// `noxy build`, passing it the rest of args.
+func runProgram(args []string) {
 	filename := args[0]
+	scriptArgs = args[1:]
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Printf("Error reading file: %s\n", err)
+		if errors.Is(err, os.ErrNotExist) && !strings.ContainsAny(filename, "./\\") {
+			fmt.Printf("Unknown command or file %q. Run 'noxy help' for the list of commands.\n", filename)
+		} else {
+			fmt.Printf("Error reading file: %s\n", err)
+		}
+		os.Exit(1)
+	}
+
+	// Files written by `noxy build` run without parsing or compiling
+	if chunk.IsSerialized(content) {
+		compiled, err := chunk.Deserialize(content)
+		if err != nil {
+			fmt.Printf("Error loading %s: %s\n", filename, err)
+			os.Exit(1)
+		}
+		runChunk(compiled, getDir(filename), showDisassembly)
 		return
 	}
 
-	runWithConfig(filename, string(content), getDir(filename), *showDisassembly)
+	runWithConfig(filename, string(content), getDir(filename), showDisassembly)
+}
+
+// runBuild implements `noxy build [-o file] file`, which compiles a script
+// to a .nxc file that `noxy run` executes directly.
+func runBuild(args []string) {
+	fs := newFlagSet("build")
+	out := fs.String("o", "", "Output file (default: the script's name with a .nxc extension)")
+	fs.Parse(args)
+	// Options may also follow the file: noxy build script.nx -o script.nxc
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	filename := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Printf("Error reading file: %s\n", err)
+		os.Exit(1)
+	}
+	compiled := compileSource(filename, string(content))
+	data, err := compiled.Serialize()
+	if err != nil {
+		fmt.Printf("Error: cannot build %s: %s\n", filename, err)
+		os.Exit(1)
+	}
+
+	target := *out
+	if target == "" {
+		target = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".nxc"
+	}
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		fmt.Printf("Error writing file: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runTests implements `noxy test [options] [paths...]`.
+func runTests(args []string) {
+	fs := newFlagSet("test")
+	addRunFlags(fs)
+	update := fs.Bool("update", false, "Write golden files instead of comparing against them")
+	parallel := fs.Int("p", 1, "Number of test files to run at once")
+	fs.Parse(args)
+
+	res, err := testrunner.Run(fs.Args(), testrunner.Options{
+		Update:   *update,
+		Config:   vmConfig,
+		Strict:   strictTypes,
+		Parallel: *parallel,
+	})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d passed, %d failed\n", res.Passed, res.Failed)
+	if res.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runBenchVM implements `noxy bench-vm [-n N] [-o file] [-baseline file]
+// [-threshold pct] [paths...]`.
+func runBenchVM(args []string) {
+	fs := newFlagSet("bench-vm")
+	runs := fs.Int("n", 5, "Number of timed runs per program")
+	out := fs.String("o", "", "Write the results as JSON to this file")
+	baseline := fs.String("baseline", "", "Compare with the results in this JSON file")
+	threshold := fs.Float64("threshold", 10, "Percent slowdown of the median that counts as a regression")
+	fs.Parse(args)
+
+	opts := benchrunner.Options{
+		Runs:      *runs,
+		Config:    vmConfig,
+		Threshold: *threshold,
+	}
+	if *baseline != "" {
+		base, err := benchrunner.ReadReport(*baseline)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		opts.Baseline = base
+	}
+	report, err := benchrunner.Run(fs.Args(), opts)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if *out != "" {
+		if err := report.WriteFile(*out); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(report.Regressions) > 0 {
+		fmt.Printf("%d regression(s): %s\n", len(report.Regressions), strings.Join(report.Regressions, ", "))
+		os.Exit(1)
+	}
 }
 
 func getDir(path string) string {
 	return filepath.Dir(path)
 }
 
+// Settings from the options of run, repl and test (see addRunFlags).
+var (
+	// showDisassembly prints the bytecode before running (--disassembly).
+	showDisassembly bool
+	// noModuleCache turns off the compiled module cache in
+	// <root>/.noxy-cache (--no-cache).
+	noModuleCache bool
+	// checkedArithmetic enables vm.VMConfig.CheckedArithmetic (--checked).
+	checkedArithmetic bool
+	// strictTypes enables compiler.Compiler.Strict and vm.VMConfig.Strict (--strict).
+	strictTypes bool
+	// leakReport enables vm.VMConfig.ReportLeaks (--report-leaks).
+	leakReport bool
+	// preloadModules enables vm.VMConfig.PreloadModules (--preload).
+	preloadModules bool
+	// scriptWorkDir sets vm.VMConfig.WorkDir to the program's directory
+	// (--script-dir).
+	scriptWorkDir bool
+	// Tracing settings from --trace, --trace-ops, --trace-func and
+	// --trace-limit.
+	traceLines  bool
+	traceOps    bool
+	traceFilter string
+	traceMax    = 10000
+)
+
+// scriptArgs are the arguments after the program's file name.
+var scriptArgs []string
+
+func vmConfig(rootPath string) vm.VMConfig {
+	cfg := vm.VMConfig{
+		RootPath:          rootPath,
+		CheckedArithmetic: checkedArithmetic,
+		Strict:            strictTypes,
+		ReportLeaks:       leakReport,
+		PreloadModules:    preloadModules,
+		Trace:             traceMode(),
+		TraceFunc:         traceFilter,
+		TraceLimit:        traceMax,
+		Args:              scriptArgs,
+	}
+	if !noModuleCache {
+		cfg.ModuleCache = filepath.Join(rootPath, vm.ModuleCacheDir)
+	}
+	if scriptWorkDir {
+		cfg.WorkDir = rootPath
+	}
+	return cfg
+}
+
+// traceMode is the vm.TraceMode chosen by --trace and --trace-ops.
+func traceMode() vm.TraceMode {
+	switch {
+	case traceOps:
+		return vm.TraceOps
+	case traceLines:
+		return vm.TraceLines
+	}
+	return vm.TraceOff
+}
+
+// historyLimit is how many lines ~/.noxy_history keeps.
+const historyLimit = 1000
+
 func startREPL(showDisasm bool) {
-	fmt.Printf("Noxy REPL %s\n", version.Version)
-	fmt.Println("Type 'exit' to quit.")
+	repl(os.Stdin, os.Stdout, vmConfig("."), historyPath(), showDisasm)
+}
 
-	// Shared VM for persistence
-	machine := vm.NewWithConfig(vm.VMConfig{RootPath: "."})
-	scanner := bufio.NewScanner(os.Stdin)
+// repl runs an interactive session reading lines from in and writing
+// prompts, results and program output to out. Lines are added to the
+// history file at history unless it is empty.
+func repl(in io.Reader, out io.Writer, config vm.VMConfig, history string, showDisasm bool) {
+	fmt.Fprintf(out, "Noxy REPL %s\n", version.Version)
+	fmt.Fprintln(out, "Type 'exit' to quit, ':help' for commands.")
 
-	// Persist globals across REPL lines
-	replGlobals := make(map[string]ast.NoxyType)
+	// Shared VM for persistence
+	config.Stdout = out
+	machine := vm.NewWithConfig(config)
+	defer machine.Close()
+	scanner := bufio.NewScanner(in)
+
+	r := &replSession{
+		machine:    machine,
+		out:        out,
+		globals:    make(map[string]ast.NoxyType),
+		structs:    make(map[string]*ast.StructStatement),
+		showDisasm: showDisasm,
+	}
+	r.openHistory(history)
+	defer r.closeHistory()
 
 	var inputBuffer string
 
 	for {
 		if inputBuffer == "" {
-			fmt.Print(">>> ")
+			fmt.Fprint(out, ">>> ")
 		} else {
-			fmt.Print("... ")
+			fmt.Fprint(out, "... ")
+		}
+		if f, ok := out.(*os.File); ok {
+			f.Sync()
 		}
-		os.Stdout.Sync()
 
 		if !scanner.Scan() {
 			break
 		}
 		line := scanner.Text()
+		r.remember(line)
 
 		if strings.TrimSpace(line) == "exit" {
 			break
@@ -118,6 +385,12 @@ func startREPL(showDisasm bool) {
 			continue
 		}
 
+		// Meta commands start with ':' and only outside multiline input
+		if inputBuffer == "" && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			r.command(strings.TrimSpace(line))
+			continue
+		}
+
 		// Append to buffer
 		if inputBuffer == "" {
 			inputBuffer = line
@@ -125,80 +398,299 @@ func startREPL(showDisasm bool) {
 			inputBuffer += "\n" + line
 		}
 
-		// 1. Parse
-		l := lexer.New(inputBuffer)
-		p := parser.New(l)
-		program := p.ParseProgram()
-
-		if len(p.Errors()) > 0 {
-			// Check for incomplete input
-			isIncomplete := false
-			for _, msg := range p.Errors() {
-				// We look for errors indicating we hit EOF unexpectedly
-				// "found end of file" (from token.Display) or "found EOF" (literal fallback)
-				if strings.Contains(msg, "found end of file") || strings.Contains(msg, "found EOF") {
-					isIncomplete = true
-					break
-				}
-			}
+		if r.eval(inputBuffer) {
+			inputBuffer = "" // Reset buffer after execution
+		}
+	}
+}
 
-			if isIncomplete {
-				// Continue reading
-				continue
-			}
+// replSession is the state a REPL keeps between inputs.
+type replSession struct {
+	machine    *vm.VM
+	out        io.Writer
+	globals    map[string]ast.NoxyType
+	structs    map[string]*ast.StructStatement
+	source     []string // inputs that ran successfully, for :save
+	showDisasm bool
+
+	history     []string
+	historyFile *os.File
+}
 
-			// Real Error
-			for _, msg := range p.Errors() {
-				fmt.Printf("%s\n", msg)
+// eval parses, compiles and runs src in the session. It returns false
+// when src stops in the middle of a statement and needs more lines.
+func (r *replSession) eval(src string) bool {
+	// 1. Parse
+	l := lexer.New(src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) > 0 {
+		// Check for incomplete input
+		for _, msg := range p.Errors() {
+			// We look for errors indicating we hit EOF unexpectedly
+			// "found end of file" (from token.Display) or "found EOF" (literal fallback)
+			if strings.Contains(msg, "found end of file") || strings.Contains(msg, "found EOF") {
+				return false
 			}
-			inputBuffer = "" // Reset
-			continue
 		}
 
-		if len(program.Statements) == 1 {
-			if exprStmt, ok := program.Statements[0].(*ast.ExpressionStmt); ok {
-				// Wrap in print call
-				// print(expr)
-				callExpr := &ast.CallExpression{
+		// Real Error
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(r.out, "%s\n", msg)
+		}
+		return true
+	}
+
+	// 2. A lone expression prints its value
+	if len(program.Statements) == 1 {
+		if exprStmt, ok := program.Statements[0].(*ast.ExpressionStmt); ok {
+			// Wrap in print call
+			// print(expr)
+			callExpr := &ast.CallExpression{
+				Token: token.Token{Type: token.IDENTIFIER, Literal: "print"},
+				Function: &ast.Identifier{
 					Token: token.Token{Type: token.IDENTIFIER, Literal: "print"},
-					Function: &ast.Identifier{
-						Token: token.Token{Type: token.IDENTIFIER, Literal: "print"},
-						Value: "print",
-					},
-					Arguments: []ast.Expression{exprStmt.Expression},
-				}
-				// Replace statement
-				program.Statements[0] = &ast.ExpressionStmt{
-					Token:      exprStmt.Token,
-					Expression: callExpr,
-				}
+					Value: "print",
+				},
+				Arguments: []ast.Expression{exprStmt.Expression},
+			}
+			// Replace statement
+			program.Statements[0] = &ast.ExpressionStmt{
+				Token:      exprStmt.Token,
+				Expression: callExpr,
 			}
 		}
+	}
+
+	// 3. Compile
+	c := compiler.NewWithState(r.globals, r.structs, "REPL")
+	c.Strict = strictTypes
+	chunk, _, err := c.Compile(program)
+	if err != nil {
+		fmt.Fprintf(r.out, "Compiler error: %s\n", err)
+		return true
+	}
 
-		// 3. Compile
-		c := compiler.NewWithState(replGlobals, make(map[string]*ast.StructStatement), "REPL")
-		chunk, _, err := c.Compile(program)
+	// Update globals
+	r.globals = c.GetGlobals()
+
+	// 4. Disassembly (optional)
+	if r.showDisasm {
+		chunk.DisassembleAll("REPL")
+	}
+
+	// 5. Interpret (using shared VM)
+	// VM.Interpret resets stack but keeps globals (which we want).
+	err = r.machine.Interpret(chunk)
+	var exit *vm.ExitError
+	if errors.As(err, &exit) {
+		// sys.exit leaves the REPL; deferred cleanups would not run
+		r.closeHistory()
+		r.machine.Close()
+		os.Exit(exit.Code)
+	}
+	if err != nil {
+		fmt.Fprintf(r.out, "Runtime error: %s\n", err)
+		return true
+	}
+	r.source = append(r.source, src)
+	return true
+}
+
+const replHelp = `Commands:
+  :load <file>   Run a file in this session
+  :save <file>   Write the inputs that ran so far to a file
+  :type <expr>   Show the static type of an expression without running it
+  :dis <name>    Disassemble a function
+  :reset         Forget all definitions and close open handles
+  :history [n]   Show the last n (default 20) lines of ~/.noxy_history
+  :help          Show this list
+  exit           Leave the REPL`
+
+// command runs a ':' meta command.
+func (r *replSession) command(line string) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case ":help":
+		fmt.Fprintln(r.out, replHelp)
+
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(r.out, "Usage: :load <file>")
+			return
+		}
+		content, err := os.ReadFile(arg)
 		if err != nil {
-			fmt.Printf("Compiler error: %s\n", err)
-			inputBuffer = "" // Reset
-			continue
+			fmt.Fprintf(r.out, "Error reading file: %s\n", err)
+			return
+		}
+		if !r.eval(string(content)) {
+			fmt.Fprintf(r.out, "%s: unexpected end of file\n", arg)
 		}
 
-		// Update globals
-		replGlobals = c.GetGlobals()
+	case ":save":
+		if arg == "" {
+			fmt.Fprintln(r.out, "Usage: :save <file>")
+			return
+		}
+		content := strings.Join(r.source, "\n")
+		if content != "" {
+			content += "\n"
+		}
+		if err := os.WriteFile(arg, []byte(content), 0644); err != nil {
+			fmt.Fprintf(r.out, "Error writing file: %s\n", err)
+			return
+		}
+		fmt.Fprintf(r.out, "Saved %d inputs to %s\n", len(r.source), arg)
 
-		// 4. Disassembly (optional)
-		if showDisasm {
-			chunk.DisassembleAll("REPL")
+	case ":type":
+		if arg == "" {
+			fmt.Fprintln(r.out, "Usage: :type <expr>")
+			return
+		}
+		t, err := r.typeOf(arg)
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			return
 		}
+		fmt.Fprintln(r.out, t)
 
-		// 5. Interpret (using shared VM)
-		// VM.Interpret resets stack but keeps globals (which we want).
-		if err := machine.Interpret(chunk); err != nil {
-			fmt.Printf("Runtime error: %s\n", err)
+	case ":dis":
+		if arg == "" {
+			fmt.Fprintln(r.out, "Usage: :dis <name>")
+			return
+		}
+		v, ok := r.machine.GetGlobal(arg)
+		if !ok {
+			fmt.Fprintf(r.out, "Undefined: %s\n", arg)
+			return
+		}
+		var fn *value.ObjFunction
+		switch obj := v.Obj.(type) {
+		case *value.ObjFunction:
+			fn = obj
+		case *value.ObjClosure:
+			fn = obj.Function
+		}
+		if fn == nil {
+			fmt.Fprintf(r.out, "%s is not a function\n", arg)
+			return
+		}
+		if fnChunk, ok := fn.Chunk.(*chunk.Chunk); ok {
+			fnChunk.DisassembleAll(fn.Name)
 		}
 
-		inputBuffer = "" // Reset buffer after execution
+	case ":reset":
+		r.machine.Reset()
+		r.globals = make(map[string]ast.NoxyType)
+		r.structs = make(map[string]*ast.StructStatement)
+		r.source = nil
+		fmt.Fprintln(r.out, "Session reset.")
+
+	case ":history":
+		n := 20
+		if arg != "" {
+			var err error
+			if n, err = strconv.Atoi(arg); err != nil || n < 0 {
+				fmt.Fprintln(r.out, "Usage: :history [n]")
+				return
+			}
+		}
+		// The last entry is this very command
+		past := r.history[:len(r.history)-1]
+		if n < len(past) {
+			past = past[len(past)-n:]
+		}
+		for _, h := range past {
+			fmt.Fprintln(r.out, h)
+		}
+
+	default:
+		fmt.Fprintf(r.out, "Unknown command %s (try :help)\n", name)
+	}
+}
+
+// typeOf compiles expr against the session's definitions, without running
+// it or changing the session, and returns its static type.
+func (r *replSession) typeOf(expr string) (string, error) {
+	p := parser.New(lexer.New(expr))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return "", errors.New(p.Errors()[0])
+	}
+	if len(program.Statements) != 1 {
+		return "", errors.New("expected a single expression")
+	}
+	exprStmt, ok := program.Statements[0].(*ast.ExpressionStmt)
+	if !ok {
+		return "", errors.New("expected an expression")
+	}
+
+	globals := make(map[string]ast.NoxyType, len(r.globals))
+	for k, v := range r.globals {
+		globals[k] = v
+	}
+	structs := make(map[string]*ast.StructStatement, len(r.structs))
+	for k, v := range r.structs {
+		structs[k] = v
+	}
+	c := compiler.NewWithState(globals, structs, "REPL")
+	_, t, err := c.Compile(exprStmt.Expression)
+	if err != nil {
+		return "", fmt.Errorf("Compiler error: %s", err)
+	}
+	if t == nil {
+		return "any", nil
+	}
+	return t.String(), nil
+}
+
+// historyPath returns the path of ~/.noxy_history. History is best
+// effort: without a home directory the REPL simply does not keep one.
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".noxy_history")
+}
+
+// openHistory loads the history file at path, trimmed to historyLimit
+// lines, and opens it for appending.
+func (r *replSession) openHistory(path string) {
+	if path == "" {
+		return
+	}
+	if content, err := os.ReadFile(path); err == nil {
+		r.history = strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		if len(r.history) == 1 && r.history[0] == "" {
+			r.history = nil
+		}
+		if len(r.history) > historyLimit {
+			r.history = r.history[len(r.history)-historyLimit:]
+			os.WriteFile(path, []byte(strings.Join(r.history, "\n")+"\n"), 0600)
+		}
+	}
+	r.historyFile, _ = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
+// remember records a non-blank input line in the history.
+func (r *replSession) remember(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	r.history = append(r.history, line)
+	if r.historyFile != nil {
+		fmt.Fprintln(r.historyFile, line)
+	}
+}
+
+func (r *replSession) closeHistory() {
+	if r.historyFile != nil {
+		r.historyFile.Close()
 	}
 }
 
@@ -231,6 +723,11 @@ func verify() {
 }
 
 func runWithConfig(filename string, input string, rootPath string, showDisasm bool) {
+	runChunk(compileSource(filename, input), rootPath, showDisasm)
+}
+
+// compileSource parses and compiles a script, exiting on errors.
+func compileSource(filename string, input string) *chunk.Chunk {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
@@ -243,20 +740,31 @@ func runWithConfig(filename string, input string, rootPath string, showDisasm bo
 	}
 
 	c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), filename)
-	chunk, _, err := c.Compile(program)
+	c.Strict = strictTypes
+	compiled, _, err := c.Compile(program)
 	if err != nil {
 		fmt.Printf("Compiler error: %s\n", err)
 		os.Exit(1)
 	}
+	return compiled
+}
 
+// runChunk runs a compiled script, exiting with its exit code.
+func runChunk(compiled *chunk.Chunk, rootPath string, showDisasm bool) {
 	if showDisasm {
 		fmt.Printf("Disassembly:\n")
-		chunk.DisassembleAll("main")
+		compiled.DisassembleAll("main")
 		fmt.Printf("\nExecution:\n")
 	}
 
-	machine := vm.NewWithConfig(vm.VMConfig{RootPath: rootPath})
-	if err := machine.Interpret(chunk); err != nil {
+	machine := vm.NewWithConfig(vmConfig(rootPath))
+	err := machine.Interpret(compiled)
+	machine.Close()
+	var exit *vm.ExitError
+	if errors.As(err, &exit) {
+		os.Exit(exit.Code)
+	}
+	if err != nil {
 		fmt.Printf("Runtime error: %s\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"noxy-vm/internal/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// replOutput feeds input to a fresh REPL session rooted at dir and returns
+// everything it wrote.
+func replOutput(t *testing.T, dir, history, input string) string {
+	t.Helper()
+	var out bytes.Buffer
+	repl(strings.NewReader(input), &out, vm.VMConfig{RootPath: dir, WorkDir: dir}, history, false)
+	return out.String()
+}
+
+func TestREPLCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string // {dir} is replaced by a temporary directory
+		want    []string
+		notWant string
+	}{
+		{"expression", "1 + 2\n", []string{">>> 3\n"}, ""},
+		{"multiline", "func f() -> int\nreturn 5\nend\nf()\n", []string{"... ... >>> 5\n"}, ""},
+		{"help", ":help\n", []string{":load <file>", ":reset"}, ""},
+		{"unknown", ":nope\n", []string{"Unknown command :nope (try :help)"}, ""},
+		{"type", "let x: int = 1\n:type x + 1\n:type x * 1.5\n", []string{">>> int\n", ">>> float\n"}, ""},
+		{"type does not run", ":type print(\"ran\")\n", []string{">>> any\n"}, "ran"},
+		{"type usage", ":type\n", []string{"Usage: :type <expr>"}, ""},
+		{"dis undefined", ":dis nope\n", []string{"Undefined: nope"}, ""},
+		{"dis not a function", "let x: int = 1\n:dis x\n", []string{"x is not a function"}, ""},
+		{"load", ":load {dir}/lib.nx\ndouble(21)\n", []string{"loaded\n", "42\n"}, ""},
+		{"load missing", ":load {dir}/missing.nx\n", []string{"Error reading file:"}, ""},
+		{"load usage", ":load\n", []string{"Usage: :load <file>"}, ""},
+		{"save usage", ":save\n", []string{"Usage: :save <file>"}, ""},
+		{"reset", "let z: int = 1\n:reset\nlet z: string = \"again\"\nz\n", []string{"Session reset.", "again\n"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			lib := "func double(n: int) -> int\n    return n * 2\nend\nprint(\"loaded\")\n"
+			if err := os.WriteFile(filepath.Join(dir, "lib.nx"), []byte(lib), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got := replOutput(t, dir, "", strings.ReplaceAll(tt.input, "{dir}", dir))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("output %q does not contain %q", got, want)
+				}
+			}
+			if tt.notWant != "" && strings.Contains(got, tt.notWant) {
+				t.Errorf("output %q contains %q", got, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestREPLSave(t *testing.T) {
+	dir := t.TempDir()
+	saved := filepath.Join(dir, "session.nx")
+	// Inputs that fail are left out of the saved file
+	input := "let x: int = 4\nlet y: int = \"no\"\nfunc twice() -> int\nreturn x * 2\nend\n:save " + saved + "\n"
+	got := replOutput(t, dir, "", input)
+	if !strings.Contains(got, "Saved 2 inputs to "+saved) {
+		t.Errorf("output %q does not report the save", got)
+	}
+	content, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "let x: int = 4\nfunc twice() -> int\nreturn x * 2\nend\n"
+	if string(content) != want {
+		t.Errorf("saved %q, want %q", content, want)
+	}
+
+	// The saved file loads back into a new session
+	got = replOutput(t, dir, "", ":load "+saved+"\ntwice()\n")
+	if !strings.Contains(got, "8\n") {
+		t.Errorf("reloaded session printed %q, want 8", got)
+	}
+}
+
+func TestREPLHistory(t *testing.T) {
+	dir := t.TempDir()
+	history := filepath.Join(dir, "history")
+
+	replOutput(t, dir, history, "let a: int = 1\n\na + 1\nexit\n")
+	content, err := os.ReadFile(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Blank lines are not recorded
+	if want := "let a: int = 1\na + 1\nexit\n"; string(content) != want {
+		t.Errorf("history is %q, want %q", content, want)
+	}
+
+	// A later session sees the earlier lines, but not :history itself
+	got := replOutput(t, dir, history, ":history 2\n")
+	if !strings.Contains(got, ">>> a + 1\nexit\n>>> ") {
+		t.Errorf(":history 2 printed %q", got)
+	}
+	got = replOutput(t, dir, history, ":history x\n")
+	if !strings.Contains(got, "Usage: :history [n]") {
+		t.Errorf(":history x printed %q", got)
+	}
+
+	// The file is trimmed to the last historyLimit lines
+	var lines strings.Builder
+	for i := 0; i < historyLimit+10; i++ {
+		fmt.Fprintf(&lines, "line %d\n", i)
+	}
+	if err := os.WriteFile(history, []byte(lines.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	replOutput(t, dir, history, "exit\n")
+	content, err = os.ReadFile(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(kept) != historyLimit+1 || kept[0] != "line 10" || kept[len(kept)-1] != "exit" {
+		t.Errorf("history kept %d lines from %q to %q", len(kept), kept[0], kept[len(kept)-1])
+	}
+}
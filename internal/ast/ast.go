@@ -62,6 +62,9 @@ func (at *ArrayType) String() string {
 	if at.ElementType == nil {
 		return "any[]"
 	}
+	if _, ok := at.ElementType.(*FunctionType); ok {
+		return "(" + at.ElementType.String() + ")[]"
+	}
 	return at.ElementType.String() + "[]"
 }
 
@@ -183,6 +186,16 @@ func (rs *ReturnStmt) String() string {
 	return out
 }
 
+// ThrowStmt raises Value, an error or a string, as a runtime error.
+type ThrowStmt struct {
+	Token token.Token // The 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStmt) statementNode()       {}
+func (ts *ThrowStmt) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStmt) String() string       { return "throw " + ts.Value.String() }
+
 type BreakStmt struct {
 	Token token.Token
 }
@@ -197,11 +210,39 @@ type UseStmt struct {
 	Alias     string
 	Selectors []string
 	SelectAll bool
+	// Plugin is set for use plugin "name": Module is then the plugin's
+	// name, or the URL of a remote plugin
+	Plugin bool
 }
 
 func (us *UseStmt) statementNode()       {}
 func (us *UseStmt) TokenLiteral() string { return us.Token.Literal }
-func (us *UseStmt) String() string       { return "use " + us.Module }
+
+// BindName is the global a plain use binds: the alias, or the last part
+// of the module path.
+func (us *UseStmt) BindName() string {
+	if us.Alias != "" {
+		return us.Alias
+	}
+	return us.Module[strings.LastIndex(us.Module, ".")+1:]
+}
+
+func (us *UseStmt) String() string {
+	if us.Plugin {
+		return "use plugin \"" + us.Module + "\""
+	}
+	return "use " + us.Module
+}
+
+// EmbedStmt bundles files matching Pattern into the compiled chunk.
+type EmbedStmt struct {
+	Token   token.Token // 'embed'
+	Pattern string
+}
+
+func (es *EmbedStmt) statementNode()       {}
+func (es *EmbedStmt) TokenLiteral() string { return es.Token.Literal }
+func (es *EmbedStmt) String() string       { return "embed \"" + es.Pattern + "\"" }
 
 type ExpressionStmt struct {
 	Token      token.Token // The first token of the expression
@@ -317,6 +358,25 @@ func (ie *InfixExpression) String() string {
 	return "(" + ie.Left.String() + " " + ie.Operator + " " + ie.Right.String() + ")"
 }
 
+// ComparisonChain is a run of ordering comparisons such as 0 <= i < n,
+// which holds when each comparison holds, every operand being evaluated
+// once. Operators[i] compares Operands[i] with Operands[i+1].
+type ComparisonChain struct {
+	Token     token.Token // The first operator
+	Operands  []Expression
+	Operators []string
+}
+
+func (cc *ComparisonChain) expressionNode()      {}
+func (cc *ComparisonChain) TokenLiteral() string { return cc.Token.Literal }
+func (cc *ComparisonChain) String() string {
+	out := "(" + cc.Operands[0].String()
+	for i, op := range cc.Operators {
+		out += " " + op + " " + cc.Operands[i+1].String()
+	}
+	return out + ")"
+}
+
 type BlockStatement struct {
 	Token      token.Token // the { token or similar
 	Statements []Statement
@@ -361,6 +421,26 @@ func (ws *WhileStatement) String() string {
 	return "while " + ws.Condition.String() + " " + ws.Body.String()
 }
 
+// TryStatement runs Body; if it raises a runtime error, the rest of Body
+// is skipped and Handler runs with the error value bound to ErrorName
+// (empty when the catch names no variable).
+type TryStatement struct {
+	Token     token.Token // The 'try' token
+	Body      *BlockStatement
+	ErrorName string
+	Handler   *BlockStatement
+}
+
+func (ts *TryStatement) statementNode()       {}
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TryStatement) String() string {
+	out := "try " + ts.Body.String() + " catch "
+	if ts.ErrorName != "" {
+		out += ts.ErrorName + " "
+	}
+	return out + ts.Handler.String()
+}
+
 type FunctionStatement struct {
 	Token      token.Token // The 'func' token
 	Name       string
@@ -376,7 +456,11 @@ func (fs *FunctionStatement) String() string {
 	for _, p := range fs.Parameters {
 		params = append(params, p.String())
 	}
-	return "func " + fs.Name + "(" + strings.Join(params, ", ") + ") " + fs.Body.String()
+	sig := "func " + fs.Name + "(" + strings.Join(params, ", ") + ") "
+	if fs.ReturnType != nil {
+		sig += "-> " + fs.ReturnType.String() + " "
+	}
+	return sig + fs.Body.String()
 }
 
 type FunctionLiteral struct {
@@ -430,6 +514,20 @@ func (fs *ForStatement) String() string {
 	return "for " + fs.Identifier + " in " + fs.Collection.String() + " " + fs.Body.String()
 }
 
+// RangeExpression is start..end, the integers from start up to but not
+// including end. It is only parsed as the collection of a for loop.
+type RangeExpression struct {
+	Token token.Token // The '..' token
+	Start Expression
+	End   Expression
+}
+
+func (re *RangeExpression) expressionNode()      {}
+func (re *RangeExpression) TokenLiteral() string { return re.Token.Literal }
+func (re *RangeExpression) String() string {
+	return re.Start.String() + ".." + re.End.String()
+}
+
 type ArrayLiteral struct {
 	Token    token.Token // The '[' token
 	Elements []Expression
@@ -487,6 +585,7 @@ type StructStatement struct {
 	Name       string
 	Fields     map[string]NoxyType
 	FieldsList []*StructField
+	Methods    []*FunctionStatement // Declared in the struct body
 }
 
 type StructField struct {
@@ -504,6 +603,9 @@ func (ss *StructStatement) String() string {
 			s += ", "
 		}
 	}
+	for _, m := range ss.Methods {
+		s += " " + m.String()
+	}
 	s += " end"
 	return s
 }
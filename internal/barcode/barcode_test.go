@@ -0,0 +1,292 @@
+package barcode
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQRTables(t *testing.T) {
+	// Known capacities, in codewords
+	for _, tt := range []struct {
+		version int
+		level   Level
+		data    int
+	}{
+		{1, LevelL, 19}, {1, LevelH, 9}, {5, LevelQ, 62}, {10, LevelM, 216},
+		{40, LevelL, 2956}, {40, LevelH, 1276},
+	} {
+		if got := dataCodewords(tt.version, tt.level); got != tt.data {
+			t.Errorf("dataCodewords(%d, %d) = %d, want %d", tt.version, tt.level, got, tt.data)
+		}
+	}
+	for version, want := range map[int][]int{
+		1: nil, 2: {6, 18}, 7: {6, 22, 38}, 32: {6, 34, 60, 86, 112, 138}, 40: {6, 30, 58, 86, 114, 142, 170},
+	} {
+		if got := alignmentPositions(version); !reflect.DeepEqual(got, want) {
+			t.Errorf("alignmentPositions(%d) = %v, want %v", version, got, want)
+		}
+	}
+	for _, tt := range []struct {
+		level Level
+		mask  int
+		want  string
+	}{
+		{LevelL, 0, "111011111000100"}, {LevelM, 0, "101010000010010"},
+		{LevelQ, 0, "011010101011111"}, {LevelH, 0, "001011010001001"},
+		{LevelL, 4, "110011000101111"}, {LevelH, 7, "000100000111011"},
+	} {
+		if got := fmt.Sprintf("%015b", formatInfo(tt.level, tt.mask)); got != tt.want {
+			t.Errorf("formatInfo(%d, %d) = %s, want %s", tt.level, tt.mask, got, tt.want)
+		}
+	}
+	if got := fmt.Sprintf("%018b", versionInfo(7)); got != "000111110010010100" {
+		t.Errorf("versionInfo(7) = %s", got)
+	}
+}
+
+func TestQRCodewords(t *testing.T) {
+	// HELLO WORLD at 1-M, as worked through in the common tutorials
+	var bits bitBuffer
+	newSegment("HELLO WORLD").write(&bits, 1)
+	bits.append(0, 4)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < 16*8; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	data := bits.bytes()
+	want := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("data codewords = %v, want %v", data, want)
+	}
+	ecc := addECC(data, 1, LevelM)[16:]
+	if wantECC := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}; !bytes.Equal(ecc, wantECC) {
+		t.Errorf("ECC = %v, want %v", ecc, wantECC)
+	}
+}
+
+func TestQRRoundTrip(t *testing.T) {
+	tests := []struct {
+		text    string
+		level   Level
+		version int
+	}{
+		{"HELLO WORLD", LevelQ, 1},
+		{"01234567", LevelM, 1},
+		{"https://example.com/tickets/8f14e45f", LevelM, 3},
+		{"ação ✓", LevelH, 2},
+		{strings.Repeat("noxy ", 60), LevelL, 11},
+		{strings.Repeat("Z", 1800), LevelH, 40},
+		{strings.Repeat("a", 2953), LevelL, 40},
+	}
+	for _, tt := range tests {
+		q, err := EncodeQR(tt.text, tt.level)
+		if err != nil {
+			t.Errorf("EncodeQR(%.20q): %v", tt.text, err)
+			continue
+		}
+		if q.Version != tt.version || q.Size() != tt.version*4+17 {
+			t.Errorf("EncodeQR(%.20q) has version %d and size %d, want version %d", tt.text, q.Version, q.Size(), tt.version)
+		}
+		if got, err := readQR(q); err != nil || got != tt.text {
+			t.Errorf("reading EncodeQR(%.20q) back gives %.20q, %v", tt.text, got, err)
+		}
+	}
+
+	if _, err := EncodeQR(strings.Repeat("a", 2954), LevelL); err == nil || err.Error() != "text of 2954 bytes is too long for a QR code at level L" {
+		t.Errorf("got %v for too long a text", err)
+	}
+}
+
+// readQR decodes q the way a reader would once it has found the modules:
+// format information, unmasking, de-interleaving, error correction check
+// and the data segment.
+func readQR(q *QR) (string, error) {
+	size := q.Size()
+	bits := 0
+	for i := 0; i <= 5; i++ {
+		bits |= b2i(q.Modules[i][8]) << i
+	}
+	bits |= b2i(q.Modules[7][8])<<6 | b2i(q.Modules[8][8])<<7 | b2i(q.Modules[8][7])<<8
+	for i := 9; i < 15; i++ {
+		bits |= b2i(q.Modules[8][14-i]) << i
+	}
+	level, mask := Level(-1), -1
+	for l := LevelL; l <= LevelH; l++ {
+		for m := 0; m < 8; m++ {
+			if formatInfo(l, m) == bits {
+				level, mask = l, m
+			}
+		}
+	}
+	if mask < 0 || level != q.Level || mask != q.Mask {
+		return "", fmt.Errorf("format information %015b does not match level %d and mask %d", bits, q.Level, q.Mask)
+	}
+
+	// The function patterns of the version mark what is not data
+	blank := &QR{Version: q.Version, Level: level, Modules: make([][]bool, size)}
+	function := make([][]bool, size)
+	for i := range function {
+		blank.Modules[i] = make([]bool, size)
+		function[i] = make([]bool, size)
+	}
+	blank.drawFunctionPatterns(function)
+	modules := &QR{Modules: make([][]bool, size)}
+	for i := range modules.Modules {
+		modules.Modules[i] = append([]bool{}, q.Modules[i]...)
+	}
+	modules.applyMask(mask, function)
+
+	var raw []byte
+	var cur byte
+	n := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				if x := right - j; !function[y][x] {
+					cur = cur<<1 | byte(b2i(modules.Modules[y][x]))
+					if n++; n%8 == 0 {
+						raw = append(raw, cur)
+					}
+				}
+			}
+		}
+	}
+
+	// De-interleave, checking each block's error correction
+	numBlocks := eccBlocks[level][q.Version]
+	eccLen := eccPerBlock[level][q.Version]
+	total := rawDataModules(q.Version) / 8
+	numShort := numBlocks - total%numBlocks
+	shortLen := total / numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < shortLen+1; i++ {
+		for j := range blocks {
+			if i == shortLen-eccLen && j < numShort {
+				continue
+			}
+			blocks[j] = append(blocks[j], raw[k])
+			k++
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		dat, ecc := block[:len(block)-eccLen], block[len(block)-eccLen:]
+		if !bytes.Equal(rsRemainder(dat, rsDivisor(eccLen)), ecc) {
+			return "", fmt.Errorf("error correction mismatch")
+		}
+		data = append(data, dat...)
+	}
+
+	var stream bitBuffer
+	for _, b := range data {
+		stream.append(int(b), 8)
+	}
+	pos := 0
+	read := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(stream[pos])
+			pos++
+		}
+		return v
+	}
+	seg := segment{mode: read(4)}
+	count := read(seg.countBits(q.Version))
+	var out strings.Builder
+	switch seg.mode {
+	case modeNumeric:
+		for ; count >= 3; count -= 3 {
+			fmt.Fprintf(&out, "%03d", read(10))
+		}
+		if count == 2 {
+			fmt.Fprintf(&out, "%02d", read(7))
+		} else if count == 1 {
+			fmt.Fprintf(&out, "%d", read(4))
+		}
+	case modeAlphanumeric:
+		for ; count >= 2; count -= 2 {
+			v := read(11)
+			out.WriteByte(alphanumericChars[v/45])
+			out.WriteByte(alphanumericChars[v%45])
+		}
+		if count == 1 {
+			out.WriteByte(alphanumericChars[read(6)])
+		}
+	case modeByte:
+		for ; count > 0; count-- {
+			out.WriteByte(byte(read(8)))
+		}
+	default:
+		return "", fmt.Errorf("unknown mode %d", seg.mode)
+	}
+	return out.String(), nil
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestCode128(t *testing.T) {
+	seen := make(map[string]bool)
+	for i, p := range code128Patterns {
+		sum := 0
+		for _, w := range p {
+			sum += int(w - '0')
+		}
+		if want := map[bool]int{true: 13, false: 11}[i == code128Stop]; sum != want || seen[p] {
+			t.Errorf("pattern %d %s is invalid", i, p)
+		}
+		seen[p] = true
+	}
+
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"PJJ123C", []int{104, 48, 42, 42, 17, 18, 19, 35, 55}},
+		{"1234", []int{105, 12, 34, 82}},
+		{"12345", []int{104, 17, 99, 23, 45, 0}},
+		{"AB123456", []int{104, 33, 34, 99, 12, 34, 56, 0}},
+		{"A1234567B", []int{104, 33, 17, 99, 23, 45, 67, 100, 34, 0}},
+		{"A12345B", []int{104, 33, 17, 18, 19, 20, 21, 34, 0}},
+	}
+	for _, tt := range tests {
+		got, err := Code128(tt.text)
+		if err != nil {
+			t.Errorf("Code128(%q): %v", tt.text, err)
+			continue
+		}
+		sum := tt.want[0]
+		for i, v := range tt.want[1 : len(tt.want)-1] {
+			sum += (i + 1) * v
+		}
+		tt.want[len(tt.want)-1] = sum % 103
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Code128(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	modules, _ := Code128Modules("1234")
+	if len(modules) != 4*11+13 || !modules[0] || modules[len(modules)-3] || !modules[len(modules)-1] {
+		t.Errorf("Code128Modules(1234) has %d modules", len(modules))
+	}
+	for _, bad := range []string{"", "tab\there", "ç"} {
+		if _, err := Code128(bad); err == nil {
+			t.Errorf("Code128(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package barcode
+
+import "fmt"
+
+// code128Patterns are the bar and space widths of each Code 128 symbol,
+// starting with a bar. 103-105 are the start codes A, B and C and 106 is
+// the stop code.
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128CodeB  = 100
+	code128CodeC  = 99
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// Code128 encodes text, printable ASCII, as Code 128 symbol values: the
+// start code, the data, and the checksum, without the stop code. Text is
+// in code set B, switching to code set C, two digits per symbol, for runs
+// of digits long enough to make the result shorter.
+func Code128(text string) ([]int, error) {
+	for i := 0; i < len(text); i++ {
+		if text[i] < 32 || text[i] > 127 {
+			return nil, fmt.Errorf("character %q cannot be encoded in Code 128", text[i])
+		}
+	}
+	if text == "" {
+		return nil, fmt.Errorf("nothing to encode")
+	}
+
+	var values []int
+	inC := false
+	for i := 0; i < len(text); {
+		run := 0
+		for i+run < len(text) && text[i+run] >= '0' && text[i+run] <= '9' {
+			run++
+		}
+		// Code C pays off for four digits at either end, or six in the
+		// middle, where switching there and back costs two symbols
+		if !inC && (run >= 4 && (i == 0 || i+run == len(text)) || run >= 6) {
+			if run%2 == 1 {
+				if values == nil {
+					values = append(values, code128StartB)
+				}
+				values = append(values, int(text[i])-32)
+				i++
+				run--
+			}
+			if values == nil {
+				values = append(values, code128StartC)
+			} else {
+				values = append(values, code128CodeC)
+			}
+			inC = true
+		}
+		if inC {
+			if run >= 2 {
+				values = append(values, int(text[i]-'0')*10+int(text[i+1]-'0'))
+				i += 2
+				continue
+			}
+			values = append(values, code128CodeB)
+			inC = false
+		}
+		if values == nil {
+			values = append(values, code128StartB)
+		}
+		values = append(values, int(text[i])-32)
+		i++
+	}
+
+	sum := values[0]
+	for i, v := range values[1:] {
+		sum += (i + 1) * v
+	}
+	return append(values, sum%103), nil
+}
+
+// Code128Modules returns the modules of text's Code 128 bar code, true
+// for a bar, including the stop code but not the quiet zones.
+func Code128Modules(text string) ([]bool, error) {
+	values, err := Code128(text)
+	if err != nil {
+		return nil, err
+	}
+	var modules []bool
+	for _, v := range append(values, code128Stop) {
+		for i, w := range code128Patterns[v] {
+			for n := 0; n < int(w-'0'); n++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	return modules, nil
+}
@@ -0,0 +1,583 @@
+// Package barcode encodes QR codes (ISO/IEC 18004) and Code 128 bar
+// codes into modules, the dark and light squares or bars that a renderer
+// draws.
+package barcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is a QR error correction level: how much of the code can be
+// damaged and still read.
+type Level int
+
+const (
+	LevelL Level = iota // about 7%
+	LevelM              // about 15%
+	LevelQ              // about 25%
+	LevelH              // about 30%
+)
+
+// ParseLevel reads "L", "M", "Q" or "H".
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return LevelL, nil
+	case "M":
+		return LevelM, nil
+	case "Q":
+		return LevelQ, nil
+	case "H":
+		return LevelH, nil
+	}
+	return 0, fmt.Errorf("invalid error correction level %q (want L, M, Q or H)", s)
+}
+
+// formatBits is the level's code in the format information.
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// eccPerBlock and eccBlocks are, per level and version, the error
+// correction codewords in each block and the number of blocks.
+var eccPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var eccBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// QR is an encoded QR code. Modules[y][x] is true for a dark module; the
+// quiet zone around the code is not included.
+type QR struct {
+	Version int
+	Level   Level
+	Mask    int
+	Modules [][]bool
+}
+
+// Size is the number of modules on each side.
+func (q *QR) Size() int {
+	return len(q.Modules)
+}
+
+// EncodeQR encodes text in the smallest QR code that holds it at the
+// given level. Text made only of digits, or only of the characters of
+// the alphanumeric mode (0-9, A-Z, space and $%*+-./:), is packed more
+// densely than other text, which is stored as UTF-8 bytes.
+func EncodeQR(text string, level Level) (*QR, error) {
+	seg := newSegment(text)
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if seg.bitLen(v) <= dataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("text of %d bytes is too long for a QR code at level %s", len(text), "LMQH"[level:level+1])
+	}
+
+	// Segment, terminator, padding to a byte, then alternating pad bytes
+	capacity := dataCodewords(version, level) * 8
+	var bits bitBuffer
+	seg.write(&bits, version)
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	data := bits.bytes()
+
+	q := &QR{Version: version, Level: level}
+	size := version*4 + 17
+	q.Modules = make([][]bool, size)
+	function := make([][]bool, size)
+	for i := range q.Modules {
+		q.Modules[i] = make([]bool, size)
+		function[i] = make([]bool, size)
+	}
+	q.drawFunctionPatterns(function)
+	q.drawCodewords(addECC(data, version, level), function)
+
+	// Keep the mask whose result is least likely to confuse a reader
+	best := -1
+	bestPenalty := 0
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask, function)
+		q.drawFormatBits(mask, function)
+		if p := q.penalty(); best < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask, function) // undo
+	}
+	q.Mask = best
+	q.applyMask(best, function)
+	q.drawFormatBits(best, function)
+	return q, nil
+}
+
+// segment is text in a single encoding mode.
+type segment struct {
+	mode  int // the 4-bit mode indicator
+	text  string
+	count int // characters (digits, alphanumerics or bytes)
+}
+
+const (
+	modeNumeric      = 0x1
+	modeAlphanumeric = 0x2
+	modeByte         = 0x4
+)
+
+const alphanumericChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+func newSegment(text string) segment {
+	numeric, alnum := true, true
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c < '0' || c > '9' {
+			numeric = false
+		}
+		if !strings.ContainsRune(alphanumericChars, rune(c)) {
+			alnum = false
+		}
+	}
+	switch {
+	case numeric && text != "":
+		return segment{mode: modeNumeric, text: text, count: len(text)}
+	case alnum && text != "":
+		return segment{mode: modeAlphanumeric, text: text, count: len(text)}
+	}
+	return segment{mode: modeByte, text: text, count: len(text)}
+}
+
+// countBits is the width of the character count for the mode and version.
+func (s segment) countBits(version int) int {
+	i := 0
+	if version >= 27 {
+		i = 2
+	} else if version >= 10 {
+		i = 1
+	}
+	switch s.mode {
+	case modeNumeric:
+		return [...]int{10, 12, 14}[i]
+	case modeAlphanumeric:
+		return [...]int{9, 11, 13}[i]
+	}
+	return [...]int{8, 16, 16}[i]
+}
+
+func (s segment) bitLen(version int) int {
+	n := 4 + s.countBits(version)
+	if s.count >= 1<<s.countBits(version) {
+		return 1 << 30 // the count does not fit
+	}
+	switch s.mode {
+	case modeNumeric:
+		return n + s.count/3*10 + [...]int{0, 4, 7}[s.count%3]
+	case modeAlphanumeric:
+		return n + s.count/2*11 + s.count%2*6
+	}
+	return n + s.count*8
+}
+
+func (s segment) write(b *bitBuffer, version int) {
+	b.append(s.mode, 4)
+	b.append(s.count, s.countBits(version))
+	switch s.mode {
+	case modeNumeric:
+		for i := 0; i < len(s.text); i += 3 {
+			chunk := s.text[i:min(i+3, len(s.text))]
+			n := 0
+			for _, c := range chunk {
+				n = n*10 + int(c-'0')
+			}
+			b.append(n, len(chunk)*3+1)
+		}
+	case modeAlphanumeric:
+		for i := 0; i < len(s.text); i += 2 {
+			n := strings.IndexByte(alphanumericChars, s.text[i])
+			if i+1 < len(s.text) {
+				b.append(n*45+strings.IndexByte(alphanumericChars, s.text[i+1]), 11)
+			} else {
+				b.append(n, 6)
+			}
+		}
+	default:
+		for i := 0; i < len(s.text); i++ {
+			b.append(int(s.text[i]), 8)
+		}
+	}
+}
+
+// bitBuffer is a sequence of bits, one per element.
+type bitBuffer []byte
+
+func (b *bitBuffer) append(val, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, byte(val>>i&1))
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		out[i/8] |= bit << (7 - i%8)
+	}
+	return out
+}
+
+// rawDataModules is the number of modules of a version left for data and
+// error correction once the function patterns are placed.
+func rawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func dataCodewords(version int, level Level) int {
+	return rawDataModules(version)/8 - eccPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// alignmentPositions are the centre coordinates of the alignment
+// patterns, used as both rows and columns.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, version*4+10; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// addECC splits data into blocks, appends each block's Reed-Solomon
+// codewords and interleaves the result.
+func addECC(data []byte, version int, level Level) []byte {
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	raw := rawDataModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		dat := data[k : k+n]
+		k += n
+		block := append([]byte{}, dat...)
+		if i < numShort {
+			block = append(block, 0) // placeholder, skipped below
+		}
+		blocks[i] = append(block, rsRemainder(dat, divisor)...)
+	}
+
+	out := make([]byte, 0, raw)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				out = append(out, block[i])
+			}
+		}
+	}
+	return out
+}
+
+// rsDivisor is the Reed-Solomon generator polynomial of the given degree,
+// highest coefficient first without the leading 1.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func (q *QR) set(x, y int, dark bool, function [][]bool) {
+	q.Modules[y][x] = dark
+	function[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns
+// and the version information, and reserves the format information.
+func (q *QR) drawFunctionPatterns(function [][]bool) {
+	size := q.Size()
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0, function)
+		q.set(i, 6, i%2 == 0, function)
+	}
+
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					d := max(abs(dx), abs(dy))
+					q.set(x, y, d != 2 && d != 4, function)
+				}
+			}
+		}
+	}
+
+	pos := alignmentPositions(q.Version)
+	last := len(pos) - 1
+	for i, px := range pos {
+		for j, py := range pos {
+			// Skip the three that would overlap the finder patterns
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(px+dx, py+dy, max(abs(dx), abs(dy)) != 1, function)
+				}
+			}
+		}
+	}
+
+	q.drawFormatBits(0, function)
+
+	if q.Version >= 7 {
+		bits := versionInfo(q.Version)
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 != 0
+			a, b := size-11+i%3, i/3
+			q.set(a, b, dark, function)
+			q.set(b, a, dark, function)
+		}
+	}
+}
+
+// versionInfo is the 18 bits of version information: the version and
+// its BCH error correction.
+func versionInfo(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	return version<<12 | rem
+}
+
+// formatInfo is the 15 bits of format information: level, mask, BCH
+// error correction, and the fixed mask that keeps them from being all
+// light.
+func formatInfo(level Level, mask int) int {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormatBits draws both copies of the format information.
+func (q *QR) drawFormatBits(mask int, function [][]bool) {
+	bits := formatInfo(q.Level, mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	size := q.Size()
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i), function)
+	}
+	q.set(8, 7, bit(6), function)
+	q.set(8, 8, bit(7), function)
+	q.set(7, 8, bit(8), function)
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i), function)
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(size-1-i, 8, bit(i), function)
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, size-15+i, bit(i), function)
+	}
+	q.set(8, size-8, true, function) // always dark
+}
+
+// drawCodewords places the bits of data in the zigzag order of the
+// standard, two columns at a time from the bottom right.
+func (q *QR) drawCodewords(data []byte, function [][]bool) {
+	size := q.Size()
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < size; vert++ {
+			y := vert
+			if upward {
+				y = size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !function[y][x] && i < len(data)*8 {
+					q.Modules[y][x] = data[i>>3]>>(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by mask; applying it twice
+// undoes it.
+func (q *QR) applyMask(mask int, function [][]bool) {
+	for y, row := range q.Modules {
+		for x := range row {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !function[y][x] {
+				row[x] = !row[x]
+			}
+		}
+	}
+}
+
+// penalty scores the code by the four rules of the standard: long runs of
+// one color, 2x2 blocks, patterns that look like a finder, and an
+// unbalanced share of dark modules.
+func (q *QR) penalty() int {
+	size := q.Size()
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.Modules[x][y]
+		}
+		return q.Modules[y][x]
+	}
+
+	p := 0
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Runs of five or more
+			run := 1
+			for x := 1; x <= size; x++ {
+				if x < size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+				run = 1
+			}
+			// 1:1:3:1:1 with four light modules on one side; modules
+			// outside the code count as light
+			for x := -4; x < size; x++ {
+				dark := func(i int) bool {
+					return x+i >= 0 && x+i < size && at(x+i, y, transpose)
+				}
+				match := func(pattern string) bool {
+					for i := range pattern {
+						if dark(i) != (pattern[i] == '1') {
+							return false
+						}
+					}
+					return true
+				}
+				if match("10111010000") || match("00001011101") {
+					p += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if q.Modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				c := q.Modules[y][x]
+				if c == q.Modules[y][x+1] && c == q.Modules[y+1][x] && c == q.Modules[y+1][x+1] {
+					p += 3
+				}
+			}
+		}
+	}
+	total := size * size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return p + k*10
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
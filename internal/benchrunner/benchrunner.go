@@ -0,0 +1,288 @@
+// Package benchrunner implements `noxy bench-vm`: it runs every .nx
+// program under the benchmark directories several times, each run in a
+// fresh VM, and reports how long the runs took and how much they
+// allocated.
+//
+// Reports are saved as JSON so that a later run, typically after a change
+// to the VM, can be compared with them; a program whose median time grew
+// by more than the threshold counts as a regression.
+package benchrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"noxy-vm/internal/ast"
+	"noxy-vm/internal/chunk"
+	"noxy-vm/internal/compiler"
+	"noxy-vm/internal/lexer"
+	"noxy-vm/internal/parser"
+	"noxy-vm/internal/version"
+	"noxy-vm/internal/vm"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options configures Run.
+type Options struct {
+	// Runs is how many timed runs each program gets (5 when zero). One
+	// untimed warm-up run comes first.
+	Runs int
+	// Config returns the VM configuration for a program in the given
+	// directory. Stdout is discarded, and WorkDir defaults to the
+	// directory.
+	Config func(root string) vm.VMConfig
+	// Out receives the report (os.Stdout when nil).
+	Out io.Writer
+	// Baseline, when set, is an earlier report to compare against.
+	Baseline *Report
+	// Threshold is how many percent slower the median may get before a
+	// program counts as a regression (10 when zero).
+	Threshold float64
+}
+
+// Result is the measurements of one program.
+type Result struct {
+	Name     string `json:"name"`
+	Runs     int    `json:"runs"`
+	MinNs    int64  `json:"min_ns"`
+	MedianNs int64  `json:"median_ns"`
+	MeanNs   int64  `json:"mean_ns"`
+	// Allocs and Bytes are per run, averaged over the timed runs.
+	Allocs uint64 `json:"allocs"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// Report is the outcome of Run, and the format of the JSON files.
+type Report struct {
+	Noxy      string    `json:"noxy"`
+	GoVersion string    `json:"go_version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	Date      time.Time `json:"date"`
+	Results   []Result  `json:"results"`
+	// Regressions names the programs that got slower than the baseline
+	// allowed; it is not saved.
+	Regressions []string `json:"-"`
+}
+
+// Run benchmarks the programs in paths, which may be .nx files or
+// directories to search recursively; no paths means "benchmarks".
+func Run(paths []string, opts Options) (*Report, error) {
+	if opts.Runs <= 0 {
+		opts.Runs = 5
+	}
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	if opts.Config == nil {
+		opts.Config = func(root string) vm.VMConfig { return vm.VMConfig{RootPath: root} }
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 10
+	}
+	files, err := findPrograms(paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no benchmark programs found")
+	}
+
+	report := &Report{
+		Noxy:      version.Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Date:      time.Now().UTC().Truncate(time.Second),
+	}
+	var base map[string]Result
+	if opts.Baseline != nil {
+		base = make(map[string]Result)
+		for _, r := range opts.Baseline.Results {
+			base[r.Name] = r
+		}
+	}
+	for _, f := range files {
+		res, err := runProgram(f.path, f.name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.path, err)
+		}
+		report.Results = append(report.Results, res)
+
+		line := fmt.Sprintf("%-20s %10s median %10s min %10d allocs %10s", res.Name,
+			formatNs(res.MedianNs), formatNs(res.MinNs), res.Allocs, formatBytes(res.Bytes))
+		if old, ok := base[res.Name]; ok && old.MedianNs > 0 {
+			change := Change(old.MedianNs, res.MedianNs)
+			line += fmt.Sprintf("  %+6.1f%%", change)
+			if change > opts.Threshold {
+				line += "  REGRESSION"
+				report.Regressions = append(report.Regressions, res.Name)
+			}
+		}
+		fmt.Fprintln(opts.Out, line)
+	}
+	return report, nil
+}
+
+// Change is how many percent new is above old; negative when it is below.
+func Change(old, new int64) float64 {
+	return float64(new-old) / float64(old) * 100
+}
+
+type program struct {
+	path string
+	// name identifies the program in reports: its path below the
+	// directory it was found in, without .nx.
+	name string
+}
+
+// findPrograms expands paths into the .nx programs to run, sorted by name,
+// skipping hidden directories, noxy_libs and testdata.
+func findPrograms(paths []string) ([]program, error) {
+	if len(paths) == 0 {
+		paths = []string{"benchmarks"}
+	}
+	var files []program
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, program{path, strings.TrimSuffix(filepath.Base(path), ".nx")})
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if p != path && (strings.HasPrefix(name, ".") || name == "noxy_libs" || name == "testdata") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(p, ".nx") && !strings.HasSuffix(p, "_test.nx") {
+				rel, _ := filepath.Rel(path, p)
+				files = append(files, program{p, strings.TrimSuffix(filepath.ToSlash(rel), ".nx")})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+// runProgram compiles file once and runs it opts.Runs times after a
+// warm-up run, each time in a new VM.
+func runProgram(file, name string, opts Options) (Result, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return Result{}, err
+	}
+	p := parser.New(lexer.New(string(content)))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return Result{}, fmt.Errorf("%s", strings.Join(p.Errors(), "\n\t"))
+	}
+	c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), file)
+	mainChunk, _, err := c.Compile(program)
+	if err != nil {
+		return Result{}, err
+	}
+
+	dir := filepath.Dir(file)
+	cfg := opts.Config(dir)
+	cfg.Stdout = io.Discard
+	if cfg.WorkDir == "" {
+		cfg.WorkDir = dir
+	}
+	if err := runOnce(mainChunk, cfg); err != nil {
+		return Result{}, err
+	}
+
+	times := make([]int64, opts.Runs)
+	var total int64
+	var before, after runtime.MemStats
+	var allocs, bytes uint64
+	for i := range times {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		err := runOnce(mainChunk, cfg)
+		times[i] = time.Since(start).Nanoseconds()
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			return Result{}, err
+		}
+		total += times[i]
+		allocs += after.Mallocs - before.Mallocs
+		bytes += after.TotalAlloc - before.TotalAlloc
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	n := len(times)
+	median := times[n/2]
+	if n%2 == 0 {
+		median = (times[n/2-1] + times[n/2]) / 2
+	}
+	return Result{
+		Name:     name,
+		Runs:     n,
+		MinNs:    times[0],
+		MedianNs: median,
+		MeanNs:   total / int64(n),
+		Allocs:   allocs / uint64(n),
+		Bytes:    bytes / uint64(n),
+	}, nil
+}
+
+func runOnce(c *chunk.Chunk, cfg vm.VMConfig) error {
+	machine := vm.NewWithConfig(cfg)
+	defer machine.Close()
+	return machine.Interpret(c)
+}
+
+// ReadReport loads a report written by WriteFile.
+func ReadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &r, nil
+}
+
+// WriteFile saves r as indented JSON.
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func formatNs(ns int64) string {
+	return time.Duration(ns).Round(10 * time.Microsecond).String()
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,78 @@
+package benchrunner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dir, "testdata"), 0755)
+	os.WriteFile(filepath.Join(dir, "loop.nx"), []byte("let s: int = 0\nfor i in 0..1000 do\n    s = s + i\nend\nprint(s)\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "concat.nx"), []byte("let s: string = \"\"\nfor i in 0..100 do\n    s = s + \"x\"\nend\n"), 0644)
+	// Neither test files nor testdata are benchmarks
+	os.WriteFile(filepath.Join(dir, "loop_test.nx"), []byte("syntax error here\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "testdata", "skip.nx"), []byte("syntax error here\n"), 0644)
+
+	var out bytes.Buffer
+	report, err := Run([]string{dir}, Options{Runs: 3, Out: &out})
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if len(report.Results) != 2 || report.Results[0].Name != "loop" || report.Results[1].Name != "sub/concat" {
+		t.Fatalf("unexpected results %+v", report.Results)
+	}
+	for _, r := range report.Results {
+		if r.Runs != 3 || r.MinNs <= 0 || r.MinNs > r.MedianNs || r.Allocs == 0 {
+			t.Errorf("implausible result %+v", r)
+		}
+	}
+	// Program output is discarded
+	if strings.Contains(out.String(), "499500") {
+		t.Errorf("program output leaked into the report:\n%s", out.String())
+	}
+
+	// Round trip through JSON, then compare against a baseline that was
+	// much faster for loop and much slower for concat
+	file := filepath.Join(dir, "results.json")
+	if err := report.WriteFile(file); err != nil {
+		t.Fatal(err)
+	}
+	base, err := ReadReport(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(base.Results) != 2 || base.Results[0] != report.Results[0] || base.GoVersion == "" {
+		t.Fatalf("report changed in the round trip: %+v", base)
+	}
+	base.Results[0].MedianNs = 1
+	base.Results[1].MedianNs *= 1000
+	out.Reset()
+	report, err = Run([]string{dir}, Options{Runs: 1, Out: &out, Baseline: base})
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if len(report.Regressions) != 1 || report.Regressions[0] != "loop" {
+		t.Errorf("expected loop to regress, got %v\n%s", report.Regressions, out.String())
+	}
+	if !strings.Contains(out.String(), "REGRESSION") {
+		t.Errorf("report does not flag the regression:\n%s", out.String())
+	}
+
+	if _, err := Run([]string{filepath.Join(dir, "testdata")}, Options{Out: &out}); err == nil {
+		t.Error("expected an error for a directory without programs")
+	}
+}
+
+func TestChange(t *testing.T) {
+	if c := Change(100, 110); c < 9.99 || c > 10.01 {
+		t.Errorf("Change(100, 110) = %v", c)
+	}
+	if c := Change(200, 100); c != -50 {
+		t.Errorf("Change(200, 100) = %v", c)
+	}
+}
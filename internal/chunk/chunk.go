@@ -3,6 +3,7 @@ package chunk
 import (
 	"fmt"
 	"noxy-vm/internal/value"
+	"sync/atomic"
 )
 
 type OpCode byte
@@ -24,8 +25,8 @@ const (
 	OP_SET_LOCAL
 	OP_GET_UPVALUE
 	OP_SET_UPVALUE
-	OP_GET_PROPERTY
-	OP_SET_PROPERTY
+	OP_GET_PROPERTY // [name_const] [cache_hi] [cache_lo]
+	OP_SET_PROPERTY // [name_const] [cache_hi] [cache_lo]
 	OP_GET_INDEX
 	OP_SET_INDEX
 	OP_ADD
@@ -47,9 +48,8 @@ const (
 	OP_SHIFT_LEFT
 	OP_SHIFT_RIGHT
 	OP_CALL
-	OP_INVOKE
+	OP_INVOKE // [name_const] [arg_count]: call a method or callable member of the receiver below the args
 	OP_RETURN
-	OP_PRINT
 	OP_IMPORT
 	OP_IMPORT_FROM_ALL
 	OP_DUP
@@ -79,6 +79,24 @@ const (
 	OP_SWAP
 	OP_COPY
 	OP_ADDR
+	OP_CHECK_TYPE
+	OP_METHOD // [name_const]: pops a closure and adds it to the struct below as a method
+	OP_ADD_FLOAT
+	OP_SUB_FLOAT
+	OP_MUL_FLOAT
+	OP_DIV_FLOAT
+	OP_GET_INDEX_ARRAY // arr[i] where arr is an int[] or float[]
+	OP_SET_INDEX_ARRAY
+	OP_SPECIALIZE // [kind]: stores the array on top of the stack unboxed (a value.ElemKind) if its elements fit
+	OP_GREATER_EQUAL
+	OP_LESS_EQUAL
+	OP_IMPORT_LAZY      // [name_const]: pushes the module, or a stand-in that loads it when first used
+	OP_IMPORT_PLUGIN    // [plugin_const][bind_const]: starts the plugin and pushes a value whose members call its methods
+	OP_TRY              // [offset]: runtime errors until the matching OP_END_TRY jump forward by offset, with the error pushed
+	OP_END_TRY          // leaves the try block of the last OP_TRY
+	OP_THROW            // pops an error or a string and raises it as a runtime error
+	OP_JUMP_IF_NOT_NULL // [offset]: jumps forward by offset, keeping the value on top of the stack, unless it is null
+	OP_OVER             // pushes a copy of the value below the top: [a, b] -> [a, b, a]
 )
 
 func (op OpCode) String() string {
@@ -165,8 +183,6 @@ func (op OpCode) String() string {
 		return "OP_INVOKE"
 	case OP_RETURN:
 		return "OP_RETURN"
-	case OP_PRINT:
-		return "OP_PRINT"
 	case OP_IMPORT:
 		return "OP_IMPORT"
 	case OP_IMPORT_FROM_ALL:
@@ -215,6 +231,42 @@ func (op OpCode) String() string {
 		return "OP_LEN"
 	case OP_SELECT:
 		return "OP_SELECT"
+	case OP_CHECK_TYPE:
+		return "OP_CHECK_TYPE"
+	case OP_METHOD:
+		return "OP_METHOD"
+	case OP_ADD_FLOAT:
+		return "OP_ADD_FLOAT"
+	case OP_SUB_FLOAT:
+		return "OP_SUB_FLOAT"
+	case OP_MUL_FLOAT:
+		return "OP_MUL_FLOAT"
+	case OP_DIV_FLOAT:
+		return "OP_DIV_FLOAT"
+	case OP_GET_INDEX_ARRAY:
+		return "OP_GET_INDEX_ARRAY"
+	case OP_SET_INDEX_ARRAY:
+		return "OP_SET_INDEX_ARRAY"
+	case OP_SPECIALIZE:
+		return "OP_SPECIALIZE"
+	case OP_GREATER_EQUAL:
+		return "OP_GREATER_EQUAL"
+	case OP_LESS_EQUAL:
+		return "OP_LESS_EQUAL"
+	case OP_IMPORT_LAZY:
+		return "OP_IMPORT_LAZY"
+	case OP_IMPORT_PLUGIN:
+		return "OP_IMPORT_PLUGIN"
+	case OP_TRY:
+		return "OP_TRY"
+	case OP_END_TRY:
+		return "OP_END_TRY"
+	case OP_THROW:
+		return "OP_THROW"
+	case OP_JUMP_IF_NOT_NULL:
+		return "OP_JUMP_IF_NOT_NULL"
+	case OP_OVER:
+		return "OP_OVER"
 	default:
 		return fmt.Sprintf("OP_%d", op)
 	}
@@ -225,6 +277,27 @@ type Chunk struct {
 	Constants []value.Value
 	Lines     []int
 	FileName  string
+	// Caches holds the inline cache of each property instruction, which
+	// names its entry by index
+	Caches []atomic.Pointer[PropertyCache]
+	// Imports lists the modules this chunk's use statements import, in
+	// source order, so they can be loaded before the chunk runs
+	Imports []Import
+}
+
+// Import is a module named by a use statement.
+type Import struct {
+	Module string
+	Line   int
+}
+
+// PropertyCache remembers where one property instruction last found its
+// field: every instance of Struct keeps it at Fields[Slot]. An entry is
+// never changed once stored, so threads running the same chunk can swap
+// entries without locking.
+type PropertyCache struct {
+	Struct *value.ObjStruct
+	Slot   int
 }
 
 func New() *Chunk {
@@ -246,6 +319,12 @@ func (c *Chunk) AddConstant(v value.Value) int {
 	return len(c.Constants) - 1
 }
 
+// AddCache adds an empty inline cache and returns its index.
+func (c *Chunk) AddCache() int {
+	c.Caches = append(c.Caches, atomic.Pointer[PropertyCache]{})
+	return len(c.Caches) - 1
+}
+
 func (c *Chunk) Disassemble(name string) {
 	fmt.Printf("== %s ==\n", name)
 	fmt.Printf("Addr Line Opcode           Indx Value\n")
@@ -336,8 +415,6 @@ func (c *Chunk) disassembleInstruction(offset int) int {
 		return c.simpleInstruction("OP_SHIFT_RIGHT", offset)
 	case OP_NEGATE:
 		return c.simpleInstruction("OP_NEGATE", offset)
-	case OP_PRINT:
-		return c.simpleInstruction("OP_PRINT", offset)
 	case OP_JUMP:
 		return c.shortInstruction("OP_JUMP", offset)
 	case OP_JUMP_IF_FALSE:
@@ -357,9 +434,9 @@ func (c *Chunk) disassembleInstruction(offset int) int {
 	case OP_SET_INDEX:
 		return c.simpleInstruction("OP_SET_INDEX", offset)
 	case OP_GET_PROPERTY:
-		return c.constantInstruction("OP_GET_PROPERTY", offset)
+		return c.propertyInstruction("OP_GET_PROPERTY", offset)
 	case OP_SET_PROPERTY:
-		return c.constantInstruction("OP_SET_PROPERTY", offset)
+		return c.propertyInstruction("OP_SET_PROPERTY", offset)
 	case OP_ZEROS:
 		return c.simpleInstruction("OP_ZEROS", offset)
 	case OP_LEN:
@@ -418,6 +495,46 @@ func (c *Chunk) disassembleInstruction(offset int) int {
 		return c.simpleInstruction("OP_COPY", offset)
 	case OP_ADDR:
 		return c.simpleInstruction("OP_ADDR", offset)
+	case OP_CHECK_TYPE:
+		return c.checkTypeInstruction("OP_CHECK_TYPE", offset)
+	case OP_INVOKE:
+		return c.invokeInstruction("OP_INVOKE", offset)
+	case OP_METHOD:
+		return c.constantInstruction("OP_METHOD", offset)
+	case OP_ADD_FLOAT:
+		return c.simpleInstruction("OP_ADD_FLOAT", offset)
+	case OP_SUB_FLOAT:
+		return c.simpleInstruction("OP_SUB_FLOAT", offset)
+	case OP_MUL_FLOAT:
+		return c.simpleInstruction("OP_MUL_FLOAT", offset)
+	case OP_DIV_FLOAT:
+		return c.simpleInstruction("OP_DIV_FLOAT", offset)
+	case OP_GET_INDEX_ARRAY:
+		return c.simpleInstruction("OP_GET_INDEX_ARRAY", offset)
+	case OP_SET_INDEX_ARRAY:
+		return c.simpleInstruction("OP_SET_INDEX_ARRAY", offset)
+	case OP_SPECIALIZE:
+		return c.byteInstruction("OP_SPECIALIZE", offset)
+	case OP_GREATER_EQUAL:
+		return c.simpleInstruction("OP_GREATER_EQUAL", offset)
+	case OP_LESS_EQUAL:
+		return c.simpleInstruction("OP_LESS_EQUAL", offset)
+	case OP_IMPORT_LAZY:
+		return c.constantInstruction("OP_IMPORT_LAZY", offset)
+	case OP_IMPORT_PLUGIN:
+		plugin, bind := c.Code[offset+1], c.Code[offset+2]
+		fmt.Printf("%-16s %4d '%v' as '%v'\n", "OP_IMPORT_PLUGIN", plugin, c.Constants[plugin], c.Constants[bind])
+		return offset + 3
+	case OP_TRY:
+		return c.shortInstruction("OP_TRY", offset)
+	case OP_END_TRY:
+		return c.simpleInstruction("OP_END_TRY", offset)
+	case OP_THROW:
+		return c.simpleInstruction("OP_THROW", offset)
+	case OP_JUMP_IF_NOT_NULL:
+		return c.shortInstruction("OP_JUMP_IF_NOT_NULL", offset)
+	case OP_OVER:
+		return c.simpleInstruction("OP_OVER", offset)
 	default:
 		fmt.Printf("Unknown opcode %d\n", instruction)
 		return offset + 1
@@ -437,12 +554,33 @@ func (c *Chunk) constantInstruction(name string, offset int) int {
 	return offset + 2
 }
 
+func (c *Chunk) propertyInstruction(name string, offset int) int {
+	constant := c.Code[offset+1]
+	cache := uint16(c.Code[offset+2])<<8 | uint16(c.Code[offset+3])
+	fmt.Printf("%-16s %4d '%v' (cache %d)\n", name, constant, c.Constants[constant], cache)
+	return offset + 4
+}
+
 func (c *Chunk) byteInstruction(name string, offset int) int {
 	slot := c.Code[offset+1]
 	fmt.Printf("%-16s %4d\n", name, slot)
 	return offset + 2
 }
 
+func (c *Chunk) checkTypeInstruction(name string, offset int) int {
+	typeConst := uint16(c.Code[offset+1])<<8 | uint16(c.Code[offset+2])
+	whatConst := uint16(c.Code[offset+3])<<8 | uint16(c.Code[offset+4])
+	fmt.Printf("%-16s %4d '%v' for %v\n", name, typeConst, c.Constants[typeConst], c.Constants[whatConst])
+	return offset + 5
+}
+
+func (c *Chunk) invokeInstruction(name string, offset int) int {
+	constant := c.Code[offset+1]
+	argCount := c.Code[offset+2]
+	fmt.Printf("%-16s (%d args) %4d '%v'\n", name, argCount, constant, c.Constants[constant])
+	return offset + 3
+}
+
 func (c *Chunk) shortInstruction(name string, offset int) int {
 	slot := uint16(c.Code[offset+1])<<8 | uint16(c.Code[offset+2])
 	fmt.Printf("%-16s %4d\n", name, slot)
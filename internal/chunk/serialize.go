@@ -0,0 +1,327 @@
+package chunk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"noxy-vm/internal/value"
+	"sync/atomic"
+)
+
+// FormatVersion changes whenever the encoding or the instruction set does,
+// so stale serialized chunks are rejected instead of misread.
+const FormatVersion = 13
+
+var magic = []byte("NXC")
+
+// Constant tags
+const (
+	tagNull byte = iota
+	tagBool
+	tagInt
+	tagFloat
+	tagString
+	tagBytes
+	tagStruct
+	tagFunction
+)
+
+// ErrUnsupportedConstant is returned when a chunk holds a constant that has
+// no serialized form (only compile-time constants are supported).
+var ErrUnsupportedConstant = errors.New("constant cannot be serialized")
+
+// Serialize encodes the chunk, including nested function chunks.
+func (c *Chunk) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(magic)
+	buf.WriteByte(FormatVersion)
+	w := &encoder{w: &buf}
+	w.chunk(c)
+	if w.err != nil {
+		return nil, w.err
+	}
+	return buf.Bytes(), nil
+}
+
+// IsSerialized reports whether data starts like the output of Serialize,
+// whatever its format version.
+func IsSerialized(data []byte) bool {
+	return len(data) > len(magic) && bytes.Equal(data[:len(magic)], magic)
+}
+
+// Deserialize decodes a chunk produced by Serialize.
+func Deserialize(data []byte) (*Chunk, error) {
+	if !IsSerialized(data) {
+		return nil, fmt.Errorf("not a compiled noxy chunk")
+	}
+	if v := data[len(magic)]; v != FormatVersion {
+		return nil, fmt.Errorf("compiled chunk format %d, expected %d", v, FormatVersion)
+	}
+	rest := data[len(magic)+1:]
+	r := &decoder{r: bufio.NewReader(bytes.NewReader(rest)), limit: uint64(len(rest))}
+	c := r.chunk()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return c, nil
+}
+
+type encoder struct {
+	w   *bytes.Buffer
+	err error
+}
+
+func (e *encoder) uvarint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.w.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func (e *encoder) varint(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.w.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func (e *encoder) str(s string) {
+	e.uvarint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *encoder) chunk(c *Chunk) {
+	e.str(c.FileName)
+	e.uvarint(uint64(len(c.Code)))
+	e.w.Write(c.Code)
+	e.uvarint(uint64(len(c.Lines)))
+	for _, line := range c.Lines {
+		e.varint(int64(line))
+	}
+	e.uvarint(uint64(len(c.Constants)))
+	for _, v := range c.Constants {
+		e.value(v)
+		if e.err != nil {
+			return
+		}
+	}
+	e.uvarint(uint64(len(c.Caches)))
+	e.uvarint(uint64(len(c.Imports)))
+	for _, imp := range c.Imports {
+		e.str(imp.Module)
+		e.varint(int64(imp.Line))
+	}
+}
+
+func (e *encoder) value(v value.Value) {
+	switch v.Type {
+	case value.VAL_NULL:
+		e.w.WriteByte(tagNull)
+	case value.VAL_BOOL:
+		e.w.WriteByte(tagBool)
+		if v.AsBool {
+			e.w.WriteByte(1)
+		} else {
+			e.w.WriteByte(0)
+		}
+	case value.VAL_INT:
+		e.w.WriteByte(tagInt)
+		e.varint(v.AsInt)
+	case value.VAL_FLOAT:
+		e.w.WriteByte(tagFloat)
+		e.uvarint(math.Float64bits(v.AsFloat))
+	case value.VAL_BYTES:
+		s, ok := v.Obj.(string)
+		if !ok {
+			e.err = ErrUnsupportedConstant
+			return
+		}
+		e.w.WriteByte(tagBytes)
+		e.str(s)
+	case value.VAL_OBJ:
+		switch o := v.Obj.(type) {
+		case string:
+			e.w.WriteByte(tagString)
+			e.str(o)
+		case *value.ObjStruct:
+			e.w.WriteByte(tagStruct)
+			e.str(o.Name)
+			e.uvarint(uint64(len(o.Fields)))
+			for _, f := range o.Fields {
+				e.str(f)
+			}
+			e.uvarint(uint64(len(o.FieldTypes)))
+			for _, t := range o.FieldTypes {
+				e.str(t)
+			}
+		default:
+			e.err = fmt.Errorf("%w: %T", ErrUnsupportedConstant, v.Obj)
+		}
+	case value.VAL_FUNCTION:
+		fn, ok := v.Obj.(*value.ObjFunction)
+		if !ok {
+			e.err = fmt.Errorf("%w: %T", ErrUnsupportedConstant, v.Obj)
+			return
+		}
+		fc, ok := fn.Chunk.(*Chunk)
+		if !ok {
+			e.err = ErrUnsupportedConstant
+			return
+		}
+		e.w.WriteByte(tagFunction)
+		e.str(fn.Name)
+		e.uvarint(uint64(fn.Arity))
+		e.uvarint(uint64(fn.UpvalueCount))
+		e.uvarint(uint64(len(fn.Params)))
+		for _, p := range fn.Params {
+			if p.IsRef {
+				e.w.WriteByte(1)
+			} else {
+				e.w.WriteByte(0)
+			}
+		}
+		e.chunk(fc)
+	default:
+		e.err = fmt.Errorf("%w: type %d", ErrUnsupportedConstant, v.Type)
+	}
+}
+
+type decoder struct {
+	r     *bufio.Reader
+	limit uint64 // input size; no count can exceed it
+	err   error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	}
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return n
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return n
+}
+
+// length reads a count and rejects values no valid chunk could contain.
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > d.limit {
+		d.fail(fmt.Errorf("corrupt compiled chunk"))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.fail(err)
+	}
+	return b
+}
+
+func (d *decoder) str() string {
+	return string(d.bytes(d.length()))
+}
+
+func (d *decoder) chunk() *Chunk {
+	c := New()
+	c.FileName = d.str()
+	c.Code = d.bytes(d.length())
+	n := d.length()
+	c.Lines = make([]int, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		c.Lines = append(c.Lines, int(d.varint()))
+	}
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		c.Constants = append(c.Constants, d.value())
+	}
+	// The caches start empty; only their number is stored
+	c.Caches = make([]atomic.Pointer[PropertyCache], d.length())
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		c.Imports = append(c.Imports, Import{Module: d.str(), Line: int(d.varint())})
+	}
+	return c
+}
+
+func (d *decoder) value() value.Value {
+	switch tag := d.byte(); tag {
+	case tagNull:
+		return value.NewNull()
+	case tagBool:
+		return value.NewBool(d.byte() == 1)
+	case tagInt:
+		return value.NewInt(d.varint())
+	case tagFloat:
+		return value.NewFloat(math.Float64frombits(d.uvarint()))
+	case tagString:
+		return value.NewString(d.str())
+	case tagBytes:
+		return value.NewBytes(d.str())
+	case tagStruct:
+		name := d.str()
+		fields := make([]string, d.length())
+		for i := range fields {
+			fields[i] = d.str()
+		}
+		def := value.NewStruct(name, fields)
+		if n := d.length(); n > 0 {
+			types := make([]string, n)
+			for i := range types {
+				types[i] = d.str()
+			}
+			def.Obj.(*value.ObjStruct).FieldTypes = types
+		}
+		return def
+	case tagFunction:
+		name := d.str()
+		arity := int(d.uvarint())
+		upvalues := int(d.uvarint())
+		params := make([]value.ParamInfo, d.length())
+		for i := range params {
+			params[i].IsRef = d.byte() == 1
+		}
+		fc := d.chunk()
+		return value.NewFunction(name, arity, upvalues, params, fc, nil)
+	default:
+		d.fail(fmt.Errorf("corrupt compiled chunk: unknown constant tag %d", tag))
+		return value.NewNull()
+	}
+}
@@ -1,11 +1,11 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"noxy-vm/internal/ast"
 	"noxy-vm/internal/chunk"
 	"noxy-vm/internal/value"
-	"strings"
 )
 
 type Local struct {
@@ -18,12 +18,14 @@ type Local struct {
 
 type Loop struct {
 	EnclosingLocals int
+	EnclosingTries  int
 	BreakJumps      []int
 }
 
 type Upvalue struct {
 	Index   uint8
 	IsLocal bool
+	Type    ast.NoxyType // Of the captured variable
 }
 
 type Compiler struct {
@@ -34,10 +36,21 @@ type Compiler struct {
 	upvalues       []Upvalue
 	scopeDepth     int
 	loops          []*Loop
+	tries          int // try blocks open around the code being compiled
 	currentLine    int
 	FileName       string
 	funcReturnType ast.NoxyType // Expected return type for current function context
+	funcName       string
 	structs        map[string]*ast.StructStatement
+	embedded       []string // Files bundled by embed statements
+	// Strict emits runtime checks that values stored in annotated
+	// variables, passed as parameters and returned match their types.
+	Strict bool
+	// typeErrors collects the type errors of the whole compilation,
+	// including nested functions, which share it; depth counts the active
+	// Compile calls so the outermost one can report them.
+	typeErrors *[]error
+	depth      int
 }
 
 func New() *Compiler {
@@ -56,6 +69,7 @@ func NewWithState(globals map[string]ast.NoxyType, structs map[string]*ast.Struc
 		loops:        []*Loop{},
 		currentLine:  1,
 		FileName:     fileName,
+		typeErrors:   new([]error),
 	}
 	c.currentChunk.FileName = fileName
 	return c
@@ -73,6 +87,8 @@ func NewChild(parent *Compiler) *Compiler {
 		loops:        []*Loop{},
 		currentLine:  parent.currentLine,
 		FileName:     parent.FileName,
+		Strict:       parent.Strict,
+		typeErrors:   parent.typeErrors,
 	}
 	c.currentChunk.FileName = parent.FileName
 	return c
@@ -82,7 +98,28 @@ func (c *Compiler) GetGlobals() map[string]ast.NoxyType {
 	return c.globals
 }
 
+// Compile compiles node and returns its static type. A type error does not
+// stop compilation, so that every one in the program is reported: the
+// outermost call returns them all, one per line in source order, followed
+// by the error that stopped compilation, if any.
 func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
+	c.depth++
+	ch, t, err := c.compile(node)
+	c.depth--
+	if c.depth > 0 || c.enclosing != nil || len(*c.typeErrors) == 0 {
+		return ch, t, err
+	}
+	errs := append(*c.typeErrors, err)
+	*c.typeErrors = nil
+	return nil, nil, errors.Join(errs...)
+}
+
+// typeError records a type error at the current line.
+func (c *Compiler) typeError(format string, args ...interface{}) {
+	*c.typeErrors = append(*c.typeErrors, fmt.Errorf("[line %d] "+format, append([]interface{}{c.currentLine}, args...)...))
+}
+
+func (c *Compiler) compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 	switch n := node.(type) {
 	case *ast.Program:
 		for _, stmt := range n.Statements {
@@ -98,6 +135,13 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 	case *ast.LetStmt:
 		c.setLine(n.Token.Line)
 		var valType ast.NoxyType
+		// A local function literal is declared before it is compiled, so it
+		// can call itself through its own name
+		_, isFuncLit := n.Value.(*ast.FunctionLiteral)
+		declaredEarly := c.scopeDepth > 0 && isFuncLit
+		if declaredEarly {
+			c.addLocal(n.Name.Value, n.Type)
+		}
 		// Compile initializer
 		if n.Value != nil {
 			_, t, err := c.Compile(n.Value)
@@ -125,13 +169,17 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			}
 
 			if !c.areTypesCompatible(n.Type, valType) {
-				return nil, nil, fmt.Errorf("[line %d] type mismatch in '%s' declaration: expected %s, got %s", c.currentLine, n.Name.Value, n.Type.String(), valType.String())
+				c.typeError("type mismatch in '%s' declaration: expected %s, got %s", n.Name.Value, n.Type.String(), valType.String())
 			}
+			c.emitStrictCheck(n.Type, "'"+n.Name.Value+"'")
+			c.emitSpecialize(n.Type)
 		}
 
 		if c.scopeDepth > 0 {
 			// Local variable
-			c.addLocal(n.Name.Value, n.Type)
+			if !declaredEarly {
+				c.addLocal(n.Name.Value, n.Type)
+			}
 			// Do NOT pop. The value stays on stack and becomes the local variable.
 		} else {
 			// Global
@@ -179,6 +227,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		return c.currentChunk, &ast.PrimitiveType{Name: "bytes"}, nil
 
 	case *ast.AssignStmt:
+		c.setLine(n.Token.Line)
 		if prefixExp, ok := n.Target.(*ast.PrefixExpression); ok {
 			// Explicit Dereference Assignment: *ref = val
 			// This signals an UPDATE (writing to the value pointed to).
@@ -219,7 +268,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			}
 
 			if !c.areTypesCompatible(refT.ElementType, valType) {
-				return nil, nil, fmt.Errorf("[line %d] type mismatch in assignment: expected %s, got %s", c.currentLine, refT.ElementType.String(), valType.String())
+				c.typeError("type mismatch in assignment: expected %s, got %s", refT.ElementType.String(), valType.String())
 			}
 
 			// 4. Emit Store
@@ -256,7 +305,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 					// Enable rebind if valType is nil (unknown, e.g. from imports) or explicitly ref
 					if isRefVal || valType == nil {
 						if valType != nil && !c.areTypesCompatible(refType, valType) {
-							return nil, nil, fmt.Errorf("[line %d] type mismatch in assignment to '%s': expected %s, got %s", c.currentLine, ident.Value, localType.String(), valType.String())
+							c.typeError("type mismatch in assignment to '%s': expected %s, got %s", ident.Value, localType.String(), valType.String())
 						}
 
 						// Check if trying to rebind a ref parameter
@@ -270,19 +319,26 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 						return c.currentChunk, nil, nil
 					}
 
-					return nil, nil, fmt.Errorf("[line %d] type mismatch in assignment to '%s': expected %s, got %s", c.currentLine, ident.Value, localType.String(), valType.String())
+					c.typeError("type mismatch in assignment to '%s': expected %s, got %s", ident.Value, localType.String(), valType.String())
 
 				} else {
 					// Standard Value Assignment (int = int)
 					if !c.areTypesCompatible(localType, valType) {
-						return nil, nil, fmt.Errorf("[line %d] type mismatch in assignment to '%s': expected %s, got %s", c.currentLine, ident.Value, localType.String(), valType.String())
+						c.typeError("type mismatch in assignment to '%s': expected %s, got %s", ident.Value, localType.String(), valType.String())
 					}
+					c.emitStrictCheck(localType, "'"+ident.Value+"'")
+					c.emitSpecialize(localType)
 					c.emitBytes(byte(chunk.OP_SET_LOCAL), byte(arg))
 					c.emitByte(byte(chunk.OP_POP))
 				}
 			} else if arg := c.resolveUpvalue(ident.Value); arg != -1 {
-				// Upvalue Logic
-				// TODO: Implement type checking for upvalues.
+				// Captured variables are checked like the locals they are
+				upType := c.upvalues[arg].Type
+				if !c.areTypesCompatible(upType, valType) {
+					c.typeError("type mismatch in assignment to '%s': expected %s, got %s", ident.Value, upType.String(), valType.String())
+				}
+				c.emitStrictCheck(upType, "'"+ident.Value+"'")
+				c.emitSpecialize(upType)
 				c.emitBytes(byte(chunk.OP_SET_UPVALUE), byte(arg))
 				c.emitByte(byte(chunk.OP_POP))
 			} else {
@@ -296,7 +352,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 						// Allow rebind if valType is Ref or nil (dynamic/unknown)
 						if isRefVal || valType == nil {
 							if valType != nil && !c.areTypesCompatible(globalType, valType) {
-								return nil, nil, fmt.Errorf("[line %d] type mismatch in rebind to global '%s': expected %s, got %s", c.currentLine, ident.Value, globalType.String(), valType.String())
+								c.typeError("type mismatch in rebind to global '%s': expected %s, got %s", ident.Value, globalType.String(), valType.String())
 							}
 
 							nameConstant := c.makeConstant(value.NewString(ident.Value))
@@ -307,15 +363,17 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 							if c.areTypesCompatible(refType.ElementType, valType) {
 								return nil, nil, fmt.Errorf("[line %d] cannot assign value to global reference '%s'.\n  hint: Did you mean to update the value? Use '*%s = ...'", c.currentLine, ident.Value, ident.Value)
 							}
-							return nil, nil, fmt.Errorf("[line %d] type mismatch in assignment to global '%s': expected %s, got %s", c.currentLine, ident.Value, globalType.String(), valType.String())
+							c.typeError("type mismatch in assignment to global '%s': expected %s, got %s", ident.Value, globalType.String(), valType.String())
 						}
 						return c.currentChunk, nil, nil
 					}
 
 					// Standard Global Assignment
 					if !c.areTypesCompatible(globalType, valType) {
-						return nil, nil, fmt.Errorf("[line %d] type mismatch in assignment to global '%s': expected %s, got %s", c.currentLine, ident.Value, globalType.String(), valType.String())
+						c.typeError("type mismatch in assignment to global '%s': expected %s, got %s", ident.Value, globalType.String(), valType.String())
 					}
+					c.emitStrictCheck(globalType, "'"+ident.Value+"'")
+					c.emitSpecialize(globalType)
 				}
 				nameConstant := c.makeConstant(value.NewString(ident.Value))
 				c.emitBytes(byte(chunk.OP_SET_GLOBAL), byte(nameConstant))
@@ -365,7 +423,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			// Type Check
 			if arrType, ok := leftType.(*ast.ArrayType); ok {
 				if idxType != nil && idxType.String() != "int" {
-					return nil, nil, fmt.Errorf("[line %d] array index must be int, got %s", c.currentLine, idxType.String())
+					c.typeError("array index must be int, got %s", idxType.String())
 				}
 
 				// STRICT CHECK:
@@ -374,14 +432,21 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 				// User must use `*arr[i] = val` for updates.
 
 				if !c.areTypesCompatible(arrType.ElementType, valType) {
-					return nil, nil, fmt.Errorf("[line %d] type mismatch in array assignment: expected %s, got %s", c.currentLine, arrType.ElementType.String(), valType.String())
+					c.typeError("type mismatch in array assignment: expected %s, got %s", arrType.ElementType.String(), valType.String())
 				}
 			} else if mapType, ok := leftType.(*ast.MapType); ok {
 				if !c.areTypesCompatible(mapType.KeyType, idxType) {
-					return nil, nil, fmt.Errorf("[line %d] type mismatch in map key: expected %s, got %s", c.currentLine, mapType.KeyType.String(), idxType.String())
+					c.typeError("type mismatch in map key: expected %s, got %s", mapType.KeyType.String(), idxType.String())
 				}
 				if !c.areTypesCompatible(mapType.ValueType, valType) {
-					return nil, nil, fmt.Errorf("[line %d] type mismatch in map value: expected %s, got %s", c.currentLine, mapType.ValueType.String(), valType.String())
+					c.typeError("type mismatch in map value: expected %s, got %s", mapType.ValueType.String(), valType.String())
+				}
+			} else if leftType != nil && leftType.String() == "buffer" {
+				if idxType != nil && idxType.String() != "int" {
+					c.typeError("buffer index must be int, got %s", idxType.String())
+				}
+				if !c.areTypesCompatible(&ast.PrimitiveType{Name: "int"}, valType) {
+					c.typeError("type mismatch in buffer assignment: expected int, got %s", valType.String())
 				}
 			} else {
 				if leftType != nil && leftType.String() != "any" {
@@ -389,7 +454,11 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 				}
 			}
 
-			c.emitByte(byte(chunk.OP_SET_INDEX))
+			if elemKind(leftType) != value.ElemValues && idxType != nil && idxType.String() == "int" {
+				c.emitByte(byte(chunk.OP_SET_INDEX_ARRAY))
+			} else {
+				c.emitByte(byte(chunk.OP_SET_INDEX))
+			}
 			c.emitByte(byte(chunk.OP_POP))
 
 		} else if memberExp, ok := n.Target.(*ast.MemberAccessExpression); ok {
@@ -415,16 +484,14 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			}
 
 			// RESOLVE FIELD TYPE:
-			var fieldType ast.NoxyType
-			if prim, ok := leftType.(*ast.PrimitiveType); ok {
-				if structDef, exists := c.structs[prim.Name]; exists {
-					for _, f := range structDef.FieldsList {
-						if f.Name == memberExp.Member {
-							fieldType = f.Type
-							break
-						}
-					}
-				}
+			fieldType, err := c.fieldType(leftType, memberExp.Member)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Through a ref the field is only checked to exist: assigning a
+			// struct value to a ref field of the target is allowed there
+			if _, ok := leftType.(*ast.RefType); ok {
+				fieldType = nil
 			}
 
 			// TYPE-BASED ASSIGNMENT LOGIC:
@@ -440,7 +507,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 					// Assuming null is compatible
 					if isRefVal || valType == nil {
 						if valType != nil && !c.areTypesCompatible(fieldType, valType) {
-							return nil, nil, fmt.Errorf("[line %d] type mismatch in rebind: expected %s, got %s", c.currentLine, fieldType.String(), valType.String())
+							c.typeError("type mismatch in rebind: expected %s, got %s", fieldType.String(), valType.String())
 						}
 					} else {
 						// Error: Trying to assign Value to Ref Field
@@ -449,14 +516,16 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 				} else {
 					// Standard Field
 					if !c.areTypesCompatible(fieldType, valType) {
-						return nil, nil, fmt.Errorf("[line %d] type mismatch in field assignment: expected %s, got %s", c.currentLine, fieldType.String(), valType.String())
+						c.typeError("type mismatch in field assignment: expected %s, got %s", fieldType.String(), valType.String())
 					}
 				}
 			}
 
 			// Field Name
 			nameConst := c.makeConstant(value.NewString(memberExp.Member))
-			c.emitBytes(byte(chunk.OP_SET_PROPERTY), byte(nameConst))
+			if err := c.emitProperty(chunk.OP_SET_PROPERTY, nameConst); err != nil {
+				return nil, nil, err
+			}
 			c.emitByte(byte(chunk.OP_POP))
 
 		} else {
@@ -468,10 +537,29 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		c.setLine(n.Token.Line)
 
 		fields := []string{}
+		fieldTypes := []string{}
 		for _, f := range n.FieldsList {
 			fields = append(fields, f.Name)
+			if f.Type != nil {
+				fieldTypes = append(fieldTypes, f.Type.String())
+			} else {
+				fieldTypes = append(fieldTypes, "any")
+			}
+		}
+		seen := map[string]bool{}
+		for _, f := range n.FieldsList {
+			seen[f.Name] = true
+		}
+		for _, m := range n.Methods {
+			if seen[m.Name] {
+				return nil, nil, fmt.Errorf("[line %d] struct %s already has a field or method '%s'", m.Token.Line, n.Name, m.Name)
+			}
+			seen[m.Name] = true
 		}
+
 		structObj := value.NewStruct(n.Name, fields)
+		// Field types let natives such as from_map rebuild nested structs
+		structObj.Obj.(*value.ObjStruct).FieldTypes = fieldTypes
 		c.emitConstant(structObj)
 
 		// Create Constructor Signature
@@ -484,6 +572,8 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			Return: &ast.PrimitiveType{Name: n.Name},
 		}
 
+		// The struct is declared before its methods are compiled, so they
+		// can build instances and call each other
 		if c.scopeDepth > 0 {
 			// Local scope: struct is a local variable
 			c.addLocal(n.Name, structType)
@@ -493,7 +583,18 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			c.globals[n.Name] = structType
 			// Register struct definition for field lookup
 			c.structs[n.Name] = n
+		}
+
+		for _, m := range n.Methods {
+			c.setLine(m.Token.Line)
+			if err := c.compileClosure(n.Name+"."+m.Name, m.Parameters[1:], m.Body, m.ReturnType, &ast.PrimitiveType{Name: n.Name}); err != nil {
+				return nil, nil, err
+			}
+			nameConst := c.makeConstant(value.NewString(m.Name))
+			c.emitBytes(byte(chunk.OP_METHOD), byte(nameConst))
+		}
 
+		if c.scopeDepth == 0 {
 			nameConst := c.makeConstant(value.NewString(n.Name))
 			c.emitBytes(byte(chunk.OP_SET_GLOBAL), byte(nameConst))
 			c.emitByte(byte(chunk.OP_POP))
@@ -501,6 +602,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		return c.currentChunk, nil, nil
 
 	case *ast.MemberAccessExpression:
+		c.setLine(n.Token.Line)
 		// Left . Member
 		_, leftType, err := c.Compile(n.Left)
 		if err != nil {
@@ -514,26 +616,19 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		}
 
 		nameConst := c.makeConstant(value.NewString(n.Member))
-		c.emitBytes(byte(chunk.OP_GET_PROPERTY), byte(nameConst))
+		if err := c.emitProperty(chunk.OP_GET_PROPERTY, nameConst); err != nil {
+			return nil, nil, err
+		}
 
 		// RESOLVE FIELD TYPE:
-		// Look up struct definition if leftType is a named PrimitiveType
-		if prim, ok := leftType.(*ast.PrimitiveType); ok {
-			if structDef, exists := c.structs[prim.Name]; exists {
-				// Find field type
-				for _, f := range structDef.FieldsList {
-					if f.Name == n.Member {
-						return c.currentChunk, f.Type, nil
-					}
-				}
-				// Field not found logic? (or let runtime handle if dynamic)
-				// For strict structs, this should probably be an error, but let's return nil (dynamic) if not found.
-			}
+		fieldType, err := c.fieldType(leftType, n.Member)
+		if err != nil {
+			return nil, nil, err
 		}
-
-		return c.currentChunk, nil, nil
+		return c.currentChunk, fieldType, nil
 
 	case *ast.ArrayLiteral:
+		c.setLine(n.Token.Line)
 		var elemType ast.NoxyType
 		for i, el := range n.Elements {
 			_, t, err := c.Compile(el)
@@ -561,6 +656,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		return c.currentChunk, &ast.ArrayType{ElementType: elemType, Size: count}, nil
 
 	case *ast.MapLiteral:
+		c.setLine(n.Token.Line)
 		// Push keys and values: k1, v1, k2, v2, ...
 		var keyType ast.NoxyType
 		var valType ast.NoxyType
@@ -600,6 +696,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		return c.currentChunk, &ast.MapType{KeyType: keyType, ValueType: valType}, nil
 
 	case *ast.IndexExpression:
+		c.setLine(n.Token.Line)
 		_, leftType, err := c.Compile(n.Left)
 		if err != nil {
 			return nil, nil, err
@@ -636,13 +733,17 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			// return nil, nil, fmt.Errorf("index must be int, got %s", idxType)
 		}
 
-		c.emitByte(byte(chunk.OP_GET_INDEX))
-
 		// Result Type: Element type of array
 		// Unwrap RefType (getting index from ref array)
 		if ref, ok := leftType.(*ast.RefType); ok {
 			leftType = ref.ElementType
 		}
+
+		if elemKind(leftType) != value.ElemValues && idxType != nil && idxType.String() == "int" {
+			c.emitByte(byte(chunk.OP_GET_INDEX_ARRAY))
+		} else {
+			c.emitByte(byte(chunk.OP_GET_INDEX))
+		}
 		if arrKey, ok := leftType.(*ast.ArrayType); ok {
 			return c.currentChunk, arrKey.ElementType, nil
 		}
@@ -650,6 +751,9 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		if mapKey, ok := leftType.(*ast.MapType); ok {
 			return c.currentChunk, mapKey.ValueType, nil
 		}
+		if leftType != nil && (leftType.String() == "buffer" || leftType.String() == "bytes") {
+			return c.currentChunk, &ast.PrimitiveType{Name: "int"}, nil
+		}
 
 		return c.currentChunk, nil, nil
 
@@ -660,7 +764,10 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			return c.currentChunk, t, nil
 		} else if arg := c.resolveUpvalue(n.Value); arg != -1 {
 			c.emitBytes(byte(chunk.OP_GET_UPVALUE), byte(arg))
-			return c.currentChunk, &ast.PrimitiveType{Name: "any"}, nil // Types for upvalues not tracked yet
+			if t := c.upvalues[arg].Type; t != nil {
+				return c.currentChunk, t, nil
+			}
+			return c.currentChunk, &ast.PrimitiveType{Name: "any"}, nil
 		} else {
 			// Global
 			nameConstant := c.makeConstant(value.NewString(n.Value))
@@ -673,6 +780,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		}
 
 	case *ast.InfixExpression:
+		c.setLine(n.Token.Line)
 		// Short-circuit Logic
 		if n.Operator == "&&" {
 			_, leftType, err := c.Compile(n.Left)
@@ -715,6 +823,9 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 
 			return c.currentChunk, &ast.PrimitiveType{Name: "bool"}, nil
 		}
+		if n.Operator == "??" {
+			return c.compileCoalesce(n)
+		}
 
 		_, leftType, err := c.Compile(n.Left)
 		if err != nil {
@@ -743,51 +854,55 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			}
 		}
 
-		// Check if both operands are INT for optimization
-		isInt := false
+		// The operator's line, in case the operands span lines
+		c.setLine(n.Token.Line)
+
+		// Check if both operands are INT (or FLOAT) for optimization
+		isInt, isFloat := false, false
 		if leftType != nil && rightType != nil {
 			if leftType.String() == "int" && rightType.String() == "int" {
 				isInt = true
 			}
+			if leftType.String() == "float" && rightType.String() == "float" {
+				isFloat = true
+			}
 		}
 
 		switch n.Operator {
 		case "+":
 			if isInt {
 				c.emitByte(byte(chunk.OP_ADD_INT))
+			} else if isFloat {
+				c.emitByte(byte(chunk.OP_ADD_FLOAT))
 			} else {
 				c.emitByte(byte(chunk.OP_ADD))
 			}
 		case "-":
 			if isInt {
 				c.emitByte(byte(chunk.OP_SUB_INT))
+			} else if isFloat {
+				c.emitByte(byte(chunk.OP_SUB_FLOAT))
 			} else {
 				c.emitByte(byte(chunk.OP_SUBTRACT))
 			}
 		case "*":
 			if isInt {
 				c.emitByte(byte(chunk.OP_MUL_INT))
+			} else if isFloat {
+				c.emitByte(byte(chunk.OP_MUL_FLOAT))
 			} else {
 				c.emitByte(byte(chunk.OP_MULTIPLY))
 			}
 		case "/":
 			if isInt {
 				c.emitByte(byte(chunk.OP_DIV_INT))
+			} else if isFloat {
+				c.emitByte(byte(chunk.OP_DIV_FLOAT))
 			} else {
 				c.emitByte(byte(chunk.OP_DIVIDE))
 			}
-		case ">":
-			if isInt {
-				c.emitByte(byte(chunk.OP_GREATER_INT))
-			} else {
-				c.emitByte(byte(chunk.OP_GREATER))
-			}
-		case "<":
-			if isInt {
-				c.emitByte(byte(chunk.OP_LESS_INT))
-			} else {
-				c.emitByte(byte(chunk.OP_LESS))
-			}
+		case ">", "<", ">=", "<=":
+			c.emitComparison(n.Operator, isInt)
 		case "==":
 			if isInt {
 				c.emitByte(byte(chunk.OP_EQUAL_INT))
@@ -802,20 +917,6 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 				c.emitByte(byte(chunk.OP_EQUAL))
 			}
 			c.emitByte(byte(chunk.OP_NOT))
-		case ">=": // >= is NOT LESS
-			if isInt {
-				c.emitByte(byte(chunk.OP_LESS_INT))
-			} else {
-				c.emitByte(byte(chunk.OP_LESS))
-			}
-			c.emitByte(byte(chunk.OP_NOT))
-		case "<=": // <= is NOT GREATER
-			if isInt {
-				c.emitByte(byte(chunk.OP_GREATER_INT))
-			} else {
-				c.emitByte(byte(chunk.OP_GREATER))
-			}
-			c.emitByte(byte(chunk.OP_NOT))
 		case "|":
 			c.emitByte(byte(chunk.OP_BIT_OR))
 		case "&":
@@ -841,6 +942,21 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			return c.currentChunk, &ast.PrimitiveType{Name: "bool"}, nil
 		}
 
+		// Arithmetic with an ndarray operand produces an ndarray
+		if (leftType != nil && leftType.String() == "ndarray") || (rightType != nil && rightType.String() == "ndarray") {
+			return c.currentChunk, &ast.PrimitiveType{Name: "ndarray"}, nil
+		}
+
+		// Arithmetic with a decimal operand produces a decimal
+		if (leftType != nil && leftType.String() == "decimal") || (rightType != nil && rightType.String() == "decimal") {
+			return c.currentChunk, &ast.PrimitiveType{Name: "decimal"}, nil
+		}
+
+		// Arithmetic with a bigint operand produces a bigint
+		if (leftType != nil && leftType.String() == "bigint") || (rightType != nil && rightType.String() == "bigint") {
+			return c.currentChunk, &ast.PrimitiveType{Name: "bigint"}, nil
+		}
+
 		// Arithmetic: if either is float, result is float
 		isFloatObj := false
 		if leftType != nil && leftType.String() == "float" {
@@ -861,7 +977,12 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		// Fallback?
 		return c.currentChunk, leftType, nil
 
+	case *ast.ComparisonChain:
+		c.setLine(n.Token.Line)
+		return c.compileComparisonChain(n)
+
 	case *ast.PrefixExpression:
+		c.setLine(n.Token.Line)
 		// Handle 'ref' operator specially - don't compile Right first
 		if n.Operator == "ref" {
 			// Handle 'ref' operator: ref x
@@ -904,6 +1025,9 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 				if _, ok := leftType.(*ast.RefType); ok {
 					c.emitByte(byte(chunk.OP_DEREF))
 				}
+				if _, err := c.fieldType(leftType, memberExp.Member); err != nil {
+					return nil, nil, err
+				}
 
 				nameConst := c.makeConstant(value.NewString(memberExp.Member))
 				c.emitBytes(byte(chunk.OP_REF_PROPERTY), byte(nameConst))
@@ -965,7 +1089,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			return nil, nil, err
 		}
 		c.emitByte(byte(chunk.OP_ZEROS))
-		return c.currentChunk, &ast.PrimitiveType{Name: "bytes"}, nil
+		return c.currentChunk, &ast.ArrayType{ElementType: &ast.PrimitiveType{Name: "int"}}, nil
 
 	case *ast.IfStatement:
 		c.setLine(n.Token.Line)
@@ -1014,7 +1138,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		loopStart := len(c.currentChunk.Code)
 
 		// Push Loop
-		loop := &Loop{EnclosingLocals: len(c.locals), BreakJumps: []int{}}
+		loop := &Loop{EnclosingLocals: len(c.locals), EnclosingTries: c.tries, BreakJumps: []int{}}
 		c.loops = append(c.loops, loop)
 
 		_, condType, err := c.Compile(n.Condition)
@@ -1053,6 +1177,13 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 	case *ast.ForStatement:
 		c.setLine(n.Token.Line)
 
+		if rng, ok := n.Collection.(*ast.RangeExpression); ok {
+			if err := c.compileRangeFor(n, rng); err != nil {
+				return nil, nil, err
+			}
+			return c.currentChunk, nil, nil
+		}
+
 		// 1. Wrapper Scope for iterator variables
 		c.beginScope()
 
@@ -1097,7 +1228,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 
 		// 6. Loop Setup
 		loopStart := len(c.currentChunk.Code)
-		loop := &Loop{EnclosingLocals: len(c.locals), BreakJumps: []int{}}
+		loop := &Loop{EnclosingLocals: len(c.locals), EnclosingTries: c.tries, BreakJumps: []int{}}
 		c.loops = append(c.loops, loop)
 
 		// 7. Condition: $index < $len
@@ -1112,7 +1243,11 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		// 8. Get Item -> User Variable
 		c.emitBytes(byte(chunk.OP_GET_LOCAL), byte(len(c.locals)-3)) // $collection
 		c.emitBytes(byte(chunk.OP_GET_LOCAL), byte(len(c.locals)-2)) // $index
-		c.emitByte(byte(chunk.OP_GET_INDEX))
+		if elemKind(colType) != value.ElemValues {
+			c.emitByte(byte(chunk.OP_GET_INDEX_ARRAY))
+		} else {
+			c.emitByte(byte(chunk.OP_GET_INDEX))
+		}
 
 		// Body Scope
 		c.beginScope()
@@ -1140,6 +1275,12 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		c.patchJump(jumpToExit)
 		c.emitByte(byte(chunk.OP_POP)) // Pop condition at exit
 
+		// Breaks land here, with only the wrapper locals left
+		for _, jump := range loop.BreakJumps {
+			c.patchJump(jump)
+		}
+		c.loops = c.loops[:len(c.loops)-1]
+
 		c.endScope() // Close Wrapper Scope ($collection, $index, $len)
 
 		return c.currentChunk, nil, nil
@@ -1307,24 +1448,28 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 
 		return c.currentChunk, nil, nil
 
-		// Pop Loop info
-		c.loops = c.loops[:len(c.loops)-1]
-
-		// 13. End Wrapper Scope (pops iterator vars)
-		c.endScope()
-
-		return c.currentChunk, nil, nil
+	case *ast.RangeExpression:
+		return nil, nil, fmt.Errorf("[line %d] a range (%s) is only allowed as the collection of a for loop", n.Token.Line, n.String())
 
 	case *ast.BreakStmt:
+		c.setLine(n.Token.Line)
 		if len(c.loops) == 0 {
 			return nil, nil, fmt.Errorf("break outside of loop")
 		}
 		loop := c.loops[len(c.loops)-1]
 
-		// Pop locals
-		toPop := len(c.locals) - loop.EnclosingLocals
-		for i := 0; i < toPop; i++ {
-			c.emitByte(byte(chunk.OP_POP))
+		// Leave the try blocks the loop body opened
+		for i := loop.EnclosingTries; i < c.tries; i++ {
+			c.emitByte(byte(chunk.OP_END_TRY))
+		}
+
+		// Pop locals, closing those that closures captured as endScope does
+		for i := len(c.locals) - 1; i >= loop.EnclosingLocals; i-- {
+			if c.locals[i].IsCaptured {
+				c.emitByte(byte(chunk.OP_CLOSE_UPVALUE))
+			} else {
+				c.emitByte(byte(chunk.OP_POP))
+			}
 		}
 
 		// Emit Jump
@@ -1332,25 +1477,63 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		loop.BreakJumps = append(loop.BreakJumps, jump)
 		return c.currentChunk, nil, nil
 
+	case *ast.TryStatement:
+		c.setLine(n.Token.Line)
+		if err := c.compileTry(n); err != nil {
+			return nil, nil, err
+		}
+		return c.currentChunk, nil, nil
+
+	case *ast.ThrowStmt:
+		c.setLine(n.Token.Line)
+		if err := c.compileThrow(n); err != nil {
+			return nil, nil, err
+		}
+		return c.currentChunk, nil, nil
+
+	case *ast.EmbedStmt:
+		c.setLine(n.Token.Line)
+		if err := c.compileEmbed(n); err != nil {
+			return nil, nil, err
+		}
+		return c.currentChunk, nil, nil
+
 	case *ast.UseStmt:
+		c.setLine(n.Token.Line)
+		if n.Plugin {
+			// use plugin "name" [as alias]: started here, as it has no
+			// top-level code to defer
+			pluginConst := c.makeConstant(value.NewString(n.Module))
+			bindConst := c.makeConstant(value.NewString(n.BindName()))
+			c.emitBytes(byte(chunk.OP_IMPORT_PLUGIN), byte(pluginConst))
+			c.emitByte(byte(bindConst))
+			c.emitBytes(byte(chunk.OP_SET_GLOBAL), byte(bindConst))
+			c.emitByte(byte(chunk.OP_POP))
+			return c.currentChunk, nil, nil
+		}
+		c.currentChunk.Imports = append(c.currentChunk.Imports, chunk.Import{Module: n.Module, Line: n.Token.Line})
 		// 1. Emit Module Name
 		nameConst := c.makeConstant(value.NewString(n.Module))
-		// 2. Emit Import (Loads module and pushes it to stack)
-		c.emitBytes(byte(chunk.OP_IMPORT), byte(nameConst))
 
-		// 3. Handle Result
+		// 2. Import it and handle the result. Selecting members needs the
+		// module right away; a plain use binds the module's name to a
+		// stand-in that loads it when the program first uses it.
 		if n.SelectAll {
 			// use pkg select *
+			c.emitBytes(byte(chunk.OP_IMPORT), byte(nameConst))
 			c.emitByte(byte(chunk.OP_IMPORT_FROM_ALL))
 		} else if len(n.Selectors) > 0 {
 			// use pkg select a, b
+			c.emitBytes(byte(chunk.OP_IMPORT), byte(nameConst))
 			for _, sel := range n.Selectors {
 				// DUP the module
 				c.emitByte(byte(chunk.OP_DUP))
 
 				// Get Property 'sel'
 				selConst := c.makeConstant(value.NewString(sel))
-				c.emitBytes(byte(chunk.OP_GET_PROPERTY), byte(selConst))
+				if err := c.emitProperty(chunk.OP_GET_PROPERTY, selConst); err != nil {
+					return nil, nil, err
+				}
 
 				// Set Global 'sel'
 				c.emitBytes(byte(chunk.OP_SET_GLOBAL), byte(selConst))
@@ -1360,26 +1543,15 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			c.emitByte(byte(chunk.OP_POP))
 		} else {
 			// use pkg.mod [as alias]
-			var bindName string
-			if n.Alias != "" {
-				bindName = n.Alias
-			} else {
-				// Default: last part of module path
-				parts := strings.Split(n.Module, ".")
-				if len(parts) > 0 {
-					bindName = parts[len(parts)-1]
-				} else {
-					bindName = n.Module
-				}
-			}
-
-			nameConst := c.makeConstant(value.NewString(bindName))
+			c.emitBytes(byte(chunk.OP_IMPORT_LAZY), byte(nameConst))
+			nameConst := c.makeConstant(value.NewString(n.BindName()))
 			c.emitBytes(byte(chunk.OP_SET_GLOBAL), byte(nameConst))
 			c.emitByte(byte(chunk.OP_POP)) // Pop module
 		}
 		return c.currentChunk, nil, nil
 
 	case *ast.ReturnStmt:
+		c.setLine(n.Token.Line)
 		if n.ReturnValue != nil {
 			_, valType, err := c.Compile(n.ReturnValue)
 			if err != nil {
@@ -1398,7 +1570,14 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 					c.emitByte(byte(chunk.OP_DEREF))
 					// Implicit Copy to ensure Value Semantics isolation on return
 					c.emitByte(byte(chunk.OP_COPY))
+					valType = valType.(*ast.RefType).ElementType
+				}
+				if isVoid(c.funcReturnType) {
+					c.typeError("'%s' returns void but returns a value", c.funcName)
+				} else if !c.areTypesCompatible(c.funcReturnType, valType) {
+					c.typeError("type mismatch in return value of '%s': expected %s, got %s", c.funcName, c.funcReturnType.String(), valType.String())
 				}
+				c.emitStrictCheck(c.funcReturnType, "return value of '"+c.funcName+"'")
 			}
 
 		} else {
@@ -1410,35 +1589,23 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 	case *ast.FunctionStatement:
 		c.setLine(n.Token.Line)
 
-		// Construct FunctionType for the global registry (Pre-register for recursion)
-		paramTypes := []ast.NoxyType{}
-		for _, p := range n.Parameters {
-			paramTypes = append(paramTypes, p.Type)
-		}
-		// Return type undefined for now (any/void), ast doesn't strictly enforce it yet
-		funcType := &ast.FunctionType{
-			Params: paramTypes,
-			Return: &ast.PrimitiveType{Name: "any"},
+		// A function nested in another is a local of the enclosing block,
+		// declared when the block starts (see BlockStatement); others are
+		// globals, registered first for recursion
+		slot := -1
+		if c.enclosing != nil {
+			slot, _ = c.resolveLocal(n.Name)
+		} else {
+			c.globals[n.Name] = functionType(n)
 		}
-		// Store in Global
-		c.globals[n.Name] = funcType
 
-		fnObj, fnCompiler, err := c.compileFunction(n.Name, n.Parameters, n.Body, n.ReturnType)
-		if err != nil {
+		if err := c.compileClosure(n.Name, n.Parameters, n.Body, n.ReturnType, nil); err != nil {
 			return nil, nil, err
 		}
-
-		funcIndex := c.makeConstant(fnObj)
-		c.emitBytes(byte(chunk.OP_CLOSURE), byte(funcIndex))
-
-		// Emit upvalue bytes
-		for _, up := range fnCompiler.upvalues {
-			isLocal := byte(0)
-			if up.IsLocal {
-				isLocal = 1
-			}
-			c.emitByte(isLocal)
-			c.emitByte(up.Index)
+		if slot != -1 {
+			c.emitBytes(byte(chunk.OP_SET_LOCAL), byte(slot))
+			c.emitByte(byte(chunk.OP_POP))
+			return c.currentChunk, nil, nil
 		}
 
 		nameConst := c.makeConstant(value.NewString(n.Name))
@@ -1455,26 +1622,10 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			fnName = "anonymous"
 		}
 
-		fnObj, fnCompiler, err := c.compileFunction(fnName, n.Parameters, n.Body, n.ReturnType) // Literal return type? n.ReturnType? FunctionLiteral needs return type field if typed. Assuming inferred/any if nil.
-		if err != nil {
-			return nil, nil, err
-		}
-		if err != nil {
+		if err := c.compileClosure(fnName, n.Parameters, n.Body, n.ReturnType, nil); err != nil {
 			return nil, nil, err
 		}
 
-		funcIndex := c.makeConstant(fnObj)
-		c.emitBytes(byte(chunk.OP_CLOSURE), byte(funcIndex))
-
-		for _, up := range fnCompiler.upvalues {
-			isLocal := byte(0)
-			if up.IsLocal {
-				isLocal = 1
-			}
-			c.emitByte(isLocal)
-			c.emitByte(up.Index)
-		}
-
 		// Construct FunctionType
 		paramTypes := []ast.NoxyType{}
 		for _, p := range n.Parameters {
@@ -1482,13 +1633,23 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 		}
 		funcType := &ast.FunctionType{
 			Params: paramTypes,
-			Return: &ast.PrimitiveType{Name: "any"},
+			Return: resultType(n.ReturnType),
 		}
 
 		return c.currentChunk, funcType, nil
 
 	case *ast.BlockStatement:
 		c.beginScope()
+		// Declaring all of a block's nested functions up front lets them
+		// call each other whatever order they are written in
+		if c.enclosing != nil {
+			for _, stmt := range n.Statements {
+				if fn, ok := stmt.(*ast.FunctionStatement); ok {
+					c.emitByte(byte(chunk.OP_NULL))
+					c.addLocal(fn.Name, functionType(fn))
+				}
+			}
+		}
 		for _, stmt := range n.Statements {
 			_, _, err := c.Compile(stmt)
 			if err != nil {
@@ -1541,7 +1702,7 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 				// Verify Type Match (only if not any)
 				if !isAnyChannel {
 					if !c.areTypesCompatible(chanType.ElementType, valType) {
-						return nil, nil, fmt.Errorf("[line %d] cannot send %s to %s", c.currentLine, valType.String(), chType.String())
+						c.typeError("cannot send %s to %s", valType.String(), chType.String())
 					}
 				}
 
@@ -1605,11 +1766,23 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 			}
 		}
 
-		// Normal Call
-		_, fnType, err := c.Compile(n.Function)
+		// Normal Call. A call of a member (p.dist(), strings.repeat(...))
+		// leaves the receiver where the callee would go, for OP_INVOKE.
+		var fnType ast.NoxyType
+		var callee string
+		var err error
+		member, isInvoke := n.Function.(*ast.MemberAccessExpression)
+		if isInvoke {
+			fnType, callee, err = c.compileReceiver(member)
+		} else {
+			_, fnType, err = c.Compile(n.Function)
+		}
 		if err != nil {
 			return nil, nil, err
 		}
+		if callee == "" {
+			callee = c.calleeName(n.Function)
+		}
 
 		funcType, isFunc := fnType.(*ast.FunctionType)
 
@@ -1675,6 +1848,9 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 					if _, ok := leftType.(*ast.RefType); ok {
 						c.emitByte(byte(chunk.OP_DEREF))
 					}
+					if _, err := c.fieldType(leftType, memberExp.Member); err != nil {
+						return nil, nil, err
+					}
 
 					nameConst := c.makeConstant(value.NewString(memberExp.Member))
 					c.emitBytes(byte(chunk.OP_REF_PROPERTY), byte(nameConst))
@@ -1711,15 +1887,34 @@ func (c *Compiler) Compile(node ast.Node) (*chunk.Chunk, ast.NoxyType, error) {
 				if err != nil {
 					return nil, nil, err
 				}
-				if _, ok := argType.(*ast.RefType); ok {
+				if ref, ok := argType.(*ast.RefType); ok {
 					c.emitByte(byte(chunk.OP_DEREF))
+					argType = ref.ElementType
+				}
+				// Parameters of types this compiler cannot check, such as
+				// structs of other modules, accept anything here as well
+				if isFunc && i < len(funcType.Params) && c.argCheckable(funcType.Params[i]) && !c.areTypesCompatible(funcType.Params[i], argType) {
+					c.typeError("type mismatch in argument %d of %s: expected %s, got %s", i+1, callee, funcType.Params[i].String(), argType.String())
 				}
 			}
 		}
+		if isFunc && len(n.Arguments) != len(funcType.Params) {
+			c.typeError("%s expects %d arguments but got %d (%s)", callee, len(funcType.Params), len(n.Arguments), funcType.String())
+		}
 
-		// Emit Call
-		c.emitBytes(byte(chunk.OP_CALL), byte(len(n.Arguments)))
-		return c.currentChunk, &ast.PrimitiveType{Name: "any"}, nil // Return type unknown for now
+		// Emit Call, attributed to the call site even if an argument spans lines
+		c.setLine(n.Token.Line)
+		if isInvoke {
+			nameConst := c.makeConstant(value.NewString(member.Member))
+			c.emitBytes(byte(chunk.OP_INVOKE), byte(nameConst))
+			c.emitByte(byte(len(n.Arguments)))
+		} else {
+			c.emitBytes(byte(chunk.OP_CALL), byte(len(n.Arguments)))
+		}
+		if isFunc {
+			return c.currentChunk, resultType(funcType.Return), nil
+		}
+		return c.currentChunk, &ast.PrimitiveType{Name: "any"}, nil
 
 	case nil:
 		// Skip
@@ -1775,6 +1970,19 @@ func (c *Compiler) makeConstant(v value.Value) int {
 	return i
 }
 
+// emitProperty emits op, OP_GET_PROPERTY or OP_SET_PROPERTY, with a new
+// inline cache for the field it finds. A chunk holds at most 65536
+// caches; past that it returns a compile error.
+func (c *Compiler) emitProperty(op chunk.OpCode, nameConst int) error {
+	cache := c.currentChunk.AddCache()
+	if cache > 65535 {
+		return fmt.Errorf("[line %d] too many property accesses in one function (at most 65536)", c.currentLine)
+	}
+	c.emitBytes(byte(op), byte(nameConst))
+	c.emitBytes(byte(cache>>8), byte(cache))
+	return nil
+}
+
 func (c *Compiler) emitConstant(v value.Value) {
 	index := c.makeConstant(v)
 	if index <= 255 {
@@ -1805,6 +2013,45 @@ func (c *Compiler) endScope() {
 	}
 }
 
+// functionType is the signature of a function statement, which calls
+// through its name are checked against.
+func functionType(n *ast.FunctionStatement) *ast.FunctionType {
+	paramTypes := []ast.NoxyType{}
+	for _, p := range n.Parameters {
+		paramTypes = append(paramTypes, p.Type)
+	}
+	return &ast.FunctionType{Params: paramTypes, Return: resultType(n.ReturnType)}
+}
+
+// calleeName describes the function called through fn for error
+// messages, in the words of the VM's runtime errors.
+func (c *Compiler) calleeName(fn ast.Expression) string {
+	switch fn := fn.(type) {
+	case *ast.Identifier:
+		if _, ok := c.structs[fn.Value]; ok {
+			return "struct " + fn.Value
+		}
+		return "function '" + fn.Value + "'"
+	case *ast.MemberAccessExpression:
+		return "function '" + fn.Member + "'"
+	}
+	return "function"
+}
+
+// resultType is the static type of a call to a function declared to return
+// t; a function without a declared return type may return anything.
+func resultType(t ast.NoxyType) ast.NoxyType {
+	if t == nil {
+		return &ast.PrimitiveType{Name: "any"}
+	}
+	return t
+}
+
+func isVoid(t ast.NoxyType) bool {
+	pt, ok := t.(*ast.PrimitiveType)
+	return ok && pt.Name == "void"
+}
+
 func (c *Compiler) addLocal(name string, t ast.NoxyType) {
 	c.locals = append(c.locals, Local{Name: name, Depth: c.scopeDepth, Type: t})
 }
@@ -1867,6 +2114,136 @@ func (c *Compiler) resolveGlobalType(name string) (ast.NoxyType, bool) {
 	return t, ok
 }
 
+// emitComparison emits the ordering comparison op of the two values on top
+// of the stack, using the int instructions when both are ints.
+func (c *Compiler) emitComparison(op string, isInt bool) {
+	switch op {
+	case ">":
+		if isInt {
+			c.emitByte(byte(chunk.OP_GREATER_INT))
+		} else {
+			c.emitByte(byte(chunk.OP_GREATER))
+		}
+	case "<":
+		if isInt {
+			c.emitByte(byte(chunk.OP_LESS_INT))
+		} else {
+			c.emitByte(byte(chunk.OP_LESS))
+		}
+	case ">=":
+		// For ints >= is NOT LESS; floats need their own op, as a
+		// comparison with NaN is false either way
+		if isInt {
+			c.emitByte(byte(chunk.OP_LESS_INT))
+			c.emitByte(byte(chunk.OP_NOT))
+		} else {
+			c.emitByte(byte(chunk.OP_GREATER_EQUAL))
+		}
+	case "<=":
+		if isInt {
+			c.emitByte(byte(chunk.OP_GREATER_INT))
+			c.emitByte(byte(chunk.OP_NOT))
+		} else {
+			c.emitByte(byte(chunk.OP_LESS_EQUAL))
+		}
+	}
+}
+
+// compileComparisonChain compiles a < b < c ..., stopping at the first
+// comparison that fails. Each operand is evaluated once and kept under the
+// result of the comparison before it, for the comparison after it:
+//
+//	<a> <b>
+//	OP_SWAP, OP_OVER     [b, a, b]
+//	<a < b>              [b, a<b]
+//	OP_JUMP_IF_FALSE fail
+//	OP_POP               [b]
+//	<c> <b < c>          [b<c]
+//	OP_JUMP end
+//	fail: OP_SWAP, OP_POP
+//	end:
+func (c *Compiler) compileComparisonChain(n *ast.ComparisonChain) (*chunk.Chunk, ast.NoxyType, error) {
+	operand := func(e ast.Expression) (ast.NoxyType, error) {
+		_, t, err := c.Compile(e)
+		if err != nil {
+			return nil, err
+		}
+		if ref, ok := t.(*ast.RefType); ok {
+			c.emitByte(byte(chunk.OP_DEREF))
+			t = ref.ElementType
+		}
+		return t, nil
+	}
+	isInt := func(a, b ast.NoxyType) bool {
+		return a != nil && b != nil && a.String() == "int" && b.String() == "int"
+	}
+
+	leftType, err := operand(n.Operands[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	var fails []int
+	last := len(n.Operators) - 1
+	for i, op := range n.Operators {
+		rightType, err := operand(n.Operands[i+1])
+		if err != nil {
+			return nil, nil, err
+		}
+		c.setLine(n.Token.Line)
+		if i < last {
+			c.emitByte(byte(chunk.OP_SWAP))
+			c.emitByte(byte(chunk.OP_OVER))
+		}
+		c.emitComparison(op, isInt(leftType, rightType))
+		if i < last {
+			fails = append(fails, c.emitJump(chunk.OP_JUMP_IF_FALSE))
+			c.emitByte(byte(chunk.OP_POP))
+		}
+		leftType = rightType
+	}
+	end := c.emitJump(chunk.OP_JUMP)
+	for _, fail := range fails {
+		c.patchJump(fail)
+	}
+	c.emitByte(byte(chunk.OP_SWAP))
+	c.emitByte(byte(chunk.OP_POP))
+	c.patchJump(end)
+	return c.currentChunk, &ast.PrimitiveType{Name: "bool"}, nil
+}
+
+// compileCoalesce compiles left ?? right, which is left unless it is null
+// and right otherwise, evaluated only then:
+//
+//	<left>
+//	OP_JUMP_IF_NOT_NULL end
+//	OP_POP
+//	<right>
+//	end:
+//
+// Both sides must have compatible types. The result has the type of left,
+// or of right when the type of left is unknown, as for null.
+func (c *Compiler) compileCoalesce(n *ast.InfixExpression) (*chunk.Chunk, ast.NoxyType, error) {
+	_, leftType, err := c.Compile(n.Left)
+	if err != nil {
+		return nil, nil, err
+	}
+	endJump := c.emitJump(chunk.OP_JUMP_IF_NOT_NULL)
+	c.emitByte(byte(chunk.OP_POP))
+	_, rightType, err := c.Compile(n.Right)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.patchJump(endJump)
+	c.setLine(n.Token.Line)
+	if !c.areTypesCompatible(leftType, rightType) {
+		return nil, nil, fmt.Errorf("[line %d] ?? operands must have the same type, got %s and %s", c.currentLine, leftType, rightType)
+	}
+	if leftType == nil {
+		return c.currentChunk, rightType, nil
+	}
+	return c.currentChunk, leftType, nil
+}
+
 func (c *Compiler) areTypesCompatible(expected, actual ast.NoxyType) bool {
 	if expected == nil || actual == nil {
 		return true // Allow lenient check for now/unknowns
@@ -1893,6 +2270,23 @@ func (c *Compiler) areTypesCompatible(expected, actual ast.NoxyType) bool {
 		}
 	}
 
+	// A function fits a signature when it accepts the arguments the
+	// signature promises and returns what it expects; a signature that
+	// returns void ignores the result
+	if expFn, ok := expected.(*ast.FunctionType); ok {
+		if actFn, ok := actual.(*ast.FunctionType); ok {
+			if len(expFn.Params) != len(actFn.Params) {
+				return false
+			}
+			for i := range expFn.Params {
+				if !c.areTypesCompatible(actFn.Params[i], expFn.Params[i]) {
+					return false
+				}
+			}
+			return isVoid(expFn.Return) || c.areTypesCompatible(expFn.Return, actFn.Return)
+		}
+	}
+
 	if expArr, ok := expected.(*ast.ArrayType); ok {
 		if actArr, ok := actual.(*ast.ArrayType); ok {
 			// If expected element is 'any', accept any actual element type
@@ -1916,6 +2310,148 @@ func (c *Compiler) areTypesCompatible(expected, actual ast.NoxyType) bool {
 	return false
 }
 
+// fieldType returns the declared type of field member on a value of static
+// type t (or a ref to it). It is nil when t is not a struct this compiler
+// knows, and an error when t is a known struct without that field.
+// compileReceiver compiles the left side of a member call and returns the
+// type of the called member when it is known: a method, or a field holding
+// a function. For a method it also returns the method's name as the VM
+// reports it.
+func (c *Compiler) compileReceiver(n *ast.MemberAccessExpression) (ast.NoxyType, string, error) {
+	_, leftType, err := c.Compile(n.Left)
+	if err != nil {
+		return nil, "", err
+	}
+	if ref, ok := leftType.(*ast.RefType); ok {
+		c.emitByte(byte(chunk.OP_DEREF))
+		leftType = ref.ElementType
+	}
+	if m := c.method(leftType, n.Member); m != nil {
+		// The receiver is not an argument
+		fnType := functionType(&ast.FunctionStatement{Parameters: m.Parameters[1:], ReturnType: m.ReturnType})
+		return fnType, "function '" + leftType.String() + "." + n.Member + "'", nil
+	}
+	fnType, err := c.fieldType(leftType, n.Member)
+	return fnType, "", err
+}
+
+// method finds the method called member of struct type t.
+func (c *Compiler) method(t ast.NoxyType, member string) *ast.FunctionStatement {
+	prim, ok := t.(*ast.PrimitiveType)
+	if !ok {
+		return nil
+	}
+	structDef, exists := c.structs[prim.Name]
+	if !exists {
+		return nil
+	}
+	for _, m := range structDef.Methods {
+		if m.Name == member {
+			return m
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) fieldType(t ast.NoxyType, member string) (ast.NoxyType, error) {
+	if ref, ok := t.(*ast.RefType); ok {
+		t = ref.ElementType
+	}
+	prim, ok := t.(*ast.PrimitiveType)
+	if !ok {
+		return nil, nil
+	}
+	structDef, exists := c.structs[prim.Name]
+	if !exists {
+		if prim.Name == "error" {
+			return c.errorMemberType(member)
+		}
+		return nil, nil
+	}
+	for _, f := range structDef.FieldsList {
+		if f.Name == member {
+			return f.Type, nil
+		}
+	}
+	if c.method(prim, member) != nil {
+		return nil, fmt.Errorf("[line %d] method '%s' of struct %s can only be called, as in x.%s()", c.currentLine, member, prim.Name, member)
+	}
+	what := "field"
+	if len(structDef.Methods) > 0 {
+		what = "field or method"
+	}
+	return nil, fmt.Errorf("[line %d] struct %s has no %s '%s'", c.currentLine, prim.Name, what, member)
+}
+
+// elemKind is how arrays of static type t store their elements: unboxed
+// for int[] and float[], as values for anything else.
+func elemKind(t ast.NoxyType) value.ElemKind {
+	arr, ok := t.(*ast.ArrayType)
+	if !ok || arr.ElementType == nil {
+		return value.ElemValues
+	}
+	switch arr.ElementType.String() {
+	case "int":
+		return value.ElemInts
+	case "float":
+		return value.ElemFloats
+	}
+	return value.ElemValues
+}
+
+// emitSpecialize makes the array on top of the stack, about to be stored
+// in a variable declared as t, switch to unboxed storage when t is int[]
+// or float[]. The VM leaves it boxed when an element does not fit, such
+// as a string that came through any.
+func (c *Compiler) emitSpecialize(t ast.NoxyType) {
+	if kind := elemKind(t); kind != value.ElemValues {
+		c.emitBytes(byte(chunk.OP_SPECIALIZE), byte(kind))
+	}
+}
+
+// emitStrictCheck makes the VM verify, in strict mode, that the value on
+// top of the stack has the declared type. what names the checked slot in
+// the error message.
+func (c *Compiler) emitStrictCheck(declared ast.NoxyType, what string) {
+	if !c.Strict || !c.strictCheckable(declared) {
+		return
+	}
+	typeConstant := c.makeConstant(value.NewString(declared.String()))
+	whatConstant := c.makeConstant(value.NewString(what))
+	c.emitByte(byte(chunk.OP_CHECK_TYPE))
+	c.emitBytes(byte(typeConstant>>8), byte(typeConstant))
+	c.emitBytes(byte(whatConstant>>8), byte(whatConstant))
+}
+
+// strictCheckable reports whether the VM knows how to check values against
+// t: primitives, struct names, and arrays and maps of those (or of any).
+// References, channels and function types are left to the compiler.
+func (c *Compiler) strictCheckable(t ast.NoxyType) bool {
+	switch t := t.(type) {
+	case *ast.PrimitiveType:
+		switch t.Name {
+		case "int", "float", "string", "bool", "bytes":
+			return true
+		}
+		_, isStruct := c.structs[t.Name]
+		return isStruct
+	case *ast.ArrayType:
+		return t.ElementType == nil || isAny(t.ElementType) || c.strictCheckable(t.ElementType)
+	case *ast.MapType:
+		return t.ValueType == nil || isAny(t.ValueType) || c.strictCheckable(t.ValueType)
+	}
+	return false
+}
+
+// argCheckable reports whether calls check the arguments given for a
+// parameter of type t: the types strict mode checks, and signatures.
+func (c *Compiler) argCheckable(t ast.NoxyType) bool {
+	if _, ok := t.(*ast.FunctionType); ok {
+		return true
+	}
+	return c.strictCheckable(t)
+}
+
 func isAny(t ast.NoxyType) bool {
 	if t == nil {
 		return false
@@ -1934,22 +2470,22 @@ func (c *Compiler) resolveUpvalue(name string) int {
 	}
 
 	// 1. Check immediate parent's locals
-	local, _ := c.enclosing.resolveLocal(name)
+	local, t := c.enclosing.resolveLocal(name)
 	if local != -1 {
 		c.enclosing.locals[local].IsCaptured = true // Mark as captured!
-		return c.addUpvalue(uint8(local), true)
+		return c.addUpvalue(uint8(local), true, t)
 	}
 
 	// 2. Check immediate parent's upvalues
 	upvalue := c.enclosing.resolveUpvalue(name)
 	if upvalue != -1 {
-		return c.addUpvalue(uint8(upvalue), false)
+		return c.addUpvalue(uint8(upvalue), false, c.enclosing.upvalues[upvalue].Type)
 	}
 
 	return -1
 }
 
-func (c *Compiler) addUpvalue(index uint8, isLocal bool) int {
+func (c *Compiler) addUpvalue(index uint8, isLocal bool, t ast.NoxyType) int {
 	// Check for existing upvalue
 	for i, u := range c.upvalues {
 		if u.Index == index && u.IsLocal == isLocal {
@@ -1961,15 +2497,46 @@ func (c *Compiler) addUpvalue(index uint8, isLocal bool) int {
 		// Error: too many upvalues
 	}
 
-	c.upvalues = append(c.upvalues, Upvalue{Index: index, IsLocal: isLocal})
+	c.upvalues = append(c.upvalues, Upvalue{Index: index, IsLocal: isLocal, Type: t})
 	return len(c.upvalues) - 1
 }
 
-func (c *Compiler) compileFunction(name string, params []*ast.Parameter, body *ast.BlockStatement, returnType ast.NoxyType) (value.Value, *Compiler, error) {
+// compileClosure compiles a function and emits the OP_CLOSURE that builds
+// it, capturing its upvalues. See compileFunction for receiver.
+func (c *Compiler) compileClosure(name string, params []*ast.Parameter, body *ast.BlockStatement, returnType, receiver ast.NoxyType) error {
+	fnObj, fnCompiler, err := c.compileFunction(name, params, body, returnType, receiver)
+	if err != nil {
+		return err
+	}
+
+	funcIndex := c.makeConstant(fnObj)
+	c.emitBytes(byte(chunk.OP_CLOSURE), byte(funcIndex))
+
+	// Emit upvalue bytes
+	for _, up := range fnCompiler.upvalues {
+		isLocal := byte(0)
+		if up.IsLocal {
+			isLocal = 1
+		}
+		c.emitByte(isLocal)
+		c.emitByte(up.Index)
+	}
+	return nil
+}
+
+// compileFunction compiles a function body into a function object. Slot 0
+// holds the function itself, or for a method (receiver not nil) the
+// instance it was invoked on, named self.
+func (c *Compiler) compileFunction(name string, params []*ast.Parameter, body *ast.BlockStatement, returnType, receiver ast.NoxyType) (value.Value, *Compiler, error) {
 	fnCompiler := NewChild(c)
-	fnCompiler.scopeDepth = 1    // Inside function body
-	fnCompiler.addLocal("", nil) // Reserve slot 0 for function instance
+	fnCompiler.scopeDepth = 1 // Inside function body
+	if receiver != nil {
+		fnCompiler.addLocal("self", receiver)
+	} else {
+		fnCompiler.addLocal("", nil) // Reserve slot 0 for function instance
+	}
 	fnCompiler.funcReturnType = returnType
+	fnCompiler.funcName = name
 
 	paramsInfo := []value.ParamInfo{}
 	for _, param := range params {
@@ -1981,6 +2548,18 @@ func (c *Compiler) compileFunction(name string, params []*ast.Parameter, body *a
 		}
 		paramsInfo = append(paramsInfo, value.ParamInfo{IsRef: isRef})
 	}
+	for i, param := range params {
+		if fnCompiler.Strict && fnCompiler.strictCheckable(param.Type) {
+			fnCompiler.emitBytes(byte(chunk.OP_GET_LOCAL), byte(i+1))
+			fnCompiler.emitStrictCheck(param.Type, "parameter '"+param.Name+"' of '"+name+"'")
+			fnCompiler.emitByte(byte(chunk.OP_POP))
+		}
+		if elemKind(param.Type) != value.ElemValues {
+			fnCompiler.emitBytes(byte(chunk.OP_GET_LOCAL), byte(i+1))
+			fnCompiler.emitSpecialize(param.Type)
+			fnCompiler.emitByte(byte(chunk.OP_POP))
+		}
+	}
 
 	_, _, err := fnCompiler.Compile(body)
 	if err != nil {
@@ -1995,3 +2574,75 @@ func (c *Compiler) compileFunction(name string, params []*ast.Parameter, body *a
 
 	return fnObj, fnCompiler, nil
 }
+
+// compileRangeFor compiles `for i in start..end do`, counting i from start
+// up to end - 1 without building an array. Both bounds are evaluated
+// once, before the first iteration.
+func (c *Compiler) compileRangeFor(n *ast.ForStatement, rng *ast.RangeExpression) error {
+	c.beginScope()
+
+	// Typed int bounds use the int opcodes; bounds of unknown type
+	// (any) fall back to the generic ones.
+	intOps := true
+	var varType ast.NoxyType = &ast.PrimitiveType{Name: "int"}
+	for _, bound := range []struct {
+		expr  ast.Expression
+		local string
+	}{{rng.Start, " $index"}, {rng.End, " $end"}} {
+		_, t, err := c.Compile(bound.expr)
+		if err != nil {
+			return err
+		}
+		if t == nil || t.String() == "any" {
+			intOps = false
+			varType = nil
+		} else if t.String() != "int" {
+			return fmt.Errorf("[line %d] range bounds must be int, got %s in %s", c.currentLine, t.String(), rng.String())
+		}
+		c.addLocal(bound.local, t)
+	}
+	endSlot := len(c.locals) - 1
+	indexSlot := endSlot - 1
+
+	lessOp, addOp := chunk.OP_LESS, chunk.OP_ADD
+	if intOps {
+		lessOp, addOp = chunk.OP_LESS_INT, chunk.OP_ADD_INT
+	}
+
+	loopStart := len(c.currentChunk.Code)
+	loop := &Loop{EnclosingLocals: len(c.locals), EnclosingTries: c.tries, BreakJumps: []int{}}
+	c.loops = append(c.loops, loop)
+
+	c.emitBytes(byte(chunk.OP_GET_LOCAL), byte(indexSlot))
+	c.emitBytes(byte(chunk.OP_GET_LOCAL), byte(endSlot))
+	c.emitByte(byte(lessOp))
+	jumpToExit := c.emitJump(chunk.OP_JUMP_IF_FALSE)
+	c.emitByte(byte(chunk.OP_POP))
+
+	// The loop variable is a copy, so assigning to it in the body does
+	// not change the iteration
+	c.beginScope()
+	c.emitBytes(byte(chunk.OP_GET_LOCAL), byte(indexSlot))
+	c.addLocal(n.Identifier, varType)
+	if _, _, err := c.Compile(n.Body); err != nil {
+		return err
+	}
+	c.endScope()
+
+	c.emitBytes(byte(chunk.OP_GET_LOCAL), byte(indexSlot))
+	c.emitConstant(value.NewInt(1))
+	c.emitByte(byte(addOp))
+	c.emitBytes(byte(chunk.OP_SET_LOCAL), byte(indexSlot))
+	c.emitByte(byte(chunk.OP_POP))
+	c.emitLoop(loopStart)
+
+	c.patchJump(jumpToExit)
+	c.emitByte(byte(chunk.OP_POP))
+	for _, jump := range loop.BreakJumps {
+		c.patchJump(jump)
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+
+	c.endScope()
+	return nil
+}
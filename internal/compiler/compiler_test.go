@@ -2,8 +2,10 @@ package compiler
 
 import (
 	"noxy-vm/internal/ast"
+	"noxy-vm/internal/chunk"
 	"noxy-vm/internal/lexer"
 	"noxy-vm/internal/parser"
+	"strings"
 	"testing"
 )
 
@@ -45,3 +47,106 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 		}
 	}
 }
+
+func TestUnknownStructField(t *testing.T) {
+	decl := "struct P\n    x: int\n    next: ref P\nend\nlet p: P = P(1, null)\n"
+	tests := map[string]string{
+		"let y: int = p.fielx":          "[line 6] struct P has no field 'fielx'",
+		"p.fielx = 2":                   "[line 6] struct P has no field 'fielx'",
+		"let r: ref int = ref p.xx":     "[line 6] struct P has no field 'xx'",
+		"let q: ref P = ref p\nq.y = 1": "[line 7] struct P has no field 'y'",
+	}
+	for src, want := range tests {
+		_, _, err := New().Compile(parse(decl + src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+
+	runCompilerTests(t, []compilerTestCase{
+		{decl + "p.x = p.x + 1"},
+		{decl + "let a: any = p\nlet y: any = a.anything"},
+		// A struct value assigned to a ref field through a ref parameter
+		{decl + "func push(node: ref P, x: int)\n    node.next = P(x, null)\nend"},
+	})
+}
+
+func TestTypeErrors(t *testing.T) {
+	decl := "struct P\n    x: int\n    func scaled(self, k: int) -> int\n        return self.x * k\n    end\nend\n" +
+		"func half(n: float) -> float\n    return n / 2.0\nend\nfunc log(msg: string) -> void\nend\n"
+	tests := map[string]string{
+		"let s: string = half(1.0)":                    "[line 12] type mismatch in 's' declaration: expected string, got float",
+		"let f: float = half(1)":                       "[line 12] type mismatch in argument 1 of function 'half': expected float, got int",
+		"let f: float = half(1.0, 2.0)":                "[line 12] function 'half' expects 1 arguments but got 2 (func(float) -> float)",
+		"let p: P = P(\"one\")":                        "[line 12] type mismatch in argument 1 of struct P: expected int, got string",
+		"let p: P = P(1)\nlet n: int = p.scaled()":     "[line 13] function 'P.scaled' expects 1 arguments but got 0 (func(int) -> int)",
+		"let p: P = P(1)\nlet s: string = p.scaled(2)": "[line 13] type mismatch in 's' declaration: expected string, got int",
+		"let n: int = log(\"x\")":                      "[line 12] type mismatch in 'n' declaration: expected int, got void",
+		"func g() -> int\n    return \"x\"\nend":       "[line 13] type mismatch in return value of 'g': expected int, got string",
+		"func g() -> void\n    return 1\nend":          "[line 13] 'g' returns void but returns a value",
+		// Signatures
+		"let g: func(int) -> float = half":                                          "[line 12] type mismatch in 'g' declaration: expected func(int) -> float, got func(float) -> float",
+		"let g: func(float) -> string = half":                                       "[line 12] type mismatch in 'g' declaration: expected func(float) -> string, got func(float) -> float",
+		"let g: func(float, float) -> float = half":                                 "[line 12] type mismatch in 'g' declaration: expected func(float, float) -> float, got func(float) -> float",
+		"let g: func(float) -> float = half\nlet s: string = g(1.0)":                "[line 13] type mismatch in 's' declaration: expected string, got float",
+		"let g: func(float) -> float = half\ng(\"x\")":                              "[line 13] type mismatch in argument 1 of function 'g': expected float, got string",
+		"let g: func(float) -> float = half\ng()":                                   "[line 13] function 'g' expects 1 arguments but got 0 (func(float) -> float)",
+		"func apply(f: func(int) -> int) -> int\n    return f(1)\nend\napply(half)": "[line 15] type mismatch in argument 1 of function 'apply': expected func(int) -> int, got func(float) -> float",
+		"struct B\n    on: func(string) -> void\nend\nlet b: B = B(half)":           "[line 15] type mismatch in argument 1 of struct B: expected func(string) -> void, got func(float) -> float",
+		// Errors
+		"throw 5": "[line 12] throw expects an error or a string, got int",
+		"try\n    log(\"x\")\ncatch e\n    let n: int = e.message\nend": "[line 15] type mismatch in 'n' declaration: expected int, got string",
+		"try\n    log(\"x\")\ncatch e\n    print(e.line)\nend":          "[line 15] error has no member 'line' (it has message and code)",
+		// Null-coalescing
+		"let m: map[string, int] = {}\nlet s: string = m[\"a\"] ?? \"x\"": "[line 13] ?? operands must have the same type, got int and string",
+		"let m: map[string, int] = {}\nlet s: string = m[\"a\"] ?? 0":     "[line 13] type mismatch in 's' declaration: expected string, got int",
+	}
+	for src, want := range tests {
+		_, _, err := New().Compile(parse(decl + src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+
+	// Every type error is reported, in order, not only the first
+	src := "let a: int = \"x\"\nlet b: string = 1\nfunc f(n: int) -> int\n    return n\nend\nlet c: int = f(true)\nlet d: int = missing.field"
+	_, _, err := New().Compile(parse(src))
+	want := "[line 1] type mismatch in 'a' declaration: expected int, got string\n" +
+		"[line 2] type mismatch in 'b' declaration: expected string, got int\n" +
+		"[line 6] type mismatch in argument 1 of function 'f': expected int, got bool"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want\n%s", err, want)
+	}
+
+	runCompilerTests(t, []compilerTestCase{
+		// Values of unknown type, null for a struct, and parameters of
+		// types the compiler does not know are checked at runtime
+		{decl + "let a: any = \"x\"\nlet f: float = half(a)"},
+		{decl + "struct Node\n    next: Node\nend\nlet n: Node = Node(null)"},
+		{"struct Box\n    items: list\nend\nlet b: Box = Box([1, 2])"},
+		{decl + "log(\"x\")\nlet p: P = P(2)\nlet n: int = p.scaled(3) + P(1).x"},
+		{"func inc(n: ref int) -> void\n    *n = *n + 1\nend\nlet v: int = 1\ninc(ref v)"},
+		{"func g() -> void\n    return\nend\ng()"},
+		// A signature accepts functions that take what it promises and
+		// return what it expects, and func or any values; one returning
+		// void ignores the result
+		{decl + "let g: func(float) -> float = half\nlet h: func(float) -> float = func(x: float) -> float\n    return x\nend\nlet f: float = g(h(1.0))"},
+		{decl + "let g: func(string) -> void = log\nlet h: func(int) -> void = P\nlet k: func(any) -> any = half"},
+		{decl + "let f: func = half\nlet a: any = half\nlet g: func(float) -> float = f\nlet h: func(float) -> float = a\nlet n: func() -> int = null"},
+		{"struct B\n    on: func(string) -> void\nend\nfunc shout(s: string) -> bool\n    return true\nend\nlet b: B = B(shout)\nb.on(\"x\")"},
+	})
+}
+
+func TestTooManyPropertyAccesses(t *testing.T) {
+	// Name constants usually run out first, so fill the caches directly
+	c := New()
+	for i := 0; i < 65536; i++ {
+		c.currentChunk.AddCache()
+	}
+	c.setLine(7)
+	err := c.emitProperty(chunk.OP_GET_PROPERTY, 0)
+	want := "[line 7] too many property accesses in one function (at most 65536)"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
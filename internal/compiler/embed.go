@@ -0,0 +1,80 @@
+package compiler
+
+import (
+	"fmt"
+	"io/fs"
+	"noxy-vm/internal/ast"
+	"noxy-vm/internal/chunk"
+	"noxy-vm/internal/value"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// embedNative is the io native that the code emitted for an embed
+// statement calls once per file, at the point the statement runs.
+const embedNative = "io_embed_add"
+
+// Embedded lists the files bundled by embed statements, relative to the
+// directory of the compiled file. A chunk that embeds files depends on
+// more than its source, so callers caching compiled chunks by source
+// should skip those.
+func (c *Compiler) Embedded() []string {
+	return c.embedded
+}
+
+// compileEmbed reads every file matching the statement's glob pattern,
+// resolved against the directory of the file being compiled, and emits
+// io_embed_add(name, contents) for each. Matched directories are embedded
+// recursively. Names use forward slashes on every platform.
+func (c *Compiler) compileEmbed(n *ast.EmbedStmt) error {
+	if c.scopeDepth > 0 || c.enclosing != nil {
+		return fmt.Errorf("[line %d] embed is only allowed at the top level of a file", c.currentLine)
+	}
+	baseDir := filepath.Dir(c.FileName)
+	matches, err := filepath.Glob(filepath.Join(baseDir, filepath.FromSlash(n.Pattern)))
+	if err != nil {
+		return fmt.Errorf("[line %d] invalid embed pattern %q: %v", c.currentLine, n.Pattern, err)
+	}
+
+	var files []string
+	for _, m := range matches {
+		err := filepath.WalkDir(m, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("[line %d] embed %q: %v", c.currentLine, n.Pattern, err)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("[line %d] embed pattern %q matches no files", c.currentLine, n.Pattern)
+	}
+	sort.Strings(files)
+
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("[line %d] embed %q: %v", c.currentLine, n.Pattern, err)
+		}
+		name, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			name = path
+		}
+		name = filepath.ToSlash(name)
+		c.embedded = append(c.embedded, name)
+
+		fnConst := c.makeConstant(value.NewString(embedNative))
+		c.emitBytes(byte(chunk.OP_GET_GLOBAL), byte(fnConst))
+		c.emitConstant(value.NewString(name))
+		c.emitConstant(value.NewBytes(string(data)))
+		c.emitBytes(byte(chunk.OP_CALL), 2)
+		c.emitByte(byte(chunk.OP_POP))
+	}
+	return nil
+}
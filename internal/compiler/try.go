@@ -0,0 +1,81 @@
+package compiler
+
+import (
+	"fmt"
+	"noxy-vm/internal/ast"
+	"noxy-vm/internal/chunk"
+)
+
+// errorType is the type of error values and of a catch variable.
+var errorType = &ast.PrimitiveType{Name: "error"}
+
+// compileTry emits
+//
+//	OP_TRY catch
+//	<body>
+//	OP_END_TRY
+//	OP_JUMP end
+//	catch: <handler>
+//	end:
+//
+// When the body raises a runtime error, the VM drops everything the body
+// left on the stack and jumps to catch with the error pushed, where it
+// becomes the first local of the handler's scope.
+func (c *Compiler) compileTry(n *ast.TryStatement) error {
+	toCatch := c.emitJump(chunk.OP_TRY)
+	c.tries++
+	if _, _, err := c.Compile(n.Body); err != nil {
+		return err
+	}
+	c.tries--
+	c.emitByte(byte(chunk.OP_END_TRY))
+	toEnd := c.emitJump(chunk.OP_JUMP)
+
+	c.patchJump(toCatch)
+	c.beginScope()
+	name := n.ErrorName
+	if name == "" {
+		name = "$error"
+	}
+	c.addLocal(name, errorType)
+	if _, _, err := c.Compile(n.Handler); err != nil {
+		return err
+	}
+	c.endScope()
+
+	c.patchJump(toEnd)
+	return nil
+}
+
+// compileThrow emits the value of a throw statement and OP_THROW, which
+// raises it.
+func (c *Compiler) compileThrow(n *ast.ThrowStmt) error {
+	_, t, err := c.Compile(n.Value)
+	if err != nil {
+		return err
+	}
+	if ref, ok := t.(*ast.RefType); ok {
+		c.emitByte(byte(chunk.OP_DEREF))
+		t = ref.ElementType
+	}
+	if t != nil && !isAny(t) {
+		switch t.String() {
+		case "error", "string":
+		default:
+			c.typeError("throw expects an error or a string, got %s", t.String())
+		}
+	}
+	c.emitByte(byte(chunk.OP_THROW))
+	return nil
+}
+
+// errorMemberType is the type of a member of an error value.
+func (c *Compiler) errorMemberType(member string) (ast.NoxyType, error) {
+	switch member {
+	case "message":
+		return &ast.PrimitiveType{Name: "string"}, nil
+	case "code":
+		return &ast.PrimitiveType{Name: "int"}, nil
+	}
+	return nil, fmt.Errorf("[line %d] error has no member '%s' (it has message and code)", c.currentLine, member)
+}
@@ -0,0 +1,206 @@
+// Package cron parses cron expressions and finds the times they match.
+//
+// An expression has five fields, minute hour day-of-month month
+// day-of-week, or six with a leading seconds field. Each field is *, a
+// number, a range a-b, a list a,b,c, or any of these followed by a step
+// /n. Months and weekdays may also be written as names (jan, mon), and
+// weekday 7 is Sunday like 0. As in Vixie cron, when both day-of-month
+// and day-of-week are restricted a day matches if either does.
+//
+// The macros @yearly (@annually), @monthly, @weekly, @daily (@midnight)
+// and @hourly stand for the usual five-field expressions.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type Schedule struct {
+	second, minute, hour, dom, month, dow uint64
+	// domStar and dowStar record an unrestricted day field, which the
+	// either-day rule ignores.
+	domStar, dowStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondField = field{name: "second", min: 0, max: 59}
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	text := strings.TrimSpace(expr)
+	if strings.HasPrefix(text, "@") {
+		m, ok := macros[strings.ToLower(text)]
+		if !ok {
+			return nil, fmt.Errorf("unknown macro %q", text)
+		}
+		text = m
+	}
+	parts := strings.Fields(text)
+	switch len(parts) {
+	case 5:
+		parts = append([]string{"0"}, parts...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("expected 5 or 6 fields in %q, got %d", expr, len(parts))
+	}
+
+	s := &Schedule{}
+	var err error
+	fields := []struct {
+		f    field
+		bits *uint64
+	}{
+		{secondField, &s.second}, {minuteField, &s.minute}, {hourField, &s.hour},
+		{domField, &s.dom}, {monthField, &s.month}, {dowField, &s.dow},
+	}
+	for i, fd := range fields {
+		if *fd.bits, err = parseField(parts[i], fd.f); err != nil {
+			return nil, err
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = parts[3] == "*" || parts[3] == "?"
+	s.dowStar = parts[5] == "*" || parts[5] == "?"
+	return s, nil
+}
+
+// parseField parses one comma-separated field into a bit set.
+func parseField(text string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(text, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepText, f.name)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(a); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rng, f.name)
+			}
+		default:
+			n, err := f.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			lo = n
+			if hasStep {
+				// 5/15 means from 5 to the end in steps of 15
+				hi = f.max
+			} else {
+				hi = n
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or name within the bounds of f.
+func (f field) value(text string) (int, error) {
+	if n, ok := f.names[strings.ToLower(text)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s field", text, f.name)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", f.name, n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// Next returns the first time after t that s matches, in t's location,
+// or the zero time if there is none within five years (such as for
+// February 30th).
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if s.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
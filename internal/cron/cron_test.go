@@ -0,0 +1,61 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// Friday
+	from := time.Date(2026, 3, 13, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * * *", "2026-03-13 10:08:00"},
+		{"*/5 * * * *", "2026-03-13 10:10:00"},
+		{"*/10 * * * * *", "2026-03-13 10:07:40"},
+		{"0 9 * * *", "2026-03-14 09:00:00"},
+		{"30 8-17/3 * * *", "2026-03-13 11:30:00"},
+		{"0 0 * * mon-wed", "2026-03-16 00:00:00"},
+		{"0 0 * * 7", "2026-03-15 00:00:00"},
+		{"0 12 1,15 * *", "2026-03-15 12:00:00"},
+		// Either day field matches when both are restricted
+		{"0 0 1 * fri", "2026-03-20 00:00:00"},
+		{"0 0 29 feb *", "2028-02-29 00:00:00"},
+		{"0 0 1 JAN ?", "2027-01-01 00:00:00"},
+		{"@hourly", "2026-03-13 11:00:00"},
+		{"@weekly", "2026-03-15 00:00:00"},
+		{"@yearly", "2027-01-01 00:00:00"},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from).Format("2006-01-02 15:04:05"); got != tt.want {
+			t.Errorf("Next(%q) = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+
+	s, _ := Parse("0 0 30 feb *")
+	if got := s.Next(from); !got.IsZero() {
+		t.Errorf("February 30th matched %s", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for expr, want := range map[string]string{
+		"* * * *":       `expected 5 or 6 fields in "* * * *", got 4`,
+		"60 * * * *":    "minute 60 out of range 0-59",
+		"* 5-2 * * *":   `invalid range "5-2" in hour field`,
+		"*/0 * * * *":   `invalid step "0" in minute field`,
+		"* * * foo * *": `invalid value "foo" in day of month field`,
+		"@often":        `unknown macro "@often"`,
+	} {
+		if _, err := Parse(expr); err == nil || err.Error() != want {
+			t.Errorf("Parse(%q) = %v, want %q", expr, err, want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package format implements `noxy fmt`: it lays out Noxy source in the
+// standard style without changing what it means.
+//
+// Formatting only touches the space around lines: blocks are indented by
+// four spaces per level (the body of a when case one level deeper than its
+// case), lines continuing an open bracket by one more level per bracket,
+// trailing whitespace is removed, runs of blank lines are kept to one and
+// the file ends with a single newline. Comments move with the lines
+// around them, and the lines of a multi-line string are left as written.
+package format
+
+import (
+	"errors"
+	"noxy-vm/internal/lexer"
+	"noxy-vm/internal/parser"
+	"noxy-vm/internal/token"
+	"strings"
+)
+
+const indent = "    "
+
+// Source returns src formatted. It formats nothing and reports the syntax
+// errors of src when it does not parse.
+func Source(src string) (string, error) {
+	p := parser.New(lexer.New(src))
+	p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return "", errors.New(strings.Join(errs, "\n"))
+	}
+
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	byLine := make([][]token.Token, len(lines)+1)
+	verbatim := make([]bool, len(lines)+1)
+	l := lexer.New(src)
+	var prev token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Line >= 1 && tok.Line <= len(lines) && tok.Type != token.NEWLINE {
+			byLine[tok.Line] = append(byLine[tok.Line], tok)
+		}
+		// A literal that ended on a later line than it started: the lines
+		// after its first are part of it
+		if isLiteral(prev.Type) {
+			for line := prev.Line + 1; line <= tok.Line && line <= len(lines); line++ {
+				verbatim[line] = true
+			}
+		}
+		prev = tok
+	}
+
+	var s state
+	var out strings.Builder
+	blank := 0
+	for i, line := range lines {
+		n := i + 1
+		if verbatim[n] {
+			out.WriteString(line + "\n")
+			s.scan(byLine[n])
+			continue
+		}
+		text := strings.TrimSpace(line)
+		if text == "" {
+			blank++
+			continue
+		}
+		if blank > 0 && out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		blank = 0
+		out.WriteString(strings.Repeat(indent, s.indent(byLine[n])) + text + "\n")
+		s.scan(byLine[n])
+	}
+	return out.String(), nil
+}
+
+func isLiteral(t token.TokenType) bool {
+	return t == token.STRING || t == token.FSTRING || t == token.BYTES
+}
+
+// open is a block or bracket that is still open: the line it started on,
+// the levels it indents by and, for a bracket, its kind and whether it
+// holds types, as in func(int, string) -> int or map[string, int], rather
+// than values.
+type open struct {
+	line   int
+	levels int
+	kind   token.TokenType
+	types  bool
+}
+
+// state is what is open at the start of a line, innermost last.
+type state struct {
+	stack []open
+	prev  token.TokenType
+}
+
+// indent returns the level of a line made of toks. Blocks and brackets
+// opened on the same line, as in spawn(func() -> void, ...), indent the
+// lines after it once; lines starting with end or a closing bracket
+// line up with the line that opened what they close, and else, elif,
+// catch, case and default with the line of their block.
+func (s *state) indent(toks []token.Token) int {
+	n := len(s.stack)
+	for _, tok := range toks {
+		if n == 0 || !closes(tok.Type) {
+			break
+		}
+		n--
+	}
+	level := 0
+	for i := 0; i < n; i++ {
+		levels := s.stack[i].levels
+		for i+1 < n && s.stack[i+1].line == s.stack[i].line {
+			i++
+			levels = max(levels, s.stack[i].levels)
+		}
+		level += levels
+	}
+	if len(toks) > 0 && n > 0 {
+		switch toks[0].Type {
+		case token.ELSE, token.ELIF, token.CATCH, token.CASE, token.DEFAULT:
+			level--
+		}
+	}
+	return level
+}
+
+func closes(t token.TokenType) bool {
+	return t == token.END || t == token.RPAREN || t == token.RBRACKET || t == token.RBRACE
+}
+
+// scan moves past the tokens of a line.
+func (s *state) scan(toks []token.Token) {
+	for _, tok := range toks {
+		valueFunc := false
+		switch tok.Type {
+		case token.IF, token.WHILE, token.FOR, token.STRUCT, token.TRY:
+			s.push(open{line: tok.Line, levels: 1, kind: tok.Type})
+		case token.WHEN:
+			s.push(open{line: tok.Line, levels: 2, kind: tok.Type})
+		case token.FUNC:
+			if !s.inType() {
+				s.push(open{line: tok.Line, levels: 1, kind: tok.Type})
+				valueFunc = true
+			}
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			types := tok.Type != token.LBRACE && (s.inType() || s.prev == token.FUNC || s.prev == token.MAP)
+			s.push(open{line: tok.Line, levels: 1, kind: tok.Type, types: types})
+		case token.END, token.RPAREN, token.RBRACKET, token.RBRACE:
+			if len(s.stack) > 0 {
+				s.stack = s.stack[:len(s.stack)-1]
+			}
+		}
+		if valueFunc {
+			// The parameters of a function are values
+			s.prev = token.ILLEGAL
+		} else {
+			s.prev = tok.Type
+		}
+	}
+}
+
+func (s *state) push(o open) {
+	s.stack = append(s.stack, o)
+}
+
+// inType reports whether the next token is part of a type: it follows a
+// ':' or '->' outside a map literal, or is inside the brackets of a type.
+func (s *state) inType() bool {
+	if n := len(s.stack); n > 0 {
+		top := s.stack[n-1]
+		if top.types {
+			return true
+		}
+		if top.kind == token.LBRACE {
+			return false
+		}
+	}
+	return s.prev == token.COLON || s.prev == token.ARROW
+}
@@ -0,0 +1,69 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"blocks", `
+func sign(n: int) -> int
+  if n > 0 then
+        return 1
+   elif n < 0 then
+  return -1
+ else
+    return 0
+  end
+end`, `func sign(n: int) -> int
+    if n > 0 then
+        return 1
+    elif n < 0 then
+        return -1
+    else
+        return 0
+    end
+end
+`},
+		{"blank lines and trailing space", "let a: int = 1   \n\n\n\nlet b: int = 2\t\n\n", "let a: int = 1\n\nlet b: int = 2\n"},
+		{"comments", "while true do\n// stop\nbreak\n  end", "while true do\n    // stop\n    break\nend\n"},
+		{"one-line blocks", "if true then print(1) end\nprint(2)", "if true then print(1) end\nprint(2)\n"},
+		{"try", "try\nthrow \"x\"\ncatch e\nprint(e)\nend", "try\n    throw \"x\"\ncatch e\n    print(e)\nend\n"},
+		{"when", "when\ncase 1 == 1 then\nprint(1)\ndefault\nprint(2)\nend", "when\n    case 1 == 1 then\n        print(1)\n    default\n        print(2)\nend\n"},
+		{"brackets", "let xs: int[] = [1,\n2,\n3]\nlet m: map[string, int] = {\n\"a\": 1\n}", "let xs: int[] = [1,\n    2,\n    3]\nlet m: map[string, int] = {\n    \"a\": 1\n}\n"},
+		// A function passed as an argument indents its body once
+		{"function literal", "spawn(func() -> void\nprint(1)\nend)", "spawn(func() -> void\n    print(1)\nend)\n"},
+		{"function in a map", "let m: map[string, any] = {\"f\": func() -> void\nprint(1)\nend}", "let m: map[string, any] = {\"f\": func() -> void\n    print(1)\nend}\n"},
+		// Function types have no body
+		{"function types", "struct B\non: func(string) -> void\nend\nfunc apply(f: func(func(int) -> int) -> int) -> func(int) -> int\nreturn f\nend",
+			"struct B\n    on: func(string) -> void\nend\nfunc apply(f: func(func(int) -> int) -> int) -> func(int) -> int\n    return f\nend\n"},
+		{"multi-line string", "func f() -> string\nreturn \"a\n  b\nc\"\nend", "func f() -> string\n    return \"a\n  b\nc\"\nend\n"},
+	}
+	for _, tt := range tests {
+		got, err := Source(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		// Formatting is stable
+		if again, _ := Source(got); again != got {
+			t.Errorf("%s: formatting again changed\n%s\nto\n%s", tt.name, got, again)
+		}
+	}
+}
+
+func TestSourceSyntaxError(t *testing.T) {
+	src := "if x then\nprint(1)\n"
+	got, err := Source(src)
+	if err == nil || !strings.Contains(err.Error(), "SyntaxError") || got != "" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
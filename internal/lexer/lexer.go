@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
 	"noxy-vm/internal/token"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
@@ -27,9 +31,38 @@ func (l *Lexer) readChar() {
 	}
 	l.position = l.readPosition
 	l.readPosition += 1
+	// Columns count characters, not the continuation bytes of UTF-8
+	if !utf8.RuneStart(l.ch) {
+		return
+	}
 	l.column++
 }
 
+// readLiteralChar moves past l.ch inside a string literal, which may
+// span lines.
+func (l *Lexer) readLiteralChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.readChar()
+}
+
+// currentRune decodes the character starting at l.ch.
+func (l *Lexer) currentRune() (rune, int) {
+	if l.ch < utf8.RuneSelf {
+		return rune(l.ch), 1
+	}
+	return utf8.DecodeRuneInString(l.input[l.position:])
+}
+
+// skipRune moves past the current character, which takes size bytes.
+func (l *Lexer) skipRune(size int) {
+	for i := 0; i < size; i++ {
+		l.readChar()
+	}
+}
+
 func (l *Lexer) peekChar() byte {
 	if l.readPosition >= len(l.input) {
 		return 0
@@ -125,6 +158,14 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BIT_OR, l.ch)
 		}
+	case '?':
+		if l.peekChar() == '?' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.COALESCE, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case '^':
 		tok = newToken(token.BIT_XOR, l.ch)
 	case '~':
@@ -146,7 +187,12 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case '.':
-		tok = newToken(token.DOT, l.ch)
+		if l.peekChar() == '.' {
+			l.readChar()
+			tok = token.Token{Type: token.DOTDOT, Literal: ".."}
+		} else {
+			tok = newToken(token.DOT, l.ch)
+		}
 	case '\n':
 		tok = newToken(token.NEWLINE, l.ch)
 		// For NEWLINE token, we want the line/col of the newline char itself
@@ -178,18 +224,17 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '"' || l.peekChar() == '\'' {
 			quote := l.peekChar()
 			l.readChar() // eat 'b'
-			lit, ok := l.readBytes(quote)
-			if !ok {
+			lit, err := l.readBytes(quote)
+			if err != nil {
 				tok.Type = token.ILLEGAL
-				tok.Literal = "unterminated bytes literal"
+				tok.Literal = err.Error()
 			} else {
 				tok.Type = token.BYTES
 				tok.Literal = lit
 			}
 		} else {
-			tok.Literal = l.readIdentifier()
-			tok.Type = token.LookupIdent(tok.Literal)
 			// Early return for identifier needed because readIdentifier advances
+			tok = l.readIdentifierToken()
 			tok.Line = startLine
 			tok.Column = startColumn
 			return tok
@@ -207,8 +252,7 @@ func (l *Lexer) NextToken() token.Token {
 				tok.Literal = lit
 			}
 		} else {
-			tok.Literal = l.readIdentifier()
-			tok.Type = token.LookupIdent(tok.Literal)
+			tok = l.readIdentifierToken()
 			tok.Line = startLine
 			tok.Column = startColumn
 			return tok
@@ -217,9 +261,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
-		if isLetter(l.ch) {
-			tok.Literal = l.readIdentifier()
-			tok.Type = token.LookupIdent(tok.Literal)
+		if r, _ := l.currentRune(); isIdentStart(r) {
+			tok = l.readIdentifierToken()
 			tok.Line = startLine
 			tok.Column = startColumn
 			return tok
@@ -228,6 +271,11 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Line = startLine
 			tok.Column = startColumn
 			return tok
+		} else if l.ch >= utf8.RuneSelf {
+			// Report the whole character, not its first byte
+			r, size := l.currentRune()
+			tok = token.Token{Type: token.ILLEGAL, Literal: string(r)}
+			l.skipRune(size - 1)
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
@@ -256,12 +304,28 @@ func (l *Lexer) skipComment() {
 
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) || isDigit(l.ch) || l.ch == '_' {
-		l.readChar()
+	for {
+		r, size := l.currentRune()
+		if !isIdentPart(r) {
+			break
+		}
+		l.skipRune(size)
 	}
 	return l.input[position:l.position]
 }
 
+// readIdentifierToken reads an identifier or keyword. Identifiers are not
+// normalized, so a letter followed by a combining accent is rejected
+// rather than silently being a different name from the precomposed
+// letter that editors normally produce.
+func (l *Lexer) readIdentifierToken() token.Token {
+	ident := l.readIdentifier()
+	if decomposedLatin(ident) {
+		return token.Token{Type: token.ILLEGAL, Literal: fmt.Sprintf("identifier %q has a combining accent; use the precomposed letter", ident)}
+	}
+	return token.Token{Type: token.LookupIdent(ident), Literal: ident}
+}
+
 func (l *Lexer) readNumber() (token.TokenType, string) {
 	position := l.position
 
@@ -288,7 +352,8 @@ func (l *Lexer) readNumber() (token.TokenType, string) {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	if l.ch == '.' {
+	// 1..5 is a range, not the float 1. followed by .5
+	if l.ch == '.' && l.peekChar() != '.' {
 		isFloat = true
 		l.readChar()
 		for isDigit(l.ch) {
@@ -344,20 +409,22 @@ func (l *Lexer) readString(quote byte) (string, bool) {
 		} else {
 			out = append(out, l.ch)
 		}
-		l.readChar()
+		l.readLiteralChar()
 	}
 
 	return string(out), true
 }
 
-func (l *Lexer) readBytes(quote byte) (string, bool) {
+// readBytes reads a bytes literal, which besides the string escapes
+// accepts \xNN for any byte.
+func (l *Lexer) readBytes(quote byte) (string, error) {
 	l.readChar()
 
 	var out []byte
 
 	for {
 		if l.ch == 0 {
-			return string(out), false
+			return "", errors.New("unterminated bytes literal")
 		}
 		if l.ch == quote {
 			break
@@ -377,6 +444,21 @@ func (l *Lexer) readBytes(quote byte) (string, bool) {
 				out = append(out, '\'')
 			case '\\':
 				out = append(out, '\\')
+			case 'x':
+				var hi, lo byte
+				ok := l.readPosition+1 < len(l.input)
+				if ok {
+					hi, ok = hexDigit(l.input[l.readPosition])
+				}
+				if ok {
+					lo, ok = hexDigit(l.input[l.readPosition+1])
+				}
+				if !ok {
+					return "", errors.New("invalid \\x escape in bytes literal: expected two hex digits")
+				}
+				out = append(out, hi<<4|lo)
+				l.readChar()
+				l.readChar()
 			default:
 				out = append(out, '\\')
 				out = append(out, l.ch)
@@ -384,50 +466,172 @@ func (l *Lexer) readBytes(quote byte) (string, bool) {
 		} else {
 			out = append(out, l.ch)
 		}
-		l.readChar()
+		l.readLiteralChar()
+	}
+	return string(out), nil // The parser converts this string to Bytes Value
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
 	}
-	return string(out), true // The parser converts this string to Bytes Value
+	return 0, false
 }
 
+// readFString reads an f-string and returns its body as written, which
+// the parser splits with SplitFString.
 func (l *Lexer) readFString(quote byte) (string, bool) {
-	l.readChar() // Skip opening quote
+	body := l.input[l.readPosition:]
+	_, n, ok := scanFString(body, quote)
+	if !ok {
+		for l.ch != 0 {
+			l.readChar()
+		}
+		return "", false
+	}
+	for i := 0; i <= n; i++ {
+		l.readLiteralChar()
+	}
+	return body[:n], true
+}
 
-	var out []byte
+// FStringPart is a piece of an f-string: literal text, or the source of
+// an interpolated expression.
+type FStringPart struct {
+	Text string
+	Expr bool
+}
 
-	for {
-		if l.ch == 0 {
-			return string(out), false
+// SplitFString splits the body of an f-string, as found in an FSTRING
+// token, into its parts. Escapes in the text are resolved, and {{ and }}
+// stand for literal braces. ok is false when a brace is left open.
+func SplitFString(body string) (parts []FStringPart, ok bool) {
+	parts, _, ok = scanFString(body, 0)
+	return parts, ok
+}
+
+// scanFString reads an f-string body from src up to the closing quote
+// (the end of src when quote is 0), returning its parts and the length
+// of the body. Inside {...} braces nest and string literals may use
+// either quote, so a '}' or the f-string's own quote in them does not
+// end the interpolation.
+func scanFString(src string, quote byte) (parts []FStringPart, n int, ok bool) {
+	var text []byte
+	flush := func() {
+		if len(text) > 0 {
+			parts = append(parts, FStringPart{Text: string(text)})
+			text = nil
 		}
-		if l.ch == quote {
-			break
+	}
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == quote:
+			flush()
+			return parts, i, true
+		case c == '\\' && i+1 < len(src):
+			text = append(text, unescapeFString(src[i+1])...)
+			i += 2
+		case (c == '{' || c == '}') && i+1 < len(src) && src[i+1] == c:
+			text = append(text, c)
+			i += 2
+		case c == '{':
+			expr, end, ok := scanInterpolation(src, i+1)
+			if !ok {
+				return parts, i, false
+			}
+			flush()
+			parts = append(parts, FStringPart{Text: expr, Expr: true})
+			i = end + 1
+		default:
+			text = append(text, c)
+			i++
 		}
-		if l.ch == '\\' {
-			l.readChar()
-			switch l.ch {
-			case 'n':
-				out = append(out, '\n')
-			case 'r':
-				out = append(out, '\r')
-			case 't':
-				out = append(out, '\t')
-			case '"':
-				out = append(out, '"')
-			case '\'':
-				out = append(out, '\'')
-			case '\\':
-				out = append(out, '\\')
-			case '{': // Escaped interpolation start?
-				out = append(out, '{')
-			default:
-				out = append(out, '\\')
-				out = append(out, l.ch)
+	}
+	flush()
+	return parts, i, quote == 0
+}
+
+func unescapeFString(c byte) []byte {
+	switch c {
+	case 'n':
+		return []byte{'\n'}
+	case 'r':
+		return []byte{'\r'}
+	case 't':
+		return []byte{'\t'}
+	case '"', '\'', '\\', '{', '}':
+		return []byte{c}
+	}
+	return []byte{'\\', c}
+}
+
+// scanInterpolation reads the expression of an interpolation starting at
+// src[start] and returns its source and the index of the closing '}'.
+// Quotes escaped for the enclosing f-string, as in f"{m[\"k\"]}", delimit
+// string literals too; the expression gets them unescaped. An
+// interpolation cannot span lines.
+func scanInterpolation(src string, start int) (string, int, bool) {
+	var out []byte
+	depth := 0
+	for i := start; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\n':
+			return "", 0, false
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\n' {
+					return "", 0, false
+				}
+				if src[j] == '\\' {
+					j++
+				}
 			}
-		} else {
-			out = append(out, l.ch)
+			if j >= len(src) {
+				return "", 0, false
+			}
+			out = append(out, src[i:j+1]...)
+			i = j
+		case c == '\\' && i+1 < len(src) && (src[i+1] == '"' || src[i+1] == '\''):
+			q := src[i+1]
+			out = append(out, q)
+			j := i + 2
+			for ; j+1 < len(src) && !(src[j] == '\\' && src[j+1] == q); j++ {
+				if src[j] == '\n' {
+					return "", 0, false
+				}
+				if src[j] == '\\' && src[j+1] == '\\' {
+					j++
+				}
+				out = append(out, src[j])
+			}
+			if j+1 >= len(src) {
+				return "", 0, false
+			}
+			out = append(out, q)
+			i = j + 1
+		case c == '{':
+			depth++
+			out = append(out, c)
+		case c == '}':
+			if depth == 0 {
+				return string(out), i, true
+			}
+			depth--
+			out = append(out, c)
+		default:
+			out = append(out, c)
 		}
-		l.readChar()
 	}
-	return string(out), true
+	return "", 0, false
 }
 
 func newToken(tokenType token.TokenType, ch byte) token.Token {
@@ -438,6 +642,38 @@ func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 
+// isIdentStart reports whether r can begin an identifier: an ASCII or
+// Unicode letter, or '_'.
+func isIdentStart(r rune) bool {
+	if r < utf8.RuneSelf {
+		return isLetter(byte(r))
+	}
+	return unicode.IsLetter(r)
+}
+
+// isIdentPart reports whether r can continue an identifier: besides the
+// characters that start one, ASCII digits and the combining marks that
+// scripts such as Devanagari need.
+func isIdentPart(r rune) bool {
+	if r < utf8.RuneSelf {
+		return isLetter(byte(r)) || isDigit(byte(r))
+	}
+	return unicode.IsLetter(r) || unicode.In(r, unicode.Mn, unicode.Mc)
+}
+
+// decomposedLatin reports whether ident has a combining mark right after
+// an ASCII letter, as in "c" followed by U+0327 instead of "ç".
+func decomposedLatin(ident string) bool {
+	var prev rune
+	for _, r := range ident {
+		if unicode.Is(unicode.Mn, r) && prev < utf8.RuneSelf && isLetter(byte(prev)) {
+			return true
+		}
+		prev = r
+	}
+	return false
+}
+
 func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"noxy-vm/internal/token"
+	"reflect"
 	"testing"
 )
 
@@ -37,6 +38,7 @@ end
 global g = 1
 zeros(10)
 ref x
+for i in 1..n do
 map[string, int]
 `
 
@@ -182,6 +184,14 @@ map[string, int]
 		{token.REF, "ref"},
 		{token.IDENTIFIER, "x"},
 		{token.NEWLINE, "\n"},
+		{token.FOR, "for"},
+		{token.IDENTIFIER, "i"},
+		{token.IN, "in"},
+		{token.INT, "1"},
+		{token.DOTDOT, ".."},
+		{token.IDENTIFIER, "n"},
+		{token.DO, "do"},
+		{token.NEWLINE, "\n"},
 		{token.MAP, "map"},
 		{token.LBRACKET, "["},
 		{token.TYPE_STRING, "string"},
@@ -208,3 +218,91 @@ map[string, int]
 		}
 	}
 }
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := "let preço = \"é\" + número_2 § 名前 नमस्ते\nprec\u0327o" // the last ç is c + U+0327
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENTIFIER, "preço", 5},
+		{token.ASSIGN, "=", 11},
+		{token.STRING, "é", 13},
+		{token.PLUS, "+", 17},
+		{token.IDENTIFIER, "número_2", 19},
+		{token.ILLEGAL, "§", 28},
+		{token.IDENTIFIER, "名前", 30},
+		{token.IDENTIFIER, "नमस्ते", 33},
+		{token.NEWLINE, "\n", 39},
+		{token.ILLEGAL, "identifier \"prec\u0327o\" has a combining accent; use the precomposed letter", 1},
+		{token.EOF, "", 7},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - got %q %q at column %d, expected %q %q at column %d",
+				i, tok.Type, tok.Literal, tok.Column, tt.expectedType, tt.expectedLiteral, tt.expectedColumn)
+		}
+	}
+}
+
+func TestFStrings(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []FStringPart
+	}{
+		{`f"a {x} b"`, []FStringPart{{Text: "a "}, {Text: "x", Expr: true}, {Text: " b"}}},
+		{`f"{{x}} {y}}}"`, []FStringPart{{Text: "{x} "}, {Text: "y", Expr: true}, {Text: "}"}}},
+		{`f"{m["k"]} {'}'}"`, []FStringPart{{Text: `m["k"]`, Expr: true}, {Text: " "}, {Text: `'}'`, Expr: true}}},
+		{`f"{m[\"k\"]}\t\{"`, []FStringPart{{Text: `m["k"]`, Expr: true}, {Text: "\t{"}}},
+		{`f'{ {"a": "}"}["a"] }'`, []FStringPart{{Text: ` {"a": "}"}["a"] `, Expr: true}}},
+		{`f"{join(xs, \"\\n\")}"`, []FStringPart{{Text: `join(xs, "\n")`, Expr: true}}},
+	}
+	for _, tt := range tests {
+		l := New(tt.input + " x")
+		tok := l.NextToken()
+		if tok.Type != token.FSTRING {
+			t.Fatalf("%s: got %q %q", tt.input, tok.Type, tok.Literal)
+		}
+		if next := l.NextToken(); next.Literal != "x" {
+			t.Fatalf("%s: f-string did not end at its closing quote, next token is %q", tt.input, next.Literal)
+		}
+		parts, ok := SplitFString(tok.Literal)
+		if !ok || !reflect.DeepEqual(parts, tt.want) {
+			t.Errorf("%s: got %+v, %v, want %+v", tt.input, parts, ok, tt.want)
+		}
+	}
+
+	for _, input := range []string{`f"{x"`, `f"{"a}"`, "f\"{x\n}\""} {
+		if tok := New(input).NextToken(); tok.Type != token.ILLEGAL {
+			t.Errorf("%s: got %q %q, want an unterminated f-string", input, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestBytesHexEscapes(t *testing.T) {
+	tok := New(`b"\x00\xFFa\x7f\n"`).NextToken()
+	if tok.Type != token.BYTES || tok.Literal != "\x00\xffa\x7f\n" {
+		t.Fatalf("got %q %q", tok.Type, tok.Literal)
+	}
+	for _, input := range []string{`b"\x4"`, `b"\xzz"`, `b"\x`} {
+		if tok := New(input).NextToken(); tok.Type != token.ILLEGAL {
+			t.Errorf("%s: got %q %q, want an invalid escape", input, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestLinesAfterMultiLineStrings(t *testing.T) {
+	l := New("let a: string = \"x\ny\"\nlet b: bytes = b'1\n2'\nlet c: string = f\"{a}\n\"\nz")
+	var last token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		last = tok
+	}
+	if last.Literal != "z" || last.Line != 7 || last.Column != 1 {
+		t.Errorf("got %s, want z at line 7, column 1", last)
+	}
+}
@@ -0,0 +1,441 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	uriAutolinkRe   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.\-]{1,31}:[^<>\x00-\x20]*)>`)
+	emailAutolinkRe = regexp.MustCompile(`^<([A-Za-z0-9.!#$%&'*+/=?^_` + "`" + `{|}~\-]+@[A-Za-z0-9](?:[A-Za-z0-9\-]{0,61}[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9\-]{0,61}[A-Za-z0-9])?)*)>`)
+	entityRe        = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+)
+
+// maxLinkParens bounds the nesting of parentheses in a link destination,
+// as CommonMark allows, so an unclosed one is not rescanned for each link.
+const maxLinkParens = 32
+
+// renderInline writes the HTML of the inline text s to out.
+func renderInline(out *strings.Builder, s string) {
+	newInliner(s, 0).render(out)
+}
+
+// An inliner renders one run of inline text. Its memos keep the searches
+// for closing delimiters linear in the length of the text.
+type inliner struct {
+	s     string
+	depth int
+	// brackets maps each '[' to the ']' that closes it
+	brackets map[int]int
+	// spans maps where a code span starts to where it ends, or to 0 when
+	// no span starts there
+	spans map[int]int
+	// noSpan maps a backtick count to a position from which no run of
+	// that many backticks follows
+	noSpan map[int]int
+	// noCloser maps a delimiter and count to a position from which
+	// nothing closes them
+	noCloser map[[2]int]int
+}
+
+func newInliner(s string, depth int) *inliner {
+	in := &inliner{s: s, depth: depth, brackets: map[int]int{}, spans: map[int]int{}, noSpan: map[int]int{}, noCloser: map[[2]int]int{}}
+	var open []int
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			if end := in.codeSpanEnd(j); end > 0 {
+				j = end - 1
+			} else {
+				j += runLength(s, j, '`') - 1
+			}
+		case '[':
+			open = append(open, j)
+		case ']':
+			if len(open) > 0 {
+				in.brackets[open[len(open)-1]] = j
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return in
+}
+
+// inner renders part of the text, such as a link's or emphasis's, at the
+// next depth, or as plain text past maxNesting.
+func (in *inliner) inner(out *strings.Builder, s string) {
+	if in.depth >= maxNesting {
+		escape(out, s)
+		return
+	}
+	newInliner(s, in.depth+1).render(out)
+}
+
+func (in *inliner) render(out *strings.Builder) {
+	s := in.s
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case '\\':
+			if i+1 < len(s) && s[i+1] == '\n' {
+				out.WriteString("<br />\n")
+				i += 2
+				continue
+			}
+			if i+1 < len(s) && isPunct(s[i+1]) {
+				escape(out, s[i+1:i+2])
+				i += 2
+				continue
+			}
+
+		case ' ':
+			// Spaces end a line, or two or more make a hard break
+			n := runLength(s, i, ' ')
+			if i+n < len(s) && s[i+n] == '\n' {
+				if n >= 2 {
+					out.WriteString("<br />")
+				}
+				i += n
+				continue
+			}
+			out.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case '`':
+			if end := in.codeSpanEnd(i); end > 0 {
+				n := runLength(s, i, '`')
+				code := strings.ReplaceAll(s[i+n:end-n], "\n", " ")
+				if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+					code = code[1 : len(code)-1]
+				}
+				out.WriteString("<code>")
+				escape(out, code)
+				out.WriteString("</code>")
+				i = end
+				continue
+			}
+			n := runLength(s, i, '`')
+			out.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case '!':
+			if l, ok := in.parseLink(i + 1); ok {
+				var alt strings.Builder
+				in.inner(&alt, l.text)
+				out.WriteString(`<img src="`)
+				escape(out, safeURL(l.dest))
+				out.WriteString(`" alt="`)
+				escape(out, plainText(alt.String()))
+				out.WriteString(`"`)
+				writeTitle(out, l.title)
+				out.WriteString(" />")
+				i = l.end
+				continue
+			}
+
+		case '[':
+			if l, ok := in.parseLink(i); ok {
+				out.WriteString(`<a href="`)
+				escape(out, safeURL(l.dest))
+				out.WriteString(`"`)
+				writeTitle(out, l.title)
+				out.WriteString(">")
+				in.inner(out, l.text)
+				out.WriteString("</a>")
+				i = l.end
+				continue
+			}
+
+		case '<':
+			if m := uriAutolinkRe.FindStringSubmatch(s[i:]); m != nil {
+				writeAutolink(out, safeURL(m[1]), m[1])
+				i += len(m[0])
+				continue
+			}
+			if m := emailAutolinkRe.FindStringSubmatch(s[i:]); m != nil {
+				writeAutolink(out, "mailto:"+m[1], m[1])
+				i += len(m[0])
+				continue
+			}
+
+		case '&':
+			if m := entityRe.FindString(s[i:]); m != "" {
+				out.WriteString(m)
+				i += len(m)
+				continue
+			}
+
+		case '*', '_', '~':
+			if n, ok := in.emphasis(out, i); ok {
+				i = n
+				continue
+			}
+		}
+		escape(out, s[i:i+1])
+		i++
+	}
+}
+
+// emphasis renders the emphasis opened by the delimiter run at
+// s[i], returning the index after its closer. A run of one marks <em>,
+// two <strong> (or <del> for ~~) and three both. It reports false if
+// s[i] opens nothing, to be written as it is.
+func (in *inliner) emphasis(out *strings.Builder, i int) (int, bool) {
+	s := in.s
+	c := s[i]
+	n := runLength(s, i, c)
+	if c == '~' && n != 2 || n > 3 {
+		out.WriteString(s[i : i+n])
+		return i + n, true
+	}
+	// An opener comes before text, and an _ does not start inside a word
+	if i+n >= len(s) || isSpace(s[i+n]) || c == '_' && i > 0 && isAlnum(s[i-1]) {
+		out.WriteString(s[i : i+n])
+		return i + n, true
+	}
+	k := in.findCloser(i+n, c, n)
+	if k < 0 {
+		return 0, false
+	}
+	open, close := "<em>", "</em>"
+	switch {
+	case c == '~':
+		open, close = "<del>", "</del>"
+	case n == 2:
+		open, close = "<strong>", "</strong>"
+	case n == 3:
+		open, close = "<em><strong>", "</strong></em>"
+	}
+	out.WriteString(open)
+	in.inner(out, s[i+n:k])
+	out.WriteString(close)
+	return k + n, true
+}
+
+// findCloser returns the index of the n delimiters c that close emphasis
+// opened before from, or -1. A closer comes after text, and an _ does not
+// end inside a word. Looking for a single delimiter skips runs of two,
+// which are nested <strong>, and a longer run closes with its last ones.
+func (in *inliner) findCloser(from int, c byte, n int) int {
+	s := in.s
+	key := [2]int{int(c), n}
+	if none, ok := in.noCloser[key]; ok && from >= none {
+		return -1
+	}
+	for j := from; j < len(s); {
+		switch {
+		case s[j] == '\\':
+			j += 2
+		case s[j] == '`' && in.codeSpanEnd(j) > 0:
+			j = in.codeSpanEnd(j)
+		case s[j] == c:
+			m := runLength(s, j, c)
+			end := j + m
+			if j > from && !isSpace(s[j-1]) && (c != '_' || end == len(s) || !isAlnum(s[end])) {
+				if n == 1 && m != 2 || n > 1 && m >= n {
+					return end - n
+				}
+			}
+			j = end
+		default:
+			j++
+		}
+	}
+	in.noCloser[key] = from
+	return -1
+}
+
+// codeSpanEnd returns the index after the code span starting with the
+// backticks at s[i], or 0 if no run of as many backticks closes it.
+func (in *inliner) codeSpanEnd(i int) int {
+	if end, ok := in.spans[i]; ok {
+		return end
+	}
+	s := in.s
+	n := runLength(s, i, '`')
+	end := 0
+	if none, ok := in.noSpan[n]; !ok || i+n < none {
+		for j := i + n; j < len(s); {
+			if s[j] != '`' {
+				j++
+				continue
+			}
+			m := runLength(s, j, '`')
+			if m == n {
+				end = j + m
+				break
+			}
+			j += m
+		}
+		if end == 0 {
+			in.noSpan[n] = i + n
+		}
+	}
+	in.spans[i] = end
+	return end
+}
+
+type link struct {
+	text, dest, title string
+	end               int
+}
+
+// parseLink parses [text](dest "title") starting at the '[' at s[i].
+func (in *inliner) parseLink(i int) (link, bool) {
+	s := in.s
+	close, ok := in.brackets[i]
+	if !ok || close+1 >= len(s) || s[close+1] != '(' {
+		return link{}, false
+	}
+	l := link{text: s[i+1 : close]}
+	k := skipSpace(s, close+2)
+	if k < len(s) && s[k] == '<' {
+		end := strings.IndexAny(s[k:], ">\n")
+		if end < 0 || s[k+end] != '>' {
+			return link{}, false
+		}
+		l.dest = s[k+1 : k+end]
+		k += end + 1
+	} else {
+		start, parens := k, 0
+	dest:
+		for ; k < len(s) && !isSpace(s[k]); k++ {
+			switch s[k] {
+			case '\\':
+				k++
+			case '(':
+				if parens++; parens > maxLinkParens {
+					return link{}, false
+				}
+			case ')':
+				if parens == 0 {
+					break dest
+				}
+				parens--
+			}
+		}
+		l.dest = s[start:min(k, len(s))]
+	}
+	k = skipSpace(s, k)
+	if k < len(s) && (s[k] == '"' || s[k] == '\'') {
+		end := strings.IndexByte(s[k+1:], s[k])
+		if end < 0 {
+			return link{}, false
+		}
+		l.title = s[k+1 : k+1+end]
+		k = skipSpace(s, k+end+2)
+	}
+	if k >= len(s) || s[k] != ')' {
+		return link{}, false
+	}
+	l.dest, l.title = unescape(l.dest), unescape(l.title)
+	l.end = k + 1
+	return l, true
+}
+
+func writeTitle(out *strings.Builder, title string) {
+	if title != "" {
+		out.WriteString(` title="`)
+		escape(out, title)
+		out.WriteString(`"`)
+	}
+}
+
+func writeAutolink(out *strings.Builder, href, text string) {
+	out.WriteString(`<a href="`)
+	escape(out, href)
+	out.WriteString(`">`)
+	escape(out, text)
+	out.WriteString("</a>")
+}
+
+// safeURL returns u, or "#" if its scheme can run script.
+func safeURL(u string) string {
+	scheme, _, found := strings.Cut(strings.ToLower(strings.TrimSpace(u)), ":")
+	if found && (scheme == "javascript" || scheme == "vbscript" || scheme == "data") {
+		return "#"
+	}
+	return u
+}
+
+// escape writes s to out with &, <, > and " escaped.
+func escape(out *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '&':
+			out.WriteString("&amp;")
+		case '<':
+			out.WriteString("&lt;")
+		case '>':
+			out.WriteString("&gt;")
+		case '"':
+			out.WriteString("&quot;")
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+}
+
+// unescape removes the backslashes before punctuation.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isPunct(s[i+1]) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// plainText is the text of rendered HTML, without its tags.
+func plainText(h string) string {
+	var sb strings.Builder
+	inTag := false
+	for i := 0; i < len(h); i++ {
+		switch {
+		case h[i] == '<':
+			inTag = true
+		case h[i] == '>' && inTag:
+			inTag = false
+		case !inTag:
+			sb.WriteByte(h[i])
+		}
+	}
+	return html.UnescapeString(sb.String())
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
@@ -0,0 +1,522 @@
+// Package markdown renders Markdown as HTML.
+//
+// It covers the CommonMark blocks documentation is written with: ATX and
+// setext headings, paragraphs, fenced and indented code, block quotes,
+// nested bullet and ordered lists, and thematic breaks. GitHub's pipe
+// tables and ~~strikethrough~~ are supported too. Inline there are code
+// spans, emphasis, links, images, <autolinks>, backslash escapes and hard
+// line breaks. Reference-style links are not.
+//
+// Raw HTML is escaped rather than passed through, and links to
+// javascript:, vbscript: and data: URLs are dropped, so rendering text
+// from untrusted users is safe. Headings get an id made from their text,
+// "Getting Started" becoming "getting-started", for linking to sections.
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type blockKind int
+
+const (
+	paragraphBlock blockKind = iota
+	headingBlock
+	codeBlock
+	quoteBlock
+	listBlock
+	ruleBlock
+	tableBlock
+)
+
+// A block is one parsed block. Which fields are set depends on kind.
+type block struct {
+	kind     blockKind
+	level    int      // heading
+	text     string   // paragraph and heading inlines, code contents
+	info     string   // code language
+	children []*block // quote
+	items    [][]*block
+	ordered  bool
+	start    int
+	tight    bool
+	align    []string   // table column alignments, "" for none
+	rows     [][]string // table cells, the header row first
+}
+
+var (
+	atxRe      = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*))?$`)
+	ruleRe     = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	setextRe   = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	fenceRe    = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \\t]*(.*?)[ \\t]*$")
+	quoteRe    = regexp.MustCompile(`^ {0,3}>`)
+	listRe     = regexp.MustCompile(`^( {0,3})([-*+]|[0-9]{1,9}[.)])( +|$)(.*)$`)
+	delimRowRe = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// ToHTML renders src as HTML.
+func ToHTML(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	lines := strings.Split(src, "\n")
+	for i, l := range lines {
+		lines[i] = expandTabs(l)
+	}
+	blocks, _ := parseBlocks(lines, 0)
+	r := &renderer{ids: map[string]int{}}
+	r.blocks(blocks)
+	return r.out.String()
+}
+
+// maxNesting bounds how deeply quotes and lists nest, and inlines, so
+// that hostile input cannot make rendering take quadratic time. Deeper
+// markers are read as text.
+const maxNesting = 32
+
+// parseBlocks parses lines, depth containers deep, into blocks. gaps
+// reports whether a blank line separates two of them, which makes a list
+// item loose.
+func parseBlocks(lines []string, depth int) (blocks []*block, gaps bool) {
+	blank := false
+	for i := 0; i < len(lines); {
+		if isBlank(lines[i]) {
+			blank = true
+			i++
+			continue
+		}
+		if blank && len(blocks) > 0 {
+			gaps = true
+		}
+		blank = false
+		var b *block
+		b, i = parseBlock(lines, i, depth)
+		blocks = append(blocks, b)
+	}
+	return blocks, gaps
+}
+
+// parseBlock parses the block starting at the non-blank lines[i] and
+// returns it with the index of the line after it.
+func parseBlock(lines []string, i, depth int) (*block, int) {
+	line := lines[i]
+
+	if indentOf(line) >= 4 {
+		var code []string
+		j := i
+		for ; j < len(lines) && (isBlank(lines[j]) || indentOf(lines[j]) >= 4); j++ {
+			code = append(code, stripIndent(lines[j], 4))
+		}
+		for isBlank(code[len(code)-1]) {
+			code = code[:len(code)-1]
+		}
+		return &block{kind: codeBlock, text: strings.Join(code, "\n") + "\n"}, j
+	}
+
+	if m := fenceRe.FindStringSubmatch(line); m != nil && !(m[2][0] == '`' && strings.Contains(m[3], "`")) {
+		indent, fence := len(m[1]), m[2]
+		b := &block{kind: codeBlock}
+		if fields := strings.Fields(m[3]); len(fields) > 0 {
+			b.info = unescape(fields[0])
+		}
+		var code []string
+		j := i + 1
+		for ; j < len(lines); j++ {
+			t := strings.TrimSpace(lines[j])
+			if indentOf(lines[j]) < 4 && len(t) >= len(fence) && strings.Trim(t, fence[:1]) == "" {
+				j++
+				break
+			}
+			code = append(code, stripIndent(lines[j], indent))
+		}
+		if len(code) > 0 {
+			b.text = strings.Join(code, "\n") + "\n"
+		}
+		return b, j
+	}
+
+	if m := atxRe.FindStringSubmatch(line); m != nil {
+		text := strings.TrimRight(m[2], " \t")
+		// A closing run of #s is dropped if a space comes before it
+		if t := strings.TrimRight(text, "#"); t == "" || strings.HasSuffix(t, " ") || strings.HasSuffix(t, "\t") {
+			text = strings.TrimRight(t, " \t")
+		}
+		return &block{kind: headingBlock, level: len(m[1]), text: text}, i + 1
+	}
+
+	if ruleRe.MatchString(line) {
+		return &block{kind: ruleBlock}, i + 1
+	}
+
+	if depth < maxNesting && quoteRe.MatchString(line) {
+		var inner []string
+		j := i
+		for ; j < len(lines); j++ {
+			l := lines[j]
+			if quoteRe.MatchString(l) {
+				l = strings.TrimLeft(l, " ")[1:]
+				inner = append(inner, strings.TrimPrefix(l, " "))
+				continue
+			}
+			// A paragraph may carry on without the '>'
+			if isBlank(l) || isBlank(inner[len(inner)-1]) || startsBlock(l) {
+				break
+			}
+			inner = append(inner, l)
+		}
+		children, _ := parseBlocks(inner, depth+1)
+		return &block{kind: quoteBlock, children: children}, j
+	}
+
+	if item := parseListItem(line); item != nil && depth < maxNesting {
+		return parseList(lines, i, item, depth)
+	}
+
+	if b, j := parseTable(lines, i); b != nil {
+		return b, j
+	}
+
+	j := i + 1
+	for ; j < len(lines) && !isBlank(lines[j]); j++ {
+		if m := setextRe.FindStringSubmatch(lines[j]); m != nil {
+			level := 1
+			if m[1][0] == '-' {
+				level = 2
+			}
+			return &block{kind: headingBlock, level: level, text: paragraphText(lines[i:j])}, j + 1
+		}
+		if startsBlock(lines[j]) {
+			break
+		}
+	}
+	return &block{kind: paragraphBlock, text: paragraphText(lines[i:j])}, j
+}
+
+// listItem is the first line of a list item.
+type listItem struct {
+	marker  byte // '-', '*' or '+', or the '.' or ')' after a number
+	number  int  // -1 in a bullet list
+	indent  int  // where the item's contents start
+	content string
+}
+
+func parseListItem(line string) *listItem {
+	m := listRe.FindStringSubmatch(line)
+	if m == nil || ruleRe.MatchString(line) {
+		return nil
+	}
+	item := &listItem{number: -1, content: m[4]}
+	marker := m[2]
+	item.marker = marker[len(marker)-1]
+	if len(marker) > 1 || marker[0] >= '0' && marker[0] <= '9' {
+		item.number, _ = strconv.Atoi(marker[:len(marker)-1])
+	}
+	spaces := len(m[3])
+	if spaces == 0 || spaces > 4 {
+		// An empty first line, or one that starts with indented code,
+		// still puts the contents one space after the marker
+		item.content = strings.Repeat(" ", max(spaces-1, 0)) + item.content
+		spaces = 1
+	}
+	item.indent = len(m[1]) + len(marker) + spaces
+	return item
+}
+
+func parseList(lines []string, i int, first *listItem, depth int) (*block, int) {
+	list := &block{kind: listBlock, ordered: first.number >= 0, start: first.number, tight: true}
+	j := i
+	for item := first; item != nil && item.marker == first.marker; {
+		itemLines := []string{item.content}
+	scan:
+		for j++; j < len(lines); j++ {
+			l := lines[j]
+			switch {
+			case isBlank(l):
+				itemLines = append(itemLines, "")
+			case indentOf(l) >= item.indent:
+				itemLines = append(itemLines, l[item.indent:])
+			case !isBlank(itemLines[len(itemLines)-1]) && !startsBlock(l) && parseListItem(l) == nil:
+				// A paragraph may carry on without the indent
+				itemLines = append(itemLines, l)
+			default:
+				break scan
+			}
+		}
+		trailing := 0
+		for len(itemLines) > 1 && isBlank(itemLines[len(itemLines)-1]) {
+			itemLines = itemLines[:len(itemLines)-1]
+			trailing++
+		}
+		children, gaps := parseBlocks(itemLines, depth+1)
+		list.items = append(list.items, children)
+		if gaps {
+			list.tight = false
+		}
+
+		item = nil
+		if j < len(lines) {
+			item = parseListItem(lines[j])
+		}
+		if trailing > 0 && item != nil && item.marker == first.marker {
+			list.tight = false
+		}
+	}
+	return list, j
+}
+
+// parseTable parses a pipe table starting at lines[i]: a header row, a
+// delimiter row of dashes with the same number of cells, and body rows up
+// to a blank line or another block.
+func parseTable(lines []string, i int) (*block, int) {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") || !delimRowRe.MatchString(lines[i+1]) {
+		return nil, i
+	}
+	header, delims := splitRow(lines[i]), splitRow(lines[i+1])
+	if len(header) != len(delims) {
+		return nil, i
+	}
+	b := &block{kind: tableBlock, rows: [][]string{header}}
+	for _, d := range delims {
+		left, right := strings.HasPrefix(d, ":"), strings.HasSuffix(d, ":")
+		switch {
+		case left && right:
+			b.align = append(b.align, "center")
+		case left:
+			b.align = append(b.align, "left")
+		case right:
+			b.align = append(b.align, "right")
+		default:
+			b.align = append(b.align, "")
+		}
+	}
+	j := i + 2
+	for ; j < len(lines) && !isBlank(lines[j]) && !startsBlock(lines[j]); j++ {
+		row := splitRow(lines[j])
+		for len(row) < len(header) {
+			row = append(row, "")
+		}
+		b.rows = append(b.rows, row[:len(header)])
+	}
+	return b, j
+}
+
+// splitRow splits a table row into its trimmed cells. "\|" is a pipe
+// inside a cell.
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// startsBlock reports whether line ends a paragraph by starting another
+// block. Lists only do when the item is not empty, and ordered ones when
+// they start at 1.
+func startsBlock(line string) bool {
+	if atxRe.MatchString(line) || ruleRe.MatchString(line) || quoteRe.MatchString(line) {
+		return true
+	}
+	if m := fenceRe.FindStringSubmatch(line); m != nil && !(m[2][0] == '`' && strings.Contains(m[3], "`")) {
+		return true
+	}
+	item := parseListItem(line)
+	return item != nil && strings.TrimSpace(item.content) != "" && item.number <= 1
+}
+
+func paragraphText(lines []string) string {
+	trimmed := make([]string, len(lines))
+	for i, l := range lines {
+		trimmed[i] = strings.TrimLeft(l, " ")
+	}
+	return strings.TrimRight(strings.Join(trimmed, "\n"), " ")
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// stripIndent removes up to n leading spaces.
+func stripIndent(line string, n int) string {
+	return line[min(indentOf(line), n):]
+}
+
+// expandTabs turns the tabs in line's indentation into spaces, to the
+// next multiple of 4 columns.
+func expandTabs(line string) string {
+	col := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			col++
+		case '\t':
+			col += 4 - col%4
+		default:
+			if col == i {
+				return line
+			}
+			return strings.Repeat(" ", col) + line[i:]
+		}
+	}
+	return strings.Repeat(" ", col)
+}
+
+type renderer struct {
+	out strings.Builder
+	// ids counts the heading ids used so far, to number repeats
+	ids map[string]int
+}
+
+func (r *renderer) blocks(blocks []*block) {
+	for _, b := range blocks {
+		r.block(b)
+	}
+}
+
+func (r *renderer) block(b *block) {
+	out := &r.out
+	switch b.kind {
+	case paragraphBlock:
+		out.WriteString("<p>")
+		renderInline(out, b.text)
+		out.WriteString("</p>\n")
+	case headingBlock:
+		var text strings.Builder
+		renderInline(&text, b.text)
+		fmt.Fprintf(out, "<h%d", b.level)
+		if id := r.headingID(text.String()); id != "" {
+			fmt.Fprintf(out, ` id="%s"`, id)
+		}
+		fmt.Fprintf(out, ">%s</h%d>\n", text.String(), b.level)
+	case codeBlock:
+		out.WriteString("<pre><code")
+		if b.info != "" {
+			out.WriteString(` class="language-`)
+			escape(out, b.info)
+			out.WriteString(`"`)
+		}
+		out.WriteString(">")
+		escape(out, b.text)
+		out.WriteString("</code></pre>\n")
+	case quoteBlock:
+		out.WriteString("<blockquote>\n")
+		r.blocks(b.children)
+		out.WriteString("</blockquote>\n")
+	case ruleBlock:
+		out.WriteString("<hr />\n")
+	case listBlock:
+		r.list(b)
+	case tableBlock:
+		r.table(b)
+	}
+}
+
+func (r *renderer) list(b *block) {
+	out := &r.out
+	tag := "ul"
+	if b.ordered {
+		tag = "ol"
+	}
+	out.WriteString("<" + tag)
+	if b.ordered && b.start != 1 {
+		fmt.Fprintf(out, ` start="%d"`, b.start)
+	}
+	out.WriteString(">\n")
+	for _, item := range b.items {
+		out.WriteString("<li>")
+		for k, c := range item {
+			// A tight list's paragraphs are not wrapped in <p>
+			if b.tight && c.kind == paragraphBlock {
+				renderInline(out, c.text)
+				if k < len(item)-1 {
+					out.WriteByte('\n')
+				}
+				continue
+			}
+			if k == 0 {
+				out.WriteByte('\n')
+			}
+			r.block(c)
+		}
+		out.WriteString("</li>\n")
+	}
+	out.WriteString("</" + tag + ">\n")
+}
+
+func (r *renderer) table(b *block) {
+	out := &r.out
+	out.WriteString("<table>\n<thead>\n")
+	for i, row := range b.rows {
+		if i == 1 {
+			out.WriteString("<tbody>\n")
+		}
+		tag := "td"
+		if i == 0 {
+			tag = "th"
+		}
+		out.WriteString("<tr>\n")
+		for k, cell := range row {
+			out.WriteString("<" + tag)
+			if b.align[k] != "" {
+				fmt.Fprintf(out, ` style="text-align: %s"`, b.align[k])
+			}
+			out.WriteString(">")
+			renderInline(out, cell)
+			out.WriteString("</" + tag + ">\n")
+		}
+		out.WriteString("</tr>\n")
+		if i == 0 {
+			out.WriteString("</thead>\n")
+		}
+	}
+	if len(b.rows) > 1 {
+		out.WriteString("</tbody>\n")
+	}
+	out.WriteString("</table>\n")
+}
+
+// headingID makes an id from a heading's rendered text: its letters,
+// digits, '-' and '_', lowercased, with spaces as '-'. A repeated id
+// gets "-1", "-2" and so on appended.
+func (r *renderer) headingID(html string) string {
+	var id strings.Builder
+	for _, c := range strings.ToLower(plainText(html)) {
+		switch {
+		case c == ' ' || c == '-':
+			id.WriteByte('-')
+		case c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c > 127 && unicode.IsLetter(c):
+			id.WriteRune(c)
+		}
+	}
+	s := id.String()
+	if s == "" {
+		return ""
+	}
+	n := r.ids[s]
+	r.ids[s] = n + 1
+	if n > 0 {
+		s = fmt.Sprintf("%s-%d", s, n)
+	}
+	return s
+}
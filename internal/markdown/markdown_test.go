@@ -0,0 +1,95 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlocks(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{"atx headings", "# Title\n### Sub ###\n#5 bolts", "<h1 id=\"title\">Title</h1>\n<h3 id=\"sub\">Sub</h3>\n<p>#5 bolts</p>\n"},
+		{"setext headings", "Title\n=====\nSub\n---", "<h1 id=\"title\">Title</h1>\n<h2 id=\"sub\">Sub</h2>\n"},
+		{"heading ids", "## Getting *Started*!\n## Getting Started\n## ¿Qué?", "<h2 id=\"getting-started\">Getting <em>Started</em>!</h2>\n<h2 id=\"getting-started-1\">Getting Started</h2>\n<h2 id=\"qué\">¿Qué?</h2>\n"},
+		{"rules", "***\n- - -\n___", "<hr />\n<hr />\n<hr />\n"},
+		{"fenced code", "```go\nif a < b {\n}\n```\nafter", "<pre><code class=\"language-go\">if a &lt; b {\n}\n</code></pre>\n<p>after</p>\n"},
+		{"tilde fence, unclosed", "~~~\n```\nx", "<pre><code>```\nx\n</code></pre>\n"},
+		{"empty fence", "```\n```", "<pre><code></code></pre>\n"},
+		{"indented code", "    a\n\n    b\n\nc", "<pre><code>a\n\nb\n</code></pre>\n<p>c</p>\n"},
+		{"indented code does not interrupt a paragraph", "a\n    b", "<p>a\nb</p>\n"},
+		{"tabs", "\tcode", "<pre><code>code\n</code></pre>\n"},
+		{"block quote", "> # Hi\n> one\ntwo\n\nthree", "<blockquote>\n<h1 id=\"hi\">Hi</h1>\n<p>one\ntwo</p>\n</blockquote>\n<p>three</p>\n"},
+		{"nested quote", ">> deep", "<blockquote>\n<blockquote>\n<p>deep</p>\n</blockquote>\n</blockquote>\n"},
+		{"tight list", "- a\n- b\n- c", "<ul>\n<li>a</li>\n<li>b</li>\n<li>c</li>\n</ul>\n"},
+		{"loose list", "- a\n\n- b", "<ul>\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ul>\n"},
+		{"item with two paragraphs", "- a\n\n  b\n- c", "<ul>\n<li>\n<p>a</p>\n<p>b</p>\n</li>\n<li>\n<p>c</p>\n</li>\n</ul>\n"},
+		{"nested list", "- a\n  - b\n  - c\n- d", "<ul>\n<li>a\n<ul>\n<li>b</li>\n<li>c</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n"},
+		{"ordered list", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"new marker, new list", "- a\n* b", "<ul>\n<li>a</li>\n</ul>\n<ul>\n<li>b</li>\n</ul>\n"},
+		{"list interrupts a paragraph", "text\n- a\n2. b", "<p>text</p>\n<ul>\n<li>a</li>\n</ul>\n<ol start=\"2\">\n<li>b</li>\n</ol>\n"},
+		{"only from 1", "text\n2. no", "<p>text\n2. no</p>\n"},
+		{"lazy continuation", "- a\nb\n\nc", "<ul>\n<li>a\nb</li>\n</ul>\n<p>c</p>\n"},
+		{"code in a list", "1. run\n\n   ```\n   x\n   ```", "<ol>\n<li>\n<p>run</p>\n<pre><code>x\n</code></pre>\n</li>\n</ol>\n"},
+		{"empty item", "-\n- a", "<ul>\n<li></li>\n<li>a</li>\n</ul>\n"},
+		{"table", "| a | b | c |\n|:--|:-:|--:|\n| 1 | `a\\|b` | x\\|y |\n| 2 |", "<table>\n<thead>\n<tr>\n<th style=\"text-align: left\">a</th>\n<th style=\"text-align: center\">b</th>\n<th style=\"text-align: right\">c</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td style=\"text-align: left\">1</td>\n<td style=\"text-align: center\"><code>a|b</code></td>\n<td style=\"text-align: right\">x|y</td>\n</tr>\n<tr>\n<td style=\"text-align: left\">2</td>\n<td style=\"text-align: center\"></td>\n<td style=\"text-align: right\"></td>\n</tr>\n</tbody>\n</table>\n"},
+		{"header-only table", "a | b\n--- | ---", "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n</table>\n"},
+		{"not a table", "a | b\n--- | --- | ---", "<p>a | b\n--- | --- | ---</p>\n"},
+		{"raw html is escaped", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"crlf", "a\r\nb\r\n", "<p>a\nb</p>\n"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		if got := ToHTML(tt.src); got != tt.want {
+			t.Errorf("%s: ToHTML(%q)\ngot:  %q\nwant: %q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestInlines(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"*em* _em_ **strong** __strong__ ***both***", "<em>em</em> <em>em</em> <strong>strong</strong> <strong>strong</strong> <em><strong>both</strong></em>"},
+		{"*a **b** c* and **a *b* c**", "<em>a <strong>b</strong> c</em> and <strong>a <em>b</em> c</strong>"},
+		{"**a *b***", "<strong>a <em>b</em></strong>"},
+		{"snake_case_name and 2 * 3 * 4", "snake_case_name and 2 * 3 * 4"},
+		{"**unclosed and *a", "**unclosed and *a"},
+		{"~~gone~~ ~one~", "<del>gone</del> ~one~"},
+		{"`a < b` and `` x ` y `` and ` `` `", "<code>a &lt; b</code> and <code>x ` y</code> and <code>``</code>"},
+		{"`*not em*`", "<code>*not em*</code>"},
+		{"unmatched `` tick", "unmatched `` tick"},
+		{`\*not em\* and \a`, `*not em* and \a`},
+		{"[link](http://x.io/a_(b) \"T&C\")", `<a href="http://x.io/a_(b)" title="T&amp;C">link</a>`},
+		{"[**bold** link](</a b>)", `<a href="/a b"><strong>bold</strong> link</a>`},
+		{"[a [nested] text](u)", `<a href="u">a [nested] text</a>`},
+		{"![a *cat*](cat.png 'Cat')", `<img src="cat.png" alt="a cat" title="Cat" />`},
+		{"[not a link] (x) and [x](", "[not a link] (x) and [x]("},
+		{"[x](javascript:alert(1)) [y](JavaScript:x)", `<a href="#">x</a> <a href="#">y</a>`},
+		{"<https://x.io/?a=1&b=2> <me@x.io> <not a link>", `<a href="https://x.io/?a=1&amp;b=2">https://x.io/?a=1&amp;b=2</a> <a href="mailto:me@x.io">me@x.io</a> &lt;not a link&gt;`},
+		{"a  \nb\\\nc \nd", "a<br />\nb<br />\nc\nd"},
+		{"&copy; &#169; & AT&T \"q\"", "&copy; &#169; &amp; AT&amp;T &quot;q&quot;"},
+	}
+	for _, tt := range tests {
+		want := "<p>" + tt.want + "</p>\n"
+		if got := ToHTML(tt.src); got != want {
+			t.Errorf("ToHTML(%q)\ngot:  %q\nwant: %q", tt.src, got, want)
+		}
+	}
+}
+
+func TestNestingLimit(t *testing.T) {
+	got := ToHTML(strings.Repeat(">", 40) + " a")
+	if n := strings.Count(got, "<blockquote>"); n != maxNesting {
+		t.Errorf("got %d block quotes, want %d", n, maxNesting)
+	}
+	if !strings.Contains(got, "<p>&gt;&gt;&gt;&gt;&gt;&gt;&gt;&gt; a</p>") {
+		t.Errorf("the quotes past the limit are not text: %q", got)
+	}
+
+	got = ToHTML(strings.Repeat("[", 40) + "a" + strings.Repeat("](u)", 40))
+	if n := strings.Count(got, "<a "); n != maxNesting+1 {
+		t.Errorf("got %d links, want %d", n, maxNesting+1)
+	}
+}
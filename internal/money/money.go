@@ -0,0 +1,167 @@
+// Package money formats amounts of money the way a locale writes them:
+// its decimal and grouping separators, where the currency symbol goes and
+// how negative amounts look. The conventions follow CLDR, except that
+// spaces are plain spaces rather than no-break spaces.
+package money
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency.
+type Currency struct {
+	Code string
+	// Symbol is used in the locales whose own currency this is; Intl
+	// everywhere else, so that "$" is never ambiguous.
+	Symbol, Intl string
+	// Digits is the number of minor unit digits: 2 for cents, 0 for yen.
+	Digits int
+}
+
+var currencies = map[string]Currency{
+	"USD": {"USD", "$", "US$", 2},
+	"BRL": {"BRL", "R$", "R$", 2},
+	"EUR": {"EUR", "€", "€", 2},
+	"GBP": {"GBP", "£", "£", 2},
+	"JPY": {"JPY", "¥", "¥", 0},
+	"CNY": {"CNY", "¥", "CN¥", 2},
+	"KRW": {"KRW", "₩", "₩", 0},
+	"INR": {"INR", "₹", "₹", 2},
+	"CAD": {"CAD", "$", "CA$", 2},
+	"AUD": {"AUD", "$", "A$", 2},
+	"MXN": {"MXN", "$", "MX$", 2},
+	"ARS": {"ARS", "$", "ARS", 2},
+	"CLP": {"CLP", "$", "CLP", 0},
+	"CHF": {"CHF", "CHF", "CHF", 2},
+	"SEK": {"SEK", "kr", "SEK", 2},
+	"NOK": {"NOK", "kr", "NOK", 2},
+	"DKK": {"DKK", "kr.", "DKK", 2},
+	"PLN": {"PLN", "zł", "PLN", 2},
+	"RUB": {"RUB", "₽", "RUB", 2},
+}
+
+// LookupCurrency finds a currency by its code, in any case.
+func LookupCurrency(code string) (Currency, error) {
+	if c, ok := currencies[strings.ToUpper(code)]; ok {
+		return c, nil
+	}
+	return Currency{}, fmt.Errorf("unknown currency %q (known: %s)", code, strings.Join(sortedKeys(currencies), ", "))
+}
+
+// Locale holds how a locale writes amounts of money.
+type Locale struct {
+	Name           string
+	Decimal, Group string
+	// Pattern places the symbol (¤) and the number (#), as in "¤ #" or
+	// "# ¤". NegPattern does the same for negative amounts.
+	Pattern, NegPattern string
+	// MinGroup is the number of integer digits below which the number is
+	// not grouped: 4 groups 1,234; 5 leaves 1234 alone but groups 12 345.
+	MinGroup int
+	// Indian groups by three and then by two: 12,34,567.
+	Indian bool
+	// Currency is the locale's own currency.
+	Currency string
+}
+
+var locales = map[string]Locale{
+	"en-US": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Currency: "USD"},
+	"en-GB": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Currency: "GBP"},
+	"en-CA": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Currency: "CAD"},
+	"en-AU": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Currency: "AUD"},
+	"en-IN": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Indian: true, Currency: "INR"},
+	"pt-BR": {Decimal: ",", Group: ".", Pattern: "¤ #", NegPattern: "-¤ #", Currency: "BRL"},
+	"pt-PT": {Decimal: ",", Group: " ", Pattern: "# ¤", NegPattern: "-# ¤", MinGroup: 5, Currency: "EUR"},
+	"es-ES": {Decimal: ",", Group: ".", Pattern: "# ¤", NegPattern: "-# ¤", MinGroup: 5, Currency: "EUR"},
+	"es-MX": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Currency: "MXN"},
+	"es-AR": {Decimal: ",", Group: ".", Pattern: "¤ #", NegPattern: "-¤ #", Currency: "ARS"},
+	"es-CL": {Decimal: ",", Group: ".", Pattern: "¤#", NegPattern: "¤-#", Currency: "CLP"},
+	"fr-FR": {Decimal: ",", Group: " ", Pattern: "# ¤", NegPattern: "-# ¤", Currency: "EUR"},
+	"fr-CA": {Decimal: ",", Group: " ", Pattern: "# ¤", NegPattern: "-# ¤", Currency: "CAD"},
+	"de-DE": {Decimal: ",", Group: ".", Pattern: "# ¤", NegPattern: "-# ¤", Currency: "EUR"},
+	"de-CH": {Decimal: ".", Group: "’", Pattern: "¤ #", NegPattern: "¤-#", Currency: "CHF"},
+	"it-IT": {Decimal: ",", Group: ".", Pattern: "# ¤", NegPattern: "-# ¤", Currency: "EUR"},
+	"nl-NL": {Decimal: ",", Group: ".", Pattern: "¤ #", NegPattern: "¤ -#", Currency: "EUR"},
+	"ja-JP": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Currency: "JPY"},
+	"zh-CN": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Currency: "CNY"},
+	"ko-KR": {Decimal: ".", Group: ",", Pattern: "¤#", NegPattern: "-¤#", Currency: "KRW"},
+	"sv-SE": {Decimal: ",", Group: " ", Pattern: "# ¤", NegPattern: "-# ¤", Currency: "SEK"},
+	"nb-NO": {Decimal: ",", Group: " ", Pattern: "# ¤", NegPattern: "-# ¤", Currency: "NOK"},
+	"da-DK": {Decimal: ",", Group: ".", Pattern: "# ¤", NegPattern: "-# ¤", Currency: "DKK"},
+	"pl-PL": {Decimal: ",", Group: " ", Pattern: "# ¤", NegPattern: "-# ¤", MinGroup: 5, Currency: "PLN"},
+	"ru-RU": {Decimal: ",", Group: " ", Pattern: "# ¤", NegPattern: "-# ¤", Currency: "RUB"},
+}
+
+// LookupLocale finds a locale by its tag. Case does not matter and "_"
+// may separate the parts: "pt_br" is "pt-BR".
+func LookupLocale(tag string) (Locale, error) {
+	name := strings.ReplaceAll(tag, "_", "-")
+	if i := strings.Index(name, "-"); i >= 0 {
+		name = strings.ToLower(name[:i]) + "-" + strings.ToUpper(name[i+1:])
+	}
+	if l, ok := locales[name]; ok {
+		l.Name = name
+		return l, nil
+	}
+	return Locale{}, fmt.Errorf("unknown locale %q (known: %s)", tag, strings.Join(sortedKeys(locales), ", "))
+}
+
+// Format writes amount, a plain decimal such as "-1234.50" already
+// rounded to the currency's digits, in the locale's style.
+func (l Locale) Format(amount string, c Currency) string {
+	pattern := l.Pattern
+	if strings.HasPrefix(amount, "-") {
+		amount = amount[1:]
+		if strings.Trim(amount, "0.") != "" {
+			pattern = l.NegPattern
+		}
+	}
+	intPart, frac := amount, ""
+	if i := strings.Index(amount, "."); i >= 0 {
+		intPart, frac = amount[:i], amount[i+1:]
+	}
+	number := l.group(intPart)
+	if frac != "" {
+		number += l.Decimal + frac
+	}
+	symbol := c.Intl
+	if c.Code == l.Currency {
+		symbol = c.Symbol
+	}
+	return strings.NewReplacer("¤", symbol, "#", number).Replace(pattern)
+}
+
+func (l Locale) group(digits string) string {
+	minGroup := l.MinGroup
+	if minGroup == 0 {
+		minGroup = 4
+	}
+	if len(digits) < minGroup {
+		return digits
+	}
+	var groups []string
+	size := 3
+	for len(digits) > size {
+		groups = append(groups, digits[len(digits)-size:])
+		digits = digits[:len(digits)-size]
+		if l.Indian {
+			size = 2
+		}
+	}
+	groups = append(groups, digits)
+	for i, j := 0, len(groups)-1; i < j; i, j = i+1, j-1 {
+		groups[i], groups[j] = groups[j], groups[i]
+	}
+	return strings.Join(groups, l.Group)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+package money
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount, currency, locale, want string
+	}{
+		{"1234.56", "USD", "en-US", "$1,234.56"},
+		{"-1234.56", "USD", "en-US", "-$1,234.56"},
+		{"1234.56", "BRL", "pt-BR", "R$ 1.234,56"},
+		{"-0.50", "BRL", "pt-BR", "-R$ 0,50"},
+		{"1234.56", "USD", "pt-BR", "US$ 1.234,56"},
+		{"1234.56", "BRL", "en-US", "R$1,234.56"},
+		{"1234567.89", "EUR", "de-DE", "1.234.567,89 €"},
+		{"1234.56", "EUR", "fr-FR", "1 234,56 €"},
+		{"1234.56", "EUR", "es-ES", "1234,56 €"},
+		{"12345.67", "EUR", "es-ES", "12.345,67 €"},
+		{"-1234.56", "EUR", "nl-NL", "€ -1.234,56"},
+		{"-1234.56", "CHF", "de-CH", "CHF-1’234.56"},
+		{"1234567", "JPY", "ja-JP", "¥1,234,567"},
+		{"1234567.89", "INR", "en-IN", "₹12,34,567.89"},
+		{"1234.56", "CAD", "en-US", "CA$1,234.56"},
+		{"999.00", "GBP", "en-GB", "£999.00"},
+		{"-0.00", "USD", "en-US", "$0.00"},
+	}
+	for _, tt := range tests {
+		c, err := LookupCurrency(tt.currency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := LookupLocale(tt.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.Format(tt.amount, c); got != tt.want {
+			t.Errorf("Format(%q, %s, %s) = %q, want %q", tt.amount, tt.currency, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if c, err := LookupCurrency("brl"); err != nil || c.Code != "BRL" {
+		t.Errorf("LookupCurrency(brl) = %v, %v", c, err)
+	}
+	if l, err := LookupLocale("pt_br"); err != nil || l.Name != "pt-BR" {
+		t.Errorf("LookupLocale(pt_br) = %v, %v", l, err)
+	}
+	if _, err := LookupCurrency("XYZ"); err == nil {
+		t.Error("LookupCurrency(XYZ) succeeded")
+	}
+	if _, err := LookupLocale("pt"); err == nil {
+		t.Error("LookupLocale(pt) succeeded")
+	}
+}
@@ -0,0 +1,80 @@
+package native
+
+import (
+	"noxy-vm/internal/value"
+	"strings"
+)
+
+// CheckArgs checks args against the parameter types of a native. When
+// they do not match it returns false and a native error listing both, such
+// as "expects (string, int), got (int, string)", which the VM reports with
+// the native's name and the line of the call.
+//
+// A parameter type is a name as value.TypeName spells it ("string", "int",
+// "bytes", "array", "map", "function", ...), or one of:
+//
+//	any        any value
+//	number     int or float
+//	struct     a struct definition, as passed to the natives that build
+//	           result instances
+//	instance   an instance of any struct
+//	a|b        either type
+//	int?       an optional trailing parameter
+//	...any     any number of further arguments (last parameter only)
+func CheckArgs(args []value.Value, params ...string) (value.Value, bool) {
+	if argsMatch(args, params) {
+		return value.Value{}, true
+	}
+	got := make([]string, len(args))
+	for i, arg := range args {
+		got[i] = value.TypeName(arg)
+	}
+	return value.NewNativeError("expects (%s), got (%s)", strings.Join(params, ", "), strings.Join(got, ", ")), false
+}
+
+func argsMatch(args []value.Value, params []string) bool {
+	for i, param := range params {
+		if rest, variadic := strings.CutPrefix(param, "..."); variadic {
+			for _, arg := range args[min(i, len(args)):] {
+				if !typeMatches(arg, rest) {
+					return false
+				}
+			}
+			return true
+		}
+		if i >= len(args) {
+			// Only optional parameters may be left out
+			return strings.HasSuffix(param, "?") && argsMatch(nil, params[i+1:])
+		}
+		if !typeMatches(args[i], strings.TrimSuffix(param, "?")) {
+			return false
+		}
+	}
+	return len(args) <= len(params)
+}
+
+func typeMatches(v value.Value, want string) bool {
+	for _, t := range strings.Split(want, "|") {
+		switch t {
+		case "any":
+			return true
+		case "number":
+			if v.Type == value.VAL_INT || v.Type == value.VAL_FLOAT {
+				return true
+			}
+		case "struct":
+			if _, ok := v.Obj.(*value.ObjStruct); ok {
+				return true
+			}
+		case "instance":
+			if _, ok := v.Obj.(*value.ObjInstance); ok {
+				return true
+			}
+		default:
+			if value.TypeName(v) == t {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+// Package binary provides the binary module: packing values into bytes
+// and unpacking them again by a format string, in the style of Python's
+// struct module, for binary file formats and network protocols.
+package binary
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+)
+
+// field is one value (or, for x, padding) of a format.
+type field struct {
+	code byte
+	size int // bytes; for s the length of the string
+}
+
+// format is a parsed format string.
+type format struct {
+	order  binary.ByteOrder
+	fields []field
+}
+
+var codeSizes = map[byte]int{
+	'x': 1, '?': 1,
+	'b': 1, 'B': 1, 'h': 2, 'H': 2, 'i': 4, 'I': 4, 'q': 8, 'Q': 8,
+	'f': 4, 'd': 8,
+}
+
+// parseFormat parses a format: an optional byte order (< little-endian,
+// > or ! big-endian, the default) followed by codes, each optionally
+// preceded by a count. A count repeats the code, except for s, where it
+// is the length of the string. Spaces are ignored.
+func parseFormat(s string) (*format, error) {
+	f := &format{order: binary.BigEndian}
+	if len(s) > 0 {
+		switch s[0] {
+		case '<':
+			f.order = binary.LittleEndian
+			s = s[1:]
+		case '>', '!':
+			s = s[1:]
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' {
+			continue
+		}
+		count, counted := 0, false
+		for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+			count = count*10 + int(s[i]-'0')
+			counted = true
+			if count > 1<<20 {
+				return nil, fmt.Errorf("count too large in format")
+			}
+		}
+		if i == len(s) {
+			return nil, fmt.Errorf("format ends with a count")
+		}
+		if !counted {
+			count = 1
+		}
+		c := s[i]
+		if c == 's' {
+			f.fields = append(f.fields, field{code: c, size: count})
+			continue
+		}
+		size, ok := codeSizes[c]
+		if !ok {
+			return nil, fmt.Errorf("unknown format code '%c'", c)
+		}
+		for ; count > 0; count-- {
+			f.fields = append(f.fields, field{code: c, size: size})
+		}
+	}
+	return f, nil
+}
+
+// size is the number of bytes the format packs into.
+func (f *format) size() int {
+	n := 0
+	for _, fd := range f.fields {
+		n += fd.size
+	}
+	return n
+}
+
+// values is the number of values the format packs.
+func (f *format) values() int {
+	n := 0
+	for _, fd := range f.fields {
+		if fd.code != 'x' {
+			n++
+		}
+	}
+	return n
+}
+
+// intRanges are the bounds of the integer codes other than Q.
+var intRanges = map[byte][2]int64{
+	'b': {math.MinInt8, math.MaxInt8}, 'B': {0, math.MaxUint8},
+	'h': {math.MinInt16, math.MaxInt16}, 'H': {0, math.MaxUint16},
+	'i': {math.MinInt32, math.MaxInt32}, 'I': {0, math.MaxUint32},
+	'q': {math.MinInt64, math.MaxInt64},
+}
+
+func (f *format) pack(vals []value.Value) ([]byte, error) {
+	if len(vals) != f.values() {
+		return nil, fmt.Errorf("format packs %d values, got %d", f.values(), len(vals))
+	}
+	out := make([]byte, f.size())
+	pos, vi := 0, 0
+	for _, fd := range f.fields {
+		buf := out[pos : pos+fd.size]
+		pos += fd.size
+		if fd.code == 'x' {
+			continue
+		}
+		v := vals[vi]
+		vi++
+		bad := func() error {
+			return fmt.Errorf("value %d: '%c' cannot pack %s", vi-1, fd.code, value.TypeName(v))
+		}
+		switch fd.code {
+		case '?':
+			if v.Type != value.VAL_BOOL {
+				return nil, bad()
+			}
+			if v.AsBool {
+				buf[0] = 1
+			}
+		case 's':
+			s, ok := v.Obj.(string)
+			if !ok || (v.Type != value.VAL_OBJ && v.Type != value.VAL_BYTES) {
+				return nil, bad()
+			}
+			copy(buf, s) // padded with zero bytes, or truncated
+		case 'f', 'd':
+			var x float64
+			switch v.Type {
+			case value.VAL_FLOAT:
+				x = v.AsFloat
+			case value.VAL_INT:
+				x = float64(v.AsInt)
+			default:
+				return nil, bad()
+			}
+			if fd.code == 'f' {
+				f.order.PutUint32(buf, math.Float32bits(float32(x)))
+			} else {
+				f.order.PutUint64(buf, math.Float64bits(x))
+			}
+		case 'Q':
+			var n uint64
+			switch {
+			case v.Type == value.VAL_INT && v.AsInt >= 0:
+				n = uint64(v.AsInt)
+			case isBigInt(v):
+				b := v.Obj.(*value.ObjBigInt).Value
+				if b.Sign() < 0 || !b.IsUint64() {
+					return nil, fmt.Errorf("value %d: %s does not fit in 'Q'", vi-1, b)
+				}
+				n = b.Uint64()
+			case v.Type == value.VAL_INT:
+				return nil, fmt.Errorf("value %d: %d does not fit in 'Q'", vi-1, v.AsInt)
+			default:
+				return nil, bad()
+			}
+			f.order.PutUint64(buf, n)
+		default:
+			if v.Type != value.VAL_INT {
+				return nil, bad()
+			}
+			r := intRanges[fd.code]
+			if v.AsInt < r[0] || v.AsInt > r[1] {
+				return nil, fmt.Errorf("value %d: %d does not fit in '%c'", vi-1, v.AsInt, fd.code)
+			}
+			putInt(f.order, buf, uint64(v.AsInt))
+		}
+	}
+	return out, nil
+}
+
+func (f *format) unpack(data []byte) []value.Value {
+	var vals []value.Value
+	pos := 0
+	for _, fd := range f.fields {
+		buf := data[pos : pos+fd.size]
+		pos += fd.size
+		switch fd.code {
+		case 'x':
+		case '?':
+			vals = append(vals, value.NewBool(buf[0] != 0))
+		case 's':
+			vals = append(vals, value.NewString(string(buf)))
+		case 'f':
+			vals = append(vals, value.NewFloat(float64(math.Float32frombits(f.order.Uint32(buf)))))
+		case 'd':
+			vals = append(vals, value.NewFloat(math.Float64frombits(f.order.Uint64(buf))))
+		case 'b':
+			vals = append(vals, value.NewInt(int64(int8(buf[0]))))
+		case 'B':
+			vals = append(vals, value.NewInt(int64(buf[0])))
+		case 'h':
+			vals = append(vals, value.NewInt(int64(int16(f.order.Uint16(buf)))))
+		case 'H':
+			vals = append(vals, value.NewInt(int64(f.order.Uint16(buf))))
+		case 'i':
+			vals = append(vals, value.NewInt(int64(int32(f.order.Uint32(buf)))))
+		case 'I':
+			vals = append(vals, value.NewInt(int64(f.order.Uint32(buf))))
+		case 'q':
+			vals = append(vals, value.NewInt(int64(f.order.Uint64(buf))))
+		case 'Q':
+			// Values past the int range come back as bigints
+			n := f.order.Uint64(buf)
+			if n > math.MaxInt64 {
+				vals = append(vals, value.NewBigInt(new(big.Int).SetUint64(n)))
+			} else {
+				vals = append(vals, value.NewInt(int64(n)))
+			}
+		}
+	}
+	return vals
+}
+
+func putInt(order binary.ByteOrder, buf []byte, n uint64) {
+	switch len(buf) {
+	case 1:
+		buf[0] = byte(n)
+	case 2:
+		order.PutUint16(buf, uint16(n))
+	case 4:
+		order.PutUint32(buf, uint32(n))
+	case 8:
+		order.PutUint64(buf, n)
+	}
+}
+
+func isBigInt(v value.Value) bool {
+	_, ok := v.Obj.(*value.ObjBigInt)
+	return ok
+}
+
+// Register adds the binary natives to r.
+func Register(r native.Registry) {
+	// binary_pack(format, values) -> bytes
+	r.DefineModuleNative("binary", "pack", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "array"); !ok {
+			return err
+		}
+		f, err := parseFormat(args[0].String())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		out, err := f.pack(args[1].Obj.(*value.ObjArray).Values())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewBytes(string(out))
+	})
+
+	// binary_unpack(format, data, offset?) -> any[]
+	// Reads the values at offset (default 0); data may extend past them.
+	r.DefineModuleNative("binary", "unpack", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "bytes", "int?"); !ok {
+			return err
+		}
+		f, err := parseFormat(args[0].String())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		data := []byte(args[1].Obj.(string))
+		offset := 0
+		if len(args) > 2 {
+			offset = int(args[2].AsInt)
+		}
+		if offset < 0 || offset > len(data) || len(data)-offset < f.size() {
+			return value.NewNativeError("format needs %d bytes at offset %d, have %d", f.size(), offset, len(data))
+		}
+		return value.NewArray(f.unpack(data[offset:]))
+	})
+
+	// binary_size(format) -> int
+	r.DefineModuleNative("binary", "size", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		f, err := parseFormat(args[0].String())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewInt(int64(f.size()))
+	})
+}
@@ -0,0 +1,111 @@
+// Package bytes provides the bytes natives: searching and splitting byte
+// strings, and packing integers for binary protocols. bytes is a type
+// name, so scripts call them by their flat names (bytes_find).
+package bytes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+)
+
+// Register adds the bytes natives to r.
+func Register(r native.Registry) {
+	// bytes_find(data, sub, start?) -> int
+	// Index of the first sub at or after start, or -1.
+	r.DefineModuleNative("bytes", "find", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "bytes", "bytes", "int?"); !ok {
+			return err
+		}
+		data := args[0].Obj.(string)
+		start := 0
+		if len(args) > 2 {
+			start = int(args[2].AsInt)
+		}
+		if start < 0 || start > len(data) {
+			return value.NewNativeError("start %d out of range for %d bytes", start, len(data))
+		}
+		i := bytes.Index([]byte(data[start:]), []byte(args[1].Obj.(string)))
+		if i >= 0 {
+			i += start
+		}
+		return value.NewInt(int64(i))
+	})
+
+	// bytes_split(data, sep) -> bytes[]
+	r.DefineModuleNative("bytes", "split", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "bytes", "bytes"); !ok {
+			return err
+		}
+		if args[1].Obj.(string) == "" {
+			return value.NewNativeError("empty separator")
+		}
+		parts := bytes.Split([]byte(args[0].Obj.(string)), []byte(args[1].Obj.(string)))
+		elements := make([]value.Value, len(parts))
+		for i, part := range parts {
+			elements[i] = value.NewBytes(string(part))
+		}
+		return value.NewArray(elements)
+	})
+
+	// bytes_to_array(data) -> int[]
+	r.DefineModuleNative("bytes", "to_array", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "bytes"); !ok {
+			return err
+		}
+		data := args[0].Obj.(string)
+		elements := make([]value.Value, len(data))
+		for i := 0; i < len(data); i++ {
+			elements[i] = value.NewInt(int64(data[i]))
+		}
+		return value.NewArray(elements)
+	})
+
+	// bytes_pack_u16be(n) -> bytes, bytes_unpack_u16be(data, offset?) -> int
+	// and the same for u16le, u32be and u32le.
+	for _, enc := range []struct {
+		name  string
+		size  int
+		order binary.ByteOrder
+	}{
+		{"u16be", 2, binary.BigEndian},
+		{"u16le", 2, binary.LittleEndian},
+		{"u32be", 4, binary.BigEndian},
+		{"u32le", 4, binary.LittleEndian},
+	} {
+		r.DefineModuleNative("bytes", "pack_"+enc.name, func(args []value.Value) value.Value {
+			if err, ok := native.CheckArgs(args, "int"); !ok {
+				return err
+			}
+			n := args[0].AsInt
+			if n < 0 || n >= 1<<(8*enc.size) {
+				return value.NewNativeError("%d does not fit in %d bytes", n, enc.size)
+			}
+			buf := make([]byte, enc.size)
+			if enc.size == 2 {
+				enc.order.PutUint16(buf, uint16(n))
+			} else {
+				enc.order.PutUint32(buf, uint32(n))
+			}
+			return value.NewBytes(string(buf))
+		})
+		r.DefineModuleNative("bytes", "unpack_"+enc.name, func(args []value.Value) value.Value {
+			if err, ok := native.CheckArgs(args, "bytes", "int?"); !ok {
+				return err
+			}
+			data := []byte(args[0].Obj.(string))
+			offset := 0
+			if len(args) > 1 {
+				offset = int(args[1].AsInt)
+			}
+			if offset < 0 || offset+enc.size > len(data) {
+				return value.NewNativeError("need %d bytes at offset %d, have %d", enc.size, offset, len(data))
+			}
+			if enc.size == 2 {
+				return value.NewInt(int64(enc.order.Uint16(data[offset:])))
+			}
+			return value.NewInt(int64(enc.order.Uint32(data[offset:])))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Package checksum provides the checksum module: fast non-cryptographic
+// checksums and hashes (CRC-32, Adler-32, FNV-1a) for integrity checks
+// and hash-based sharding. The crypto module has the cryptographic ones.
+package checksum
+
+import (
+	"hash/adler32"
+	"hash/crc32"
+	"hash/fnv"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// data returns the contents of a string or bytes argument.
+func data(v value.Value) []byte {
+	return []byte(v.Obj.(string))
+}
+
+// Register adds the checksum natives to r.
+func Register(r native.Registry) {
+	// checksum_crc32(data, crc?) -> int
+	// IEEE CRC-32 of data. Passing the CRC of the data so far continues
+	// it, so a large file can be checked in chunks.
+	r.DefineModuleNative("checksum", "crc32", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string|bytes", "int?"); !ok {
+			return err
+		}
+		var crc uint32
+		if len(args) > 1 {
+			crc = uint32(args[1].AsInt)
+		}
+		return value.NewInt(int64(crc32.Update(crc, crc32.IEEETable, data(args[0]))))
+	})
+
+	// checksum_crc32c(data, crc?) -> int
+	// CRC-32 with the Castagnoli polynomial, as used by iSCSI, ext4 and
+	// many storage formats.
+	r.DefineModuleNative("checksum", "crc32c", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string|bytes", "int?"); !ok {
+			return err
+		}
+		var crc uint32
+		if len(args) > 1 {
+			crc = uint32(args[1].AsInt)
+		}
+		return value.NewInt(int64(crc32.Update(crc, castagnoli, data(args[0]))))
+	})
+
+	// checksum_adler32(data) -> int
+	r.DefineModuleNative("checksum", "adler32", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string|bytes"); !ok {
+			return err
+		}
+		return value.NewInt(int64(adler32.Checksum(data(args[0]))))
+	})
+
+	// checksum_fnv32a(data) -> int
+	r.DefineModuleNative("checksum", "fnv32a", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string|bytes"); !ok {
+			return err
+		}
+		h := fnv.New32a()
+		h.Write(data(args[0]))
+		return value.NewInt(int64(h.Sum32()))
+	})
+
+	// checksum_fnv64a(data) -> int
+	// The 64 bits of the hash as an int, so about half the hashes are
+	// negative.
+	r.DefineModuleNative("checksum", "fnv64a", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string|bytes"); !ok {
+			return err
+		}
+		h := fnv.New64a()
+		h.Write(data(args[0]))
+		return value.NewInt(int64(h.Sum64()))
+	})
+}
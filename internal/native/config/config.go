@@ -0,0 +1,413 @@
+// Package config provides the config module, which builds a program's
+// configuration from layers: defaults, a JSON or TOML file, environment
+// variables and command-line flags, each overriding the ones before it.
+// The defaults decide which keys exist and what type each has.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Register adds the config natives to r.
+func Register(r native.Registry) {
+	// config_load(defaults, options?) -> map
+	// options: "file" (a .json or .toml file), "file_required" (default
+	// true), "env_prefix" (reads PREFIX_KEY variables when set) and
+	// "args" (the flags; the script's arguments by default).
+	r.DefineModuleNative("config", "load", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "map", "map?"); !ok {
+			return err
+		}
+		defaults := args[0].Obj.(*value.ObjMap)
+		opts := options{fileRequired: true, args: r.ScriptArgs()}
+		if len(args) > 1 {
+			if err := opts.parse(args[1].Obj.(*value.ObjMap)); err != nil {
+				return value.NewNativeError("%s", err)
+			}
+		}
+		cfg, err := load(defaults, opts, r.ResolvePath)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.Value{Type: value.VAL_OBJ, Obj: cfg}
+	})
+}
+
+type options struct {
+	file         string
+	fileRequired bool
+	envPrefix    string
+	useEnv       bool
+	args         []string
+}
+
+func (o *options) parse(m *value.ObjMap) error {
+	for _, k := range m.Keys {
+		v := m.Data[k]
+		str, isStr := v.Obj.(string)
+		isStr = isStr && v.Type == value.VAL_OBJ
+		switch k {
+		case "file":
+			if !isStr {
+				return fmt.Errorf("option file must be a string, got %s", value.TypeName(v))
+			}
+			o.file = str
+		case "file_required":
+			if v.Type != value.VAL_BOOL {
+				return fmt.Errorf("option file_required must be a bool, got %s", value.TypeName(v))
+			}
+			o.fileRequired = v.AsBool
+		case "env_prefix":
+			if !isStr {
+				return fmt.Errorf("option env_prefix must be a string, got %s", value.TypeName(v))
+			}
+			o.envPrefix, o.useEnv = str, true
+		case "args":
+			arr, ok := v.Obj.(*value.ObjArray)
+			if !ok {
+				return fmt.Errorf("option args must be a string array, got %s", value.TypeName(v))
+			}
+			o.args = make([]string, arr.Len())
+			for i, el := range arr.Values() {
+				o.args[i] = el.String()
+			}
+		default:
+			return fmt.Errorf("unknown option %v", k)
+		}
+	}
+	return nil
+}
+
+// leaf is a configuration key that holds a value rather than a table.
+type leaf struct {
+	path []string
+	def  value.Value
+}
+
+func (l leaf) name() string { return strings.Join(l.path, ".") }
+
+// load applies the layers in order of precedence over a copy of defaults.
+func load(defaults *value.ObjMap, opts options, resolve func(string) string) (*value.ObjMap, error) {
+	cfg := copyValue(value.Value{Type: value.VAL_OBJ, Obj: defaults}).Obj.(*value.ObjMap)
+	leaves := collectLeaves(defaults, nil, nil)
+
+	if opts.file != "" {
+		data, err := readFile(resolve(opts.file))
+		switch {
+		case os.IsNotExist(err) && !opts.fileRequired:
+		case err != nil:
+			return nil, err
+		default:
+			if err := mergeTable(defaults, cfg, data, nil); err != nil {
+				return nil, fmt.Errorf("%s: %s", opts.file, err)
+			}
+		}
+	}
+
+	if opts.useEnv {
+		for _, l := range leaves {
+			name := envName(opts.envPrefix, l.path)
+			s, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			v, err := parseString(l.def, s, l.name())
+			if err != nil {
+				return nil, fmt.Errorf("environment variable %s: %s", name, err)
+			}
+			set(cfg, l.path, v)
+		}
+	}
+
+	return cfg, applyFlags(cfg, leaves, opts.args)
+}
+
+// readFile parses a .json or .toml file into a map.
+func readFile(path string) (*value.ObjMap, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(src, &data); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	case ".toml":
+		t, err := parseTOML(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		data = t
+	default:
+		return nil, fmt.Errorf("%s: unknown config file format (use .json or .toml)", path)
+	}
+	m, ok := fromGo(data).Obj.(*value.ObjMap)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected an object at the top level", path)
+	}
+	return m, nil
+}
+
+// fromGo converts decoded JSON or TOML into Noxy values.
+func fromGo(i interface{}) value.Value {
+	switch v := i.(type) {
+	case int64:
+		return value.NewInt(v)
+	case []interface{}:
+		arr := make([]value.Value, len(v))
+		for idx, el := range v {
+			arr[idx] = fromGo(el)
+		}
+		return value.NewArray(arr)
+	case map[string]interface{}:
+		m := make(map[string]value.Value, len(v))
+		for k, el := range v {
+			m[k] = fromGo(el)
+		}
+		return value.NewMapWithData(m)
+	}
+	return value.FromJSON(i)
+}
+
+// collectLeaves lists the keys of defaults that are not tables. An empty
+// map is a free-form table, which only the file can fill.
+func collectLeaves(defaults *value.ObjMap, prefix []string, out []leaf) []leaf {
+	for _, k := range defaults.Keys {
+		key := fmt.Sprint(k)
+		path := append(append([]string(nil), prefix...), key)
+		v := defaults.Data[k]
+		if m, ok := v.Obj.(*value.ObjMap); ok {
+			out = collectLeaves(m, path, out)
+			continue
+		}
+		out = append(out, leaf{path: path, def: v})
+	}
+	return out
+}
+
+// mergeTable sets the keys of data in cfg, checking them against the
+// table defaults.
+func mergeTable(defaults, cfg, data *value.ObjMap, path []string) error {
+	for _, k := range data.Keys {
+		key := fmt.Sprint(k)
+		name := strings.Join(append(append([]string(nil), path...), key), ".")
+		v := data.Data[k]
+		def, known := defaults.Get(k)
+		if !known {
+			if len(defaults.Keys) == 0 {
+				cfg.Set(k, v)
+				continue
+			}
+			return fmt.Errorf("unknown key %s", name)
+		}
+		if defMap, ok := def.Obj.(*value.ObjMap); ok {
+			vMap, ok := v.Obj.(*value.ObjMap)
+			if !ok {
+				return fmt.Errorf("%s: expected a table, got %s", name, value.TypeName(v))
+			}
+			sub, _ := cfg.Get(k)
+			if err := mergeTable(defMap, sub.Obj.(*value.ObjMap), vMap, append(path, key)); err != nil {
+				return err
+			}
+			continue
+		}
+		conv, err := convert(def, v, name)
+		if err != nil {
+			return err
+		}
+		cfg.Set(k, conv)
+	}
+	return nil
+}
+
+// convert checks a value from the file against the type of its default.
+// Ints are accepted for floats, and whole floats for ints.
+func convert(def, v value.Value, name string) (value.Value, error) {
+	mismatch := fmt.Errorf("%s: expected %s, got %s", name, value.TypeName(def), value.TypeName(v))
+	switch def.Type {
+	case value.VAL_NULL:
+		return v, nil
+	case value.VAL_INT:
+		switch {
+		case v.Type == value.VAL_INT:
+			return v, nil
+		case v.Type == value.VAL_FLOAT && v.AsFloat == float64(int64(v.AsFloat)):
+			return value.NewInt(int64(v.AsFloat)), nil
+		}
+		return value.Value{}, mismatch
+	case value.VAL_FLOAT:
+		switch v.Type {
+		case value.VAL_FLOAT:
+			return v, nil
+		case value.VAL_INT:
+			return value.NewFloat(float64(v.AsInt)), nil
+		}
+		return value.Value{}, mismatch
+	}
+	if defArr, ok := def.Obj.(*value.ObjArray); ok {
+		arr, ok := v.Obj.(*value.ObjArray)
+		if !ok {
+			return value.Value{}, mismatch
+		}
+		elems := make([]value.Value, arr.Len())
+		for i, el := range arr.Values() {
+			conv, err := convert(elemDefault(defArr), el, fmt.Sprintf("%s[%d]", name, i))
+			if err != nil {
+				return value.Value{}, err
+			}
+			elems[i] = conv
+		}
+		return value.NewArray(elems), nil
+	}
+	if value.TypeName(v) != value.TypeName(def) {
+		return value.Value{}, mismatch
+	}
+	return v, nil
+}
+
+// elemDefault is the value that elements of an array default are typed
+// like: its first element, or null (any type) for an empty array.
+func elemDefault(arr *value.ObjArray) value.Value {
+	if arr.Len() == 0 {
+		return value.NewNull()
+	}
+	return arr.At(0)
+}
+
+// parseString parses a value from the environment or a flag as the type
+// of its default. Arrays are comma-separated.
+func parseString(def value.Value, s, name string) (value.Value, error) {
+	switch def.Type {
+	case value.VAL_NULL:
+		return value.NewString(s), nil
+	case value.VAL_INT:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 0, 64)
+		if err != nil {
+			return value.Value{}, fmt.Errorf("%s: %q is not an int", name, s)
+		}
+		return value.NewInt(n), nil
+	case value.VAL_FLOAT:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return value.Value{}, fmt.Errorf("%s: %q is not a float", name, s)
+		}
+		return value.NewFloat(f), nil
+	case value.VAL_BOOL:
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "true", "1", "yes", "on":
+			return value.NewBool(true), nil
+		case "false", "0", "no", "off":
+			return value.NewBool(false), nil
+		}
+		return value.Value{}, fmt.Errorf("%s: %q is not a bool", name, s)
+	}
+	switch d := def.Obj.(type) {
+	case string:
+		return value.NewString(s), nil
+	case *value.ObjArray:
+		var elems []value.Value
+		if strings.TrimSpace(s) != "" {
+			for i, part := range strings.Split(s, ",") {
+				el, err := parseString(elemDefault(d), strings.TrimSpace(part), fmt.Sprintf("%s[%d]", name, i))
+				if err != nil {
+					return value.Value{}, err
+				}
+				elems = append(elems, el)
+			}
+		}
+		return value.NewArray(elems), nil
+	}
+	return value.Value{}, fmt.Errorf("%s: a %s cannot be set from text", name, value.TypeName(def))
+}
+
+// applyFlags sets the keys given as --key=value, --key value, --flag
+// (true) or --no-flag (false). Keys are the dotted names of the defaults,
+// with - and _ interchangeable. Arguments not starting with -- are
+// skipped, and -- ends the flags.
+func applyFlags(cfg *value.ObjMap, leaves []leaf, args []string) error {
+	norm := func(s string) string { return strings.ReplaceAll(s, "_", "-") }
+	byFlag := make(map[string]leaf, len(leaves))
+	for _, l := range leaves {
+		byFlag[norm(l.name())] = l
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return nil
+		}
+		name, val, hasVal := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		l, ok := byFlag[norm(name)]
+		if !ok {
+			if neg, isNeg := byFlag[norm(strings.TrimPrefix(name, "no-"))]; isNeg && !hasVal && strings.HasPrefix(name, "no-") && neg.def.Type == value.VAL_BOOL {
+				set(cfg, neg.path, value.NewBool(false))
+				continue
+			}
+			return fmt.Errorf("unknown flag --%s", name)
+		}
+		if !hasVal {
+			switch {
+			case l.def.Type == value.VAL_BOOL:
+				val = "true"
+			case i+1 < len(args):
+				i++
+				val = args[i]
+			default:
+				return fmt.Errorf("flag --%s needs a value", name)
+			}
+		}
+		v, err := parseString(l.def, val, l.name())
+		if err != nil {
+			return fmt.Errorf("flag --%s", err)
+		}
+		set(cfg, l.path, v)
+	}
+	return nil
+}
+
+// envName is the environment variable for a key: db.max_conns with
+// prefix APP_ is APP_DB_MAX_CONNS.
+func envName(prefix string, path []string) string {
+	name := strings.ToUpper(strings.Join(path, "_"))
+	return prefix + strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+// set stores v at path in cfg, whose tables already exist.
+func set(cfg *value.ObjMap, path []string, v value.Value) {
+	for _, key := range path[:len(path)-1] {
+		next, _ := cfg.Get(key)
+		cfg = next.Obj.(*value.ObjMap)
+	}
+	cfg.Set(path[len(path)-1], v)
+}
+
+// copyValue copies maps and arrays deeply, so the result does not share
+// them with the defaults.
+func copyValue(v value.Value) value.Value {
+	switch o := v.Obj.(type) {
+	case *value.ObjMap:
+		m := &value.ObjMap{Data: make(map[interface{}]value.Value, len(o.Data))}
+		for _, k := range o.Keys {
+			m.Set(k, copyValue(o.Data[k]))
+		}
+		return value.Value{Type: value.VAL_OBJ, Obj: m}
+	case *value.ObjArray:
+		elems := make([]value.Value, o.Len())
+		for i, el := range o.Values() {
+			elems[i] = copyValue(el)
+		}
+		return value.NewArray(elems)
+	}
+	return v
+}
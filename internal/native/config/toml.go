@@ -0,0 +1,490 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses the TOML that configuration files use: key/value
+// pairs, [tables], [[arrays of tables]], dotted and quoted keys, basic
+// and literal strings (also multi-line), integers, floats, booleans,
+// arrays and inline tables. Dates and times are kept as strings.
+//
+// Integers are int64 and floats float64; tables are map[string]any and
+// arrays []any.
+func parseTOML(src string) (map[string]interface{}, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := make(map[string]interface{})
+	current := root
+	// Tables defined by a [header], which may not be defined again
+	defined := make(map[string]bool)
+
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return root, nil
+		}
+		if p.peek() == '[' {
+			array := strings.HasPrefix(p.src[p.pos:], "[[")
+			if array {
+				p.pos += 2
+			} else {
+				p.pos++
+			}
+			p.skipBlank(false)
+			path, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipBlank(false)
+			end := "]"
+			if array {
+				end = "]]"
+			}
+			if !strings.HasPrefix(p.src[p.pos:], end) {
+				return nil, p.errorf("expected %s after table name", end)
+			}
+			p.pos += len(end)
+			name := strings.Join(path, ".")
+			if array {
+				current, err = appendTable(root, path)
+			} else {
+				if defined[name] {
+					return nil, p.errorf("table [%s] defined twice", name)
+				}
+				defined[name] = true
+				current, err = subTable(root, path)
+			}
+			if err != nil {
+				return nil, p.errorf("%s", err)
+			}
+		} else if err := p.parseKeyValue(current); err != nil {
+			return nil, err
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipBlank skips spaces, tabs and comments, and newlines too when
+// newlines is set.
+func (p *tomlParser) skipBlank(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipBlank(false)
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return p.errorf("unexpected %q", p.peek())
+	}
+	return nil
+}
+
+// parseKeyValue parses key = value into table.
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	path, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipBlank(false)
+	if p.peek() != '=' {
+		return p.errorf("expected = after key %s", strings.Join(path, "."))
+	}
+	p.pos++
+	p.skipBlank(false)
+	val, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	parent, err := subTable(table, path[:len(path)-1])
+	if err != nil {
+		return p.errorf("%s", err)
+	}
+	last := path[len(path)-1]
+	if _, exists := parent[last]; exists {
+		return p.errorf("key %s defined twice", strings.Join(path, "."))
+	}
+	parent[last] = val
+	return nil
+}
+
+// parseKey parses a possibly dotted key into its parts.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var path []string
+	for {
+		p.skipBlank(false)
+		var part string
+		switch p.peek() {
+		case '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		case '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a key")
+			}
+			part = p.src[start:p.pos]
+		}
+		path = append(path, part)
+		p.skipBlank(false)
+		if p.peek() != '.' {
+			return path, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	rest := p.src[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, `"""`):
+		return p.parseMultilineString(`"""`)
+	case strings.HasPrefix(rest, "'''"):
+		return p.parseMultilineString("'''")
+	case p.peek() == '"':
+		return p.parseBasicString()
+	case p.peek() == '\'':
+		return p.parseLiteralString()
+	case p.peek() == '[':
+		return p.parseArray()
+	case p.peek() == '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.peek())) {
+		p.pos++
+	}
+	tok := p.src[start:p.pos]
+	switch tok {
+	case "":
+		return nil, p.errorf("expected a value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+	// Dates and times (1979-05-27, 07:32:00) stay strings
+	if len(tok) >= 5 && (tok[4] == '-' && tok[0] != '+' && tok[0] != '-' || tok[2] == ':') {
+		return tok, nil
+	}
+	num := strings.ReplaceAll(tok, "_", "")
+	if strings.HasPrefix(num, "0x") || strings.HasPrefix(num, "0o") || strings.HasPrefix(num, "0b") {
+		n, err := strconv.ParseInt(num[2:], map[byte]int{'x': 16, 'o': 8, 'b': 2}[num[1]], 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer %s", tok)
+		}
+		return n, nil
+	}
+	if strings.ContainsAny(num, ".eE") {
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return nil, p.errorf("invalid float %s", tok)
+		}
+		return f, nil
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return nil, p.errorf("invalid value %s", tok)
+	}
+	return n, nil
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++ // opening quote
+	var sb strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		if c == '"' {
+			p.pos++
+			return sb.String(), nil
+		}
+		if c == '\\' {
+			if err := p.parseEscape(&sb); err != nil {
+				return "", err
+			}
+			continue
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' {
+		if p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		p.pos++
+	}
+	if p.eof() {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[start:p.pos]
+	p.pos++
+	return s, nil
+}
+
+// parseMultilineString parses a """ or ”' string. A newline right after
+// the opening delimiter is dropped, and in """ strings a backslash at the
+// end of a line joins it with the next non-blank text.
+func (p *tomlParser) parseMultilineString(delim string) (string, error) {
+	p.pos += 3
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+		p.line++
+	} else if p.peek() == '\n' {
+		p.pos++
+		p.line++
+	}
+	var sb strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			p.pos += 3
+			return sb.String(), nil
+		}
+		c := p.peek()
+		if c == '\\' && delim == `"""` {
+			// Line-ending backslash
+			j := p.pos + 1
+			for j < len(p.src) && (p.src[j] == ' ' || p.src[j] == '\t' || p.src[j] == '\r') {
+				j++
+			}
+			if j < len(p.src) && p.src[j] == '\n' {
+				p.pos = j
+				for !p.eof() && strings.ContainsRune(" \t\r\n", rune(p.peek())) {
+					if p.peek() == '\n' {
+						p.line++
+					}
+					p.pos++
+				}
+				continue
+			}
+			if err := p.parseEscape(&sb); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if c == '\n' {
+			p.line++
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseEscape(sb *strings.Builder) error {
+	p.pos++ // backslash
+	if p.eof() {
+		return p.errorf("unterminated string")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		sb.WriteByte('\b')
+	case 't':
+		sb.WriteByte('\t')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'r':
+		sb.WriteByte('\r')
+	case 'e':
+		sb.WriteByte(0x1b)
+	case '"', '\\':
+		sb.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.src) {
+			return p.errorf("invalid \\%c escape", c)
+		}
+		code, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.errorf("invalid \\%c escape", c)
+		}
+		sb.WriteRune(rune(code))
+		p.pos += n
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++ // [
+	arr := []interface{}{}
+	for {
+		p.skipBlank(true)
+		if p.peek() == ']' {
+			p.pos++
+			return arr, nil
+		}
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, val)
+		p.skipBlank(true)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+	p.pos++ // {
+	table := make(map[string]interface{})
+	p.skipBlank(false)
+	if p.peek() == '}' {
+		p.pos++
+		return table, nil
+	}
+	for {
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipBlank(false)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, p.errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// subTable returns the table at path below table, creating missing ones.
+// The last element of an array of tables stands for the array.
+func subTable(table map[string]interface{}, path []string) (map[string]interface{}, error) {
+	for i, key := range path {
+		switch next := table[key].(type) {
+		case nil:
+			t := make(map[string]interface{})
+			table[key] = t
+			table = t
+		case map[string]interface{}:
+			table = next
+		case []interface{}:
+			last, ok := lastTable(next)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", strings.Join(path[:i+1], "."))
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%s is not a table", strings.Join(path[:i+1], "."))
+		}
+	}
+	return table, nil
+}
+
+// appendTable adds a table to the array of tables at path.
+func appendTable(root map[string]interface{}, path []string) (map[string]interface{}, error) {
+	parent, err := subTable(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+	t := make(map[string]interface{})
+	switch arr := parent[key].(type) {
+	case nil:
+		parent[key] = []interface{}{t}
+	case []interface{}:
+		if _, ok := lastTable(arr); !ok {
+			return nil, fmt.Errorf("%s is not an array of tables", strings.Join(path, "."))
+		}
+		parent[key] = append(arr, t)
+	default:
+		return nil, fmt.Errorf("%s is not an array of tables", strings.Join(path, "."))
+	}
+	return t, nil
+}
+
+func lastTable(arr []interface{}) (map[string]interface{}, bool) {
+	if len(arr) == 0 {
+		return nil, false
+	}
+	t, ok := arr[len(arr)-1].(map[string]interface{})
+	return t, ok
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	got, err := parseTOML(`title = "TOML \"x\"\t\u00e9" # comment
+path = 'C:\dir'
+"quoted key" = 1_000
+site.name = "n"
+hex = 0xff
+pi = 3.14
+exp = -2e3
+on = true
+when = 1979-05-27T07:32:00Z
+nums = [
+  1, 2, # trailing comma
+]
+point = { x = 1, y = { z = "w" } }
+text = """
+one \
+   two"""
+raw = '''a\nb'''
+
+[server.http]
+port = 80
+
+[[hosts]]
+name = "a"
+
+[[hosts]]
+name = "b"
+[hosts.extra]
+k = 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"title":      "TOML \"x\"\té",
+		"path":       `C:\dir`,
+		"quoted key": int64(1000),
+		"site":       map[string]interface{}{"name": "n"},
+		"hex":        int64(255),
+		"pi":         3.14,
+		"exp":        -2000.0,
+		"on":         true,
+		"when":       "1979-05-27T07:32:00Z",
+		"nums":       []interface{}{int64(1), int64(2)},
+		"point":      map[string]interface{}{"x": int64(1), "y": map[string]interface{}{"z": "w"}},
+		"text":       "one two",
+		"raw":        `a\nb`,
+		"server":     map[string]interface{}{"http": map[string]interface{}{"port": int64(80)}},
+		"hosts": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[string]interface{}{"name": "b", "extra": map[string]interface{}{"k": int64(1)}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %#v\nwant %#v", got, want)
+	}
+
+	for _, tc := range []struct{ src, want string }{
+		{"a = 1\na = 2", "line 2: key a defined twice"},
+		{"[t]\n[t]", "line 2: table [t] defined twice"},
+		{"a = 1\n[a]", "line 2: a is not a table"},
+		{`a = "open`, "line 1: unterminated string"},
+		{"a = 1 2", "line 1: unexpected '2'"},
+		{"a = [1 2]", "line 1: expected , or ] in array"},
+		{"a = 12abc", "line 1: invalid value 12abc"},
+		{`a = "\q"`, `line 1: invalid escape \q`},
+	} {
+		if _, err := parseTOML(tc.src); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want %q", tc.src, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Package cron provides the cron module: the times a cron expression
+// matches, which the stdlib's scheduler module runs jobs at.
+package cron
+
+import (
+	"noxy-vm/internal/cron"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"time"
+)
+
+// Register adds the cron natives to r.
+func Register(r native.Registry) {
+	// cron_next(expr, after?) -> int
+	// The first Unix time (seconds) after `after`, by default now, that
+	// expr matches in local time.
+	r.DefineModuleNative("cron", "next", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "int?"); !ok {
+			return err
+		}
+		s, err := cron.Parse(args[0].String())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		after := time.Now()
+		if len(args) > 1 {
+			after = time.Unix(args[1].AsInt, 0)
+		}
+		next := s.Next(after)
+		if next.IsZero() {
+			return value.NewNativeError("%q never matches", args[0].String())
+		}
+		return value.NewInt(next.Unix())
+	})
+}
@@ -0,0 +1,178 @@
+// Package http provides the http natives that do not need sockets:
+// cookies, HMAC signing for cookie sessions, content types and
+// multipart form parsing.
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"strings"
+)
+
+// Register adds the http natives to r.
+func Register(r native.Registry) {
+	registerMime(r)
+
+	// http_parse_cookies(header) -> map[string, string]
+	// Reads a Cookie request header ("a=1; b=2"). Malformed pairs are
+	// skipped and the first occurrence of a name wins.
+	r.DefineModuleNative("http", "parse_cookies", func(args []value.Value) value.Value {
+		cookies := value.NewMap()
+		if len(args) < 1 {
+			return cookies
+		}
+		m := cookies.Obj.(*value.ObjMap)
+		for _, part := range strings.Split(args[0].String(), ";") {
+			name, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok || !validCookieName(name) {
+				continue
+			}
+			if _, seen := m.Get(name); seen {
+				continue
+			}
+			if len(val) > 1 && val[0] == '"' && val[len(val)-1] == '"' {
+				val = val[1 : len(val)-1]
+			}
+			m.Set(name, value.NewString(val))
+		}
+		return cookies
+	})
+
+	// http_format_cookie(name, value, options) -> string
+	// Builds a Set-Cookie header value. options may hold path, domain,
+	// same_site (strings), max_age (int) and secure, http_only (bools).
+	r.DefineModuleNative("http", "format_cookie", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected a name and a value")
+		}
+		name := args[0].String()
+		if !validCookieName(name) {
+			return value.NewNativeError("invalid cookie name %q", name)
+		}
+		var sb strings.Builder
+		sb.WriteString(name + "=" + sanitizeCookieValue(args[1].String()))
+
+		if len(args) < 3 || args[2].Type == value.VAL_NULL {
+			return value.NewString(sb.String())
+		}
+		opts, ok := args[2].Obj.(*value.ObjMap)
+		if !ok {
+			return value.NewNativeError("options must be a map, got %s", value.TypeName(args[2]))
+		}
+		for _, k := range opts.Keys {
+			key, _ := k.(string)
+			v, _ := opts.Get(k)
+			switch key {
+			case "path", "domain":
+				attr := strings.ToUpper(key[:1]) + key[1:]
+				if s := v.String(); s != "" && !strings.ContainsAny(s, ";\r\n") {
+					sb.WriteString("; " + attr + "=" + s)
+				}
+			case "max_age":
+				if v.AsInt > 0 {
+					sb.WriteString(fmt.Sprintf("; Max-Age=%d", v.AsInt))
+				} else if v.AsInt < 0 {
+					sb.WriteString("; Max-Age=0")
+				}
+			case "secure":
+				if v.Type == value.VAL_BOOL && v.AsBool {
+					sb.WriteString("; Secure")
+				}
+			case "http_only":
+				if v.Type == value.VAL_BOOL && v.AsBool {
+					sb.WriteString("; HttpOnly")
+				}
+			case "same_site":
+				switch strings.ToLower(v.String()) {
+				case "lax":
+					sb.WriteString("; SameSite=Lax")
+				case "strict":
+					sb.WriteString("; SameSite=Strict")
+				case "none":
+					sb.WriteString("; SameSite=None")
+				default:
+					return value.NewNativeError("same_site must be Lax, Strict or None, got %q", v.String())
+				}
+			default:
+				return value.NewNativeError("unknown cookie option '%s'", key)
+			}
+		}
+		return value.NewString(sb.String())
+	})
+
+	// http_sign(data, secret) -> string
+	// Returns "<data>.<mac>", both base64url encoded, where mac is the
+	// HMAC-SHA256 of data under secret.
+	r.DefineModuleNative("http", "sign", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected data and a secret")
+		}
+		data := []byte(args[0].String())
+		enc := base64.RawURLEncoding
+		return value.NewString(enc.EncodeToString(data) + "." + enc.EncodeToString(mac(data, args[1].String())))
+	})
+
+	// http_unsign(token, secret) -> string or null
+	// Checks a token made by http_sign and returns its data, or null when
+	// the token was tampered with or signed with another secret.
+	r.DefineModuleNative("http", "unsign", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNull()
+		}
+		encData, encMac, ok := strings.Cut(args[0].String(), ".")
+		if !ok {
+			return value.NewNull()
+		}
+		enc := base64.RawURLEncoding
+		data, err := enc.DecodeString(encData)
+		if err != nil {
+			return value.NewNull()
+		}
+		got, err := enc.DecodeString(encMac)
+		if err != nil || !hmac.Equal(got, mac(data, args[1].String())) {
+			return value.NewNull()
+		}
+		return value.NewString(string(data))
+	})
+}
+
+func mac(data []byte, secret string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// validCookieName reports whether name is an RFC 6265 token.
+func validCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("()<>@,;:\\\"/[]?={}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeCookieValue drops bytes a cookie value cannot carry and quotes
+// values containing spaces or commas, as browsers expect.
+func sanitizeCookieValue(v string) string {
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c >= ' ' && c < 0x7f && c != '"' && c != ';' && c != '\\' {
+			sb.WriteByte(c)
+		}
+	}
+	v = sb.String()
+	if strings.ContainsAny(v, " ,") {
+		return `"` + v + `"`
+	}
+	return v
+}
@@ -0,0 +1,174 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	nethttp "net/http"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mimeTypes fixes the common web types so results do not depend on the
+// host's mime.types; other extensions fall back to the mime package.
+var mimeTypes = map[string]string{
+	".html":  "text/html; charset=utf-8",
+	".htm":   "text/html; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "application/javascript",
+	".mjs":   "application/javascript",
+	".json":  "application/json",
+	".txt":   "text/plain; charset=utf-8",
+	".md":    "text/plain; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".xml":   "application/xml",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".ico":   "image/x-icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".wasm":  "application/wasm",
+	".pdf":   "application/pdf",
+	".zip":   "application/zip",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+}
+
+func registerMime(r native.Registry) {
+	// http_mime_type(path) -> string
+	// The content type for a file name's extension, or
+	// application/octet-stream when it is unknown.
+	r.DefineModuleNative("http", "mime_type", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("application/octet-stream")
+		}
+		return value.NewString(mimeType(args[0].String()))
+	})
+
+	// http_sniff_type(data) -> string
+	// Guesses the content type from the first bytes of data, following
+	// the WHATWG sniffing algorithm.
+	r.DefineModuleNative("http", "sniff_type", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("application/octet-stream")
+		}
+		return value.NewString(nethttp.DetectContentType(bytesArg(args[0])))
+	})
+
+	// http_parse_multipart(body, content_type) -> map
+	// Parses a multipart/form-data body into {"fields": map[string, string],
+	// "files": [...]}. Each uploaded file is written to a temporary file
+	// and described by a map with field, filename, path, size and
+	// content_type; deleting those files is up to the caller.
+	r.DefineModuleNative("http", "parse_multipart", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected a body and a content type")
+		}
+		mediaType, params, err := mime.ParseMediaType(args[1].String())
+		if err != nil || mediaType != "multipart/form-data" {
+			return value.NewNativeError("content type %q is not multipart/form-data", args[1].String())
+		}
+		if params["boundary"] == "" {
+			return value.NewNativeError("content type has no boundary")
+		}
+
+		fields := value.NewMap()
+		var files []value.Value
+		mr := multipart.NewReader(strings.NewReader(string(bytesArg(args[0]))), params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				removeUploads(files)
+				return value.NewNativeError("malformed body: %s", err)
+			}
+			if part.FileName() == "" {
+				data, err := io.ReadAll(part)
+				if err != nil {
+					removeUploads(files)
+					return value.NewNativeError("malformed body: %s", err)
+				}
+				m := fields.Obj.(*value.ObjMap)
+				if _, seen := m.Get(part.FormName()); !seen {
+					m.Set(part.FormName(), value.NewString(string(data)))
+				}
+				continue
+			}
+			upload, err := saveUpload(part)
+			if err != nil {
+				removeUploads(files)
+				return value.NewNativeError("%s", err)
+			}
+			files = append(files, upload)
+		}
+
+		form := value.NewMap()
+		m := form.Obj.(*value.ObjMap)
+		m.Set("fields", fields)
+		m.Set("files", value.NewArray(files))
+		return form
+	})
+}
+
+func mimeType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if t, ok := mimeTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// saveUpload copies a file part to a temporary file and describes it.
+func saveUpload(part *multipart.Part) (value.Value, error) {
+	f, err := os.CreateTemp("", "noxy-upload-*"+filepath.Ext(part.FileName()))
+	if err != nil {
+		return value.Value{}, err
+	}
+	defer f.Close()
+	size, err := io.Copy(f, part)
+	if err != nil {
+		os.Remove(f.Name())
+		return value.Value{}, err
+	}
+	contentType := part.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = mimeType(part.FileName())
+	}
+	return value.NewMapWithData(map[string]value.Value{
+		"field":        value.NewString(part.FormName()),
+		"filename":     value.NewString(filepath.Base(part.FileName())),
+		"path":         value.NewString(f.Name()),
+		"size":         value.NewInt(size),
+		"content_type": value.NewString(contentType),
+	}), nil
+}
+
+// removeUploads deletes the temporary files of a form that failed to
+// parse, since the script never learns their paths.
+func removeUploads(files []value.Value) {
+	for _, upload := range files {
+		if p, ok := upload.Obj.(*value.ObjMap).Get("path"); ok {
+			os.Remove(p.String())
+		}
+	}
+}
+
+// bytesArg reads a bytes or string argument.
+func bytesArg(v value.Value) []byte {
+	if v.Type == value.VAL_BYTES {
+		return []byte(v.Obj.(string))
+	}
+	return []byte(v.String())
+}
@@ -0,0 +1,200 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"noxy-vm/internal/barcode"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"strings"
+)
+
+// registerBarcodes adds the qr and code128 natives, which render codes as
+// images.
+func registerBarcodes(r native.Registry) {
+	// qr_encode(text, options?) -> image
+	// options: "level" ("L", "M", "Q" or "H"; "M" by default), "scale"
+	// (pixels per module, 4), "border" (modules of quiet zone, 4),
+	// "color" and "background".
+	r.DefineModuleNative("qr", "encode", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "map?"); !ok {
+			return err
+		}
+		opts, err := readRenderOptions(args, 1, renderOptions{scale: 4, border: 4, level: "M"}, "level")
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		q, err := encodeQR(args[0].String(), opts.level)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		img, err := opts.canvas(q.Size(), q.Size())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		for y, row := range q.Modules {
+			for x, dark := range row {
+				if dark {
+					opts.module(img, x, y, 1)
+				}
+			}
+		}
+		return value.NewImage(img)
+	})
+
+	// qr_matrix(text, level?) -> string[]
+	// The modules as rows of "1" (dark) and "0", without the quiet zone,
+	// for drawing a code some other way.
+	r.DefineModuleNative("qr", "matrix", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string?"); !ok {
+			return err
+		}
+		level := "M"
+		if len(args) > 1 {
+			level = args[1].String()
+		}
+		q, err := encodeQR(args[0].String(), level)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		rows := make([]value.Value, q.Size())
+		for y, row := range q.Modules {
+			rows[y] = value.NewString(modulesString(row))
+		}
+		return value.NewArray(rows)
+	})
+
+	// code128_encode(text, options?) -> image
+	// options: "scale" (pixels per module, 2), "height" (of the bars in
+	// modules, 40), "border" (modules of quiet zone, 10), "color" and
+	// "background".
+	r.DefineModuleNative("code128", "encode", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "map?"); !ok {
+			return err
+		}
+		opts, err := readRenderOptions(args, 1, renderOptions{scale: 2, border: 10, height: 40}, "height")
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		modules, err := barcode.Code128Modules(args[0].String())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		img, err := opts.canvas(len(modules), opts.height)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		for x, bar := range modules {
+			if bar {
+				opts.module(img, x, 0, opts.height)
+			}
+		}
+		return value.NewImage(img)
+	})
+
+	// code128_pattern(text) -> string
+	// The modules from the start code to the stop code as "1" (bar) and
+	// "0" (space).
+	r.DefineModuleNative("code128", "pattern", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		modules, err := barcode.Code128Modules(args[0].String())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewString(modulesString(modules))
+	})
+}
+
+func encodeQR(text, levelName string) (*barcode.QR, error) {
+	level, err := barcode.ParseLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
+	return barcode.EncodeQR(text, level)
+}
+
+func modulesString(modules []bool) string {
+	var sb strings.Builder
+	for _, m := range modules {
+		if m {
+			sb.WriteByte('1')
+		} else {
+			sb.WriteByte('0')
+		}
+	}
+	return sb.String()
+}
+
+// renderOptions control how a code is drawn. Sizes other than scale are
+// in modules.
+type renderOptions struct {
+	scale, border, height int
+	level                 string
+	fg, bg                color.NRGBA
+}
+
+// readRenderOptions reads the options map at args[i] over defaults.
+// extra names the one option besides scale, border and the colors that
+// the code accepts.
+func readRenderOptions(args []value.Value, i int, defaults renderOptions, extra string) (renderOptions, error) {
+	opts := defaults
+	opts.fg = color.NRGBA{0, 0, 0, 255}
+	opts.bg = color.NRGBA{255, 255, 255, 255}
+	if len(args) <= i {
+		return opts, nil
+	}
+	m := args[i].Obj.(*value.ObjMap)
+	for _, k := range m.Keys {
+		key := fmt.Sprint(k)
+		v := m.Data[k]
+		var err error
+		switch {
+		case key == "scale":
+			opts.scale, err = intOption(key, v, 1, 64)
+		case key == "border":
+			opts.border, err = intOption(key, v, 0, 64)
+		case key == "height" && extra == "height":
+			opts.height, err = intOption(key, v, 1, 1000)
+		case key == "level" && extra == "level":
+			opts.level = v.String()
+		case key == "color":
+			opts.fg, err = parseColor(v)
+		case key == "background":
+			opts.bg, err = parseColor(v)
+		default:
+			err = fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+func intOption(key string, v value.Value, lo, hi int64) (int, error) {
+	if v.Type != value.VAL_INT || v.AsInt < lo || v.AsInt > hi {
+		return 0, fmt.Errorf("option %q must be an int from %d to %d, got %s", key, lo, hi, v.String())
+	}
+	return int(v.AsInt), nil
+}
+
+// canvas is an image for a code of w by h modules, filled with the
+// background and with room for the border on every side.
+func (o renderOptions) canvas(w, h int) (*image.RGBA, error) {
+	img, err := newImage((w+2*o.border)*o.scale, (h+2*o.border)*o.scale)
+	if err != nil {
+		return nil, err
+	}
+	draw.Draw(img, img.Bounds(), image.NewUniform(o.bg), image.Point{}, draw.Src)
+	return img, nil
+}
+
+// module fills the module at (x, y), h modules tall.
+func (o renderOptions) module(img *image.RGBA, x, y, h int) {
+	px, py := (x+o.border)*o.scale, (y+o.border)*o.scale
+	fillRect(img, image.Rect(px, py, px+o.scale, py+h*o.scale), o.fg)
+}
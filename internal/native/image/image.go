@@ -0,0 +1,490 @@
+// Package image provides the image module: creating, drawing on,
+// scaling and encoding RGBA images, enough for charts, badges and
+// thumbnails.
+//
+// Colors are "#rgb", "#rrggbb" or "#rrggbbaa" strings, or [r, g, b] and
+// [r, g, b, a] arrays of 0-255. Drawing outside an image is clipped, and
+// colors that are not opaque are blended over what is already there,
+// except by set_pixel, which replaces the pixel.
+//
+// The qr and code128 modules, which draw codes as images, live here too.
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"strconv"
+	"strings"
+)
+
+// maxPixels bounds the size of a new image, so a bad width or height
+// fails instead of exhausting memory.
+const maxPixels = 1 << 26
+
+// Register adds the image natives to r.
+func Register(r native.Registry) {
+	// image_new(width, height, color?) -> image
+	// Transparent unless color is given.
+	r.DefineModuleNative("image", "new", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "int", "int", "string|array?"); !ok {
+			return err
+		}
+		img, err := newImage(int(args[0].AsInt), int(args[1].AsInt))
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		if len(args) > 2 {
+			c, err := parseColor(args[2])
+			if err != nil {
+				return value.NewNativeError("%s", err)
+			}
+			draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+		return value.NewImage(img)
+	})
+
+	// image_decode(data) -> image
+	// Reads a PNG, JPEG or GIF (its first frame).
+	r.DefineModuleNative("image", "decode", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "bytes"); !ok {
+			return err
+		}
+		src, _, err := image.Decode(strings.NewReader(args[0].Obj.(string)))
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		b := src.Bounds()
+		img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+		return value.NewImage(img)
+	})
+
+	r.DefineModuleNative("image", "width", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image"); !ok {
+			return err
+		}
+		return value.NewInt(int64(imageOf(args[0]).Bounds().Dx()))
+	})
+
+	r.DefineModuleNative("image", "height", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image"); !ok {
+			return err
+		}
+		return value.NewInt(int64(imageOf(args[0]).Bounds().Dy()))
+	})
+
+	// image_get_pixel(img, x, y) -> int[]
+	// [r, g, b, a], not premultiplied.
+	r.DefineModuleNative("image", "get_pixel", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int", "int"); !ok {
+			return err
+		}
+		img := imageOf(args[0])
+		x, y := int(args[1].AsInt), int(args[2].AsInt)
+		if !(image.Point{x, y}).In(img.Bounds()) {
+			return value.NewNativeError("(%d, %d) is outside the %dx%d image", x, y, img.Bounds().Dx(), img.Bounds().Dy())
+		}
+		c := color.NRGBAModel.Convert(img.RGBAAt(x, y)).(color.NRGBA)
+		return value.NewArray([]value.Value{
+			value.NewInt(int64(c.R)), value.NewInt(int64(c.G)),
+			value.NewInt(int64(c.B)), value.NewInt(int64(c.A)),
+		})
+	})
+
+	// image_set_pixel(img, x, y, color)
+	r.DefineModuleNative("image", "set_pixel", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int", "int", "string|array"); !ok {
+			return err
+		}
+		c, err := parseColor(args[3])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		imageOf(args[0]).Set(int(args[1].AsInt), int(args[2].AsInt), c)
+		return value.NewNull()
+	})
+
+	// image_fill_rect(img, x, y, width, height, color)
+	r.DefineModuleNative("image", "fill_rect", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int", "int", "int", "int", "string|array"); !ok {
+			return err
+		}
+		c, err := parseColor(args[5])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		x, y := int(args[1].AsInt), int(args[2].AsInt)
+		fillRect(imageOf(args[0]), image.Rect(x, y, x+int(args[3].AsInt), y+int(args[4].AsInt)), c)
+		return value.NewNull()
+	})
+
+	// image_draw_rect(img, x, y, width, height, color)
+	// The one-pixel outline of the rectangle fill_rect would fill.
+	r.DefineModuleNative("image", "draw_rect", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int", "int", "int", "int", "string|array"); !ok {
+			return err
+		}
+		c, err := parseColor(args[5])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		img := imageOf(args[0])
+		x0, y0 := int(args[1].AsInt), int(args[2].AsInt)
+		x1, y1 := x0+int(args[3].AsInt), y0+int(args[4].AsInt)
+		if x1 <= x0 || y1 <= y0 {
+			return value.NewNull()
+		}
+		fillRect(img, image.Rect(x0, y0, x1, y0+1), c)
+		if y1-1 > y0 {
+			fillRect(img, image.Rect(x0, y1-1, x1, y1), c)
+		}
+		fillRect(img, image.Rect(x0, y0+1, x0+1, y1-1), c)
+		if x1-1 > x0 {
+			fillRect(img, image.Rect(x1-1, y0+1, x1, y1-1), c)
+		}
+		return value.NewNull()
+	})
+
+	// image_draw_line(img, x0, y0, x1, y1, color)
+	// Both end points included.
+	r.DefineModuleNative("image", "draw_line", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int", "int", "int", "int", "string|array"); !ok {
+			return err
+		}
+		c, err := parseColor(args[5])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		drawLine(imageOf(args[0]), int(args[1].AsInt), int(args[2].AsInt), int(args[3].AsInt), int(args[4].AsInt), c)
+		return value.NewNull()
+	})
+
+	// image_draw_text(img, x, y, text, color, scale?)
+	// Draws text with its top-left corner at (x, y) in the built-in 5x7
+	// font, each pixel of which becomes a scale by scale square. "\n"
+	// starts a new line.
+	r.DefineModuleNative("image", "draw_text", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int", "int", "string", "string|array", "int?"); !ok {
+			return err
+		}
+		c, err := parseColor(args[4])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		scale, err := scaleArg(args, 5)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		drawText(imageOf(args[0]), int(args[1].AsInt), int(args[2].AsInt), args[3].String(), c, scale)
+		return value.NewNull()
+	})
+
+	// image_text_size(text, scale?) -> int[]
+	// [width, height] of what draw_text would draw, for centering text or
+	// sizing a badge around it.
+	r.DefineModuleNative("image", "text_size", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "int?"); !ok {
+			return err
+		}
+		scale, err := scaleArg(args, 1)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		w, h := textSize(args[0].String(), scale)
+		return value.NewArray([]value.Value{value.NewInt(int64(w)), value.NewInt(int64(h))})
+	})
+
+	// image_draw_image(dst, src, x, y)
+	// Draws src over dst with its top-left corner at (x, y).
+	r.DefineModuleNative("image", "draw_image", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "image", "int", "int"); !ok {
+			return err
+		}
+		dst, src := imageOf(args[0]), imageOf(args[1])
+		at := image.Pt(int(args[2].AsInt), int(args[3].AsInt))
+		draw.Draw(dst, src.Bounds().Add(at), src, image.Point{}, draw.Over)
+		return value.NewNull()
+	})
+
+	// image_crop(img, x, y, width, height) -> image
+	// A copy of the part of img inside the rectangle.
+	r.DefineModuleNative("image", "crop", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int", "int", "int", "int"); !ok {
+			return err
+		}
+		src := imageOf(args[0])
+		x, y := int(args[1].AsInt), int(args[2].AsInt)
+		rect := image.Rect(x, y, x+int(args[3].AsInt), y+int(args[4].AsInt)).Intersect(src.Bounds())
+		if rect.Empty() {
+			return value.NewNativeError("the rectangle is outside the %dx%d image", src.Bounds().Dx(), src.Bounds().Dy())
+		}
+		img := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		draw.Draw(img, img.Bounds(), src, rect.Min, draw.Src)
+		return value.NewImage(img)
+	})
+
+	// image_resize(img, width, height) -> image
+	// A scaled copy of img. Shrinking averages the pixels each new pixel
+	// covers, so thumbnails stay smooth.
+	r.DefineModuleNative("image", "resize", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int", "int"); !ok {
+			return err
+		}
+		img, err := newImage(int(args[1].AsInt), int(args[2].AsInt))
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		resize(img, imageOf(args[0]))
+		return value.NewImage(img)
+	})
+
+	// image_encode_png(img) -> bytes
+	r.DefineModuleNative("image", "encode_png", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image"); !ok {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, imageOf(args[0])); err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewBytes(buf.String())
+	})
+
+	// image_encode_jpeg(img, quality?) -> bytes
+	// quality is 1-100, 90 by default. JPEG has no transparency.
+	r.DefineModuleNative("image", "encode_jpeg", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "image", "int?"); !ok {
+			return err
+		}
+		quality := 90
+		if len(args) > 1 {
+			quality = int(args[1].AsInt)
+			if quality < 1 || quality > 100 {
+				return value.NewNativeError("quality must be 1-100, got %d", quality)
+			}
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, imageOf(args[0]), &jpeg.Options{Quality: quality}); err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewBytes(buf.String())
+	})
+
+	registerBarcodes(r)
+}
+
+func imageOf(v value.Value) *image.RGBA {
+	return v.Obj.(*value.ObjImage).Image
+}
+
+func newImage(width, height int) (*image.RGBA, error) {
+	if width <= 0 || height <= 0 || width > maxPixels/height {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	return image.NewRGBA(image.Rect(0, 0, width, height)), nil
+}
+
+func scaleArg(args []value.Value, i int) (int, error) {
+	if len(args) <= i {
+		return 1, nil
+	}
+	if scale := args[i].AsInt; scale >= 1 && scale <= 64 {
+		return int(scale), nil
+	}
+	return 0, fmt.Errorf("scale must be 1-64, got %d", args[i].AsInt)
+}
+
+// parseColor reads a color string or array.
+func parseColor(v value.Value) (color.NRGBA, error) {
+	if arr, ok := v.Obj.(*value.ObjArray); ok {
+		if arr.Len() != 3 && arr.Len() != 4 {
+			return color.NRGBA{}, fmt.Errorf("a color array has 3 or 4 elements, got %d", arr.Len())
+		}
+		c := [4]uint8{0, 0, 0, 255}
+		for i, el := range arr.Values() {
+			if el.Type != value.VAL_INT || el.AsInt < 0 || el.AsInt > 255 {
+				return color.NRGBA{}, fmt.Errorf("color components must be ints 0-255, got %s", el.String())
+			}
+			c[i] = uint8(el.AsInt)
+		}
+		return color.NRGBA{c[0], c[1], c[2], c[3]}, nil
+	}
+
+	s := v.String()
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if !strings.HasPrefix(s, "#") || len(hex) != 8 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q (want #rgb, #rrggbb or #rrggbbaa)", s)
+	}
+	return color.NRGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}, nil
+}
+
+// fillRect draws c over the part of r inside img.
+func fillRect(img *image.RGBA, r image.Rectangle, c color.NRGBA) {
+	op := draw.Over
+	if c.A == 255 {
+		op = draw.Src
+	}
+	draw.Draw(img, r.Intersect(img.Bounds()), image.NewUniform(c), image.Point{}, op)
+}
+
+// blend draws c over the pixel at (x, y), if it is inside img.
+func blend(img *image.RGBA, x, y int, c color.NRGBA) {
+	if c.A == 255 {
+		img.SetRGBA(x, y, color.RGBA{c.R, c.G, c.B, 255})
+		return
+	}
+	fillRect(img, image.Rect(x, y, x+1, y+1), c)
+}
+
+// drawLine draws the line from (x0, y0) to (x1, y1) with Bresenham's
+// algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.NRGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		blend(img, x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if 2*e >= dy {
+			e += dy
+			x0 += sx
+		}
+		if 2*e <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func drawText(img *image.RGBA, x, y int, text string, c color.NRGBA, scale int) {
+	cx := x
+	for _, r := range text {
+		if r == '\n' {
+			cx = x
+			y += glyphHeight * scale
+			continue
+		}
+		for col, bits := range glyph(r) {
+			for row := 0; row < 7; row++ {
+				if bits&(1<<row) != 0 {
+					px, py := cx+col*scale, y+row*scale
+					fillRect(img, image.Rect(px, py, px+scale, py+scale), c)
+				}
+			}
+		}
+		cx += glyphWidth * scale
+	}
+}
+
+// textSize measures text as drawText draws it, leaving out the spacing
+// after the last character and below the last line.
+func textSize(text string, scale int) (int, int) {
+	lines := strings.Split(text, "\n")
+	longest := 0
+	for _, line := range lines {
+		if n := len([]rune(line)); n > longest {
+			longest = n
+		}
+	}
+	w := 0
+	if longest > 0 {
+		w = (longest*glyphWidth - 1) * scale
+	}
+	return w, (len(lines)*glyphHeight - 1) * scale
+}
+
+// resize scales src into dst. Each destination pixel averages the source
+// pixels under it, or interpolates between the nearest four when
+// enlarging.
+func resize(dst, src *image.RGBA) {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := dst.Bounds().Dx(), dst.Bounds().Dy()
+	for y := 0; y < dh; y++ {
+		y0, y1 := span(y, dh, sh)
+		for x := 0; x < dw; x++ {
+			x0, x1 := span(x, dw, sw)
+			var sum [4]float64
+			var weight float64
+			for sy := int(y0); float64(sy) < y1; sy++ {
+				wy := overlap(y0, y1, sy)
+				for sx := int(x0); float64(sx) < x1; sx++ {
+					w := wy * overlap(x0, x1, sx)
+					p := src.RGBAAt(src.Bounds().Min.X+sx, src.Bounds().Min.Y+sy)
+					sum[0] += w * float64(p.R)
+					sum[1] += w * float64(p.G)
+					sum[2] += w * float64(p.B)
+					sum[3] += w * float64(p.A)
+					weight += w
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				uint8(sum[0]/weight + 0.5), uint8(sum[1]/weight + 0.5),
+				uint8(sum[2]/weight + 0.5), uint8(sum[3]/weight + 0.5),
+			})
+		}
+	}
+}
+
+// span is the part of the source, in source pixels, that destination
+// pixel i of n covers. When enlarging it is widened to one pixel around
+// the pixel's center, so neighbours blend.
+func span(i, n, size int) (float64, float64) {
+	ratio := float64(size) / float64(n)
+	lo, hi := float64(i)*ratio, float64(i+1)*ratio
+	if ratio < 1 {
+		center := (lo + hi) / 2
+		lo, hi = center-0.5, center+0.5
+		if lo < 0 {
+			lo, hi = 0, 1
+		}
+		if hi > float64(size) {
+			lo, hi = float64(size)-1, float64(size)
+		}
+	}
+	return lo, hi
+}
+
+// overlap is how much of source pixel p lies within [lo, hi).
+func overlap(lo, hi float64, p int) float64 {
+	a, b := float64(p), float64(p+1)
+	if lo > a {
+		a = lo
+	}
+	if hi < b {
+		b = hi
+	}
+	if b <= a {
+		return 0
+	}
+	return b - a
+}
@@ -0,0 +1,100 @@
+package io
+
+import (
+	"errors"
+	"io/fs"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// file is what the descriptor table holds: an *os.File or an embedded
+// asset opened read-only.
+type file interface {
+	Name() string
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	WriteString(s string) (int, error)
+	Seek(offset int64, whence int) (int64, error)
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
+var errEmbeddedReadOnly = errors.New("embedded files are read-only")
+
+// embeddedFile serves an asset bundled with an embed statement.
+type embeddedFile struct {
+	*strings.Reader
+	name string
+	size int64
+}
+
+func (f *embeddedFile) Name() string              { return f.name }
+func (f *embeddedFile) Write([]byte) (int, error) { return 0, errEmbeddedReadOnly }
+func (f *embeddedFile) WriteString(string) (int, error) {
+	return 0, errEmbeddedReadOnly
+}
+func (f *embeddedFile) Stat() (os.FileInfo, error) { return embeddedInfo{f}, nil }
+func (f *embeddedFile) Close() error               { return nil }
+
+type embeddedInfo struct{ f *embeddedFile }
+
+func (i embeddedInfo) Name() string       { return path.Base(i.f.name) }
+func (i embeddedInfo) Size() int64        { return i.f.size }
+func (i embeddedInfo) Mode() fs.FileMode  { return 0444 }
+func (i embeddedInfo) ModTime() time.Time { return time.Time{} }
+func (i embeddedInfo) IsDir() bool        { return false }
+func (i embeddedInfo) Sys() any           { return nil }
+
+// registerEmbedded adds the natives behind embed statements: io_embed_add
+// is what compiled embed statements call, io_open_embedded opens an asset
+// like io_open opens a file, and io_list_embedded names them all.
+func (files *Files) registerEmbedded(r native.Registry) {
+	r.DefineModuleNative("io", "embed_add", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "bytes"); !ok {
+			return err
+		}
+		data := args[1].Obj.(string)
+		files.embedded[args[0].String()] = data
+		return value.NewNull()
+	})
+	r.DefineModuleNative("io", "open_embedded", func(args []value.Value) value.Value {
+		// args: name, FileStructDef
+		if err, ok := native.CheckArgs(args, "string", "struct"); !ok {
+			return err
+		}
+		name := args[0].String()
+		structDef := args[1].Obj.(*value.ObjStruct)
+
+		var fd int64
+		data, isOpen := files.embedded[name]
+		if isOpen {
+			fd = files.next
+			files.next++
+			files.open[fd] = &embeddedFile{Reader: strings.NewReader(data), name: name, size: int64(len(data))}
+		}
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("fd", value.NewInt(fd))
+		inst.Set("path", value.NewString(name))
+		inst.Set("mode", value.NewString("r"))
+		inst.Set("open", value.NewBool(isOpen))
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("io", "list_embedded", func(args []value.Value) value.Value {
+		names := make([]string, 0, len(files.embedded))
+		for name := range files.embedded {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		elems := make([]value.Value, len(names))
+		for i, name := range names {
+			elems[i] = value.NewString(name)
+		}
+		return value.NewArray(elems)
+	})
+}
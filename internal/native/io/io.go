@@ -0,0 +1,227 @@
+// Package io provides the io module: files addressed by descriptor
+// numbers, plus whole-file and directory helpers.
+package io
+
+import (
+	"fmt"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Files is the table of files opened with io_open and io_open_embedded,
+// plus the assets bundled by embed statements.
+type Files struct {
+	open     map[int64]file
+	next     int64
+	embedded map[string]string
+}
+
+// OpenResources implements native.Resources.
+func (files *Files) OpenResources() []string {
+	fds := make([]int64, 0, len(files.open))
+	for fd := range files.open {
+		fds = append(fds, fd)
+	}
+	sort.Slice(fds, func(i, j int) bool { return fds[i] < fds[j] })
+	open := make([]string, 0, len(fds))
+	for _, fd := range fds {
+		open = append(open, fmt.Sprintf("file %d (%s)", fd, files.open[fd].Name()))
+	}
+	return open
+}
+
+// CloseAll implements native.Resources. Descriptor numbers start over;
+// embedded assets stay available.
+func (files *Files) CloseAll() {
+	for fd, f := range files.open {
+		f.Close()
+		delete(files.open, fd)
+	}
+	files.next = 1
+}
+
+// Register adds the io natives to r and returns their file table.
+func Register(r native.Registry) *Files {
+	files := &Files{open: make(map[int64]file), next: 1, embedded: make(map[string]string)}
+
+	r.DefineModuleNative("io", "open", func(args []value.Value) value.Value {
+		// args: path, mode, FileStructDef
+		if err, ok := native.CheckArgs(args, "string", "string", "struct"); !ok {
+			return err
+		}
+		path := args[0].String()
+		mode := args[1].String()
+		structDef := args[2].Obj.(*value.ObjStruct)
+
+		flag := os.O_RDONLY
+		if mode == "w" {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		} else if mode == "a" {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		} else if mode == "rw" || mode == "r+" {
+			flag = os.O_RDWR | os.O_CREATE
+		}
+
+		f, err := os.OpenFile(r.ResolvePath(path), flag, 0644)
+		isOpen := true
+		var fd int64 = 0
+
+		if err != nil {
+			isOpen = false
+		} else {
+			fd = files.next
+			files.next++
+			files.open[fd] = f
+		}
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("fd", value.NewInt(fd))
+		inst.Set("path", value.NewString(path))
+		inst.Set("mode", value.NewString(mode))
+		inst.Set("open", value.NewBool(isOpen))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("io", "close", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "instance"); !ok {
+			return err
+		}
+		inst := args[0].Obj.(*value.ObjInstance)
+
+		fd := inst.Field("fd").AsInt
+		if f, exists := files.open[fd]; exists {
+			f.Close()
+			delete(files.open, fd)
+			inst.Set("open", value.NewBool(false))
+		}
+		return value.NewNull()
+	})
+	r.DefineModuleNative("io", "write", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "instance", "any"); !ok {
+			return err
+		}
+		inst := args[0].Obj.(*value.ObjInstance)
+
+		fd := inst.Field("fd").AsInt
+		f, exists := files.open[fd]
+		if !exists {
+			return value.Errorf("%s is not open", inst.Field("path"))
+		}
+		var err error
+		if args[1].Type == value.VAL_BYTES {
+			// Bytes are stored as string in Obj, but treat as raw bytes
+			_, err = f.Write([]byte(args[1].Obj.(string)))
+		} else {
+			_, err = f.WriteString(args[1].String())
+		}
+		if err != nil {
+			return value.Errorf("%v", err)
+		}
+		return value.NewNull()
+	})
+	r.DefineModuleNative("io", "read", func(args []value.Value) value.Value {
+		// args: fileInst, IOResultStructDef
+		if err, ok := native.CheckArgs(args, "instance", "struct"); !ok {
+			return err
+		}
+		content, errMsg := files.readAll(args[0])
+		return native.NewResult(args[1], errMsg, map[string]value.Value{"data": value.NewString(content)})
+	})
+
+	r.DefineModuleNative("io", "read_bytes", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "instance", "struct"); !ok {
+			return err
+		}
+		content, errMsg := files.readAll(args[0])
+		return native.NewResult(args[1], errMsg, map[string]value.Value{"data": value.NewBytes(content)})
+	})
+	r.DefineModuleNative("io", "exists", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewBool(false)
+		}
+		path := r.ResolvePath(args[0].String())
+		_, err := os.Stat(path)
+		return value.NewBool(err == nil)
+	})
+	r.DefineModuleNative("io", "remove", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewBool(false)
+		}
+		path := r.ResolvePath(args[0].String())
+		err := os.Remove(path)
+		return value.NewBool(err == nil)
+	})
+	r.DefineModuleNative("io", "read_lines", func(args []value.Value) value.Value {
+		// args: fileInst, IOLinesResultStructDef
+		if err, ok := native.CheckArgs(args, "instance", "struct"); !ok {
+			return err
+		}
+		content, errMsg := files.readAll(args[0])
+		var lines []value.Value
+		if errMsg == "" {
+			// A trailing newline leaves an empty last line, as strings.Split does
+			for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+				lines = append(lines, value.NewString(line))
+			}
+		}
+		return native.NewResult(args[1], errMsg, map[string]value.Value{"data": value.NewArray(lines)})
+	})
+	r.DefineModuleNative("io", "stat", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "struct"); !ok {
+			return err
+		}
+		path := r.ResolvePath(args[0].String())
+		structDef := args[1].Obj.(*value.ObjStruct)
+
+		info, err := os.Stat(path)
+		exists := (err == nil)
+		size := int64(0)
+		isDir := false
+		if exists {
+			size = info.Size()
+			isDir = info.IsDir()
+		}
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("exists", value.NewBool(exists))
+		inst.Set("size", value.NewInt(size))
+		inst.Set("is_dir", value.NewBool(isDir))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("io", "mkdir", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewBool(false)
+		}
+		path := r.ResolvePath(args[0].String())
+		err := os.MkdirAll(path, 0755)
+		return value.NewBool(err == nil)
+	})
+
+	files.registerEmbedded(r)
+
+	return files
+}
+
+// readAll reads the whole of the open file inst from the start. The error
+// message is "" on success.
+func (files *Files) readAll(inst value.Value) (string, string) {
+	f, ok := files.open[inst.Obj.(*value.ObjInstance).Field("fd").AsInt]
+	if !ok {
+		return "", "File not open"
+	}
+	stat, _ := f.Stat()
+	if stat.Size() == 0 {
+		return "", ""
+	}
+	buf := make([]byte, stat.Size())
+	f.Seek(0, 0)
+	n, err := f.Read(buf)
+	if err != nil && n == 0 {
+		return "", err.Error()
+	}
+	return string(buf[:n]), ""
+}
@@ -0,0 +1,189 @@
+// Package jwt provides the jwt module: issuing and validating JSON Web
+// Tokens signed with HS256 (shared secret) or RS256 (RSA key pair).
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"strings"
+	"time"
+)
+
+var enc = base64.RawURLEncoding
+
+// Register adds the jwt natives to r.
+func Register(r native.Registry) {
+	// jwt_sign(claims, key, alg) -> string
+	// alg is "HS256" (the default; key is the shared secret) or "RS256"
+	// (key is a PEM encoded RSA private key).
+	r.DefineModuleNative("jwt", "sign", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected claims and a key")
+		}
+		if _, ok := args[0].Obj.(*value.ObjMap); !ok {
+			if _, ok := args[0].Obj.(*value.ObjInstance); !ok {
+				return value.NewNativeError("claims must be a map or struct, got %s", value.TypeName(args[0]))
+			}
+		}
+		alg := "HS256"
+		if len(args) > 2 && args[2].Type != value.VAL_NULL {
+			alg = strings.ToUpper(args[2].String())
+		}
+		payload, err := json.Marshal(value.ToJSON(args[0]))
+		if err != nil {
+			return value.NewNativeError("claims are not valid JSON: %s", err)
+		}
+		header, _ := json.Marshal(struct {
+			Alg string `json:"alg"`
+			Typ string `json:"typ"`
+		}{alg, "JWT"})
+		signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signingInput))
+
+		var sig []byte
+		switch alg {
+		case "HS256":
+			sig = hmacSHA256(signingInput, args[1].String())
+		case "RS256":
+			key, err := parsePrivateKey(args[1].String())
+			if err != nil {
+				return value.NewNativeError("%s", err)
+			}
+			sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			if err != nil {
+				return value.NewNativeError("%s", err)
+			}
+		default:
+			return value.NewNativeError("unsupported algorithm %q (use HS256 or RS256)", alg)
+		}
+		return value.NewString(signingInput + "." + enc.EncodeToString(sig))
+	})
+
+	// jwt_verify(token, key) -> map or null
+	// Returns the claims when the signature is valid and the token is
+	// within its "nbf"/"exp" window, or null otherwise. The algorithm
+	// follows the key, never the token: a PEM key (public key,
+	// certificate or private key) accepts only RS256, anything else is an
+	// HS256 secret.
+	r.DefineModuleNative("jwt", "verify", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected a token and a key")
+		}
+		parts := strings.Split(args[0].String(), ".")
+		if len(parts) != 3 {
+			return value.NewNull()
+		}
+		var header struct {
+			Alg string `json:"alg"`
+		}
+		if !decodeJSON(parts[0], &header) {
+			return value.NewNull()
+		}
+		sig, err := enc.DecodeString(parts[2])
+		if err != nil {
+			return value.NewNull()
+		}
+		signingInput := parts[0] + "." + parts[1]
+		key := args[1].String()
+
+		if strings.Contains(key, "-----BEGIN") {
+			pub, err := parsePublicKey(key)
+			if err != nil {
+				return value.NewNativeError("%s", err)
+			}
+			digest := sha256.Sum256([]byte(signingInput))
+			if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+				return value.NewNull()
+			}
+		} else if header.Alg != "HS256" || !hmac.Equal(sig, hmacSHA256(signingInput, key)) {
+			return value.NewNull()
+		}
+
+		var claims map[string]interface{}
+		if !decodeJSON(parts[1], &claims) {
+			return value.NewNull()
+		}
+		now := float64(time.Now().Unix())
+		if exp, ok := claims["exp"].(float64); ok && now >= exp {
+			return value.NewNull()
+		}
+		if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+			return value.NewNull()
+		}
+		return value.FromJSON(claims)
+	})
+}
+
+func hmacSHA256(data, secret string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// decodeJSON decodes one base64url segment of a token into dst.
+func decodeJSON(segment string, dst interface{}) bool {
+	data, err := enc.DecodeString(segment)
+	return err == nil && json.Unmarshal(data, dst) == nil
+}
+
+func parsePrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("RS256 needs a PEM encoded RSA private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("invalid RSA private key: " + err.Error())
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("RS256 needs an RSA key")
+	}
+	return rsaKey, nil
+}
+
+func parsePublicKey(pemKey string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("invalid PEM key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		var priv *rsa.PrivateKey
+		if priv, err = parsePrivateKey(pemKey); err == nil {
+			key = &priv.PublicKey
+		}
+	}
+	if err != nil {
+		return nil, errors.New("invalid RSA key: " + err.Error())
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("RS256 needs an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,21 @@
+// Package markdown provides the markdown module: rendering Markdown as
+// HTML for documentation generators and pages served over HTTP.
+package markdown
+
+import (
+	"noxy-vm/internal/markdown"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+)
+
+// Register adds the markdown natives to r.
+func Register(r native.Registry) {
+	// markdown_to_html(text) -> string
+	// Raw HTML in text is escaped, so untrusted text is safe to render.
+	r.DefineModuleNative("markdown", "to_html", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		return value.NewString(markdown.ToHTML(args[0].String()))
+	})
+}
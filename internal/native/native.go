@@ -0,0 +1,29 @@
+// Package native holds the native modules that the VM can be built with.
+// Each subpackage registers the natives of one domain (io, net, time,
+// strings, bytes, binary, checksum, semver, config, cron, image,
+// markdown, stats, ndarray, sqlite, http, url, jwt) through a Registry,
+// which the VM implements.
+package native
+
+import "noxy-vm/internal/value"
+
+// Registry receives native functions grouped by module. See
+// vm.DefineModuleNative for how scripts reach them.
+type Registry interface {
+	DefineModuleNative(module, name string, fn value.NativeFunc)
+	// ResolvePath makes a relative file path relative to the script's
+	// working directory (see vm.VMConfig.WorkDir).
+	ResolvePath(path string) string
+	// ScriptArgs returns the arguments given to the script after its file
+	// name (see vm.VMConfig.Args).
+	ScriptArgs() []string
+}
+
+// Resources is a table of handles (files, sockets, databases) that scripts
+// open and may forget to close.
+type Resources interface {
+	// OpenResources describes each handle still open, in handle order.
+	OpenResources() []string
+	// CloseAll closes every open handle.
+	CloseAll()
+}
@@ -0,0 +1,277 @@
+// Package ndarray provides the ndarray module: n-dimensional arrays of
+// floats for numeric code, with element-wise arithmetic through the usual
+// operators, dot products, transposes and slices.
+package ndarray
+
+import (
+	"fmt"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/ndarray"
+	"noxy-vm/internal/value"
+)
+
+// Register adds the ndarray natives to r.
+func Register(r native.Registry) {
+	// ndarray_new(shape, fill = 0.0) -> ndarray
+	// shape is an int for a vector or an int[] such as [2, 3] for a matrix.
+	r.DefineModuleNative("ndarray", "new", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "int|array", "number?"); !ok {
+			return err
+		}
+		shape, err := readShape(args[0])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		a, err := ndarray.New(shape)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		if len(args) > 1 {
+			fill := toFloat(args[1])
+			for i := range a.Data {
+				a.Data[i] = fill
+			}
+		}
+		return value.NewNDArray(a)
+	})
+
+	// ndarray_from(xs) -> ndarray
+	// xs is an array of numbers, or of arrays of the same length for more
+	// dimensions: from([[1, 2], [3, 4]]) has shape [2, 2].
+	r.DefineModuleNative("ndarray", "from", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "array"); !ok {
+			return err
+		}
+		a, err := fromNested(args[0])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewNDArray(a)
+	})
+
+	// ndarray_to_array(a) -> array
+	// The elements as nested arrays of floats, the inverse of from.
+	r.DefineModuleNative("ndarray", "to_array", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "ndarray"); !ok {
+			return err
+		}
+		a := args[0].Obj.(*value.ObjNDArray).Array
+		return toNested(a, 0, 0)
+	})
+
+	// ndarray_shape(a) -> int[]
+	r.DefineModuleNative("ndarray", "shape", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "ndarray"); !ok {
+			return err
+		}
+		shape := args[0].Obj.(*value.ObjNDArray).Array.Shape
+		dims := make([]value.Value, len(shape))
+		for i, n := range shape {
+			dims[i] = value.NewInt(int64(n))
+		}
+		return value.NewArray(dims)
+	})
+
+	// ndarray_reshape(a, shape) -> ndarray
+	// A copy of a with a shape of the same number of elements.
+	r.DefineModuleNative("ndarray", "reshape", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "ndarray", "int|array"); !ok {
+			return err
+		}
+		shape, err := readShape(args[1])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		a, err := args[0].Obj.(*value.ObjNDArray).Array.Reshape(shape)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewNDArray(a)
+	})
+
+	// ndarray_get(a, i, j, ...) -> float
+	// The element at one index per dimension.
+	r.DefineModuleNative("ndarray", "get", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "ndarray", "...int"); !ok {
+			return err
+		}
+		a := args[0].Obj.(*value.ObjNDArray).Array
+		off, err := a.Offset(readIndex(args[1:]))
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewFloat(a.Data[off])
+	})
+
+	// ndarray_set(a, i, j, ..., x) -> null
+	// Stores x at one index per dimension, changing a in place.
+	r.DefineModuleNative("ndarray", "set", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "ndarray", "...number"); !ok {
+			return err
+		}
+		if len(args) < 2 {
+			return value.NewNativeError("expects the indexes and the value to store")
+		}
+		index := args[1 : len(args)-1]
+		for i, v := range index {
+			if v.Type != value.VAL_INT {
+				return value.NewNativeError("index %d is %s, not int", i, value.TypeName(v))
+			}
+		}
+		a := args[0].Obj.(*value.ObjNDArray).Array
+		off, err := a.Offset(readIndex(index))
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		a.Data[off] = toFloat(args[len(args)-1])
+		return value.NewNull()
+	})
+
+	// ndarray_dot(a, b) -> ndarray | float
+	// The matrix product. A vector on the left acts as a row and one on the
+	// right as a column; two vectors give their inner product as a float.
+	r.DefineModuleNative("ndarray", "dot", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "ndarray", "ndarray"); !ok {
+			return err
+		}
+		a := args[0].Obj.(*value.ObjNDArray).Array
+		b := args[1].Obj.(*value.ObjNDArray).Array
+		if len(a.Shape) == 1 && len(b.Shape) == 1 {
+			s, err := ndarray.Inner(a, b)
+			if err != nil {
+				return value.NewNativeError("%s", err)
+			}
+			return value.NewFloat(s)
+		}
+		p, err := ndarray.Dot(a, b)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewNDArray(p)
+	})
+
+	// ndarray_transpose(a) -> ndarray
+	// Reverses the axes: rows become columns.
+	r.DefineModuleNative("ndarray", "transpose", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "ndarray"); !ok {
+			return err
+		}
+		return value.NewNDArray(ndarray.Transpose(args[0].Obj.(*value.ObjNDArray).Array))
+	})
+
+	// ndarray_slice(a, start, end, axis = 0) -> ndarray
+	// A copy of the indexes start up to but not including end along axis,
+	// keeping every dimension: slice(m, 0, 1) is the first row as a 1xN
+	// matrix.
+	r.DefineModuleNative("ndarray", "slice", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "ndarray", "int", "int", "int?"); !ok {
+			return err
+		}
+		axis := 0
+		if len(args) > 3 {
+			axis = int(args[3].AsInt)
+		}
+		s, err := ndarray.Slice(args[0].Obj.(*value.ObjNDArray).Array, axis, int(args[1].AsInt), int(args[2].AsInt))
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewNDArray(s)
+	})
+}
+
+func toFloat(v value.Value) float64 {
+	if v.Type == value.VAL_INT {
+		return float64(v.AsInt)
+	}
+	return v.AsFloat
+}
+
+// readShape reads an int or an array of ints as a shape.
+func readShape(v value.Value) ([]int, error) {
+	if v.Type == value.VAL_INT {
+		return []int{int(v.AsInt)}, nil
+	}
+	elems := v.Obj.(*value.ObjArray).Values()
+	shape := make([]int, len(elems))
+	for i, e := range elems {
+		if e.Type != value.VAL_INT {
+			return nil, fmt.Errorf("shape element %d is %s, not int", i, value.TypeName(e))
+		}
+		shape[i] = int(e.AsInt)
+	}
+	return shape, nil
+}
+
+func readIndex(args []value.Value) []int {
+	index := make([]int, len(args))
+	for i, v := range args {
+		index[i] = int(v.AsInt)
+	}
+	return index
+}
+
+// fromNested takes the shape from the first element at each depth and
+// then requires every array at that depth to match it.
+func fromNested(v value.Value) (*ndarray.Array, error) {
+	var shape []int
+	for e := v; ; {
+		arr, ok := e.Obj.(*value.ObjArray)
+		if !ok {
+			break
+		}
+		shape = append(shape, arr.Len())
+		if arr.Len() == 0 {
+			break
+		}
+		e = arr.At(0)
+	}
+	a, err := ndarray.New(shape)
+	if err != nil {
+		return nil, err
+	}
+	a.Data = a.Data[:0]
+	if err := appendNested(a, v, 0, "xs"); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func appendNested(a *ndarray.Array, v value.Value, depth int, path string) error {
+	if depth == len(a.Shape) {
+		if v.Type != value.VAL_INT && v.Type != value.VAL_FLOAT {
+			return fmt.Errorf("%s is %s, not a number", path, value.TypeName(v))
+		}
+		a.Data = append(a.Data, toFloat(v))
+		return nil
+	}
+	arr, ok := v.Obj.(*value.ObjArray)
+	if !ok {
+		return fmt.Errorf("%s is %s, not an array", path, value.TypeName(v))
+	}
+	if arr.Len() != a.Shape[depth] {
+		return fmt.Errorf("%s has %d elements, not %d: the rows must have the same length", path, arr.Len(), a.Shape[depth])
+	}
+	for i, e := range arr.Values() {
+		if err := appendNested(a, e, depth+1, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toNested builds the arrays of a from axis on, starting at Data[off].
+func toNested(a *ndarray.Array, axis, off int) value.Value {
+	stride := 1
+	for _, d := range a.Shape[axis+1:] {
+		stride *= d
+	}
+	elems := make([]value.Value, a.Shape[axis])
+	for i := range elems {
+		if axis == len(a.Shape)-1 {
+			elems[i] = value.NewFloat(a.Data[off+i])
+		} else {
+			elems[i] = toNested(a, axis+1, off+i*stride)
+		}
+	}
+	return value.NewArray(elems)
+}
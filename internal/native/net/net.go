@@ -0,0 +1,787 @@
+// Package net provides the net module: TCP listeners and connections
+// addressed by integer handles, and select over them.
+package net
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// State holds the listeners and connections opened by scripts. It is
+// shared by every thread of a program.
+type State struct {
+	listeners map[int]net.Listener
+	conns     map[int]net.Conn
+	nextID    int
+	lock      sync.Mutex
+
+	// Data and connections peeked by net_select, handed out by the next
+	// net_recv or net_accept on the same handle.
+	bufferedData  map[int][]byte
+	bufferedConns map[int]net.Conn
+
+	// Limits given to net_listen, by listener and by the connections
+	// each listener accepted.
+	limits     map[int]*limits
+	connLimits map[int]*limits
+}
+
+// limits are a listener's options. slots holds a token per open
+// connection when max_conns is set.
+type limits struct {
+	slots  chan struct{}
+	reject bool
+	idle   time.Duration
+	closed chan struct{}
+}
+
+// dialer tries a host's IPv6 and IPv4 addresses in parallel (RFC 6555
+// "happy eyeballs"), falling back to IPv4 when IPv6 has not connected
+// within FallbackDelay.
+var dialer = net.Dialer{
+	Timeout:       5 * time.Second,
+	FallbackDelay: 300 * time.Millisecond,
+}
+
+// hostArg reads a host argument, accepting bracketed IPv6 literals
+// ("[::1]") as well as bare ones ("::1").
+func hostArg(v value.Value) string {
+	host := v.String()
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// parseLimits reads net_listen's options map.
+func parseLimits(v value.Value) (*limits, error) {
+	opts, ok := v.Obj.(*value.ObjMap)
+	if !ok {
+		return nil, fmt.Errorf("options must be a map, got %s", value.TypeName(v))
+	}
+	l := &limits{closed: make(chan struct{})}
+	for _, k := range opts.Keys {
+		key, _ := k.(string)
+		val, _ := opts.Get(k)
+		switch key {
+		case "max_conns":
+			if val.Type != value.VAL_INT || val.AsInt < 0 {
+				return nil, fmt.Errorf("max_conns must be a non-negative int")
+			}
+			if val.AsInt > 0 {
+				l.slots = make(chan struct{}, val.AsInt)
+			}
+		case "on_full":
+			switch val.String() {
+			case "wait":
+			case "reject":
+				l.reject = true
+			default:
+				return nil, fmt.Errorf("on_full must be \"wait\" or \"reject\", got %q", val.String())
+			}
+		case "idle_timeout_ms":
+			if val.Type != value.VAL_INT || val.AsInt < 0 {
+				return nil, fmt.Errorf("idle_timeout_ms must be a non-negative int")
+			}
+			l.idle = time.Duration(val.AsInt) * time.Millisecond
+		default:
+			return nil, fmt.Errorf("unknown listen option '%s'", key)
+		}
+	}
+	return l, nil
+}
+
+// dropConn closes a connection and frees its slot. The caller holds
+// st.lock.
+func (st *State) dropConn(id int) {
+	if conn, ok := st.conns[id]; ok {
+		conn.Close()
+		delete(st.conns, id)
+	}
+	delete(st.bufferedData, id)
+	if l := st.connLimits[id]; l != nil && l.slots != nil {
+		<-l.slots
+	}
+	delete(st.connLimits, id)
+}
+
+// dropListener closes a listener and wakes accepts waiting for a slot.
+// The caller holds st.lock.
+func (st *State) dropListener(id int) {
+	st.listeners[id].Close()
+	delete(st.listeners, id)
+	if conn, ok := st.bufferedConns[id]; ok {
+		conn.Close()
+		delete(st.bufferedConns, id)
+	}
+	if l := st.limits[id]; l != nil {
+		close(l.closed)
+		delete(st.limits, id)
+	}
+}
+
+// OpenResources implements native.Resources.
+func (st *State) OpenResources() []string {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	var open []string
+	for _, id := range sortedIDs(st.listeners) {
+		open = append(open, fmt.Sprintf("listener %d (%s)", id, st.listeners[id].Addr()))
+	}
+	for _, id := range sortedIDs(st.conns) {
+		open = append(open, fmt.Sprintf("connection %d (%s)", id, st.conns[id].RemoteAddr()))
+	}
+	return open
+}
+
+// CloseAll implements native.Resources.
+func (st *State) CloseAll() {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	for id := range st.listeners {
+		st.dropListener(id)
+	}
+	for id := range st.conns {
+		st.dropConn(id)
+	}
+	for id, conn := range st.bufferedConns {
+		conn.Close()
+		delete(st.bufferedConns, id)
+	}
+	for id := range st.bufferedData {
+		delete(st.bufferedData, id)
+	}
+}
+
+func sortedIDs[T any](m map[int]T) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// socketFD reads the handle of a Socket, which natives receive as a map
+// or, once stored in a typed slot, as an instance.
+func socketFD(v value.Value) (int, bool) {
+	var fd value.Value
+	var ok bool
+	switch s := v.Obj.(type) {
+	case *value.ObjMap:
+		fd, ok = s.Data["fd"]
+	case *value.ObjInstance:
+		fd, ok = s.Get("fd")
+	}
+	return int(fd.AsInt), ok && fd.Type == value.VAL_INT
+}
+
+// netResult is what recv, send, send_all and pipe return: a NetResult
+// when the wrapper passes the struct as shape, or the same fields in a map
+// for scripts that call the natives without it.
+func netResult(shape value.Value, errMsg string, data string, count int64) value.Value {
+	return native.NewResult(shape, errMsg, map[string]value.Value{
+		"data":  value.NewBytes(data),
+		"count": value.NewInt(count),
+	})
+}
+
+// classifyError names a socket error for scripts: "timeout", "closed"
+// (by us or the peer), "reset", or the error text for anything else.
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, net.ErrClosed), errors.Is(err, io.EOF), errors.Is(err, syscall.EPIPE):
+		return "closed"
+	}
+	return err.Error()
+}
+
+// accept takes the next connection for listener id, one peeked by
+// net_select first, while honouring the listener's limits. It fails with
+// net.ErrClosed if the listener closes while waiting for a slot.
+func (st *State) accept(id int, listener net.Listener, lim *limits) (net.Conn, error) {
+	for {
+		waiting := lim != nil && lim.slots != nil && !lim.reject
+		if waiting {
+			select {
+			case lim.slots <- struct{}{}:
+			case <-lim.closed:
+				return nil, net.ErrClosed
+			}
+		}
+		st.lock.Lock()
+		conn, buffered := st.bufferedConns[id]
+		delete(st.bufferedConns, id)
+		st.lock.Unlock()
+		var err error
+		if !buffered {
+			conn, err = listener.Accept()
+		}
+		if err != nil {
+			if waiting {
+				<-lim.slots
+			}
+			return nil, err
+		}
+		if lim == nil || lim.slots == nil || waiting {
+			return conn, nil
+		}
+		select {
+		case lim.slots <- struct{}{}:
+			return conn, nil
+		default:
+			// Full: turn the newcomer away
+			conn.Close()
+		}
+	}
+}
+
+// Register adds the net natives to r and returns their state.
+func Register(r native.Registry) *State {
+	st := &State{
+		listeners:     make(map[int]net.Listener),
+		conns:         make(map[int]net.Conn),
+		nextID:        1,
+		bufferedData:  make(map[int][]byte),
+		bufferedConns: make(map[int]net.Conn),
+		limits:        make(map[int]*limits),
+		connLimits:    make(map[int]*limits),
+	}
+
+	// Net Native Functions
+
+	// net_listen(host, port, options) opens a listener. The optional
+	// options map limits the connections it accepts: max_conns caps how
+	// many are open at once, on_full says whether net_accept then "wait"s
+	// for one to close (the default) or "reject"s newcomers by closing
+	// them, and idle_timeout_ms closes connections that send nothing for
+	// that long while a net_recv waits on them.
+	r.DefineModuleNative("net", "listen", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "any", "int", "map|null?"); !ok {
+			return errVal
+		}
+		host := hostArg(args[0])
+		port := int(args[1].AsInt)
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+		var lim *limits
+		if len(args) > 2 && args[2].Type != value.VAL_NULL {
+			var err error
+			if lim, err = parseLimits(args[2]); err != nil {
+				return value.NewNativeError("%s", err)
+			}
+		}
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			// Return Socket with open=false
+			socketFields := map[string]value.Value{
+				"fd":   value.NewInt(-1),
+				"addr": value.NewString(host),
+				"port": value.NewInt(int64(port)),
+				"open": value.NewBool(false),
+			}
+			return value.NewMapWithData(socketFields)
+		}
+
+		st.lock.Lock()
+		id := st.nextID
+		st.nextID++
+		st.listeners[id] = listener
+		if lim != nil {
+			st.limits[id] = lim
+		}
+		st.lock.Unlock()
+
+		// Port 0 asks for any free port; report the one chosen
+		if tcp, ok := listener.Addr().(*net.TCPAddr); ok {
+			port = tcp.Port
+		}
+		socketFields := map[string]value.Value{
+			"fd":   value.NewInt(int64(id)),
+			"addr": value.NewString(host),
+			"port": value.NewInt(int64(port)),
+			"open": value.NewBool(true),
+		}
+		return value.NewMapWithData(socketFields)
+	})
+
+	r.DefineModuleNative("net", "accept", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewNativeError("expected a socket")
+		}
+		fd, ok := socketFD(args[0])
+		if !ok {
+			return value.NewNativeError("expected a socket, got %s", value.TypeName(args[0]))
+		}
+
+		st.lock.Lock()
+		listener, ok := st.listeners[fd]
+		lim := st.limits[fd]
+		st.lock.Unlock()
+
+		if !ok {
+			socketFields := map[string]value.Value{
+				"fd":   value.NewInt(-1),
+				"addr": value.NewString(""),
+				"port": value.NewInt(0),
+				"open": value.NewBool(false),
+			}
+			return value.NewMapWithData(socketFields)
+		}
+
+		// Accept blocks. Lock is released above.
+		conn, err := st.accept(fd, listener, lim)
+		if err != nil {
+			socketFields := map[string]value.Value{
+				"fd":   value.NewInt(-1),
+				"addr": value.NewString(""),
+				"port": value.NewInt(0),
+				"open": value.NewBool(false),
+			}
+			return value.NewMapWithData(socketFields)
+		}
+
+		st.lock.Lock()
+		id := st.nextID
+		st.nextID++
+		st.conns[id] = conn
+		if lim != nil {
+			st.connLimits[id] = lim
+		}
+		st.lock.Unlock()
+
+		remoteAddr := conn.RemoteAddr().String()
+		socketFields := map[string]value.Value{
+			"fd":   value.NewInt(int64(id)),
+			"addr": value.NewString(remoteAddr),
+			"port": value.NewInt(0),
+			"open": value.NewBool(true),
+		}
+		return value.NewMapWithData(socketFields)
+	})
+
+	r.DefineModuleNative("net", "connect", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "any", "int"); !ok {
+			return errVal
+		}
+		host := hostArg(args[0])
+		port := int(args[1].AsInt)
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+		conn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			socketFields := map[string]value.Value{
+				"fd":   value.NewInt(-1),
+				"addr": value.NewString(host),
+				"port": value.NewInt(int64(port)),
+				"open": value.NewBool(false),
+			}
+			return value.NewMapWithData(socketFields)
+		}
+
+		st.lock.Lock()
+		id := st.nextID
+		st.nextID++
+		st.conns[id] = conn
+		st.lock.Unlock()
+
+		socketFields := map[string]value.Value{
+			"fd":   value.NewInt(int64(id)),
+			"addr": value.NewString(host),
+			"port": value.NewInt(int64(port)),
+			"open": value.NewBool(true),
+		}
+		return value.NewMapWithData(socketFields)
+	})
+
+	r.DefineModuleNative("net", "recv", func(args []value.Value) value.Value {
+		if len(args) < 2 || args[1].Type != value.VAL_INT {
+			return value.NewNativeError("expected a socket and a size")
+		}
+		fd, ok := socketFD(args[0])
+		if !ok {
+			return value.NewNativeError("expected a socket, got %s", value.TypeName(args[0]))
+		}
+		size := int(args[1].AsInt)
+		if size < 0 {
+			return value.NewNativeError("negative size %d", size)
+		}
+		shape := native.ResultArg(args, 2)
+
+		st.lock.Lock()
+		conn, ok := st.conns[fd]
+		lim := st.connLimits[fd]
+		st.lock.Unlock()
+
+		if !ok {
+			return netResult(shape, "invalid socket", "", 0)
+		}
+		if lim != nil && lim.idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(lim.idle))
+		}
+
+		var n int
+		buf := make([]byte, size)
+
+		// Check buffered data from select
+		st.lock.Lock()
+		if buffered, ok := st.bufferedData[fd]; ok {
+			// Copy buffered data
+			copy(buf, buffered)
+			n = len(buffered)
+			delete(st.bufferedData, fd)
+		}
+		st.lock.Unlock()
+
+		// Try to read more if space available
+		if n < size {
+			// Blocking read (no deadline unless idle_timeout_ms is set)
+			n2, err2 := conn.Read(buf[n:])
+			if n2 > 0 {
+				n += n2
+			}
+
+			// Ignore timeout errors if we have at least some data
+			if err2 != nil {
+				if n == 0 {
+					// Only return error if we really got nothing
+					if err2 != nil && n2 == 0 {
+						if err2 == io.EOF {
+							// Return ok=true, count=0 for EOF
+							return netResult(shape, "", "", 0)
+						}
+						errStr := err2.Error()
+						if lim != nil && lim.idle > 0 && classifyError(err2) == "timeout" {
+							// A silent client: hang up on it
+							st.lock.Lock()
+							st.dropConn(fd)
+							st.lock.Unlock()
+							errStr = "idle timeout"
+						}
+						return netResult(shape, errStr, "", 0)
+					}
+				}
+			}
+		}
+
+		return netResult(shape, "", string(buf[:n]), int64(n))
+	})
+
+	r.DefineModuleNative("net", "send", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected a socket and data")
+		}
+		fd, ok := socketFD(args[0])
+		if !ok {
+			return value.NewNativeError("expected a socket, got %s", value.TypeName(args[0]))
+		}
+		shape := native.ResultArg(args, 2)
+		var data string
+		if args[1].Type == value.VAL_BYTES {
+			data = args[1].Obj.(string)
+		} else {
+			data = args[1].String()
+		}
+
+		st.lock.Lock()
+		conn, ok := st.conns[fd]
+		st.lock.Unlock()
+
+		if !ok {
+			return netResult(shape, "invalid socket", "", 0)
+		}
+
+		n, err := conn.Write([]byte(data))
+		if err != nil {
+			return netResult(shape, err.Error(), "", 0)
+		}
+
+		return netResult(shape, "", "", int64(n))
+	})
+
+	// net_send_all(sock, data, timeout_ms) writes all of data, waiting up
+	// to timeout_ms (0 for no limit) for a slow peer to accept it. count
+	// is how much was written even on failure, and error names the
+	// failure: "timeout", "closed", "reset" or the system message.
+	r.DefineModuleNative("net", "send_all", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected a socket and data")
+		}
+		fd, _ := socketFD(args[0])
+		var data string
+		if args[1].Type == value.VAL_BYTES {
+			data = args[1].Obj.(string)
+		} else {
+			data = args[1].String()
+		}
+		var timeout time.Duration
+		if len(args) > 2 && args[2].Type == value.VAL_INT {
+			timeout = time.Duration(args[2].AsInt) * time.Millisecond
+		}
+		shape := native.ResultArg(args, 3)
+
+		st.lock.Lock()
+		conn, ok := st.conns[fd]
+		st.lock.Unlock()
+
+		if !ok {
+			return netResult(shape, "closed", "", 0)
+		}
+
+		if timeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			defer conn.SetWriteDeadline(time.Time{})
+		}
+		// Write only returns early with an error, so a short count
+		// always comes with one.
+		n, err := conn.Write([]byte(data))
+		return netResult(shape, classifyError(err), "", int64(n))
+	})
+
+	r.DefineModuleNative("net", "close", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewNativeError("expected a socket")
+		}
+
+		// net.nx passes the fd; a whole socket works too
+		fd, ok := socketFD(args[0])
+		if args[0].Type == value.VAL_INT {
+			fd, ok = int(args[0].AsInt), true
+		}
+		if !ok {
+			return value.NewNativeError("expected a socket, got %s", value.TypeName(args[0]))
+		}
+
+		st.lock.Lock()
+		defer st.lock.Unlock()
+
+		// Try closing as listener
+		if _, ok := st.listeners[fd]; ok {
+			st.dropListener(fd)
+			return value.NewNull()
+		}
+
+		// Try closing as connection
+		st.dropConn(fd)
+
+		return value.NewNull()
+	})
+
+	// net_pipe(a, b) copies data both ways between two connections until
+	// each side has finished sending, then closes both. It blocks, so
+	// forwarders run it in a spawned routine per connection.
+	r.DefineModuleNative("net", "pipe", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected two sockets")
+		}
+		fdA, okA := socketFD(args[0])
+		fdB, okB := socketFD(args[1])
+		shape := native.ResultArg(args, 2)
+
+		st.lock.Lock()
+		connA, foundA := st.conns[fdA]
+		connB, foundB := st.conns[fdB]
+		pendingA, pendingB := st.bufferedData[fdA], st.bufferedData[fdB]
+		if okA && okB && foundA && foundB && fdA != fdB {
+			delete(st.bufferedData, fdA)
+			delete(st.bufferedData, fdB)
+		}
+		st.lock.Unlock()
+
+		if !okA || !okB || !foundA || !foundB || fdA == fdB {
+			return netResult(shape, "invalid socket", "", 0)
+		}
+
+		type half struct {
+			n   int64
+			err error
+		}
+		done := make(chan half, 2)
+		copyHalf := func(dst, src net.Conn, pending []byte) {
+			var h half
+			if len(pending) > 0 {
+				n, err := dst.Write(pending)
+				h.n, h.err = int64(n), err
+			}
+			if h.err == nil {
+				n, err := io.Copy(dst, src)
+				h.n += n
+				h.err = err
+			}
+			if h.err != nil {
+				// Unblock the other direction too
+				connA.Close()
+				connB.Close()
+			} else if tcp, ok := dst.(*net.TCPConn); ok {
+				// Pass the EOF on while the other direction keeps going
+				tcp.CloseWrite()
+			}
+			done <- h
+		}
+		go copyHalf(connB, connA, pendingA)
+		go copyHalf(connA, connB, pendingB)
+
+		var total int64
+		var firstErr error
+		for i := 0; i < 2; i++ {
+			h := <-done
+			total += h.n
+			if firstErr == nil {
+				firstErr = h.err
+			}
+		}
+
+		st.lock.Lock()
+		st.dropConn(fdA)
+		st.dropConn(fdB)
+		st.lock.Unlock()
+
+		errStr := ""
+		if firstErr != nil {
+			errStr = firstErr.Error()
+		}
+		return netResult(shape, errStr, "", total)
+	})
+
+	r.DefineModuleNative("net", "setblocking", func(args []value.Value) value.Value {
+		// For TCP in Go, blocking is handled at a different level
+		// This is a no-op for now, as Go handles timeouts via SetDeadline
+		return value.NewNull()
+	})
+
+	r.DefineModuleNative("net", "select", func(args []value.Value) value.Value {
+		// args: read, write (ignored), err (ignored), timeout
+		if len(args) < 4 || args[3].Type != value.VAL_INT {
+			return value.NewNativeError("expected read, write and error sets and a timeout")
+		}
+
+		timeoutMs := int(args[3].AsInt)
+		// Minimum 1ms to allow polling
+		if timeoutMs < 1 {
+			timeoutMs = 1
+		}
+
+		// Prepare Result Data
+		readyRead := make([]value.Value, 0)
+
+		// Parse Read Set
+		readArrVal := args[0]
+		if readArrVal.Type == value.VAL_OBJ {
+			if arr, ok := readArrVal.Obj.(*value.ObjArray); ok {
+				for _, el := range arr.Values() {
+					if el.Type == value.VAL_OBJ { // Check if socket (Map or Instance)
+						// Extract FD
+						var fd int64 = -1
+
+						if m, ok := el.Obj.(*value.ObjMap); ok {
+							if f, ok := m.Data["fd"]; ok {
+								fd = f.AsInt
+							}
+						} else if inst, ok := el.Obj.(*value.ObjInstance); ok {
+							if f, ok := inst.Get("fd"); ok {
+								fd = f.AsInt
+							}
+						}
+
+						if fd != -1 {
+							isReady := false
+							id := int(fd)
+
+							// 1. Check buffers first
+							if _, ok := st.bufferedConns[id]; ok {
+								isReady = true
+							} else if _, ok := st.bufferedData[id]; ok {
+								isReady = true
+							} else {
+								// 2. Poll
+								st.lock.Lock()
+								l, isListener := st.listeners[id]
+								c, isConn := st.conns[id]
+								st.lock.Unlock()
+
+								if isListener {
+									if tcpL, ok := l.(*net.TCPListener); ok {
+										tcpL.SetDeadline(time.Now().Add(time.Millisecond * time.Duration(timeoutMs)))
+										conn, err := l.Accept()
+										if err == nil {
+											isReady = true
+											st.bufferedConns[id] = conn
+										}
+										// Reset deadline?
+										tcpL.SetDeadline(time.Time{})
+									}
+								} else if isConn {
+									conn := c
+									conn.SetReadDeadline(time.Now().Add(time.Millisecond * time.Duration(timeoutMs)))
+									buf := make([]byte, 1) // Peek 1 byte
+									n, err := conn.Read(buf)
+									if err == nil && n > 0 {
+										isReady = true
+										// Buffer the data
+										st.bufferedData[id] = buf[:n]
+									}
+									// Reset deadline
+									conn.SetReadDeadline(time.Time{})
+								}
+							}
+
+							if isReady {
+								readyRead = append(readyRead, el)
+							}
+						}
+					}
+				}
+			}
+		}
+
+		// Construct SelectResult Map
+		// struct SelectResult { read: Socket[64], read_count: int, ... }
+
+		// Fill read array up to 64
+		resReadArr := make([]value.Value, 64)
+		for i := 0; i < 64; i++ {
+			if i < len(readyRead) {
+				resReadArr[i] = readyRead[i]
+			} else {
+				resReadArr[i] = value.NewNull()
+			}
+		}
+
+		// Empties for others
+		emptyArr := make([]value.Value, 64)
+		for i := 0; i < 64; i++ {
+			emptyArr[i] = value.NewNull()
+		}
+
+		resFields := map[string]value.Value{
+			"read":        value.NewArray(resReadArr),
+			"read_count":  value.NewInt(int64(len(readyRead))),
+			"write":       value.NewArray(emptyArr),
+			"write_count": value.NewInt(0),
+			"error":       value.NewArray(emptyArr),
+			"error_count": value.NewInt(0),
+		}
+		return value.NewMapWithData(resFields)
+	})
+
+	return st
+}
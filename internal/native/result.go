@@ -0,0 +1,47 @@
+package native
+
+import "noxy-vm/internal/value"
+
+// NewResult builds what a native returns for an operation that can fail
+// without the script being wrong, such as a read, a send or a query. The
+// result is an instance of shape, the struct the module's wrapper passes
+// (IOResult, NetResult, ExecResult, ...), holding ok, error ("" when ok)
+// and the operation's own fields. errMsg is the failure, or "" on success.
+//
+// For scripts written before the convention, shape may also be an
+// instance of the struct, as the sqlite wrappers used to pass, or null,
+// which gives a map with the same fields, as net natives used to return.
+func NewResult(shape value.Value, errMsg string, fields map[string]value.Value) value.Value {
+	var def *value.ObjStruct
+	switch s := shape.Obj.(type) {
+	case *value.ObjStruct:
+		def = s
+	case *value.ObjInstance:
+		def = s.Struct
+	default:
+		data := make(map[string]value.Value, len(fields)+2)
+		for name, v := range fields {
+			data[name] = v
+		}
+		data["ok"] = value.NewBool(errMsg == "")
+		data["error"] = value.NewString(errMsg)
+		return value.NewMapWithData(data)
+	}
+	res := value.NewInstance(def)
+	inst := res.Obj.(*value.ObjInstance)
+	inst.Set("ok", value.NewBool(errMsg == ""))
+	inst.Set("error", value.NewString(errMsg))
+	for name, v := range fields {
+		inst.Set(name, v)
+	}
+	return res
+}
+
+// ResultArg returns the result struct passed as args[i], or null when the
+// caller left it out (see NewResult).
+func ResultArg(args []value.Value, i int) value.Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return value.NewNull()
+}
@@ -0,0 +1,65 @@
+// Package semver provides the semver module: parsing and comparing
+// semantic versions and checking them against ranges, with the same rules
+// the package manager resolves versions by.
+package semver
+
+import (
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/semver"
+	"noxy-vm/internal/value"
+)
+
+// Register adds the semver natives to r.
+func Register(r native.Registry) {
+	// semver_parse(version) -> map
+	// {"major", "minor", "patch", "pre", "build"}, or null if version is
+	// not a semantic version.
+	r.DefineModuleNative("semver", "parse", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		v, ok := semver.Parse(args[0].String())
+		if !ok {
+			return value.NewNull()
+		}
+		return value.NewMapWithData(map[string]value.Value{
+			"major": value.NewInt(int64(v.Major)),
+			"minor": value.NewInt(int64(v.Minor)),
+			"patch": value.NewInt(int64(v.Patch)),
+			"pre":   value.NewString(v.Pre),
+			"build": value.NewString(v.Build),
+		})
+	})
+
+	// semver_compare(a, b) -> int
+	r.DefineModuleNative("semver", "compare", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string"); !ok {
+			return err
+		}
+		a, ok := semver.Parse(args[0].String())
+		if !ok {
+			return value.NewNativeError("invalid version %q", args[0].String())
+		}
+		b, ok := semver.Parse(args[1].String())
+		if !ok {
+			return value.NewNativeError("invalid version %q", args[1].String())
+		}
+		return value.NewInt(int64(semver.Compare(a, b)))
+	})
+
+	// semver_satisfies(version, range) -> bool
+	r.DefineModuleNative("semver", "satisfies", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string"); !ok {
+			return err
+		}
+		v, ok := semver.Parse(args[0].String())
+		if !ok {
+			return value.NewNativeError("invalid version %q", args[0].String())
+		}
+		rng, err := semver.ParseRange(args[1].String())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewBool(rng.Contains(v))
+	})
+}
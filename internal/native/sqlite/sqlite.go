@@ -0,0 +1,456 @@
+//go:build !js
+
+// Package sqlite provides the sqlite module: databases and prepared
+// statements addressed by integer handles.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"sort"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// State holds the databases and statements opened by scripts. It is
+// shared by every thread of a program.
+type State struct {
+	dbs      map[int]*sql.DB
+	stmts    map[int]*sql.Stmt
+	params   map[int]map[int]interface{}
+	nextDB   int
+	nextStmt int
+	lock     sync.Mutex
+}
+
+// OpenResources implements native.Resources.
+func (st *State) OpenResources() []string {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	var open []string
+	for _, h := range sortedHandles(st.dbs) {
+		open = append(open, fmt.Sprintf("sqlite database %d", h))
+	}
+	for _, h := range sortedHandles(st.stmts) {
+		open = append(open, fmt.Sprintf("sqlite statement %d", h))
+	}
+	return open
+}
+
+// CloseAll implements native.Resources. Statements are closed before the
+// databases they belong to.
+func (st *State) CloseAll() {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	for h, stmt := range st.stmts {
+		stmt.Close()
+		delete(st.stmts, h)
+		delete(st.params, h)
+	}
+	for h, db := range st.dbs {
+		db.Close()
+		delete(st.dbs, h)
+	}
+}
+
+func sortedHandles[T any](m map[int]T) []int {
+	handles := make([]int, 0, len(m))
+	for h := range m {
+		handles = append(handles, h)
+	}
+	sort.Ints(handles)
+	return handles
+}
+
+// Register adds the sqlite natives to r and returns their state.
+func Register(r native.Registry) *State {
+	st := &State{
+		dbs:      make(map[int]*sql.DB),
+		stmts:    make(map[int]*sql.Stmt),
+		params:   make(map[int]map[int]interface{}),
+		nextDB:   1,
+		nextStmt: 1,
+	}
+
+	// SQLite Native Functions
+	r.DefineModuleNative("sqlite", "open", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "string", "struct|instance"); !ok {
+			return errVal
+		}
+		path := args[0].String()
+		structDef := structOf(args[1])
+
+		db, err := sql.Open("sqlite", path)
+		openVal := true
+		if err != nil {
+			openVal = false
+		} else {
+			if err = db.Ping(); err != nil {
+				openVal = false
+			}
+		}
+
+		st.lock.Lock()
+		id := st.nextDB
+		st.nextDB++
+		st.dbs[id] = db
+		st.lock.Unlock()
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("handle", value.NewInt(int64(id)))
+		inst.Set("open", value.NewBool(openVal))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+
+	r.DefineModuleNative("sqlite", "close", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance"); !ok {
+			return errVal
+		}
+		dbInst := args[0].Obj.(*value.ObjInstance)
+
+		handle := int(dbInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		defer st.lock.Unlock()
+
+		if db, ok := st.dbs[handle]; ok {
+			db.Close()
+			delete(st.dbs, handle)
+			dbInst.Set("open", value.NewBool(false))
+		}
+		return value.NewNull()
+	})
+
+	r.DefineModuleNative("sqlite", "exec", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance", "string", "struct|instance"); !ok {
+			return errVal
+		}
+		dbInst := args[0].Obj.(*value.ObjInstance)
+		sqlStr := args[1].String()
+		shape := args[2]
+
+		handle := int(dbInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		db, ok := st.dbs[handle]
+		st.lock.Unlock() // Unlock for Exec
+
+		if ok {
+			result, err := db.Exec(sqlStr)
+			return execResult(shape, result, err)
+		}
+		// Invalid handle
+		return execResult(shape, nil, errors.New("invalid database handle"))
+	})
+
+	r.DefineModuleNative("sqlite", "exec_params", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance", "string", "array", "struct|instance"); !ok {
+			return errVal
+		}
+		dbInst := args[0].Obj.(*value.ObjInstance)
+		sqlStr := args[1].String()
+		paramsArray := args[2].Obj.(*value.ObjArray)
+		shape := args[3]
+
+		handle := int(dbInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		db, ok := st.dbs[handle]
+		st.lock.Unlock()
+
+		if ok {
+			// Convert params
+			queryArgs := make([]interface{}, paramsArray.Len())
+			for i, val := range paramsArray.Values() {
+				switch val.Type {
+				case value.VAL_INT:
+					queryArgs[i] = val.AsInt
+				case value.VAL_FLOAT:
+					queryArgs[i] = val.AsFloat
+				case value.VAL_BOOL:
+					queryArgs[i] = val.AsBool
+				case value.VAL_NULL:
+					queryArgs[i] = nil
+				case value.VAL_OBJ:
+					if b, ok := val.Obj.(string); ok {
+						queryArgs[i] = b
+					} else {
+						queryArgs[i] = val.String()
+					}
+				default:
+					queryArgs[i] = val.String()
+				}
+			}
+
+			result, err := db.Exec(sqlStr, queryArgs...)
+			return execResult(shape, result, err)
+		}
+		// Invalid handle
+		return execResult(shape, nil, errors.New("invalid database handle"))
+	})
+
+	r.DefineModuleNative("sqlite", "prepare", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance", "string", "struct|instance"); !ok {
+			return errVal
+		}
+		dbInst := args[0].Obj.(*value.ObjInstance)
+		sqlStr := args[1].String()
+		stmtStructDef := structOf(args[2])
+
+		handle := int(dbInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		db, ok := st.dbs[handle]
+		st.lock.Unlock()
+
+		if !ok {
+			return value.Errorf("invalid database handle")
+		}
+		stmt, err := db.Prepare(sqlStr)
+		if err != nil {
+			return value.Errorf("%s", err)
+		}
+		st.lock.Lock()
+		id := st.nextStmt
+		st.nextStmt++
+		st.stmts[id] = stmt
+		st.params[id] = make(map[int]interface{})
+		st.lock.Unlock()
+
+		inst := value.NewInstance(stmtStructDef).Obj.(*value.ObjInstance)
+		inst.Set("handle", value.NewInt(int64(id)))
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+
+	// bindFunc records val for the statement and index in args, which
+	// the bind natives have already checked.
+	bindFunc := func(args []value.Value, val interface{}) value.Value {
+		stmtInst := args[0].Obj.(*value.ObjInstance)
+		idx := int(args[1].AsInt)
+
+		handle := int(stmtInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		defer st.lock.Unlock()
+
+		if _, ok := st.stmts[handle]; ok {
+			if st.params[handle] == nil {
+				st.params[handle] = make(map[int]interface{})
+			}
+			st.params[handle][idx] = val
+		}
+		return value.NewNull()
+	}
+
+	r.DefineModuleNative("sqlite", "bind_text", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance", "int", "any"); !ok {
+			return errVal
+		}
+		return bindFunc(args, args[2].String())
+	})
+	r.DefineModuleNative("sqlite", "bind_float", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance", "int", "number"); !ok {
+			return errVal
+		}
+		f := args[2].AsFloat
+		if args[2].Type == value.VAL_INT {
+			f = float64(args[2].AsInt)
+		}
+		return bindFunc(args, f)
+	})
+	r.DefineModuleNative("sqlite", "bind_int", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance", "int", "int"); !ok {
+			return errVal
+		}
+		return bindFunc(args, args[2].AsInt)
+	})
+
+	r.DefineModuleNative("sqlite", "step_exec", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance", "struct|instance"); !ok {
+			return errVal
+		}
+		stmtInst := args[0].Obj.(*value.ObjInstance)
+		shape := args[1]
+
+		handle := int(stmtInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		stmt, ok := st.stmts[handle]
+		var params map[int]interface{}
+		if ok {
+			origParams := st.params[handle]
+			params = make(map[int]interface{})
+			for k, v := range origParams {
+				params[k] = v
+			}
+		}
+		st.lock.Unlock()
+
+		if ok {
+			// params := vm.stmtParams[handle] // Replaced by copy
+			var maxIdx int
+			for k := range params {
+				if k > maxIdx {
+					maxIdx = k
+				}
+			}
+			argsList := make([]interface{}, maxIdx)
+			for k, v := range params {
+				if k > 0 && k <= maxIdx {
+					argsList[k-1] = v
+				}
+			}
+			result, err := stmt.Exec(argsList...)
+
+			return execResult(shape, result, err)
+		}
+
+		return execResult(shape, nil, errors.New("invalid statement handle"))
+	})
+
+	r.DefineModuleNative("sqlite", "reset", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance"); !ok {
+			return errVal
+		}
+		stmtInst := args[0].Obj.(*value.ObjInstance)
+		handle := int(stmtInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		defer st.lock.Unlock()
+
+		if _, ok := st.stmts[handle]; ok {
+			st.params[handle] = make(map[int]interface{})
+		}
+		return value.NewNull()
+	})
+
+	r.DefineModuleNative("sqlite", "finalize", func(args []value.Value) value.Value {
+		if errVal, ok := native.CheckArgs(args, "instance"); !ok {
+			return errVal
+		}
+		stmtInst := args[0].Obj.(*value.ObjInstance)
+		handle := int(stmtInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		defer st.lock.Unlock()
+
+		if stmt, ok := st.stmts[handle]; ok {
+			stmt.Close()
+			delete(st.stmts, handle)
+			delete(st.params, handle)
+		}
+		return value.NewNull()
+	})
+
+	r.DefineModuleNative("sqlite", "query", func(args []value.Value) value.Value {
+		// db, sql, result and row templates
+		if errVal, ok := native.CheckArgs(args, "instance", "string", "struct|instance", "struct|instance"); !ok {
+			return errVal
+		}
+		dbInst := args[0].Obj.(*value.ObjInstance)
+		sqlStr := args[1].String()
+		shape := args[2]
+		rowStruct := structOf(args[3])
+
+		handle := int(dbInst.Field("handle").AsInt)
+
+		st.lock.Lock()
+		db, ok := st.dbs[handle]
+		st.lock.Unlock()
+
+		if ok {
+			rows, err := db.Query(sqlStr)
+			if err != nil {
+				return queryResult(shape, err.Error(), nil, nil)
+			}
+			defer rows.Close()
+
+			cols, _ := rows.Columns()
+			colVals := make([]value.Value, len(cols))
+			for i, c := range cols {
+				colVals[i] = value.NewString(c)
+			}
+
+			var rowInsts []value.Value
+
+			for rows.Next() {
+				// Scan to interface{}
+				dest := make([]interface{}, len(cols))
+				destPtrs := make([]interface{}, len(cols))
+				for i := range dest {
+					destPtrs[i] = &dest[i]
+				}
+
+				rows.Scan(destPtrs...)
+
+				rowVals := make([]value.Value, len(cols))
+				for i, v := range dest {
+					// Convert Go type to Noxy value
+					switch tv := v.(type) {
+					case nil:
+						rowVals[i] = value.NewNull()
+					case int64:
+						rowVals[i] = value.NewInt(tv)
+					case float64:
+						rowVals[i] = value.NewFloat(tv)
+					case string:
+						rowVals[i] = value.NewString(tv)
+					case []byte:
+						rowVals[i] = value.NewString(string(tv))
+					default:
+						rowVals[i] = value.NewString(fmt.Sprintf("%v", tv))
+					}
+				}
+
+				// Create Row instance
+				rowInst := value.NewInstance(rowStruct).Obj.(*value.ObjInstance)
+				rowInst.Set("values", value.NewArray(rowVals))
+				rowInsts = append(rowInsts, value.Value{Type: value.VAL_OBJ, Obj: rowInst})
+			}
+
+			return queryResult(shape, "", colVals, rowInsts)
+		}
+		return queryResult(shape, "invalid database handle", nil, nil)
+	})
+
+	return st
+}
+
+// execResult is the ExecResult of a statement run with Exec.
+func execResult(shape value.Value, result sql.Result, err error) value.Value {
+	if err != nil {
+		return native.NewResult(shape, err.Error(), map[string]value.Value{
+			"rows_affected":  value.NewInt(0),
+			"last_insert_id": value.NewInt(0),
+		})
+	}
+	rowsAffected, _ := result.RowsAffected()
+	lastID, _ := result.LastInsertId()
+	return native.NewResult(shape, "", map[string]value.Value{
+		"rows_affected":  value.NewInt(rowsAffected),
+		"last_insert_id": value.NewInt(lastID),
+	})
+}
+
+// queryResult is the QueryResult of a query, empty when errMsg is set.
+func queryResult(shape value.Value, errMsg string, columns, rows []value.Value) value.Value {
+	return native.NewResult(shape, errMsg, map[string]value.Value{
+		"columns":   value.NewArray(columns),
+		"rows":      value.NewArray(rows),
+		"row_count": value.NewInt(int64(len(rows))),
+	})
+}
+
+// structOf is the struct a wrapper passes, or the struct of an instance
+// passed as a template by older wrappers.
+func structOf(v value.Value) *value.ObjStruct {
+	if inst, ok := v.Obj.(*value.ObjInstance); ok {
+		return inst.Struct
+	}
+	return v.Obj.(*value.ObjStruct)
+}
@@ -0,0 +1,279 @@
+// Package stats provides the stats module: sums, averages, spread,
+// percentiles and histograms over arrays of numbers, computed in ways that
+// keep rounding errors small (compensated sums, two-pass variance).
+package stats
+
+import (
+	"fmt"
+	"math"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"sort"
+)
+
+// maxBins bounds the histogram natives, whose result has one count per
+// bin.
+const maxBins = 100000
+
+// Register adds the stats natives to r.
+func Register(r native.Registry) {
+	// stats_sum(xs) -> int | float
+	// An int when every element is an int (an error if it overflows),
+	// otherwise a float summed with Neumaier's compensation, so
+	// sum([0.1, 0.2, 0.3]) is 0.6.
+	r.DefineModuleNative("stats", "sum", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "array"); !ok {
+			return err
+		}
+		elems := args[0].Obj.(*value.ObjArray).Values()
+		var total int64
+		for i, e := range elems {
+			if e.Type != value.VAL_INT {
+				xs, err := numbers(args[0])
+				if err != nil {
+					return value.NewNativeError("%s", err)
+				}
+				return value.NewFloat(sum(xs))
+			}
+			next := total + e.AsInt
+			if (e.AsInt > 0 && next < total) || (e.AsInt < 0 && next > total) {
+				return value.NewNativeError("the sum overflows int at element %d; convert the elements to float", i)
+			}
+			total = next
+		}
+		return value.NewInt(total)
+	})
+
+	// stats_mean(xs) -> float
+	r.DefineModuleNative("stats", "mean", func(args []value.Value) value.Value {
+		xs, err, ok := nonEmpty(args, "array")
+		if !ok {
+			return err
+		}
+		return value.NewFloat(mean(xs))
+	})
+
+	// stats_median(xs) -> float
+	// The middle element, or the mean of the two middle ones.
+	r.DefineModuleNative("stats", "median", func(args []value.Value) value.Value {
+		xs, err, ok := nonEmpty(args, "array")
+		if !ok {
+			return err
+		}
+		return value.NewFloat(percentile(sorted(xs), 50))
+	})
+
+	// stats_stddev(xs, sample = false) -> float
+	// The population standard deviation, or with sample the sample one
+	// (dividing by n - 1).
+	r.DefineModuleNative("stats", "stddev", func(args []value.Value) value.Value {
+		xs, err, ok := nonEmpty(args, "array", "bool?")
+		if !ok {
+			return err
+		}
+		sample := len(args) > 1 && args[1].AsBool
+		if sample && len(xs) < 2 {
+			return value.NewNativeError("the sample standard deviation needs at least 2 elements")
+		}
+		return value.NewFloat(math.Sqrt(variance(xs, sample)))
+	})
+
+	// stats_percentile(xs, p) -> float
+	// p is from 0 to 100. Between elements the value is interpolated
+	// linearly, so percentile(xs, 50) is the median.
+	r.DefineModuleNative("stats", "percentile", func(args []value.Value) value.Value {
+		xs, err, ok := nonEmpty(args, "array", "number")
+		if !ok {
+			return err
+		}
+		p := toFloat(args[1])
+		if !(p >= 0 && p <= 100) {
+			return value.NewNativeError("percentile must be from 0 to 100, got %s", args[1].String())
+		}
+		return value.NewFloat(percentile(sorted(xs), p))
+	})
+
+	// stats_histogram(xs, bins, lo?, hi?) -> map
+	// Counts the elements in bins of equal width from lo to hi (the
+	// smallest and largest elements by default). The result has "counts",
+	// an int per bin, and "edges", the bins+1 bounds; each bin holds
+	// lo <= x < hi except the last, which also holds its upper edge.
+	// Elements outside lo..hi are not counted.
+	r.DefineModuleNative("stats", "histogram", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "array", "int", "number?", "number?"); !ok {
+			return err
+		}
+		if len(args) == 3 {
+			return value.NewNativeError("pass both lo and hi, or neither")
+		}
+		xs, err := numbers(args[0])
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		bins := args[1].AsInt
+		if bins < 1 || bins > maxBins {
+			return value.NewNativeError("bins must be from 1 to %d, got %d", maxBins, bins)
+		}
+		var lo, hi float64
+		if len(args) == 4 {
+			lo, hi = toFloat(args[2]), toFloat(args[3])
+			if !(lo < hi) {
+				return value.NewNativeError("lo must be less than hi, got %s and %s", args[2].String(), args[3].String())
+			}
+		} else {
+			lo, hi = dataRange(xs)
+		}
+		edges, counts := histogram(xs, int(bins), lo, hi)
+		edgeVals := make([]value.Value, len(edges))
+		for i, e := range edges {
+			edgeVals[i] = value.NewFloat(e)
+		}
+		countVals := make([]value.Value, len(counts))
+		for i, n := range counts {
+			countVals[i] = value.NewInt(int64(n))
+		}
+		return value.NewMapWithData(map[string]value.Value{
+			"counts": value.NewArray(countVals),
+			"edges":  value.NewArray(edgeVals),
+		})
+	})
+}
+
+// numbers reads an array of ints and floats as floats.
+func numbers(v value.Value) ([]float64, error) {
+	arr := v.Obj.(*value.ObjArray)
+	if arr.Kind == value.ElemFloats {
+		return append([]float64(nil), arr.Floats...), nil
+	}
+	elems := arr.Values()
+	xs := make([]float64, len(elems))
+	for i, e := range elems {
+		if e.Type != value.VAL_INT && e.Type != value.VAL_FLOAT {
+			return nil, fmt.Errorf("element %d is %s, not a number", i, value.TypeName(e))
+		}
+		xs[i] = toFloat(e)
+	}
+	return xs, nil
+}
+
+// nonEmpty checks args against params, the first of which is the array,
+// and reads the array, which must have elements. Like native.CheckArgs it
+// returns false and a native error when they do not.
+func nonEmpty(args []value.Value, params ...string) ([]float64, value.Value, bool) {
+	if err, ok := native.CheckArgs(args, params...); !ok {
+		return nil, err, false
+	}
+	xs, err := numbers(args[0])
+	if err != nil {
+		return nil, value.NewNativeError("%s", err), false
+	}
+	if len(xs) == 0 {
+		return nil, value.NewNativeError("the array is empty"), false
+	}
+	return xs, value.Value{}, true
+}
+
+func toFloat(v value.Value) float64 {
+	if v.Type == value.VAL_INT {
+		return float64(v.AsInt)
+	}
+	return v.AsFloat
+}
+
+// sum adds xs with Neumaier's compensation, which keeps the low-order
+// bits that a plain loop loses when adding numbers of different size.
+func sum(xs []float64) float64 {
+	var s, c float64
+	for _, x := range xs {
+		t := s + x
+		if math.Abs(s) >= math.Abs(x) {
+			c += (s - t) + x
+		} else {
+			c += (x - t) + s
+		}
+		s = t
+	}
+	return s + c
+}
+
+func mean(xs []float64) float64 {
+	return sum(xs) / float64(len(xs))
+}
+
+// variance sums the squared distances from the mean, which unlike the
+// mean of squares minus the squared mean does not cancel catastrophically
+// when the spread is small next to the values.
+func variance(xs []float64, sample bool) float64 {
+	m := mean(xs)
+	sq := make([]float64, len(xs))
+	for i, x := range xs {
+		sq[i] = (x - m) * (x - m)
+	}
+	n := float64(len(xs))
+	if sample {
+		n--
+	}
+	return sum(sq) / n
+}
+
+func sorted(xs []float64) []float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	return s
+}
+
+// percentile interpolates between the elements of sorted xs around rank
+// p/100 * (n-1).
+func percentile(xs []float64, p float64) float64 {
+	rank := p / 100 * float64(len(xs)-1)
+	i := int(math.Floor(rank))
+	if i >= len(xs)-1 {
+		return xs[len(xs)-1]
+	}
+	frac := rank - float64(i)
+	return xs[i] + frac*(xs[i+1]-xs[i])
+}
+
+// dataRange is the range of a default histogram: from the smallest to the
+// largest element, widened by half around a single value and 0..1 when
+// there are no elements.
+func dataRange(xs []float64) (float64, float64) {
+	if len(xs) == 0 {
+		return 0, 1
+	}
+	lo, hi := xs[0], xs[0]
+	for _, x := range xs[1:] {
+		lo, hi = math.Min(lo, x), math.Max(hi, x)
+	}
+	if lo == hi {
+		return lo - 0.5, hi + 0.5
+	}
+	return lo, hi
+}
+
+func histogram(xs []float64, bins int, lo, hi float64) ([]float64, []int) {
+	edges := make([]float64, bins+1)
+	for i := range edges {
+		edges[i] = lo + (hi-lo)*float64(i)/float64(bins)
+	}
+	edges[bins] = hi
+	counts := make([]int, bins)
+	for _, x := range xs {
+		if !(x >= lo && x <= hi) {
+			continue
+		}
+		i := int((x - lo) / (hi - lo) * float64(bins))
+		// Agree with the edges where rounding puts x in a neighbour
+		if i > 0 && x < edges[i] {
+			i--
+		}
+		if i < bins-1 && x >= edges[i+1] {
+			i++
+		}
+		if i >= bins {
+			i = bins - 1
+		}
+		counts[i]++
+	}
+	return edges, counts
+}
@@ -0,0 +1,260 @@
+// Package strings provides the strings module: searching, case mapping,
+// splitting and character classification.
+package strings
+
+import (
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"strings"
+)
+
+// Register adds the strings natives to r.
+func Register(r native.Registry) {
+	// Strings Module
+	r.DefineModuleNative("strings", "contains", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string"); !ok {
+			return err
+		}
+		return value.NewBool(strings.Contains(args[0].String(), args[1].String()))
+	})
+	r.DefineModuleNative("strings", "starts_with", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string"); !ok {
+			return err
+		}
+		return value.NewBool(strings.HasPrefix(args[0].String(), args[1].String()))
+	})
+	r.DefineModuleNative("strings", "ends_with", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string"); !ok {
+			return err
+		}
+		return value.NewBool(strings.HasSuffix(args[0].String(), args[1].String()))
+	})
+	r.DefineModuleNative("strings", "index_of", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string"); !ok {
+			return err
+		}
+		return value.NewInt(int64(strings.Index(args[0].String(), args[1].String())))
+	})
+	r.DefineModuleNative("strings", "count", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string"); !ok {
+			return err
+		}
+		return value.NewInt(int64(strings.Count(args[0].String(), args[1].String())))
+	})
+	r.DefineModuleNative("strings", "to_upper", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		return value.NewString(strings.ToUpper(args[0].String()))
+	})
+	r.DefineModuleNative("strings", "to_lower", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		return value.NewString(strings.ToLower(args[0].String()))
+	})
+	r.DefineModuleNative("strings", "trim", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		return value.NewString(strings.TrimSpace(args[0].String()))
+	})
+
+	r.DefineModuleNative("strings", "reverse", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		s := args[0].String()
+		runes := []rune(s)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return value.NewString(string(runes))
+	})
+	r.DefineModuleNative("strings", "repeat", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "int"); !ok {
+			return err
+		}
+		if args[1].AsInt < 0 {
+			return value.NewNativeError("negative count %d", args[1].AsInt)
+		}
+		return value.NewString(strings.Repeat(args[0].String(), int(args[1].AsInt)))
+	})
+
+	r.DefineModuleNative("strings", "replace", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string", "string"); !ok {
+			return err
+		}
+		return value.NewString(strings.ReplaceAll(args[0].String(), args[1].String(), args[2].String()))
+	})
+	r.DefineModuleNative("strings", "replace_first", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string", "string"); !ok {
+			return err
+		}
+		return value.NewString(strings.Replace(args[0].String(), args[1].String(), args[2].String(), 1))
+	})
+	r.DefineModuleNative("strings", "pad_left", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "int", "string"); !ok {
+			return err
+		}
+		s := args[0].String()
+		totalLen := int(args[1].AsInt)
+		padChar := args[2].String()
+		if len(s) >= totalLen {
+			return value.NewString(s)
+		}
+		padding := totalLen - len(s)
+		return value.NewString(strings.Repeat(padChar, padding) + s)
+	})
+	r.DefineModuleNative("strings", "split", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "string", "struct"); !ok {
+			return err
+		}
+		s := args[0].String()
+		sep := args[1].String()
+		structDef := args[2].Obj.(*value.ObjStruct)
+
+		parts := strings.Split(s, sep)
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("count", value.NewInt(int64(len(parts))))
+
+		partValues := make([]value.Value, len(parts))
+		for i, p := range parts {
+			partValues[i] = value.NewString(p)
+		}
+		inst.Set("parts", value.NewArray(partValues))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("strings", "join_count", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "array", "string", "int"); !ok {
+			return err
+		}
+		arrVal := args[0]
+		sep := args[1].String()
+		count := int(args[2].AsInt)
+
+		arr := arrVal.Obj.(*value.ObjArray)
+		var parts []string
+		max := arr.Len()
+		if count < max {
+			max = count
+		}
+		for i := 0; i < max; i++ {
+			parts = append(parts, arr.At(i).String())
+		}
+		return value.NewString(strings.Join(parts, sep))
+	})
+	r.DefineModuleNative("strings", "substring", func(args []value.Value) value.Value {
+		// args: string, start, length
+		if err, ok := native.CheckArgs(args, "string", "int", "int"); !ok {
+			return err
+		}
+		s := args[0].String()
+		runes := []rune(s)
+		start := int(args[1].AsInt)
+		length := int(args[2].AsInt)
+
+		if start < 0 {
+			start = 0
+		}
+		if start >= len(runes) {
+			return value.NewString("")
+		}
+
+		if length <= 0 {
+			return value.NewString("")
+		}
+		end := start + length
+		if end > len(runes) {
+			end = len(runes)
+		}
+
+		return value.NewString(string(runes[start:end]))
+	})
+	r.DefineModuleNative("strings", "is_empty", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		return value.NewBool(len(args[0].String()) == 0)
+	})
+	r.DefineModuleNative("strings", "is_digit", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		s := args[0].String()
+		if len(s) == 0 {
+			return value.NewBool(false)
+		}
+		for _, r := range s {
+			if r < '0' || r > '9' {
+				return value.NewBool(false)
+			}
+		}
+		return value.NewBool(true)
+	})
+	r.DefineModuleNative("strings", "is_alpha", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		s := args[0].String()
+		if len(s) == 0 {
+			return value.NewBool(false)
+		}
+		for _, r := range s {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+				return value.NewBool(false)
+			}
+		}
+		return value.NewBool(true)
+	})
+	r.DefineModuleNative("strings", "is_alnum", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		s := args[0].String()
+		if len(s) == 0 {
+			return value.NewBool(false)
+		}
+		for _, r := range s {
+			isDigit := r >= '0' && r <= '9'
+			isAlpha := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+			if !isDigit && !isAlpha {
+				return value.NewBool(false)
+			}
+		}
+		return value.NewBool(true)
+	})
+	r.DefineModuleNative("strings", "is_space", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		s := args[0].String()
+		if len(s) == 0 {
+			return value.NewBool(false)
+		}
+		for _, r := range s {
+			if r != ' ' && r != '\t' && r != '\n' && r != '\r' {
+				return value.NewBool(false)
+			}
+		}
+		return value.NewBool(true)
+	})
+	r.DefineModuleNative("strings", "char_at", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string", "int"); !ok {
+			return err
+		}
+		r, ok := value.RuneAt(args[0].String(), int(args[1].AsInt))
+		if !ok {
+			return value.NewString("")
+		}
+		return value.NewChar(r)
+	})
+	r.DefineModuleNative("strings", "from_char_code", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "int"); !ok {
+			return err
+		}
+		return value.NewChar(rune(args[0].AsInt))
+	})
+}
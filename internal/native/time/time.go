@@ -0,0 +1,389 @@
+// Package time provides the time module: clocks, sleeping, and building,
+// formatting and parsing DateTime instances.
+package time
+
+import (
+	"fmt"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"strings"
+	"time"
+)
+
+// Register adds the time natives to r.
+func Register(r native.Registry) {
+	r.DefineModuleNative("time", "now_ms", func(args []value.Value) value.Value {
+		return value.NewInt(time.Now().UnixMilli())
+	})
+	r.DefineModuleNative("time", "now", func(args []value.Value) value.Value {
+		return value.NewInt(time.Now().Unix())
+	})
+
+	r.DefineModuleNative("time", "sleep", func(args []value.Value) value.Value {
+		if len(args) != 1 {
+			return value.NewNull()
+		}
+		ms := args[0].AsInt
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return value.NewNull()
+	})
+	r.DefineModuleNative("time", "now_datetime", func(args []value.Value) value.Value {
+		// args[0] is DateTime struct def
+		if len(args) < 1 {
+			return value.NewNull()
+		}
+		structDef, ok := args[0].Obj.(*value.ObjStruct)
+		if !ok {
+			return value.NewNull()
+		}
+
+		t := time.Now()
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("year", value.NewInt(int64(t.Year())))
+		inst.Set("month", value.NewInt(int64(t.Month())))
+		inst.Set("day", value.NewInt(int64(t.Day())))
+		inst.Set("hour", value.NewInt(int64(t.Hour())))
+		inst.Set("minute", value.NewInt(int64(t.Minute())))
+		inst.Set("second", value.NewInt(int64(t.Second())))
+		inst.Set("weekday", value.NewInt(int64(t.Weekday())))
+		inst.Set("yearday", value.NewInt(int64(t.YearDay())))
+		inst.Set("timestamp", value.NewInt(t.Unix()))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("time", "format", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		inst, ok := args[0].Obj.(*value.ObjInstance)
+		if !ok {
+			return value.NewString("")
+		}
+
+		// Reconstruct time.Time from fields
+		// Minimal fields: year, month, day, hour, minute, second
+		y := int(inst.Field("year").AsInt)
+		m := time.Month(inst.Field("month").AsInt)
+		d := int(inst.Field("day").AsInt)
+		h := int(inst.Field("hour").AsInt)
+		min := int(inst.Field("minute").AsInt)
+		s := int(inst.Field("second").AsInt)
+
+		t := time.Date(y, m, d, h, min, s, 0, time.Local)
+		return value.NewString(t.Format("2006-01-02 15:04:05"))
+	})
+	r.DefineModuleNative("time", "format_date", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		inst, ok := args[0].Obj.(*value.ObjInstance)
+		if !ok {
+			return value.NewString("")
+		}
+		y := int(inst.Field("year").AsInt)
+		m := time.Month(inst.Field("month").AsInt)
+		d := int(inst.Field("day").AsInt)
+		t := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+		return value.NewString(t.Format("2006-01-02"))
+	})
+	r.DefineModuleNative("time", "format_time", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		inst, ok := args[0].Obj.(*value.ObjInstance)
+		if !ok {
+			return value.NewString("")
+		}
+		h := int(inst.Field("hour").AsInt)
+		min := int(inst.Field("minute").AsInt)
+		s := int(inst.Field("second").AsInt)
+		t := time.Date(0, 1, 1, h, min, s, 0, time.Local)
+		return value.NewString(t.Format("15:04:05"))
+	})
+	r.DefineModuleNative("time", "make_datetime", func(args []value.Value) value.Value {
+		// args: structDef, y, m, d, h, min, s
+		if len(args) < 7 {
+			return value.NewNull()
+		}
+		structDef, ok := args[0].Obj.(*value.ObjStruct)
+		if !ok {
+			return value.NewNull()
+		}
+
+		y := int(args[1].AsInt)
+		m := time.Month(args[2].AsInt)
+		d := int(args[3].AsInt)
+		h := int(args[4].AsInt)
+		min := int(args[5].AsInt)
+		s := int(args[6].AsInt)
+
+		t := time.Date(y, m, d, h, min, s, 0, time.Local)
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("year", value.NewInt(int64(t.Year())))
+		inst.Set("month", value.NewInt(int64(t.Month())))
+		inst.Set("day", value.NewInt(int64(t.Day())))
+		inst.Set("hour", value.NewInt(int64(t.Hour())))
+		inst.Set("minute", value.NewInt(int64(t.Minute())))
+		inst.Set("second", value.NewInt(int64(t.Second())))
+		inst.Set("weekday", value.NewInt(int64(t.Weekday())))
+		inst.Set("yearday", value.NewInt(int64(t.YearDay())))
+		inst.Set("timestamp", value.NewInt(t.Unix()))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("time", "to_timestamp", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewInt(0)
+		}
+		inst, ok := args[0].Obj.(*value.ObjInstance)
+		if !ok {
+			return value.NewInt(0)
+		}
+
+		val, ok := inst.Get("timestamp")
+		if ok {
+			return val
+		}
+		return value.NewInt(0)
+	})
+	r.DefineModuleNative("time", "from_timestamp", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNull()
+		}
+		ts := args[0].AsInt
+		structDef, ok := args[1].Obj.(*value.ObjStruct)
+		if !ok {
+			return value.NewNull()
+		}
+
+		t := time.Unix(ts, 0)
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("year", value.NewInt(int64(t.Year())))
+		inst.Set("month", value.NewInt(int64(t.Month())))
+		inst.Set("day", value.NewInt(int64(t.Day())))
+		inst.Set("hour", value.NewInt(int64(t.Hour())))
+		inst.Set("minute", value.NewInt(int64(t.Minute())))
+		inst.Set("second", value.NewInt(int64(t.Second())))
+		inst.Set("weekday", value.NewInt(int64(t.Weekday())))
+		inst.Set("yearday", value.NewInt(int64(t.YearDay())))
+		inst.Set("timestamp", value.NewInt(t.Unix()))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("time", "diff", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewInt(0)
+		}
+		ts1 := args[0].AsInt
+		ts2 := args[1].AsInt
+		return value.NewInt(ts1 - ts2)
+	})
+	r.DefineModuleNative("time", "add_days", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewInt(0)
+		}
+		ts := args[0].AsInt
+		days := args[1].AsInt
+		return value.NewInt(ts + (days * 86400))
+	})
+	r.DefineModuleNative("time", "before", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewBool(false)
+		}
+		return value.NewBool(args[0].AsInt < args[1].AsInt)
+	})
+	r.DefineModuleNative("time", "after", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewBool(false)
+		}
+		return value.NewBool(args[0].AsInt > args[1].AsInt)
+	})
+	r.DefineModuleNative("time", "is_leap_year", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewBool(false)
+		}
+		year := args[0].AsInt
+		return value.NewBool(year%4 == 0 && (year%100 != 0 || year%400 == 0))
+	})
+	r.DefineModuleNative("time", "days_in_month", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewInt(0)
+		}
+		year := int(args[0].AsInt)
+		month := time.Month(args[1].AsInt)
+		// Trick: go to next month day 0
+		t := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		return value.NewInt(int64(t.Day()))
+	})
+	r.DefineModuleNative("time", "weekday_name", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		wd := time.Weekday(args[0].AsInt)
+
+		names := []string{
+			"Domingo", "Segunda-feira", "Terça-feira", "Quarta-feira",
+			"Quinta-feira", "Sexta-feira", "Sábado",
+		}
+		if int(wd) >= 0 && int(wd) < len(names) {
+			return value.NewString(names[wd])
+		}
+		return value.NewString(wd.String())
+	})
+	r.DefineModuleNative("time", "month_name", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		m := time.Month(args[0].AsInt)
+		names := map[time.Month]string{
+			time.January: "Janeiro", time.February: "Fevereiro", time.March: "Março",
+			time.April: "Abril", time.May: "Maio", time.June: "Junho",
+			time.July: "Julho", time.August: "Agosto", time.September: "Setembro",
+			time.October: "Outubro", time.November: "Novembro", time.December: "Dezembro",
+		}
+		if name, ok := names[m]; ok {
+			return value.NewString(name)
+		}
+		return value.NewString(m.String())
+	})
+
+	r.DefineModuleNative("time", "format_custom", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewString("")
+		}
+		inst, ok := args[0].Obj.(*value.ObjInstance)
+		if !ok {
+			return value.NewString("")
+		}
+		fmtStr := args[1].String()
+
+		y := int(inst.Field("year").AsInt)
+		m := time.Month(inst.Field("month").AsInt)
+		d := int(inst.Field("day").AsInt)
+		h := int(inst.Field("hour").AsInt)
+		min := int(inst.Field("minute").AsInt)
+		s := int(inst.Field("second").AsInt)
+		// t := time.Date(y, m, d, h, min, s, 0, time.Local) // Unused in this simple implementation
+
+		// Simplified replacement for strftime
+		// Noxy: %Y=ano, %m=mês, %d=dia, %H=hora, %M=min, %S=seg
+		res := fmtStr
+		res = strings.ReplaceAll(res, "%Y", fmt.Sprintf("%04d", y))
+		res = strings.ReplaceAll(res, "%m", fmt.Sprintf("%02d", m))
+		res = strings.ReplaceAll(res, "%d", fmt.Sprintf("%02d", d))
+		res = strings.ReplaceAll(res, "%H", fmt.Sprintf("%02d", h))
+		res = strings.ReplaceAll(res, "%M", fmt.Sprintf("%02d", min))
+		res = strings.ReplaceAll(res, "%S", fmt.Sprintf("%02d", s))
+
+		return value.NewString(res)
+	})
+	r.DefineModuleNative("time", "parse", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNull()
+		}
+		str, ok := args[0].Obj.(string)
+		if !ok {
+			return value.NewNull()
+		}
+		structDef, ok := args[1].Obj.(*value.ObjStruct)
+		if !ok {
+			return value.NewNull()
+		}
+
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", str, time.Local)
+		if err != nil {
+			return value.NewNull()
+		}
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("year", value.NewInt(int64(t.Year())))
+		inst.Set("month", value.NewInt(int64(t.Month())))
+		inst.Set("day", value.NewInt(int64(t.Day())))
+		inst.Set("hour", value.NewInt(int64(t.Hour())))
+		inst.Set("minute", value.NewInt(int64(t.Minute())))
+		inst.Set("second", value.NewInt(int64(t.Second())))
+		inst.Set("weekday", value.NewInt(int64(t.Weekday())))
+		inst.Set("yearday", value.NewInt(int64(t.YearDay())))
+		inst.Set("timestamp", value.NewInt(t.Unix()))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("time", "parse_date", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNull()
+		}
+		str, ok := args[0].Obj.(string)
+		if !ok {
+			return value.NewNull()
+		}
+		structDef, ok := args[1].Obj.(*value.ObjStruct)
+		if !ok {
+			return value.NewNull()
+		}
+
+		t, err := time.ParseInLocation("2006-01-02", str, time.Local)
+		if err != nil {
+			return value.NewNull()
+		}
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("year", value.NewInt(int64(t.Year())))
+		inst.Set("month", value.NewInt(int64(t.Month())))
+		inst.Set("day", value.NewInt(int64(t.Day())))
+		inst.Set("hour", value.NewInt(int64(t.Hour())))
+		inst.Set("minute", value.NewInt(int64(t.Minute())))
+		inst.Set("second", value.NewInt(int64(t.Second())))
+		inst.Set("weekday", value.NewInt(int64(t.Weekday())))
+		inst.Set("yearday", value.NewInt(int64(t.YearDay())))
+		inst.Set("timestamp", value.NewInt(t.Unix()))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+	r.DefineModuleNative("time", "add_seconds", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewInt(0)
+		}
+		ts := args[0].AsInt
+		secs := args[1].AsInt
+		return value.NewInt(ts + secs)
+	})
+	r.DefineModuleNative("time", "diff_duration", func(args []value.Value) value.Value {
+		if len(args) < 3 {
+			return value.NewNull()
+		}
+		ts1 := args[0].AsInt
+		ts2 := args[1].AsInt
+		structDef, ok := args[2].Obj.(*value.ObjStruct)
+		if !ok {
+			return value.NewNull()
+		}
+
+		diff := ts1 - ts2
+		if diff < 0 {
+			diff = -diff
+		}
+
+		totalSecs := ts1 - ts2
+		absSecs := totalSecs
+		if absSecs < 0 {
+			absSecs = -absSecs
+		}
+
+		days := absSecs / 86400
+		rem := absSecs % 86400
+		hours := rem / 3600
+		rem = rem % 3600
+		mins := rem / 60
+		secs := rem % 60
+
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("days", value.NewInt(days))
+		inst.Set("hours", value.NewInt(hours))
+		inst.Set("minutes", value.NewInt(mins))
+		inst.Set("seconds", value.NewInt(secs))
+		inst.Set("total_seconds", value.NewInt(totalSecs))
+
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
+}
@@ -0,0 +1,146 @@
+// Package url provides the url module: splitting URLs into their parts,
+// percent-encoding, and reading and building query strings.
+package url
+
+import (
+	neturl "net/url"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"strconv"
+	"strings"
+)
+
+// defaultPorts is what url_parse reports when a URL names no port.
+var defaultPorts = map[string]int64{"http": 80, "https": 443, "ws": 80, "wss": 443, "ftp": 21}
+
+// Register adds the url natives to r.
+func Register(r native.Registry) {
+	// url_parse(s) -> map or null
+	// Keys: scheme, user, host, port, path (decoded), raw_path, query
+	// (raw), params (decoded query map) and fragment. port falls back to the scheme's default,
+	// or 0 when it has none. Invalid URLs give null.
+	r.DefineModuleNative("url", "parse", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewNativeError("expected a URL")
+		}
+		u, err := neturl.Parse(args[0].String())
+		if err != nil {
+			return value.NewNull()
+		}
+		port := defaultPorts[strings.ToLower(u.Scheme)]
+		if p := u.Port(); p != "" {
+			n, err := strconv.ParseInt(p, 10, 64)
+			if err != nil {
+				return value.NewNull()
+			}
+			port = n
+		}
+		m := value.NewMap()
+		parts := m.Obj.(*value.ObjMap)
+		parts.Set("scheme", value.NewString(u.Scheme))
+		parts.Set("user", value.NewString(u.User.Username()))
+		parts.Set("host", value.NewString(u.Hostname()))
+		parts.Set("port", value.NewInt(port))
+		parts.Set("path", value.NewString(u.Path))
+		parts.Set("raw_path", value.NewString(u.EscapedPath()))
+		parts.Set("query", value.NewString(u.RawQuery))
+		parts.Set("params", parseQuery(u.RawQuery))
+		parts.Set("fragment", value.NewString(u.Fragment))
+		return m
+	})
+
+	// url_encode / url_decode escape for query strings ("a b" <-> "a+b").
+	// Decoding returns the input unchanged when it is not valid.
+	r.DefineModuleNative("url", "encode", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		return value.NewString(neturl.QueryEscape(args[0].String()))
+	})
+	r.DefineModuleNative("url", "decode", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		decoded, err := neturl.QueryUnescape(args[0].String())
+		if err != nil {
+			return args[0]
+		}
+		return value.NewString(decoded)
+	})
+
+	// url_path_encode / url_path_decode escape one path segment
+	// ("a b" <-> "a%20b"; "+" is left alone).
+	r.DefineModuleNative("url", "path_encode", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		return value.NewString(neturl.PathEscape(args[0].String()))
+	})
+	r.DefineModuleNative("url", "path_decode", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		decoded, err := neturl.PathUnescape(args[0].String())
+		if err != nil {
+			return args[0]
+		}
+		return value.NewString(decoded)
+	})
+
+	// url_query_parse(q) -> map[string, string]
+	// The first value of a repeated key wins.
+	r.DefineModuleNative("url", "query_parse", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewMap()
+		}
+		return parseQuery(strings.TrimPrefix(args[0].String(), "?"))
+	})
+
+	// url_query_build(map) -> string
+	// Keys keep the map's order; array values repeat their key.
+	r.DefineModuleNative("url", "query_build", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewString("")
+		}
+		params, ok := args[0].Obj.(*value.ObjMap)
+		if !ok {
+			return value.NewNativeError("expected a map, got %s", value.TypeName(args[0]))
+		}
+		var pairs []string
+		for _, k := range params.Keys {
+			v, _ := params.Get(k)
+			key := neturl.QueryEscape(value.KeyValue(k).String())
+			if arr, ok := v.Obj.(*value.ObjArray); ok {
+				for _, el := range arr.Values() {
+					pairs = append(pairs, key+"="+neturl.QueryEscape(el.String()))
+				}
+				continue
+			}
+			pairs = append(pairs, key+"="+neturl.QueryEscape(v.String()))
+		}
+		return value.NewString(strings.Join(pairs, "&"))
+	})
+}
+
+// parseQuery decodes a raw query string into an ordered map. Pairs that
+// fail to decode are kept as written.
+func parseQuery(raw string) value.Value {
+	m := value.NewMap()
+	params := m.Obj.(*value.ObjMap)
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, "=")
+		if dk, err := neturl.QueryUnescape(k); err == nil {
+			k = dk
+		}
+		if dv, err := neturl.QueryUnescape(v); err == nil {
+			v = dv
+		}
+		if _, seen := params.Get(k); !seen {
+			params.Set(k, value.NewString(v))
+		}
+	}
+	return m
+}
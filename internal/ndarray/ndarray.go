@@ -0,0 +1,300 @@
+// Package ndarray implements dense n-dimensional arrays of float64 stored
+// in row-major order: the element-wise arithmetic, dot products,
+// transposes and slices behind the ndarray module.
+package ndarray
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxSize bounds the number of elements of an array (256 MiB of data).
+	MaxSize = 1 << 25
+	// MaxDims bounds the number of dimensions.
+	MaxDims = 32
+)
+
+// Array is an n-dimensional array. Data holds the elements row by row, so
+// the last index varies fastest; len(Data) is the product of Shape.
+type Array struct {
+	Shape []int
+	Data  []float64
+}
+
+// New makes an array of zeros with the given shape.
+func New(shape []int) (*Array, error) {
+	size, err := checkShape(shape)
+	if err != nil {
+		return nil, err
+	}
+	return &Array{Shape: append([]int(nil), shape...), Data: make([]float64, size)}, nil
+}
+
+func checkShape(shape []int) (int, error) {
+	if len(shape) == 0 || len(shape) > MaxDims {
+		return 0, fmt.Errorf("an ndarray has from 1 to %d dimensions, got %d", MaxDims, len(shape))
+	}
+	size := 1
+	for _, n := range shape {
+		if n < 0 {
+			return 0, fmt.Errorf("dimensions must not be negative, got shape %s", FormatShape(shape))
+		}
+		if n > 0 && size > MaxSize/n {
+			return 0, fmt.Errorf("shape %s has more than %d elements", FormatShape(shape), MaxSize)
+		}
+		size *= n
+	}
+	return size, nil
+}
+
+// FormatShape writes a shape as "[2, 3]".
+func FormatShape(shape []int) string {
+	parts := make([]string, len(shape))
+	for i, n := range shape {
+		parts[i] = fmt.Sprint(n)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// Copy returns an array with its own copy of the data.
+func (a *Array) Copy() *Array {
+	return &Array{Shape: append([]int(nil), a.Shape...), Data: append([]float64(nil), a.Data...)}
+}
+
+// Offset finds the element at index, which has one entry per dimension,
+// in Data.
+func (a *Array) Offset(index []int) (int, error) {
+	if len(index) != len(a.Shape) {
+		return 0, fmt.Errorf("an array of shape %s takes %d indexes, got %d", FormatShape(a.Shape), len(a.Shape), len(index))
+	}
+	off := 0
+	for axis, i := range index {
+		if i < 0 || i >= a.Shape[axis] {
+			return 0, fmt.Errorf("index %d is out of range for axis %d of length %d", i, axis, a.Shape[axis])
+		}
+		off = off*a.Shape[axis] + i
+	}
+	return off, nil
+}
+
+// Reshape returns a copy of a with a new shape of the same size.
+func (a *Array) Reshape(shape []int) (*Array, error) {
+	size, err := checkShape(shape)
+	if err != nil {
+		return nil, err
+	}
+	if size != len(a.Data) {
+		return nil, fmt.Errorf("cannot reshape %d elements of shape %s to %s", len(a.Data), FormatShape(a.Shape), FormatShape(shape))
+	}
+	return &Array{Shape: append([]int(nil), shape...), Data: append([]float64(nil), a.Data...)}, nil
+}
+
+func apply(op byte, x, y float64) float64 {
+	switch op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	default:
+		return x / y
+	}
+}
+
+// Apply combines two arrays of the same shape element by element with op,
+// one of + - * /. Division follows IEEE rules: 1/0 is +Inf.
+func Apply(op byte, a, b *Array) (*Array, error) {
+	if !sameShape(a.Shape, b.Shape) {
+		return nil, fmt.Errorf("shapes %s and %s do not match", FormatShape(a.Shape), FormatShape(b.Shape))
+	}
+	r := &Array{Shape: append([]int(nil), a.Shape...), Data: make([]float64, len(a.Data))}
+	for i, x := range a.Data {
+		r.Data[i] = apply(op, x, b.Data[i])
+	}
+	return r, nil
+}
+
+// ApplyScalar combines every element of a with s: a op s, or s op a when
+// scalarFirst is set.
+func ApplyScalar(op byte, a *Array, s float64, scalarFirst bool) *Array {
+	r := &Array{Shape: append([]int(nil), a.Shape...), Data: make([]float64, len(a.Data))}
+	for i, x := range a.Data {
+		if scalarFirst {
+			r.Data[i] = apply(op, s, x)
+		} else {
+			r.Data[i] = apply(op, x, s)
+		}
+	}
+	return r
+}
+
+func sameShape(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Inner is the dot product of two vectors of the same length.
+func Inner(a, b *Array) (float64, error) {
+	if len(a.Shape) != 1 || len(b.Shape) != 1 || a.Shape[0] != b.Shape[0] {
+		return 0, fmt.Errorf("the inner product needs two vectors of the same length, got shapes %s and %s", FormatShape(a.Shape), FormatShape(b.Shape))
+	}
+	var s float64
+	for i, x := range a.Data {
+		s += x * b.Data[i]
+	}
+	return s, nil
+}
+
+// Dot is the matrix product of a and b, where either may be a vector: a
+// vector on the left is a row, one on the right a column, and the result
+// then has one dimension fewer. Two vectors give a vector of one element,
+// their Inner product.
+func Dot(a, b *Array) (*Array, error) {
+	if len(a.Shape) > 2 || len(b.Shape) > 2 {
+		return nil, fmt.Errorf("dot takes vectors and matrices, got shapes %s and %s", FormatShape(a.Shape), FormatShape(b.Shape))
+	}
+	rows, inner := 1, a.Shape[0]
+	if len(a.Shape) == 2 {
+		rows, inner = a.Shape[0], a.Shape[1]
+	}
+	cols := 1
+	if len(b.Shape) == 2 {
+		cols = b.Shape[1]
+	}
+	if b.Shape[0] != inner {
+		return nil, fmt.Errorf("shapes %s and %s are not aligned: %d != %d", FormatShape(a.Shape), FormatShape(b.Shape), inner, b.Shape[0])
+	}
+	var shape []int
+	if len(a.Shape) == 2 {
+		shape = append(shape, rows)
+	}
+	if len(b.Shape) == 2 {
+		shape = append(shape, cols)
+	}
+	if len(shape) == 0 {
+		shape = []int{1}
+	}
+	r := &Array{Shape: shape, Data: make([]float64, rows*cols)}
+	// i-k-j order walks both a row of b and a row of the result in order
+	for i := 0; i < rows; i++ {
+		out := r.Data[i*cols : (i+1)*cols]
+		for k := 0; k < inner; k++ {
+			x := a.Data[i*inner+k]
+			row := b.Data[k*cols : (k+1)*cols]
+			for j, y := range row {
+				out[j] += x * y
+			}
+		}
+	}
+	return r, nil
+}
+
+// Transpose reverses the axes of a: element [i, j, k] moves to [k, j, i].
+// A vector is returned as a copy.
+func Transpose(a *Array) *Array {
+	n := len(a.Shape)
+	shape := make([]int, n)
+	for i, d := range a.Shape {
+		shape[n-1-i] = d
+	}
+	// strides[i] is the step in a.Data for a step along axis i of the result
+	strides := make([]int, n)
+	step := 1
+	for i := n - 1; i >= 0; i-- {
+		strides[n-1-i] = step
+		step *= a.Shape[i]
+	}
+	r := &Array{Shape: shape, Data: make([]float64, len(a.Data))}
+	index := make([]int, n)
+	src := 0
+	for i := range r.Data {
+		r.Data[i] = a.Data[src]
+		// Advance index like an odometer, keeping src in step
+		for axis := n - 1; axis >= 0; axis-- {
+			index[axis]++
+			src += strides[axis]
+			if index[axis] < shape[axis] {
+				break
+			}
+			src -= strides[axis] * shape[axis]
+			index[axis] = 0
+		}
+	}
+	return r
+}
+
+// Slice copies the part of a from start up to but not including end along
+// axis.
+func Slice(a *Array, axis, start, end int) (*Array, error) {
+	if axis < 0 || axis >= len(a.Shape) {
+		return nil, fmt.Errorf("axis %d is out of range for an array of %d dimensions", axis, len(a.Shape))
+	}
+	if start < 0 || end > a.Shape[axis] || start > end {
+		return nil, fmt.Errorf("slice %d:%d is out of range for axis %d of length %d", start, end, axis, a.Shape[axis])
+	}
+	// Each of outer blocks holds a.Shape[axis] runs of inner elements
+	outer, inner := 1, 1
+	for _, d := range a.Shape[:axis] {
+		outer *= d
+	}
+	for _, d := range a.Shape[axis+1:] {
+		inner *= d
+	}
+	shape := append([]int(nil), a.Shape...)
+	shape[axis] = end - start
+	r := &Array{Shape: shape, Data: make([]float64, 0, outer*(end-start)*inner)}
+	for o := 0; o < outer; o++ {
+		base := o * a.Shape[axis] * inner
+		r.Data = append(r.Data, a.Data[base+start*inner:base+end*inner]...)
+	}
+	return r, nil
+}
+
+// summarizeOver is the size above which Format shows only the first and
+// last summaryItems entries of each axis.
+const (
+	summarizeOver = 1000
+	summaryItems  = 3
+)
+
+// Format writes a as nested lists, [[1, 2], [3, 4]], formatting each
+// element with num. Large arrays are summarized with "...".
+func (a *Array) Format(num func(float64) string) string {
+	var sb strings.Builder
+	a.format(&sb, num, 0, 0, len(a.Data) > summarizeOver)
+	return sb.String()
+}
+
+func (a *Array) format(sb *strings.Builder, num func(float64) string, axis, off int, summarize bool) {
+	stride := 1
+	for _, d := range a.Shape[axis+1:] {
+		stride *= d
+	}
+	n := a.Shape[axis]
+	sb.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if summarize && n > 2*summaryItems && i == summaryItems {
+			sb.WriteString("...")
+			i = n - summaryItems - 1
+			continue
+		}
+		if axis == len(a.Shape)-1 {
+			sb.WriteString(num(a.Data[off+i]))
+		} else {
+			a.format(sb, num, axis+1, off+i*stride, summarize)
+		}
+	}
+	sb.WriteByte(']')
+}
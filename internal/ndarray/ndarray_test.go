@@ -0,0 +1,125 @@
+package ndarray
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func num(f float64) string { return fmt.Sprint(f) }
+
+func arange(shape ...int) *Array {
+	a, err := New(shape)
+	if err != nil {
+		panic(err)
+	}
+	for i := range a.Data {
+		a.Data[i] = float64(i)
+	}
+	return a
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		a    *Array
+		want string
+	}{
+		{arange(3), "[0, 1, 2]"},
+		{arange(2, 3), "[[0, 1, 2], [3, 4, 5]]"},
+		{arange(2, 1, 2), "[[[0, 1]], [[2, 3]]]"},
+		{arange(0), "[]"},
+		{arange(2, 0), "[[], []]"},
+		{arange(1001), "[0, 1, 2, ..., 998, 999, 1000]"},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Format(num); got != tt.want {
+			t.Errorf("Format(%v) = %s, want %s", tt.a.Shape, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, shape := range [][]int{{}, {-1}, {MaxSize, 2}, make([]int, MaxDims+1)} {
+		if _, err := New(shape); err == nil {
+			t.Errorf("New(%v) succeeded", shape)
+		}
+	}
+	a := arange(2, 3, 4)
+	off, err := a.Offset([]int{1, 2, 3})
+	if err != nil || a.Data[off] != 23 {
+		t.Errorf("Offset([1, 2, 3]) = %d, %v", off, err)
+	}
+	if _, err := a.Offset([]int{0, 3, 0}); err == nil || !strings.Contains(err.Error(), "axis 1 of length 3") {
+		t.Errorf("Offset([0, 3, 0]) error = %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	a, b := arange(2, 2), arange(2, 2)
+	r, err := Apply('*', a, b)
+	if err != nil || r.Format(num) != "[[0, 1], [4, 9]]" {
+		t.Errorf("a * b = %v, %v", r, err)
+	}
+	if r := ApplyScalar('-', a, 1, true); r.Format(num) != "[[1, 0], [-1, -2]]" {
+		t.Errorf("1 - a = %s", r.Format(num))
+	}
+	if r := ApplyScalar('/', a, 0, false); r.Format(num) != "[[NaN, +Inf], [+Inf, +Inf]]" {
+		t.Errorf("a / 0 = %s", r.Format(num))
+	}
+	if _, err := Apply('+', a, arange(4)); err == nil || err.Error() != "shapes [2, 2] and [4] do not match" {
+		t.Errorf("mismatched shapes: %v", err)
+	}
+}
+
+func TestDot(t *testing.T) {
+	tests := []struct {
+		a, b *Array
+		want string
+	}{
+		{arange(2, 3), arange(3, 2), "[[10, 13], [28, 40]]"},
+		{arange(2, 3), arange(3), "[5, 14]"},
+		{arange(3), arange(3, 2), "[10, 13]"},
+		{arange(3), arange(3), "[5]"},
+	}
+	for _, tt := range tests {
+		r, err := Dot(tt.a, tt.b)
+		if err != nil || r.Format(num) != tt.want {
+			t.Errorf("Dot(%v, %v) = %v, %v, want %s", tt.a.Shape, tt.b.Shape, r, err, tt.want)
+		}
+	}
+	if _, err := Dot(arange(2, 3), arange(2, 3)); err == nil || err.Error() != "shapes [2, 3] and [2, 3] are not aligned: 3 != 2" {
+		t.Errorf("unaligned: %v", err)
+	}
+	if s, err := Inner(arange(3), arange(3)); err != nil || s != 5 {
+		t.Errorf("Inner = %v, %v", s, err)
+	}
+}
+
+func TestTransposeAndSlice(t *testing.T) {
+	if r := Transpose(arange(2, 3)); r.Format(num) != "[[0, 3], [1, 4], [2, 5]]" {
+		t.Errorf("Transpose([2, 3]) = %s", r.Format(num))
+	}
+	a := arange(2, 3, 4)
+	r := Transpose(a)
+	for _, idx := range [][]int{{0, 0, 0}, {1, 2, 3}, {0, 1, 2}, {1, 0, 3}} {
+		src, _ := a.Offset(idx)
+		dst, _ := r.Offset([]int{idx[2], idx[1], idx[0]})
+		if a.Data[src] != r.Data[dst] {
+			t.Errorf("Transpose moved %v", idx)
+		}
+	}
+	s, err := Slice(arange(3, 3), 1, 1, 3)
+	if err != nil || s.Format(num) != "[[1, 2], [4, 5], [7, 8]]" {
+		t.Errorf("Slice(axis 1, 1:3) = %v, %v", s, err)
+	}
+	s, err = Slice(arange(3, 2), 0, 2, 3)
+	if err != nil || s.Format(num) != "[[4, 5]]" {
+		t.Errorf("Slice(axis 0, 2:3) = %v, %v", s, err)
+	}
+	if _, err := Slice(arange(3), 0, 2, 4); err == nil {
+		t.Error("Slice past the end succeeded")
+	}
+	if _, err := arange(6).Reshape([]int{4}); err == nil {
+		t.Error("Reshape to a different size succeeded")
+	}
+}
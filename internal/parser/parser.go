@@ -6,6 +6,7 @@ import (
 	"noxy-vm/internal/lexer"
 	"noxy-vm/internal/token"
 	"strconv"
+	"unicode"
 )
 
 type Parser struct {
@@ -17,6 +18,10 @@ type Parser struct {
 	prefixParseFns map[token.TokenType]func() ast.Expression
 	infixParseFns  map[token.TokenType]func(ast.Expression) ast.Expression
 
+	// grouped is the last expression parsed inside parentheses, which a
+	// comparison after it does not chain with
+	grouped ast.Expression
+
 	errors []string
 }
 
@@ -61,12 +66,13 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NEQ, p.parseInfixExpression)
-	p.registerInfix(token.LT, p.parseInfixExpression)
-	p.registerInfix(token.GT, p.parseInfixExpression)
-	p.registerInfix(token.LTE, p.parseInfixExpression)
-	p.registerInfix(token.GTE, p.parseInfixExpression)
+	p.registerInfix(token.LT, p.parseComparison)
+	p.registerInfix(token.GT, p.parseComparison)
+	p.registerInfix(token.LTE, p.parseComparison)
+	p.registerInfix(token.GTE, p.parseComparison)
 	p.registerInfix(token.AND, p.parseInfixExpression)
 	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.COALESCE, p.parseInfixExpression)
 	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
 	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
 	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
@@ -127,6 +133,10 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseIfStatement()
 	case token.WHILE:
 		return p.parseWhileStatement()
+	case token.TRY:
+		return p.parseTryStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	case token.FOR:
 		return p.parseForStatement()
 	case token.STRUCT:
@@ -137,6 +147,8 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseBreakStatement()
 	case token.USE:
 		return p.parseUseStatement()
+	case token.EMBED:
+		return p.parseEmbedStatement()
 	case token.WHEN:
 		return p.parseWhenStatement()
 	case token.NEWLINE:
@@ -251,6 +263,11 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 		stmt.Alternative = p.parseBlockStatement()
 
 		// Verify valid block termination for Alternative
+		if p.curTokenIs(token.ELIF) {
+			p.errors = append(p.errors, fmt.Sprintf("[%d:%d] SyntaxError: 'elif' cannot follow 'else'",
+				p.curToken.Line, p.curToken.Column))
+			return nil
+		}
 		if !p.curTokenIs(token.END) {
 			got := p.curToken.Literal
 			if p.curTokenIs(token.EOF) {
@@ -335,12 +352,24 @@ func (p *Parser) parseBreakStatement() *ast.BreakStmt {
 	return stmt
 }
 
+func (p *Parser) parseEmbedStatement() *ast.EmbedStmt {
+	stmt := &ast.EmbedStmt{Token: p.curToken}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Pattern = p.curToken.Literal
+	return stmt
+}
+
 func (p *Parser) parseUseStatement() *ast.UseStmt {
 	stmt := &ast.UseStmt{Token: p.curToken}
 
 	if !p.expectPeek(token.IDENTIFIER) {
 		return nil
 	}
+	if p.curToken.Literal == "plugin" && p.peekTokenIs(token.STRING) {
+		return p.parseUsePlugin(stmt)
+	}
 	// Parse dot-separated module path: pkg.sub.mod
 	stmt.Module = p.curToken.Literal
 
@@ -391,6 +420,52 @@ func (p *Parser) parseUseStatement() *ast.UseStmt {
 	return stmt
 }
 
+// parseUsePlugin parses the rest of use plugin "name" [as alias], with
+// curToken on 'plugin'. The plugin is bound to its name unless that is not
+// an identifier, such as a URL, which then needs an alias.
+func (p *Parser) parseUsePlugin(stmt *ast.UseStmt) *ast.UseStmt {
+	p.nextToken() // eat plugin
+	stmt.Plugin = true
+	stmt.Module = p.curToken.Literal
+	nameTok := p.curToken
+
+	if p.peekTokenIs(token.AS) {
+		p.nextToken() // eat as
+		if !p.expectPeek(token.IDENTIFIER) {
+			return nil
+		}
+		stmt.Alias = p.curToken.Literal
+	} else if !isIdentifier(stmt.Module) {
+		p.errors = append(p.errors, fmt.Sprintf("[%d:%d] SyntaxError: plugin \"%s\" needs a name to be used by\n  hint: use 'use plugin \"%s\" as <name>'",
+			nameTok.Line, nameTok.Column, stmt.Module, stmt.Module))
+		return nil
+	}
+
+	if p.peekTokenIs(token.SELECT) {
+		p.errors = append(p.errors, fmt.Sprintf("[%d:%d] SyntaxError: cannot select from a plugin\n  hint: call its methods as %s.method(...)",
+			p.peekToken.Line, p.peekToken.Column, stmt.BindName()))
+		for !p.peekTokenIs(token.NEWLINE) && !p.peekTokenIs(token.EOF) {
+			p.nextToken()
+		}
+		return nil
+	}
+
+	if p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// isIdentifier reports whether s could be written as a Noxy identifier.
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != "" && token.LookupIdent(s) == token.IDENTIFIER
+}
+
 func (p *Parser) parseWhenStatement() *ast.WhenStatement {
 	stmt := &ast.WhenStatement{Token: p.curToken, Cases: []*ast.CaseClause{}}
 	p.nextToken() // eat 'when'
@@ -613,7 +688,12 @@ func (p *Parser) parseAtomicType() ast.NoxyType {
 		t = &ast.PrimitiveType{Name: "bytes"}
 	case token.TYPE_ANY:
 		t = &ast.PrimitiveType{Name: "any"}
+	case token.TYPE_VOID:
+		t = &ast.PrimitiveType{Name: "void"}
 	case token.FUNC:
+		if p.peekTokenIs(token.LPAREN) {
+			return p.parseFunctionType()
+		}
 		t = &ast.PrimitiveType{Name: "func"}
 	case token.BYTES: // This is Literal 'b"..."'.
 		t = &ast.PrimitiveType{Name: "bytes"}
@@ -657,10 +737,47 @@ func (p *Parser) parseAtomicType() ast.NoxyType {
 	return t
 }
 
+// parseFunctionType parses a function signature type, such as
+// func(int, string) -> bool, with curToken on 'func'. Like a function
+// declared without '->', a signature without one may return anything.
+func (p *Parser) parseFunctionType() ast.NoxyType {
+	p.nextToken() // eat func
+	ft := &ast.FunctionType{Params: []ast.NoxyType{}, Return: &ast.PrimitiveType{Name: "any"}}
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+	} else {
+		for {
+			p.nextToken()
+			param := p.parseType()
+			if param == nil {
+				return nil
+			}
+			ft.Params = append(ft.Params, param)
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken() // eat ,
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken() // eat )
+		p.nextToken() // eat ->
+		ft.Return = p.parseType()
+		if ft.Return == nil {
+			return nil
+		}
+	}
+	return ft
+}
+
 // Precedence system setup
 const (
 	_ int = iota
 	LOWEST
+	COALESCE    // ??
 	OR          // ||
 	AND         // &&
 	BIT_OR      // |
@@ -685,6 +802,7 @@ var precedences = map[token.TokenType]int{
 	token.GTE:         LESSGREATER,
 	token.AND:         AND,
 	token.OR:          OR,
+	token.COALESCE:    COALESCE,
 	token.BIT_AND:     BIT_AND,
 	token.BIT_OR:      BIT_OR,
 	token.BIT_XOR:     BIT_XOR,
@@ -780,79 +898,48 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseFString() ast.Expression {
-	// Simple F-String parser
-	// Breaks literal into parts and concatenates matches
-	literal := p.curToken.Literal
+	// Breaks the literal into parts and concatenates them, converting
+	// each interpolated expression with to_str
+	parts, ok := lexer.SplitFString(p.curToken.Literal)
+	if !ok {
+		p.errors = append(p.errors, "unclosed brace in f-string")
+		return nil
+	}
 	var exprs []ast.Expression
 
-	lastIdx := 0
-	for i := 0; i < len(literal); i++ {
-		if literal[i] == '{' {
-			// Add previous string part
-			if i > lastIdx {
-				exprs = append(exprs, &ast.StringLiteral{
-					Token: token.Token{Type: token.STRING, Literal: literal[lastIdx:i]},
-					Value: literal[lastIdx:i],
-				})
-			}
+	for _, part := range parts {
+		if !part.Expr {
+			exprs = append(exprs, &ast.StringLiteral{
+				Token: token.Token{Type: token.STRING, Literal: part.Text},
+				Value: part.Text,
+			})
+			continue
+		}
 
-			braceCount := 1
-			j := i + 1
-			for ; j < len(literal); j++ {
-				if literal[j] == '{' {
-					braceCount++
-				} else if literal[j] == '}' {
-					braceCount--
-					if braceCount == 0 {
-						break
-					}
-				}
-			}
+		// Parse expression
+		l := lexer.New(part.Text)
+		par := New(l) // Recursive parser
 
-			if j >= len(literal) {
-				// Error: unclosed brace
-				p.errors = append(p.errors, fmt.Sprintf("unclosed brace in f-string"))
-				return nil
+		innerExpr := par.parseExpression(LOWEST)
+		// Check errors
+		if len(par.Errors()) > 0 {
+			for _, msg := range par.Errors() {
+				p.errors = append(p.errors, fmt.Sprintf("f-string expr error: %s", msg))
 			}
+			return nil
+		}
 
-			exprContent := literal[i+1 : j]
-
-			// Parse expression
-			l := lexer.New(exprContent)
-			par := New(l) // Recursive parser
-
-			innerExpr := par.parseExpression(LOWEST)
-			// Check errors
-			if len(par.Errors()) > 0 {
-				for _, msg := range par.Errors() {
-					p.errors = append(p.errors, fmt.Sprintf("f-string expr error: %s", msg))
-				}
-				return nil
-			}
-
-			// Wrap in to_str() call: to_str(expr)
-			callExpr := &ast.CallExpression{
-				Token: token.Token{Type: token.IDENTIFIER, Literal: "("}, // Dummy token?
-				Function: &ast.Identifier{
-					Token: token.Token{Type: token.IDENTIFIER, Literal: "to_str"},
-					Value: "to_str",
-				},
-				Arguments: []ast.Expression{innerExpr},
-			}
-
-			exprs = append(exprs, callExpr)
-
-			lastIdx = j + 1
-			i = j // Advance outer loop
+		// Wrap in to_str() call: to_str(expr)
+		callExpr := &ast.CallExpression{
+			Token: token.Token{Type: token.IDENTIFIER, Literal: "("}, // Dummy token?
+			Function: &ast.Identifier{
+				Token: token.Token{Type: token.IDENTIFIER, Literal: "to_str"},
+				Value: "to_str",
+			},
+			Arguments: []ast.Expression{innerExpr},
 		}
-	}
 
-	// Add remaining string
-	if lastIdx < len(literal) {
-		exprs = append(exprs, &ast.StringLiteral{
-			Token: token.Token{Type: token.STRING, Literal: literal[lastIdx:]},
-			Value: literal[lastIdx:],
-		})
+		exprs = append(exprs, callExpr)
 	}
 
 	if len(exprs) == 0 {
@@ -897,12 +984,42 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseComparison parses an ordering comparison. One that follows another,
+// as in 0 <= i < n, extends it into a ComparisonChain instead of comparing
+// its bool result; (a < b) < c keeps its parentheses' meaning.
+func (p *Parser) parseComparison(left ast.Expression) ast.Expression {
+	expression := p.parseInfixExpression(left).(*ast.InfixExpression)
+	if left == p.grouped {
+		return expression
+	}
+	switch l := left.(type) {
+	case *ast.ComparisonChain:
+		l.Operands = append(l.Operands, expression.Right)
+		l.Operators = append(l.Operators, expression.Operator)
+		return l
+	case *ast.InfixExpression:
+		if isOrdering(l.Operator) {
+			return &ast.ComparisonChain{
+				Token:     l.Token,
+				Operands:  []ast.Expression{l.Left, l.Right, expression.Right},
+				Operators: []string{l.Operator, expression.Operator},
+			}
+		}
+	}
+	return expression
+}
+
+func isOrdering(op string) bool {
+	return op == "<" || op == ">" || op == "<=" || op == ">="
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
+	p.grouped = exp
 	return exp
 }
 
@@ -931,6 +1048,59 @@ func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 	return stmt
 }
 
+func (p *Parser) parseTryStatement() *ast.TryStatement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	stmt.Body = p.parseBlockStatement()
+
+	if !p.curTokenIs(token.CATCH) {
+		got := p.curToken.Literal
+		if p.curTokenIs(token.EOF) {
+			got = "EOF"
+		}
+		p.errors = append(p.errors, fmt.Sprintf("[%d:%d] SyntaxError: expected 'catch', found %s\n  hint: a try block ends with 'catch [name] ... end'",
+			p.curToken.Line, p.curToken.Column, got))
+		return nil
+	}
+	if p.peekTokenIs(token.IDENTIFIER) {
+		p.nextToken()
+		stmt.ErrorName = p.curToken.Literal
+	}
+
+	stmt.Handler = p.parseBlockStatement()
+
+	if !p.curTokenIs(token.END) {
+		got := p.curToken.Literal
+		if p.curTokenIs(token.EOF) {
+			got = "EOF"
+		}
+		p.errors = append(p.errors, fmt.Sprintf("[%d:%d] SyntaxError: expected 'end', found %s",
+			p.curToken.Line, p.curToken.Column, got))
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStmt {
+	stmt := &ast.ThrowStmt{Token: p.curToken}
+
+	if p.peekTokenIs(token.NEWLINE) || p.peekTokenIs(token.EOF) || p.peekTokenIs(token.END) {
+		p.errors = append(p.errors, fmt.Sprintf("[%d:%d] SyntaxError: expected an error after 'throw'\n  hint: use 'throw error(\"message\", code)'",
+			p.curToken.Line, p.curToken.Column))
+		return nil
+	}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	// Optional newline
+	if p.peekToken.Type == token.NEWLINE {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -938,7 +1108,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	// Skip current token (THEN or ELSE)
 	p.nextToken()
 
-	for !p.curTokenIs(token.END) && !p.curTokenIs(token.ELSE) && !p.curTokenIs(token.ELIF) && !p.curTokenIs(token.EOF) {
+	for !p.curTokenIs(token.END) && !p.curTokenIs(token.ELSE) && !p.curTokenIs(token.ELIF) && !p.curTokenIs(token.CATCH) && !p.curTokenIs(token.EOF) {
 		// Removed check for FUNC/STRUCT to allow nested definitions (closures)
 
 		stmt := p.parseStatement()
@@ -981,6 +1151,12 @@ func (p *Parser) parseCaseBody() *ast.BlockStatement {
 }
 
 func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
+	return p.parseFunction(false)
+}
+
+// parseFunction parses a function statement, or with method set a method
+// of a struct, whose first parameter is an untyped self.
+func (p *Parser) parseFunction(method bool) *ast.FunctionStatement {
 	stmt := &ast.FunctionStatement{Token: p.curToken}
 
 	if !p.expectPeek(token.IDENTIFIER) {
@@ -993,7 +1169,7 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	}
 
 	errCountBefore := len(p.errors)
-	stmt.Parameters = p.parseFunctionParameters()
+	stmt.Parameters = p.parseFunctionParameters(method)
 
 	// If there was an error in parameters (stmt.Parameters is nil AND/OR errors increased), skip until end
 	// Note: parseFunctionParameters returns nil on error.
@@ -1038,7 +1214,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	errCountBefore := len(p.errors)
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters = p.parseFunctionParameters(false)
 
 	if lit.Parameters == nil && len(p.errors) > errCountBefore {
 		p.skipUntilEnd()
@@ -1068,7 +1244,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Parameter {
+func (p *Parser) parseFunctionParameters(method bool) []*ast.Parameter {
 	parameters := []*ast.Parameter{}
 
 	if p.peekTokenIs(token.RPAREN) {
@@ -1082,25 +1258,30 @@ func (p *Parser) parseFunctionParameters() []*ast.Parameter {
 	paramName := p.curToken.Literal
 	// paramToken := p.curToken
 
-	// Expect Type: `name: type`
-	// Check for missing type
-	if p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RPAREN) {
-		msg := fmt.Sprintf("[%d:%d] SyntaxError: missing type annotation for parameter '%s'\n  hint: use '%s: <type>'",
-			p.peekToken.Line, p.peekToken.Column,
-			paramName, paramName)
-		p.errors = append(p.errors, msg)
-		// Don't return nil instantly, maybe try to recover?
-		// For now returning nil stops parsing effectively
-		return nil
-	}
+	if method && paramName == "self" && (p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RPAREN)) {
+		// A method's receiver has no annotation: it is the struct
+		parameters = append(parameters, &ast.Parameter{Name: paramName})
+	} else {
+		// Expect Type: `name: type`
+		// Check for missing type
+		if p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RPAREN) {
+			msg := fmt.Sprintf("[%d:%d] SyntaxError: missing type annotation for parameter '%s'\n  hint: use '%s: <type>'",
+				p.peekToken.Line, p.peekToken.Column,
+				paramName, paramName)
+			p.errors = append(p.errors, msg)
+			// Don't return nil instantly, maybe try to recover?
+			// For now returning nil stops parsing effectively
+			return nil
+		}
 
-	if !p.expectPeek(token.COLON) {
-		return nil
-	}
-	p.nextToken()          // eat COLON
-	pType := p.parseType() // eat Type
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()          // eat COLON
+		pType := p.parseType() // eat Type
 
-	parameters = append(parameters, &ast.Parameter{Name: paramName, Type: pType})
+		parameters = append(parameters, &ast.Parameter{Name: paramName, Type: pType})
+	}
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken() // eat COMMA
@@ -1120,7 +1301,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Parameter {
 			return nil
 		}
 		p.nextToken()
-		pType = p.parseType()
+		pType := p.parseType()
 
 		parameters = append(parameters, &ast.Parameter{Name: paramName, Type: pType})
 	}
@@ -1342,6 +1523,24 @@ func (p *Parser) parseStructStatement() *ast.StructStatement {
 			continue
 		}
 
+		if p.curTokenIs(token.FUNC) {
+			method := p.parseFunction(true)
+			switch {
+			case method == nil:
+				// Reported, and skipped up to the method's end
+			case len(method.Parameters) > 0 && method.Parameters[0].Name == "self" && method.Parameters[0].Type != nil:
+				p.errors = append(p.errors, fmt.Sprintf("[%d:%d] SyntaxError: self must not have a type annotation in method '%s'\n  hint: use 'func %s(self, ...)'",
+					method.Token.Line, method.Token.Column, method.Name, method.Name))
+			case len(method.Parameters) == 0 || method.Parameters[0].Name != "self":
+				p.errors = append(p.errors, fmt.Sprintf("[%d:%d] SyntaxError: method '%s' must take self as its first parameter\n  hint: use 'func %s(self, ...)'",
+					method.Token.Line, method.Token.Column, method.Name, method.Name))
+			default:
+				stmt.Methods = append(stmt.Methods, method)
+			}
+			p.nextToken() // eat END
+			continue
+		}
+
 		if p.curToken.Type != token.IDENTIFIER {
 			// Error or break?
 			// If not identifier, maybe illegal.
@@ -1411,6 +1610,14 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 
 	stmt.Collection = p.parseExpression(LOWEST)
 
+	if p.peekTokenIs(token.DOTDOT) {
+		p.nextToken()
+		rng := &ast.RangeExpression{Token: p.curToken, Start: stmt.Collection}
+		p.nextToken()
+		rng.End = p.parseExpression(LOWEST)
+		stmt.Collection = rng
+	}
+
 	if !p.expectPeek(token.DO) {
 		return nil
 	}
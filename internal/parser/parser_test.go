@@ -3,6 +3,7 @@ package parser
 import (
 	"noxy-vm/internal/ast"
 	"noxy-vm/internal/lexer"
+	"strings"
 	"testing"
 )
 
@@ -110,3 +111,292 @@ func TestParseMap(t *testing.T) {
 		t.Fatalf("map.Keys has wrong length. got=%d", len(mapLit.Keys))
 	}
 }
+
+func TestParseElif(t *testing.T) {
+	input := `
+if x < 0 then
+	y = 1
+elif x == 0 then
+	y = 2
+elif x < 10 then
+	y = 3
+else
+	y = 4
+end
+`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+			len(program.Statements))
+	}
+
+	// Each elif is an if nested alone in the alternative of the one before
+	stmt := program.Statements[0].(*ast.IfStatement)
+	conditions := []string{"(x < 0)", "(x == 0)", "(x < 10)"}
+	for i, want := range conditions {
+		if got := stmt.Condition.String(); got != want {
+			t.Fatalf("condition %d: got %s, want %s", i, got, want)
+		}
+		if i == len(conditions)-1 {
+			break
+		}
+		if stmt.Alternative == nil || len(stmt.Alternative.Statements) != 1 {
+			t.Fatalf("condition %d: alternative is not a single elif", i)
+		}
+		next, ok := stmt.Alternative.Statements[0].(*ast.IfStatement)
+		if !ok {
+			t.Fatalf("condition %d: alternative is %T, not *ast.IfStatement", i, stmt.Alternative.Statements[0])
+		}
+		stmt = next
+	}
+	if stmt.Alternative == nil || len(stmt.Alternative.Statements) != 1 {
+		t.Fatalf("the last elif has no else block")
+	}
+	if _, ok := stmt.Alternative.Statements[0].(*ast.IfStatement); ok {
+		t.Fatalf("the else block parsed as an elif")
+	}
+}
+
+func TestParseElifErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"if a then\nelse\nelif b then\nend", "[3:1] SyntaxError: 'elif' cannot follow 'else'"},
+		{"if a then\nelif b then\n", "[3:1] SyntaxError: expected 'end', 'else' or 'elif', found EOF"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.want {
+			t.Errorf("%q: got errors %q, want first %q", tt.input, errors, tt.want)
+		}
+	}
+}
+
+func TestParseStructMethods(t *testing.T) {
+	input := `
+struct Point
+	x: float
+	y: float
+
+	func dist(self) -> float
+		return self.x
+	end
+
+	func move(self, dx: float, dy: float)
+		self.x = self.x + dx
+	end
+end
+`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.StructStatement)
+	if len(stmt.FieldsList) != 2 {
+		t.Fatalf("got %d fields, want 2", len(stmt.FieldsList))
+	}
+	want := []string{"func dist(self: any) -> float ", "func move(self: any, dx: float, dy: float) "}
+	if len(stmt.Methods) != len(want) {
+		t.Fatalf("got %d methods, want %d", len(stmt.Methods), len(want))
+	}
+	for i, m := range stmt.Methods {
+		if got := m.String(); !strings.HasPrefix(got, want[i]) {
+			t.Errorf("method %d: got %q, want it to start with %q", i, got, want[i])
+		}
+	}
+}
+
+func TestParseFunctionTypes(t *testing.T) {
+	input := `
+let a: func = f
+let b: func(int, string) -> bool = f
+let c: func() -> void = f
+let d: func(ref int, func(int) -> int) = f
+let e: (func(int) -> int)[] = []
+let g: func(int) -> int[] = f
+let h: map[string, func(string) -> void] = {}
+func apply(cb: func(float) -> float, x: float) -> func() -> float
+    return cb
+end
+`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	want := []string{
+		"func",
+		"func(int, string) -> bool",
+		"func() -> void",
+		"func(ref int, func(int) -> int) -> any",
+		"(func(int) -> int)[]",
+		"func(int) -> int[]",
+		"map[string, func(string) -> void]",
+	}
+	if len(program.Statements) != len(want)+1 {
+		t.Fatalf("got %d statements, want %d", len(program.Statements), len(want)+1)
+	}
+	for i, w := range want {
+		if got := program.Statements[i].(*ast.LetStmt).Type.String(); got != w {
+			t.Errorf("statement %d: type %q, want %q", i+1, got, w)
+		}
+	}
+	fn := program.Statements[len(want)].(*ast.FunctionStatement)
+	if got := fn.String(); !strings.HasPrefix(got, "func apply(cb: func(float) -> float, x: float) -> func() -> float ") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseUsePlugin(t *testing.T) {
+	tests := []struct {
+		input  string
+		module string
+		bind   string
+		plugin bool
+	}{
+		{`use plugin "dynamodb"`, "dynamodb", "dynamodb", true},
+		{`use plugin "dynamodb" as ddb`, "dynamodb", "ddb", true},
+		{`use plugin "https://example.com/pricing" as pricing`, "https://example.com/pricing", "pricing", true},
+		// A module called plugin
+		{`use plugin`, "plugin", "plugin", false},
+		{`use plugin as p`, "plugin", "p", false},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.UseStmt)
+		if stmt.Module != tt.module || stmt.BindName() != tt.bind || stmt.Plugin != tt.plugin {
+			t.Errorf("%s: got module %q bound to %q (plugin %t)", tt.input, stmt.Module, stmt.BindName(), stmt.Plugin)
+		}
+	}
+
+	errorTests := []struct {
+		input string
+		want  string
+	}{
+		{`use plugin "https://example.com/pricing"`, "[1:12] SyntaxError: plugin \"https://example.com/pricing\" needs a name to be used by\n  hint: use 'use plugin \"https://example.com/pricing\" as <name>'"},
+		{`use plugin "if"`, "[1:12] SyntaxError: plugin \"if\" needs a name to be used by\n  hint: use 'use plugin \"if\" as <name>'"},
+		{`use plugin "s3" select get_object`, "[1:17] SyntaxError: cannot select from a plugin\n  hint: call its methods as s3.method(...)"},
+	}
+	for _, tt := range errorTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0] != tt.want {
+			t.Errorf("%q: got errors %q, want only %q", tt.input, errors, tt.want)
+		}
+	}
+}
+
+func TestParseStructMethodErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"struct P\n    func f(a: int) end\nend", "[2:5] SyntaxError: method 'f' must take self as its first parameter\n  hint: use 'func f(self, ...)'"},
+		{"struct P\n    func f() end\nend", "[2:5] SyntaxError: method 'f' must take self as its first parameter\n  hint: use 'func f(self, ...)'"},
+		{"struct P\n    func f(self: P) end\nend", "[2:5] SyntaxError: self must not have a type annotation in method 'f'\n  hint: use 'func f(self, ...)'"},
+		{"struct P\n    func f(a: int, self) end\nend", "[2:24] SyntaxError: missing type annotation for parameter 'self'\n  hint: use 'self: <type>'"},
+		{"func f(self) end", "[1:12] SyntaxError: missing type annotation for parameter 'self'\n  hint: use 'self: <type>'"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0] != tt.want {
+			t.Errorf("%q: got errors %q, want only %q", tt.input, errors, tt.want)
+		}
+	}
+}
+
+func TestParseTryCatch(t *testing.T) {
+	tests := []struct {
+		input   string
+		errName string
+		body    int
+		handler int
+	}{
+		{"try\n    f()\n    g()\ncatch e\n    print(e)\nend", "e", 2, 1},
+		{"try\n    f()\ncatch\nend", "", 1, 0},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.TryStatement)
+		if stmt.ErrorName != tt.errName || len(stmt.Body.Statements) != tt.body || len(stmt.Handler.Statements) != tt.handler {
+			t.Errorf("%q: got %s", tt.input, stmt)
+		}
+	}
+
+	p := New(lexer.New(`throw error("bad", 2)`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if got := program.Statements[0].String(); got != "throw error(bad, 2)" {
+		t.Errorf("got %s", got)
+	}
+
+	errorTests := []struct {
+		input string
+		want  string
+	}{
+		{"try\n    f()\nend", "[3:1] SyntaxError: expected 'catch', found end\n  hint: a try block ends with 'catch [name] ... end'"},
+		{"throw\n", "[1:1] SyntaxError: expected an error after 'throw'\n  hint: use 'throw error(\"message\", code)'"},
+	}
+	for _, tt := range errorTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0] != tt.want {
+			t.Errorf("%q: got errors %q, want only %q", tt.input, errors, tt.want)
+		}
+	}
+}
+
+func TestParseCoalesce(t *testing.T) {
+	tests := map[string]string{
+		"a ?? b":           "(a ?? b)",
+		"a ?? b ?? c":      "((a ?? b) ?? c)",
+		"m[k] ?? 0 + 1":    "((m[k]) ?? (0 + 1))",
+		"a ?? b || c == d": "(a ?? (b || (c == d)))",
+	}
+	for input, want := range tests {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.Statements[0].String(); got != want {
+			t.Errorf("%q: got %s, want %s", input, got, want)
+		}
+	}
+
+	p := New(lexer.New("a ? b"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("a single '?' parsed without errors")
+	}
+}
+
+func TestParseComparisonChain(t *testing.T) {
+	tests := map[string]string{
+		"0 <= i < n":          "(0 <= i < n)",
+		"a < b <= c > d":      "(a < b <= c > d)",
+		"0 <= i < n + 1 == t": "((0 <= i < (n + 1)) == t)",
+		"a < b == c < d":      "((a < b) == (c < d))",
+		"(a < b) < c":         "((a < b) < c)",
+		"a < (b < c)":         "(a < (b < c))",
+	}
+	for input, want := range tests {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.Statements[0].String(); got != want {
+			t.Errorf("%q: got %s, want %s", input, got, want)
+		}
+	}
+}
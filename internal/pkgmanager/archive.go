@@ -0,0 +1,219 @@
+package pkgmanager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HTTPClient is used for archive downloads. It honours HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY.
+var HTTPClient = &http.Client{
+	Timeout:   5 * time.Minute,
+	Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+}
+
+// Offline forbids all network access; packages must come from the cache or
+// an existing noxy_libs install.
+var Offline bool
+
+// proxyList returns the package sources from NOXY_PROXY, a comma-separated
+// list of mirror URLs and the keyword "direct" (the package's own host).
+// The default is "direct".
+func proxyList() []string {
+	env := os.Getenv("NOXY_PROXY")
+	if env == "" {
+		return []string{"direct"}
+	}
+	var list []string
+	for _, entry := range strings.Split(env, ",") {
+		entry = strings.TrimRight(strings.TrimSpace(entry), "/")
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+	if len(list) == 0 {
+		return []string{"direct"}
+	}
+	return list
+}
+
+// fetchFromMirror downloads <mirror>/<pkg>/@v/<version>.tar.gz. Mirror
+// archives use the same layout as GitHub's: one top-level directory.
+func fetchFromMirror(mirror, repoURL, version, dir string) error {
+	url := fmt.Sprintf("%s/%s/@v/%s.tar.gz", mirror, repoURL, version)
+	resp, err := HTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return extractTarGz(resp.Body, dir)
+}
+
+// archiveURL returns the tarball URL for a repository at a given ref, or
+// false if the host has no known archive endpoint.
+func archiveURL(repoURL, ref string) (string, bool) {
+	parts := strings.Split(strings.TrimSuffix(repoURL, ".git"), "/")
+	if len(parts) != 3 {
+		return "", false
+	}
+	host, user, repo := parts[0], parts[1], parts[2]
+
+	switch host {
+	case "github.com":
+		return fmt.Sprintf("https://codeload.github.com/%s/%s/tar.gz/%s", user, repo, ref), true
+	case "gitlab.com":
+		return fmt.Sprintf("https://gitlab.com/%s/%s/-/archive/%s/%s-%s.tar.gz", user, repo, ref, repo, ref), true
+	}
+	return "", false
+}
+
+// fetchArchive downloads the repository tarball and extracts it into dir.
+func fetchArchive(repoURL, ref, dir string) error {
+	url, ok := archiveURL(repoURL, ref)
+	if !ok {
+		return fmt.Errorf("no archive endpoint for %s", repoURL)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	authorizeArchiveRequest(req, repoURL)
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return extractTarGz(resp.Body, dir)
+}
+
+// extractTarGz unpacks a gzipped tarball into dir, dropping the single
+// top-level directory that GitHub/GitLab archives wrap their contents in.
+func extractTarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Strip the leading "<repo>-<ref>/" component
+		name := hdr.Name
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		} else {
+			continue
+		}
+		if name == "" {
+			continue
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(name))
+		if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+			return fmt.Errorf("archive entry escapes target directory: %s", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			mode := os.FileMode(0644)
+			if hdr.Mode&0111 != 0 {
+				mode = 0755
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		default:
+			// Symlinks and other special entries are skipped
+		}
+	}
+}
+
+// HashDir computes a content hash over every regular file under dir
+// (relative path and contents, in sorted order). The result is independent
+// of how the package was fetched, so tarball and git installs agree.
+func HashDir(dir string) (string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return "", err
+		}
+		fileSum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%x  %s\n", fileSum, name)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
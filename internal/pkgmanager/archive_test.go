@@ -0,0 +1,226 @@
+package pkgmanager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractAndHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noxy-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := makeTarGz(t, map[string]string{
+		"repo-v1.0.0/lib.nx":       "func f() -> int return 1 end\n",
+		"repo-v1.0.0/sub/noxy.mod": "module repo\n",
+	})
+
+	if err := extractTarGz(bytes.NewReader(data), dir); err != nil {
+		t.Fatalf("extractTarGz failed: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "sub", "noxy.mod"))
+	if err != nil {
+		t.Fatalf("expected top-level directory to be stripped: %v", err)
+	}
+	if string(content) != "module repo\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	h1, err := HashDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, _ := HashDir(dir)
+	if h1 != h2 {
+		t.Errorf("hash is not stable: %s != %s", h1, h2)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "lib.nx"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h3, _ := HashDir(dir)
+	if h3 == h1 {
+		t.Errorf("hash did not change after modifying a file")
+	}
+}
+
+func TestExtractRejectsTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noxy-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := makeTarGz(t, map[string]string{"repo/../../evil.nx": "x"})
+	if err := extractTarGz(bytes.NewReader(data), dir); err == nil {
+		t.Errorf("expected error for entry escaping the target directory")
+	}
+}
+
+func TestSumFile(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "noxy.sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	sf := NewSumFile()
+	sf.Set("github.com/user/repo", "v1.0.0", "sha256:abc")
+	if err := sf.Save(tmpfile.Name()); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := ParseSumFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("ParseSumFile failed: %v", err)
+	}
+	if h, ok := loaded.Get("github.com/user/repo", "v1.0.0"); !ok || h != "sha256:abc" {
+		t.Errorf("expected sha256:abc, got %q (found=%v)", h, ok)
+	}
+
+	missing, err := ParseSumFile(filepath.Join(os.TempDir(), "does-not-exist.sum"))
+	if err != nil || len(missing.Sums) != 0 {
+		t.Errorf("expected empty sum file for missing path, got %v, %v", missing, err)
+	}
+}
+
+func TestArchiveURL(t *testing.T) {
+	url, ok := archiveURL("github.com/user/repo", "v1.0.0")
+	if !ok || url != "https://codeload.github.com/user/repo/tar.gz/v1.0.0" {
+		t.Errorf("unexpected github archive url %q", url)
+	}
+	url, ok = archiveURL("gitlab.com/user/repo", "main")
+	if !ok || url != "https://gitlab.com/user/repo/-/archive/main/repo-main.tar.gz" {
+		t.Errorf("unexpected gitlab archive url %q", url)
+	}
+	if _, ok := archiveURL("example.com/user/repo", "v1"); ok {
+		t.Errorf("expected no archive url for unknown host")
+	}
+}
+
+func TestCachePathAndCopy(t *testing.T) {
+	cache, err := ioutil.TempDir("", "noxy-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+	os.Setenv("NOXY_CACHE", cache)
+	defer os.Unsetenv("NOXY_CACHE")
+
+	src := filepath.Join(cache, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "sub", "a.nx"), []byte("let a: int = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h1, _ := HashDir(src)
+
+	want := filepath.Join(cache, "github_com", "user", "repo@v1.2.3-"+h1[len("sha256:"):len("sha256:")+16])
+	if got := CachePath("github.com/user/repo", "v1.2.3", h1); got != want {
+		t.Errorf("CachePath = %q, want %q", got, want)
+	}
+	// A moved tag has different contents and so a different entry
+	if other := CachePath("github.com/user/repo", "v1.2.3", "sha256:0123456789abcdef0123"); other == want {
+		t.Errorf("CachePath ignores the hash")
+	}
+
+	if got, ok := storeInCache("github.com/user/repo", "v1.2.3", h1, src); !ok || got != want {
+		t.Fatalf("storeInCache = %q, %v", got, ok)
+	}
+
+	h2, err := HashDir(want)
+	if err != nil {
+		t.Fatalf("cached copy missing: %v", err)
+	}
+	if h1 != h2 {
+		t.Errorf("cached copy differs from source: %s != %s", h1, h2)
+	}
+
+	if _, ok := CachedPackage("github.com/user/repo", "v1.2.3", h1); !ok {
+		t.Errorf("CachedPackage rejected an intact entry")
+	}
+	if _, ok := CachedPackage("github.com/user/repo", "v1.2.4", h1); ok {
+		t.Errorf("CachedPackage accepted a missing entry")
+	}
+}
+
+func TestMirrorAndOffline(t *testing.T) {
+	data := makeTarGz(t, map[string]string{"lib-v1.0.0/lib.nx": "func f() -> int return 1 end\n"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/example.com/user/lib/@v/v1.0.0.tar.gz":
+			w.Write(data)
+		case "/example.com/user/lib/@v/list":
+			fmt.Fprint(w, "v1.0.0\nv1.1.0\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	os.Setenv("NOXY_PROXY", "http://127.0.0.1:1/, "+srv.URL)
+	defer os.Unsetenv("NOXY_PROXY")
+
+	dir, err := ioutil.TempDir("", "noxy-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "lib")
+	if err := fetchPackage("example.com/user/lib", "https://example.com/user/lib.git", "v1.0.0", target); err != nil {
+		t.Fatalf("fetch from mirror failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "lib.nx")); err != nil {
+		t.Fatalf("expected lib.nx from mirror: %v", err)
+	}
+
+	tags, err := listVersions("example.com/user/lib")
+	if err != nil || len(tags) != 2 || tags[1] != "v1.1.0" {
+		t.Fatalf("listVersions = %v, %v", tags, err)
+	}
+
+	Offline = true
+	defer func() { Offline = false }()
+	if err := fetchPackage("example.com/user/lib", "", "v1.0.0", filepath.Join(dir, "other")); err == nil {
+		t.Fatal("expected offline fetch to fail")
+	}
+	if _, err := listVersions("example.com/user/lib"); err == nil {
+		t.Fatal("expected offline listVersions to fail")
+	}
+}
@@ -0,0 +1,197 @@
+package pkgmanager
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// parsePackageArg splits a --get argument into the package path, the URL
+// git should clone and the version. Besides github.com/user/repo[@version]
+// it accepts SSH forms, which are cloned over SSH but recorded under the
+// plain package path:
+//
+//	git@github.com:user/repo[.git][@version]
+//	ssh://git@github.com/user/repo[.git][@version]
+func parsePackageArg(arg string) (repoURL, gitURL, version string) {
+	version = "HEAD"
+
+	rest := arg
+	var sshHost string
+	switch {
+	case strings.HasPrefix(arg, "ssh://"):
+		rest = strings.TrimPrefix(arg, "ssh://")
+		if i := strings.Index(rest, "@"); i >= 0 && i < strings.Index(rest, "/") {
+			rest = rest[i+1:] // drop "git@"
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			sshHost, rest = rest[:i], rest[i+1:]
+		}
+	case strings.HasPrefix(arg, "git@"):
+		rest = strings.TrimPrefix(arg, "git@")
+		if i := strings.Index(rest, ":"); i >= 0 {
+			sshHost, rest = rest[:i], rest[i+1:]
+		}
+	}
+
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest, version = rest[:i], rest[i+1:]
+	}
+	rest = strings.TrimSuffix(rest, ".git")
+
+	if sshHost != "" {
+		repoURL = sshHost + "/" + rest
+		gitURL = "git@" + sshHost + ":" + rest + ".git"
+		return
+	}
+
+	repoURL = rest
+	gitURL = rest
+	if !strings.HasPrefix(gitURL, "http") && !strings.HasPrefix(gitURL, "git@") {
+		if os.Getenv("NOXY_GIT_SSH") != "" {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				gitURL = "git@" + rest[:i] + ":" + rest[i+1:] + ".git"
+			}
+		} else {
+			gitURL = "https://" + gitURL
+		}
+	}
+	return
+}
+
+// defaultTokenHosts are the hosts NOXY_GIT_TOKEN is sent to when
+// NOXY_GIT_TOKEN_HOSTS is not set.
+const defaultTokenHosts = "github.com,gitlab.com"
+
+// hostToken returns the access token for a git host: NOXY_GIT_TOKEN if the
+// host is one of NOXY_GIT_TOKEN_HOSTS, otherwise the password of the
+// matching machine entry in ~/.netrc. Limiting the token to named hosts
+// keeps it from reaching a server that only a dependency points at.
+func hostToken(host string) string {
+	if tok := os.Getenv("NOXY_GIT_TOKEN"); tok != "" && tokenHost(host) {
+		return tok
+	}
+	return netrcPassword(host)
+}
+
+// tokenHost reports whether NOXY_GIT_TOKEN may be sent to host.
+func tokenHost(host string) bool {
+	hosts := os.Getenv("NOXY_GIT_TOKEN_HOSTS")
+	if hosts == "" {
+		hosts = defaultTokenHosts
+	}
+	for _, h := range strings.Split(hosts, ",") {
+		if strings.EqualFold(strings.TrimSpace(h), host) {
+			return true
+		}
+	}
+	return false
+}
+
+func netrcPath() string {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcPassword looks up "machine <host> ... password <token>" in netrc.
+func netrcPassword(host string) string {
+	path := netrcPath()
+	if path == "" {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, strings.Fields(line)...)
+	}
+
+	inMachine := false
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			inMachine = i+1 < len(tokens) && tokens[i+1] == host
+			i++
+		case "default":
+			inMachine = true
+		case "password":
+			if inMachine && i+1 < len(tokens) {
+				return tokens[i+1]
+			}
+			i++
+		case "login", "account":
+			i++
+		}
+	}
+	return ""
+}
+
+// repoHost returns the host part of a package path.
+func repoHost(repoURL string) string {
+	if i := strings.Index(repoURL, "/"); i >= 0 {
+		return repoURL[:i]
+	}
+	return repoURL
+}
+
+// authorizeArchiveRequest attaches the host's token to an archive request.
+func authorizeArchiveRequest(req *http.Request, repoURL string) {
+	host := repoHost(repoURL)
+	tok := hostToken(host)
+	if tok == "" {
+		return
+	}
+	if host == "gitlab.com" {
+		req.Header.Set("PRIVATE-TOKEN", tok)
+		return
+	}
+	req.Header.Set("Authorization", "token "+tok)
+}
+
+// gitAuthEnv returns environment variables that make git send the host's
+// token as an HTTP header. They are read by git as configuration
+// (GIT_CONFIG_COUNT, git 2.31 and later), so the token never appears in
+// the clone URL or on the command line, where the process list shows it.
+func gitAuthEnv(repoURL string) []string {
+	host := repoHost(repoURL)
+	tok := hostToken(host)
+	if tok == "" {
+		return nil
+	}
+	user := "x-access-token"
+	if host == "gitlab.com" {
+		user = "oauth2"
+	}
+	cred := base64.StdEncoding.EncodeToString([]byte(user + ":" + tok))
+	// Keep any configuration the user already passes this way
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	return []string{
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+1),
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=http.extraHeader", n),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", n, cred),
+	}
+}
@@ -0,0 +1,108 @@
+package pkgmanager
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePackageArg(t *testing.T) {
+	os.Unsetenv("NOXY_GIT_SSH")
+
+	tests := []struct {
+		arg, repo, git, version string
+	}{
+		{"github.com/user/repo", "github.com/user/repo", "https://github.com/user/repo", "HEAD"},
+		{"github.com/user/repo@v1.2.0", "github.com/user/repo", "https://github.com/user/repo", "v1.2.0"},
+		{"git@github.com:user/repo.git", "github.com/user/repo", "git@github.com:user/repo.git", "HEAD"},
+		{"git@gitlab.com:team/lib@v0.3.0", "gitlab.com/team/lib", "git@gitlab.com:team/lib.git", "v0.3.0"},
+		{"ssh://git@github.com/user/repo.git@v2.0.0", "github.com/user/repo", "git@github.com:user/repo.git", "v2.0.0"},
+	}
+
+	for _, tt := range tests {
+		repo, git, version := parsePackageArg(tt.arg)
+		if repo != tt.repo || git != tt.git || version != tt.version {
+			t.Errorf("parsePackageArg(%q) = (%q, %q, %q), want (%q, %q, %q)",
+				tt.arg, repo, git, version, tt.repo, tt.git, tt.version)
+		}
+	}
+}
+
+func TestHostTokenSources(t *testing.T) {
+	dir := t.TempDir()
+	netrc := filepath.Join(dir, "netrc")
+	content := "machine gitlab.com\n  login me\n  password glpat-secret\nmachine github.com login me password ghp_netrc\n"
+	if err := ioutil.WriteFile(netrc, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("NETRC", netrc)
+	defer os.Unsetenv("NETRC")
+	os.Unsetenv("NOXY_GIT_TOKEN")
+
+	if tok := hostToken("gitlab.com"); tok != "glpat-secret" {
+		t.Errorf("expected netrc token for gitlab.com, got %q", tok)
+	}
+	if tok := hostToken("example.com"); tok != "" {
+		t.Errorf("expected no token for unknown host, got %q", tok)
+	}
+
+	os.Setenv("NOXY_GIT_TOKEN", "env-token")
+	defer os.Unsetenv("NOXY_GIT_TOKEN")
+	if tok := hostToken("github.com"); tok != "env-token" {
+		t.Errorf("NOXY_GIT_TOKEN should take precedence, got %q", tok)
+	}
+
+	req, _ := http.NewRequest("GET", "https://codeload.github.com/u/r/tar.gz/v1", nil)
+	authorizeArchiveRequest(req, "github.com/u/r")
+	if got := req.Header.Get("Authorization"); got != "token env-token" {
+		t.Errorf("unexpected Authorization header %q", got)
+	}
+
+	req, _ = http.NewRequest("GET", "https://gitlab.com/u/r/-/archive/v1/r-v1.tar.gz", nil)
+	authorizeArchiveRequest(req, "gitlab.com/u/r")
+	if got := req.Header.Get("PRIVATE-TOKEN"); got != "env-token" {
+		t.Errorf("unexpected PRIVATE-TOKEN header %q", got)
+	}
+
+	// Other hosts never get NOXY_GIT_TOKEN, only their netrc entry
+	if tok := hostToken("evil.example.com"); tok != "" {
+		t.Errorf("NOXY_GIT_TOKEN sent to an unlisted host: %q", tok)
+	}
+	os.Setenv("NOXY_GIT_TOKEN_HOSTS", "git.corp.example.com, GitHub.com")
+	defer os.Unsetenv("NOXY_GIT_TOKEN_HOSTS")
+	if tok := hostToken("git.corp.example.com"); tok != "env-token" {
+		t.Errorf("expected NOXY_GIT_TOKEN for a listed host, got %q", tok)
+	}
+	if tok := hostToken("github.com"); tok != "env-token" {
+		t.Errorf("host list should be case-insensitive, got %q", tok)
+	}
+	if tok := hostToken("gitlab.com"); tok != "glpat-secret" {
+		t.Errorf("expected netrc token for an unlisted host, got %q", tok)
+	}
+}
+
+func TestGitAuthEnv(t *testing.T) {
+	os.Unsetenv("NETRC")
+	os.Unsetenv("NOXY_GIT_TOKEN_HOSTS")
+	os.Setenv("NOXY_GIT_TOKEN", "env-token")
+	defer os.Unsetenv("NOXY_GIT_TOKEN")
+	os.Setenv("GIT_CONFIG_COUNT", "1")
+	defer os.Unsetenv("GIT_CONFIG_COUNT")
+
+	cred := base64.StdEncoding.EncodeToString([]byte("x-access-token:env-token"))
+	want := []string{
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_1=http.extraHeader",
+		"GIT_CONFIG_VALUE_1=Authorization: Basic " + cred,
+	}
+	if got := gitAuthEnv("github.com/u/r"); !reflect.DeepEqual(got, want) {
+		t.Errorf("gitAuthEnv = %q, want %q", got, want)
+	}
+	if got := gitAuthEnv("example.com/u/r"); got != nil {
+		t.Errorf("expected no credentials for an unlisted host, got %q", got)
+	}
+}
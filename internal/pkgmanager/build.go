@@ -0,0 +1,117 @@
+package pkgmanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// AllowBuild permits running the build commands packages declare in their
+// noxy.mod. Builds are not sandboxed: they execute code from the package
+// with the user's filesystem and network access, so they are opt-in.
+var AllowBuild bool
+
+// BuildTimeout bounds a single package build.
+const BuildTimeout = 10 * time.Minute
+
+// buildEnv lists the variables passed to build commands. Everything else
+// in the environment (tokens, cloud credentials) is withheld from package
+// code; this guards against leaks, not against a hostile package.
+var buildEnv = []string{
+	"PATH", "HOME", "USERPROFILE", "SYSTEMROOT", "COMSPEC", "PATHEXT",
+	"TEMP", "TMP", "TMPDIR", "APPDATA", "LOCALAPPDATA",
+	"GOROOT", "GOPATH", "GOCACHE", "GOMODCACHE", "GOPROXY", "GOFLAGS", "GOTOOLCHAIN",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+}
+
+// runBuild runs the build command declared by the package installed in dir,
+// if any. The command runs unsandboxed, inside dir without a shell, with a
+// filtered environment and a timeout.
+func runBuild(repoURL, dir string) error {
+	command, err := buildCommand(dir)
+	if err != nil || command == "" {
+		return err
+	}
+
+	if !AllowBuild {
+		fmt.Printf("Note: %s has a build step (%s); run with -allow-build to build it (unsandboxed; only for packages you trust)\n", repoURL, command)
+		return nil
+	}
+
+	args := strings.Fields(command)
+	if err := checkBuildExecutable(args[0]); err != nil {
+		return fmt.Errorf("build of %s: %w", repoURL, err)
+	}
+
+	fmt.Printf("Building %s: %s\n", repoURL, command)
+	ctx, cancel := context.WithTimeout(context.Background(), BuildTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = filteredEnv()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("build of %s timed out after %s", repoURL, BuildTimeout)
+		}
+		return fmt.Errorf("build of %s failed: %w", repoURL, err)
+	}
+	return nil
+}
+
+// buildCommand returns the build command the package in dir declares for
+// this platform, or "".
+func buildCommand(dir string) (string, error) {
+	modPath := filepath.Join(dir, "noxy.mod")
+	if _, err := os.Stat(modPath); err != nil {
+		return "", nil
+	}
+	config, err := ParseModFile(modPath)
+	if err != nil {
+		return "", err
+	}
+	return config.BuildCommand(runtime.GOOS), nil
+}
+
+// hasBuildStep reports whether the package in dir has to be built, which
+// only happens in noxy_libs. An unreadable noxy.mod counts as one, so
+// runBuild gets to report it.
+func hasBuildStep(dir string) bool {
+	command, err := buildCommand(dir)
+	return err != nil || command != ""
+}
+
+// checkBuildExecutable rejects executable paths that point outside the
+// package: it must be a program on PATH or a file inside the package. It
+// does not limit what that program does.
+func checkBuildExecutable(name string) error {
+	if filepath.IsAbs(name) {
+		return fmt.Errorf("absolute executable path %s is not allowed", name)
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("executable %s is outside the package", name)
+		}
+	}
+	return nil
+}
+
+func filteredEnv() []string {
+	var env []string
+	for _, key := range buildEnv {
+		if val, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+val)
+		}
+	}
+	if Offline {
+		env = append(env, "GOPROXY=off")
+	}
+	return env
+}
@@ -0,0 +1,64 @@
+package pkgmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBuildCommandSelection(t *testing.T) {
+	config := NewModuleConfig()
+	config.Build[""] = "bash build_plugin.sh"
+	config.Build["windows"] = "powershell -File build_plugin.ps1"
+
+	if got := config.BuildCommand("linux"); got != "bash build_plugin.sh" {
+		t.Errorf("linux build = %q", got)
+	}
+	if got := config.BuildCommand("windows"); got != "powershell -File build_plugin.ps1" {
+		t.Errorf("windows build = %q", got)
+	}
+
+	for _, bad := range []string{"/bin/sh", "../evil.sh", "tools/../../x"} {
+		if err := checkBuildExecutable(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if err := checkBuildExecutable("./build.sh"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "noxy.mod"), "module plugin\n\nbuild sh build.sh\n")
+	writeFile(t, filepath.Join(dir, "build.sh"), "echo \"token=$SECRET_TOKEN\" > built\n")
+	os.Setenv("SECRET_TOKEN", "leaked")
+	defer os.Unsetenv("SECRET_TOKEN")
+
+	// Without --allow-build nothing runs
+	AllowBuild = false
+	if err := runBuild("example.com/user/plugin", dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "built")); err == nil {
+		t.Fatal("build ran without AllowBuild")
+	}
+
+	AllowBuild = true
+	defer func() { AllowBuild = false }()
+	if err := runBuild("example.com/user/plugin", dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "built"))
+	if err != nil {
+		t.Fatalf("build did not run: %v", err)
+	}
+	if string(data) != "token=\n" {
+		t.Errorf("build environment was not filtered: %q", data)
+	}
+}
@@ -0,0 +1,124 @@
+package pkgmanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CacheDir returns the user-level package cache: $NOXY_CACHE if set,
+// otherwise ~/.noxy/cache.
+func CacheDir() string {
+	if dir := os.Getenv("NOXY_CACHE"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "noxy-cache")
+	}
+	return filepath.Join(home, ".noxy", "cache")
+}
+
+// CachePath returns where a package version with the given content hash
+// (as recorded in noxy.sum) lives in the cache, e.g.
+// ~/.noxy/cache/github_com/user/repo@v1.0.0-9f2c4e1a0b7d3c58. The hash is
+// part of the key so a tag that is moved gets a new entry instead of
+// reusing the old copy.
+func CachePath(repoURL, version, hash string) string {
+	sum := strings.TrimPrefix(hash, "sha256:")
+	if len(sum) > 16 {
+		sum = sum[:16]
+	}
+	return filepath.Join(CacheDir(), filepath.FromSlash(LibPath(repoURL))+"@"+version+"-"+sum)
+}
+
+// CachedPackage returns the cache entry of a package version with the
+// given hash, and whether it exists and its contents still match the hash.
+func CachedPackage(repoURL, version, hash string) (string, bool) {
+	dir := CachePath(repoURL, version, hash)
+	if !dirExists(dir) {
+		return dir, false
+	}
+	got, err := HashDir(dir)
+	return dir, err == nil && got == hash
+}
+
+// verifiedCache holds the cache entries already checked by
+// verifiedCachedPackage in this process.
+var verifiedCache sync.Map
+
+// verifiedCachedPackage is CachedPackage for import resolution, which asks
+// for the same packages over and over: each entry is hashed only once per
+// process.
+func verifiedCachedPackage(repoURL, version, hash string) (string, bool) {
+	dir := CachePath(repoURL, version, hash)
+	if _, ok := verifiedCache.Load(dir); ok {
+		return dir, true
+	}
+	dir, ok := CachedPackage(repoURL, version, hash)
+	if ok {
+		verifiedCache.Store(dir, true)
+	}
+	return dir, ok
+}
+
+// storeInCache copies a verified package into the cache and returns the
+// entry. Failures only cost a future re-download, so they are reported as
+// warnings.
+func storeInCache(repoURL, version, hash, srcDir string) (string, bool) {
+	dst := CachePath(repoURL, version, hash)
+	tmp := dst + ".tmp"
+	os.RemoveAll(tmp)
+	if err := copyDir(srcDir, tmp); err != nil {
+		fmt.Printf("Warning: failed to cache %s@%s: %s\n", repoURL, version, err)
+		os.RemoveAll(tmp)
+		return "", false
+	}
+	os.RemoveAll(dst)
+	if err := os.Rename(tmp, dst); err != nil {
+		fmt.Printf("Warning: failed to cache %s@%s: %s\n", repoURL, version, err)
+		os.RemoveAll(tmp)
+		return "", false
+	}
+	return dst, true
+}
+
+// copyDir recursively copies src to dst, preserving file modes.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
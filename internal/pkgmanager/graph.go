@@ -0,0 +1,296 @@
+package pkgmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// depGraph is the resolved dependency tree of a project: every reachable
+// package with the version in use and the packages it requires.
+type depGraph struct {
+	Versions map[string]string   // pkg -> version
+	Deps     map[string][]string // pkg -> required pkgs
+	Direct   map[string]bool     // required by the project itself
+	Parent   map[string]string   // first package that pulled pkg in ("" = project)
+}
+
+// project is a noxy.mod together with the noxy.sum next to it.
+type project struct {
+	Dir      string
+	Config   *ModuleConfig
+	Sums     *SumFile
+	Vendored bool
+}
+
+// loadProjectAt reads the project in dir. A missing noxy.sum is an empty one.
+func loadProjectAt(dir string, config *ModuleConfig) (*project, error) {
+	sums, err := ParseSumFile(filepath.Join(dir, SumFileName))
+	if err != nil {
+		return nil, err
+	}
+	return &project{Dir: dir, Config: config, Sums: sums, Vendored: IsVendored(dir)}, nil
+}
+
+// packageDir returns where a package's source is found: a local
+// replacement, the copy in noxy_libs, or, unless the project is vendored,
+// the cache entry matching its hash in noxy.sum.
+func (p *project) packageDir(pkg, version string) string {
+	source, sourceVersion := pkg, version
+	if target, ok := p.Config.Replace[pkg]; ok {
+		if IsLocalReplacement(target) {
+			if filepath.IsAbs(target) {
+				return target
+			}
+			return filepath.Join(p.Dir, target)
+		}
+		var v string
+		source, _, v = parsePackageArg(target)
+		if v != "HEAD" {
+			sourceVersion = v
+		}
+	}
+	if dir := filepath.Join(p.Dir, NoxyLibsDir, filepath.FromSlash(LibPath(pkg))); dirExists(dir) {
+		return dir
+	}
+	if p.Vendored {
+		return ""
+	}
+	if hash, ok := p.Sums.Get(source, sourceVersion); ok {
+		if dir, ok := verifiedCachedPackage(source, sourceVersion, hash); ok {
+			return dir
+		}
+	}
+	return ""
+}
+
+// CachedPackages maps every package in the dependency graph of the project
+// in dir, described by config, to its verified entry in the package cache.
+// Packages found in noxy_libs or replaced by a local directory are left
+// out, and so is everything when the project is vendored.
+func CachedPackages(dir string, config *ModuleConfig) map[string]string {
+	cached := make(map[string]string)
+	p, err := loadProjectAt(dir, config)
+	if err != nil || p.Vendored {
+		return cached
+	}
+	cache := CacheDir() + string(filepath.Separator)
+	g := loadGraph(p)
+	for pkg, version := range g.Versions {
+		if d := p.packageDir(pkg, version); strings.HasPrefix(d, cache) {
+			cached[pkg] = d
+		}
+	}
+	return cached
+}
+
+// loadGraph walks requirements breadth-first from the project's noxy.mod.
+// When two packages require different versions, the first one seen wins,
+// which always favours the project's own requirements.
+func loadGraph(p *project) *depGraph {
+	root := p.Config
+	g := &depGraph{
+		Versions: make(map[string]string),
+		Deps:     make(map[string][]string),
+		Direct:   make(map[string]bool),
+		Parent:   make(map[string]string),
+	}
+
+	queue := sortedKeys(root.Require)
+	for _, pkg := range queue {
+		g.Versions[pkg] = root.Require[pkg]
+		g.Direct[pkg] = true
+		g.Parent[pkg] = ""
+	}
+
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+
+		dir := p.packageDir(pkg, g.Versions[pkg])
+		if dir == "" {
+			continue
+		}
+		modPath := filepath.Join(dir, "noxy.mod")
+		if _, err := os.Stat(modPath); err != nil {
+			continue
+		}
+		config, err := ParseModFile(modPath)
+		if err != nil {
+			continue
+		}
+
+		for _, dep := range sortedKeys(config.Require) {
+			if dep == pkg {
+				continue
+			}
+			g.Deps[pkg] = append(g.Deps[pkg], dep)
+			if _, seen := g.Versions[dep]; !seen {
+				g.Versions[dep] = config.Require[dep]
+				g.Parent[dep] = pkg
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return g
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func loadProjectModFile() (*ModuleConfig, error) {
+	if _, err := os.Stat("noxy.mod"); err != nil {
+		return nil, fmt.Errorf("noxy.mod not found in current directory")
+	}
+	return ParseModFile("noxy.mod")
+}
+
+func loadProject() (*project, error) {
+	config, err := loadProjectModFile()
+	if err != nil {
+		return nil, err
+	}
+	return loadProjectAt(".", config)
+}
+
+// List prints every dependency of the project with its version. Packages
+// only pulled in by other packages are marked "// indirect".
+func List() error {
+	p, err := loadProject()
+	if err != nil {
+		return err
+	}
+	config := p.Config
+	g := loadGraph(p)
+
+	pkgs := make([]string, 0, len(g.Versions))
+	for pkg := range g.Versions {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+
+	if len(pkgs) == 0 {
+		fmt.Println("No dependencies.")
+		return nil
+	}
+
+	for _, pkg := range pkgs {
+		line := pkg + " " + g.Versions[pkg]
+		var notes []string
+		if target, ok := config.Replace[pkg]; ok {
+			line += " => " + target
+		}
+		if !g.Direct[pkg] {
+			notes = append(notes, "indirect")
+		}
+		if p.packageDir(pkg, g.Versions[pkg]) == "" {
+			notes = append(notes, "not installed")
+		}
+		if len(notes) > 0 {
+			line += " // " + strings.Join(notes, ", ")
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// Why prints the shortest chain of requirements that pulls pkg into the
+// project.
+func Why(pkg string) error {
+	p, err := loadProject()
+	if err != nil {
+		return err
+	}
+	config := p.Config
+	g := loadGraph(p)
+
+	if _, ok := g.Versions[pkg]; !ok {
+		fmt.Printf("%s is not needed by %s\n", pkg, moduleName(config))
+		return nil
+	}
+
+	chain := []string{}
+	for dep := pkg; dep != ""; dep = g.Parent[dep] {
+		chain = append([]string{dep + " " + g.Versions[dep]}, chain...)
+	}
+
+	fmt.Println(moduleName(config))
+	for i, link := range chain {
+		fmt.Printf("%s%s\n", strings.Repeat("  ", i+1), link)
+	}
+	return nil
+}
+
+// Remove drops pkg from noxy.mod and deletes it, along with any transitive
+// dependencies nothing else needs, from noxy_libs. Cached copies are kept
+// for other projects.
+func Remove(pkg string) error {
+	p, err := loadProject()
+	if err != nil {
+		return err
+	}
+	config := p.Config
+	if _, ok := config.Require[pkg]; !ok {
+		return fmt.Errorf("%s is not a direct dependency", pkg)
+	}
+
+	before := loadGraph(p)
+	delete(config.Require, pkg)
+	after := loadGraph(p)
+
+	if err := config.Save("noxy.mod"); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s from noxy.mod\n", pkg)
+
+	for dep := range before.Versions {
+		if _, still := after.Versions[dep]; still {
+			continue
+		}
+		dir := filepath.Join(NoxyLibsDir, filepath.FromSlash(LibPath(dep)))
+		if !dirExists(dir) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("Warning: failed to remove %s: %s\n", dir, err)
+			continue
+		}
+		fmt.Printf("Pruned %s\n", dir)
+		pruneEmptyParents(filepath.Dir(dir))
+	}
+	if p.Vendored {
+		if err := pruneVendorList(after.Versions); err != nil {
+			fmt.Printf("Warning: failed to update %s: %s\n", VendorListName, err)
+		}
+	}
+	return nil
+}
+
+// pruneEmptyParents removes now-empty directories up to noxy_libs.
+func pruneEmptyParents(dir string) {
+	for dir != NoxyLibsDir && dir != "." && strings.HasPrefix(dir, NoxyLibsDir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func moduleName(config *ModuleConfig) string {
+	if config.Module != "" {
+		return config.Module
+	}
+	return "noxy.mod"
+}
@@ -0,0 +1,67 @@
+package pkgmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDependencyGraphAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	writeFile(t, "noxy.mod", "module app\n\nrequire github.com/a/web v1.0.0\nrequire github.com/d/log v0.3.0\n")
+	writeFile(t, "noxy_libs/github_com/a/web/noxy.mod", "require github.com/b/json v2.0.0\n")
+	writeFile(t, "noxy_libs/github_com/b/json/noxy.mod", "require github.com/c/util v1.1.0\n")
+	writeFile(t, "noxy_libs/github_com/c/util/util.nx", "")
+	writeFile(t, "noxy_libs/github_com/d/log/noxy.mod", "require github.com/c/util v1.1.0\n")
+
+	p, err := loadProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := loadGraph(p)
+
+	if len(g.Versions) != 4 {
+		t.Fatalf("expected 4 packages in graph, got %v", g.Versions)
+	}
+	if !g.Direct["github.com/a/web"] || g.Direct["github.com/b/json"] {
+		t.Errorf("direct/indirect classification is wrong: %v", g.Direct)
+	}
+	if g.Parent["github.com/b/json"] != "github.com/a/web" {
+		t.Errorf("expected b/json to be pulled in by a/web, got %q", g.Parent["github.com/b/json"])
+	}
+
+	if err := Remove("github.com/a/web"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	if dirExists(filepath.Join("noxy_libs", "github_com", "a")) || dirExists(filepath.Join("noxy_libs", "github_com", "b")) {
+		t.Errorf("expected a/web and b/json to be pruned")
+	}
+	if !dirExists(filepath.Join("noxy_libs", "github_com", "c", "util")) {
+		t.Errorf("c/util is still needed by d/log and must be kept")
+	}
+
+	config, _ := ParseModFile("noxy.mod")
+	if _, ok := config.Require["github.com/a/web"]; ok {
+		t.Errorf("a/web should be removed from noxy.mod")
+	}
+	if config.Require["github.com/d/log"] != "v0.3.0" {
+		t.Errorf("d/log should stay in noxy.mod")
+	}
+}
@@ -11,42 +11,132 @@ import (
 
 const NoxyLibsDir = "noxy_libs"
 
+// installer carries the state shared by one Get invocation across the
+// dependency tree.
+type installer struct {
+	visited   map[string]bool
+	sums      *SumFile
+	replace   map[string]string // from the root noxy.mod
+	vendor    bool              // copy every package into noxy_libs
+	installed map[string]string // pkg -> version put into noxy_libs
+}
+
+func newInstaller(sums *SumFile, replace map[string]string) *installer {
+	if replace == nil {
+		replace = make(map[string]string)
+	}
+	return &installer{
+		visited:   make(map[string]bool),
+		sums:      sums,
+		replace:   replace,
+		vendor:    IsVendored("."),
+		installed: make(map[string]string),
+	}
+}
+
+// finish records what the installer did in noxy.sum and, for vendored
+// projects, in the vendor list.
+func (inst *installer) finish() error {
+	if inst.vendor {
+		if err := inst.saveVendorList(); err != nil {
+			return fmt.Errorf("failed to write %s: %w", VendorListName, err)
+		}
+	}
+	if _, err := os.Stat(SumFileName); os.IsNotExist(err) && len(inst.sums.Sums) == 0 {
+		return nil
+	}
+	return inst.sums.Save(SumFileName)
+}
+
 func Get(pkgArg string) error {
-	visited := make(map[string]bool)
-	return downloadPackage(pkgArg, true, visited)
+	sums, err := ParseSumFile(SumFileName)
+	if err != nil {
+		return err
+	}
+
+	var replace map[string]string
+	if _, err := os.Stat("noxy.mod"); err == nil {
+		config, err := ParseModFile("noxy.mod")
+		if err != nil {
+			return err
+		}
+		replace = config.Replace
+	}
+	inst := newInstaller(sums, replace)
+
+	if err := inst.downloadPackage(pkgArg, true); err != nil {
+		return err
+	}
+	return inst.finish()
+}
+
+// LibPath converts a package path into its directory under noxy_libs,
+// e.g. github.com/user/repo -> github_com/user/repo.
+func LibPath(repoURL string) string {
+	parts := strings.Split(repoURL, "/")
+	if len(parts) > 0 {
+		parts[0] = strings.ReplaceAll(parts[0], ".", "_")
+	}
+	return strings.Join(parts, "/")
 }
 
-func downloadPackage(pkgArg string, isRoot bool, visited map[string]bool) error {
-	// 1. Parse argument: github.com/user/repo@version
-	parts := strings.Split(pkgArg, "@")
-	repoURL := parts[0] // e.g., github.com/user/repo
-	version := "HEAD"
-	if len(parts) > 1 {
-		version = parts[1]
+// ImportPath returns the dotted name used to import a package with `use`:
+// its LibPath with every character that cannot appear in an identifier
+// replaced by '_', e.g. github.com/user/noxy-json -> github_com.user.noxy_json.
+func ImportPath(repoURL string) string {
+	parts := strings.Split(LibPath(repoURL), "/")
+	for i, part := range parts {
+		parts[i] = strings.Map(func(r rune) rune {
+			if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return '_'
+		}, part)
 	}
+	return strings.Join(parts, ".")
+}
+
+// IsLocalReplacement reports whether a replace target is a filesystem path
+// rather than another package.
+func IsLocalReplacement(target string) bool {
+	return strings.HasPrefix(target, "./") || strings.HasPrefix(target, "../") ||
+		strings.HasPrefix(target, ".\\") || strings.HasPrefix(target, "..\\") ||
+		filepath.IsAbs(target)
+}
+
+func (inst *installer) downloadPackage(pkgArg string, isRoot bool) error {
+	// 1. Parse argument: github.com/user/repo@version (or an SSH URL)
+	repoURL, gitURL, version := parsePackageArg(pkgArg)
 
 	// Avoid cycles
 	cacheKey := repoURL + "@" + version
-	if visited[cacheKey] {
+	if inst.visited[cacheKey] {
 		return nil
 	}
-	visited[cacheKey] = true
+	inst.visited[cacheKey] = true
 
-	// Ensure we have a valid URL (assume https for now if no scheme)
-	gitURL := repoURL
-	if !strings.HasPrefix(gitURL, "http") && !strings.HasPrefix(gitURL, "git@") {
-		gitURL = "https://" + gitURL
+	// Replacements keep the original install location (imports do not
+	// change) but take the source from elsewhere.
+	sourceURL, sourceVersion := repoURL, version
+	if target, ok := inst.replace[repoURL]; ok {
+		if IsLocalReplacement(target) {
+			return inst.useLocalReplacement(repoURL, version, target, isRoot)
+		}
+		var v string
+		sourceURL, _, v = parsePackageArg(target)
+		if v != "HEAD" {
+			sourceVersion = v
+		}
+		fmt.Printf("Replacing %s with %s@%s\n", repoURL, sourceURL, sourceVersion)
+	}
+
+	if sourceURL != repoURL {
+		_, gitURL, _ = parsePackageArg(sourceURL)
 	}
 
 	// 2. Prepare target directory
 	// Store in noxy_libs/<domain>/<user>/<repo>
-	// Replace dots in domain with underscores (e.g. github.com -> github_com)
-	parts = strings.Split(repoURL, "/")
-	if len(parts) > 0 {
-		parts[0] = strings.ReplaceAll(parts[0], ".", "_")
-	}
-	localPath := strings.Join(parts, "/")
-	targetDir := filepath.Join(NoxyLibsDir, filepath.FromSlash(localPath))
+	targetDir := filepath.Join(NoxyLibsDir, filepath.FromSlash(LibPath(repoURL)))
 
 	if isRoot {
 		fmt.Printf("Getting package %s...\n", pkgArg)
@@ -54,32 +144,99 @@ func downloadPackage(pkgArg string, isRoot bool, visited map[string]bool) error
 		fmt.Printf("Getting dependency %s...\n", pkgArg)
 	}
 
-	// Check if already exists
-	if _, err := os.Stat(targetDir); !os.IsNotExist(err) {
-		// fmt.Printf("Updating existing package in %s...\n", targetDir)
-		// It exists, try to pull
-		if err := gitPull(targetDir); err != nil {
-			fmt.Printf("Warning: failed to update package %s: %s\n", repoURL, err)
-		}
-	} else {
-		// Clone it
-		// fmt.Printf("Cloning into %s...\n", targetDir)
-		if err := gitClone(gitURL, targetDir); err != nil {
-			return fmt.Errorf("failed to clone package: %w", err)
+	// 3. Fetch into a staging directory so a failed download or checksum
+	// mismatch never clobbers an existing install.
+	stagingDir := targetDir + ".tmp"
+	os.RemoveAll(stagingDir)
+	defer func() {
+		os.RemoveAll(stagingDir)
+		// Staging creates noxy_libs even for packages that end up only
+		// in the cache; don't leave it behind empty
+		pruneEmptyParents(filepath.Dir(stagingDir))
+		os.Remove(NoxyLibsDir)
+	}()
+
+	// Pinned versions are immutable, so once noxy.sum records their hash
+	// they can be served from the user-level cache. HEAD always goes to
+	// the network.
+	pinned := sourceVersion != "HEAD"
+	var want string
+	var known bool
+	if pinned {
+		want, known = inst.sums.Get(sourceURL, sourceVersion)
+	}
+	srcDir := ""
+	if known {
+		if cached, ok := CachedPackage(sourceURL, sourceVersion, want); ok {
+			srcDir = cached
+		} else if dirExists(cached) {
+			// A corrupted cache entry: drop it and fetch again
+			fmt.Printf("Warning: cached %s@%s does not match %s, downloading again\n", sourceURL, sourceVersion, SumFileName)
+			os.RemoveAll(cached)
 		}
 	}
 
-	// 3. Checkout version
-	if version != "HEAD" {
-		// fmt.Printf("Checking out version %s...\n", version)
-		if err := gitCheckout(targetDir, version); err != nil {
-			return fmt.Errorf("failed to checkout version %s: %w", version, err)
+	if srcDir == "" {
+		// Offline, an unpinned package can only be the copy already installed
+		if Offline && !pinned && dirExists(targetDir) {
+			fmt.Printf("Offline: keeping installed %s\n", repoURL)
+			inst.downloadDependencies(targetDir)
+			if isRoot {
+				fmt.Println("Done.")
+			}
+			return nil
+		}
+
+		if err := fetchPackage(sourceURL, gitURL, sourceVersion, stagingDir); err != nil {
+			return err
+		}
+
+		// 4. Verify against noxy.sum. HEAD moves, so only pinned versions
+		// are recorded.
+		hash, err := HashDir(stagingDir)
+		if err != nil {
+			return fmt.Errorf("failed to hash package: %w", err)
+		}
+		if pinned {
+			if known && want != hash {
+				return fmt.Errorf("checksum mismatch for %s@%s:\n\t%s: %s\n\tdownloaded: %s", sourceURL, sourceVersion, SumFileName, want, hash)
+			}
+			inst.sums.Set(sourceURL, sourceVersion, hash)
+			if cached, ok := storeInCache(sourceURL, sourceVersion, hash, stagingDir); ok {
+				srcDir = cached
+			}
 		}
 	}
 
-	// 4. Remove .git directory to avoid nested repo issues
-	if err := os.RemoveAll(filepath.Join(targetDir, ".git")); err != nil {
-		fmt.Printf("Warning: failed to remove .git directory: %s\n", err)
+	// Projects that are not vendored import pinned packages straight from
+	// the cache. noxy_libs holds the rest: vendored packages, HEAD
+	// checkouts, and packages with a build step, whose artifacts must not
+	// end up in the shared cache.
+	pkgDir := srcDir
+	if srcDir == "" || inst.vendor || hasBuildStep(srcDir) {
+		if srcDir != "" {
+			if err := copyDir(srcDir, stagingDir); err != nil {
+				return fmt.Errorf("failed to copy package from cache: %w", err)
+			}
+		}
+		if err := os.RemoveAll(targetDir); err != nil {
+			return fmt.Errorf("failed to remove old package: %w", err)
+		}
+		if err := os.Rename(stagingDir, targetDir); err != nil {
+			return fmt.Errorf("failed to install package: %w", err)
+		}
+
+		// Built artifacts only ever live in noxy_libs; the cache keeps sources.
+		if err := runBuild(repoURL, targetDir); err != nil {
+			return err
+		}
+		inst.installed[repoURL] = version
+		pkgDir = targetDir
+	} else if dirExists(targetDir) {
+		// An older copy in noxy_libs would shadow the cached one
+		if err := os.RemoveAll(targetDir); err != nil {
+			return fmt.Errorf("failed to remove old package: %w", err)
+		}
 	}
 
 	// 5. Update noxy.mod (ONLY if ROOT)
@@ -90,46 +247,124 @@ func downloadPackage(pkgArg string, isRoot bool, visited map[string]bool) error
 	}
 
 	// 6. Recursively download dependencies from the downloaded package's noxy.mod
-	pkgModPath := filepath.Join(targetDir, "noxy.mod")
-	if _, err := os.Stat(pkgModPath); err == nil {
-		// Parse it
-		config, err := ParseModFile(pkgModPath)
-		if err != nil {
-			fmt.Printf("Warning: failed to parse %s: %s\n", pkgModPath, err)
-		} else {
-			for depPkg, depVer := range config.Require {
-				depArg := depPkg
-				if depVer != "" {
-					depArg = depPkg + "@" + depVer
-				}
-				if err := downloadPackage(depArg, false, visited); err != nil {
-					fmt.Printf("Warning: failed to download dependency %s: %s\n", depArg, err)
-				}
-			}
+	inst.downloadDependencies(pkgDir)
+
+	if isRoot {
+		fmt.Println("Done.")
+	}
+	return nil
+}
+
+// useLocalReplacement handles a package replaced by a local directory:
+// nothing is downloaded, the VM resolves imports to the directory directly.
+func (inst *installer) useLocalReplacement(repoURL, version, dir string, isRoot bool) error {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("replacement for %s: %s is not a directory", repoURL, dir)
+	}
+	fmt.Printf("Using local replacement %s => %s\n", repoURL, dir)
+
+	if isRoot {
+		if err := updateModFile(repoURL, version); err != nil {
+			fmt.Printf("Warning: failed to update noxy.mod: %s\n", err)
 		}
 	}
 
+	inst.downloadDependencies(dir)
+
 	if isRoot {
 		fmt.Println("Done.")
 	}
 	return nil
 }
 
-func gitClone(url, dir string) error {
-	cmd := exec.Command("git", "clone", url, dir)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+func (inst *installer) downloadDependencies(pkgDir string) {
+	pkgModPath := filepath.Join(pkgDir, "noxy.mod")
+	if _, err := os.Stat(pkgModPath); err != nil {
+		return
+	}
+
+	config, err := ParseModFile(pkgModPath)
+	if err != nil {
+		fmt.Printf("Warning: failed to parse %s: %s\n", pkgModPath, err)
+		return
+	}
+	for depPkg, depVer := range config.Require {
+		depArg := depPkg
+		if depVer != "" {
+			depArg = depPkg + "@" + depVer
+		}
+		if err := inst.downloadPackage(depArg, false); err != nil {
+			fmt.Printf("Warning: failed to download dependency %s: %s\n", depArg, err)
+		}
+	}
 }
 
-func gitPull(dir string) error {
-	// If .git is gone, we can't pull.
-	// Check if .git exists
-	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
-		return nil
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// fetchPackage places the package source in dir. Sources are tried in
+// NOXY_PROXY order; "direct" means the host's HTTPS archive, then git.
+func fetchPackage(repoURL, gitURL, version, dir string) error {
+	if Offline {
+		return fmt.Errorf("%s@%s is not in the package cache (offline mode)", repoURL, version)
+	}
+
+	var lastErr error
+	for _, source := range proxyList() {
+		if source == "direct" {
+			return fetchDirect(repoURL, gitURL, version, dir)
+		}
+		err := fetchFromMirror(source, repoURL, version, dir)
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("Warning: mirror %s failed (%s)\n", source, err)
+		os.RemoveAll(dir)
+		lastErr = err
+	}
+	return fmt.Errorf("no source could provide %s@%s: %w", repoURL, version, lastErr)
+}
+
+// fetchDirect downloads from the package's host, preferring the HTTPS
+// archive and falling back to git.
+func fetchDirect(repoURL, gitURL, version, dir string) error {
+	// SSH clones are an explicit request for git
+	if _, ok := archiveURL(repoURL, version); ok && !strings.HasPrefix(gitURL, "git@") {
+		err := fetchArchive(repoURL, version, dir)
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("Warning: archive download failed (%s), falling back to git\n", err)
+		os.RemoveAll(dir)
 	}
 
-	cmd := exec.Command("git", "-C", dir, "pull")
+	if err := gitClone(repoURL, gitURL, dir); err != nil {
+		return fmt.Errorf("failed to clone package: %w", err)
+	}
+
+	if version != "HEAD" {
+		if err := gitCheckout(dir, version); err != nil {
+			return fmt.Errorf("failed to checkout version %s: %w", version, err)
+		}
+	}
+
+	// Remove .git directory to avoid nested repo issues
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		fmt.Printf("Warning: failed to remove .git directory: %s\n", err)
+	}
+	return nil
+}
+
+func gitClone(repoURL, url, dir string) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git is not installed")
+	}
+	cmd := exec.Command("git", "clone", url, dir)
+	// Fail instead of prompting for credentials (CI has no terminal)
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), gitAuthEnv(repoURL)...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -8,16 +8,34 @@ import (
 
 type ModuleConfig struct {
 	Module      string
+	Description string // one-line summary shown by --search
 	NoxyVersion string
 	Require     map[string]string
+	Plugins     map[string]string // plugin name -> remote endpoint URL
+	Replace     map[string]string // package -> local path or package[@version]
+	Build       map[string]string // GOOS ("" for any) -> post-install build command
+	Aliases     map[string]string // import name -> package
 }
 
 func NewModuleConfig() *ModuleConfig {
 	return &ModuleConfig{
 		Require: make(map[string]string),
+		Plugins: make(map[string]string),
+		Replace: make(map[string]string),
+		Build:   make(map[string]string),
+		Aliases: make(map[string]string),
 	}
 }
 
+// BuildCommand returns the build command for goos: build.<goos> if given,
+// otherwise the plain build directive.
+func (c *ModuleConfig) BuildCommand(goos string) string {
+	if cmd, ok := c.Build[goos]; ok {
+		return cmd
+	}
+	return c.Build[""]
+}
+
 func ParseModFile(path string) (*ModuleConfig, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -38,11 +56,22 @@ func ParseModFile(path string) (*ModuleConfig, error) {
 			continue
 		}
 
+		// build <command...> / build.<goos> <command...>
+		if parts[0] == "build" || strings.HasPrefix(parts[0], "build.") {
+			if len(parts) >= 2 {
+				goos := strings.TrimPrefix(strings.TrimPrefix(parts[0], "build"), ".")
+				config.Build[goos] = strings.TrimSpace(strings.TrimPrefix(line, parts[0]))
+			}
+			continue
+		}
+
 		switch parts[0] {
 		case "module":
 			if len(parts) >= 2 {
 				config.Module = parts[1]
 			}
+		case "description":
+			config.Description = strings.TrimSpace(strings.TrimPrefix(line, "description"))
 		case "noxy":
 			if len(parts) >= 2 {
 				config.NoxyVersion = parts[1]
@@ -52,6 +81,21 @@ func ParseModFile(path string) (*ModuleConfig, error) {
 				// require <pkg> <version>
 				config.Require[parts[1]] = parts[2]
 			}
+		case "replace":
+			// replace <pkg> => <path | pkg[@version]>
+			if len(parts) >= 4 && parts[2] == "=>" {
+				config.Replace[parts[1]] = parts[3]
+			}
+		case "alias":
+			if len(parts) >= 3 {
+				// alias <name> <pkg>
+				config.Aliases[parts[1]] = parts[2]
+			}
+		case "plugin":
+			if len(parts) >= 3 {
+				// plugin <name> <url>
+				config.Plugins[parts[1]] = parts[2]
+			}
 		}
 	}
 
@@ -65,13 +109,57 @@ func (c *ModuleConfig) Save(path string) error {
 		sb.WriteString(fmt.Sprintf("module %s\n\n", c.Module))
 	}
 
+	if c.Description != "" {
+		sb.WriteString(fmt.Sprintf("description %s\n\n", c.Description))
+	}
+
 	if c.NoxyVersion != "" {
 		sb.WriteString(fmt.Sprintf("noxy %s\n\n", c.NoxyVersion))
 	}
 
 	if len(c.Require) > 0 {
-		for pkg, ver := range c.Require {
-			sb.WriteString(fmt.Sprintf("require %s %s\n", pkg, ver))
+		for _, pkg := range sortedKeys(c.Require) {
+			sb.WriteString(fmt.Sprintf("require %s %s\n", pkg, c.Require[pkg]))
+		}
+	}
+
+	if len(c.Replace) > 0 {
+		if len(c.Require) > 0 {
+			sb.WriteString("\n")
+		}
+		for _, pkg := range sortedKeys(c.Replace) {
+			sb.WriteString(fmt.Sprintf("replace %s => %s\n", pkg, c.Replace[pkg]))
+		}
+	}
+
+	if len(c.Aliases) > 0 {
+		if len(c.Require) > 0 || len(c.Replace) > 0 {
+			sb.WriteString("\n")
+		}
+		for _, name := range sortedKeys(c.Aliases) {
+			sb.WriteString(fmt.Sprintf("alias %s %s\n", name, c.Aliases[name]))
+		}
+	}
+
+	if len(c.Plugins) > 0 {
+		if len(c.Require) > 0 || len(c.Replace) > 0 || len(c.Aliases) > 0 {
+			sb.WriteString("\n")
+		}
+		for _, name := range sortedKeys(c.Plugins) {
+			sb.WriteString(fmt.Sprintf("plugin %s %s\n", name, c.Plugins[name]))
+		}
+	}
+
+	if len(c.Build) > 0 {
+		if len(c.Require) > 0 || len(c.Replace) > 0 || len(c.Aliases) > 0 || len(c.Plugins) > 0 {
+			sb.WriteString("\n")
+		}
+		for _, goos := range sortedKeys(c.Build) {
+			directive := "build"
+			if goos != "" {
+				directive += "." + goos
+			}
+			sb.WriteString(fmt.Sprintf("%s %s\n", directive, c.Build[goos]))
 		}
 	}
 
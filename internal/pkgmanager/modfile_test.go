@@ -62,3 +62,117 @@ require github.com/user/repo v1.0.0
 		t.Errorf("Expected saved content to contain 'noxy v1.3.0', got:\n%s", savedContent)
 	}
 }
+
+func TestModFilePluginsAndReplace(t *testing.T) {
+	content := `
+module noxy-test
+
+require github.com/user/repo v1.0.0
+
+replace github.com/user/repo => ../repo
+replace github.com/user/other => github.com/fork/other@v2.0.0
+
+plugin dynamodb https://plugins.example.com/dynamodb
+
+alias repo github.com/user/repo
+`
+	tmpfile, err := ioutil.TempFile("", "noxy.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseModFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("ParseModFile failed: %v", err)
+	}
+
+	if config.Plugins["dynamodb"] != "https://plugins.example.com/dynamodb" {
+		t.Errorf("Expected plugin dynamodb url, got %q", config.Plugins["dynamodb"])
+	}
+
+	// Plugins must survive a save/parse round trip
+	if err := config.Save(tmpfile.Name()); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	config, err = ParseModFile(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("ParseModFile failed: %v", err)
+	}
+	if config.Plugins["dynamodb"] != "https://plugins.example.com/dynamodb" {
+		t.Errorf("Expected plugin to be saved, got %q", config.Plugins["dynamodb"])
+	}
+	if config.Require["github.com/user/repo"] != "v1.0.0" {
+		t.Errorf("Expected require to be kept, got %q", config.Require["github.com/user/repo"])
+	}
+	if config.Replace["github.com/user/repo"] != "../repo" {
+		t.Errorf("Expected local replace, got %q", config.Replace["github.com/user/repo"])
+	}
+	if config.Replace["github.com/user/other"] != "github.com/fork/other@v2.0.0" {
+		t.Errorf("Expected package replace, got %q", config.Replace["github.com/user/other"])
+	}
+	if config.Aliases["repo"] != "github.com/user/repo" {
+		t.Errorf("Expected alias to be saved, got %q", config.Aliases["repo"])
+	}
+	if !IsLocalReplacement("../repo") || IsLocalReplacement("github.com/fork/other@v2.0.0") {
+		t.Errorf("IsLocalReplacement misclassified replace targets")
+	}
+}
+
+// saveModFile saves config to a temporary file and returns its content.
+func saveModFile(t *testing.T, config *ModuleConfig) string {
+	t.Helper()
+	path := t.TempDir() + "/noxy.mod"
+	if err := config.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestModFileSavesPluginsInOrder(t *testing.T) {
+	config := NewModuleConfig()
+	for _, name := range []string{"sqs", "dynamodb", "s3", "auth", "mail"} {
+		config.Plugins[name] = "https://plugins.example.com/" + name
+	}
+	want := "plugin auth https://plugins.example.com/auth\n" +
+		"plugin dynamodb https://plugins.example.com/dynamodb\n" +
+		"plugin mail https://plugins.example.com/mail\n" +
+		"plugin s3 https://plugins.example.com/s3\n" +
+		"plugin sqs https://plugins.example.com/sqs\n"
+	// Map order varies between runs, so save several times
+	for i := 0; i < 10; i++ {
+		if got := saveModFile(t, config); got != want {
+			t.Fatalf("saved:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
+
+func TestModFileSavesReplacesInOrder(t *testing.T) {
+	config := NewModuleConfig()
+	config.Require["github.com/user/a"] = "v1.0.0"
+	config.Replace["github.com/user/zeta"] = "../zeta"
+	config.Replace["github.com/user/alpha"] = "github.com/fork/alpha@v1.1.0"
+	config.Replace["github.com/user/mid"] = "/src/mid"
+	config.Replace["github.com/user/beta"] = "./beta"
+	want := "require github.com/user/a v1.0.0\n\n" +
+		"replace github.com/user/alpha => github.com/fork/alpha@v1.1.0\n" +
+		"replace github.com/user/beta => ./beta\n" +
+		"replace github.com/user/mid => /src/mid\n" +
+		"replace github.com/user/zeta => ../zeta\n"
+	for i := 0; i < 10; i++ {
+		if got := saveModFile(t, config); got != want {
+			t.Fatalf("saved:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
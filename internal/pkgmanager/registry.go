@@ -0,0 +1,335 @@
+package pkgmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"noxy-vm/internal/semver"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The package index is a JSON document listing published packages. It is
+// either a static file (e.g. committed to a shared repository) or served
+// by a registry: GET <url> returns the index, POST <url> with an IndexEntry
+// publishes a version.
+
+type IndexEntry struct {
+	Package     string   `json:"package"`
+	Description string   `json:"description,omitempty"`
+	Versions    []string `json:"versions"`
+	Latest      string   `json:"latest"`
+	Updated     string   `json:"updated,omitempty"`
+}
+
+type Index struct {
+	Packages []IndexEntry `json:"packages"`
+}
+
+// registryLocation returns NOXY_REGISTRY, a URL or a path to an index file.
+func registryLocation() (string, error) {
+	loc := os.Getenv("NOXY_REGISTRY")
+	if loc == "" {
+		return "", fmt.Errorf("NOXY_REGISTRY is not set (use a registry URL or the path of an index.json file)")
+	}
+	return loc, nil
+}
+
+func isURL(loc string) bool {
+	return strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://")
+}
+
+// loadIndex reads the index. A missing local file is an empty index, so the
+// first publish creates it.
+func loadIndex(loc string) (*Index, error) {
+	var data []byte
+	if isURL(loc) {
+		if Offline {
+			return nil, fmt.Errorf("cannot reach registry %s in offline mode", loc)
+		}
+		resp, err := HTTPClient.Get(loc)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", loc, resp.Status)
+		}
+		if data, err = ioutil.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		data, err = ioutil.ReadFile(loc)
+		if os.IsNotExist(err) {
+			return &Index{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("invalid package index %s: %w", loc, err)
+	}
+	return &idx, nil
+}
+
+func (idx *Index) Save(path string) error {
+	sort.Slice(idx.Packages, func(i, j int) bool { return idx.Packages[i].Package < idx.Packages[j].Package })
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Add merges a published version into the index, keeping versions sorted
+// and Latest pointing at the newest release.
+func (idx *Index) Add(e IndexEntry) {
+	for i := range idx.Packages {
+		if idx.Packages[i].Package != e.Package {
+			continue
+		}
+		old := &idx.Packages[i]
+		for _, v := range e.Versions {
+			if !containsString(old.Versions, v) {
+				old.Versions = append(old.Versions, v)
+			}
+		}
+		if e.Description != "" {
+			old.Description = e.Description
+		}
+		old.Updated = e.Updated
+		old.normalize()
+		return
+	}
+	e.normalize()
+	idx.Packages = append(idx.Packages, e)
+}
+
+func (e *IndexEntry) normalize() {
+	sort.Slice(e.Versions, func(i, j int) bool {
+		a, _ := parseSemver(e.Versions[i])
+		b, _ := parseSemver(e.Versions[j])
+		return semver.Compare(a, b) < 0
+	})
+	e.Latest = ""
+	for i := len(e.Versions) - 1; i >= 0; i-- {
+		if sv, _ := parseSemver(e.Versions[i]); sv.Pre == "" {
+			e.Latest = e.Versions[i]
+			break
+		}
+	}
+	if e.Latest == "" && len(e.Versions) > 0 {
+		e.Latest = e.Versions[len(e.Versions)-1]
+	}
+}
+
+// Search returns the packages whose path or description contains every
+// word of query (case-insensitive).
+func (idx *Index) Search(query string) []IndexEntry {
+	words := strings.Fields(strings.ToLower(query))
+	var found []IndexEntry
+	for _, e := range idx.Packages {
+		text := strings.ToLower(e.Package + " " + e.Description)
+		match := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				match = false
+				break
+			}
+		}
+		if match {
+			found = append(found, e)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Package < found[j].Package })
+	return found
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Search prints the index entries matching query.
+func Search(query string) error {
+	loc, err := registryLocation()
+	if err != nil {
+		return err
+	}
+	idx, err := loadIndex(loc)
+	if err != nil {
+		return err
+	}
+
+	found := idx.Search(query)
+	if len(found) == 0 {
+		fmt.Printf("No packages found for %q\n", query)
+		return nil
+	}
+	for _, e := range found {
+		fmt.Printf("%s %s\n", e.Package, e.Latest)
+		if e.Description != "" {
+			fmt.Printf("    %s\n", e.Description)
+		}
+	}
+	return nil
+}
+
+// packageFromRemote converts a git remote URL into a package path, e.g.
+// https://github.com/user/repo.git -> github.com/user/repo.
+func packageFromRemote(remote string) string {
+	if strings.HasPrefix(remote, "git@") || strings.HasPrefix(remote, "ssh://") {
+		repoURL, _, _ := parsePackageArg(remote)
+		return repoURL
+	}
+	rest := remote
+	if i := strings.Index(rest, "://"); i >= 0 {
+		rest = rest[i+3:]
+	}
+	// Drop credentials embedded in the URL
+	if i := strings.Index(rest, "@"); i >= 0 && i < strings.Index(rest, "/") {
+		rest = rest[i+1:]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(rest, "/"), ".git")
+}
+
+func gitOutput(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// validateForPublish checks that noxy.mod describes a package others can
+// install: it must name the module and pin every requirement.
+func validateForPublish(config *ModuleConfig) error {
+	if config.Module == "" {
+		return fmt.Errorf("noxy.mod has no module name")
+	}
+	for _, pkg := range sortedKeys(config.Require) {
+		if ver := config.Require[pkg]; ver == "" || ver == "HEAD" {
+			return fmt.Errorf("requirement %s is not pinned to a version", pkg)
+		}
+	}
+	for _, pkg := range sortedKeys(config.Replace) {
+		if IsLocalReplacement(config.Replace[pkg]) {
+			fmt.Printf("Warning: replace %s => %s only applies to this project, not to users of the package\n", pkg, config.Replace[pkg])
+		}
+	}
+	return nil
+}
+
+// Publish validates noxy.mod, tags the current commit with version, pushes
+// the tag and records the release in the package index.
+func Publish(version string) error {
+	if Offline {
+		return fmt.Errorf("cannot publish in offline mode")
+	}
+	if _, ok := parseSemver(version); !ok {
+		return fmt.Errorf("invalid version %q (expected vMAJOR.MINOR.PATCH)", version)
+	}
+	loc, err := registryLocation()
+	if err != nil {
+		return err
+	}
+
+	config, err := loadProjectModFile()
+	if err != nil {
+		return err
+	}
+	if err := validateForPublish(config); err != nil {
+		return err
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git is not installed")
+	}
+	remote, err := gitOutput("remote", "get-url", "origin")
+	if err != nil || remote == "" {
+		return fmt.Errorf("no git remote 'origin' configured")
+	}
+	pkg := packageFromRemote(remote)
+	if host := repoHost(pkg); !strings.Contains(host, ".") {
+		return fmt.Errorf("cannot derive a package path from remote %s", remote)
+	}
+
+	if status, err := gitOutput("status", "--porcelain"); err != nil {
+		return fmt.Errorf("failed to read git status: %w", err)
+	} else if status != "" {
+		return fmt.Errorf("working tree has uncommitted changes")
+	}
+	if _, err := gitOutput("rev-parse", "-q", "--verify", "refs/tags/"+version); err == nil {
+		return fmt.Errorf("tag %s already exists", version)
+	}
+
+	fmt.Printf("Publishing %s@%s...\n", pkg, version)
+	if out, err := exec.Command("git", "tag", version).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to tag %s: %s", version, strings.TrimSpace(string(out)))
+	}
+	push := exec.Command("git", "push", "origin", version)
+	push.Stdout = os.Stdout
+	push.Stderr = os.Stderr
+	if err := push.Run(); err != nil {
+		return fmt.Errorf("failed to push tag %s: %w", version, err)
+	}
+
+	entry := IndexEntry{
+		Package:     pkg,
+		Description: config.Description,
+		Versions:    []string{version},
+		Updated:     time.Now().UTC().Format(time.RFC3339),
+	}
+	if isURL(loc) {
+		err = postIndexEntry(loc, entry)
+	} else {
+		var idx *Index
+		if idx, err = loadIndex(loc); err == nil {
+			idx.Add(entry)
+			err = idx.Save(loc)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("tag %s was pushed but the index was not updated: %w", version, err)
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+// postIndexEntry publishes to a registry, authenticating with
+// NOXY_REGISTRY_TOKEN when set.
+func postIndexEntry(url string, entry IndexEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("NOXY_REGISTRY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s: %s %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
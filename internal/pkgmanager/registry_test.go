@@ -0,0 +1,80 @@
+package pkgmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexAddSearchAndSave(t *testing.T) {
+	idx := &Index{}
+	idx.Add(IndexEntry{Package: "github.com/user/json", Description: "JSON helpers", Versions: []string{"v1.0.0"}})
+	idx.Add(IndexEntry{Package: "github.com/user/json", Versions: []string{"v1.2.0"}})
+	idx.Add(IndexEntry{Package: "github.com/user/json", Versions: []string{"v2.0.0-beta.1"}})
+	idx.Add(IndexEntry{Package: "github.com/other/http", Description: "HTTP client", Versions: []string{"v0.1.0"}})
+
+	found := idx.Search("JSON")
+	if len(found) != 1 || found[0].Latest != "v1.2.0" || found[0].Description != "JSON helpers" {
+		t.Fatalf("unexpected search result: %+v", found)
+	}
+	if len(found[0].Versions) != 3 || found[0].Versions[2] != "v2.0.0-beta.1" {
+		t.Errorf("versions not merged in order: %v", found[0].Versions)
+	}
+	if got := idx.Search("github client"); len(got) != 1 || got[0].Package != "github.com/other/http" {
+		t.Errorf("multi-word search failed: %+v", got)
+	}
+
+	dir, err := ioutil.TempDir("", "noxy-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "index.json")
+	if err := idx.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Packages) != 2 || loaded.Packages[0].Package != "github.com/other/http" {
+		t.Errorf("round trip failed: %+v", loaded.Packages)
+	}
+
+	empty, err := loadIndex(filepath.Join(dir, "missing.json"))
+	if err != nil || len(empty.Packages) != 0 {
+		t.Errorf("missing index should be empty, got %+v, %v", empty, err)
+	}
+}
+
+func TestPackageFromRemote(t *testing.T) {
+	cases := map[string]string{
+		"https://github.com/user/repo.git":   "github.com/user/repo",
+		"https://token@gitlab.com/group/lib": "gitlab.com/group/lib",
+		"git@github.com:user/repo.git":       "github.com/user/repo",
+		"ssh://git@github.com/user/repo.git": "github.com/user/repo",
+	}
+	for remote, want := range cases {
+		if got := packageFromRemote(remote); got != want {
+			t.Errorf("packageFromRemote(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func TestValidateForPublish(t *testing.T) {
+	config := NewModuleConfig()
+	if err := validateForPublish(config); err == nil {
+		t.Error("expected error for missing module name")
+	}
+	config.Module = "lib"
+	config.Require["github.com/user/dep"] = "HEAD"
+	if err := validateForPublish(config); err == nil {
+		t.Error("expected error for unpinned requirement")
+	}
+	config.Require["github.com/user/dep"] = "v1.0.0"
+	if err := validateForPublish(config); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package pkgmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+const SumFileName = "noxy.sum"
+
+// SumFile records the content hash of every installed package version:
+//
+//	<pkg> <version> <hash>
+type SumFile struct {
+	Sums map[string]string // "<pkg> <version>" -> hash
+}
+
+func NewSumFile() *SumFile {
+	return &SumFile{Sums: make(map[string]string)}
+}
+
+// ParseSumFile reads a noxy.sum file. A missing file yields an empty SumFile.
+func ParseSumFile(path string) (*SumFile, error) {
+	sf := NewSumFile()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed line", path, i+1)
+		}
+		sf.Sums[parts[0]+" "+parts[1]] = parts[2]
+	}
+	return sf, nil
+}
+
+func (sf *SumFile) Get(pkg, version string) (string, bool) {
+	h, ok := sf.Sums[pkg+" "+version]
+	return h, ok
+}
+
+func (sf *SumFile) Set(pkg, version, hash string) {
+	sf.Sums[pkg+" "+version] = hash
+}
+
+func (sf *SumFile) Save(path string) error {
+	keys := make([]string, 0, len(sf.Sums))
+	for k := range sf.Sums {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf("%s %s\n", k, sf.Sums[k]))
+	}
+	return ioutil.WriteFile(path, []byte(sb.String()), 0644)
+}
@@ -0,0 +1,216 @@
+package pkgmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"noxy-vm/internal/semver"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// parseSemver parses a release tag, which unlike the versions in
+// semver.Parse must start with "v".
+func parseSemver(tag string) (semver.Version, bool) {
+	if !strings.HasPrefix(tag, "v") {
+		return semver.Version{}, false
+	}
+	return semver.Parse(tag)
+}
+
+// latestAllowed picks the newest tag that is a compatible upgrade of
+// current: same major version, and no pre-releases unless current is one.
+// It returns "" if nothing newer is allowed.
+func latestAllowed(current string, tags []string) string {
+	cur, ok := parseSemver(current)
+	if !ok {
+		return ""
+	}
+	best, bestTag := cur, ""
+	for _, tag := range tags {
+		sv, ok := parseSemver(tag)
+		if !ok || sv.Major != cur.Major {
+			continue
+		}
+		if sv.Pre != "" && cur.Pre == "" {
+			continue
+		}
+		if semver.Compare(sv, best) > 0 {
+			best, bestTag = sv, tag
+		}
+	}
+	return bestTag
+}
+
+// listVersions returns the tags published for a package, asking NOXY_PROXY
+// mirrors (<mirror>/<pkg>/@v/list) before the package's host.
+func listVersions(repoURL string) ([]string, error) {
+	if Offline {
+		return nil, fmt.Errorf("cannot list versions of %s in offline mode", repoURL)
+	}
+
+	for _, source := range proxyList() {
+		if source == "direct" {
+			return listVersionsDirect(repoURL)
+		}
+		tags, err := listMirrorVersions(source, repoURL)
+		if err == nil {
+			return tags, nil
+		}
+		fmt.Printf("Warning: mirror %s failed (%s)\n", source, err)
+	}
+	return nil, fmt.Errorf("no source could list versions of %s", repoURL)
+}
+
+func listMirrorVersions(mirror, repoURL string) ([]string, error) {
+	url := fmt.Sprintf("%s/%s/@v/list", mirror, repoURL)
+	resp, err := HTTPClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+func listVersionsDirect(repoURL string) ([]string, error) {
+	if _, err := exec.LookPath("git"); err == nil {
+		_, gitURL, _ := parsePackageArg(repoURL)
+		cmd := exec.Command("git", "ls-remote", "--tags", "--refs", gitURL)
+		cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), gitAuthEnv(repoURL)...)
+		out, err := cmd.Output()
+		if err == nil {
+			var tags []string
+			for _, line := range strings.Split(string(out), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) == 2 {
+					tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+				}
+			}
+			return tags, nil
+		}
+	}
+
+	parts := strings.Split(repoURL, "/")
+	if len(parts) == 3 && parts[0] == "github.com" {
+		return listGitHubTags(parts[1], parts[2])
+	}
+	return nil, fmt.Errorf("cannot list versions of %s (git not available)", repoURL)
+}
+
+func listGitHubTags(user, repo string) ([]string, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/tags?per_page=100", user, repo)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorizeArchiveRequest(req, "github.com/"+user+"/"+repo)
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	var body []struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	tags := make([]string, len(body))
+	for i, t := range body {
+		tags[i] = t.Name
+	}
+	return tags, nil
+}
+
+// Update upgrades pkg (or every direct dependency if pkg is "") to the
+// latest compatible version, updates noxy.mod and noxy.sum, and prints the
+// version changes.
+func Update(pkg string) error {
+	config, err := loadProjectModFile()
+	if err != nil {
+		return err
+	}
+
+	targets := sortedKeys(config.Require)
+	if pkg != "" {
+		if _, ok := config.Require[pkg]; !ok {
+			return fmt.Errorf("%s is not a direct dependency", pkg)
+		}
+		targets = []string{pkg}
+	}
+
+	sums, err := ParseSumFile(SumFileName)
+	if err != nil {
+		return err
+	}
+	inst := newInstaller(sums, config.Replace)
+
+	var changes []string
+	failed := 0
+	for _, p := range targets {
+		current := config.Require[p]
+		if target, ok := config.Replace[p]; ok && IsLocalReplacement(target) {
+			fmt.Printf("Skipping %s (replaced by %s)\n", p, target)
+			continue
+		}
+		if _, ok := parseSemver(current); !ok {
+			fmt.Printf("Skipping %s (%s is not a semantic version)\n", p, current)
+			continue
+		}
+
+		tags, err := listVersions(p)
+		if err != nil {
+			fmt.Printf("Warning: %s\n", err)
+			failed++
+			continue
+		}
+		next := latestAllowed(current, tags)
+		if next == "" {
+			continue
+		}
+
+		if err := inst.downloadPackage(p+"@"+next, false); err != nil {
+			fmt.Printf("Warning: failed to update %s to %s: %s\n", p, next, err)
+			failed++
+			continue
+		}
+		config.Require[p] = next
+		changes = append(changes, fmt.Sprintf("%s %s -> %s", p, current, next))
+	}
+
+	if len(changes) == 0 {
+		if failed > 0 {
+			return fmt.Errorf("%d package(s) could not be checked", failed)
+		}
+		fmt.Println("All dependencies are up to date.")
+		return nil
+	}
+
+	if err := config.Save("noxy.mod"); err != nil {
+		return err
+	}
+	if err := inst.finish(); err != nil {
+		return err
+	}
+
+	fmt.Println("Updated:")
+	for _, c := range changes {
+		fmt.Println("  " + c)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package pkgmanager
+
+import "testing"
+
+func TestLatestAllowed(t *testing.T) {
+	tags := []string{"v1.0.0", "v1.2.0", "v1.10.1", "v2.0.0", "v1.11.0-beta.1", "latest", "v1.3"}
+
+	tests := []struct {
+		current  string
+		expected string
+	}{
+		{"v1.0.0", "v1.10.1"},               // newest within major, numeric (not lexical) order
+		{"v1.10.1", ""},                     // already latest
+		{"v2.0.0", ""},                      // no newer v2
+		{"v1.11.0-alpha", "v1.11.0-beta.1"}, // pre-release current may move to newer pre-release
+		{"HEAD", ""},                        // not a semantic version
+	}
+
+	for _, tt := range tests {
+		if got := latestAllowed(tt.current, tags); got != tt.expected {
+			t.Errorf("latestAllowed(%q) = %q, want %q", tt.current, got, tt.expected)
+		}
+	}
+}
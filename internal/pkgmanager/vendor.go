@@ -0,0 +1,140 @@
+package pkgmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VendorListName is the file in noxy_libs that marks a project as
+// vendored and lists the packages copied there:
+//
+//	<pkg> <version>
+//
+// While it exists, imports resolve only from noxy_libs, never from the
+// package cache.
+const VendorListName = "vendor.txt"
+
+const vendorListHeader = "# Packages vendored by `noxy vendor`. While this file exists, imports\n# are resolved from noxy_libs only.\n"
+
+// IsVendored reports whether the project in dir has been vendored.
+func IsVendored(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, NoxyLibsDir, VendorListName))
+	return err == nil
+}
+
+// readVendorList returns the packages listed in the project's vendor list.
+// A missing file yields an empty list.
+func readVendorList(path string) (map[string]string, error) {
+	list := make(map[string]string)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: malformed line", path, i+1)
+		}
+		list[parts[0]] = parts[1]
+	}
+	return list, nil
+}
+
+// saveVendorList adds the packages this installer put into noxy_libs to
+// the vendor list.
+func (inst *installer) saveVendorList() error {
+	path := filepath.Join(NoxyLibsDir, VendorListName)
+	list, err := readVendorList(path)
+	if err != nil {
+		return err
+	}
+	for pkg, version := range inst.installed {
+		list[pkg] = version
+	}
+	return writeVendorList(path, list)
+}
+
+// pruneVendorList drops the packages the project no longer needs from the
+// vendor list.
+func pruneVendorList(needed map[string]string) error {
+	path := filepath.Join(NoxyLibsDir, VendorListName)
+	list, err := readVendorList(path)
+	if err != nil {
+		return err
+	}
+	for pkg := range list {
+		if _, ok := needed[pkg]; !ok {
+			delete(list, pkg)
+		}
+	}
+	return writeVendorList(path, list)
+}
+
+func writeVendorList(path string, list map[string]string) error {
+	var sb strings.Builder
+	sb.WriteString(vendorListHeader)
+	for _, pkg := range sortedKeys(list) {
+		sb.WriteString(fmt.Sprintf("%s %s\n", pkg, list[pkg]))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+// Vendor installs every package required by the project's noxy.mod
+// (and their dependencies) into noxy_libs, using the cache where possible,
+// and marks the project as vendored so it can be built without network
+// access or a package cache.
+func Vendor() error {
+	if _, err := os.Stat("noxy.mod"); err != nil {
+		return fmt.Errorf("noxy.mod not found in current directory")
+	}
+	config, err := ParseModFile("noxy.mod")
+	if err != nil {
+		return err
+	}
+	sums, err := ParseSumFile(SumFileName)
+	if err != nil {
+		return err
+	}
+
+	inst := newInstaller(sums, config.Replace)
+	inst.vendor = true
+
+	var failed []string
+	for _, pkg := range sortedKeys(config.Require) {
+		arg := pkg
+		if ver := config.Require[pkg]; ver != "" {
+			arg = pkg + "@" + ver
+		}
+		if err := inst.downloadPackage(arg, false); err != nil {
+			fmt.Printf("Error: failed to vendor %s: %s\n", arg, err)
+			failed = append(failed, arg)
+		}
+	}
+
+	if len(sums.Sums) > 0 {
+		if err := sums.Save(SumFileName); err != nil {
+			return err
+		}
+	}
+	if err := inst.saveVendorList(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", VendorListName, err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to vendor %d package(s)", len(failed))
+	}
+	fmt.Println("Done.")
+	return nil
+}
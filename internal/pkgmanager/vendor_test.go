@@ -0,0 +1,155 @@
+package pkgmanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveMirror serves the given package archives, keyed by
+// "<pkg>@<version>", the way a NOXY_PROXY mirror does.
+func serveMirror(t *testing.T, archives map[string][]byte) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, data := range archives {
+			i := strings.LastIndex(key, "@")
+			if r.URL.Path == "/"+key[:i]+"/@v/"+key[i+1:]+".tar.gz" {
+				w.Write(data)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("NOXY_PROXY", srv.URL)
+}
+
+// inTempProject runs the test from a new project directory with its own
+// package cache.
+func inTempProject(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("NOXY_CACHE", filepath.Join(dir, "cache"))
+	project := filepath.Join(dir, "app")
+	writeFile(t, filepath.Join(project, "noxy.mod"), "module app\n")
+	wd, _ := os.Getwd()
+	if err := os.Chdir(project); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestGetUsesCacheUnlessVendored(t *testing.T) {
+	inTempProject(t)
+	archives := map[string][]byte{
+		"example.com/user/web@v1.0.0": makeTarGz(t, map[string]string{
+			"web-v1.0.0/noxy.mod": "require example.com/user/util v0.1.0\n",
+			"web-v1.0.0/web.nx":   "func serve() -> int return 1 end\n",
+		}),
+		"example.com/user/util@v0.1.0": makeTarGz(t, map[string]string{
+			"util-v0.1.0/util.nx": "func help() -> int return 2 end\n",
+		}),
+	}
+	serveMirror(t, archives)
+
+	if err := Get("example.com/user/web@v1.0.0"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if dirExists(NoxyLibsDir) {
+		t.Errorf("Get copied packages into noxy_libs in a project that is not vendored")
+	}
+	sums, _ := ParseSumFile(SumFileName)
+	webHash, ok := sums.Get("example.com/user/web", "v1.0.0")
+	if _, utilOK := sums.Get("example.com/user/util", "v0.1.0"); !ok || !utilOK {
+		t.Fatalf("noxy.sum is missing entries: %v", sums.Sums)
+	}
+
+	// Imports resolve to the cache, dependencies of dependencies included
+	config, _ := ParseModFile("noxy.mod")
+	cached := CachedPackages(".", config)
+	if len(cached) != 2 || cached["example.com/user/web"] != CachePath("example.com/user/web", "v1.0.0", webHash) {
+		t.Errorf("CachedPackages = %v", cached)
+	}
+
+	// A cached entry that no longer matches noxy.sum is not used
+	webDir := cached["example.com/user/web"]
+	writeFile(t, filepath.Join(webDir, "web.nx"), "func serve() -> int return 666 end\n")
+	if _, ok := CachedPackage("example.com/user/web", "v1.0.0", webHash); ok {
+		t.Errorf("CachedPackage accepted a modified entry")
+	}
+	// and is replaced by the next install
+	if err := Get("example.com/user/web@v1.0.0"); err != nil {
+		t.Fatalf("Get with a modified cache entry failed: %v", err)
+	}
+	if _, ok := CachedPackage("example.com/user/web", "v1.0.0", webHash); !ok {
+		t.Errorf("modified cache entry was not fetched again")
+	}
+
+	// Vendoring copies everything into noxy_libs and stops using the cache
+	if err := Vendor(); err != nil {
+		t.Fatalf("Vendor failed: %v", err)
+	}
+	for _, dir := range []string{"noxy_libs/example_com/user/web", "noxy_libs/example_com/user/util"} {
+		if !dirExists(filepath.FromSlash(dir)) {
+			t.Errorf("%s was not vendored", dir)
+		}
+	}
+	if !IsVendored(".") {
+		t.Fatalf("Vendor did not write %s", VendorListName)
+	}
+	list, err := readVendorList(filepath.Join(NoxyLibsDir, VendorListName))
+	if err != nil || list["example.com/user/web"] != "v1.0.0" || list["example.com/user/util"] != "v0.1.0" {
+		t.Errorf("vendor list = %v, %v", list, err)
+	}
+	if cached := CachedPackages(".", config); len(cached) != 0 {
+		t.Errorf("CachedPackages = %v for a vendored project", cached)
+	}
+
+	// Removing a dependency of a vendored project updates the list
+	if err := Remove("example.com/user/web"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if list, _ := readVendorList(filepath.Join(NoxyLibsDir, VendorListName)); len(list) != 0 {
+		t.Errorf("vendor list after Remove = %v", list)
+	}
+}
+
+func TestMovedTagGetsNewCacheEntry(t *testing.T) {
+	inTempProject(t)
+	archives := map[string][]byte{
+		"example.com/user/lib@v1.0.0": makeTarGz(t, map[string]string{"lib-v1.0.0/lib.nx": "func f() -> int return 1 end\n"}),
+	}
+	serveMirror(t, archives)
+	if err := Get("example.com/user/lib@v1.0.0"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	sums, _ := ParseSumFile(SumFileName)
+	before, _ := sums.Get("example.com/user/lib", "v1.0.0")
+
+	// The tag now points at different code. A project that recorded the
+	// old hash keeps getting the old copy from the cache...
+	archives["example.com/user/lib@v1.0.0"] = makeTarGz(t, map[string]string{"lib-v1.0.0/lib.nx": "func f() -> int return 2 end\n"})
+	if err := Get("example.com/user/lib@v1.0.0"); err != nil {
+		t.Fatalf("Get from the cache failed: %v", err)
+	}
+
+	// ...while a project without noxy.sum downloads the new code into a
+	// separate entry instead of being handed the old one
+	if err := os.Remove(SumFileName); err != nil {
+		t.Fatal(err)
+	}
+	if err := Get("example.com/user/lib@v1.0.0"); err != nil {
+		t.Fatalf("Get without noxy.sum failed: %v", err)
+	}
+	sums, _ = ParseSumFile(SumFileName)
+	after, _ := sums.Get("example.com/user/lib", "v1.0.0")
+	if after == before {
+		t.Fatalf("moved tag was served from the old cache entry")
+	}
+	for _, hash := range []string{before, after} {
+		if _, ok := CachedPackage("example.com/user/lib", "v1.0.0", hash); !ok {
+			t.Errorf("cache entry for %s is missing", hash)
+		}
+	}
+}
@@ -2,14 +2,18 @@ package plugin
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"noxy-vm/internal/value"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Request sent to plugin
@@ -22,6 +26,7 @@ type PluginRequest struct {
 type PluginResponse struct {
 	Result interface{} `json:"result,omitempty"`
 	Error  string      `json:"error,omitempty"`
+	Code   int64       `json:"code,omitempty"` // Of the error, if any
 }
 
 type PluginClient struct {
@@ -31,18 +36,48 @@ type PluginClient struct {
 	Stdout  *bufio.Scanner
 	Running bool
 	Lock    sync.Mutex
+
+	// Remote plugins: requests are POSTed to URL instead of written to stdin
+	URL  string
+	HTTP *http.Client
 }
 
-var (
-	LoadedPlugins = make(map[string]*PluginClient)
-	PluginsLock   sync.Mutex
-)
+// RemoteTimeout bounds a single request to a remote (HTTP) plugin.
+const RemoteTimeout = 60 * time.Second
+
+// IsRemote reports whether target names an HTTP plugin endpoint rather than
+// an executable.
+func IsRemote(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// Registry holds the plugins loaded by one VM and the threads it spawns,
+// keyed by name. It is safe for concurrent use.
+type Registry struct {
+	lock    sync.Mutex
+	plugins map[string]*PluginClient
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{plugins: make(map[string]*PluginClient)}
+}
+
+// Get returns the plugin loaded under name.
+func (r *Registry) Get(name string) (*PluginClient, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	client, ok := r.plugins[name]
+	return client, ok
+}
 
-func LoadPlugin(name string, executableName string) (*PluginClient, error) {
-	PluginsLock.Lock()
-	defer PluginsLock.Unlock()
+// Load starts executableName as the plugin called name, unless a plugin of
+// that name is already loaded, in which case that one is returned.
+func (r *Registry) Load(name string, executableName string) (*PluginClient, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-	if client, ok := LoadedPlugins[name]; ok {
+	if client, ok := r.plugins[name]; ok {
 		return client, nil
 	}
 
@@ -76,7 +111,17 @@ func LoadPlugin(name string, executableName string) (*PluginClient, error) {
 		}
 	}
 
-	cmd := exec.Command(execPath)
+	client, err := startClient(name, exec.Command(execPath))
+	if err != nil {
+		return nil, err
+	}
+	r.plugins[name] = client
+	return client, nil
+}
+
+// startClient starts cmd as the plugin called name, talking to it over its
+// stdin and stdout.
+func startClient(name string, cmd *exec.Cmd) (*PluginClient, error) {
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create stdin pipe: %v", err)
@@ -93,24 +138,99 @@ func LoadPlugin(name string, executableName string) (*PluginClient, error) {
 		return nil, fmt.Errorf("failed to start plugin process: %v", err)
 	}
 
-	client := &PluginClient{
+	return &PluginClient{
 		Name:    name,
 		Cmd:     cmd,
 		Stdin:   stdin,
 		Stdout:  bufio.NewScanner(stdoutPipe),
 		Running: true,
+	}, nil
+}
+
+// LoadRemote registers a plugin served over HTTP. Each call is POSTed to
+// url as a PluginRequest and must be answered with a PluginResponse, the
+// same JSON shapes used over stdio.
+func (r *Registry) LoadRemote(name string, url string) (*PluginClient, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if client, ok := r.plugins[name]; ok {
+		return client, nil
+	}
+
+	if !IsRemote(url) {
+		return nil, fmt.Errorf("invalid plugin url: %s", url)
 	}
 
-	LoadedPlugins[name] = client
+	client := &PluginClient{
+		Name:    name,
+		URL:     url,
+		HTTP:    &http.Client{Timeout: RemoteTimeout},
+		Running: true,
+	}
+
+	r.plugins[name] = client
 	return client, nil
 }
 
+// Close stops every loaded plugin and forgets them, so a later Load starts
+// a fresh process.
+func (r *Registry) Close() {
+	r.lock.Lock()
+	clients := r.plugins
+	r.plugins = make(map[string]*PluginClient)
+	r.lock.Unlock()
+
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// ShutdownGrace is how long Close waits for a plugin process to exit after
+// its stdin is closed before killing it.
+const ShutdownGrace = time.Second
+
+// Close stops the plugin: a subprocess sees EOF on stdin and is killed if
+// it has not exited within ShutdownGrace. Later calls return null.
+func (c *PluginClient) Close() {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if !c.Running {
+		return
+	}
+	c.Running = false
+	if c.Cmd == nil {
+		return
+	}
+
+	c.Stdin.Close()
+	exited := make(chan struct{})
+	go func() {
+		c.Cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(ShutdownGrace):
+		c.Cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// Call sends method and args to the plugin and returns its result. A
+// failed request returns an error value: the error the plugin answered
+// with, or why the request could not be made.
 func (c *PluginClient) Call(method string, args []value.Value) value.Value {
 	c.Lock.Lock()
 	defer c.Lock.Unlock()
 
 	if !c.Running {
-		return value.NewNull()
+		return value.Errorf("plugin %s is not running", c.Name)
+	}
+
+	if c.URL != "" {
+		return c.callRemote(method, args)
 	}
 
 	// Marshal args to JSON
@@ -126,15 +246,13 @@ func (c *PluginClient) Call(method string, args []value.Value) value.Value {
 
 	reqBytes, err := json.Marshal(req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Plugin Error: failed to marshal request: %v\n", err)
-		return value.NewNull()
+		return value.Errorf("plugin %s: failed to marshal request: %v", c.Name, err)
 	}
 
 	// Send Request
 	if _, err := c.Stdin.Write(append(reqBytes, '\n')); err != nil {
-		fmt.Fprintf(os.Stderr, "Plugin Error: failed to write to plugin: %v\n", err)
 		c.Running = false
-		return value.NewNull()
+		return value.Errorf("plugin %s: failed to write to plugin: %v", c.Name, err)
 	}
 
 	// Read Response
@@ -142,27 +260,55 @@ func (c *PluginClient) Call(method string, args []value.Value) value.Value {
 		respBytes := c.Stdout.Bytes()
 		var resp PluginResponse
 		if err := json.Unmarshal(respBytes, &resp); err != nil {
-			fmt.Fprintf(os.Stderr, "Plugin Error: failed to unmarshal response: %v\n", err)
-			return value.NewNull()
-		}
-
-		if resp.Error != "" {
-			// Maybe return error object? For now basic null or print?
-			// Let's print for debug, return null
-			fmt.Fprintf(os.Stderr, "Plugin Remote Error: %s\n", resp.Error)
-			return value.NewNull()
+			return value.Errorf("plugin %s: failed to unmarshal response: %v", c.Name, err)
 		}
-
-		return InterfaceToValue(resp.Result)
+		return resp.result()
 	} else {
+		c.Running = false
 		if err := c.Stdout.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "Plugin Error: read failed: %v\n", err)
-		} else {
-			fmt.Fprintf(os.Stderr, "Plugin Error: unexpected EOF\n")
+			return value.Errorf("plugin %s: read failed: %v", c.Name, err)
 		}
-		c.Running = false
-		return value.NewNull()
+		return value.Errorf("plugin %s: unexpected EOF", c.Name)
+	}
+}
+
+func (c *PluginClient) callRemote(method string, args []value.Value) value.Value {
+	jsonArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		jsonArgs[i] = ValueToInterface(arg)
+	}
+
+	reqBytes, err := json.Marshal(PluginRequest{Method: method, Params: jsonArgs})
+	if err != nil {
+		return value.Errorf("plugin %s: failed to marshal request: %v", c.Name, err)
+	}
+
+	resp, err := c.HTTP.Post(c.URL, "application/json", bytes.NewReader(reqBytes))
+	if err != nil {
+		// Unlike a dead subprocess, a remote endpoint may come back, so the
+		// client stays usable.
+		return value.Errorf("plugin %s: request to %s failed: %v", c.Name, c.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return value.Errorf("plugin %s: %s returned %s", c.Name, c.URL, resp.Status)
+	}
+
+	var pr PluginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return value.Errorf("plugin %s: failed to unmarshal response: %v", c.Name, err)
+	}
+	return pr.result()
+}
+
+// result is the result of a response, or an error value with the message
+// and code of an error response.
+func (r *PluginResponse) result() value.Value {
+	if r.Error != "" {
+		return value.NewError(r.Error, r.Code)
 	}
+	return InterfaceToValue(r.Result)
 }
 
 // Helpers to convert between Value and Go interface{} for JSON
@@ -182,8 +328,8 @@ func ValueToInterface(v value.Value) interface{} {
 		case string:
 			return o
 		case *value.ObjArray:
-			arr := make([]interface{}, len(o.Elements))
-			for i, e := range o.Elements {
+			arr := make([]interface{}, o.Len())
+			for i, e := range o.Values() {
 				arr[i] = ValueToInterface(e)
 			}
 			return arr
@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"noxy-vm/internal/value"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestHelperProcess is the plugin started by the stdio tests: it answers
+// each request line with a response line until stdin closes.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("NOXY_PLUGIN_HELPER") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	out := json.NewEncoder(os.Stdout)
+	for scanner.Scan() {
+		var req PluginRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			out.Encode(PluginResponse{Error: err.Error()})
+			continue
+		}
+		switch req.Method {
+		case "echo":
+			out.Encode(PluginResponse{Result: req.Params})
+		case "fail":
+			out.Encode(PluginResponse{Error: "no such item", Code: 404})
+		case "garbage":
+			os.Stdout.WriteString("not json\n")
+		case "crash":
+			os.Exit(3)
+		default:
+			out.Encode(PluginResponse{Error: "unknown method " + req.Method})
+		}
+	}
+	os.Exit(0)
+}
+
+// startHelper starts TestHelperProcess as a plugin.
+func startHelper(t *testing.T) *PluginClient {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), "NOXY_PLUGIN_HELPER=1")
+	client, err := startClient("helper", cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+// errorOf returns the message and code of an error value, failing the
+// test if v is not one.
+func errorOf(t *testing.T, v value.Value) (string, int64) {
+	t.Helper()
+	e, ok := v.Obj.(*value.ObjError)
+	if !ok || !value.IsError(v) {
+		t.Fatalf("got %s, want an error", v)
+	}
+	return e.Message, e.Code
+}
+
+func TestStdioRoundTrip(t *testing.T) {
+	client := startHelper(t)
+
+	args := []value.Value{
+		value.NewInt(7),
+		value.NewFloat(1.5),
+		value.NewString("hi"),
+		value.NewBool(true),
+		value.NewNull(),
+		value.NewArray([]value.Value{value.NewInt(1), value.NewString("two")}),
+		value.NewMapWithData(map[string]value.Value{"k": value.NewInt(3)}),
+	}
+	got := client.Call("echo", args)
+	if want := `[7, 1.5, "hi", true, null, [1, "two"], {"k": 3}]`; got.String() != want {
+		t.Errorf("echo returned %s, want %s", got, want)
+	}
+
+	msg, code := errorOf(t, client.Call("fail", nil))
+	if msg != "no such item" || code != 404 {
+		t.Errorf("fail returned %q code %d", msg, code)
+	}
+
+	// A bad response fails the call but leaves the plugin running
+	msg, _ = errorOf(t, client.Call("garbage", nil))
+	if !strings.Contains(msg, "failed to unmarshal response") {
+		t.Errorf("garbage returned %q", msg)
+	}
+	if got := client.Call("echo", []value.Value{value.NewInt(1)}); got.String() != "[1]" {
+		t.Errorf("echo after a bad response returned %s", got)
+	}
+
+	// A plugin that dies stops the client
+	msg, _ = errorOf(t, client.Call("crash", nil))
+	if msg != "plugin helper: unexpected EOF" {
+		t.Errorf("crash returned %q", msg)
+	}
+	msg, _ = errorOf(t, client.Call("echo", nil))
+	if msg != "plugin helper is not running" {
+		t.Errorf("call after crash returned %q", msg)
+	}
+}
+
+func TestStdioClose(t *testing.T) {
+	client := startHelper(t)
+	client.Close()
+	if client.Cmd.ProcessState == nil || !client.Cmd.ProcessState.Exited() {
+		t.Errorf("plugin process still running after Close")
+	}
+	msg, _ := errorOf(t, client.Call("echo", nil))
+	if msg != "plugin helper is not running" {
+		t.Errorf("call after Close returned %q", msg)
+	}
+	client.Close() // closing twice is harmless
+}
+
+func TestCallRemote(t *testing.T) {
+	var requests []PluginRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); r.Method != http.MethodPost || ct != "application/json" {
+			t.Errorf("got %s with Content-Type %q, want a JSON POST", r.Method, ct)
+		}
+		var req PluginRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		switch req.Method {
+		case "fail":
+			json.NewEncoder(w).Encode(PluginResponse{Error: "throttled", Code: 429})
+		case "down":
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		case "garbage":
+			w.Write([]byte("<html>"))
+		default:
+			json.NewEncoder(w).Encode(PluginResponse{Result: map[string]interface{}{"method": req.Method, "params": req.Params}})
+		}
+	}))
+	defer server.Close()
+
+	r := NewRegistry()
+	defer r.Close()
+	client, err := r.LoadRemote("remote", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := r.LoadRemote("remote", "http://unused"); again != client {
+		t.Errorf("LoadRemote did not reuse the loaded plugin")
+	}
+
+	got := client.Call("get_item", []value.Value{value.NewString("users"), value.NewInt(1)})
+	if want := `{"method": "get_item", "params": ["users", 1]}`; got.String() != want {
+		t.Errorf("get_item returned %s, want %s", got, want)
+	}
+	if len(requests) != 1 || requests[0].Method != "get_item" {
+		t.Errorf("server saw %v", requests)
+	}
+
+	msg, code := errorOf(t, client.Call("fail", nil))
+	if msg != "throttled" || code != 429 {
+		t.Errorf("fail returned %q code %d", msg, code)
+	}
+	msg, _ = errorOf(t, client.Call("down", nil))
+	if !strings.Contains(msg, "returned 503 Service Unavailable") {
+		t.Errorf("down returned %q", msg)
+	}
+	msg, _ = errorOf(t, client.Call("garbage", nil))
+	if !strings.Contains(msg, "failed to unmarshal response") {
+		t.Errorf("garbage returned %q", msg)
+	}
+
+	// An unreachable endpoint fails the call, but the client stays usable
+	server.Close()
+	msg, _ = errorOf(t, client.Call("get_item", nil))
+	if !strings.Contains(msg, "request to "+server.URL+" failed") {
+		t.Errorf("call to a closed server returned %q", msg)
+	}
+	if !client.Running {
+		t.Errorf("remote client stopped after a failed request")
+	}
+
+	if _, err := r.LoadRemote("bad", "ftp://example.com"); err == nil {
+		t.Errorf("LoadRemote accepted a non-HTTP url")
+	}
+}
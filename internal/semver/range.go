@@ -0,0 +1,197 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Range is a set of versions, such as "^1.2.0" or ">=1.0.0 <2.0.0 || 3.x".
+// It is a union of comparator sets; a version is in a set when it
+// satisfies every comparator of the set.
+type Range struct {
+	sets [][]comparator
+}
+
+type comparator struct {
+	op string // one of = < <= > >=
+	v  Version
+}
+
+// ParseRange parses a range. Sets are separated by "||"; within a set,
+// comparators are separated by spaces. A comparator is an operator (=,
+// <, <=, >, >=, defaulting to =) and a version, or one of the shorthands:
+//
+//	^1.2.3   >=1.2.3 <2.0.0 (^0.2.3 is <0.3.0, ^0.0.3 is <0.0.4)
+//	~1.2.3   >=1.2.3 <1.3.0
+//	1.2.x    >=1.2.0 <1.3.0, also written 1.2 or 1.2.*
+//	*        any version, also written x or as an empty range
+//
+// Versions may omit trailing parts after an operator too: ">=1.2" is
+// ">=1.2.0".
+func ParseRange(s string) (Range, error) {
+	var r Range
+	for _, part := range strings.Split(s, "||") {
+		set := []comparator{}
+		for _, tok := range strings.Fields(part) {
+			cs, err := parseComparator(tok)
+			if err != nil {
+				return Range{}, fmt.Errorf("invalid range %q: %s", s, err)
+			}
+			set = append(set, cs...)
+		}
+		r.sets = append(r.sets, set)
+	}
+	return r, nil
+}
+
+// Contains reports whether v is in the range. A pre-release version is
+// only in a set that mentions a pre-release of the same MAJOR.MINOR.PATCH,
+// so ">=1.0.0" does not match "2.0.0-beta" but ">=2.0.0-alpha" does.
+func (r Range) Contains(v Version) bool {
+	for _, set := range r.sets {
+		if setContains(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func setContains(set []comparator, v Version) bool {
+	for _, c := range set {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	if v.Pre == "" {
+		return true
+	}
+	for _, c := range set {
+		if c.v.Pre != "" && c.v.Major == v.Major && c.v.Minor == v.Minor && c.v.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparator) matches(v Version) bool {
+	cmp := Compare(v, c.v)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
+// parseComparator expands one token of a range into comparators.
+func parseComparator(tok string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(tok, prefix) {
+			op, tok = prefix, tok[len(prefix):]
+			break
+		}
+	}
+	v, n, err := parsePartial(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	// upper is the first version past the wildcard: 1.x -> 2.0.0,
+	// 1.2.x -> 1.3.0. The "-0" pre-release keeps pre-releases of upper
+	// itself out.
+	upper := func(n int) Version {
+		switch n {
+		case 1:
+			return Version{Major: v.Major + 1, Pre: "0"}
+		case 2:
+			return Version{Major: v.Major, Minor: v.Minor + 1, Pre: "0"}
+		}
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, Pre: "0"}
+	}
+	switch op {
+	case "^":
+		switch {
+		case n == 0:
+			return nil, nil
+		case v.Major > 0 || n == 1:
+			return []comparator{{">=", v}, {"<", upper(1)}}, nil
+		case v.Minor > 0 || n == 2:
+			return []comparator{{">=", v}, {"<", upper(2)}}, nil
+		}
+		return []comparator{{">=", v}, {"<", upper(3)}}, nil
+	case "~":
+		switch n {
+		case 0:
+			return nil, nil
+		case 1:
+			return []comparator{{">=", v}, {"<", upper(1)}}, nil
+		}
+		return []comparator{{">=", v}, {"<", upper(2)}}, nil
+	case "", "=":
+		if n == 0 {
+			return nil, nil
+		}
+		if n < 3 {
+			return []comparator{{">=", v}, {"<", upper(n)}}, nil
+		}
+		return []comparator{{"=", v}}, nil
+	case ">", "<=":
+		// >1.2 means >=1.3.0, <=1.2 means <1.3.0
+		if n == 0 {
+			if op == ">" {
+				return []comparator{{"<", Version{}}}, nil // nothing
+			}
+			return nil, nil
+		}
+		if n < 3 {
+			if op == ">" {
+				return []comparator{{">=", upper(n)}}, nil
+			}
+			return []comparator{{"<", upper(n)}}, nil
+		}
+	case ">=", "<":
+		if n == 0 {
+			if op == "<" {
+				return []comparator{{"<", Version{Pre: "0"}}}, nil // nothing
+			}
+			return nil, nil
+		}
+	}
+	return []comparator{{op, v}}, nil
+}
+
+// parsePartial parses a version whose trailing parts may be missing or
+// wildcards (x, X or *), returning how many parts were given.
+func parsePartial(s string) (Version, int, error) {
+	if v, ok := Parse(s); ok {
+		return v, 3, nil
+	}
+	s = strings.TrimPrefix(s, "v")
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return Version{}, 0, fmt.Errorf("bad version %q", s)
+	}
+	var nums [3]int
+	n := 0
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			break
+		}
+		num, ok := parseNum(p)
+		if !ok {
+			return Version{}, 0, fmt.Errorf("bad version %q", s)
+		}
+		nums[i] = num
+		n++
+	}
+	if n == 3 {
+		return Version{}, 0, fmt.Errorf("bad version %q", s)
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, n, nil
+}
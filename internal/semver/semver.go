@@ -0,0 +1,156 @@
+// Package semver parses and compares semantic versions and version
+// ranges. The package manager resolves versions with it, and the semver
+// native module exposes it to scripts, so both agree on what a version
+// and a range mean.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD] version.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string
+	Build               string
+}
+
+// Parse parses a version, with or without a leading "v".
+func Parse(s string) (Version, bool) {
+	v := strings.TrimPrefix(s, "v")
+	var sv Version
+	if i := strings.Index(v, "+"); i >= 0 {
+		sv.Build = v[i+1:]
+		if !validIdents(sv.Build) {
+			return Version{}, false
+		}
+		v = v[:i]
+	}
+	if i := strings.Index(v, "-"); i >= 0 {
+		sv.Pre = v[i+1:]
+		if !validIdents(sv.Pre) {
+			return Version{}, false
+		}
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return Version{}, false
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, ok := parseNum(p)
+		if !ok {
+			return Version{}, false
+		}
+		nums[i] = n
+	}
+	sv.Major, sv.Minor, sv.Patch = nums[0], nums[1], nums[2]
+	return sv, true
+}
+
+// parseNum parses a version number: digits without a leading zero.
+func parseNum(s string) (int, bool) {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// validIdents reports whether s is a dot-separated list of non-empty
+// alphanumeric identifiers (hyphens allowed), as pre-release and build
+// metadata are.
+func validIdents(s string) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, c := range id {
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String formats v without a leading "v".
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 by semver precedence: a pre-release sorts
+// before its release, pre-release identifiers compare numerically when
+// both are numbers, and build metadata is ignored.
+func Compare(a, b Version) int {
+	for _, d := range []int{a.Major - b.Major, a.Minor - b.Minor, a.Patch - b.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case a.Pre == b.Pre:
+		return 0
+	case a.Pre == "":
+		return 1
+	case b.Pre == "":
+		return -1
+	}
+	as, bs := strings.Split(a.Pre, "."), strings.Split(b.Pre, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareIdent(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// compareIdent compares pre-release identifiers: numbers numerically and
+// before alphanumeric identifiers, which compare as strings.
+func compareIdent(a, b string) int {
+	an, aNum := parseNum(a)
+	bn, bNum := parseNum(b)
+	switch {
+	case aNum && bNum:
+		return sign(an - bn)
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
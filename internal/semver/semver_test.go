@@ -0,0 +1,76 @@
+package semver
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	// In increasing order of precedence
+	versions := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "v1.0.0", "1.2.0", "1.10.0", "2.0.0",
+	}
+	for i := range versions {
+		for j := range versions {
+			a, ok1 := Parse(versions[i])
+			b, ok2 := Parse(versions[j])
+			if !ok1 || !ok2 {
+				t.Fatalf("cannot parse %q or %q", versions[i], versions[j])
+			}
+			want := sign(i - j)
+			if got := Compare(a, b); got != want {
+				t.Errorf("Compare(%s, %s) = %d, want %d", versions[i], versions[j], got, want)
+			}
+		}
+	}
+
+	if v, ok := Parse("1.2.3-rc.1+build.5"); !ok || v.Pre != "rc.1" || v.Build != "build.5" || v.String() != "1.2.3-rc.1+build.5" {
+		t.Errorf("Parse(1.2.3-rc.1+build.5) = %+v, %v", v, ok)
+	}
+	for _, bad := range []string{"1.2", "1.2.3.4", "01.2.3", "1.2.3-", "1.2.3-a..b", "a.b.c", ""} {
+		if _, ok := Parse(bad); ok {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		rng     string
+		in, out []string
+	}{
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0", "2.0.0-alpha", "1.5.0-beta"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0", "0.2.2"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0"}},
+		{"1.2.x", []string{"1.2.0", "1.2.7"}, []string{"1.3.0", "1.1.9"}},
+		{"1", []string{"1.0.0", "1.9.9"}, []string{"2.0.0"}},
+		{">=1.0.0 <2.0.0 || 3.x", []string{"1.0.0", "3.1.0"}, []string{"2.5.0", "4.0.0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"=1.2.3", []string{"v1.2.3"}, []string{"1.2.4"}},
+		{">=2.0.0-alpha", []string{"2.0.0-beta", "2.1.0"}, []string{"2.1.0-beta", "1.9.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, []string{"1.0.0-rc.1"}},
+		{"", []string{"1.0.0"}, nil},
+	}
+	for _, tt := range tests {
+		r, err := ParseRange(tt.rng)
+		if err != nil {
+			t.Fatalf("ParseRange(%q): %v", tt.rng, err)
+		}
+		for _, s := range tt.in {
+			if v, _ := Parse(s); !r.Contains(v) {
+				t.Errorf("%q does not contain %s", tt.rng, s)
+			}
+		}
+		for _, s := range tt.out {
+			if v, _ := Parse(s); r.Contains(v) {
+				t.Errorf("%q contains %s", tt.rng, s)
+			}
+		}
+	}
+
+	for _, bad := range []string{"^a.b", "1.2.3.4", ">=x1", "~1.2.3-"} {
+		if _, err := ParseRange(bad); err == nil {
+			t.Errorf("ParseRange(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package testrunner
+
+import (
+	"fmt"
+	"math"
+	"noxy-vm/internal/value"
+	"noxy-vm/internal/vm"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fixture is the per-test state behind the testing natives.
+type fixture struct {
+	dir    string // directory of the test file; golden files live in dir/testdata
+	update bool
+
+	lock    sync.Mutex
+	tempDir string // created on first use by the running test
+}
+
+// end removes the temporary directory of the test that just ran.
+func (fx *fixture) end() {
+	fx.lock.Lock()
+	defer fx.lock.Unlock()
+	if fx.tempDir != "" {
+		os.RemoveAll(fx.tempDir)
+		fx.tempDir = ""
+	}
+}
+
+func (fx *fixture) register(machine *vm.VM) {
+	// testing_fail(msg)
+	machine.DefineModuleNative("testing", "fail", func(args []value.Value) value.Value {
+		msg := "test failed"
+		if len(args) > 0 {
+			msg = args[0].String()
+		}
+		return value.NewNativeError("%s", msg)
+	})
+
+	// testing_assert(cond, msg)
+	machine.DefineModuleNative("testing", "assert", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewNativeError("expected a condition")
+		}
+		if args[0].Type == value.VAL_BOOL && args[0].AsBool {
+			return value.NewNull()
+		}
+		msg := "assertion failed"
+		if len(args) > 1 {
+			msg = args[1].String()
+		}
+		return value.NewNativeError("%s", msg)
+	})
+
+	// testing_assert_true(cond, msg?)
+	machine.DefineModuleNative("testing", "assert_true", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewNativeError("expected a condition")
+		}
+		if args[0].Type == value.VAL_BOOL && args[0].AsBool {
+			return value.NewNull()
+		}
+		if len(args) > 1 {
+			return value.NewNativeError("%s", args[1].String())
+		}
+		return value.NewNativeError("expected true, got %s", show(args[0]))
+	})
+
+	// testing_assert_eq(actual, expected, msg?)
+	// Arrays, maps, struct instances and errors are compared element by
+	// element, as are ints with floats.
+	machine.DefineModuleNative("testing", "assert_eq", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected the actual and expected values")
+		}
+		if equal(args[0], args[1], make(map[[2]interface{}]bool)) {
+			return value.NewNull()
+		}
+		if len(args) > 2 {
+			return value.NewNativeError("%s: got %s, want %s", args[2].String(), show(args[0]), show(args[1]))
+		}
+		return value.NewNativeError("got %s, want %s", show(args[0]), show(args[1]))
+	})
+
+	// testing_temp_dir() -> string
+	// The same empty directory for every call within a test; it is
+	// removed with its contents when the test ends.
+	machine.DefineModuleNative("testing", "temp_dir", func(args []value.Value) value.Value {
+		fx.lock.Lock()
+		defer fx.lock.Unlock()
+		if fx.tempDir == "" {
+			dir, err := os.MkdirTemp("", "noxy-test-*")
+			if err != nil {
+				return value.NewNativeError("%s", err)
+			}
+			fx.tempDir = dir
+		}
+		return value.NewString(fx.tempDir)
+	})
+
+	// testing_golden(name, actual)
+	// Fails unless actual (string or bytes) equals testdata/<name>.golden
+	// next to the test file. With `noxy test -update` the file is
+	// written instead.
+	machine.DefineModuleNative("testing", "golden", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected a name and the actual output")
+		}
+		name := args[0].String()
+		if name == "" || strings.Contains(name, "..") || filepath.IsAbs(name) {
+			return value.NewNativeError("invalid golden file name %q", name)
+		}
+		actual := args[1].String()
+		if args[1].Type == value.VAL_BYTES {
+			actual = args[1].Obj.(string)
+		}
+		rel := filepath.Join("testdata", name+".golden")
+		path := filepath.Join(fx.dir, rel)
+
+		if fx.update {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return value.NewNativeError("%s", err)
+			}
+			if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+				return value.NewNativeError("%s", err)
+			}
+			return value.NewNull()
+		}
+
+		want, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return value.NewNativeError("%s does not exist (run noxy test -update to create it)", rel)
+		}
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		if string(want) == actual {
+			return value.NewNull()
+		}
+		line, w, g := firstDiff(string(want), actual)
+		return value.NewNativeError("%s differs at line %d:\n\twant: %q\n\tgot:  %q\n\t(run noxy test -update to accept the new output)", rel, line, w, g)
+	})
+}
+
+// firstDiff returns the first line (1-based) where want and got differ,
+// with that line from each.
+func firstDiff(want, got string) (int, string, string) {
+	wl := strings.Split(want, "\n")
+	gl := strings.Split(got, "\n")
+	for i := 0; ; i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w != g || i >= len(wl) || i >= len(gl) {
+			return i + 1, w, g
+		}
+	}
+}
+
+// equal reports whether a and b hold the same data. seen holds the pairs
+// of containers being compared, so that values containing themselves
+// compare equal instead of recursing forever.
+func equal(a, b value.Value, seen map[[2]interface{}]bool) bool {
+	if a.Type == value.VAL_INT && b.Type == value.VAL_FLOAT {
+		return float64(a.AsInt) == b.AsFloat
+	}
+	if a.Type == value.VAL_FLOAT && b.Type == value.VAL_INT {
+		return a.AsFloat == float64(b.AsInt)
+	}
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case value.VAL_NULL:
+		return true
+	case value.VAL_BOOL:
+		return a.AsBool == b.AsBool
+	case value.VAL_INT:
+		return a.AsInt == b.AsInt
+	case value.VAL_FLOAT:
+		return a.AsFloat == b.AsFloat || math.IsNaN(a.AsFloat) && math.IsNaN(b.AsFloat)
+	}
+	if a.Obj == b.Obj {
+		return true
+	}
+	pair := [2]interface{}{a.Obj, b.Obj}
+	if seen[pair] {
+		return true
+	}
+	switch x := a.Obj.(type) {
+	case *value.ObjArray:
+		y, ok := b.Obj.(*value.ObjArray)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+		seen[pair] = true
+		for i := 0; i < x.Len(); i++ {
+			if !equal(x.At(i), y.At(i), seen) {
+				return false
+			}
+		}
+		return true
+	case *value.ObjMap:
+		y, ok := b.Obj.(*value.ObjMap)
+		if !ok || len(x.Data) != len(y.Data) {
+			return false
+		}
+		seen[pair] = true
+		for key, v := range x.Data {
+			w, ok := y.Data[key]
+			if !ok || !equal(v, w, seen) {
+				return false
+			}
+		}
+		return true
+	case *value.ObjInstance:
+		y, ok := b.Obj.(*value.ObjInstance)
+		if !ok || x.Struct.Name != y.Struct.Name || len(x.Fields) != len(y.Fields) {
+			return false
+		}
+		seen[pair] = true
+		for i := range x.Fields {
+			if !equal(x.Fields[i], y.Fields[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *value.ObjError:
+		y, ok := b.Obj.(*value.ObjError)
+		return ok && x.Message == y.Message && x.Code == y.Code
+	case string:
+		// Strings and bytes; a.Obj == b.Obj already compared them
+		return false
+	}
+	// Other values, such as bigints and decimals, are equal when they
+	// are the same kind of value and print the same
+	return fmt.Sprintf("%T", a.Obj) == fmt.Sprintf("%T", b.Obj) && a.String() == b.String()
+}
+
+// show formats v for a failure message, quoting strings so that "1" and
+// 1 tell apart.
+func show(v value.Value) string {
+	if s, ok := v.Obj.(string); ok && v.Type == value.VAL_OBJ {
+		return strconv.Quote(s)
+	}
+	return value.Inspect(v, "")
+}
@@ -0,0 +1,286 @@
+// Package testrunner implements `noxy test`: it finds *_test.nx files,
+// runs every top-level function whose name starts with test_ and reports
+// which of them failed.
+//
+// A test fails when it raises a runtime error, typically through the
+// testing natives defined for test files:
+//
+//	testing.fail(msg)              fail with msg
+//	testing.assert(cond, msg)      fail with msg unless cond holds
+//	testing.assert_true(cond, msg?)  the same, msg defaulting to the value of cond
+//	testing.assert_eq(actual, expected, msg?)  fail unless the values hold the same data
+//	testing.assert_error(body, msg) -> error  the error body() raised, failing with msg if none
+//	testing.temp_dir() -> string   a directory removed after the test
+//	testing.golden(name, actual)   compare actual with testdata/<name>.golden
+//	testing.with_stub(name, fn, body)  run body with the function name replaced by fn
+//
+// Functions named setup and teardown run before and after every test;
+// teardown runs even when the test fails.
+package testrunner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"noxy-vm/internal/ast"
+	"noxy-vm/internal/compiler"
+	"noxy-vm/internal/lexer"
+	"noxy-vm/internal/parser"
+	"noxy-vm/internal/vm"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options configures Run.
+type Options struct {
+	// Update makes testing.golden write the actual output to the golden
+	// file instead of comparing against it.
+	Update bool
+	// Config returns the VM configuration for a test file in the given
+	// directory. Stdout is replaced by Out, and WorkDir defaults to the
+	// directory.
+	Config func(root string) vm.VMConfig
+	// Strict compiles test files with compiler.Compiler.Strict.
+	Strict bool
+	// Parallel is how many test files run at once (1 when zero). Each
+	// file has a VM of its own; with more than one, the output of a file,
+	// including print_err, is held back until the file finishes so that
+	// reports never interleave.
+	Parallel int
+	// Out receives the report and the output of the tests (os.Stdout when
+	// nil).
+	Out io.Writer
+}
+
+// Result counts the tests run.
+type Result struct {
+	Passed int
+	Failed int
+}
+
+// Run runs the tests in paths, which may be test files or directories to
+// search recursively; no paths means the current directory.
+func Run(paths []string, opts Options) (Result, error) {
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	if opts.Config == nil {
+		opts.Config = func(root string) vm.VMConfig { return vm.VMConfig{RootPath: root} }
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(files) == 0 {
+		return Result{}, fmt.Errorf("no test files found")
+	}
+
+	var total Result
+	if opts.Parallel <= 1 {
+		for _, file := range files {
+			res := runFile(file, opts, opts.Out, false)
+			total.Passed += res.Passed
+			total.Failed += res.Failed
+		}
+		return total, nil
+	}
+
+	// Workers take files in order; reports are printed in the same order,
+	// each as soon as it and every file before it are done.
+	type report struct {
+		out bytes.Buffer
+		res Result
+	}
+	reports := make([]*report, len(files))
+	done := make([]chan struct{}, len(files))
+	for i := range files {
+		reports[i] = &report{}
+		done[i] = make(chan struct{})
+	}
+	next := make(chan int)
+	for w := 0; w < opts.Parallel && w < len(files); w++ {
+		go func() {
+			for i := range next {
+				reports[i].res = runFile(files[i], opts, &reports[i].out, true)
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range files {
+			next <- i
+		}
+		close(next)
+	}()
+	for i := range files {
+		<-done[i]
+		opts.Out.Write(reports[i].out.Bytes())
+		total.Passed += reports[i].res.Passed
+		total.Failed += reports[i].res.Failed
+	}
+	return total, nil
+}
+
+// findTestFiles expands paths into a sorted list of *_test.nx files,
+// skipping hidden directories, noxy_libs and testdata.
+func findTestFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if p != path && (strings.HasPrefix(name, ".") || name == "noxy_libs" || name == "testdata") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(p, "_test.nx") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// runFile runs the tests of one file in a VM of its own, writing the
+// report and the output of print to out; withStderr sends print_err
+// there too.
+func runFile(file string, opts Options, out io.Writer, withStderr bool) Result {
+	start := time.Now()
+	var res Result
+	fail := func(err error) Result {
+		fmt.Fprintf(out, "FAIL\t%s\n\t%s\n", file, err)
+		return Result{Failed: 1}
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fail(err)
+	}
+	p := parser.New(lexer.New(string(content)))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return fail(fmt.Errorf("%s", strings.Join(p.Errors(), "\n\t")))
+	}
+	c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), file)
+	c.Strict = opts.Strict
+	mainChunk, _, err := c.Compile(program)
+	if err != nil {
+		return fail(err)
+	}
+
+	var tests []string
+	hooks := make(map[string]bool)
+	for _, stmt := range program.Statements {
+		fn, ok := stmt.(*ast.FunctionStatement)
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(fn.Name, "test_"):
+			tests = append(tests, fn.Name)
+		case fn.Name == "setup" || fn.Name == "teardown":
+			hooks[fn.Name] = true
+		}
+	}
+
+	dir := filepath.Dir(file)
+	cfg := opts.Config(dir)
+	cfg.Stdout = out
+	if cfg.WorkDir == "" {
+		cfg.WorkDir = dir
+	}
+	if withStderr {
+		cfg.Stderr = out
+	}
+	machine := vm.NewWithConfig(cfg)
+	defer machine.Close()
+	fx := &fixture{dir: dir, update: opts.Update}
+	fx.register(machine)
+
+	// Top-level code runs once, defining the tests
+	if err := machine.Interpret(mainChunk); err != nil {
+		return fail(err)
+	}
+
+	for _, name := range tests {
+		testStart := time.Now()
+		err := runTest(machine, fx, name, hooks)
+		elapsed := time.Since(testStart).Seconds()
+		if err != nil {
+			res.Failed++
+			fmt.Fprintf(out, "--- FAIL: %s (%.2fs)\n\t%s\n", name, elapsed, err)
+		} else {
+			res.Passed++
+			fmt.Fprintf(out, "--- PASS: %s (%.2fs)\n", name, elapsed)
+		}
+	}
+
+	status := "ok"
+	if res.Failed > 0 {
+		status = "FAIL"
+	}
+	fmt.Fprintf(out, "%s\t%s\t%.2fs\n", status, file, time.Since(start).Seconds())
+	return res
+}
+
+// runTest runs one test between the setup and teardown hooks. The first
+// error wins; teardown runs whenever setup succeeded. Stubs the test left
+// in place (because it failed inside testing.with_stub) are undone.
+func runTest(machine *vm.VM, fx *fixture, name string, hooks map[string]bool) error {
+	defer fx.end()
+	defer machine.RestoreStubs()
+
+	if hooks["setup"] {
+		if err := call(machine, "setup"); err != nil {
+			return fmt.Errorf("setup: %s", err)
+		}
+	}
+	err := call(machine, name)
+	if hooks["teardown"] {
+		if terr := call(machine, "teardown"); terr != nil && err == nil {
+			err = fmt.Errorf("teardown: %s", terr)
+		}
+	}
+	return err
+}
+
+// call runs fn() in machine, whose globals already define fn.
+func call(machine *vm.VM, fn string) error {
+	p := parser.New(lexer.New(fn + "()"))
+	program := p.ParseProgram()
+	c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), fn)
+	callChunk, _, err := c.Compile(program)
+	if err != nil {
+		return err
+	}
+	err = machine.Interpret(callChunk)
+	var exit *vm.ExitError
+	if errors.As(err, &exit) {
+		return fmt.Errorf("unexpected sys.exit(%d)", exit.Code)
+	}
+	return err
+}
@@ -0,0 +1,333 @@
+package testrunner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleTests = `use testing
+use io
+
+let log: string = ""
+
+func setup() -> void
+    log = log + "s"
+end
+
+func teardown() -> void
+    log = log + "t"
+end
+
+func test_golden() -> void
+    testing.golden("report", "a\nb\n")
+end
+
+func test_temp_dir() -> void
+    let dir: string = testing.temp_dir()
+    let f: File = io.open(dir + "/x.txt", "w")
+    io.write(f, "x")
+    io.close(f)
+    testing.assert(dir == testing.temp_dir(), "temp_dir changed within a test")
+    print("DIR=" + dir)
+end
+
+func test_hooks() -> void
+    testing.assert(log == "ststs", f"unexpected hook order {log}")
+end
+
+func test_fails() -> void
+    testing.fail("boom")
+end
+`
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sample_test.nx")
+	if err := os.WriteFile(file, []byte(sampleTests), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Not a test file, and testdata is never searched
+	os.WriteFile(filepath.Join(dir, "helper.nx"), []byte("print(1)\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "testdata"), 0755)
+	os.WriteFile(filepath.Join(dir, "testdata", "skip_test.nx"), []byte("syntax error here\n"), 0644)
+
+	// Without the golden file, test_golden fails
+	var out bytes.Buffer
+	res, err := Run([]string{dir}, Options{Out: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed != 2 || res.Failed != 2 {
+		t.Fatalf("got %+v, want 2 passed and 2 failed:\n%s", res, out.String())
+	}
+	for _, want := range []string{
+		"--- FAIL: test_golden",
+		"testdata/report.golden does not exist",
+		"--- PASS: test_temp_dir",
+		"--- PASS: test_hooks",
+		"--- FAIL: test_fails",
+		"testing.fail: boom",
+		"FAIL\t" + file,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	// The temporary directory is gone once the test ends
+	for _, line := range strings.Split(out.String(), "\n") {
+		if tmp, ok := strings.CutPrefix(line, "DIR="); ok {
+			if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+				t.Errorf("temp dir %s was not removed", tmp)
+			}
+		}
+	}
+
+	// -update writes the golden file, after which it matches
+	out.Reset()
+	if _, err := Run([]string{file}, Options{Out: &out, Update: true}); err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(filepath.Join(dir, "testdata", "report.golden"))
+	if err != nil || string(golden) != "a\nb\n" {
+		t.Fatalf("golden file = %q, %v", golden, err)
+	}
+	out.Reset()
+	res, _ = Run([]string{file}, Options{Out: &out})
+	if res.Passed != 3 || res.Failed != 1 {
+		t.Fatalf("got %+v after -update:\n%s", res, out.String())
+	}
+
+	// A changed output reports the first differing line
+	os.WriteFile(filepath.Join(dir, "testdata", "report.golden"), []byte("a\nc\n"), 0644)
+	out.Reset()
+	Run([]string{file}, Options{Out: &out})
+	if !strings.Contains(out.String(), "differs at line 2") {
+		t.Errorf("expected a golden diff:\n%s", out.String())
+	}
+}
+
+func TestRunBrokenFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "broken_test.nx")
+	os.WriteFile(file, []byte("func test_x() -> void\n"), 0644)
+
+	var out bytes.Buffer
+	res, err := Run([]string{dir}, Options{Out: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Failed != 1 || !strings.HasPrefix(out.String(), "FAIL\t"+file) {
+		t.Fatalf("got %+v:\n%s", res, out.String())
+	}
+
+	if _, err := Run([]string{t.TempDir()}, Options{Out: &out}); err == nil {
+		t.Error("expected an error for a directory without test files")
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 6; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("f%d_test.nx", i))
+		src := fmt.Sprintf(`use testing
+let id: int = %d
+
+func test_a() -> void
+    print(f"file {id} a")
+    time_sleep(%d)
+    print_err(f"file {id} err")
+end
+
+func test_b() -> void
+    testing.assert(id != 3, "file 3 fails")
+    print(f"file {id} b")
+end
+`, i, 30-5*i)
+		os.WriteFile(file, []byte(src), 0644)
+		files = append(files, file)
+	}
+
+	var out bytes.Buffer
+	res, err := Run([]string{dir}, Options{Out: &out, Parallel: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed != 11 || res.Failed != 1 {
+		t.Fatalf("got %+v:\n%s", res, out.String())
+	}
+
+	// Each file's output is contiguous and files keep their order
+	var want strings.Builder
+	for i, file := range files {
+		fmt.Fprintf(&want, "file %d a\nfile %d err\n--- PASS: test_a", i, i)
+		if i == 3 {
+			fmt.Fprintf(&want, "|--- FAIL: test_b|FAIL\t%s", file)
+		} else {
+			fmt.Fprintf(&want, "|file %d b\n--- PASS: test_b|ok\t%s", i, file)
+		}
+		want.WriteString("|")
+	}
+	pos := 0
+	for _, piece := range strings.Split(want.String(), "|") {
+		if piece == "" {
+			continue
+		}
+		k := strings.Index(out.String()[pos:], piece)
+		if k < 0 {
+			t.Fatalf("output lacks %q after offset %d:\n%s", piece, pos, out.String())
+		}
+		pos += k + len(piece)
+	}
+}
+
+func TestRunRestoresStubs(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "stub_test.nx")
+	os.WriteFile(file, []byte(`use testing
+
+func clock() -> int
+    return time_now()
+end
+
+func test_a_fails_while_stubbed() -> void
+    testing.with_stub("time_now", func() -> int return 7 end, func() -> void
+        testing.assert(clock() == 7, "stub not seen")
+        testing.fail("stop here")
+    end)
+end
+
+func test_b_sees_original() -> void
+    testing.assert(clock() > 7, "stub leaked into the next test")
+end
+`), 0644)
+
+	var out bytes.Buffer
+	res, err := Run([]string{file}, Options{Out: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed != 1 || res.Failed != 1 || !strings.Contains(out.String(), "testing.fail: stop here") {
+		t.Fatalf("got %+v:\n%s", res, out.String())
+	}
+}
+
+func TestRunWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	src := `use testing
+use io
+use sys
+
+func test_relative_paths() -> void
+    testing.assert(sys.chdir("data"), "no data directory")
+    let f: File = io.open("out.txt", "w")
+    io.write(f, "%s")
+    io.close(f)
+end
+`
+	for _, name := range []string{"a", "b"} {
+		os.MkdirAll(filepath.Join(dir, name, "data"), 0755)
+		os.WriteFile(filepath.Join(dir, name, name+"_test.nx"), []byte(fmt.Sprintf(src, name)), 0644)
+	}
+
+	var out bytes.Buffer
+	res, err := Run([]string{dir}, Options{Out: &out, Parallel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed != 2 {
+		t.Fatalf("got %+v:\n%s", res, out.String())
+	}
+	for _, name := range []string{"a", "b"} {
+		got, err := os.ReadFile(filepath.Join(dir, name, "data", "out.txt"))
+		if err != nil || string(got) != name {
+			t.Errorf("%s/data/out.txt = %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestRunExit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "exit_test.nx")
+	os.WriteFile(file, []byte(`use sys
+
+func test_exits() -> void
+    sys.exit(0)
+end
+
+func test_after() -> void
+end
+`), 0644)
+
+	var out bytes.Buffer
+	res, err := Run([]string{file}, Options{Out: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed != 1 || res.Failed != 1 || !strings.Contains(out.String(), "unexpected sys.exit(0)") {
+		t.Fatalf("got %+v:\n%s", res, out.String())
+	}
+}
+
+func TestRunAssertions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "assert_test.nx")
+	os.WriteFile(file, []byte(`use testing
+
+struct Point
+    x: int
+    tags: string[]
+end
+
+func check(n: int) -> void
+    if n < 0 then
+        throw error("negative", 3)
+    end
+end
+
+func test_pass() -> void
+    testing.assert_eq([1, 2], [1, 2.0])
+    testing.assert_eq({"a": [Point(1, ["x"])]}, {"a": [Point(1, ["x"])]})
+    testing.assert_true(1 < 2)
+    let e: error = testing.assert_error(func() -> void
+        check(-1)
+    end, "no error")
+    testing.assert_eq(e, error("negative", 3))
+end
+
+func test_eq() -> void
+    testing.assert_eq(["1"], [1], "lists differ")
+end
+
+func test_true() -> void
+    testing.assert_true(null)
+end
+
+func test_error() -> void
+    testing.assert_error(func() -> void
+        check(1)
+    end, "check accepted 1")
+end
+`), 0644)
+
+	var out bytes.Buffer
+	res, err := Run([]string{file}, Options{Out: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed != 1 || res.Failed != 3 {
+		t.Fatalf("got %+v, want 1 passed and 3 failed:\n%s", res, out.String())
+	}
+	for _, want := range []string{
+		`testing.assert_eq: lists differ: got ["1"], want [1]`,
+		"testing.assert_true: expected true, got null",
+		"check accepted 1",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
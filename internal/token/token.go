@@ -36,6 +36,9 @@ const (
 	WHEN    TokenType = "WHEN"
 	CASE    TokenType = "CASE"
 	DEFAULT TokenType = "DEFAULT"
+	TRY     TokenType = "TRY"
+	CATCH   TokenType = "CATCH"
+	THROW   TokenType = "THROW"
 
 	// Palavras-chave - Tipos
 	TYPE_INT    TokenType = "TYPE_INT"
@@ -59,6 +62,7 @@ const (
 	USE    TokenType = "USE"
 	SELECT TokenType = "SELECT"
 	AS     TokenType = "AS"
+	EMBED  TokenType = "EMBED"
 
 	// Palavras-chave - Especiais
 	ZEROS TokenType = "ZEROS"
@@ -83,6 +87,9 @@ const (
 	OR  TokenType = "OR"  // ||
 	NOT TokenType = "NOT" // !
 
+	// Null-coalescing
+	COALESCE TokenType = "COALESCE" // ??
+
 	// Operadores Bitwise
 	BIT_AND     TokenType = "BIT_AND"     // &
 	BIT_OR      TokenType = "BIT_OR"      // |
@@ -107,6 +114,7 @@ const (
 	COMMA    TokenType = "COMMA"    // ,
 	COLON    TokenType = "COLON"    // :
 	DOT      TokenType = "DOT"      // .
+	DOTDOT   TokenType = "DOTDOT"   // ..
 
 	// Especiais
 	NEWLINE TokenType = "NEWLINE"
@@ -144,6 +152,7 @@ var keywords = map[string]TokenType{
 	"use":     USE,
 	"select":  SELECT,
 	"as":      AS,
+	"embed":   EMBED,
 	"zeros":   ZEROS,
 	"elif":    ELIF,
 	"for":     FOR,
@@ -151,6 +160,9 @@ var keywords = map[string]TokenType{
 	"when":    WHEN,
 	"case":    CASE,
 	"default": DEFAULT,
+	"try":     TRY,
+	"catch":   CATCH,
+	"throw":   THROW,
 }
 
 func LookupIdent(ident string) TokenType {
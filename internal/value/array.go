@@ -0,0 +1,160 @@
+package value
+
+// ElemKind is how an ObjArray stores its elements.
+type ElemKind uint8
+
+const (
+	ElemValues ElemKind = iota // boxed, in Elements
+	ElemInts                   // unboxed, in Ints
+	ElemFloats                 // unboxed, in Floats
+)
+
+func NewIntArray(xs []int64) Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjArray{Ints: xs, Kind: ElemInts}}
+}
+
+func NewFloatArray(xs []float64) Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjArray{Floats: xs, Kind: ElemFloats}}
+}
+
+func (oa *ObjArray) Len() int {
+	switch oa.Kind {
+	case ElemInts:
+		return len(oa.Ints)
+	case ElemFloats:
+		return len(oa.Floats)
+	}
+	return len(oa.Elements)
+}
+
+// At returns element i, which must be in range.
+func (oa *ObjArray) At(i int) Value {
+	switch oa.Kind {
+	case ElemInts:
+		return NewInt(oa.Ints[i])
+	case ElemFloats:
+		return NewFloat(oa.Floats[i])
+	}
+	return oa.Elements[i]
+}
+
+// Set stores v at i, which must be in range. A float array stores an int
+// as a float; a typed array that cannot hold v (a string reaching an
+// int[] through any) becomes generic first.
+func (oa *ObjArray) Set(i int, v Value) {
+	switch {
+	case oa.Kind == ElemInts && v.Type == VAL_INT:
+		oa.Ints[i] = v.AsInt
+	case oa.Kind == ElemFloats && v.Type == VAL_FLOAT:
+		oa.Floats[i] = v.AsFloat
+	case oa.Kind == ElemFloats && v.Type == VAL_INT:
+		oa.Floats[i] = float64(v.AsInt)
+	default:
+		oa.Generic()[i] = v
+	}
+}
+
+// Append adds v at the end, under the same rules as Set.
+func (oa *ObjArray) Append(v Value) {
+	switch {
+	case oa.Kind == ElemInts && v.Type == VAL_INT:
+		oa.Ints = append(oa.Ints, v.AsInt)
+	case oa.Kind == ElemFloats && v.Type == VAL_FLOAT:
+		oa.Floats = append(oa.Floats, v.AsFloat)
+	case oa.Kind == ElemFloats && v.Type == VAL_INT:
+		oa.Floats = append(oa.Floats, float64(v.AsInt))
+	default:
+		oa.Elements = append(oa.Generic(), v)
+	}
+}
+
+// Values returns the elements for reading. For a typed array they are
+// boxed into a new slice, so writes to it are lost: use Generic to change
+// the elements.
+func (oa *ObjArray) Values() []Value {
+	if oa.Kind == ElemValues {
+		return oa.Elements
+	}
+	elems := make([]Value, oa.Len())
+	for i := range elems {
+		elems[i] = oa.At(i)
+	}
+	return elems
+}
+
+// Generic moves the elements of a typed array into Elements for good and
+// returns them.
+func (oa *ObjArray) Generic() []Value {
+	if oa.Kind != ElemValues {
+		oa.Elements = oa.Values()
+		oa.Ints, oa.Floats, oa.Kind = nil, nil, ElemValues
+	}
+	return oa.Elements
+}
+
+// Specialize switches the array to the storage of kind when every element
+// fits it: ints for ElemInts, and ints (converted) or floats for
+// ElemFloats. It reports whether the array now has that kind.
+func (oa *ObjArray) Specialize(kind ElemKind) bool {
+	if oa.Kind == kind {
+		return true
+	}
+	elems := oa.Values()
+	switch kind {
+	case ElemInts:
+		xs := make([]int64, len(elems))
+		for i, e := range elems {
+			if e.Type != VAL_INT {
+				return false
+			}
+			xs[i] = e.AsInt
+		}
+		oa.Ints = xs
+	case ElemFloats:
+		xs := make([]float64, len(elems))
+		for i, e := range elems {
+			switch e.Type {
+			case VAL_FLOAT:
+				xs[i] = e.AsFloat
+			case VAL_INT:
+				xs[i] = float64(e.AsInt)
+			default:
+				return false
+			}
+		}
+		oa.Floats = xs
+	default:
+		oa.Generic()
+		return true
+	}
+	oa.Elements, oa.Kind = nil, kind
+	if kind == ElemInts {
+		oa.Floats = nil
+	} else {
+		oa.Ints = nil
+	}
+	return true
+}
+
+// Slice copies elements start up to end into a new array of the same kind.
+func (oa *ObjArray) Slice(start, end int) *ObjArray {
+	switch oa.Kind {
+	case ElemInts:
+		return &ObjArray{Ints: append([]int64(nil), oa.Ints[start:end]...), Kind: ElemInts}
+	case ElemFloats:
+		return &ObjArray{Floats: append([]float64(nil), oa.Floats[start:end]...), Kind: ElemFloats}
+	}
+	return &ObjArray{Elements: append([]Value(nil), oa.Elements[start:end]...)}
+}
+
+// Truncate keeps the first n elements, n being at most Len.
+func (oa *ObjArray) Truncate(n int) {
+	switch oa.Kind {
+	case ElemInts:
+		oa.Ints = oa.Ints[:n]
+	case ElemFloats:
+		oa.Floats = oa.Floats[:n]
+	default:
+		oa.Elements = oa.Elements[:n]
+	}
+}
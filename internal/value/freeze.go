@@ -0,0 +1,68 @@
+package value
+
+import "fmt"
+
+// Freeze marks v and every array, map, set and instance reachable from it
+// as read-only. The VM rejects writes to frozen values with a runtime
+// error. Freezing is permanent and cycles are handled, since values that
+// are already frozen are not visited again.
+func Freeze(v Value) Value {
+	switch o := v.Obj.(type) {
+	case *ObjArray:
+		if !o.Frozen {
+			o.Frozen = true
+			for _, el := range o.Elements {
+				Freeze(el)
+			}
+		}
+	case *ObjMap:
+		if !o.Frozen {
+			o.Frozen = true
+			for _, k := range o.Keys {
+				Freeze(o.Data[k])
+			}
+		}
+	case *ObjSet:
+		if !o.Frozen {
+			o.Frozen = true
+			for _, k := range o.Keys {
+				Freeze(o.Data[k])
+			}
+		}
+	case *ObjInstance:
+		if !o.Frozen {
+			o.Frozen = true
+			for _, f := range o.Fields {
+				Freeze(f)
+			}
+		}
+	}
+	return v
+}
+
+// IsFrozen reports whether v is a frozen array, map, set or instance.
+// Other values are immutable already and report false.
+func IsFrozen(v Value) bool {
+	switch o := v.Obj.(type) {
+	case *ObjArray:
+		return o.Frozen
+	case *ObjMap:
+		return o.Frozen
+	case *ObjSet:
+		return o.Frozen
+	case *ObjInstance:
+		return o.Frozen
+	}
+	return false
+}
+
+// CheckMutable returns an error naming the value's type when v is frozen.
+func CheckMutable(v Value) error {
+	if !IsFrozen(v) {
+		return nil
+	}
+	if inst, ok := v.Obj.(*ObjInstance); ok {
+		return fmt.Errorf("cannot modify frozen %s instance", inst.Struct.Name)
+	}
+	return fmt.Errorf("cannot modify frozen %s", TypeName(v))
+}
@@ -0,0 +1,189 @@
+package value
+
+import (
+	"fmt"
+	"math"
+)
+
+// Map keys and set elements are stored under a comparable Go value derived
+// from the Noxy value:
+//
+//	int, integral float  int64 (so 1 and 1.0 are the same key, as 1 == 1.0)
+//	other floats         float64 (NaN is rejected: it is not equal to itself)
+//	string               string
+//	bool                 bool
+//	bytes                BytesKey
+//	struct instance      InstanceKey (compared field by field)
+//
+// Arrays, maps, sets, buffers, functions and null are not hashable.
+
+// BytesKey keeps bytes keys distinct from string keys with the same text.
+type BytesKey string
+
+// InstanceKey identifies a struct instance by its definition and the keys
+// of its field values, so two instances with equal fields are the same key.
+// The key is a snapshot: changing the instance later does not move it.
+type InstanceKey struct {
+	Struct *ObjStruct
+	Fields interface{} // keyList of field keys in declaration order, or nil
+}
+
+// keyList is a comparable linked list of keys.
+type keyList struct {
+	Head interface{}
+	Tail interface{} // keyList or nil
+}
+
+// nullKey stands for a null field inside an InstanceKey.
+type nullKey struct{}
+
+// Boxing an int64 as an interface{} allocates unless it is below 256, so
+// the keys of [minIntKey, maxIntKey), common as counters and ids, are
+// boxed once here.
+const (
+	minIntKey = -128
+	maxIntKey = 4096
+)
+
+var intKeys = func() []interface{} {
+	keys := make([]interface{}, maxIntKey-minIntKey)
+	for i := range keys {
+		keys[i] = int64(i + minIntKey)
+	}
+	return keys
+}()
+
+func intKey(n int64) interface{} {
+	if n >= minIntKey && n < maxIntKey {
+		return intKeys[n-minIntKey]
+	}
+	return n
+}
+
+// HashKey returns the Go map key for v, or an error naming the value's type
+// when it cannot be used as a key.
+func (v Value) HashKey() (interface{}, error) {
+	switch v.Type {
+	case VAL_INT:
+		return intKey(v.AsInt), nil
+	case VAL_FLOAT:
+		f := v.AsFloat
+		if math.IsNaN(f) {
+			return nil, fmt.Errorf("NaN cannot be used as a key")
+		}
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return intKey(int64(f)), nil
+		}
+		return f, nil
+	case VAL_BOOL:
+		return v.AsBool, nil
+	case VAL_BYTES:
+		return BytesKey(v.Obj.(string)), nil
+	case VAL_OBJ:
+		switch o := v.Obj.(type) {
+		case string:
+			// v.Obj already holds the string; returning o would box it again
+			return v.Obj, nil
+		case *ObjInstance:
+			var fields interface{}
+			for i := len(o.Struct.Fields) - 1; i >= 0; i-- {
+				field := o.Fields[i]
+				var key interface{} = nullKey{}
+				if field.Type != VAL_NULL {
+					var err error
+					if key, err = field.HashKey(); err != nil {
+						return nil, fmt.Errorf("%s key: field %s: %w", o.Struct.Name, o.Struct.Fields[i], err)
+					}
+				}
+				fields = keyList{Head: key, Tail: fields}
+			}
+			return InstanceKey{Struct: o.Struct, Fields: fields}, nil
+		}
+	}
+	return nil, fmt.Errorf("unhashable type %s (keys must be int, float, string, bool, bytes or a struct of those)", TypeName(v))
+}
+
+// KeyValue converts a key produced by HashKey back into a value. Instance
+// keys produce a new instance.
+func KeyValue(key interface{}) Value {
+	switch k := key.(type) {
+	case int64:
+		return NewInt(k)
+	case float64:
+		return NewFloat(k)
+	case string:
+		return NewString(k)
+	case bool:
+		return NewBool(k)
+	case BytesKey:
+		return NewBytes(string(k))
+	case InstanceKey:
+		inst := NewInstance(k.Struct)
+		fields := inst.Obj.(*ObjInstance).Fields
+		list := k.Fields
+		for i := range fields {
+			node, ok := list.(keyList)
+			if !ok {
+				continue
+			}
+			fields[i] = KeyValue(node.Head)
+			list = node.Tail
+		}
+		return inst
+	}
+	return NewNull()
+}
+
+// TypeName names v's type the way error messages show it to scripts.
+func TypeName(v Value) string {
+	switch v.Type {
+	case VAL_BOOL:
+		return "bool"
+	case VAL_NULL:
+		return "null"
+	case VAL_INT:
+		return "int"
+	case VAL_FLOAT:
+		return "float"
+	case VAL_BYTES:
+		return "bytes"
+	case VAL_FUNCTION, VAL_NATIVE:
+		return "function"
+	case VAL_CHANNEL:
+		return "channel"
+	case VAL_WAITGROUP:
+		return "waitgroup"
+	case VAL_REF:
+		return "ref"
+	case VAL_OBJ:
+		switch o := v.Obj.(type) {
+		case string:
+			return "string"
+		case *ObjArray:
+			return "array"
+		case *ObjMap:
+			return "map"
+		case *ObjSet:
+			return "set"
+		case *ObjBuffer:
+			return "buffer"
+		case *ObjError:
+			return "error"
+		case *ObjStringBuilder:
+			return "string_builder"
+		case *ObjImage:
+			return "image"
+		case *ObjNDArray:
+			return "ndarray"
+		case *ObjBigInt:
+			return "bigint"
+		case *ObjDecimal:
+			return "decimal"
+		case *ObjInstance:
+			return o.Struct.Name + " instance"
+		case *ObjStruct:
+			return "struct " + o.Name
+		}
+	}
+	return fmt.Sprintf("%T", v.Obj)
+}
@@ -0,0 +1,110 @@
+package value
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Inspect formats v for display. Collections and struct instances are
+// printed recursively with field names; strings nested inside them are
+// quoted. With a non-empty indent each element goes on its own line.
+// Containers that (directly or indirectly) contain themselves print as
+// <cycle> instead of recursing forever.
+func Inspect(v Value, indent string) string {
+	p := &printer{indent: indent, seen: make(map[interface{}]bool)}
+	p.value(v, 0, false)
+	return p.sb.String()
+}
+
+type printer struct {
+	sb     strings.Builder
+	indent string
+	seen   map[interface{}]bool // containers on the current path
+}
+
+func (p *printer) value(v Value, depth int, nested bool) {
+	if v.Type != VAL_OBJ {
+		p.sb.WriteString(v.String())
+		return
+	}
+	switch o := v.Obj.(type) {
+	case string:
+		if nested {
+			p.sb.WriteString(strconv.Quote(o))
+		} else {
+			p.sb.WriteString(o)
+		}
+	case *ObjArray:
+		if p.enter(o) {
+			p.list("[", "]", o.Len(), depth, func(i int) {
+				p.value(o.At(i), depth+1, true)
+			})
+			delete(p.seen, o)
+		}
+	case *ObjSet:
+		if p.enter(o) {
+			p.list("set{", "}", len(o.Keys), depth, func(i int) {
+				p.value(o.Data[o.Keys[i]], depth+1, true)
+			})
+			delete(p.seen, o)
+		}
+	case *ObjMap:
+		if p.enter(o) {
+			p.list("{", "}", len(o.Keys), depth, func(i int) {
+				k := o.Keys[i]
+				p.value(KeyValue(k), depth+1, true)
+				p.sb.WriteString(": ")
+				p.value(o.Data[k], depth+1, true)
+			})
+			delete(p.seen, o)
+		}
+	case *ObjInstance:
+		if p.enter(o) {
+			p.list(o.Struct.Name+"(", ")", len(o.Struct.Fields), depth, func(i int) {
+				name := o.Struct.Fields[i]
+				p.sb.WriteString(name)
+				p.sb.WriteString(": ")
+				p.value(o.Fields[i], depth+1, true)
+			})
+			delete(p.seen, o)
+		}
+	default:
+		p.sb.WriteString(v.String())
+	}
+}
+
+// enter marks a container as being printed, or writes <cycle> if it
+// already is.
+func (p *printer) enter(container interface{}) bool {
+	if p.seen[container] {
+		p.sb.WriteString("<cycle>")
+		return false
+	}
+	p.seen[container] = true
+	return true
+}
+
+func (p *printer) list(open, close string, n int, depth int, item func(i int)) {
+	p.sb.WriteString(open)
+	if n == 0 {
+		p.sb.WriteString(close)
+		return
+	}
+	for i := 0; i < n; i++ {
+		if p.indent != "" {
+			p.sb.WriteString("\n")
+			p.sb.WriteString(strings.Repeat(p.indent, depth+1))
+		} else if i > 0 {
+			p.sb.WriteString(" ")
+		}
+		item(i)
+		if i < n-1 {
+			p.sb.WriteString(",")
+		}
+	}
+	if p.indent != "" {
+		p.sb.WriteString("\n")
+		p.sb.WriteString(strings.Repeat(p.indent, depth))
+	}
+	p.sb.WriteString(close)
+}
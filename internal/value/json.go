@@ -0,0 +1,99 @@
+package value
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ToJSON converts v into the Go value encoding/json marshals for it:
+// maps and instances become objects, arrays and sets arrays, and big
+// numbers json.Number.
+func ToJSON(v Value) interface{} {
+	switch v.Type {
+	case VAL_NULL:
+		return nil
+	case VAL_BOOL:
+		return v.AsBool
+	case VAL_INT:
+		return v.AsInt
+	case VAL_FLOAT:
+		return v.AsFloat
+	case VAL_OBJ:
+		switch o := v.Obj.(type) {
+		case string:
+			return o
+		case *ObjArray:
+			arr := make([]interface{}, o.Len())
+			for i, el := range o.Values() {
+				arr[i] = ToJSON(el)
+			}
+			return arr
+		case *ObjMap:
+			m := make(map[string]interface{})
+			for k, val := range o.Data {
+				m[KeyValue(k).String()] = ToJSON(val)
+			}
+			return m
+		case *ObjInstance:
+			m := make(map[string]interface{})
+			for i, val := range o.Fields {
+				m[o.Struct.Fields[i]] = ToJSON(val)
+			}
+			return m
+		case *ObjStruct:
+			return o.Name
+		case *ObjBuffer:
+			return string(o.Data)
+		case *ObjError:
+			return map[string]interface{}{"message": o.Message, "code": o.Code}
+		case *ObjBigInt:
+			return json.Number(o.Value.String())
+		case *ObjDecimal:
+			return json.Number(o.String())
+		case *ObjSet:
+			arr := make([]interface{}, 0, o.Len())
+			for _, el := range o.Values() {
+				arr = append(arr, ToJSON(el))
+			}
+			return arr
+		}
+	case VAL_BYTES:
+		// Base64 encode bytes? Or generic string?
+		return v.Obj.(string)
+	}
+	return v.String()
+}
+
+// FromJSON converts a value decoded by encoding/json into a Noxy value.
+// Whole numbers become ints and object keys are sorted.
+func FromJSON(i interface{}) Value {
+	if i == nil {
+		return NewNull()
+	}
+	switch v := i.(type) {
+	case bool:
+		return NewBool(v)
+	case float64:
+		// JSON numbers are float64 by default
+		// Try to see if it's an int
+		if v == float64(int64(v)) {
+			return NewInt(int64(v))
+		}
+		return NewFloat(v)
+	case string:
+		return NewString(v)
+	case []interface{}:
+		arr := make([]Value, len(v))
+		for idx, el := range v {
+			arr[idx] = FromJSON(el)
+		}
+		return NewArray(arr)
+	case map[string]interface{}:
+		m := make(map[string]Value)
+		for k, val := range v {
+			m[k] = FromJSON(val)
+		}
+		return NewMapWithData(m)
+	}
+	return NewString(fmt.Sprintf("%v", i))
+}
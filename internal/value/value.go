@@ -2,7 +2,16 @@ package value
 
 import (
 	"fmt"
+	"image"
+	"math"
+	"math/big"
+	"noxy-vm/internal/ndarray"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 )
 
 type ValueType int
@@ -69,59 +78,417 @@ type ObjNative struct {
 	Fn   NativeFunc
 }
 
+// NativeError is carried by the value returned from NewNativeError. The VM
+// turns it into a runtime error at the call site instead of pushing it.
+type NativeError struct {
+	Message string
+}
+
+func (ne *NativeError) Error() string {
+	return ne.Message
+}
+
+// NewNativeError lets a native fail the call with a runtime error.
+func NewNativeError(format string, args ...interface{}) Value {
+	return Value{Type: VAL_NULL, Obj: &NativeError{Message: fmt.Sprintf(format, args...)}}
+}
+
+// ObjError is a value of the error type: a message and a code, made by
+// error(message, code) and returned by natives that fail. A try block's
+// catch receives one too.
+type ObjError struct {
+	Message string
+	Code    int64
+}
+
+func (oe *ObjError) String() string {
+	if oe.Code != 0 {
+		return fmt.Sprintf("error: %s (code %d)", oe.Message, oe.Code)
+	}
+	return "error: " + oe.Message
+}
+
+// NewError returns an error value.
+func NewError(message string, code int64) Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjError{Message: message, Code: code}}
+}
+
+// Errorf returns an error value with code 0, for natives to return when
+// they fail.
+func Errorf(format string, args ...interface{}) Value {
+	return NewError(fmt.Sprintf(format, args...), 0)
+}
+
+// IsError reports whether v is an error value.
+func IsError(v Value) bool {
+	_, ok := v.Obj.(*ObjError)
+	return ok && v.Type == VAL_OBJ
+}
+
 type ObjArray struct {
 	Elements []Value
+	// An array declared int[] or float[] keeps its elements unboxed in
+	// Ints or Floats instead, as Kind says, and Elements is nil. Code that
+	// is not specialized for them reads through Values or At, and code that
+	// changes or keeps the elements calls Generic first.
+	Ints   []int64
+	Floats []float64
+	Kind   ElemKind
+	Frozen bool
 }
 
 func (oa *ObjArray) String() string {
-	s := "["
-	for i, e := range oa.Elements {
-		// Avoid infinite recursion if element is self
-		if e.Type == VAL_OBJ {
-			if arr, ok := e.Obj.(*ObjArray); ok && arr == oa {
-				s += "<cycle>"
+	return Inspect(Value{Type: VAL_OBJ, Obj: oa}, "")
+}
+
+func (oa *ObjArray) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'T':
+		fmt.Fprint(f, "array")
+	case 's', 'v':
+		fmt.Fprint(f, oa.String())
+	default:
+		fmt.Fprintf(f, "%%!%c(*ObjArray=%s)", verb, oa.String())
+	}
+}
+
+// ObjBuffer is a mutable, growable byte sequence. Unlike bytes values,
+// which are immutable, it supports in-place writes and amortized appends.
+type ObjBuffer struct {
+	Data []byte
+}
+
+func (ob *ObjBuffer) String() string {
+	return fmt.Sprintf("buffer(b\"%s\")", ob.Data)
+}
+
+func (ob *ObjBuffer) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'T':
+		fmt.Fprint(f, "buffer")
+	case 's', 'v':
+		fmt.Fprint(f, ob.String())
+	case 'x':
+		fmt.Fprintf(f, "%x", ob.Data)
+	default:
+		fmt.Fprintf(f, "%%!%c(*ObjBuffer=%s)", verb, ob.String())
+	}
+}
+
+// ObjStringBuilder accumulates string pieces without the quadratic cost of
+// repeated concatenation.
+type ObjStringBuilder struct {
+	Builder strings.Builder
+}
+
+func (sb *ObjStringBuilder) String() string {
+	return fmt.Sprintf("<string builder len=%d>", sb.Builder.Len())
+}
+
+func (sb *ObjStringBuilder) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'T':
+		fmt.Fprint(f, "string_builder")
+	default:
+		fmt.Fprint(f, sb.String())
+	}
+}
+
+// ObjImage is a mutable RGBA image that the image natives draw on.
+type ObjImage struct {
+	Image *image.RGBA
+}
+
+func (oi *ObjImage) String() string {
+	b := oi.Image.Bounds()
+	return fmt.Sprintf("<image %dx%d>", b.Dx(), b.Dy())
+}
+
+func (oi *ObjImage) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'T':
+		fmt.Fprint(f, "image")
+	default:
+		fmt.Fprint(f, oi.String())
+	}
+}
+
+// ObjNDArray is a mutable n-dimensional array of floats, stored unboxed so
+// that numeric code does not pay for a Value per element.
+type ObjNDArray struct {
+	Array *ndarray.Array
+}
+
+func (on *ObjNDArray) String() string {
+	return "ndarray(" + on.Array.Format(FormatFloat) + ")"
+}
+
+func (on *ObjNDArray) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'T':
+		fmt.Fprint(f, "ndarray")
+	default:
+		fmt.Fprint(f, on.String())
+	}
+}
+
+// ObjBigInt is an arbitrary-precision integer. It is immutable: arithmetic
+// always produces a new value.
+type ObjBigInt struct {
+	Value *big.Int
+}
+
+func (ob *ObjBigInt) String() string {
+	return ob.Value.String()
+}
+
+func (ob *ObjBigInt) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'T':
+		fmt.Fprint(f, "bigint")
+	case 'x':
+		fmt.Fprint(f, ob.Value.Text(16))
+	default:
+		fmt.Fprint(f, ob.String())
+	}
+}
+
+// ObjDecimal is an exact base-10 number for money and other quantities
+// float64 cannot represent. Value always has at most Scale fractional
+// digits; Scale is also the number of digits printed.
+type ObjDecimal struct {
+	Value *big.Rat
+	Scale int
+}
+
+func (od *ObjDecimal) String() string {
+	return od.Value.FloatString(od.Scale)
+}
+
+func (od *ObjDecimal) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'T':
+		fmt.Fprint(f, "decimal")
+	default:
+		fmt.Fprint(f, od.String())
+	}
+}
+
+// Rounding modes accepted by RoundRat.
+var RoundingModes = []string{"half_even", "half_up", "half_down", "up", "down", "ceil", "floor"}
+
+// RoundRat rounds r to places fractional digits. "up" and "down" round away
+// from and toward zero; "ceil" and "floor" toward positive and negative
+// infinity.
+func RoundRat(r *big.Rat, places int, mode string) (*big.Rat, error) {
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(pow))
+	q, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		// Compare twice the remainder with the denominator to find halves
+		twice := new(big.Int).Abs(rem)
+		twice.Lsh(twice, 1)
+		half := twice.Cmp(scaled.Denom())
+		negative := scaled.Sign() < 0
+
+		away := false
+		switch mode {
+		case "half_even":
+			away = half > 0 || (half == 0 && q.Bit(0) == 1)
+		case "half_up":
+			away = half >= 0
+		case "half_down":
+			away = half > 0
+		case "up":
+			away = true
+		case "down":
+			away = false
+		case "ceil":
+			away = !negative
+		case "floor":
+			away = negative
+		default:
+			return nil, fmt.Errorf("unknown rounding mode %q", mode)
+		}
+		if away {
+			if negative {
+				q.Sub(q, big.NewInt(1))
 			} else {
-				s += e.String()
+				q.Add(q, big.NewInt(1))
+			}
+		}
+	}
+	return new(big.Rat).SetFrac(q, pow), nil
+}
+
+// ParseDecimal parses "12", "-0.50" or "1.5e3". The scale is the number of
+// fractional digits written.
+func ParseDecimal(s string) (Value, bool) {
+	s = strings.TrimSpace(s)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.ContainsAny(s, "/") {
+		return Value{}, false
+	}
+	mantissa, exp := strings.ToLower(s), 0
+	if i := strings.Index(mantissa, "e"); i >= 0 {
+		exp, _ = strconv.Atoi(mantissa[i+1:])
+		mantissa = mantissa[:i]
+	}
+	written := 0
+	if i := strings.Index(mantissa, "."); i >= 0 {
+		written = len(mantissa) - i - 1
+	}
+	return NewDecimal(r, MinScale(r, written-exp)), true
+}
+
+// MinScale returns the fewest fractional digits (at least min) that
+// represent r exactly, or -1 if r has no finite decimal expansion.
+func MinScale(r *big.Rat, min int) int {
+	den := new(big.Int).Set(r.Denom())
+	scale := 0
+	for _, p := range []int64{2, 5} {
+		n, m := 0, new(big.Int)
+		for {
+			q, rem := new(big.Int).QuoRem(den, big.NewInt(p), m)
+			if rem.Sign() != 0 {
+				break
 			}
-		} else {
-			s += e.String()
+			den = q
+			n++
+		}
+		if n > scale {
+			scale = n
 		}
+	}
+	if den.Cmp(big.NewInt(1)) != 0 {
+		return -1
+	}
+	if scale < min {
+		return min
+	}
+	return scale
+}
 
-		if i < len(oa.Elements)-1 {
-			s += ", "
+// ObjSet is an unordered collection of unique hashable values with O(1)
+// membership. Like maps, it remembers insertion order for printing and
+// conversion to arrays.
+type ObjSet struct {
+	Data   map[interface{}]Value
+	Keys   []interface{}
+	Frozen bool
+}
+
+// Add inserts v, reporting whether it was new. Values that cannot be
+// hashed (see HashKey) are rejected with an error.
+func (os *ObjSet) Add(v Value) (bool, error) {
+	key, err := v.HashKey()
+	if err != nil {
+		return false, err
+	}
+	if _, exists := os.Data[key]; exists {
+		return false, nil
+	}
+	os.Data[key] = v
+	os.Keys = append(os.Keys, key)
+	return true, nil
+}
+
+func (os *ObjSet) Contains(v Value) bool {
+	key, err := v.HashKey()
+	if err != nil {
+		return false
+	}
+	_, exists := os.Data[key]
+	return exists
+}
+
+func (os *ObjSet) Remove(v Value) bool {
+	key, err := v.HashKey()
+	if err != nil {
+		return false
+	}
+	if _, exists := os.Data[key]; !exists {
+		return false
+	}
+	delete(os.Data, key)
+	for i, k := range os.Keys {
+		if k == key {
+			os.Keys = append(os.Keys[:i], os.Keys[i+1:]...)
+			break
 		}
 	}
-	s += "]"
-	return s
+	return true
 }
 
-func (oa *ObjArray) Format(f fmt.State, verb rune) {
+func (os *ObjSet) Len() int {
+	return len(os.Keys)
+}
+
+// Values returns the elements in insertion order.
+func (os *ObjSet) Values() []Value {
+	vals := make([]Value, len(os.Keys))
+	for i, k := range os.Keys {
+		vals[i] = os.Data[k]
+	}
+	return vals
+}
+
+func (os *ObjSet) String() string {
+	return Inspect(Value{Type: VAL_OBJ, Obj: os}, "")
+}
+
+func (os *ObjSet) Format(f fmt.State, verb rune) {
 	switch verb {
 	case 'T':
-		fmt.Fprint(f, "array")
+		fmt.Fprint(f, "set")
 	case 's', 'v':
-		fmt.Fprint(f, oa.String())
+		fmt.Fprint(f, os.String())
 	default:
-		fmt.Fprintf(f, "%%!%c(*ObjArray=%s)", verb, oa.String())
+		fmt.Fprintf(f, "%%!%c(*ObjSet=%s)", verb, os.String())
 	}
 }
 
+// ObjMap keeps its keys in insertion order so printing and iteration are
+// reproducible. Data may be read directly, but writes and deletes must go
+// through Set and Delete to keep Keys in sync.
 type ObjMap struct {
-	Data map[interface{}]Value
+	Data   map[interface{}]Value
+	Keys   []interface{}
+	Frozen bool
 }
 
-func (om *ObjMap) String() string {
-	s := "{"
-	i := 0
-	for k, v := range om.Data {
-		s += fmt.Sprintf("%v: %s", k, v.String())
-		if i < len(om.Data)-1 {
-			s += ", "
+func (om *ObjMap) Get(key interface{}) (Value, bool) {
+	v, ok := om.Data[key]
+	return v, ok
+}
+
+// Set stores a value. New keys are appended; existing keys keep their position.
+func (om *ObjMap) Set(key interface{}, val Value) {
+	if _, exists := om.Data[key]; !exists {
+		om.Keys = append(om.Keys, key)
+	}
+	om.Data[key] = val
+}
+
+func (om *ObjMap) Delete(key interface{}) {
+	if _, exists := om.Data[key]; !exists {
+		return
+	}
+	delete(om.Data, key)
+	for i, k := range om.Keys {
+		if k == key {
+			om.Keys = append(om.Keys[:i], om.Keys[i+1:]...)
+			break
 		}
-		i++
 	}
-	s += "}"
-	return s
+}
+
+func (om *ObjMap) Len() int {
+	return len(om.Keys)
+}
+
+func (om *ObjMap) String() string {
+	return Inspect(Value{Type: VAL_OBJ, Obj: om}, "")
 }
 
 func (om *ObjMap) Format(f fmt.State, verb rune) {
@@ -136,8 +503,23 @@ func (om *ObjMap) Format(f fmt.State, verb rune) {
 }
 
 type ObjStruct struct {
-	Name   string
-	Fields []string
+	Name       string
+	Fields     []string
+	FieldTypes []string // declared type of each field ("int", "Address[]"); nil if unknown
+	// Methods are attached when the struct statement runs (OP_METHOD)
+	Methods map[string]*ObjClosure
+	// slots maps each field name to its index in Fields, which is also
+	// where every instance keeps that field
+	slots map[string]int
+}
+
+// Slot returns the index of the field called name, or -1 if the struct
+// has no such field.
+func (os *ObjStruct) Slot(name string) int {
+	if i, ok := os.slots[name]; ok {
+		return i
+	}
+	return -1
 }
 
 func (os *ObjStruct) String() string {
@@ -157,11 +539,40 @@ func (os *ObjStruct) Format(f fmt.State, verb rune) {
 
 type ObjInstance struct {
 	Struct *ObjStruct
-	Fields map[string]Value
+	// Fields holds one value per field of Struct, in the order of
+	// Struct.Fields
+	Fields []Value
+	Frozen bool
+}
+
+// Get returns the field called name, and false if the struct has no such
+// field.
+func (oi *ObjInstance) Get(name string) (Value, bool) {
+	if i := oi.Struct.Slot(name); i >= 0 {
+		return oi.Fields[i], true
+	}
+	return Value{}, false
+}
+
+// Field returns the field called name, or the zero Value if there is none.
+func (oi *ObjInstance) Field(name string) Value {
+	v, _ := oi.Get(name)
+	return v
+}
+
+// Set stores v in the field called name, and reports false if the struct
+// has no such field.
+func (oi *ObjInstance) Set(name string, v Value) bool {
+	i := oi.Struct.Slot(name)
+	if i < 0 {
+		return false
+	}
+	oi.Fields[i] = v
+	return true
 }
 
 func (oi *ObjInstance) String() string {
-	return fmt.Sprintf("<%s instance>", oi.Struct.Name)
+	return Inspect(Value{Type: VAL_OBJ, Obj: oi}, "")
 }
 
 func (oi *ObjInstance) Format(f fmt.State, verb rune) {
@@ -197,7 +608,19 @@ func (oc *ObjChannel) Format(f fmt.State, verb rune) {
 }
 
 type ObjWaitGroup struct {
-	Wg *sync.WaitGroup
+	Wg    *sync.WaitGroup
+	count atomic.Int64 // mirrors Wg's counter, which panics below zero
+}
+
+// Add adds delta to the counter, or reports false and leaves it unchanged
+// when that would make it negative.
+func (ow *ObjWaitGroup) Add(delta int) bool {
+	if ow.count.Add(int64(delta)) < 0 {
+		ow.count.Add(-int64(delta))
+		return false
+	}
+	ow.Wg.Add(delta)
+	return true
 }
 
 func (ow *ObjWaitGroup) String() string {
@@ -260,6 +683,24 @@ func (or *ObjRef) Format(f fmt.State, verb rune) {
 	}
 }
 
+// FormatFloat returns the shortest representation that parses back to f,
+// always with a fractional part ("1.5", "2.0") so floats stay
+// distinguishable from ints. Very large and very small magnitudes use
+// exponent notation.
+func FormatFloat(f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-4 || abs >= 1e16) {
+		return strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
 func (v Value) String() string {
 	switch v.Type {
 	case VAL_BOOL:
@@ -269,13 +710,25 @@ func (v Value) String() string {
 	case VAL_INT:
 		return fmt.Sprintf("%d", v.AsInt)
 	case VAL_FLOAT:
-		return fmt.Sprintf("%f", v.AsFloat)
+		return FormatFloat(v.AsFloat)
 	case VAL_OBJ:
 		switch o := v.Obj.(type) {
 		case *ObjArray:
 			return o.String()
 		case *ObjMap:
 			return o.String()
+		case *ObjBuffer:
+			return o.String()
+		case *ObjError:
+			return o.String()
+		case *ObjSet:
+			return o.String()
+		case *ObjBigInt:
+			return o.String()
+		case *ObjDecimal:
+			return o.String()
+		case *ObjStringBuilder:
+			return o.String()
 		case *ObjStruct:
 			return o.String()
 		case *ObjInstance:
@@ -326,32 +779,100 @@ func NewNull() Value {
 	return Value{Type: VAL_NULL}
 }
 
+// NewString wraps v. Storing a string in Obj allocates, except for the
+// one-byte strings, which come from a table: loops that take a string
+// apart character by character make these constantly.
 func NewString(v string) Value {
+	if len(v) == 1 {
+		return byteStrings[v[0]]
+	}
 	return Value{Type: VAL_OBJ, Obj: v}
 }
 
+// NewChar returns the one-character string r, without allocating for
+// ASCII characters.
+func NewChar(r rune) Value {
+	if r >= 0 && r < utf8.RuneSelf {
+		return byteStrings[r]
+	}
+	return Value{Type: VAL_OBJ, Obj: string(r)}
+}
+
+// RuneAt returns character idx of s, counting characters rather than
+// bytes as string indexing does, without converting s to []rune.
+func RuneAt(s string, idx int) (rune, bool) {
+	if idx < 0 {
+		return 0, false
+	}
+	for _, r := range s {
+		if idx == 0 {
+			return r, true
+		}
+		idx--
+	}
+	return 0, false
+}
+
+// byteStrings holds the one-byte strings, ready to use. Ints, floats,
+// bools and null need no such table: they live in the Value itself.
+var byteStrings [256]Value
+
+func init() {
+	for i := range byteStrings {
+		byteStrings[i] = Value{Type: VAL_OBJ, Obj: string([]byte{byte(i)})}
+	}
+}
+
 func NewArray(elements []Value) Value {
 	return Value{Type: VAL_OBJ, Obj: &ObjArray{Elements: elements}}
 }
 
+func NewBigInt(n *big.Int) Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjBigInt{Value: n}}
+}
+
+func NewDecimal(r *big.Rat, scale int) Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjDecimal{Value: r, Scale: scale}}
+}
+
+func NewSet() Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjSet{Data: make(map[interface{}]Value)}}
+}
+
 func NewMap() Value {
 	return Value{Type: VAL_OBJ, Obj: &ObjMap{Data: make(map[interface{}]Value)}}
 }
 
+// NewMapWithData builds a map from a Go map. Go maps are unordered, so the
+// keys are inserted in sorted order.
 func NewMapWithData(data map[string]Value) Value {
-	m := make(map[interface{}]Value)
-	for k, v := range data {
-		m[k] = v
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
 	}
-	return Value{Type: VAL_OBJ, Obj: &ObjMap{Data: m}}
+	sort.Strings(keys)
+	m := &ObjMap{Data: make(map[interface{}]Value, len(data)), Keys: make([]interface{}, 0, len(data))}
+	for _, k := range keys {
+		m.Set(k, data[k])
+	}
+	return Value{Type: VAL_OBJ, Obj: m}
 }
 
 func NewStruct(name string, fields []string) Value {
-	return Value{Type: VAL_OBJ, Obj: &ObjStruct{Name: name, Fields: fields}}
+	slots := make(map[string]int, len(fields))
+	for i, f := range fields {
+		slots[f] = i
+	}
+	return Value{Type: VAL_OBJ, Obj: &ObjStruct{Name: name, Fields: fields, slots: slots}}
 }
 
+// NewInstance makes an instance of def with every field null.
 func NewInstance(def *ObjStruct) Value {
-	return Value{Type: VAL_OBJ, Obj: &ObjInstance{Struct: def, Fields: make(map[string]Value)}}
+	fields := make([]Value, len(def.Fields))
+	for i := range fields {
+		fields[i] = NewNull()
+	}
+	return Value{Type: VAL_OBJ, Obj: &ObjInstance{Struct: def, Fields: fields}}
 }
 
 func NewFunction(name string, arity int, upvalueCount int, params []ParamInfo, chunk interface{}, globals map[string]Value) Value {
@@ -379,6 +900,18 @@ func NewBytes(v string) Value {
 	return Value{Type: VAL_BYTES, Obj: v}
 }
 
+func NewBuffer(data []byte) Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjBuffer{Data: data}}
+}
+
+func NewImage(img *image.RGBA) Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjImage{Image: img}}
+}
+
+func NewNDArray(a *ndarray.Array) Value {
+	return Value{Type: VAL_OBJ, Obj: &ObjNDArray{Array: a}}
+}
+
 func NewChannel(size int) Value {
 	return Value{Type: VAL_CHANNEL, Obj: &ObjChannel{Chan: make(chan Value, size)}}
 }
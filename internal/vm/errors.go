@@ -0,0 +1,115 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"noxy-vm/internal/chunk"
+	"noxy-vm/internal/value"
+)
+
+// tryHandler is a try block being run: where its catch block starts, and
+// the frame and stack height to go back to when the block fails.
+type tryHandler struct {
+	frameCount int
+	stackTop   int
+	catchIP    int
+}
+
+// defineErrorNatives adds error(message, code) and is_error(v).
+func (vm *VM) defineErrorNatives() {
+	vm.DefineNative("error", func(args []value.Value) value.Value {
+		if len(args) < 1 || len(args) > 2 {
+			return value.NewNativeError("expects a message and an optional code")
+		}
+		message, ok := args[0].Obj.(string)
+		if !ok || args[0].Type != value.VAL_OBJ {
+			return value.NewNativeError("message must be a string, got %s", value.TypeName(args[0]))
+		}
+		var code int64
+		if len(args) == 2 {
+			if args[1].Type != value.VAL_INT {
+				return value.NewNativeError("code must be an int, got %s", value.TypeName(args[1]))
+			}
+			code = args[1].AsInt
+		}
+		return value.NewError(message, code)
+	})
+	vm.DefineNative("is_error", func(args []value.Value) value.Value {
+		if len(args) != 1 {
+			return value.NewNativeError("expects one argument")
+		}
+		return value.NewBool(value.IsError(args[0]))
+	})
+}
+
+// errorMember reads message or code from an error value.
+func (vm *VM) errorMember(c *chunk.Chunk, ip int, e *value.ObjError, name string) (value.Value, error) {
+	switch name {
+	case "message":
+		return value.NewString(e.Message), nil
+	case "code":
+		return value.NewInt(e.Code), nil
+	}
+	return value.Value{}, vm.runtimeError(c, ip, "error has no member '%s'%s", name, didYouMean(name, []string{"message", "code"}))
+}
+
+// throw raises v, an error or a string, as a runtime error. A catch block
+// receives the same error value.
+func (vm *VM) throw(c *chunk.Chunk, ip int, v value.Value) error {
+	e, ok := v.Obj.(*value.ObjError)
+	if !ok {
+		s, isString := v.Obj.(string)
+		if !isString || v.Type != value.VAL_OBJ {
+			return vm.runtimeError(c, ip, "throw expects an error or a string, got %s", value.TypeName(v))
+		}
+		e = &value.ObjError{Message: s}
+	}
+	rtErr := vm.runtimeError(c, ip, "%s", errorMessage(e)).(*RuntimeError)
+	rtErr.thrown = e
+	return rtErr
+}
+
+// errorMessage is how a thrown error reads when nothing catches it.
+func errorMessage(e *value.ObjError) string {
+	if e.Code != 0 {
+		return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+	}
+	return e.Message
+}
+
+// catch hands err to the innermost try block run by this run call (one
+// whose frame is at least minFrameCount deep): it unwinds the frames and
+// stack above the block and resumes at its catch block with the error
+// pushed. It reports false when no such block exists or err is not a
+// runtime error, such as sys.exit.
+func (vm *VM) catch(err error, minFrameCount int) bool {
+	n := len(vm.tries)
+	if n == 0 || vm.tries[n-1].frameCount < minFrameCount {
+		return false
+	}
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) {
+		return false
+	}
+	h := vm.tries[n-1]
+	vm.tries = vm.tries[:n-1]
+
+	for i := h.stackTop; i < vm.stackTop; i++ {
+		vm.closeUpvalue(&vm.stack[i])
+		vm.stack[i] = value.Value{}
+	}
+	vm.stackTop = h.stackTop
+	for i := h.frameCount; i < vm.frameCount; i++ {
+		vm.frames[i] = CallFrame{}
+	}
+	vm.frameCount = h.frameCount
+	vm.currentFrame = &vm.frames[h.frameCount-1]
+	vm.currentFrame.IP = h.catchIP
+
+	e := rtErr.thrown
+	if e == nil {
+		e = &value.ObjError{Message: rtErr.Message}
+	}
+	vm.push(value.Value{Type: value.VAL_OBJ, Obj: e})
+	return true
+}
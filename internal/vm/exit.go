@@ -0,0 +1,27 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+)
+
+// ExitError is the error Interpret returns when the script calls sys.exit.
+// The program unwinds like it does for a runtime error, so the embedder
+// gets to clean up (see Close) before ending the process with Code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// exit ends the process for a spawned thread that called sys.exit. No
+// embedder is waiting for the thread, so it shuts the VM down itself:
+// handles are closed, plugins stopped and output flushed first.
+func (vm *VM) exit(code int) {
+	vm.Close()
+	flushWriter(vm.stdout())
+	flushWriter(vm.stderr())
+	os.Exit(code)
+}
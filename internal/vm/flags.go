@@ -0,0 +1,233 @@
+package vm
+
+import (
+	"fmt"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/value"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// flagSet holds the flags a script defines with the flag module.
+type flagSet struct {
+	lock  sync.Mutex
+	usage string
+	flags []*scriptFlag
+	args  []string // positional arguments of the last parse
+}
+
+type scriptFlag struct {
+	name string
+	def  value.Value
+	help string
+}
+
+func (fs *flagSet) lookup(name string) *scriptFlag {
+	for _, f := range fs.flags {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// defineFlagNatives adds the flag module. Flags are defined once per
+// program, so its state is shared by all threads.
+func (vm *VM) defineFlagNatives() {
+	fs := &flagSet{}
+
+	define := func(kind string) {
+		// flag_<kind>(name, default, help?)
+		vm.DefineModuleNative("flag", kind, func(args []value.Value) value.Value {
+			if err, ok := native.CheckArgs(args, "string", kind, "string?"); !ok {
+				return err
+			}
+			name := strings.TrimLeft(args[0].String(), "-")
+			if name == "" || name == "help" || name == "h" || strings.ContainsAny(name, "= ") {
+				return value.NewNativeError("invalid flag name %q", args[0].String())
+			}
+			fs.lock.Lock()
+			defer fs.lock.Unlock()
+			if fs.lookup(name) != nil {
+				return value.NewNativeError("flag --%s defined twice", name)
+			}
+			f := &scriptFlag{name: name, def: args[1]}
+			if len(args) > 2 {
+				f.help = args[2].String()
+			}
+			fs.flags = append(fs.flags, f)
+			return value.NewNull()
+		})
+	}
+	for _, kind := range []string{"string", "int", "float", "bool"} {
+		define(kind)
+	}
+
+	// flag_usage(text) sets the first line of --help, such as
+	// "Usage: resize [flags] <image>".
+	vm.DefineModuleNative("flag", "usage", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		fs.lock.Lock()
+		fs.usage = args[0].String()
+		fs.lock.Unlock()
+		return value.NewNull()
+	})
+
+	// flag_parse(argv?) -> map
+	// The value of every defined flag, by name. Without argv, or given
+	// sys.argv(), it parses the program's arguments. --help prints the
+	// usage and exits with status 0; a bad flag prints the error and the
+	// usage to stderr and exits with status 2.
+	vm.DefineModuleNative("flag", "parse", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "array?"); !ok {
+			return err
+		}
+		argv := vm.Config.Args
+		if len(args) > 0 {
+			elems := args[0].Obj.(*value.ObjArray).Values()
+			given := make([]string, len(elems))
+			for i, el := range elems {
+				given[i] = el.String()
+			}
+			if !slices.Equal(given, os.Args) {
+				argv = given
+			}
+		}
+
+		fs.lock.Lock()
+		defer fs.lock.Unlock()
+		vals, positional, err := fs.parse(argv)
+		if err == errHelp {
+			fmt.Fprint(vm.stdout(), fs.help())
+			return value.Value{Type: value.VAL_NULL, Obj: &ExitError{Code: 0}}
+		}
+		if err != nil {
+			fmt.Fprintf(vm.stderr(), "%s\n%s", err, fs.help())
+			return value.Value{Type: value.VAL_NULL, Obj: &ExitError{Code: 2}}
+		}
+		fs.args = positional
+		return value.Value{Type: value.VAL_OBJ, Obj: vals}
+	})
+
+	// flag_args() -> string[]
+	// The arguments of the last parse that are not flags.
+	vm.DefineModuleNative("flag", "args", func(args []value.Value) value.Value {
+		fs.lock.Lock()
+		defer fs.lock.Unlock()
+		elems := make([]value.Value, len(fs.args))
+		for i, a := range fs.args {
+			elems[i] = value.NewString(a)
+		}
+		return value.NewArray(elems)
+	})
+}
+
+var errHelp = fmt.Errorf("help requested")
+
+// parse reads flags from anywhere in argv: -name or --name, followed by
+// =value or the next argument. Bools take no argument (--name=false and
+// --no-name turn them off). A lone - is an argument, and -- ends the
+// flags. fs.lock must be held.
+func (fs *flagSet) parse(argv []string) (*value.ObjMap, []string, error) {
+	vals := &value.ObjMap{Data: make(map[interface{}]value.Value)}
+	for _, f := range fs.flags {
+		vals.Set(f.name, f.def)
+	}
+	positional := []string{}
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "--" {
+			positional = append(positional, argv[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			positional = append(positional, arg)
+			continue
+		}
+		name, val, hasVal := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name == "help" || name == "h" {
+			return nil, nil, errHelp
+		}
+		f := fs.lookup(name)
+		if f == nil {
+			if neg := fs.lookup(strings.TrimPrefix(name, "no-")); neg != nil && strings.HasPrefix(name, "no-") && !hasVal && neg.def.Type == value.VAL_BOOL {
+				vals.Set(neg.name, value.NewBool(false))
+				continue
+			}
+			return nil, nil, fmt.Errorf("unknown flag: %s", arg)
+		}
+		if !hasVal {
+			if f.def.Type == value.VAL_BOOL {
+				vals.Set(f.name, value.NewBool(true))
+				continue
+			}
+			if i+1 >= len(argv) {
+				return nil, nil, fmt.Errorf("flag needs a value: --%s", f.name)
+			}
+			i++
+			val = argv[i]
+		}
+		v, err := f.parseValue(val)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid value %q for flag --%s: expected %s", val, f.name, value.TypeName(f.def))
+		}
+		vals.Set(f.name, v)
+	}
+	return vals, positional, nil
+}
+
+func (f *scriptFlag) parseValue(s string) (value.Value, error) {
+	switch f.def.Type {
+	case value.VAL_INT:
+		n, err := strconv.ParseInt(s, 0, 64)
+		return value.NewInt(n), err
+	case value.VAL_FLOAT:
+		x, err := strconv.ParseFloat(s, 64)
+		return value.NewFloat(x), err
+	case value.VAL_BOOL:
+		b, err := strconv.ParseBool(s)
+		return value.NewBool(b), err
+	}
+	return value.NewString(s), nil
+}
+
+// help formats the usage line and one line per flag, with the flag
+// names aligned in a column. fs.lock must be held.
+func (fs *flagSet) help() string {
+	var sb strings.Builder
+	if fs.usage != "" {
+		sb.WriteString(fs.usage + "\n\n")
+	}
+	sb.WriteString("Flags:\n")
+	names := make([]string, len(fs.flags)+1)
+	width := 0
+	for i, f := range fs.flags {
+		names[i] = "--" + f.name
+		if f.def.Type != value.VAL_BOOL {
+			names[i] += " " + value.TypeName(f.def)
+		}
+		width = max(width, len(names[i]))
+	}
+	names[len(fs.flags)] = "-h, --help"
+	width = max(width, len(names[len(fs.flags)]))
+	for i, f := range fs.flags {
+		desc := []string{f.help}
+		switch {
+		case f.def.Type == value.VAL_BOOL:
+		case f.def.Type == value.VAL_OBJ && f.def.String() == "":
+		case f.def.Type == value.VAL_OBJ:
+			desc = append(desc, fmt.Sprintf("(default %q)", f.def.String()))
+		default:
+			desc = append(desc, fmt.Sprintf("(default %s)", f.def.String()))
+		}
+		line := fmt.Sprintf("  %-*s  %s", width, names[i], strings.TrimSpace(strings.Join(desc, " ")))
+		sb.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("  %-*s  %s\n", width, names[len(fs.flags)], "show this help"))
+	return sb.String()
+}
@@ -0,0 +1,154 @@
+package vm
+
+import (
+	"fmt"
+	"noxy-vm/internal/value"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// moduleRegistry caches the modules imported by a VM and the threads it
+// spawns. When several threads import a module that is not loaded yet,
+// one of them loads it while the others wait for the result, so the
+// module's top-level code runs once.
+type moduleRegistry struct {
+	lock    sync.Mutex
+	loaded  map[string]value.Value
+	loading map[string]*moduleLoad
+	waiting map[*VM]*moduleLoad // the load each thread is blocked on
+}
+
+// moduleLoad is a module being loaded by owner.
+type moduleLoad struct {
+	name  string
+	owner *VM
+	done  chan struct{}
+	val   value.Value
+	err   error
+}
+
+func newModuleRegistry() *moduleRegistry {
+	return &moduleRegistry{
+		loaded:  make(map[string]value.Value),
+		loading: make(map[string]*moduleLoad),
+		waiting: make(map[*VM]*moduleLoad),
+	}
+}
+
+func (r *moduleRegistry) get(name string) (value.Value, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	val, ok := r.loaded[name]
+	return val, ok
+}
+
+func (r *moduleRegistry) set(name string, val value.Value) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.loaded[name] = val
+}
+
+// all returns the loaded modules, sorted by name.
+func (r *moduleRegistry) all() []value.Value {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	names := make([]string, 0, len(r.loaded))
+	for name := range r.loaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mods := make([]value.Value, len(names))
+	for i, name := range names {
+		mods[i] = r.loaded[name]
+	}
+	return mods
+}
+
+// reset forgets the loaded modules. Loads in progress finish, but their
+// result is not cached.
+func (r *moduleRegistry) reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.loaded = make(map[string]value.Value)
+	r.loading = make(map[string]*moduleLoad)
+}
+
+// importModule returns the module called name, loading it with load on
+// behalf of vm unless it is cached or another thread is already loading
+// it. An import that would wait for itself, directly or through other
+// threads' imports, is an import cycle and fails.
+func (r *moduleRegistry) importModule(vm *VM, name string, load func() (value.Value, error)) (value.Value, error) {
+	r.lock.Lock()
+	if val, ok := r.loaded[name]; ok {
+		r.lock.Unlock()
+		return val, nil
+	}
+	if l, ok := r.loading[name]; ok {
+		if r.cycle(vm, l) {
+			r.lock.Unlock()
+			return value.Value{}, fmt.Errorf("import cycle: '%s' is imported while it is still being loaded", name)
+		}
+		r.waiting[vm] = l
+		r.lock.Unlock()
+
+		<-l.done
+
+		r.lock.Lock()
+		delete(r.waiting, vm)
+		r.lock.Unlock()
+		return l.val, l.err
+	}
+	l := &moduleLoad{name: name, owner: vm, done: make(chan struct{})}
+	r.loading[name] = l
+	r.lock.Unlock()
+
+	l.val, l.err = load()
+
+	r.lock.Lock()
+	if r.loading[name] == l {
+		delete(r.loading, name)
+		if l.err == nil {
+			r.loaded[name] = l.val
+		}
+	}
+	r.lock.Unlock()
+	close(l.done)
+	return l.val, l.err
+}
+
+// loadingBy reports whether vm is loading the module called name.
+func (r *moduleRegistry) loadingBy(vm *VM, name string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	l, ok := r.loading[name]
+	return ok && l.owner == vm
+}
+
+// lazyModule stands in for a module bound by a plain use statement until
+// the program first uses it (see VM.resolveLazy). It keeps the module once
+// loaded, so later uses skip the registry; the globals holding it are
+// never rewritten.
+type lazyModule struct {
+	name string
+	mod  atomic.Pointer[value.Value]
+}
+
+func (m *lazyModule) String() string {
+	return "<module " + m.name + ">"
+}
+
+// cycle reports whether vm waiting for l would wait for itself: l is
+// loaded by vm, or by a thread that is waiting on such a load. r.lock
+// must be held.
+func (r *moduleRegistry) cycle(vm *VM, l *moduleLoad) bool {
+	seen := make(map[*moduleLoad]bool)
+	for l != nil && !seen[l] {
+		if l.owner == vm {
+			return true
+		}
+		seen[l] = true
+		l = r.waiting[l.owner]
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package vm
+
+import (
+	"noxy-vm/internal/native"
+	nativebinary "noxy-vm/internal/native/binary"
+	nativebytes "noxy-vm/internal/native/bytes"
+	nativechecksum "noxy-vm/internal/native/checksum"
+	nativeconfig "noxy-vm/internal/native/config"
+	nativecron "noxy-vm/internal/native/cron"
+	nativehttp "noxy-vm/internal/native/http"
+	nativeimage "noxy-vm/internal/native/image"
+	nativeio "noxy-vm/internal/native/io"
+	nativejwt "noxy-vm/internal/native/jwt"
+	nativemarkdown "noxy-vm/internal/native/markdown"
+	nativendarray "noxy-vm/internal/native/ndarray"
+	nativesemver "noxy-vm/internal/native/semver"
+	nativestats "noxy-vm/internal/native/stats"
+	nativestrings "noxy-vm/internal/native/strings"
+	nativetime "noxy-vm/internal/native/time"
+	nativeurl "noxy-vm/internal/native/url"
+)
+
+// A nativeDomain registers the natives of one domain and returns the
+// handle table they keep, if any.
+type nativeDomain func(r native.Registry) native.Resources
+
+// nativeDomains are the domains compiled into this binary. Optional ones
+// append themselves from files guarded by build tags (nonet, nosqlite).
+var nativeDomains = []nativeDomain{
+	func(r native.Registry) native.Resources { nativetime.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativestrings.Register(r); return nil },
+	func(r native.Registry) native.Resources { return nativeio.Register(r) },
+	func(r native.Registry) native.Resources { nativehttp.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativeurl.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativejwt.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativebytes.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativebinary.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativechecksum.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativesemver.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativeconfig.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativecron.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativeimage.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativemarkdown.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativestats.Register(r); return nil },
+	func(r native.Registry) native.Resources { nativendarray.Register(r); return nil },
+}
+
+// ScriptArgs returns VMConfig.Args, for native.Registry.
+func (vm *VM) ScriptArgs() []string {
+	return vm.Config.Args
+}
@@ -0,0 +1,14 @@
+//go:build !nonet
+
+package vm
+
+import (
+	"noxy-vm/internal/native"
+	nativenet "noxy-vm/internal/native/net"
+)
+
+func init() {
+	nativeDomains = append(nativeDomains, func(r native.Registry) native.Resources {
+		return nativenet.Register(r)
+	})
+}
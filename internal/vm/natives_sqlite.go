@@ -0,0 +1,14 @@
+//go:build !nosqlite && !js
+
+package vm
+
+import (
+	"noxy-vm/internal/native"
+	nativesqlite "noxy-vm/internal/native/sqlite"
+)
+
+func init() {
+	nativeDomains = append(nativeDomains, func(r native.Registry) native.Resources {
+		return nativesqlite.Register(r)
+	})
+}
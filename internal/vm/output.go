@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"io"
+	"noxy-vm/internal/value"
+	"os"
+	"strings"
+)
+
+func (vm *VM) stdout() io.Writer {
+	if vm.Config.Stdout != nil {
+		return vm.Config.Stdout
+	}
+	return os.Stdout
+}
+
+func (vm *VM) stderr() io.Writer {
+	if vm.Config.Stderr != nil {
+		return vm.Config.Stderr
+	}
+	return os.Stderr
+}
+
+// flushWriter pushes out anything w is holding back: buffered writers are
+// flushed, files are synced. Errors are ignored, as syncing a pipe or a
+// terminal is not supported everywhere.
+func flushWriter(w io.Writer) {
+	switch w := w.(type) {
+	case interface{ Flush() error }:
+		w.Flush()
+	case interface{ Sync() error }:
+		w.Sync()
+	}
+}
+
+func joinArgs(args []value.Value, sep string) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = arg.String()
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,131 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"noxy-vm/internal/value"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// sysPipe implements sys.pipe(cmd1, cmd2, ..., options?): each command is
+// an argv array run without a shell, with the stdout of each streamed
+// into the stdin of the next. options may give "input", the stdin of the
+// first command. The result is a map:
+//
+//	output      stdout of the last command
+//	stderr      stderr of every command, in pipeline order
+//	exit_codes  the exit status of each command (127 if it did not start)
+//	exit_code   the last non-zero status, or 0
+//	ok          whether every command succeeded
+func (vm *VM) sysPipe(args []value.Value) value.Value {
+	var input *string
+	if n := len(args); n > 0 {
+		if opts, ok := args[n-1].Obj.(*value.ObjMap); ok {
+			args = args[:n-1]
+			for _, k := range opts.Keys {
+				v := opts.Data[k]
+				switch {
+				case k == "input" && (v.Type == value.VAL_BYTES || value.TypeName(v) == "string"):
+					s := v.Obj.(string)
+					input = &s
+				default:
+					return value.NewNativeError("unknown option %v", k)
+				}
+			}
+		}
+	}
+	if len(args) == 0 {
+		return value.NewNativeError("expects at least one command")
+	}
+
+	cmds := make([]*exec.Cmd, len(args))
+	stderrs := make([]bytes.Buffer, len(args))
+	for i, arg := range args {
+		arr, ok := arg.Obj.(*value.ObjArray)
+		if !ok || arr.Len() == 0 {
+			return value.NewNativeError("command %d must be a non-empty string array, got %s", i+1, value.TypeName(arg))
+		}
+		argv := make([]string, arr.Len())
+		for j, el := range arr.Values() {
+			if value.TypeName(el) != "string" {
+				return value.NewNativeError("command %d: argument %d is %s, not string", i+1, j, value.TypeName(el))
+			}
+			argv[j] = el.String()
+		}
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Dir = vm.workDir()
+		cmd.Stderr = &stderrs[i]
+		cmds[i] = cmd
+	}
+	if input != nil {
+		cmds[0].Stdin = strings.NewReader(*input)
+	}
+	var stdout bytes.Buffer
+	cmds[len(cmds)-1].Stdout = &stdout
+
+	// Connect neighbours with OS pipes, so data streams between the
+	// processes without passing through the VM. The parent's copies are
+	// closed once both sides started, so every reader sees EOF when its
+	// writer exits (or never started).
+	var parentEnds []*os.File
+	for i := 0; i < len(cmds)-1; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			for _, f := range parentEnds {
+				f.Close()
+			}
+			return value.NewNativeError("%s", err)
+		}
+		cmds[i].Stdout = w
+		cmds[i+1].Stdin = r
+		parentEnds = append(parentEnds, r, w)
+	}
+
+	codes := make([]int, len(cmds))
+	started := make([]bool, len(cmds))
+	for i, cmd := range cmds {
+		if err := cmd.Start(); err != nil {
+			codes[i] = 127
+			fmt.Fprintf(&stderrs[i], "%s: %s\n", cmd.Args[0], err)
+			continue
+		}
+		started[i] = true
+	}
+	for _, f := range parentEnds {
+		f.Close()
+	}
+	for i, cmd := range cmds {
+		if !started[i] {
+			continue
+		}
+		if err := cmd.Wait(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+				codes[i] = exitErr.ExitCode()
+			} else {
+				codes[i] = 1
+			}
+		}
+	}
+
+	var allStderr strings.Builder
+	codeVals := make([]value.Value, len(codes))
+	status := 0
+	for i, code := range codes {
+		allStderr.Write(stderrs[i].Bytes())
+		codeVals[i] = value.NewInt(int64(code))
+		if code != 0 {
+			status = code
+		}
+	}
+	result := &value.ObjMap{Data: make(map[interface{}]value.Value)}
+	result.Set("output", value.NewString(stdout.String()))
+	result.Set("stderr", value.NewString(allStderr.String()))
+	result.Set("exit_codes", value.NewArray(codeVals))
+	result.Set("exit_code", value.NewInt(int64(status)))
+	result.Set("ok", value.NewBool(status == 0))
+	return value.Value{Type: value.VAL_OBJ, Obj: result}
+}
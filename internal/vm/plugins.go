@@ -0,0 +1,171 @@
+package vm
+
+import (
+	"fmt"
+	"noxy-vm/internal/plugin"
+	"noxy-vm/internal/value"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// loadPlugin starts the plugin called name from cmdName, a command or the
+// URL of a remote plugin. A plugin line for name in noxy.mod takes
+// precedence over cmdName.
+func (vm *VM) loadPlugin(name, cmdName string) error {
+	// A remote endpoint, given directly or via "plugin <name> <url>" in
+	// noxy.mod, takes precedence over a local executable.
+	if url := vm.remotePluginURL(name); url != "" {
+		cmdName = url
+	}
+	if plugin.IsRemote(cmdName) {
+		_, err := vm.shared.Plugins.LoadRemote(name, cmdName)
+		return err
+	}
+
+	// Intelligent Path Search
+	var cmdPath string
+	found := false
+
+	// 1. Check absolute path or PATH override
+	if filepath.IsAbs(cmdName) {
+		if _, err := os.Stat(cmdName); err == nil {
+			cmdPath = cmdName
+			found = true
+		}
+	} else {
+		// 2. Check path provided directly (PATH lookup)
+		if path, err := exec.LookPath(cmdName); err == nil {
+			cmdPath = path
+			found = true
+		}
+	}
+
+	// 3. Check Current Working Directory (explicitly)
+	if !found {
+		cwd, _ := vm.Getwd()
+		localPath := filepath.Join(cwd, cmdName)
+		// Add .exe on Windows if not present
+		if runtime.GOOS == "windows" && !strings.HasSuffix(localPath, ".exe") {
+			localPath += ".exe"
+		}
+		if _, err := os.Stat(localPath); err == nil {
+			cmdPath = localPath
+			found = true
+		}
+	}
+
+	// 4. Check noxy_libs recursively (Depth restricted)
+	if !found {
+		cwd, _ := vm.Getwd()
+		libsDir := filepath.Join(cwd, "noxy_libs")
+		filepath.Walk(libsDir, func(path string, info os.FileInfo, err error) error {
+			if found {
+				return filepath.SkipDir // Stop if found
+			}
+			if err != nil {
+				return nil // Ignore errors
+			}
+			if info.IsDir() {
+				if info.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			fname := info.Name()
+			isMatch := fname == cmdName
+			if runtime.GOOS == "windows" {
+				isMatch = fname == cmdName || fname == cmdName+".exe"
+			}
+
+			if isMatch {
+				cmdPath = path
+				found = true
+				return filepath.SkipDir // Abort walk
+			}
+			return nil
+		})
+	}
+
+	if !found {
+		return fmt.Errorf("command not found: %s", cmdName)
+	}
+
+	if _, err := vm.shared.Plugins.Load(name, cmdPath); err != nil {
+		return fmt.Errorf("failed to load plugin: %v", err)
+	}
+	return nil
+}
+
+// definePluginNative exposes a loaded plugin as <name>_request(method, ...).
+// The plugin is looked up on every call, so the native keeps working when
+// the plugin is loaded again after Reset. Wrappers written for it expect
+// null from a failed request, so it prints the error and returns null
+// where plugin methods return the error.
+func (vm *VM) definePluginNative(name string) {
+	nativeName := name + "_request" // e.g. dynamodb_request
+	vm.DefineNative(nativeName, func(args []value.Value) value.Value {
+		client, ok := vm.shared.Plugins.Get(name)
+		if !ok || len(args) < 1 {
+			return value.NewNull()
+		}
+		method := args[0].String()
+		params := args[1:]
+		result := client.Call(method, params)
+		if e, ok := result.Obj.(*value.ObjError); ok {
+			fmt.Fprintf(os.Stderr, "Plugin Error: %s\n", e.Message)
+			return value.NewNull()
+		}
+		return result
+	})
+}
+
+// pluginModule is the value use plugin binds. Its members are natives
+// that call the plugin method of the same name with their arguments, so
+// ddb.put_item(t, item) sends {"method": "put_item", "params": [t, item]}.
+type pluginModule struct {
+	name    string
+	methods sync.Map // method name -> value.Value, made on first use
+}
+
+func (p *pluginModule) String() string {
+	return "<plugin " + p.name + ">"
+}
+
+// importPlugin starts the plugin named by a use plugin statement, unless
+// it is loaded already, and returns the value to bind to bind. target is
+// the plugin's name, run as the command noxy-plugin-<name>, or the URL of
+// a remote plugin, which is then named after bind.
+func (vm *VM) importPlugin(target, bind string) (value.Value, error) {
+	name, cmdName := target, "noxy-plugin-"+target
+	if plugin.IsRemote(target) {
+		name, cmdName = bind, target
+	}
+	if _, loaded := vm.shared.Plugins.Get(name); !loaded {
+		if err := vm.loadPlugin(name, cmdName); err != nil {
+			return value.Value{}, err
+		}
+	}
+	return value.Value{Type: value.VAL_OBJ, Obj: &pluginModule{name: name}}, nil
+}
+
+// pluginMethod returns the native that calls method on p. Like the
+// <name>_request natives, it looks the plugin up on every call.
+func (vm *VM) pluginMethod(p *pluginModule, method string) value.Value {
+	if fn, ok := p.methods.Load(method); ok {
+		return fn.(value.Value)
+	}
+	fn := value.NewNative(p.name+"."+method, func(args []value.Value) value.Value {
+		client, ok := vm.shared.Plugins.Get(p.name)
+		if !ok {
+			return value.Errorf("plugin %s is not loaded", p.name)
+		}
+		return client.Call(method, args)
+	})
+	actual, _ := p.methods.LoadOrStore(method, fn)
+	return actual.(value.Value)
+}
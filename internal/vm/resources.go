@@ -0,0 +1,44 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// OpenResources describes every file, database, statement, listener and
+// connection handle that is still open, grouped by kind.
+func (vm *VM) OpenResources() []string {
+	var open []string
+	for _, res := range vm.shared.Resources {
+		open = append(open, res.OpenResources()...)
+	}
+	return open
+}
+
+// Close releases every handle the script left open: files, prepared
+// statements, databases, listeners and connections, and stops the plugins
+// it loaded. With
+// VMConfig.ReportLeaks set, the handles are first listed on stderr.
+//
+// Handles live in state shared with spawned threads, so Close must only be
+// called once the whole program is done, by the VM that started it.
+func (vm *VM) Close() {
+	if vm.Config.ReportLeaks {
+		writeLeakReport(os.Stderr, vm.OpenResources())
+	}
+	for _, res := range vm.shared.Resources {
+		res.CloseAll()
+	}
+	vm.shared.Plugins.Close()
+}
+
+func writeLeakReport(w io.Writer, open []string) {
+	if len(open) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "leak report: %d handle(s) still open at exit:\n", len(open))
+	for _, r := range open {
+		fmt.Fprintf(w, "  %s\n", r)
+	}
+}
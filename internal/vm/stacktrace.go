@@ -0,0 +1,71 @@
+package vm
+
+import (
+	"fmt"
+	"noxy-vm/internal/chunk"
+	"noxy-vm/internal/value"
+	"strings"
+)
+
+// RuntimeError is the error a program stops with when an instruction
+// fails. Error() starts with "[file:line N] message", followed by the calls
+// that were active, innermost first, when there were any.
+type RuntimeError struct {
+	Message string
+	File    string
+	Line    int
+	// Stack lists the active calls, innermost first; the last entry is the
+	// script itself.
+	Stack []StackEntry
+
+	thrown *value.ObjError // The value of a throw statement, for its catch
+}
+
+// StackEntry is one active call: the function and the line it had reached.
+type StackEntry struct {
+	Function string
+	File     string
+	Line     int
+}
+
+func (e *RuntimeError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[%s:line %d] %s", e.File, e.Line, e.Message)
+	if len(e.Stack) > 1 {
+		sb.WriteString("\nStack trace (most recent call first):")
+		for _, s := range e.Stack {
+			fmt.Fprintf(&sb, "\n    at %s (%s:%d)", s.Function, s.File, s.Line)
+		}
+	}
+	return sb.String()
+}
+
+// stackTrace lists the frames of vm, innermost first. The innermost frame
+// is running c at ip; the others are where they made their calls.
+func (vm *VM) stackTrace(c *chunk.Chunk, ip int) []StackEntry {
+	stack := make([]StackEntry, 0, vm.frameCount)
+	for i := vm.frameCount - 1; i >= 0; i-- {
+		frame := &vm.frames[i]
+		fn := frame.Closure.Function
+		fc, _ := fn.Chunk.(*chunk.Chunk)
+		at := frame.IP
+		if i == vm.frameCount-1 && fc == c {
+			at = ip
+		}
+		file, line := sourceLine(fc, at)
+		stack = append(stack, StackEntry{Function: fn.Name, File: file, Line: line})
+	}
+	return stack
+}
+
+// sourceLine finds the line of the instruction just before ip, the one
+// that is running or that made a call.
+func sourceLine(c *chunk.Chunk, ip int) (string, int) {
+	if c == nil {
+		return "?", 0
+	}
+	if ip > 0 && ip <= len(c.Lines) {
+		return c.FileName, c.Lines[ip-1]
+	}
+	return c.FileName, 0
+}
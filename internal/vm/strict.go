@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"noxy-vm/internal/value"
+	"strings"
+)
+
+// matchesType reports whether v fits the declared type t, written the way
+// ast types print ("int", "P", "string[]", "map[string, P[]]"). It backs
+// OP_CHECK_TYPE, which the compiler only emits in strict mode and only for
+// types it knows the VM can check. Arrays and maps are checked element by
+// element; struct-typed slots may also hold null.
+func matchesType(v value.Value, t string) bool {
+	switch t {
+	case "any", "func":
+		return true
+	case "int":
+		return v.Type == value.VAL_INT
+	case "float":
+		return v.Type == value.VAL_FLOAT
+	case "bool":
+		return v.Type == value.VAL_BOOL
+	case "bytes":
+		return v.Type == value.VAL_BYTES
+	case "string":
+		_, ok := v.Obj.(string)
+		return v.Type == value.VAL_OBJ && ok
+	}
+
+	if elem, ok := strings.CutSuffix(t, "[]"); ok {
+		arr, ok := v.Obj.(*value.ObjArray)
+		if v.Type != value.VAL_OBJ || !ok {
+			return false
+		}
+		for _, e := range arr.Values() {
+			if !matchesType(e, elem) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if inner, ok := strings.CutPrefix(t, "map["); ok {
+		m, ok := v.Obj.(*value.ObjMap)
+		if v.Type != value.VAL_OBJ || !ok {
+			return false
+		}
+		valType := mapValueType(strings.TrimSuffix(inner, "]"))
+		for _, e := range m.Data {
+			if !matchesType(e, valType) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Anything else is a struct name.
+	if v.Type == value.VAL_NULL {
+		return true
+	}
+	inst, ok := v.Obj.(*value.ObjInstance)
+	return v.Type == value.VAL_OBJ && ok && inst.Struct.Name == t
+}
+
+// mapValueType returns V from the "K, V" inside map[K, V].
+func mapValueType(kv string) string {
+	depth := 0
+	for i, r := range kv {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				return strings.TrimSpace(kv[i+1:])
+			}
+		}
+	}
+	return "any"
+}
@@ -0,0 +1,178 @@
+package vm
+
+import (
+	"fmt"
+	"noxy-vm/internal/value"
+	"strings"
+)
+
+// stub records the slots a stubbed function was replaced in, and what
+// each held before, so unstub can put them back.
+type stub struct {
+	name    string
+	patches []stubPatch
+}
+
+type stubPatch struct {
+	slot map[string]value.Value // nil for a module map
+	mod  *value.ObjMap
+	key  string
+	old  value.Value
+}
+
+// defineStubNatives adds testing.stub and testing.unstub, which the
+// stdlib's testing.with_stub builds on.
+func (vm *VM) defineStubNatives() {
+	// testing_stub(name, replacement)
+	// name is a global ("time_now", "fetch_user") or a module member
+	// ("time.now").
+	vm.DefineModuleNative("testing", "stub", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNativeError("expected a name and a replacement")
+		}
+		if args[1].Type != value.VAL_FUNCTION && args[1].Type != value.VAL_NATIVE {
+			return value.NewNativeError("replacement must be a function, got %s", value.TypeName(args[1]))
+		}
+		if err := vm.Stub(args[0].String(), args[1]); err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewNull()
+	})
+
+	// testing_unstub(name) undoes the latest stub of name
+	vm.DefineModuleNative("testing", "unstub", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewNativeError("expected a name")
+		}
+		if err := vm.Unstub(args[0].String()); err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		return value.NewNull()
+	})
+}
+
+// Stub replaces the function called name with replacement until Unstub.
+// Every reference to the original is replaced: the global itself, its
+// aliases in the globals (such as names imported with select), its entry
+// in loaded modules and native modules, and the module globals of the
+// module that defined it, so calls from inside that module are stubbed
+// too. Stubs affect all threads.
+func (vm *VM) Stub(name string, replacement value.Value) error {
+	// A module bound by a plain use is loaded on its first use, which
+	// this is
+	if mod, _, dotted := strings.Cut(name, "."); dotted {
+		if g, ok := vm.GetGlobal(mod); ok {
+			if lazy, ok := g.Obj.(*lazyModule); ok && lazy.mod.Load() == nil {
+				if _, err := vm.ImportModule(lazy.name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	vm.shared.GlobalsLock.Lock()
+	defer vm.shared.GlobalsLock.Unlock()
+
+	orig, ok := vm.shared.Globals[name]
+	if !ok {
+		if mod, member, dotted := strings.Cut(name, "."); dotted {
+			if modVal, loaded := vm.shared.Modules.get(mod); loaded {
+				if m, isMap := modVal.Obj.(*value.ObjMap); isMap {
+					orig, ok = m.Get(member)
+				}
+			}
+			if !ok {
+				orig, ok = vm.shared.NativeModules[mod][member]
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("cannot stub '%s': not defined", name)
+	}
+	if orig.Type != value.VAL_FUNCTION && orig.Type != value.VAL_NATIVE {
+		return fmt.Errorf("cannot stub '%s': it is a %s, not a function", name, value.TypeName(orig))
+	}
+
+	s := &stub{name: name}
+	patchMap := func(slot map[string]value.Value) {
+		for k, v := range slot {
+			if sameObject(v, orig) {
+				s.patches = append(s.patches, stubPatch{slot: slot, key: k, old: v})
+				slot[k] = replacement
+			}
+		}
+	}
+	patchMap(vm.shared.Globals)
+	for _, members := range vm.shared.NativeModules {
+		patchMap(members)
+	}
+	if fn := functionOf(orig); fn != nil && fn.Globals != nil {
+		patchMap(fn.Globals)
+	}
+	for _, modVal := range vm.shared.Modules.all() {
+		m, ok := modVal.Obj.(*value.ObjMap)
+		if !ok {
+			continue
+		}
+		for _, k := range m.Keys {
+			if key, ok := k.(string); ok && sameObject(m.Data[k], orig) {
+				s.patches = append(s.patches, stubPatch{mod: m, key: key, old: m.Data[k]})
+				m.Set(key, replacement)
+			}
+		}
+	}
+	vm.shared.Stubs = append(vm.shared.Stubs, s)
+	return nil
+}
+
+// Unstub undoes the most recent Stub of name.
+func (vm *VM) Unstub(name string) error {
+	vm.shared.GlobalsLock.Lock()
+	defer vm.shared.GlobalsLock.Unlock()
+	for i := len(vm.shared.Stubs) - 1; i >= 0; i-- {
+		if s := vm.shared.Stubs[i]; s.name == name {
+			s.restore()
+			vm.shared.Stubs = append(vm.shared.Stubs[:i], vm.shared.Stubs[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' is not stubbed", name)
+}
+
+// RestoreStubs undoes every stub still in place, newest first. The test
+// runner calls it after each test, so a test that fails inside
+// testing.with_stub does not leave its stubs behind.
+func (vm *VM) RestoreStubs() {
+	vm.shared.GlobalsLock.Lock()
+	defer vm.shared.GlobalsLock.Unlock()
+	for i := len(vm.shared.Stubs) - 1; i >= 0; i-- {
+		vm.shared.Stubs[i].restore()
+	}
+	vm.shared.Stubs = nil
+}
+
+func (s *stub) restore() {
+	for i := len(s.patches) - 1; i >= 0; i-- {
+		p := s.patches[i]
+		if p.mod != nil {
+			p.mod.Set(p.key, p.old)
+		} else {
+			p.slot[p.key] = p.old
+		}
+	}
+}
+
+// sameObject reports whether a and b are the same function object.
+func sameObject(a, b value.Value) bool {
+	return a.Type == b.Type && a.Obj != nil && a.Obj == b.Obj
+}
+
+func functionOf(v value.Value) *value.ObjFunction {
+	switch fn := v.Obj.(type) {
+	case *value.ObjClosure:
+		return fn.Function
+	case *value.ObjFunction:
+		return fn
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"fmt"
+	"noxy-vm/internal/chunk"
+	"noxy-vm/internal/value"
+	"sort"
+)
+
+// undefinedGlobal reports a missing global, suggesting the closest name
+// visible from globals: module-level names, VM globals and builtins, and
+// members of imported modules written as "module.member".
+func (vm *VM) undefinedGlobal(c *chunk.Chunk, ip int, globals map[string]value.Value, name string) error {
+	modules := map[*value.ObjMap]bool{}
+	vm.shared.GlobalsLock.RLock()
+	candidates := make([]string, 0, len(globals)+len(vm.shared.Globals))
+	for n := range vm.shared.Globals {
+		candidates = append(candidates, n)
+	}
+	vm.shared.GlobalsLock.RUnlock()
+	for _, m := range vm.shared.Modules.all() {
+		if mod, ok := m.Obj.(*value.ObjMap); ok {
+			modules[mod] = true
+		}
+	}
+
+	for n, v := range globals {
+		candidates = append(candidates, n)
+		if mod, ok := v.Obj.(*value.ObjMap); ok && modules[mod] {
+			for _, member := range mapKeyNames(mod) {
+				candidates = append(candidates, n+"."+member)
+			}
+		}
+	}
+	return vm.runtimeError(c, ip, "undefined global variable '%s'%s", name, didYouMean(name, candidates))
+}
+
+// fieldNames lists an instance's fields for didYouMean.
+func fieldNames(instance *value.ObjInstance) []string {
+	return append([]string(nil), instance.Struct.Fields...)
+}
+
+// memberNames lists the fields and methods of an instance for didYouMean.
+func memberNames(instance *value.ObjInstance) []string {
+	names := fieldNames(instance)
+	for n := range instance.Struct.Methods {
+		names = append(names, n)
+	}
+	return names
+}
+
+// mapKeyNames lists the string keys of a map or module for didYouMean.
+func mapKeyNames(m *value.ObjMap) []string {
+	var names []string
+	for _, k := range m.Keys {
+		if n, ok := k.(string); ok {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// didYouMean returns a "; did you mean 'x'?" hint naming the candidate
+// closest to name, or "" when none is close enough to be a likely typo.
+func didYouMean(name string, candidates []string) string {
+	sort.Strings(candidates)
+	best, bestDist := "", (len(name)+2)/3+1
+	for _, cand := range candidates {
+		if cand == name {
+			continue
+		}
+		if d := editDistance(name, cand); d < bestDist {
+			best, bestDist = cand, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf("; did you mean '%s'?", best)
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment)
+// distance, so a swapped pair of letters counts as one edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
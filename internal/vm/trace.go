@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"fmt"
+	"noxy-vm/internal/chunk"
+	"noxy-vm/internal/value"
+	"os"
+	"strings"
+)
+
+// TraceMode selects what VMConfig.Trace reports.
+type TraceMode int
+
+const (
+	TraceOff TraceMode = iota
+	// TraceLines reports each source line when execution reaches it.
+	TraceLines
+	// TraceOps reports every executed instruction with the top of the stack.
+	TraceOps
+)
+
+// traceStackDepth is how many stack values a TraceOps event shows.
+const traceStackDepth = 8
+
+// TraceEvent describes one traced step. Stack holds the topmost values,
+// deepest first, and is only filled in TraceOps mode.
+type TraceEvent struct {
+	File     string
+	Line     int
+	Function string
+	Op       chunk.OpCode
+	Stack    []value.Value
+}
+
+func (ev TraceEvent) String() string {
+	if ev.Stack == nil {
+		return fmt.Sprintf("[trace] %s:%d in %s", ev.File, ev.Line, ev.Function)
+	}
+	parts := make([]string, len(ev.Stack))
+	for i, v := range ev.Stack {
+		parts[i] = value.Inspect(v, "")
+	}
+	return fmt.Sprintf("[trace] %s:%d in %s  %-18s [%s]", ev.File, ev.Line, ev.Function, ev.Op, strings.Join(parts, ", "))
+}
+
+// tracer filters and rate-limits trace events before handing them to the
+// hook.
+type tracer struct {
+	hook  func(TraceEvent)
+	count int
+
+	lastChunk *chunk.Chunk
+	lastLine  int
+}
+
+// SetTraceHook replaces the function that receives trace events. By
+// default they are written to stderr.
+func (vm *VM) SetTraceHook(hook func(TraceEvent)) {
+	vm.tracer().hook = hook
+}
+
+func (vm *VM) tracer() *tracer {
+	if vm.trace == nil {
+		vm.trace = &tracer{hook: func(ev TraceEvent) { fmt.Fprintln(os.Stderr, ev) }}
+	}
+	return vm.trace
+}
+
+// traceStep is called before each instruction while tracing is on. ip
+// points at the opcode about to run.
+func (vm *VM) traceStep(frame *CallFrame, c *chunk.Chunk, ip int) {
+	t := vm.tracer()
+	fnName := frame.Closure.Function.Name
+	if vm.Config.TraceFunc != "" && fnName != vm.Config.TraceFunc {
+		return
+	}
+	line := 0
+	if ip < len(c.Lines) {
+		line = c.Lines[ip]
+	}
+	if vm.Config.Trace == TraceLines {
+		if c == t.lastChunk && line == t.lastLine {
+			return
+		}
+		t.lastChunk, t.lastLine = c, line
+	}
+	if vm.Config.TraceLimit > 0 && t.count >= vm.Config.TraceLimit {
+		if t.count == vm.Config.TraceLimit {
+			t.count++
+			fmt.Fprintf(os.Stderr, "[trace] limit of %d events reached, tracing stopped\n", vm.Config.TraceLimit)
+		}
+		return
+	}
+	t.count++
+
+	ev := TraceEvent{File: c.FileName, Line: line, Function: fnName, Op: chunk.OpCode(c.Code[ip])}
+	if vm.Config.Trace == TraceOps {
+		start := vm.stackTop - traceStackDepth
+		if start < 0 {
+			start = 0
+		}
+		ev.Stack = append([]value.Value{}, vm.stack[start:vm.stackTop]...)
+	}
+	t.hook(ev)
+}
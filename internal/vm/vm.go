@@ -7,51 +7,53 @@ import (
 	"crypto/pbkdf2"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
+	"math"
+	"math/big"
 	"noxy-vm/internal/ast"
 	"noxy-vm/internal/chunk"
 	"noxy-vm/internal/compiler"
 	"noxy-vm/internal/lexer"
+	"noxy-vm/internal/money"
+	"noxy-vm/internal/native"
+	"noxy-vm/internal/ndarray"
 	"noxy-vm/internal/parser"
+	"noxy-vm/internal/pkgmanager"
 	"noxy-vm/internal/plugin"
 	"noxy-vm/internal/stdlib"
 	"noxy-vm/internal/value"
+	"noxy-vm/internal/version"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"runtime"
-	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
-
-	_ "modernc.org/sqlite"
 )
 
 const StackMax = 2048
 const FramesMax = 64
 
 func (vm *VM) runtimeError(c *chunk.Chunk, ip int, format string, args ...interface{}) error {
-	line := 0
-	file := "?"
-	if c != nil {
-		file = c.FileName
-		if ip > 0 && ip <= len(c.Lines) {
-			line = c.Lines[ip-1]
-		}
+	file, line := sourceLine(c, ip)
+	return &RuntimeError{
+		Message: fmt.Sprintf(format, args...),
+		File:    file,
+		Line:    line,
+		Stack:   vm.stackTrace(c, ip),
 	}
-	msg := fmt.Sprintf(format, args...)
-	return fmt.Errorf("[%s:line %d] %s", file, line, msg)
 }
 
 type CallFrame struct {
@@ -62,27 +64,28 @@ type CallFrame struct {
 }
 
 type SharedState struct {
-	Globals     map[string]value.Value // Global variables/functions
-	Modules     map[string]value.Value // Cached modules (Name -> ObjMap)
-	GlobalsLock sync.RWMutex
-
-	// Shared Network Resources
-	NetListeners map[int]net.Listener
-	NetConns     map[int]net.Conn
-	NextNetID    int
-	NetLock      sync.Mutex
-
-	// Shared Database Resources
-	DbHandles   map[int]*sql.DB
-	StmtHandles map[int]*sql.Stmt
-	StmtParams  map[int]map[int]interface{}
-	NextDbID    int
-	NextStmtID  int
-	DbLock      sync.Mutex
+	Globals       map[string]value.Value            // Global variables/functions
+	Modules       *moduleRegistry                   // Imported modules (Name -> ObjMap)
+	NativeModules map[string]map[string]value.Value // Natives grouped by module (Module -> Member -> Native)
+	GlobalsLock   sync.RWMutex
+
+	// Handle tables of the native domains (open files, sockets,
+	// databases), closed together by Close
+	Resources []native.Resources
+
+	// Stubs in place, oldest first (see Stub); guarded by GlobalsLock
+	Stubs []*stub
+
+	// Working directory of the script (see VMConfig.WorkDir), empty for
+	// the process's; guarded by GlobalsLock
+	WorkDir string
+
+	// Plugins loaded with sys.load_plugin, stopped by Close
+	Plugins *plugin.Registry
 }
 
 type VM struct {
-	frames       [FramesMax]*CallFrame
+	frames       [FramesMax]CallFrame // by value, so a call allocates nothing
 	frameCount   int
 	currentFrame *CallFrame
 
@@ -95,22 +98,56 @@ type VM struct {
 	shared *SharedState
 	Config VMConfig
 
-	// IO Management
-	openFiles map[int64]*os.File
-	nextFD    int64
-
-	// Net Management (Moved to SharedState)
-	netBufferedData  map[int][]byte   // For peeked data during select (Local to thread/VM?)
-	netBufferedConns map[int]net.Conn // For peeked accepts (Local to thread/VM?)
-	// netListeners, netConns, nextNetID removed from VM
-
 	LastPopped value.Value
 
 	openUpvalues *value.ObjUpvalue // Head of linked list of open upvalues
+	tries        []tryHandler      // try blocks being run, innermost last
+
+	trace *tracer // Created on first use when Config.Trace is on
 }
 
+// ModuleCacheDir is the conventional name of the compiled module cache,
+// created next to the program's entry point.
+const ModuleCacheDir = ".noxy-cache"
+
 type VMConfig struct {
 	RootPath string
+	// ModuleCache is the directory where compiled modules are cached,
+	// keyed by content hash. Empty disables the cache.
+	ModuleCache string
+	// CheckedArithmetic turns integer overflow, integer division that
+	// truncates a negative quotient, and NaN or infinite float results
+	// into runtime errors instead of silently producing wrong values.
+	CheckedArithmetic bool
+	// Strict compiles imported modules with compiler.Compiler.Strict, so
+	// their type annotations are also checked at runtime.
+	Strict bool
+	// ReportLeaks makes Close list the handles the script never closed.
+	ReportLeaks bool
+	// Stdout and Stderr receive the output of print, iprint, print_raw and
+	// print_err (os.Stdout and os.Stderr when nil). flush and input flush
+	// them if they buffer.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Trace reports executed lines or instructions (see SetTraceHook),
+	// only inside the function named TraceFunc when it is set, and stops
+	// after TraceLimit events when that is positive.
+	Trace      TraceMode
+	TraceFunc  string
+	TraceLimit int
+	// WorkDir is the working directory the script sees: relative paths
+	// given to the io natives, sys.exec and sys.chdir resolve against it,
+	// and sys.getcwd reports it. The process working directory is never
+	// changed, so VMs in one process can each have their own. Empty means
+	// the process working directory.
+	WorkDir string
+	// Args are the arguments given to the script after its file name,
+	// which the config module reads flags from.
+	Args []string
+	// PreloadModules loads every module the program imports, and the
+	// modules those import, before its first statement runs, instead of
+	// when each is first used. A missing module is then reported at once.
+	PreloadModules bool
 }
 
 func New() *VM {
@@ -119,61 +156,65 @@ func New() *VM {
 
 func NewWithConfig(cfg VMConfig) *VM {
 	shared := &SharedState{
-		Globals:      make(map[string]value.Value),
-		Modules:      make(map[string]value.Value),
-		NetListeners: make(map[int]net.Listener),
-		NetConns:     make(map[int]net.Conn),
-		NextNetID:    1,
-		DbHandles:    make(map[int]*sql.DB),
-		StmtHandles:  make(map[int]*sql.Stmt),
-		StmtParams:   make(map[int]map[int]interface{}),
-		NextDbID:     1,
-		NextStmtID:   1,
+		Globals:       make(map[string]value.Value),
+		Modules:       newModuleRegistry(),
+		NativeModules: make(map[string]map[string]value.Value),
+		Plugins:       plugin.NewRegistry(),
+	}
+	shared.WorkDir = absWorkDir(cfg.WorkDir)
+	vm := NewWithShared(shared, cfg)
+	// Domain natives keep state shared by all threads, so they are
+	// registered once here rather than by every NewWithShared
+	for _, register := range nativeDomains {
+		if res := register(vm); res != nil {
+			shared.Resources = append(shared.Resources, res)
+		}
 	}
-	return NewWithShared(shared, cfg)
+	vm.defineStubNatives()
+	vm.defineFlagNatives()
+	vm.defineErrorNatives()
+	return vm
 }
 
 func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 	vm := &VM{
-		shared:    shared,
-		Config:    cfg,
-		openFiles: make(map[int64]*os.File),
-		nextFD:    1,
-
-		netBufferedData:  make(map[int][]byte),
-		netBufferedConns: make(map[int]net.Conn),
+		shared: shared,
+		Config: cfg,
 	}
 
-	// Define 'print' native
+	// Printing natives: print and print_err end with a newline, iprint
+	// does not, and print_raw writes its arguments back to back
 	vm.DefineNative("print", func(args []value.Value) value.Value {
-		var parts []string
-		for _, arg := range args {
-			parts = append(parts, arg.String())
-		}
-		fmt.Println(strings.Join(parts, " "))
+		fmt.Fprintln(vm.stdout(), joinArgs(args, " "))
 		return value.NewNull()
 	})
-
-	// Define 'iprint' native (inline print)
 	vm.DefineNative("iprint", func(args []value.Value) value.Value {
-		var parts []string
-		for _, arg := range args {
-			parts = append(parts, arg.String())
-		}
-		fmt.Print(strings.Join(parts, " "))
+		fmt.Fprint(vm.stdout(), joinArgs(args, " "))
+		return value.NewNull()
+	})
+	vm.DefineNative("print_raw", func(args []value.Value) value.Value {
+		fmt.Fprint(vm.stdout(), joinArgs(args, ""))
+		return value.NewNull()
+	})
+	vm.DefineNative("print_err", func(args []value.Value) value.Value {
+		fmt.Fprintln(vm.stderr(), joinArgs(args, " "))
+		return value.NewNull()
+	})
+	vm.DefineNative("flush", func(args []value.Value) value.Value {
+		flushWriter(vm.stdout())
+		flushWriter(vm.stderr())
 		return value.NewNull()
 	})
 
 	// Concurrency Primitives
 	vm.DefineNative("spawn", func(args []value.Value) value.Value {
 		if len(args) < 1 {
-			return value.NewNull()
+			return value.NewNativeError("expects a function")
 		}
 		fnVal := args[0]
 		if fnVal.Type != value.VAL_FUNCTION {
 			// Only script functions are supported in spawn.
-			fmt.Println("Runtime Error: spawn expects a function")
-			return value.NewNull()
+			return value.NewNativeError("expects a script function, got %s", value.TypeName(fnVal))
 		}
 
 		threadArgs := args[1:]
@@ -188,1171 +229,297 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		} else if fn, ok := fnVal.Obj.(*value.ObjFunction); ok {
 			closure = &value.ObjClosure{Function: fn, Upvalues: []*value.ObjUpvalue{}}
 		} else {
-			fmt.Println("Runtime Error: spawn expects a function or closure")
-			return value.NewNull()
+			return value.NewNativeError("expects a function or closure")
 		}
 
 		fnObj := closure.Function
 
 		// Check arity
 		if len(threadArgs) != fnObj.Arity {
-			fmt.Printf("Runtime Error: spawn expected %d args, got %d\n", fnObj.Arity, len(threadArgs))
-			return value.NewNull()
-		}
-
-		// Push Function (Stack slot 0)
-		threadVM.push(fnVal)
-
-		// Push Args
-		for _, arg := range threadArgs {
-			threadVM.push(arg)
-		}
-
-		// Create Frame
-		frame := &CallFrame{
-			Closure: closure,
-			IP:      0,
-			Slots:   0,
-			Globals: nil,
-		}
-
-		// Inherit globals from the function/closure.
-		frame.Globals = fnObj.Globals
-
-		threadVM.frames[0] = frame
-		threadVM.frameCount = 1
-		threadVM.currentFrame = frame
-
-		// Launch Goroutine
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					fmt.Printf("Thread Panic: %v\n%s", r, debug.Stack())
-				}
-			}()
-			err := threadVM.run(1) // Run until finished (frame 0 popped)
-			if err != nil {
-				fmt.Printf("Thread Error: %v\n", err)
-			}
-		}()
-
-		return value.NewNull()
-	})
-
-	vm.DefineNative("make_chan", func(args []value.Value) value.Value {
-		size := 0
-		if len(args) > 0 {
-			if args[0].Type == value.VAL_INT {
-				size = int(args[0].AsInt)
-			}
-		}
-		return value.NewChannel(size)
-	})
-
-	vm.DefineNative("chan_send", func(args []value.Value) value.Value {
-		if len(args) != 2 {
-			return value.NewNull()
-		}
-		if args[0].Type != value.VAL_CHANNEL {
-			return value.NewNull()
-		}
-		ch := args[0].Obj.(*value.ObjChannel).Chan
-		ch <- args[1]
-		return args[1]
-	})
-
-	vm.DefineNative("chan_close", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewNull()
-		}
-		if args[0].Type != value.VAL_CHANNEL {
-			return value.NewNull()
-		}
-		chObj := args[0].Obj.(*value.ObjChannel)
-
-		chObj.Lock.Lock()
-		defer chObj.Lock.Unlock()
-
-		if !chObj.Closed {
-			close(chObj.Chan)
-			chObj.Closed = true
-		}
-		return value.NewNull()
-	})
-
-	vm.DefineNative("chan_is_closed", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewBool(false)
-		}
-		if args[0].Type != value.VAL_CHANNEL {
-			return value.NewBool(false)
-		}
-		chObj := args[0].Obj.(*value.ObjChannel)
-
-		chObj.Lock.Lock()
-		defer chObj.Lock.Unlock()
-
-		return value.NewBool(chObj.Closed)
-	})
-
-	vm.DefineNative("chan_recv", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewNull()
-		}
-		if args[0].Type != value.VAL_CHANNEL {
-			return value.NewNull()
-		}
-		ch := args[0].Obj.(*value.ObjChannel).Chan
-		val, ok := <-ch
-		if !ok {
-			return value.NewNull()
-		}
-		return val
-	})
-
-	vm.DefineNative("make_wg", func(args []value.Value) value.Value {
-		return value.NewWaitGroup()
-	})
-
-	vm.DefineNative("wg_add", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		if args[0].Type != value.VAL_WAITGROUP {
-			return value.NewNull()
-		}
-		delta := int(0)
-		if args[1].Type == value.VAL_INT {
-			delta = int(args[1].AsInt)
-		}
-		if delta == 0 {
-			return value.NewNull()
-		}
-		wg := args[0].Obj.(*value.ObjWaitGroup).Wg
-		wg.Add(delta)
-		return value.NewNull()
-	})
-
-	vm.DefineNative("wg_done", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewNull()
-		}
-		if args[0].Type != value.VAL_WAITGROUP {
-			return value.NewNull()
-		}
-		wg := args[0].Obj.(*value.ObjWaitGroup).Wg
-		wg.Done()
-		return value.NewNull()
-	})
-
-	vm.DefineNative("wg_wait", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewNull()
-		}
-		if args[0].Type != value.VAL_WAITGROUP {
-			return value.NewNull()
-		}
-		wg := args[0].Obj.(*value.ObjWaitGroup).Wg
-		wg.Wait()
-		return value.NewNull()
-	})
-
-	vm.DefineNative("to_str", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			// Should return error or empty?
-			return value.NewString("")
-		}
-		if args[0].Type == value.VAL_BYTES {
-			return value.NewString(args[0].Obj.(string))
-		}
-		return value.NewString(args[0].String())
-	})
-	vm.DefineNative("to_int", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewInt(0)
-		}
-		v := args[0]
-		if v.Type == value.VAL_INT {
-			return value.NewInt(v.AsInt)
-		}
-		if v.Type == value.VAL_FLOAT {
-			return value.NewInt(int64(v.AsFloat))
-		}
-		if v.Type == value.VAL_OBJ {
-			if s, ok := v.Obj.(string); ok {
-				if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-					return value.NewInt(i)
-				}
-				if f, err := strconv.ParseFloat(s, 64); err == nil {
-					return value.NewInt(int64(f))
-				}
-			}
-		}
-		return value.NewInt(0)
-	})
-	vm.DefineNative("to_float", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewFloat(0.0)
-		}
-		v := args[0]
-		if v.Type == value.VAL_FLOAT {
-			return value.NewFloat(v.AsFloat)
-		}
-		if v.Type == value.VAL_INT {
-			return value.NewFloat(float64(v.AsInt))
-		}
-		if v.Type == value.VAL_OBJ {
-			if s, ok := v.Obj.(string); ok {
-				if f, err := strconv.ParseFloat(s, 64); err == nil {
-					return value.NewFloat(f)
-				}
-			}
-		}
-		return value.NewFloat(0.0)
-	})
-	vm.DefineNative("time_now_ms", func(args []value.Value) value.Value {
-		return value.NewInt(time.Now().UnixMilli())
-	})
-	vm.DefineNative("time_now", func(args []value.Value) value.Value {
-		return value.NewInt(time.Now().Unix())
-	})
-
-	vm.DefineNative("time_sleep", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewNull()
-		}
-		ms := args[0].AsInt
-		time.Sleep(time.Duration(ms) * time.Millisecond)
-		return value.NewNull()
-	})
-	vm.DefineNative("time_now_datetime", func(args []value.Value) value.Value {
-		// args[0] is DateTime struct def
-		if len(args) < 1 {
-			return value.NewNull()
-		}
-		structDef, ok := args[0].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
-		}
-
-		t := time.Now()
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["year"] = value.NewInt(int64(t.Year()))
-		inst.Fields["month"] = value.NewInt(int64(t.Month()))
-		inst.Fields["day"] = value.NewInt(int64(t.Day()))
-		inst.Fields["hour"] = value.NewInt(int64(t.Hour()))
-		inst.Fields["minute"] = value.NewInt(int64(t.Minute()))
-		inst.Fields["second"] = value.NewInt(int64(t.Second()))
-		inst.Fields["weekday"] = value.NewInt(int64(t.Weekday()))
-		inst.Fields["yearday"] = value.NewInt(int64(t.YearDay()))
-		inst.Fields["timestamp"] = value.NewInt(t.Unix())
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
-	})
-	vm.DefineNative("time_format", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewString("")
-		}
-
-		// Reconstruct time.Time from fields
-		// Minimal fields: year, month, day, hour, minute, second
-		y := int(inst.Fields["year"].AsInt)
-		m := time.Month(inst.Fields["month"].AsInt)
-		d := int(inst.Fields["day"].AsInt)
-		h := int(inst.Fields["hour"].AsInt)
-		min := int(inst.Fields["minute"].AsInt)
-		s := int(inst.Fields["second"].AsInt)
-
-		t := time.Date(y, m, d, h, min, s, 0, time.Local)
-		return value.NewString(t.Format("2006-01-02 15:04:05"))
-	})
-	vm.DefineNative("time_format_date", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewString("")
-		}
-		y := int(inst.Fields["year"].AsInt)
-		m := time.Month(inst.Fields["month"].AsInt)
-		d := int(inst.Fields["day"].AsInt)
-		t := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
-		return value.NewString(t.Format("2006-01-02"))
-	})
-	vm.DefineNative("time_format_time", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewString("")
-		}
-		h := int(inst.Fields["hour"].AsInt)
-		min := int(inst.Fields["minute"].AsInt)
-		s := int(inst.Fields["second"].AsInt)
-		t := time.Date(0, 1, 1, h, min, s, 0, time.Local)
-		return value.NewString(t.Format("15:04:05"))
-	})
-	vm.DefineNative("time_make_datetime", func(args []value.Value) value.Value {
-		// args: structDef, y, m, d, h, min, s
-		if len(args) < 7 {
-			return value.NewNull()
-		}
-		structDef, ok := args[0].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
-		}
-
-		y := int(args[1].AsInt)
-		m := time.Month(args[2].AsInt)
-		d := int(args[3].AsInt)
-		h := int(args[4].AsInt)
-		min := int(args[5].AsInt)
-		s := int(args[6].AsInt)
-
-		t := time.Date(y, m, d, h, min, s, 0, time.Local)
-
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["year"] = value.NewInt(int64(t.Year()))
-		inst.Fields["month"] = value.NewInt(int64(t.Month()))
-		inst.Fields["day"] = value.NewInt(int64(t.Day()))
-		inst.Fields["hour"] = value.NewInt(int64(t.Hour()))
-		inst.Fields["minute"] = value.NewInt(int64(t.Minute()))
-		inst.Fields["second"] = value.NewInt(int64(t.Second()))
-		inst.Fields["weekday"] = value.NewInt(int64(t.Weekday()))
-		inst.Fields["yearday"] = value.NewInt(int64(t.YearDay()))
-		inst.Fields["timestamp"] = value.NewInt(t.Unix())
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
-	})
-	vm.DefineNative("time_to_timestamp", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewInt(0)
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewInt(0)
-		}
-
-		val, ok := inst.Fields["timestamp"]
-		if ok {
-			return val
-		}
-		return value.NewInt(0)
-	})
-	vm.DefineNative("time_from_timestamp", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		ts := args[0].AsInt
-		structDef, ok := args[1].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
-		}
-
-		t := time.Unix(ts, 0)
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["year"] = value.NewInt(int64(t.Year()))
-		inst.Fields["month"] = value.NewInt(int64(t.Month()))
-		inst.Fields["day"] = value.NewInt(int64(t.Day()))
-		inst.Fields["hour"] = value.NewInt(int64(t.Hour()))
-		inst.Fields["minute"] = value.NewInt(int64(t.Minute()))
-		inst.Fields["second"] = value.NewInt(int64(t.Second()))
-		inst.Fields["weekday"] = value.NewInt(int64(t.Weekday()))
-		inst.Fields["yearday"] = value.NewInt(int64(t.YearDay()))
-		inst.Fields["timestamp"] = value.NewInt(t.Unix())
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
-	})
-	vm.DefineNative("time_diff", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewInt(0)
-		}
-		ts1 := args[0].AsInt
-		ts2 := args[1].AsInt
-		return value.NewInt(ts1 - ts2)
-	})
-	vm.DefineNative("time_add_days", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewInt(0)
-		}
-		ts := args[0].AsInt
-		days := args[1].AsInt
-		return value.NewInt(ts + (days * 86400))
-	})
-	vm.DefineNative("time_before", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewBool(false)
-		}
-		return value.NewBool(args[0].AsInt < args[1].AsInt)
-	})
-	vm.DefineNative("time_after", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewBool(false)
-		}
-		return value.NewBool(args[0].AsInt > args[1].AsInt)
-	})
-	vm.DefineNative("time_is_leap_year", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(false)
-		}
-		year := args[0].AsInt
-		return value.NewBool(year%4 == 0 && (year%100 != 0 || year%400 == 0))
-	})
-	vm.DefineNative("time_days_in_month", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewInt(0)
-		}
-		year := int(args[0].AsInt)
-		month := time.Month(args[1].AsInt)
-		// Trick: go to next month day 0
-		t := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
-		return value.NewInt(int64(t.Day()))
-	})
-	vm.DefineNative("time_weekday_name", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
-		}
-		wd := time.Weekday(args[0].AsInt)
-
-		names := []string{
-			"Domingo", "Segunda-feira", "Terça-feira", "Quarta-feira",
-			"Quinta-feira", "Sexta-feira", "Sábado",
-		}
-		if int(wd) >= 0 && int(wd) < len(names) {
-			return value.NewString(names[wd])
-		}
-		return value.NewString(wd.String())
-	})
-	vm.DefineNative("time_month_name", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
-		}
-		m := time.Month(args[0].AsInt)
-		names := map[time.Month]string{
-			time.January: "Janeiro", time.February: "Fevereiro", time.March: "Março",
-			time.April: "Abril", time.May: "Maio", time.June: "Junho",
-			time.July: "Julho", time.August: "Agosto", time.September: "Setembro",
-			time.October: "Outubro", time.November: "Novembro", time.December: "Dezembro",
-		}
-		if name, ok := names[m]; ok {
-			return value.NewString(name)
-		}
-		return value.NewString(m.String())
-	})
-	vm.DefineNative("io_open", func(args []value.Value) value.Value {
-		// args: path, mode, FileStructDef
-		if len(args) < 3 {
-			return value.NewNull()
-		}
-		path := args[0].String()
-		mode := args[1].String()
-
-		structDef, ok := args[2].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
-		}
-
-		flag := os.O_RDONLY
-		if mode == "w" {
-			flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
-		} else if mode == "a" {
-			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
-		} else if mode == "rw" || mode == "r+" {
-			flag = os.O_RDWR | os.O_CREATE
-		}
-
-		f, err := os.OpenFile(path, flag, 0644)
-		isOpen := true
-		var fd int64 = 0
-
-		if err != nil {
-			isOpen = false
-		} else {
-			fd = vm.nextFD
-			vm.nextFD++
-			vm.openFiles[fd] = f
-		}
-
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["fd"] = value.NewInt(fd)
-		inst.Fields["path"] = value.NewString(path)
-		inst.Fields["mode"] = value.NewString(mode)
-		inst.Fields["open"] = value.NewBool(isOpen)
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
-	})
-	vm.DefineNative("io_close", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewNull()
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-
-		fd := inst.Fields["fd"].AsInt
-		if f, exists := vm.openFiles[fd]; exists {
-			f.Close()
-			delete(vm.openFiles, fd)
-			inst.Fields["open"] = value.NewBool(false)
-		}
-		return value.NewNull()
-	})
-	vm.DefineNative("io_write", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-
-		fd := inst.Fields["fd"].AsInt
-		if f, exists := vm.openFiles[fd]; exists {
-			if args[1].Type == value.VAL_BYTES {
-				// Bytes are stored as string in Obj, but treat as raw bytes
-				data := args[1].Obj.(string)
-				f.Write([]byte(data))
-			} else {
-				content := args[1].String()
-				f.WriteString(content)
-			}
-		}
-		return value.NewNull()
-	})
-	vm.DefineNative("io_read", func(args []value.Value) value.Value {
-		// args: fileInst, IOResultStructDef
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-		resStruct, ok := args[1].Obj.(*value.ObjStruct) // IOResult
-		if !ok {
-			return value.NewNull()
-		}
-
-		fd := inst.Fields["fd"].AsInt
-		var contentStr string
-		var errorStr string
-		var isOk bool = false
-
-		if f, exists := vm.openFiles[fd]; exists {
-			// Read all
-			stat, _ := f.Stat()
-			if stat.Size() > 0 {
-				buf := make([]byte, stat.Size())
-				f.Seek(0, 0)
-				n, err := f.Read(buf)
-				if err == nil || (err != nil && n > 0) { // simple read
-					contentStr = string(buf[:n])
-					isOk = true
-				} else {
-					errorStr = err.Error()
-				}
-			} else {
-				isOk = true // empty file
-			}
-		} else {
-			errorStr = "File not open"
-		}
-
-		resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-		resInst.Fields["ok"] = value.NewBool(isOk)
-		resInst.Fields["data"] = value.NewString(contentStr)
-		resInst.Fields["error"] = value.NewString(errorStr)
-		return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-	})
-
-	vm.DefineNative("io_read_bytes", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-		resStruct, ok := args[1].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
-		}
-
-		fd := inst.Fields["fd"].AsInt
-		var contentBytes []byte
-		var errorStr string
-		var isOk bool = false
-
-		if f, exists := vm.openFiles[fd]; exists {
-			// Read all
-			stat, _ := f.Stat()
-			if stat.Size() > 0 {
-				buf := make([]byte, stat.Size())
-				f.Seek(0, 0)
-				n, err := f.Read(buf)
-				if err == nil || (err != nil && n > 0) {
-					contentBytes = buf[:n]
-					isOk = true
-				} else {
-					errorStr = err.Error()
-				}
-			} else {
-				contentBytes = []byte{}
-				isOk = true
-			}
-		} else {
-			errorStr = "File not open"
-		}
-
-		resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-		resInst.Fields["ok"] = value.NewBool(isOk)
-		resInst.Fields["data"] = value.NewBytes(string(contentBytes))
-		resInst.Fields["error"] = value.NewString(errorStr)
-		return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-	})
-	vm.DefineNative("io_exists", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(false)
-		}
-		path := args[0].String()
-		_, err := os.Stat(path)
-		return value.NewBool(err == nil)
-	})
-	vm.DefineNative("io_remove", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(false)
-		}
-		path := args[0].String()
-		err := os.Remove(path)
-		return value.NewBool(err == nil)
-	})
-	vm.DefineNative("io_read_lines", func(args []value.Value) value.Value {
-		// args: fileInst, IOLinesResultStructDef
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-		resStruct, ok := args[1].Obj.(*value.ObjStruct) // IOLinesResult
-		if !ok {
-			return value.NewNull()
-		}
-
-		fd := inst.Fields["fd"].AsInt
-		var lines []string
-		var errorStr string
-		var isOk bool = false
-
-		if f, exists := vm.openFiles[fd]; exists {
-			// Read all
-			stat, _ := f.Stat()
-			var contentStr string
-			if stat.Size() > 0 {
-				f.Seek(0, 0)
-				buf := make([]byte, stat.Size())
-				n, err := f.Read(buf)
-				if err == nil || (err != nil && n > 0) {
-					contentStr = string(buf[:n])
-					isOk = true
-				} else {
-					errorStr = err.Error()
-				}
-			} else {
-				isOk = true
-			}
-
-			if isOk {
-				// Split by newlines, handling \r\n and \n
-				// Naive split
-				contentStr = strings.ReplaceAll(contentStr, "\r\n", "\n")
-				lines = strings.Split(contentStr, "\n")
-				// Retain behavior of strings.Split where trailing newline results in an empty string.
-			}
-		} else {
-			errorStr = "File not open"
-		}
-
-		resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-		resInst.Fields["ok"] = value.NewBool(isOk)
-
-		linesVal := make([]value.Value, len(lines))
-		for i, line := range lines {
-			linesVal[i] = value.NewString(line)
-		}
-		resInst.Fields["data"] = value.NewArray(linesVal)
-
-		resInst.Fields["error"] = value.NewString(errorStr)
-		return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-	})
-	vm.DefineNative("io_stat", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		path := args[0].String()
-		structDef, ok := args[1].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
-		}
-
-		info, err := os.Stat(path)
-		exists := (err == nil)
-		size := int64(0)
-		isDir := false
-		if exists {
-			size = info.Size()
-			isDir = info.IsDir()
-		}
-
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["exists"] = value.NewBool(exists)
-		inst.Fields["size"] = value.NewInt(size)
-		inst.Fields["is_dir"] = value.NewBool(isDir)
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
-	})
-	vm.DefineNative("io_mkdir", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(false)
-		}
-		path := args[0].String()
-		err := os.MkdirAll(path, 0755)
-		return value.NewBool(err == nil)
-	})
-
-	vm.DefineNative("time_format_custom", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewString("")
-		}
-		inst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewString("")
-		}
-		fmtStr := args[1].Obj.(string)
-
-		y := int(inst.Fields["year"].AsInt)
-		m := time.Month(inst.Fields["month"].AsInt)
-		d := int(inst.Fields["day"].AsInt)
-		h := int(inst.Fields["hour"].AsInt)
-		min := int(inst.Fields["minute"].AsInt)
-		s := int(inst.Fields["second"].AsInt)
-		// t := time.Date(y, m, d, h, min, s, 0, time.Local) // Unused in this simple implementation
-
-		// Simplified replacement for strftime
-		// Noxy: %Y=ano, %m=mês, %d=dia, %H=hora, %M=min, %S=seg
-		res := fmtStr
-		res = strings.ReplaceAll(res, "%Y", fmt.Sprintf("%04d", y))
-		res = strings.ReplaceAll(res, "%m", fmt.Sprintf("%02d", m))
-		res = strings.ReplaceAll(res, "%d", fmt.Sprintf("%02d", d))
-		res = strings.ReplaceAll(res, "%H", fmt.Sprintf("%02d", h))
-		res = strings.ReplaceAll(res, "%M", fmt.Sprintf("%02d", min))
-		res = strings.ReplaceAll(res, "%S", fmt.Sprintf("%02d", s))
-
-		return value.NewString(res)
-	})
-	vm.DefineNative("time_parse", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		str := args[0].Obj.(string)
-		structDef, ok := args[1].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
-		}
-
-		t, err := time.ParseInLocation("2006-01-02 15:04:05", str, time.Local)
-		if err != nil {
-			return value.NewNull()
-		}
-
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["year"] = value.NewInt(int64(t.Year()))
-		inst.Fields["month"] = value.NewInt(int64(t.Month()))
-		inst.Fields["day"] = value.NewInt(int64(t.Day()))
-		inst.Fields["hour"] = value.NewInt(int64(t.Hour()))
-		inst.Fields["minute"] = value.NewInt(int64(t.Minute()))
-		inst.Fields["second"] = value.NewInt(int64(t.Second()))
-		inst.Fields["weekday"] = value.NewInt(int64(t.Weekday()))
-		inst.Fields["yearday"] = value.NewInt(int64(t.YearDay()))
-		inst.Fields["timestamp"] = value.NewInt(t.Unix())
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
-	})
-	vm.DefineNative("time_parse_date", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		str := args[0].Obj.(string)
-		structDef, ok := args[1].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
-		}
-
-		t, err := time.ParseInLocation("2006-01-02", str, time.Local)
-		if err != nil {
-			return value.NewNull()
-		}
-
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["year"] = value.NewInt(int64(t.Year()))
-		inst.Fields["month"] = value.NewInt(int64(t.Month()))
-		inst.Fields["day"] = value.NewInt(int64(t.Day()))
-		inst.Fields["hour"] = value.NewInt(int64(t.Hour()))
-		inst.Fields["minute"] = value.NewInt(int64(t.Minute()))
-		inst.Fields["second"] = value.NewInt(int64(t.Second()))
-		inst.Fields["weekday"] = value.NewInt(int64(t.Weekday()))
-		inst.Fields["yearday"] = value.NewInt(int64(t.YearDay()))
-		inst.Fields["timestamp"] = value.NewInt(t.Unix())
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
-	})
-	vm.DefineNative("time_add_seconds", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewInt(0)
-		}
-		ts := args[0].AsInt
-		secs := args[1].AsInt
-		return value.NewInt(ts + secs)
-	})
-	vm.DefineNative("time_diff_duration", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewNull()
-		}
-		ts1 := args[0].AsInt
-		ts2 := args[1].AsInt
-		structDef, ok := args[2].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
+			return value.NewNativeError("function '%s' expects %d arguments but got %d", fnObj.Name, fnObj.Arity, len(threadArgs))
 		}
 
-		diff := ts1 - ts2
-		if diff < 0 {
-			diff = -diff
-		}
+		// Push Function (Stack slot 0)
+		threadVM.push(fnVal)
 
-		totalSecs := ts1 - ts2
-		absSecs := totalSecs
-		if absSecs < 0 {
-			absSecs = -absSecs
+		// Push Args
+		for _, arg := range threadArgs {
+			threadVM.push(arg)
 		}
 
-		days := absSecs / 86400
-		rem := absSecs % 86400
-		hours := rem / 3600
-		rem = rem % 3600
-		mins := rem / 60
-		secs := rem % 60
+		// Create Frame, inheriting globals from the function/closure
+		threadVM.frames[0] = CallFrame{
+			Closure: closure,
+			IP:      0,
+			Slots:   0,
+			Globals: fnObj.Globals,
+		}
+		threadVM.frameCount = 1
+		threadVM.currentFrame = &threadVM.frames[0]
 
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["days"] = value.NewInt(days)
-		inst.Fields["hours"] = value.NewInt(hours)
-		inst.Fields["minutes"] = value.NewInt(mins)
-		inst.Fields["seconds"] = value.NewInt(secs)
-		inst.Fields["total_seconds"] = value.NewInt(totalSecs)
+		// Launch Goroutine
+		go func() {
+			err := threadVM.run(1) // Run until finished (frame 0 popped)
+			var exit *ExitError
+			if errors.As(err, &exit) {
+				threadVM.exit(exit.Code)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Thread Error: %v\n", err)
+			}
+		}()
 
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+		return value.NewNull()
 	})
 
-	// Strings Module
-	vm.DefineNative("strings_contains", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewBool(false)
-		}
-		return value.NewBool(strings.Contains(args[0].String(), args[1].String()))
-	})
-	vm.DefineNative("strings_starts_with", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewBool(false)
+	vm.DefineNative("make_chan", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "int?"); !ok {
+			return err
 		}
-		return value.NewBool(strings.HasPrefix(args[0].String(), args[1].String()))
-	})
-	vm.DefineNative("strings_ends_with", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewBool(false)
+		size := 0
+		if len(args) > 0 {
+			size = int(args[0].AsInt)
 		}
-		return value.NewBool(strings.HasSuffix(args[0].String(), args[1].String()))
-	})
-	vm.DefineNative("strings_index_of", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewInt(-1)
+		if size < 0 {
+			return value.NewNativeError("buffer size must not be negative, got %d", size)
 		}
-		return value.NewInt(int64(strings.Index(args[0].String(), args[1].String())))
+		return value.NewChannel(size)
 	})
-	vm.DefineNative("strings_count", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewInt(0)
+
+	vm.DefineNative("chan_send", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "channel", "any"); !ok {
+			return err
 		}
-		return value.NewInt(int64(strings.Count(args[0].String(), args[1].String())))
-	})
-	vm.DefineNative("strings_to_upper", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
+		if !sendOn(args[0].Obj.(*value.ObjChannel).Chan, args[1]) {
+			return value.NewNativeError("send on closed channel")
 		}
-		return value.NewString(strings.ToUpper(args[0].String()))
+		return args[1]
 	})
-	vm.DefineNative("strings_to_lower", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
+
+	vm.DefineNative("chan_close", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "channel"); !ok {
+			return err
 		}
-		return value.NewString(strings.ToLower(args[0].String()))
-	})
-	vm.DefineNative("strings_trim", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
+		chObj := args[0].Obj.(*value.ObjChannel)
+
+		chObj.Lock.Lock()
+		defer chObj.Lock.Unlock()
+
+		if !chObj.Closed {
+			close(chObj.Chan)
+			chObj.Closed = true
 		}
-		return value.NewString(strings.TrimSpace(args[0].String()))
+		return value.NewNull()
 	})
 
-	// Input
-	vm.DefineNative("input", func(args []value.Value) value.Value {
-		// args[0]: prompt (optional)
-		if len(args) > 0 {
-			fmt.Print(args[0].String())
+	vm.DefineNative("chan_is_closed", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "channel"); !ok {
+			return err
 		}
-		reader := bufio.NewReader(os.Stdin)
-		text, _ := reader.ReadString('\n')
-		// Trim newline (windows \r\n and unix \n)
-		text = strings.TrimRight(text, "\r\n")
-		return value.NewString(text)
+		chObj := args[0].Obj.(*value.ObjChannel)
+
+		chObj.Lock.Lock()
+		defer chObj.Lock.Unlock()
+
+		return value.NewBool(chObj.Closed)
 	})
-	vm.DefineNative("strings_reverse", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
-		}
-		s := args[0].String()
-		runes := []rune(s)
-		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
-			runes[i], runes[j] = runes[j], runes[i]
+
+	vm.DefineNative("chan_recv", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "channel"); !ok {
+			return err
 		}
-		return value.NewString(string(runes))
-	})
-	vm.DefineNative("strings_repeat", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewString("")
+		ch := args[0].Obj.(*value.ObjChannel).Chan
+		val, ok := <-ch
+		if !ok {
+			return value.NewNull()
 		}
-		return value.NewString(strings.Repeat(args[0].String(), int(args[1].AsInt)))
+		return val
 	})
 
-	vm.DefineNative("strings_replace", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewString("")
-		}
-		return value.NewString(strings.ReplaceAll(args[0].String(), args[1].String(), args[2].String()))
+	vm.DefineNative("make_wg", func(args []value.Value) value.Value {
+		return value.NewWaitGroup()
 	})
-	vm.DefineNative("strings_replace_first", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewString("")
+
+	vm.DefineNative("wg_add", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "waitgroup", "int"); !ok {
+			return err
 		}
-		return value.NewString(strings.Replace(args[0].String(), args[1].String(), args[2].String(), 1))
-	})
-	vm.DefineNative("strings_pad_left", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewString("")
+		delta := int(args[1].AsInt)
+		if delta == 0 {
+			return value.NewNull()
 		}
-		s := args[0].String()
-		totalLen := int(args[1].AsInt)
-		padChar := args[2].String()
-		if len(s) >= totalLen {
-			return value.NewString(s)
+		if !args[0].Obj.(*value.ObjWaitGroup).Add(delta) {
+			return value.NewNativeError("negative counter")
 		}
-		padding := totalLen - len(s)
-		return value.NewString(strings.Repeat(padChar, padding) + s)
+		return value.NewNull()
 	})
-	vm.DefineNative("strings_split", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewNull()
+
+	vm.DefineNative("wg_done", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "waitgroup"); !ok {
+			return err
 		}
-		s := args[0].String()
-		sep := args[1].String()
-		structDef, ok := args[2].Obj.(*value.ObjStruct)
-		if !ok {
-			return value.NewNull()
+		if !args[0].Obj.(*value.ObjWaitGroup).Add(-1) {
+			return value.NewNativeError("called more times than wg_add added")
 		}
+		return value.NewNull()
+	})
 
-		parts := strings.Split(s, sep)
-
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["count"] = value.NewInt(int64(len(parts)))
-
-		partValues := make([]value.Value, len(parts))
-		for i, p := range parts {
-			partValues[i] = value.NewString(p)
+	vm.DefineNative("wg_wait", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "waitgroup"); !ok {
+			return err
 		}
-		inst.Fields["parts"] = value.NewArray(partValues)
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+		wg := args[0].Obj.(*value.ObjWaitGroup).Wg
+		wg.Wait()
+		return value.NewNull()
 	})
-	vm.DefineNative("strings_join_count", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewString("")
-		}
-		arrVal := args[0]
-		sep := args[1].String()
-		count := int(args[2].AsInt)
 
-		if arrVal.Type == value.VAL_OBJ {
-			if arr, ok := arrVal.Obj.(*value.ObjArray); ok {
-				var parts []string
-				max := len(arr.Elements)
-				if count < max {
-					max = count
-				}
-				for i := 0; i < max; i++ {
-					parts = append(parts, arr.Elements[i].String())
-				}
-				return value.NewString(strings.Join(parts, sep))
-			}
+	vm.DefineNative("to_str", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "any"); !ok {
+			return err
 		}
-		return value.NewString("")
-	})
-	vm.DefineNative("ord", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewInt(0)
+		if args[0].Type == value.VAL_BYTES {
+			return value.NewString(args[0].Obj.(string))
 		}
-		s := args[0].String()
-		if len(s) == 0 {
-			return value.NewInt(0)
+		if buf, ok := args[0].Obj.(*value.ObjBuffer); ok {
+			return value.NewString(string(buf.Data))
 		}
-		return value.NewInt(int64(s[0]))
+		return value.NewString(args[0].String())
 	})
-	vm.DefineNative("strings_contains", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewBool(false)
+	vm.DefineNative("to_int", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "any"); !ok {
+			return err
 		}
-		s := args[0].String()
-		substr := args[1].String()
-		return value.NewBool(strings.Contains(s, substr))
-	})
-	vm.DefineNative("strings_replace", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewString("")
+		v := args[0]
+		if v.Type == value.VAL_INT {
+			return value.NewInt(v.AsInt)
 		}
-		s := args[0].String()
-		old := args[1].String()
-		new := args[2].String()
-		return value.NewString(strings.ReplaceAll(s, old, new))
-	})
-	vm.DefineNative("strings_substring", func(args []value.Value) value.Value {
-		// args: string, start, length
-		if len(args) < 3 {
-			return value.NewString("")
+		if v.Type == value.VAL_FLOAT {
+			return value.NewInt(int64(v.AsFloat))
 		}
-		s := args[0].String()
-		runes := []rune(s)
-		start := int(args[1].AsInt)
-		length := int(args[2].AsInt)
-
-		if start < 0 {
-			start = 0
+		if n, ok := v.Obj.(*value.ObjBigInt); ok {
+			if !n.Value.IsInt64() {
+				return value.NewNativeError("%s does not fit in an int", n.Value)
+			}
+			return value.NewInt(n.Value.Int64())
 		}
-		if start >= len(runes) {
-			return value.NewString("")
+		if d, ok := v.Obj.(*value.ObjDecimal); ok {
+			n := new(big.Int).Quo(d.Value.Num(), d.Value.Denom())
+			if !n.IsInt64() {
+				return value.NewNativeError("%s does not fit in an int", v)
+			}
+			return value.NewInt(n.Int64())
 		}
-
-		end := start + length
-		if end > len(runes) {
-			end = len(runes)
+		if s, ok := v.Obj.(string); ok && v.Type == value.VAL_OBJ {
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return value.NewInt(i)
+			}
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return value.NewInt(int64(f))
+			}
+			return value.NewNativeError("cannot convert %q to int", s)
 		}
-
-		return value.NewString(string(runes[start:end]))
+		return value.NewNativeError("cannot convert %s to int", value.TypeName(v))
 	})
-	vm.DefineNative("strings_is_empty", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(true)
+	vm.DefineNative("to_float", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "any"); !ok {
+			return err
 		}
-		return value.NewBool(len(args[0].String()) == 0)
-	})
-	vm.DefineNative("strings_is_digit", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(false)
+		v := args[0]
+		if v.Type == value.VAL_FLOAT {
+			return value.NewFloat(v.AsFloat)
 		}
-		s := args[0].String()
-		if len(s) == 0 {
-			return value.NewBool(false)
+		if v.Type == value.VAL_INT {
+			return value.NewFloat(float64(v.AsInt))
+		}
+		if n, ok := v.Obj.(*value.ObjBigInt); ok {
+			f, _ := new(big.Float).SetInt(n.Value).Float64()
+			return value.NewFloat(f)
 		}
-		for _, r := range s {
-			if r < '0' || r > '9' {
-				return value.NewBool(false)
+		if d, ok := v.Obj.(*value.ObjDecimal); ok {
+			f, _ := d.Value.Float64()
+			return value.NewFloat(f)
+		}
+		if s, ok := v.Obj.(string); ok && v.Type == value.VAL_OBJ {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return value.NewFloat(f)
 			}
+			return value.NewNativeError("cannot convert %q to float", s)
 		}
-		return value.NewBool(true)
+		return value.NewNativeError("cannot convert %s to float", value.TypeName(v))
 	})
-	vm.DefineNative("strings_is_alpha", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(false)
+	// inspect(value, indent = 2) -> multi-line representation. indent is a
+	// number of spaces or the indentation string itself.
+	vm.DefineNative("inspect", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "any", "int|string?"); !ok {
+			return err
 		}
-		s := args[0].String()
-		if len(s) == 0 {
-			return value.NewBool(false)
-		}
-		for _, r := range s {
-			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
-				return value.NewBool(false)
+		indent := "  "
+		if len(args) > 1 {
+			if args[1].Type == value.VAL_INT {
+				if args[1].AsInt <= 0 {
+					return value.NewString(value.Inspect(args[0], ""))
+				}
+				indent = strings.Repeat(" ", int(args[1].AsInt))
+			} else {
+				indent = args[1].Obj.(string)
 			}
 		}
-		return value.NewBool(true)
+		return value.NewString(value.Inspect(args[0], indent))
 	})
-	vm.DefineNative("strings_is_alnum", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(false)
-		}
-		s := args[0].String()
-		if len(s) == 0 {
-			return value.NewBool(false)
+	// freeze(value) makes arrays, maps, sets and instances read-only, deeply
+	vm.DefineNative("freeze", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "any"); !ok {
+			return err
 		}
-		for _, r := range s {
-			isDigit := r >= '0' && r <= '9'
-			isAlpha := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
-			if !isDigit && !isAlpha {
-				return value.NewBool(false)
-			}
+		return value.Freeze(args[0])
+	})
+	vm.DefineNative("is_frozen", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "any"); !ok {
+			return err
 		}
-		return value.NewBool(true)
+		return value.NewBool(value.IsFrozen(args[0]))
 	})
-	vm.DefineNative("strings_is_space", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewBool(false)
+	// float_format(value, decimals) formats with a fixed number of decimals
+	vm.DefineNative("float_format", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "number", "int"); !ok {
+			return err
 		}
-		s := args[0].String()
-		if len(s) == 0 {
-			return value.NewBool(false)
+		f := args[0].AsFloat
+		if args[0].Type == value.VAL_INT {
+			f = float64(args[0].AsInt)
 		}
-		for _, r := range s {
-			if r != ' ' && r != '\t' && r != '\n' && r != '\r' {
-				return value.NewBool(false)
-			}
+		if args[1].AsInt < 0 {
+			return value.NewString(value.FormatFloat(f))
 		}
-		return value.NewBool(true)
+		return value.NewString(strconv.FormatFloat(f, 'f', int(args[1].AsInt), 64))
 	})
-	vm.DefineNative("strings_char_at", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewString("")
-		}
-		s := args[0].String()
-		runes := []rune(s)
-		idx := int(args[1].AsInt)
-		if idx < 0 || idx >= len(runes) {
-			return value.NewString("")
+
+	// Input
+	vm.DefineNative("input", func(args []value.Value) value.Value {
+		// args[0]: prompt (optional)
+		if len(args) > 0 {
+			fmt.Fprint(vm.stdout(), args[0].String())
 		}
-		return value.NewString(string(runes[idx]))
+		flushWriter(vm.stdout())
+		reader := bufio.NewReader(os.Stdin)
+		text, _ := reader.ReadString('\n')
+		// Trim newline (windows \r\n and unix \n)
+		text = strings.TrimRight(text, "\r\n")
+		return value.NewString(text)
 	})
-	vm.DefineNative("strings_from_char_code", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewString("")
+	vm.DefineNative("ord", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "string"); !ok {
+			return err
+		}
+		s := args[0].String()
+		if len(s) == 0 {
+			return value.NewInt(0)
 		}
-		return value.NewString(string(rune(args[0].AsInt)))
+		return value.NewInt(int64(s[0]))
 	})
 
 	// Crypto Module - Native implementations for cryptographic operations
-	vm.DefineNative("crypto_random_bytes", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("crypto", "random_bytes", func(args []value.Value) value.Value {
 		if len(args) < 1 {
 			return value.NewNull()
 		}
@@ -1369,7 +536,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBytes(string(bytes))
 	})
 
-	vm.DefineNative("crypto_pbkdf2_sha256", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("crypto", "pbkdf2_sha256", func(args []value.Value) value.Value {
 		// args: (senha: string, salt: bytes, iteracoes: int, tamanho: int)
 		if len(args) < 4 {
 			return value.NewNull()
@@ -1396,7 +563,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBytes(string(chave))
 	})
 
-	vm.DefineNative("crypto_aes256_gcm_encrypt", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("crypto", "aes256_gcm_encrypt", func(args []value.Value) value.Value {
 		// args: (chave: bytes, texto: bytes) -> bytes (nonce + ciphertext + tag)
 		if len(args) < 2 {
 			return value.NewNull()
@@ -1442,7 +609,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBytes(string(resultado))
 	})
 
-	vm.DefineNative("crypto_aes256_gcm_decrypt", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("crypto", "aes256_gcm_decrypt", func(args []value.Value) value.Value {
 		// args: (chave: bytes, dados: bytes) -> bytes (plaintext)
 		if len(args) < 2 {
 			return value.NewNull()
@@ -1496,11 +663,11 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 	})
 
 	// Sys Module
-	vm.DefineNative("sys_os", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("sys", "os", func(args []value.Value) value.Value {
 		return value.NewString(runtime.GOOS)
 	})
 
-	vm.DefineNative("sys_exec", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("sys", "exec", func(args []value.Value) value.Value {
 		if len(args) < 2 {
 			return value.NewNull()
 		}
@@ -1516,6 +683,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		} else {
 			cmd = exec.Command("sh", "-c", cmdStr)
 		}
+		cmd.Dir = vm.workDir()
 
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
@@ -1536,14 +704,14 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		}
 
 		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["exit_code"] = value.NewInt(int64(exitCode))
-		inst.Fields["output"] = value.NewString(outputStr)
-		inst.Fields["ok"] = value.NewBool(okVal)
+		inst.Set("exit_code", value.NewInt(int64(exitCode)))
+		inst.Set("output", value.NewString(outputStr))
+		inst.Set("ok", value.NewBool(okVal))
 
 		return value.Value{Type: value.VAL_OBJ, Obj: inst}
 	})
 
-	vm.DefineNative("sys_exec_output", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("sys", "exec_output", func(args []value.Value) value.Value {
 		if len(args) < 2 {
 			return value.NewNull()
 		}
@@ -1559,6 +727,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		} else {
 			cmd = exec.Command("sh", "-c", cmdStr)
 		}
+		cmd.Dir = vm.workDir()
 
 		outBytes, err := cmd.CombinedOutput()
 		outputStr := string(outBytes)
@@ -1571,119 +740,37 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 			if exitErr, ok := err.(*exec.ExitError); ok {
 				exitCode = exitErr.ExitCode()
 			} else {
-				exitCode = 1
-			}
-			okVal = false
-		} else {
-			okVal = true
-		}
-
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["exit_code"] = value.NewInt(int64(exitCode))
-		inst.Fields["output"] = value.NewString(strings.TrimSpace(outputStr))
-		inst.Fields["ok"] = value.NewBool(okVal)
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
-	})
-
-	vm.DefineNative("sys_load_plugin", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewBool(false)
-		}
-		name := args[0].String()
-		cmdName := args[1].String()
-
-		// Intelligent Path Search
-		var cmdPath string
-		found := false
-
-		// 1. Check absolute path or PATH override
-		if filepath.IsAbs(cmdName) {
-			if _, err := os.Stat(cmdName); err == nil {
-				cmdPath = cmdName
-				found = true
-			}
-		} else {
-			// 2. Check path provided directly (PATH lookup)
-			if path, err := exec.LookPath(cmdName); err == nil {
-				cmdPath = path
-				found = true
-			}
-		}
-
-		// 3. Check Current Working Directory (explicitly)
-		if !found {
-			cwd, _ := os.Getwd()
-			localPath := filepath.Join(cwd, cmdName)
-			// Add .exe on Windows if not present
-			if runtime.GOOS == "windows" && !strings.HasSuffix(localPath, ".exe") {
-				localPath += ".exe"
-			}
-			if _, err := os.Stat(localPath); err == nil {
-				cmdPath = localPath
-				found = true
+				exitCode = 1
 			}
+			okVal = false
+		} else {
+			okVal = true
 		}
 
-		// 4. Check noxy_libs recursively (Depth restricted)
-		if !found {
-			cwd, _ := os.Getwd()
-			libsDir := filepath.Join(cwd, "noxy_libs")
-			filepath.Walk(libsDir, func(path string, info os.FileInfo, err error) error {
-				if found {
-					return filepath.SkipDir // Stop if found
-				}
-				if err != nil {
-					return nil // Ignore errors
-				}
-				if info.IsDir() {
-					if info.Name() == ".git" {
-						return filepath.SkipDir
-					}
-					return nil
-				}
+		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
+		inst.Set("exit_code", value.NewInt(int64(exitCode)))
+		inst.Set("output", value.NewString(strings.TrimSpace(outputStr)))
+		inst.Set("ok", value.NewBool(okVal))
 
-				fname := info.Name()
-				isMatch := fname == cmdName
-				if runtime.GOOS == "windows" {
-					isMatch = fname == cmdName || fname == cmdName+".exe"
-				}
+		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+	})
 
-				if isMatch {
-					cmdPath = path
-					found = true
-					return filepath.SkipDir // Abort walk
-				}
-				return nil
-			})
-		}
+	vm.DefineModuleNative("sys", "pipe", vm.sysPipe)
 
-		if !found {
-			fmt.Printf("Plugin Load Error: command not found: %s\n", cmdName)
+	vm.DefineModuleNative("sys", "load_plugin", func(args []value.Value) value.Value {
+		if len(args) < 2 {
 			return value.NewBool(false)
 		}
-
-		client, err := plugin.LoadPlugin(name, cmdPath)
-		if err != nil {
-			fmt.Printf("Plugin Load Error: failed to load plugin: %v\n", err)
+		name := args[0].String()
+		if err := vm.loadPlugin(name, args[1].String()); err != nil {
+			fmt.Printf("Plugin Load Error: %v\n", err)
 			return value.NewBool(false)
 		}
-
-		// Define Native dynamically
-		nativeName := name + "_request" // e.g. dynamodb_request
-		vm.DefineNative(nativeName, func(args []value.Value) value.Value {
-			if len(args) < 1 {
-				return value.NewNull()
-			}
-			method := args[0].String()
-			params := args[1:]
-			return client.Call(method, params)
-		})
-
+		vm.definePluginNative(name)
 		return value.NewBool(true)
 	})
 
-	vm.DefineNative("sys_getenv", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("sys", "getenv", func(args []value.Value) value.Value {
 		if len(args) < 2 {
 			return value.NewNull()
 		}
@@ -1696,13 +783,13 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		val, found := os.LookupEnv(key)
 
 		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["value"] = value.NewString(val)
-		inst.Fields["ok"] = value.NewBool(found)
+		inst.Set("value", value.NewString(val))
+		inst.Set("ok", value.NewBool(found))
 
 		return value.Value{Type: value.VAL_OBJ, Obj: inst}
 	})
 
-	vm.DefineNative("sys_setenv", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("sys", "setenv", func(args []value.Value) value.Value {
 		if len(args) < 2 {
 			return value.NewBool(false)
 		}
@@ -1712,15 +799,22 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBool(err == nil)
 	})
 
-	vm.DefineNative("sys_getcwd", func(args []value.Value) value.Value {
-		dir, err := os.Getwd()
+	vm.DefineModuleNative("sys", "getcwd", func(args []value.Value) value.Value {
+		dir, err := vm.Getwd()
 		if err != nil {
 			return value.NewString("")
 		}
 		return value.NewString(dir)
 	})
 
-	vm.DefineNative("sys_argv", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("sys", "chdir", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewBool(false)
+		}
+		return value.NewBool(vm.Chdir(args[0].String()) == nil)
+	})
+
+	vm.DefineModuleNative("sys", "argv", func(args []value.Value) value.Value {
 		// Convert os.Args to string[]
 		vals := make([]value.Value, len(os.Args))
 		for i, a := range os.Args {
@@ -1729,7 +823,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewArray(vals)
 	})
 
-	vm.DefineNative("sys_sleep", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("sys", "sleep", func(args []value.Value) value.Value {
 		if len(args) < 1 {
 			return value.NewNull()
 		}
@@ -1738,18 +832,18 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewNull()
 	})
 
-	vm.DefineNative("sys_exit", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("sys", "exit", func(args []value.Value) value.Value {
 		code := 0
 		if len(args) > 0 {
 			code = int(args[0].AsInt)
 		}
-		os.Exit(code)
-		return value.NewNull()
+		// Unwinds the program through callValue; see ExitError
+		return value.Value{Type: value.VAL_NULL, Obj: &ExitError{Code: code}}
 	})
 
 	vm.DefineNative("length", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewInt(0)
+		if err, ok := native.CheckArgs(args, "string|bytes|array|map|set|buffer"); !ok {
+			return err
 		}
 		arg := args[0]
 		if arg.Type == value.VAL_BYTES {
@@ -1762,92 +856,94 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 				return value.NewInt(int64(utf8.RuneCountInString(str)))
 			}
 			if arr, ok := arg.Obj.(*value.ObjArray); ok {
-				return value.NewInt(int64(len(arr.Elements)))
+				return value.NewInt(int64(arr.Len()))
 			}
 			if mp, ok := arg.Obj.(*value.ObjMap); ok {
-				return value.NewInt(int64(len(mp.Data)))
+				return value.NewInt(int64(mp.Len()))
+			}
+			if buf, ok := arg.Obj.(*value.ObjBuffer); ok {
+				return value.NewInt(int64(len(buf.Data)))
+			}
+			if set, ok := arg.Obj.(*value.ObjSet); ok {
+				return value.NewInt(int64(set.Len()))
 			}
 		}
 		return value.NewInt(0)
 	})
 
 	vm.DefineNative("keys", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewArray(nil)
+		if err, ok := native.CheckArgs(args, "map"); !ok {
+			return err
 		}
-		mapVal := args[0]
-		if mapVal.Type == value.VAL_OBJ {
-			if m, ok := mapVal.Obj.(*value.ObjMap); ok {
-				keys := make([]value.Value, 0, len(m.Data))
-				for k := range m.Data {
-					if kInt, ok := k.(int64); ok {
-						keys = append(keys, value.NewInt(kInt))
-					} else if kStr, ok := k.(string); ok {
-						keys = append(keys, value.NewString(kStr))
-					}
-				}
-				return value.NewArray(keys)
-			}
+		m := args[0].Obj.(*value.ObjMap)
+		keys := make([]value.Value, 0, m.Len())
+		for _, k := range m.Keys {
+			keys = append(keys, value.KeyValue(k))
 		}
-		return value.NewArray(nil)
+		return value.NewArray(keys)
 	})
 
 	vm.DefineNative("delete", func(args []value.Value) value.Value {
-		if len(args) != 2 {
-			return value.NewNull()
+		if err, ok := native.CheckArgs(args, "map", "any"); !ok {
+			return err
 		}
 		mapVal := args[0]
 		keyVal := args[1]
+		if err := value.CheckMutable(mapVal); err != nil {
+			return value.NewNativeError("%s", err)
+		}
 		if mapVal.Type == value.VAL_OBJ {
 			if m, ok := mapVal.Obj.(*value.ObjMap); ok {
-				var key interface{}
-				if keyVal.Type == value.VAL_INT {
-					key = keyVal.AsInt
-				} else if keyVal.Type == value.VAL_OBJ {
-					if str, ok := keyVal.Obj.(string); ok {
-						key = str
-					}
-				}
-				if key != nil {
-					delete(m.Data, key)
+				if key, err := keyVal.HashKey(); err == nil {
+					m.Delete(key)
 				}
 			}
 		}
 		return value.NewNull()
 	})
 	vm.DefineNative("append", func(args []value.Value) value.Value {
-		if len(args) != 2 {
-			return value.NewNull()
+		if err, ok := native.CheckArgs(args, "array|buffer", "any"); !ok {
+			return err
 		}
 		arrVal := args[0]
 		item := args[1]
+		if err := value.CheckMutable(arrVal); err != nil {
+			return value.NewNativeError("%s", err)
+		}
 		if arrVal.Type == value.VAL_OBJ {
 			if arr, ok := arrVal.Obj.(*value.ObjArray); ok {
-				arr.Elements = append(arr.Elements, item)
+				arr.Append(item)
+			}
+			if buf, ok := arrVal.Obj.(*value.ObjBuffer); ok {
+				bufferAppend(buf, item)
 			}
 		}
 		return value.NewNull()
 	})
 	vm.DefineNative("pop", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewNull()
+		if err, ok := native.CheckArgs(args, "array"); !ok {
+			return err
 		}
 		arrVal := args[0]
+		if err := value.CheckMutable(arrVal); err != nil {
+			return value.NewNativeError("%s", err)
+		}
 		if arrVal.Type == value.VAL_OBJ {
 			if arr, ok := arrVal.Obj.(*value.ObjArray); ok {
-				if len(arr.Elements) == 0 {
+				n := arr.Len()
+				if n == 0 {
 					return value.NewNull()
 				}
-				val := arr.Elements[len(arr.Elements)-1]
-				arr.Elements = arr.Elements[:len(arr.Elements)-1]
+				val := arr.At(n - 1)
+				arr.Truncate(n - 1)
 				return val
 			}
 		}
 		return value.NewNull()
 	})
 	vm.DefineNative("slice", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewNull()
+		if err, ok := native.CheckArgs(args, "string|bytes|array|buffer", "int", "int"); !ok {
+			return err
 		}
 		seq := args[0]
 		start := int(args[1].AsInt)
@@ -1876,15 +972,20 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 				return value.NewString(string(runes[start:end]))
 			}
 			if arr, ok := seq.Obj.(*value.ObjArray); ok {
-				start = clamp(start, len(arr.Elements))
-				end = clamp(end, len(arr.Elements))
+				start = clamp(start, arr.Len())
+				end = clamp(end, arr.Len())
 				if start > end {
 					return value.NewArray(nil)
 				}
-
-				newElems := make([]value.Value, end-start)
-				copy(newElems, arr.Elements[start:end])
-				return value.NewArray(newElems)
+				return value.Value{Type: value.VAL_OBJ, Obj: arr.Slice(start, end)}
+			}
+			if buf, ok := seq.Obj.(*value.ObjBuffer); ok {
+				start = clamp(start, len(buf.Data))
+				end = clamp(end, len(buf.Data))
+				if start > end {
+					return value.NewBuffer(nil)
+				}
+				return value.NewBuffer(append([]byte(nil), buf.Data[start:end]...))
 			}
 		case value.VAL_BYTES:
 			if str, ok := seq.Obj.(string); ok {
@@ -1900,14 +1001,14 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewNull()
 	})
 	vm.DefineNative("contains", func(args []value.Value) value.Value {
-		if len(args) != 2 {
-			return value.NewBool(false)
+		if err, ok := native.CheckArgs(args, "array", "any"); !ok {
+			return err
 		}
 		arrVal := args[0]
 		target := args[1]
 		if arrVal.Type == value.VAL_OBJ {
 			if arr, ok := arrVal.Obj.(*value.ObjArray); ok {
-				for _, el := range arr.Elements {
+				for _, el := range arr.Values() {
 					if valuesEqual(el, target) {
 						return value.NewBool(true)
 					}
@@ -1917,23 +1018,15 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBool(false)
 	})
 	vm.DefineNative("has_key", func(args []value.Value) value.Value {
-		if len(args) != 2 {
-			return value.NewBool(false)
+		if err, ok := native.CheckArgs(args, "map", "any"); !ok {
+			return err
 		}
 		mapVal := args[0]
 		keyVal := args[1]
 		if mapVal.Type == value.VAL_OBJ {
 			if mapObj, ok := mapVal.Obj.(*value.ObjMap); ok {
-				var key interface{}
-				if keyVal.Type == value.VAL_INT {
-					key = keyVal.AsInt
-				} else if keyVal.Type == value.VAL_OBJ {
-					if str, ok := keyVal.Obj.(string); ok {
-						key = str
-					} else {
-						return value.NewBool(false)
-					}
-				} else {
+				key, err := keyVal.HashKey()
+				if err != nil {
 					return value.NewBool(false)
 				}
 				_, ok := mapObj.Data[key]
@@ -1943,8 +1036,8 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBool(false)
 	})
 	vm.DefineNative("to_bytes", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewBytes("")
+		if err, ok := native.CheckArgs(args, "string|bytes|buffer|array|int"); !ok {
+			return err
 		}
 		arg := args[0]
 		switch arg.Type {
@@ -1952,16 +1045,22 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 			if str, ok := arg.Obj.(string); ok {
 				return value.NewBytes(str)
 			}
+			if buf, ok := arg.Obj.(*value.ObjBuffer); ok {
+				return value.NewBytes(string(buf.Data))
+			}
 			if arr, ok := arg.Obj.(*value.ObjArray); ok {
 				// Array of ints -> bytes
-				bs := make([]byte, len(arr.Elements))
-				for i, el := range arr.Elements {
-					if el.Type == value.VAL_INT {
-						bs[i] = byte(el.AsInt)
+				bs := make([]byte, arr.Len())
+				for i, el := range arr.Values() {
+					if el.Type != value.VAL_INT {
+						return value.NewNativeError("element %d is %s, not int", i, value.TypeName(el))
 					}
+					bs[i] = byte(el.AsInt)
 				}
 				return value.NewBytes(string(bs))
 			}
+		case value.VAL_BYTES:
+			return arg
 		case value.VAL_INT:
 			// Single int to single byte
 			return value.NewBytes(string([]byte{byte(arg.AsInt)}))
@@ -1969,903 +1068,349 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBytes("")
 	})
 
-	// Net Native Functions
-	vm.DefineNative("net_listen", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		host := args[0].String()
-		port := int(args[1].AsInt)
-		addr := fmt.Sprintf("%s:%d", host, port)
-
-		listener, err := net.Listen("tcp", addr)
-		if err != nil {
-			// Return Socket with open=false
-			socketFields := map[string]value.Value{
-				"fd":   value.NewInt(-1),
-				"addr": value.NewString(host),
-				"port": value.NewInt(int64(port)),
-				"open": value.NewBool(false),
-			}
-			return value.NewMapWithData(socketFields)
+	// Byte buffers: mutable counterparts of bytes for building binary data
+	vm.DefineModuleNative("buffer", "new", func(args []value.Value) value.Value {
+		capacity := 0
+		if len(args) > 0 && args[0].Type == value.VAL_INT && args[0].AsInt > 0 {
+			capacity = int(args[0].AsInt)
 		}
-
-		vm.shared.NetLock.Lock()
-		id := vm.shared.NextNetID
-		vm.shared.NextNetID++
-		vm.shared.NetListeners[id] = listener
-		vm.shared.NetLock.Unlock()
-
-		socketFields := map[string]value.Value{
-			"fd":   value.NewInt(int64(id)),
-			"addr": value.NewString(host),
-			"port": value.NewInt(int64(port)),
-			"open": value.NewBool(true),
+		return value.NewBuffer(make([]byte, 0, capacity))
+	})
+	vm.DefineModuleNative("buffer", "from", func(args []value.Value) value.Value {
+		buf := &value.ObjBuffer{}
+		if len(args) == 1 {
+			bufferAppend(buf, args[0])
 		}
-		return value.NewMapWithData(socketFields)
+		return value.Value{Type: value.VAL_OBJ, Obj: buf}
 	})
-
-	vm.DefineNative("net_accept", func(args []value.Value) value.Value {
-		if len(args) < 1 {
+	vm.DefineModuleNative("buffer", "append", func(args []value.Value) value.Value {
+		if len(args) != 2 {
 			return value.NewNull()
 		}
-		sockMap, ok := args[0].Obj.(*value.ObjMap)
+		buf, ok := args[0].Obj.(*value.ObjBuffer)
 		if !ok {
 			return value.NewNull()
 		}
-		fdVal, exists := sockMap.Data["fd"]
-		if !exists {
-			return value.NewNull()
-		}
-		fd := int(fdVal.AsInt)
-
-		vm.shared.NetLock.Lock()
-		listener, ok := vm.shared.NetListeners[fd]
-		vm.shared.NetLock.Unlock()
-
-		if !ok {
-			socketFields := map[string]value.Value{
-				"fd":   value.NewInt(-1),
-				"addr": value.NewString(""),
-				"port": value.NewInt(0),
-				"open": value.NewBool(false),
-			}
-			return value.NewMapWithData(socketFields)
-		}
-
-		// Check buffered connection from select
-		var conn net.Conn
-		var err error
-
-		if bufferedConn, ok := vm.netBufferedConns[fd]; ok {
-			conn = bufferedConn
-			delete(vm.netBufferedConns, fd)
-		} else {
-			// Accept blocks. Lock is released above.
-			conn, err = listener.Accept()
-		}
-
-		if err != nil {
-			socketFields := map[string]value.Value{
-				"fd":   value.NewInt(-1),
-				"addr": value.NewString(""),
-				"port": value.NewInt(0),
-				"open": value.NewBool(false),
-			}
-			return value.NewMapWithData(socketFields)
+		bufferAppend(buf, args[1])
+		return args[0]
+	})
+	vm.DefineModuleNative("buffer", "set", func(args []value.Value) value.Value {
+		if len(args) != 3 || args[1].Type != value.VAL_INT || args[2].Type != value.VAL_INT {
+			return value.NewBool(false)
 		}
-
-		vm.shared.NetLock.Lock()
-		id := vm.shared.NextNetID
-		vm.shared.NextNetID++
-		vm.shared.NetConns[id] = conn
-		vm.shared.NetLock.Unlock()
-
-		remoteAddr := conn.RemoteAddr().String()
-		socketFields := map[string]value.Value{
-			"fd":   value.NewInt(int64(id)),
-			"addr": value.NewString(remoteAddr),
-			"port": value.NewInt(0),
-			"open": value.NewBool(true),
+		buf, ok := args[0].Obj.(*value.ObjBuffer)
+		idx := int(args[1].AsInt)
+		if !ok || idx < 0 || idx >= len(buf.Data) {
+			return value.NewBool(false)
 		}
-		return value.NewMapWithData(socketFields)
+		buf.Data[idx] = byte(args[2].AsInt)
+		return value.NewBool(true)
 	})
-
-	vm.DefineNative("net_connect", func(args []value.Value) value.Value {
-		if len(args) < 2 {
+	vm.DefineModuleNative("buffer", "reserve", func(args []value.Value) value.Value {
+		if len(args) != 2 || args[1].Type != value.VAL_INT {
 			return value.NewNull()
 		}
-		host := args[0].String()
-		port := int(args[1].AsInt)
-		addr := fmt.Sprintf("%s:%d", host, port)
-
-		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
-		if err != nil {
-			socketFields := map[string]value.Value{
-				"fd":   value.NewInt(-1),
-				"addr": value.NewString(host),
-				"port": value.NewInt(int64(port)),
-				"open": value.NewBool(false),
+		if buf, ok := args[0].Obj.(*value.ObjBuffer); ok {
+			if extra := int(args[1].AsInt); extra > cap(buf.Data)-len(buf.Data) {
+				grown := make([]byte, len(buf.Data), len(buf.Data)+extra)
+				copy(grown, buf.Data)
+				buf.Data = grown
 			}
-			return value.NewMapWithData(socketFields)
 		}
-
-		vm.shared.NetLock.Lock()
-		id := vm.shared.NextNetID
-		vm.shared.NextNetID++
-		vm.shared.NetConns[id] = conn
-		vm.shared.NetLock.Unlock()
-
-		socketFields := map[string]value.Value{
-			"fd":   value.NewInt(int64(id)),
-			"addr": value.NewString(host),
-			"port": value.NewInt(int64(port)),
-			"open": value.NewBool(true),
-		}
-		return value.NewMapWithData(socketFields)
+		return value.NewNull()
 	})
-
-	vm.DefineNative("net_recv", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		sockMap, ok := args[0].Obj.(*value.ObjMap)
-		if !ok {
+	vm.DefineModuleNative("buffer", "truncate", func(args []value.Value) value.Value {
+		if len(args) != 2 || args[1].Type != value.VAL_INT {
 			return value.NewNull()
 		}
-		fdVal, _ := sockMap.Data["fd"]
-		fd := int(fdVal.AsInt)
-		size := int(args[1].AsInt)
-
-		vm.shared.NetLock.Lock()
-		conn, ok := vm.shared.NetConns[fd]
-		vm.shared.NetLock.Unlock()
-
-		if !ok {
-			resultFields := map[string]value.Value{
-				"ok":    value.NewBool(false),
-				"data":  value.NewBytes(""),
-				"count": value.NewInt(0),
-				"error": value.NewString("invalid socket"),
-			}
-			return value.NewMapWithData(resultFields)
-		}
-
-		var n int
-		buf := make([]byte, size)
-
-		// Check buffered data from select
-		if buffered, ok := vm.netBufferedData[fd]; ok {
-			// Copy buffered data
-			copy(buf, buffered)
-			n = len(buffered)
-			delete(vm.netBufferedData, fd)
-		}
-
-		// Try to read more if space available
-		if n < size {
-			// Blocking read (no deadline)
-			n2, err2 := conn.Read(buf[n:])
-			if n2 > 0 {
-				n += n2
-			}
-
-			// Ignore timeout errors if we have at least some data
-			if err2 != nil {
-				if n == 0 {
-					// Only return error if we really got nothing
-					if err2 != nil && n2 == 0 {
-						if err2 == io.EOF {
-							// Return ok=true, count=0 for EOF
-							resultFields := map[string]value.Value{
-								"ok":    value.NewBool(true),
-								"data":  value.NewBytes(""),
-								"count": value.NewInt(0),
-								"error": value.NewString(""),
-							}
-							return value.NewMapWithData(resultFields)
-						}
-						resultFields := map[string]value.Value{
-							"ok":    value.NewBool(false),
-							"data":  value.NewBytes(""),
-							"count": value.NewInt(0),
-							"error": value.NewString(err2.Error()),
-						}
-						return value.NewMapWithData(resultFields)
-					}
-				}
+		if buf, ok := args[0].Obj.(*value.ObjBuffer); ok {
+			if n := int(args[1].AsInt); n >= 0 && n < len(buf.Data) {
+				buf.Data = buf.Data[:n]
 			}
 		}
-
-		resultFields := map[string]value.Value{
-			"ok":    value.NewBool(true),
-			"data":  value.NewBytes(string(buf[:n])),
-			"count": value.NewInt(int64(n)),
-			"error": value.NewString(""),
-		}
-		return value.NewMapWithData(resultFields)
+		return value.NewNull()
 	})
-
-	vm.DefineNative("net_send", func(args []value.Value) value.Value {
-		if len(args) < 2 {
-			return value.NewNull()
-		}
-		sockMap, ok := args[0].Obj.(*value.ObjMap)
-		if !ok {
-			fmt.Printf("DEBUG: net_send args[0] not map: %T %v\n", args[0].Obj, args[0].Obj)
-			return value.NewNull()
-		}
-		fdVal, _ := sockMap.Data["fd"]
-		fd := int(fdVal.AsInt)
-		var data string
-		if args[1].Type == value.VAL_BYTES {
-			data = args[1].Obj.(string)
-		} else {
-			data = args[1].String()
-		}
-
-		vm.shared.NetLock.Lock()
-		conn, ok := vm.shared.NetConns[fd]
-		vm.shared.NetLock.Unlock()
-
-		if !ok {
-			resultFields := map[string]value.Value{
-				"ok":    value.NewBool(false),
-				"data":  value.NewBytes(""),
-				"count": value.NewInt(0),
-				"error": value.NewString("invalid socket"),
+	vm.DefineModuleNative("buffer", "to_bytes", func(args []value.Value) value.Value {
+		if len(args) == 1 {
+			if buf, ok := args[0].Obj.(*value.ObjBuffer); ok {
+				return value.NewBytes(string(buf.Data))
 			}
-			return value.NewMapWithData(resultFields)
 		}
-
-		n, err := conn.Write([]byte(data))
-		if err != nil {
-			resultFields := map[string]value.Value{
-				"ok":    value.NewBool(false),
-				"data":  value.NewBytes(""),
-				"count": value.NewInt(0),
-				"error": value.NewString(err.Error()),
+		return value.NewBytes("")
+	})
+	vm.DefineModuleNative("buffer", "to_string", func(args []value.Value) value.Value {
+		if len(args) == 1 {
+			if buf, ok := args[0].Obj.(*value.ObjBuffer); ok {
+				return value.NewString(string(buf.Data))
 			}
-			return value.NewMapWithData(resultFields)
 		}
+		return value.NewString("")
+	})
 
-		resultFields := map[string]value.Value{
-			"ok":    value.NewBool(true),
-			"data":  value.NewBytes(""),
-			"count": value.NewInt(int64(n)),
-			"error": value.NewString(""),
+	// String builders: linear-time construction of large strings
+	vm.DefineNative("sb_new", func(args []value.Value) value.Value {
+		sb := &value.ObjStringBuilder{}
+		if len(args) > 0 && args[0].Type == value.VAL_INT && args[0].AsInt > 0 {
+			sb.Builder.Grow(int(args[0].AsInt))
 		}
-		return value.NewMapWithData(resultFields)
+		return value.Value{Type: value.VAL_OBJ, Obj: sb}
 	})
-
-	vm.DefineNative("net_close", func(args []value.Value) value.Value {
+	vm.DefineNative("sb_append", func(args []value.Value) value.Value {
 		if len(args) < 1 {
 			return value.NewNull()
 		}
-
-		var fd int
-		// Check if arg is int (new style) or map (old style compatibility if needed, but we changed net.nx)
-		if args[0].Type == value.VAL_INT {
-			fd = int(args[0].AsInt)
-		} else if args[0].Type == value.VAL_OBJ {
-			// Fallback for old calls? Or just error.
-			if sockMap, ok := args[0].Obj.(*value.ObjMap); ok {
-				if fdVal, found := sockMap.Data["fd"]; found {
-					fd = int(fdVal.AsInt)
-				}
-			}
-		} else {
-			return value.NewNull()
-		}
-
-		vm.shared.NetLock.Lock()
-		defer vm.shared.NetLock.Unlock()
-
-		// Try closing as listener
-		if listener, ok := vm.shared.NetListeners[fd]; ok {
-			listener.Close()
-			delete(vm.shared.NetListeners, fd)
+		sb, ok := args[0].Obj.(*value.ObjStringBuilder)
+		if !ok {
 			return value.NewNull()
 		}
-
-		// Try closing as connection
-		if conn, ok := vm.shared.NetConns[fd]; ok {
-			conn.Close()
-			delete(vm.shared.NetConns, fd)
-		}
-
-		return value.NewNull()
-	})
-
-	vm.DefineNative("net_setblocking", func(args []value.Value) value.Value {
-		// For TCP in Go, blocking is handled at a different level
-		// This is a no-op for now, as Go handles timeouts via SetDeadline
-		return value.NewNull()
-	})
-
-	vm.DefineNative("net_select", func(args []value.Value) value.Value {
-		// args: read, write (ignored), err (ignored), timeout
-		if len(args) < 4 {
-			return value.NewNull() // Or error map
-		}
-
-		timeoutMs := int(args[3].AsInt)
-		// Minimum 1ms to allow polling
-		if timeoutMs < 1 {
-			timeoutMs = 1
-		}
-
-		// Prepare Result Data
-		readyRead := make([]value.Value, 0)
-
-		// Parse Read Set
-		readArrVal := args[0]
-		if readArrVal.Type == value.VAL_OBJ {
-			if arr, ok := readArrVal.Obj.(*value.ObjArray); ok {
-				for _, el := range arr.Elements {
-					if el.Type == value.VAL_OBJ { // Check if socket (Map or Instance)
-						// Extract FD
-						var fd int64 = -1
-
-						if m, ok := el.Obj.(*value.ObjMap); ok {
-							if f, ok := m.Data["fd"]; ok {
-								fd = f.AsInt
-							}
-						} else if inst, ok := el.Obj.(*value.ObjInstance); ok {
-							if f, ok := inst.Fields["fd"]; ok {
-								fd = f.AsInt
-							}
-						}
-
-						if fd != -1 {
-							isReady := false
-							id := int(fd)
-
-							// 1. Check buffers first
-							if _, ok := vm.netBufferedConns[id]; ok {
-								isReady = true
-							} else if _, ok := vm.netBufferedData[id]; ok {
-								isReady = true
-							} else {
-								// 2. Poll
-								vm.shared.NetLock.Lock()
-								l, isListener := vm.shared.NetListeners[id]
-								c, isConn := vm.shared.NetConns[id]
-								vm.shared.NetLock.Unlock()
-
-								if isListener {
-									if tcpL, ok := l.(*net.TCPListener); ok {
-										tcpL.SetDeadline(time.Now().Add(time.Millisecond * time.Duration(timeoutMs)))
-										conn, err := l.Accept()
-										if err == nil {
-											isReady = true
-											vm.netBufferedConns[id] = conn
-										}
-										// Reset deadline?
-										tcpL.SetDeadline(time.Time{})
-									}
-								} else if isConn {
-									conn := c
-									conn.SetReadDeadline(time.Now().Add(time.Millisecond * time.Duration(timeoutMs)))
-									buf := make([]byte, 1) // Peek 1 byte
-									n, err := conn.Read(buf)
-									if err == nil && n > 0 {
-										isReady = true
-										// Buffer the data
-										vm.netBufferedData[id] = buf[:n]
-									}
-									// Reset deadline
-									conn.SetReadDeadline(time.Time{})
-								}
-							}
-
-							if isReady {
-								readyRead = append(readyRead, el)
-							}
-						}
-					}
-				}
-			}
-		}
-
-		// Construct SelectResult Map
-		// struct SelectResult { read: Socket[64], read_count: int, ... }
-
-		// Fill read array up to 64
-		resReadArr := make([]value.Value, 64)
-		for i := 0; i < 64; i++ {
-			if i < len(readyRead) {
-				resReadArr[i] = readyRead[i]
+		for _, arg := range args[1:] {
+			if arg.Type == value.VAL_BYTES {
+				sb.Builder.WriteString(arg.Obj.(string))
 			} else {
-				resReadArr[i] = value.NewNull()
+				sb.Builder.WriteString(arg.String())
 			}
 		}
-
-		// Empties for others
-		emptyArr := make([]value.Value, 64)
-		for i := 0; i < 64; i++ {
-			emptyArr[i] = value.NewNull()
-		}
-
-		resFields := map[string]value.Value{
-			"read":        value.NewArray(resReadArr),
-			"read_count":  value.NewInt(int64(len(readyRead))),
-			"write":       value.NewArray(emptyArr),
-			"write_count": value.NewInt(0),
-			"error":       value.NewArray(emptyArr),
-			"error_count": value.NewInt(0),
-		}
-		return value.NewMapWithData(resFields)
+		return args[0]
 	})
-
-	// SQLite Native Functions
-	vm.DefineNative("sqlite_open", func(args []value.Value) value.Value {
-		if len(args) != 2 {
-			return value.NewNull()
-		} // path, wrapper struct
-		path := args[0].String()
-		structInst, ok := args[1].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-		structDef := structInst.Struct
-
-		db, err := sql.Open("sqlite", path)
-		openVal := true
-		if err != nil {
-			openVal = false
-		} else {
-			if err = db.Ping(); err != nil {
-				openVal = false
+	vm.DefineNative("sb_len", func(args []value.Value) value.Value {
+		if len(args) == 1 {
+			if sb, ok := args[0].Obj.(*value.ObjStringBuilder); ok {
+				return value.NewInt(int64(sb.Builder.Len()))
 			}
 		}
-
-		vm.shared.DbLock.Lock()
-		id := vm.shared.NextDbID
-		vm.shared.NextDbID++
-		vm.shared.DbHandles[id] = db
-		vm.shared.DbLock.Unlock()
-
-		inst := value.NewInstance(structDef).Obj.(*value.ObjInstance)
-		inst.Fields["handle"] = value.NewInt(int64(id))
-		inst.Fields["open"] = value.NewBool(openVal)
-
-		return value.Value{Type: value.VAL_OBJ, Obj: inst}
+		return value.NewInt(0)
 	})
-
-	vm.DefineNative("sqlite_close", func(args []value.Value) value.Value {
-		if len(args) != 1 {
-			return value.NewNull()
-		}
-		dbInst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-
-		handle := int(dbInst.Fields["handle"].AsInt)
-
-		vm.shared.DbLock.Lock()
-		defer vm.shared.DbLock.Unlock()
-
-		if db, ok := vm.shared.DbHandles[handle]; ok {
-			db.Close()
-			delete(vm.shared.DbHandles, handle)
-			dbInst.Fields["open"] = value.NewBool(false)
+	vm.DefineNative("sb_to_string", func(args []value.Value) value.Value {
+		if len(args) == 1 {
+			if sb, ok := args[0].Obj.(*value.ObjStringBuilder); ok {
+				return value.NewString(sb.Builder.String())
+			}
 		}
-		return value.NewNull()
+		return value.NewString("")
 	})
-
-	vm.DefineNative("sqlite_exec", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewNull()
-		}
-		dbInst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-		sqlStr := args[1].String()
-
-		resTmplInst, ok := args[2].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
+	vm.DefineNative("sb_reset", func(args []value.Value) value.Value {
+		if len(args) == 1 {
+			if sb, ok := args[0].Obj.(*value.ObjStringBuilder); ok {
+				sb.Builder.Reset()
+			}
 		}
-		resStruct := resTmplInst.Struct
-
-		handle := int(dbInst.Fields["handle"].AsInt)
-
-		vm.shared.DbLock.Lock()
-		db, ok := vm.shared.DbHandles[handle]
-		vm.shared.DbLock.Unlock() // Unlock for Exec
-
-		if ok {
-			result, err := db.Exec(sqlStr)
-			resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-			if err != nil {
-				resInst.Fields["ok"] = value.NewBool(false)
-				resInst.Fields["error"] = value.NewString(err.Error())
-				resInst.Fields["rows_affected"] = value.NewInt(0)
-				resInst.Fields["last_insert_id"] = value.NewInt(0)
-			} else {
-				rowsAffected, _ := result.RowsAffected()
-				lastId, _ := result.LastInsertId()
-				resInst.Fields["ok"] = value.NewBool(true)
-				resInst.Fields["error"] = value.NewString("")
-				resInst.Fields["rows_affected"] = value.NewInt(rowsAffected)
-				resInst.Fields["last_insert_id"] = value.NewInt(lastId)
-			}
-			return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-		}
-		// Invalid handle
-		resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-		resInst.Fields["ok"] = value.NewBool(false)
-		resInst.Fields["error"] = value.NewString("invalid database handle")
-		resInst.Fields["rows_affected"] = value.NewInt(0)
-		resInst.Fields["last_insert_id"] = value.NewInt(0)
-		return value.Value{Type: value.VAL_OBJ, Obj: resInst}
+		return value.NewNull()
 	})
 
-	vm.DefineNative("sqlite_exec_params", func(args []value.Value) value.Value {
-		if len(args) < 4 {
-			return value.NewNull()
-		}
-		dbInst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
-		}
-		sqlStr := args[1].String()
-		paramsArray, ok := args[2].Obj.(*value.ObjArray)
-		if !ok {
-			return value.NewNull()
-		}
-
-		resTmplInst, ok := args[3].Obj.(*value.ObjInstance)
-		if !ok {
+	// Big integers: arbitrary precision, used with the usual operators
+	vm.DefineNative("bigint", func(args []value.Value) value.Value {
+		if len(args) != 1 {
 			return value.NewNull()
 		}
-		resStruct := resTmplInst.Struct
-
-		handle := int(dbInst.Fields["handle"].AsInt)
-
-		vm.shared.DbLock.Lock()
-		db, ok := vm.shared.DbHandles[handle]
-		vm.shared.DbLock.Unlock()
-
-		if ok {
-			// Convert params
-			queryArgs := make([]interface{}, len(paramsArray.Elements))
-			for i, val := range paramsArray.Elements {
-				switch val.Type {
-				case value.VAL_INT:
-					queryArgs[i] = val.AsInt
-				case value.VAL_FLOAT:
-					queryArgs[i] = val.AsFloat
-				case value.VAL_BOOL:
-					queryArgs[i] = val.AsBool
-				case value.VAL_NULL:
-					queryArgs[i] = nil
-				case value.VAL_OBJ:
-					if b, ok := val.Obj.(string); ok {
-						queryArgs[i] = b
-					} else {
-						queryArgs[i] = val.String()
-					}
-				default:
-					queryArgs[i] = val.String()
+		switch v := args[0]; {
+		case v.Type == value.VAL_INT:
+			return value.NewBigInt(big.NewInt(v.AsInt))
+		case v.Type == value.VAL_FLOAT:
+			if n, acc := big.NewFloat(v.AsFloat).Int(nil); acc == big.Exact {
+				return value.NewBigInt(n)
+			}
+		case v.Type == value.VAL_OBJ:
+			if n, ok := v.Obj.(*value.ObjBigInt); ok {
+				return value.NewBigInt(n.Value)
+			}
+			if s, ok := v.Obj.(string); ok {
+				s = strings.TrimSpace(s)
+				n, ok := new(big.Int).SetString(s, 10)
+				if !ok {
+					// 0x, 0o and 0b prefixes
+					n, ok = new(big.Int).SetString(s, 0)
+				}
+				if ok {
+					return value.NewBigInt(n)
 				}
 			}
-
-			result, err := db.Exec(sqlStr, queryArgs...)
-			resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-			if err != nil {
-				resInst.Fields["ok"] = value.NewBool(false)
-				resInst.Fields["error"] = value.NewString(err.Error())
-				resInst.Fields["rows_affected"] = value.NewInt(0)
-				resInst.Fields["last_insert_id"] = value.NewInt(0)
-			} else {
-				rowsAffected, _ := result.RowsAffected()
-				lastId, _ := result.LastInsertId()
-				resInst.Fields["ok"] = value.NewBool(true)
-				resInst.Fields["error"] = value.NewString("")
-				resInst.Fields["rows_affected"] = value.NewInt(rowsAffected)
-				resInst.Fields["last_insert_id"] = value.NewInt(lastId)
-			}
-			return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-		}
-		// Invalid handle
-		resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-		resInst.Fields["ok"] = value.NewBool(false)
-		resInst.Fields["error"] = value.NewString("invalid database handle")
-		resInst.Fields["rows_affected"] = value.NewInt(0)
-		resInst.Fields["last_insert_id"] = value.NewInt(0)
-		return value.Value{Type: value.VAL_OBJ, Obj: resInst}
+		}
+		return value.NewNull()
 	})
-
-	vm.DefineNative("sqlite_prepare", func(args []value.Value) value.Value {
-		if len(args) < 3 {
-			return value.NewNull()
-		} // db, sql, stmt wrapper
-		dbInst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
+	vm.DefineNative("bigint_pow", func(args []value.Value) value.Value {
+		if len(args) < 2 || args[1].Type != value.VAL_INT || args[1].AsInt < 0 {
 			return value.NewNull()
 		}
-		sqlStr := args[1].String()
-		stmtInst, ok := args[2].Obj.(*value.ObjInstance)
+		base, ok := toBigInt(args[0])
 		if !ok {
 			return value.NewNull()
 		}
-		stmtStructDef := stmtInst.Struct
-
-		handle := int(dbInst.Fields["handle"].AsInt)
-
-		vm.shared.DbLock.Lock()
-		db, ok := vm.shared.DbHandles[handle]
-		vm.shared.DbLock.Unlock()
-
-		if ok {
-			stmt, err := db.Prepare(sqlStr)
-			if err == nil {
-				vm.shared.DbLock.Lock()
-				id := vm.shared.NextStmtID
-				vm.shared.NextStmtID++
-				vm.shared.StmtHandles[id] = stmt
-				vm.shared.StmtParams[id] = make(map[int]interface{})
-				vm.shared.DbLock.Unlock()
-
-				inst := value.NewInstance(stmtStructDef).Obj.(*value.ObjInstance)
-				inst.Fields["handle"] = value.NewInt(int64(id))
-				return value.Value{Type: value.VAL_OBJ, Obj: inst}
+		var mod *big.Int
+		if len(args) > 2 {
+			if m, ok := toBigInt(args[2]); ok && m.Sign() != 0 {
+				mod = m
 			}
 		}
-		return value.NewNull()
+		return value.NewBigInt(new(big.Int).Exp(base, big.NewInt(args[1].AsInt), mod))
 	})
 
-	bindFunc := func(args []value.Value, val interface{}) value.Value {
-		if len(args) < 3 {
+	// Decimals: exact base-10 arithmetic for money
+	vm.DefineNative("decimal", func(args []value.Value) value.Value {
+		if len(args) != 1 {
 			return value.NewNull()
 		}
-		stmtInst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
+		v := args[0]
+		if v.Type == value.VAL_FLOAT {
+			// The shortest representation, so decimal(0.1) is 0.1
+			if d, ok := value.ParseDecimal(strconv.FormatFloat(v.AsFloat, 'f', -1, 64)); ok {
+				return d
+			}
 			return value.NewNull()
 		}
-		idx := int(args[1].AsInt)
-
-		handle := int(stmtInst.Fields["handle"].AsInt)
-
-		vm.shared.DbLock.Lock()
-		defer vm.shared.DbLock.Unlock()
-
-		if _, ok := vm.shared.StmtHandles[handle]; ok {
-			if vm.shared.StmtParams[handle] == nil {
-				vm.shared.StmtParams[handle] = make(map[int]interface{})
+		if s, ok := v.Obj.(string); ok && v.Type == value.VAL_OBJ {
+			if d, ok := value.ParseDecimal(s); ok {
+				return d
 			}
-			vm.shared.StmtParams[handle][idx] = val
+			return value.NewNull()
+		}
+		if d, ok := toDecimal(v); ok {
+			return value.NewDecimal(d.Value, d.Scale)
 		}
 		return value.NewNull()
-	}
-
-	vm.DefineNative("sqlite_bind_text", func(args []value.Value) value.Value {
-		return bindFunc(args, args[2].String())
-	})
-	vm.DefineNative("sqlite_bind_float", func(args []value.Value) value.Value {
-		return bindFunc(args, args[2].AsFloat)
-	})
-	vm.DefineNative("sqlite_bind_int", func(args []value.Value) value.Value {
-		return bindFunc(args, args[2].AsInt)
 	})
-
-	vm.DefineNative("sqlite_step_exec", func(args []value.Value) value.Value {
-		if len(args) < 2 {
+	// decimal_round(d, places, mode = "half_even")
+	vm.DefineNative("decimal_round", func(args []value.Value) value.Value {
+		if len(args) < 2 || args[1].Type != value.VAL_INT || args[1].AsInt < 0 {
 			return value.NewNull()
 		}
-		stmtInst, ok := args[0].Obj.(*value.ObjInstance)
+		d, ok := toDecimal(args[0])
 		if !ok {
 			return value.NewNull()
 		}
-		resTmplInst, ok := args[1].Obj.(*value.ObjInstance)
-		if !ok {
+		mode := "half_even"
+		if len(args) > 2 {
+			mode = args[2].String()
+		}
+		places := int(args[1].AsInt)
+		r, err := value.RoundRat(d.Value, places, mode)
+		if err != nil {
 			return value.NewNull()
 		}
-		resStruct := resTmplInst.Struct
-
-		handle := int(stmtInst.Fields["handle"].AsInt)
+		return value.NewDecimal(r, places)
+	})
+	// decimal_format(d, places, thousands_sep = "") rounds half-even and
+	// always prints exactly places digits.
+	vm.DefineNative("decimal_format", func(args []value.Value) value.Value {
+		if len(args) < 2 || args[1].Type != value.VAL_INT || args[1].AsInt < 0 {
+			return value.NewString("")
+		}
+		d, ok := toDecimal(args[0])
+		if !ok {
+			return value.NewString("")
+		}
+		places := int(args[1].AsInt)
+		r, _ := value.RoundRat(d.Value, places, "half_even")
+		text := r.FloatString(places)
+		if len(args) > 2 && args[2].String() != "" {
+			text = groupThousands(text, args[2].String())
+		}
+		return value.NewString(text)
+	})
+	// currency_format(amount, currency, locale = "en-US") rounds half-even
+	// to the currency's minor units: "R$ 1.234,56" for BRL in pt-BR.
+	vm.DefineNative("currency_format", func(args []value.Value) value.Value {
+		if err, ok := native.CheckArgs(args, "decimal|int|bigint", "string", "string?"); !ok {
+			return err
+		}
+		d, _ := toDecimal(args[0])
+		c, err := money.LookupCurrency(args[1].String())
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		tag := "en-US"
+		if len(args) > 2 {
+			tag = args[2].String()
+		}
+		l, err := money.LookupLocale(tag)
+		if err != nil {
+			return value.NewNativeError("%s", err)
+		}
+		r, _ := value.RoundRat(d.Value, c.Digits, "half_even")
+		return value.NewString(l.Format(r.FloatString(c.Digits), c))
+	})
 
-		vm.shared.DbLock.Lock()
-		stmt, ok := vm.shared.StmtHandles[handle]
-		var params map[int]interface{}
-		if ok {
-			origParams := vm.shared.StmtParams[handle]
-			params = make(map[int]interface{})
-			for k, v := range origParams {
-				params[k] = v
+	// Sets: unique hashable values with O(1) membership
+	vm.DefineModuleNative("set", "new", func(args []value.Value) value.Value {
+		if len(args) > 0 {
+			if arr, ok := args[0].Obj.(*value.ObjArray); ok {
+				return newSetFrom(arr.Values())
 			}
 		}
-		vm.shared.DbLock.Unlock()
-
-		if ok {
-			// params := vm.stmtParams[handle] // Replaced by copy
-			var maxIdx int
-			for k := range params {
-				if k > maxIdx {
-					maxIdx = k
-				}
+		return value.NewSet()
+	})
+	vm.DefineModuleNative("set", "add", func(args []value.Value) value.Value {
+		if len(args) == 2 {
+			if err := value.CheckMutable(args[0]); err != nil {
+				return value.NewNativeError("%s", err)
 			}
-			argsList := make([]interface{}, maxIdx)
-			for k, v := range params {
-				if k > 0 && k <= maxIdx {
-					argsList[k-1] = v
-				}
+			if set, ok := args[0].Obj.(*value.ObjSet); ok {
+				added, _ := set.Add(args[1])
+				return value.NewBool(added)
 			}
-			result, err := stmt.Exec(argsList...)
-
-			resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-			if err != nil {
-				resInst.Fields["ok"] = value.NewBool(false)
-				resInst.Fields["error"] = value.NewString(err.Error())
-				resInst.Fields["rows_affected"] = value.NewInt(0)
-				resInst.Fields["last_insert_id"] = value.NewInt(0)
-			} else {
-				rowsAffected, _ := result.RowsAffected()
-				lastId, _ := result.LastInsertId()
-				resInst.Fields["ok"] = value.NewBool(true)
-				resInst.Fields["error"] = value.NewString("")
-				resInst.Fields["rows_affected"] = value.NewInt(rowsAffected)
-				resInst.Fields["last_insert_id"] = value.NewInt(lastId)
-			}
-			return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-		}
-
-		resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-		resInst.Fields["ok"] = value.NewBool(false)
-		resInst.Fields["error"] = value.NewString("invalid statement handle")
-		resInst.Fields["rows_affected"] = value.NewInt(0)
-		resInst.Fields["last_insert_id"] = value.NewInt(0)
-		return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-	})
-
-	vm.DefineNative("sqlite_reset", func(args []value.Value) value.Value {
-		if len(args) < 1 {
-			return value.NewNull()
 		}
-		stmtInst, ok := args[0].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
+		return value.NewBool(false)
+	})
+	vm.DefineModuleNative("set", "remove", func(args []value.Value) value.Value {
+		if len(args) == 2 {
+			if err := value.CheckMutable(args[0]); err != nil {
+				return value.NewNativeError("%s", err)
+			}
+			if set, ok := args[0].Obj.(*value.ObjSet); ok {
+				return value.NewBool(set.Remove(args[1]))
+			}
 		}
-		handle := int(stmtInst.Fields["handle"].AsInt)
-
-		vm.shared.DbLock.Lock()
-		defer vm.shared.DbLock.Unlock()
-
-		if _, ok := vm.shared.StmtHandles[handle]; ok {
-			vm.shared.StmtParams[handle] = make(map[int]interface{})
+		return value.NewBool(false)
+	})
+	vm.DefineModuleNative("set", "contains", func(args []value.Value) value.Value {
+		if len(args) == 2 {
+			if set, ok := args[0].Obj.(*value.ObjSet); ok {
+				return value.NewBool(set.Contains(args[1]))
+			}
 		}
-		return value.NewNull()
+		return value.NewBool(false)
 	})
-
-	vm.DefineNative("sqlite_finalize", func(args []value.Value) value.Value {
-		if len(args) < 1 {
+	vm.DefineModuleNative("set", "union", func(args []value.Value) value.Value {
+		a, b, ok := setArgs(args)
+		if !ok {
 			return value.NewNull()
 		}
-		stmtInst, ok := args[0].Obj.(*value.ObjInstance)
+		return newSetFrom(append(a.Values(), b.Values()...))
+	})
+	vm.DefineModuleNative("set", "intersection", func(args []value.Value) value.Value {
+		a, b, ok := setArgs(args)
 		if !ok {
 			return value.NewNull()
 		}
-		handle := int(stmtInst.Fields["handle"].AsInt)
-
-		vm.shared.DbLock.Lock()
-		defer vm.shared.DbLock.Unlock()
-
-		if stmt, ok := vm.shared.StmtHandles[handle]; ok {
-			stmt.Close()
-			delete(vm.shared.StmtHandles, handle)
-			delete(vm.shared.StmtParams, handle)
+		result := value.NewSet()
+		set := result.Obj.(*value.ObjSet)
+		for _, v := range a.Values() {
+			if b.Contains(v) {
+				set.Add(v)
+			}
 		}
-		return value.NewNull()
+		return result
 	})
-
-	vm.DefineNative("sqlite_query", func(args []value.Value) value.Value {
-		if len(args) < 4 {
-			return value.NewNull()
-		} // db, sql, tmplQueryResult, tmplRow
-
-		dbInst, ok := args[0].Obj.(*value.ObjInstance)
+	vm.DefineModuleNative("set", "difference", func(args []value.Value) value.Value {
+		a, b, ok := setArgs(args)
 		if !ok {
 			return value.NewNull()
 		}
-		sqlStr := args[1].String()
-
-		resTmplInst, ok := args[2].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
+		result := value.NewSet()
+		set := result.Obj.(*value.ObjSet)
+		for _, v := range a.Values() {
+			if !b.Contains(v) {
+				set.Add(v)
+			}
 		}
-		resStruct := resTmplInst.Struct
-
-		rowTmplInst, ok := args[3].Obj.(*value.ObjInstance)
-		if !ok {
-			return value.NewNull()
+		return result
+	})
+	vm.DefineModuleNative("set", "to_array", func(args []value.Value) value.Value {
+		if len(args) == 1 {
+			if set, ok := args[0].Obj.(*value.ObjSet); ok {
+				return value.NewArray(set.Values())
+			}
 		}
-		rowStruct := rowTmplInst.Struct
-
-		handle := int(dbInst.Fields["handle"].AsInt)
-
-		vm.shared.DbLock.Lock()
-		db, ok := vm.shared.DbHandles[handle]
-		vm.shared.DbLock.Unlock()
-
-		if ok {
-			rows, err := db.Query(sqlStr)
-			if err != nil {
-				// Return QueryResult with ok=false and error message
-				resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-				resInst.Fields["columns"] = value.NewArray(nil)
-				resInst.Fields["rows"] = value.NewArray(nil)
-				resInst.Fields["row_count"] = value.NewInt(0)
-				resInst.Fields["ok"] = value.NewBool(false)
-				resInst.Fields["error"] = value.NewString(err.Error())
-				return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-			}
-			defer rows.Close()
-
-			cols, _ := rows.Columns()
-			colVals := make([]value.Value, len(cols))
-			for i, c := range cols {
-				colVals[i] = value.NewString(c)
-			}
-
-			var rowInsts []value.Value
-
-			for rows.Next() {
-				// Scan to interface{}
-				dest := make([]interface{}, len(cols))
-				destPtrs := make([]interface{}, len(cols))
-				for i := range dest {
-					destPtrs[i] = &dest[i]
-				}
-
-				rows.Scan(destPtrs...)
-
-				rowVals := make([]value.Value, len(cols))
-				for i, v := range dest {
-					// Convert Go type to Noxy value
-					switch tv := v.(type) {
-					case nil:
-						rowVals[i] = value.NewNull()
-					case int64:
-						rowVals[i] = value.NewInt(tv)
-					case float64:
-						rowVals[i] = value.NewFloat(tv)
-					case string:
-						rowVals[i] = value.NewString(tv)
-					case []byte:
-						rowVals[i] = value.NewString(string(tv))
-					default:
-						rowVals[i] = value.NewString(fmt.Sprintf("%v", tv))
-					}
-				}
-
-				// Create Row instance
-				rowInst := value.NewInstance(rowStruct).Obj.(*value.ObjInstance)
-				rowInst.Fields["values"] = value.NewArray(rowVals)
-				rowInsts = append(rowInsts, value.Value{Type: value.VAL_OBJ, Obj: rowInst})
-			}
-
-			// Create QueryResult instance with ok=true
-			resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-			resInst.Fields["columns"] = value.NewArray(colVals)
-			resInst.Fields["rows"] = value.NewArray(rowInsts)
-			resInst.Fields["row_count"] = value.NewInt(int64(len(rowInsts)))
-			resInst.Fields["ok"] = value.NewBool(true)
-			resInst.Fields["error"] = value.NewString("")
-
-			return value.Value{Type: value.VAL_OBJ, Obj: resInst}
-		}
-		// DB handle not found - return error result
-		resInst := value.NewInstance(resStruct).Obj.(*value.ObjInstance)
-		resInst.Fields["columns"] = value.NewArray(nil)
-		resInst.Fields["rows"] = value.NewArray(nil)
-		resInst.Fields["row_count"] = value.NewInt(0)
-		resInst.Fields["ok"] = value.NewBool(false)
-		resInst.Fields["error"] = value.NewString("invalid database handle")
-		return value.Value{Type: value.VAL_OBJ, Obj: resInst}
+		return value.NewArray([]value.Value{})
 	})
 
 	vm.DefineNative("hex", func(args []value.Value) value.Value {
@@ -2906,7 +1451,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBytes(string(decoded))
 	})
 
-	vm.DefineNative("base64_encode", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("base64", "encode", func(args []value.Value) value.Value {
 		if len(args) != 1 {
 			return value.NewString("")
 		}
@@ -2920,7 +1465,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewString(base64.StdEncoding.EncodeToString([]byte(data)))
 	})
 
-	vm.DefineNative("base64_decode", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("base64", "decode", func(args []value.Value) value.Value {
 		if len(args) != 1 {
 			return value.NewBytes("")
 		}
@@ -2933,7 +1478,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 
 	const base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 
-	vm.DefineNative("base62_encode", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("base62", "encode", func(args []value.Value) value.Value {
 		if len(args) != 1 {
 			return value.NewString("")
 		}
@@ -2970,7 +1515,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewString(string(coded))
 	})
 
-	vm.DefineNative("base62_decode", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("base62", "decode", func(args []value.Value) value.Value {
 		if len(args) != 1 {
 			return value.NewInt(0)
 		}
@@ -3112,6 +1657,22 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 							typeName = "array"
 						} else if _, ok := val.Obj.(*value.ObjMap); ok {
 							typeName = "map"
+						} else if _, ok := val.Obj.(*value.ObjBuffer); ok {
+							typeName = "buffer"
+						} else if _, ok := val.Obj.(*value.ObjError); ok {
+							typeName = "error"
+						} else if _, ok := val.Obj.(*value.ObjStringBuilder); ok {
+							typeName = "string_builder"
+						} else if _, ok := val.Obj.(*value.ObjImage); ok {
+							typeName = "image"
+						} else if _, ok := val.Obj.(*value.ObjNDArray); ok {
+							typeName = "ndarray"
+						} else if _, ok := val.Obj.(*value.ObjSet); ok {
+							typeName = "set"
+						} else if _, ok := val.Obj.(*value.ObjBigInt); ok {
+							typeName = "bigint"
+						} else if _, ok := val.Obj.(*value.ObjDecimal); ok {
+							typeName = "decimal"
 						} else if inst, ok := val.Obj.(*value.ObjInstance); ok {
 							typeName = inst.Struct.Name
 						} else if _, ok := val.Obj.(*value.ObjStruct); ok {
@@ -3161,11 +1722,11 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewString(fmt.Sprintf(newFormatBuilder.String(), newArgs...))
 	})
 
-	vm.DefineNative("json_dumps", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("json", "dumps", func(args []value.Value) value.Value {
 		if len(args) < 1 {
 			return value.NewString("null")
 		}
-		goVal := jsonValToGo(args[0])
+		goVal := value.ToJSON(args[0])
 		bytes, err := json.Marshal(goVal)
 		if err != nil {
 			return value.NewString("null") // Or error?
@@ -3174,7 +1735,7 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 	})
 
 	// json_parse(str) -> Value
-	vm.DefineNative("json_parse", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("json", "parse", func(args []value.Value) value.Value {
 		if len(args) < 1 {
 			return value.NewNull()
 		}
@@ -3185,11 +1746,11 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		if err != nil {
 			return value.NewNull()
 		}
-		return goValToNoxy(result)
+		return value.FromJSON(result)
 	})
 
 	// json_loads(str, target) -> Bool
-	vm.DefineNative("json_loads", func(args []value.Value) value.Value {
+	vm.DefineModuleNative("json", "loads", func(args []value.Value) value.Value {
 		if len(args) < 2 {
 			return value.NewBool(false)
 		}
@@ -3209,84 +1770,43 @@ func NewWithShared(shared *SharedState, cfg VMConfig) *VM {
 		return value.NewBool(false)
 	})
 
-	return vm
-}
-
-// Helper: Convert Noxy Value to Go Interface for JSON Marshal
-func jsonValToGo(v value.Value) interface{} {
-	switch v.Type {
-	case value.VAL_NULL:
-		return nil
-	case value.VAL_BOOL:
-		return v.AsBool
-	case value.VAL_INT:
-		return v.AsInt
-	case value.VAL_FLOAT:
-		return v.AsFloat
-	case value.VAL_OBJ:
-		switch o := v.Obj.(type) {
-		case string:
-			return o
-		case *value.ObjArray:
-			arr := make([]interface{}, len(o.Elements))
-			for i, el := range o.Elements {
-				arr[i] = jsonValToGo(el)
-			}
-			return arr
-		case *value.ObjMap:
-			m := make(map[string]interface{})
-			for k, val := range o.Data {
-				keyStr := fmt.Sprintf("%v", k)
-				m[keyStr] = jsonValToGo(val)
-			}
-			return m
-		case *value.ObjInstance:
-			m := make(map[string]interface{})
-			for k, val := range o.Fields {
-				m[k] = jsonValToGo(val)
-			}
-			return m
-		case *value.ObjStruct:
-			return o.Name
+	// to_map(value) -> map. Struct instances become maps keyed by field name,
+	// recursively through arrays and maps.
+	vm.DefineNative("to_map", func(args []value.Value) value.Value {
+		if len(args) < 1 {
+			return value.NewNull()
 		}
-	case value.VAL_BYTES:
-		// Base64 encode bytes? Or generic string?
-		return v.Obj.(string)
-	}
-	return v.String()
-}
+		return instanceToMap(args[0])
+	})
 
-// Helper: Convert Go Interface to Noxy Value
-func goValToNoxy(i interface{}) value.Value {
-	if i == nil {
-		return value.NewNull()
-	}
-	switch v := i.(type) {
-	case bool:
-		return value.NewBool(v)
-	case float64:
-		// JSON numbers are float64 by default
-		// Try to see if it's an int
-		if v == float64(int64(v)) {
-			return value.NewInt(int64(v))
-		}
-		return value.NewFloat(v)
-	case string:
-		return value.NewString(v)
-	case []interface{}:
-		arr := make([]value.Value, len(v))
-		for idx, el := range v {
-			arr[idx] = goValToNoxy(el)
+	// from_map(StructDef, map) -> instance or null. Nested struct fields,
+	// arrays and maps of structs are rebuilt from their declared types.
+	vm.DefineNative("from_map", func(args []value.Value) value.Value {
+		if len(args) < 2 {
+			return value.NewNull()
 		}
-		return value.NewArray(arr)
-	case map[string]interface{}:
-		m := make(map[string]value.Value)
-		for k, val := range v {
-			m[k] = goValToNoxy(val)
+		def, ok := args[0].Obj.(*value.ObjStruct)
+		if !ok || args[0].Type != value.VAL_OBJ {
+			return value.NewNull()
 		}
-		return value.NewMapWithData(m)
+		if result, ok := mapToInstance(vm, def, args[1]); ok {
+			return result
+		}
+		return value.NewNull()
+	})
+
+	return vm
+}
+
+// sortedMapKeys orders the keys of a decoded JSON object, since Go maps
+// do not keep the document order.
+func sortedMapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	return value.NewString(fmt.Sprintf("%v", i))
+	sort.Strings(keys)
+	return keys
 }
 
 // Helper: Populate Target
@@ -3296,6 +1816,9 @@ func populateTarget(vm *VM, target value.Value, data interface{}) bool {
 		populateRef(vm, ref, data)
 		return true
 	} else if target.Type == value.VAL_OBJ {
+		if value.IsFrozen(target) {
+			return false
+		}
 		// Populate Object In-Place
 		populateObj(vm, target, data)
 		return true
@@ -3310,22 +1833,22 @@ func populateObj(vm *VM, currentVal value.Value, data interface{}) {
 			for _, fieldName := range inst.Struct.Fields {
 				if val, exists := dataMap[fieldName]; exists {
 					// Check if field is instance -> recurse
-					fieldType := inst.Fields[fieldName]
+					fieldType := inst.Field(fieldName)
 					if fieldType.Type == value.VAL_OBJ {
 						if _, isInst := fieldType.Obj.(*value.ObjInstance); isInst {
 							populateObj(vm, fieldType, val)
 							continue
 						}
 					}
-					inst.Fields[fieldName] = goValToNoxy(val)
+					inst.Set(fieldName, value.FromJSON(val))
 				}
 			}
 		}
 	} else if m, ok := currentVal.Obj.(*value.ObjMap); ok {
 		if dataMap, ok := data.(map[string]interface{}); ok {
 			// Clear logic? Or merge? Go unmarshal merges.
-			for k, v := range dataMap {
-				m.Data[k] = goValToNoxy(v)
+			for _, k := range sortedMapKeys(dataMap) {
+				m.Set(k, value.FromJSON(dataMap[k]))
 			}
 		}
 	} else if arr, ok := currentVal.Obj.(*value.ObjArray); ok {
@@ -3336,13 +1859,201 @@ func populateObj(vm *VM, currentVal value.Value, data interface{}) {
 			// Actually we can just replace Elements slice.
 			newElems := make([]value.Value, len(dataArr))
 			for i, el := range dataArr {
-				newElems[i] = goValToNoxy(el)
+				newElems[i] = value.FromJSON(el)
 			}
+			arr.Generic()
 			arr.Elements = newElems
 		}
 	}
 }
 
+// Helper: Convert instances to maps for to_map
+func instanceToMap(v value.Value) value.Value {
+	if v.Type != value.VAL_OBJ {
+		return v
+	}
+	switch o := v.Obj.(type) {
+	case *value.ObjInstance:
+		m := make(map[string]value.Value)
+		for _, name := range o.Struct.Fields {
+			m[name] = instanceToMap(o.Field(name))
+		}
+		return value.NewMapWithData(m)
+	case *value.ObjArray:
+		arr := make([]value.Value, o.Len())
+		for i, el := range o.Values() {
+			arr[i] = instanceToMap(el)
+		}
+		return value.NewArray(arr)
+	case *value.ObjMap:
+		m := value.NewMap()
+		result := m.Obj.(*value.ObjMap)
+		for _, k := range o.Keys {
+			result.Set(k, instanceToMap(o.Data[k]))
+		}
+		return m
+	}
+	return v
+}
+
+// Helper: Build an instance of def from a map for from_map
+func mapToInstance(vm *VM, def *value.ObjStruct, v value.Value) (value.Value, bool) {
+	if v.Type == value.VAL_OBJ {
+		if inst, ok := v.Obj.(*value.ObjInstance); ok && inst.Struct == def {
+			return v, true
+		}
+	}
+	m, ok := v.Obj.(*value.ObjMap)
+	if !ok || v.Type != value.VAL_OBJ {
+		return value.NewNull(), false
+	}
+	result := value.NewInstance(def)
+	inst := result.Obj.(*value.ObjInstance)
+	for i, name := range def.Fields {
+		typ := "any"
+		if i < len(def.FieldTypes) {
+			typ = def.FieldTypes[i]
+		}
+		field, exists := m.Data[name]
+		if !exists || field.Type == value.VAL_NULL {
+			inst.Set(name, zeroValueForType(typ))
+			continue
+		}
+		converted, ok := convertToType(vm, typ, field)
+		if !ok {
+			return value.NewNull(), false
+		}
+		inst.Set(name, converted)
+	}
+	return result, true
+}
+
+// convertToType converts v to the declared type typ ("int", "Point[]",
+// "map[string, Point]"), rebuilding nested struct instances.
+func convertToType(vm *VM, typ string, v value.Value) (value.Value, bool) {
+	switch {
+	case typ == "any" || v.Type == value.VAL_NULL || strings.HasPrefix(typ, "func("):
+		return v, true
+	case typ == "int":
+		if v.Type == value.VAL_FLOAT && v.AsFloat == float64(int64(v.AsFloat)) {
+			return value.NewInt(int64(v.AsFloat)), true
+		}
+		return v, v.Type == value.VAL_INT
+	case typ == "float":
+		if v.Type == value.VAL_INT {
+			return value.NewFloat(float64(v.AsInt)), true
+		}
+		return v, v.Type == value.VAL_FLOAT
+	case typ == "bool":
+		return v, v.Type == value.VAL_BOOL
+	case typ == "string":
+		_, ok := v.Obj.(string)
+		return v, ok && v.Type == value.VAL_OBJ
+	case strings.HasSuffix(typ, "[]"):
+		arr, ok := v.Obj.(*value.ObjArray)
+		if !ok {
+			return v, false
+		}
+		elemType := strings.TrimSuffix(typ, "[]")
+		elems := make([]value.Value, arr.Len())
+		for i, el := range arr.Values() {
+			if elems[i], ok = convertToType(vm, elemType, el); !ok {
+				return v, false
+			}
+		}
+		return value.NewArray(elems), true
+	case strings.HasPrefix(typ, "map[") && strings.HasSuffix(typ, "]"):
+		src, ok := v.Obj.(*value.ObjMap)
+		if !ok {
+			return v, false
+		}
+		valueType := "any"
+		if i := strings.Index(typ, ", "); i >= 0 {
+			valueType = typ[i+2 : len(typ)-1]
+		}
+		result := value.NewMap()
+		dst := result.Obj.(*value.ObjMap)
+		for _, k := range src.Keys {
+			converted, ok := convertToType(vm, valueType, src.Data[k])
+			if !ok {
+				return v, false
+			}
+			dst.Set(k, converted)
+		}
+		return result, true
+	}
+	if def, ok := vm.lookupStruct(typ); ok {
+		return mapToInstance(vm, def, v)
+	}
+	// Unknown types (bytes, func, ...) are kept as they are
+	return v, true
+}
+
+// lookupStruct resolves a struct name used in a field type: in the calling
+// frame's globals, the shared globals, then the loaded modules.
+func (vm *VM) lookupStruct(name string) (*value.ObjStruct, bool) {
+	asStruct := func(v value.Value) (*value.ObjStruct, bool) {
+		def, ok := v.Obj.(*value.ObjStruct)
+		return def, ok && v.Type == value.VAL_OBJ
+	}
+	lookup := func(name string) (value.Value, bool) {
+		if vm.currentFrame != nil && vm.currentFrame.Globals != nil {
+			if v, ok := vm.currentFrame.Globals[name]; ok {
+				return v, true
+			}
+		}
+		return vm.GetGlobal(name)
+	}
+
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		modName, typeName := name[:i], name[i+1:]
+		mod, ok := lookup(modName)
+		if !ok {
+			mod, ok = vm.GetModule(modName)
+		}
+		if m, isMap := mod.Obj.(*value.ObjMap); ok && isMap {
+			return asStruct(m.Data[typeName])
+		}
+		return nil, false
+	}
+
+	if v, ok := lookup(name); ok {
+		return asStruct(v)
+	}
+	for _, mod := range vm.shared.Modules.all() {
+		if m, ok := mod.Obj.(*value.ObjMap); ok {
+			if def, ok := asStruct(m.Data[name]); ok {
+				return def, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// zeroValueForType is the value from_map gives to a missing field.
+func zeroValueForType(typ string) value.Value {
+	switch {
+	case typ == "int":
+		return value.NewInt(0)
+	case typ == "float":
+		return value.NewFloat(0)
+	case typ == "bool":
+		return value.NewBool(false)
+	case typ == "string":
+		return value.NewString("")
+	case typ == "bytes":
+		return value.NewBytes("")
+	case strings.HasPrefix(typ, "func("):
+		// A signature, possibly returning an array
+		return value.NewNull()
+	case strings.HasSuffix(typ, "[]"):
+		return value.NewArray([]value.Value{})
+	case strings.HasPrefix(typ, "map["):
+		return value.NewMap()
+	}
+	return value.NewNull()
+}
+
 // Helper: Populate a Reference with Go Data (Deeply)
 func populateRef(vm *VM, ref *value.ObjRef, data interface{}) {
 	var currentVal value.Value
@@ -3360,13 +2071,13 @@ func populateRef(vm *VM, ref *value.ObjRef, data interface{}) {
 		currentVal = *ref.Ptr
 	case value.REF_PROPERTY:
 		if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-			currentVal = inst.Fields[ref.Name]
+			currentVal = inst.Field(ref.Name)
 		}
 	case value.REF_INDEX:
 		if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
 			idx := int(ref.Index.AsInt)
-			if idx >= 0 && idx < len(arr.Elements) {
-				currentVal = arr.Elements[idx]
+			if idx >= 0 && idx < arr.Len() {
+				currentVal = arr.At(idx)
 			}
 		}
 	}
@@ -3377,27 +2088,156 @@ func populateRef(vm *VM, ref *value.ObjRef, data interface{}) {
 		return
 	}
 
-	// Replace reference
-	newValue := goValToNoxy(data)
-	switch ref.RefType {
-	case value.REF_GLOBAL:
-		vm.SetGlobal(ref.Name, newValue)
-	case value.REF_UPVALUE:
-		*ref.Upvalue.Location = newValue
-	case value.REF_PTR:
-		*ref.Ptr = newValue
-	case value.REF_PROPERTY:
-		if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-			inst.Fields[ref.Name] = newValue
-		}
-	case value.REF_INDEX:
-		if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
-			idx := int(ref.Index.AsInt)
-			if idx >= 0 && idx < len(arr.Elements) {
-				arr.Elements[idx] = newValue
+	// Replace reference
+	newValue := value.FromJSON(data)
+	switch ref.RefType {
+	case value.REF_GLOBAL:
+		vm.SetGlobal(ref.Name, newValue)
+	case value.REF_UPVALUE:
+		*ref.Upvalue.Location = newValue
+	case value.REF_PTR:
+		*ref.Ptr = newValue
+	case value.REF_PROPERTY:
+		if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
+			inst.Set(ref.Name, newValue)
+		}
+	case value.REF_INDEX:
+		if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
+			idx := int(ref.Index.AsInt)
+			if idx >= 0 && idx < arr.Len() {
+				arr.Set(idx, newValue)
+			}
+		}
+	}
+}
+
+// projectModFiles returns the parsed noxy.mod files that apply to this run
+// (RootPath first, then the working directory) with their directories.
+func (vm *VM) projectModFiles() ([]*pkgmanager.ModuleConfig, []string) {
+	var configs []*pkgmanager.ModuleConfig
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, dir := range []string{vm.Config.RootPath, "."} {
+		abs, err := filepath.Abs(dir)
+		if err != nil || seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		path := filepath.Join(dir, "noxy.mod")
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		config, err := pkgmanager.ParseModFile(path)
+		if err != nil {
+			continue
+		}
+		configs = append(configs, config)
+		dirs = append(dirs, dir)
+	}
+	return configs, dirs
+}
+
+// remotePluginURL returns the endpoint configured for a plugin in the
+// project's noxy.mod, or "".
+func (vm *VM) remotePluginURL(name string) string {
+	configs, _ := vm.projectModFiles()
+	for _, config := range configs {
+		if url, ok := config.Plugins[name]; ok {
+			return url
+		}
+	}
+	return ""
+}
+
+// cachedModulePath maps a module path inside a package the project
+// depends on to its copy in the user-level package cache. Only entries
+// matching noxy.sum are used, and none for a vendored project.
+func (vm *VM) cachedModulePath(suffix string) (string, bool) {
+	configs, dirs := vm.projectModFiles()
+	for i, config := range configs {
+		for pkg, dir := range pkgmanager.CachedPackages(dirs[i], config) {
+			rest, ok := moduleSubpath(suffix, pkg)
+			if !ok {
+				continue
+			}
+			return filepath.Join(dir, rest), true
+		}
+	}
+	return "", false
+}
+
+// moduleSubpath reports whether suffix (an import path converted to a file
+// path) lies inside package pkg, and returns the remainder.
+func moduleSubpath(suffix, pkg string) (string, bool) {
+	prefix := filepath.FromSlash(pkgmanager.LibPath(pkg))
+	if suffix == prefix {
+		return "", true
+	}
+	if strings.HasPrefix(suffix, prefix+string(filepath.Separator)) {
+		return suffix[len(prefix)+1:], true
+	}
+	return "", false
+}
+
+// modulePathName converts an import name into a relative file path. An
+// alias declared in noxy.mod (alias json github.com/user/noxy-json) or the
+// identifier form of a required package (github_com.user.noxy_json) maps to
+// the package's directory under noxy_libs, so packages whose names are not
+// valid identifiers can still be imported.
+func modulePathName(name string, configs []*pkgmanager.ModuleConfig) string {
+	toPath := func(pkg, rest string) string {
+		dir := filepath.FromSlash(pkgmanager.LibPath(pkg))
+		if rest == "" {
+			return dir
+		}
+		return filepath.Join(dir, strings.ReplaceAll(rest, ".", string(filepath.Separator)))
+	}
+
+	head, rest := name, ""
+	if i := strings.Index(name, "."); i >= 0 {
+		head, rest = name[:i], name[i+1:]
+	}
+	for _, config := range configs {
+		if pkg, ok := config.Aliases[head]; ok {
+			return toPath(pkg, rest)
+		}
+	}
+	for _, config := range configs {
+		for pkg := range config.Require {
+			prefix := pkgmanager.ImportPath(pkg)
+			if name == prefix {
+				return toPath(pkg, "")
+			}
+			if strings.HasPrefix(name, prefix+".") {
+				return toPath(pkg, name[len(prefix)+1:])
+			}
+		}
+	}
+	return strings.ReplaceAll(name, ".", string(filepath.Separator))
+}
+
+// replacedModulePath maps a module path inside a package that noxy.mod
+// replaces with a local directory (replace github.com/u/lib => ../lib) to
+// the corresponding path in that directory.
+func (vm *VM) replacedModulePath(suffix string) (string, bool) {
+	configs, dirs := vm.projectModFiles()
+	for i, config := range configs {
+		for pkg, target := range config.Replace {
+			if !pkgmanager.IsLocalReplacement(target) {
+				continue
+			}
+			rest, ok := moduleSubpath(suffix, pkg)
+			if !ok {
+				continue
 			}
+			base := target
+			if !filepath.IsAbs(base) {
+				base = filepath.Join(dirs[i], base)
+			}
+			return filepath.Join(base, rest), true
 		}
 	}
+	return "", false
 }
 
 func (vm *VM) DefineNative(name string, fn value.NativeFunc) {
@@ -3408,6 +2248,56 @@ func (vm *VM) DefineNative(name string, fn value.NativeFunc) {
 	vm.SetGlobal(name, value.NewNative(name, fn))
 }
 
+// DefineModuleNative adds fn to the native module named module. Scripts
+// call it as module.name after `use module`, or through the flat global
+// module_name that older scripts and the stdlib wrappers use. Like
+// DefineNative, it never replaces an existing definition.
+func (vm *VM) DefineModuleNative(module, name string, fn value.NativeFunc) {
+	vm.shared.GlobalsLock.Lock()
+	defer vm.shared.GlobalsLock.Unlock()
+	members, ok := vm.shared.NativeModules[module]
+	if !ok {
+		members = make(map[string]value.Value)
+		vm.shared.NativeModules[module] = members
+	}
+	if _, exists := members[name]; exists {
+		return
+	}
+	native := value.NewNative(module+"."+name, fn)
+	members[name] = native
+	flat := module + "_" + name
+	if _, exists := vm.shared.Globals[flat]; !exists {
+		vm.shared.Globals[flat] = native
+	}
+}
+
+// RegisterModule defines a whole native module at once. Importing it with
+// `use name` yields a module whose members are the given functions. When a
+// script module of the same name exists (such as the stdlib's time.nx), the
+// natives are added to it alongside the members the script defines.
+func (vm *VM) RegisterModule(name string, members map[string]value.NativeFunc) {
+	names := make([]string, 0, len(members))
+	for member := range members {
+		names = append(names, member)
+	}
+	sort.Strings(names)
+	for _, member := range names {
+		vm.DefineModuleNative(name, member, members[member])
+	}
+}
+
+// nativeModule returns a fresh module map holding the natives registered
+// under name.
+func (vm *VM) nativeModule(name string) (*value.ObjMap, bool) {
+	vm.shared.GlobalsLock.RLock()
+	defer vm.shared.GlobalsLock.RUnlock()
+	members, ok := vm.shared.NativeModules[name]
+	if !ok {
+		return nil, false
+	}
+	return value.NewMapWithData(members).Obj.(*value.ObjMap), true
+}
+
 func (vm *VM) SetGlobal(name string, val value.Value) {
 	vm.shared.GlobalsLock.Lock()
 	defer vm.shared.GlobalsLock.Unlock()
@@ -3422,23 +2312,63 @@ func (vm *VM) GetGlobal(name string) (value.Value, bool) {
 }
 
 func (vm *VM) SetModule(name string, val value.Value) {
-	vm.shared.GlobalsLock.Lock()
-	defer vm.shared.GlobalsLock.Unlock()
-	vm.shared.Modules[name] = val
+	vm.shared.Modules.set(name, val)
 }
 
 func (vm *VM) GetModule(name string) (value.Value, bool) {
-	vm.shared.GlobalsLock.RLock()
-	defer vm.shared.GlobalsLock.RUnlock()
-	val, ok := vm.shared.Modules[name]
-	return val, ok
+	return vm.shared.Modules.get(name)
+}
+
+// ImportModule returns the module called name, loading and caching it on
+// first use. Threads importing the same module at once share one load.
+func (vm *VM) ImportModule(name string) (value.Value, error) {
+	return vm.shared.Modules.importModule(vm, name, func() (value.Value, error) {
+		return vm.loadModule(name)
+	})
 }
 
+// Interpret runs c to completion. A VM can run any number of chunks, one
+// after another: each run starts with an empty stack, but globals, loaded
+// modules and open handles left behind by earlier runs stay in place, so a
+// later chunk can use what an earlier one defined (this is how the REPL
+// works). Call Reset between runs to start from a fresh state instead.
 func (vm *VM) Interpret(c *chunk.Chunk) error {
 	// Pass nil to indicate using Shared State Globals
 	return vm.InterpretWithGlobals(c, nil)
 }
 
+// Reset returns the VM to the state of a newly constructed one: open
+// handles are closed (see Close), stubs are undone (see Stub), the working
+// directory goes back to VMConfig.WorkDir, and script globals and the
+// module cache are dropped. Natives, including those added with
+// DefineNative, are kept.
+// Reset affects the state shared with spawned threads, so none may still
+// be running.
+func (vm *VM) Reset() {
+	vm.Close()
+	vm.RestoreStubs()
+
+	vm.shared.GlobalsLock.Lock()
+	for name, val := range vm.shared.Globals {
+		if val.Type != value.VAL_NATIVE {
+			delete(vm.shared.Globals, name)
+		}
+	}
+	vm.shared.WorkDir = absWorkDir(vm.Config.WorkDir)
+	vm.shared.GlobalsLock.Unlock()
+	vm.shared.Modules.reset()
+
+	for i := range vm.stack[:vm.stackTop] {
+		vm.stack[i] = value.Value{}
+	}
+	vm.stackTop = 0
+	vm.frameCount = 0
+	vm.currentFrame = nil
+	vm.openUpvalues = nil
+	vm.tries = nil
+	vm.LastPopped = value.Value{}
+}
+
 func (vm *VM) InterpretWithGlobals(c *chunk.Chunk, globals map[string]value.Value) error {
 	scriptFn := &value.ObjFunction{
 		Name:    "script",
@@ -3448,31 +2378,81 @@ func (vm *VM) InterpretWithGlobals(c *chunk.Chunk, globals map[string]value.Valu
 	}
 
 	vm.stackTop = 0
+	// Upvalues left open by an earlier run point into its stack, and so
+	// do the try blocks it failed in
+	vm.openUpvalues = nil
+	vm.tries = vm.tries[:0]
 	vm.push(value.NewFunction("script", 0, 0, nil, c, globals)) // Push script function to stack slot 0
 
 	// Call frame for script
 	scriptClosure := &value.ObjClosure{Function: scriptFn, Upvalues: []*value.ObjUpvalue{}, Globals: globals}
-	frame := &CallFrame{
+	vm.frames[0] = CallFrame{
 		Closure: scriptClosure,
 		IP:      0,
 		Slots:   1,   // Locals start at 1
 		Globals: nil, // Use nil to force fallback to Shared VM Globals (Locked)
 	}
-
-	if globals != nil && len(globals) > 0 {
-		frame.Globals = globals
-	} else {
-		frame.Globals = nil
+	if len(globals) > 0 {
+		vm.frames[0].Globals = globals
 	}
-
-	vm.frames[0] = frame
 	vm.frameCount = 1
-	vm.currentFrame = frame
+	vm.currentFrame = &vm.frames[0]
 
+	if vm.Config.PreloadModules {
+		if err := vm.preloadImports(c); err != nil {
+			return err
+		}
+	}
 	return vm.run(1)
 }
 
+// preloadImports loads the modules imported anywhere in c, including in
+// its functions, so that they are loaded before c runs. Modules this VM is
+// loading already are skipped: they import each other, which lazy imports
+// allow.
+func (vm *VM) preloadImports(c *chunk.Chunk) error {
+	for _, imp := range c.Imports {
+		if vm.shared.Modules.loadingBy(vm, imp.Module) {
+			continue
+		}
+		if _, err := vm.ImportModule(imp.Module); err != nil {
+			var exit *ExitError
+			var rerr *RuntimeError
+			if errors.As(err, &exit) || errors.As(err, &rerr) {
+				return err
+			}
+			return &RuntimeError{
+				Message: fmt.Sprintf("failed to import module '%s': %v", imp.Module, err),
+				File:    c.FileName,
+				Line:    imp.Line,
+			}
+		}
+	}
+	for _, k := range c.Constants {
+		if fn, ok := k.Obj.(*value.ObjFunction); ok {
+			if fc, ok := fn.Chunk.(*chunk.Chunk); ok {
+				if err := vm.preloadImports(fc); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// run executes frames until the frame count drops below minFrameCount. A
+// runtime error raised inside a try block of one of those frames resumes
+// at the block's catch instead of ending the run.
 func (vm *VM) run(minFrameCount int) error {
+	for {
+		err := vm.execute(minFrameCount)
+		if err == nil || !vm.catch(err, minFrameCount) {
+			return err
+		}
+	}
+}
+
+func (vm *VM) execute(minFrameCount int) error {
 	// Cache current frame values for speed
 	frame := vm.currentFrame
 	c := frame.Closure.Function.Chunk.(*chunk.Chunk)
@@ -3483,6 +2463,13 @@ func (vm *VM) run(minFrameCount int) error {
 			return nil
 		}
 
+		if vm.Config.Trace != TraceOff {
+			vm.traceStep(frame, c, ip)
+		}
+		if vm.stackTop > StackMax-stackSlack {
+			return vm.runtimeError(c, ip+1, "stack overflow (more than %d values on the stack)", StackMax-stackSlack)
+		}
+
 		instruction := chunk.OpCode(c.Code[ip])
 		ip++
 
@@ -3552,6 +2539,13 @@ func (vm *VM) run(minFrameCount int) error {
 				ip += offset
 			}
 
+		case chunk.OP_JUMP_IF_NOT_NULL:
+			offset := int(c.Code[ip])<<8 | int(c.Code[ip+1])
+			ip += 2
+			if vm.peek(0).Type != value.VAL_NULL {
+				ip += offset
+			}
+
 		case chunk.OP_LOOP:
 			offset := int(c.Code[ip])<<8 | int(c.Code[ip+1])
 			ip += 2
@@ -3564,6 +2558,14 @@ func (vm *VM) run(minFrameCount int) error {
 		case chunk.OP_POP:
 			vm.LastPopped = vm.pop()
 
+		case chunk.OP_CHECK_TYPE:
+			typeName := c.Constants[int(c.Code[ip])<<8|int(c.Code[ip+1])].Obj.(string)
+			what := c.Constants[int(c.Code[ip+2])<<8|int(c.Code[ip+3])].Obj.(string)
+			ip += 4
+			if v := vm.peek(0); !matchesType(v, typeName) {
+				return vm.runtimeError(c, ip, "strict: %s is declared %s but got %s", what, typeName, value.TypeName(v))
+			}
+
 		case chunk.OP_ADDR:
 			val := vm.pop()
 			if val.Type == value.VAL_REF {
@@ -3601,7 +2603,13 @@ func (vm *VM) run(minFrameCount int) error {
 				// Try VM globals (Builtins / Shared)
 				val, ok = vm.GetGlobal(name)
 				if !ok {
-					return vm.runtimeError(c, ip, "undefined global variable '%s'", name)
+					return vm.undefinedGlobal(c, ip, frame.Globals, name)
+				}
+			}
+			if val.Type == value.VAL_OBJ {
+				var err error
+				if val, err = vm.resolveLazy(frame, c, ip, val); err != nil {
+					return err
 				}
 			}
 			vm.push(val)
@@ -3674,7 +2682,7 @@ func (vm *VM) run(minFrameCount int) error {
 					if !ok {
 						val, ok = vm.GetGlobal(ref.Name)
 						if !ok {
-							return vm.runtimeError(c, ip, "undefined global variable '%s'", ref.Name)
+							return vm.undefinedGlobal(c, ip, frame.Globals, ref.Name)
 						}
 					}
 					container = val
@@ -3684,7 +2692,7 @@ func (vm *VM) run(minFrameCount int) error {
 					container = *ref.Ptr
 				case value.REF_PROPERTY:
 					if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-						if val, ok := inst.Fields[ref.Name]; ok {
+						if val, ok := inst.Get(ref.Name); ok {
 							container = val
 						} else {
 							container = value.NewNull()
@@ -3693,8 +2701,8 @@ func (vm *VM) run(minFrameCount int) error {
 				case value.REF_INDEX:
 					if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
 						idx := int(ref.Index.AsInt)
-						if idx >= 0 && idx < len(arr.Elements) {
-							container = arr.Elements[idx]
+						if idx >= 0 && idx < arr.Len() {
+							container = arr.At(idx)
 						}
 					}
 				}
@@ -3708,7 +2716,7 @@ func (vm *VM) run(minFrameCount int) error {
 			// Debug: Check ID if Node
 			/*(
 			  if inst, ok := container.Obj.(*value.ObjInstance); ok {
-			       if idVal, hasId := inst.Fields["id"]; hasId {
+			       if idVal, hasId := inst.Get("id"); hasId {
 			           fmt.Printf("VM REF_PROPERTY: %s on Node[%v]\n", name, idVal)
 			       }
 			  }
@@ -3755,7 +2763,7 @@ func (vm *VM) run(minFrameCount int) error {
 					if !ok {
 						val, ok = vm.GetGlobal(ref.Name)
 						if !ok {
-							return vm.runtimeError(c, ip, "undefined global variable '%s'", ref.Name)
+							return vm.undefinedGlobal(c, ip, frame.Globals, ref.Name)
 						}
 					}
 					vm.push(val)
@@ -3769,7 +2777,7 @@ func (vm *VM) run(minFrameCount int) error {
 					// Read property from container
 					// Assume ObjInstance for now. Could be Map (if string key?) or Module?
 					if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-						if val, ok := inst.Fields[ref.Name]; ok {
+						if val, ok := inst.Get(ref.Name); ok {
 							vm.push(val)
 						} else {
 							// Default value or null? Or error?
@@ -3784,31 +2792,18 @@ func (vm *VM) run(minFrameCount int) error {
 					// Read index from container (Array or Map)
 					if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
 						idx := int(ref.Index.AsInt)
-						if idx < 0 || idx >= len(arr.Elements) {
+						if idx < 0 || idx >= arr.Len() {
 							return vm.runtimeError(c, ip, "Index out of bounds")
 						}
-						vm.push(arr.Elements[idx])
+						vm.push(arr.At(idx))
 					} else if m, ok := ref.Container.Obj.(*value.ObjMap); ok {
 						// Map key
 						// Need to hash key? ObjMap uses interface{} key or Value key?
 						// ObjMap keys are interface{}. We need Value->Interface conversion or map stores Values?
 						// value.go: Data map[interface{}]Value
-						var key interface{}
-						// Minimal key conversion logic (duplicated from elsewhere? or simple)
-						if ref.Index.Type == value.VAL_OBJ {
-							if s, ok := ref.Index.Obj.(string); ok {
-								key = s
-							} else {
-								key = ref.Index.Obj // Pointer/etc
-							}
-						} else {
-							// Primitive
-							if ref.Index.Type == value.VAL_INT {
-								key = ref.Index.AsInt
-							} else {
-								key = ref.Index.AsInt
-								return vm.runtimeError(c, ip, "Map key type not fully supported in ref yet")
-							}
+						key, err := ref.Index.HashKey()
+						if err != nil {
+							return vm.runtimeError(c, ip, "invalid map key: %s", err)
 						}
 
 						if val, ok := m.Data[key]; ok {
@@ -3850,31 +2845,31 @@ func (vm *VM) run(minFrameCount int) error {
 				// Local assignment: Write to Ptr
 				*ref.Ptr = val
 			case value.REF_PROPERTY:
+				if err := value.CheckMutable(ref.Container); err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
 				if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-					inst.Fields[ref.Name] = val
+					inst.Set(ref.Name, val)
 				} else {
 					return vm.runtimeError(c, ip, "Target is not an instance")
 				}
 			case value.REF_INDEX:
+				if err := value.CheckMutable(ref.Container); err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
 				if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
 					idx := int(ref.Index.AsInt)
-					if idx < 0 || idx >= len(arr.Elements) {
+					if idx < 0 || idx >= arr.Len() {
 						return vm.runtimeError(c, ip, "Index out of bounds")
 					}
-					arr.Elements[idx] = val
+					arr.Set(idx, val)
 				} else if m, ok := ref.Container.Obj.(*value.ObjMap); ok {
 					// Map Write
-					var key interface{}
-					if ref.Index.Type == value.VAL_OBJ {
-						if s, ok := ref.Index.Obj.(string); ok {
-							key = s
-						} else {
-							return vm.runtimeError(c, ip, "Map key must be string (simple ref support)")
-						}
-					} else if ref.Index.Type == value.VAL_INT {
-						key = ref.Index.AsInt
+					key, err := ref.Index.HashKey()
+					if err != nil {
+						return vm.runtimeError(c, ip, "invalid map key: %s", err)
 					}
-					m.Data[key] = val
+					m.Set(key, val)
 				}
 			}
 		case chunk.OP_STORE_REF:
@@ -3900,30 +2895,30 @@ func (vm *VM) run(minFrameCount int) error {
 			case value.REF_PTR:
 				*ref.Ptr = val
 			case value.REF_PROPERTY:
+				if err := value.CheckMutable(ref.Container); err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
 				if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-					inst.Fields[ref.Name] = val
+					inst.Set(ref.Name, val)
 				} else {
 					return vm.runtimeError(c, ip, "Target is not an instance")
 				}
 			case value.REF_INDEX:
+				if err := value.CheckMutable(ref.Container); err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
 				if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
 					idx := int(ref.Index.AsInt)
-					if idx < 0 || idx >= len(arr.Elements) {
+					if idx < 0 || idx >= arr.Len() {
 						return vm.runtimeError(c, ip, "Index out of bounds")
 					}
-					arr.Elements[idx] = val
+					arr.Set(idx, val)
 				} else if m, ok := ref.Container.Obj.(*value.ObjMap); ok {
-					var key interface{}
-					if ref.Index.Type == value.VAL_OBJ {
-						if s, ok := ref.Index.Obj.(string); ok {
-							key = s
-						} else {
-							return vm.runtimeError(c, ip, "Map key must be string")
-						}
-					} else if ref.Index.Type == value.VAL_INT {
-						key = ref.Index.AsInt
+					key, err := ref.Index.HashKey()
+					if err != nil {
+						return vm.runtimeError(c, ip, "invalid map key: %s", err)
 					}
-					m.Data[key] = val
+					m.Set(key, val)
 				}
 			}
 
@@ -3931,6 +2926,11 @@ func (vm *VM) run(minFrameCount int) error {
 			b := vm.pop()
 			a := vm.pop()
 			if a.Type == value.VAL_INT && b.Type == value.VAL_INT {
+				if vm.Config.CheckedArithmetic {
+					if msg := checkIntArith('+', a.AsInt, b.AsInt); msg != "" {
+						return vm.runtimeError(c, ip, "%s", msg)
+					}
+				}
 				vm.push(value.NewInt(a.AsInt + b.AsInt))
 			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_FLOAT {
 				vm.push(value.NewFloat(a.AsFloat + b.AsFloat))
@@ -3939,6 +2939,15 @@ func (vm *VM) run(minFrameCount int) error {
 
 			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_INT {
 				vm.push(value.NewFloat(a.AsFloat + float64(b.AsInt)))
+			} else if x, y, ok := bigIntOperands(a, b); ok {
+				vm.push(value.NewBigInt(new(big.Int).Add(x, y)))
+			} else if x, y, ok := decimalOperands(a, b); ok {
+				vm.push(decimalAdd(x, y))
+			} else if r, ok, err := ndarrayArith('+', a, b); ok {
+				if err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
+				vm.push(r)
 			} else if a.Type == value.VAL_OBJ && b.Type == value.VAL_OBJ {
 				// Check if both are strings
 				strA, okA := a.Obj.(string)
@@ -3960,6 +2969,11 @@ func (vm *VM) run(minFrameCount int) error {
 			} else {
 				return vm.runtimeError(c, ip, "operands must be numbers or strings or bytes")
 			}
+			if vm.Config.CheckedArithmetic {
+				if msg := checkFloatResult('+', a, b, vm.peek(0)); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 
 		case chunk.OP_ADD_INT:
 			// Inline pop/pop/push for optimization
@@ -3967,6 +2981,11 @@ func (vm *VM) run(minFrameCount int) error {
 			// result replaces a (at stackTop-2)
 			// b (at stackTop-1) is cleared
 			// stackTop decrements by 1
+			if vm.Config.CheckedArithmetic {
+				if msg := checkIntArith('+', vm.stack[vm.stackTop-2].AsInt, vm.stack[vm.stackTop-1].AsInt); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 			vm.stack[vm.stackTop-2] = value.NewInt(vm.stack[vm.stackTop-2].AsInt + vm.stack[vm.stackTop-1].AsInt)
 			vm.stack[vm.stackTop-1] = value.Value{}
 			vm.stackTop--
@@ -3975,6 +2994,11 @@ func (vm *VM) run(minFrameCount int) error {
 			b := vm.pop()
 			a := vm.pop()
 			if a.Type == value.VAL_INT && b.Type == value.VAL_INT {
+				if vm.Config.CheckedArithmetic {
+					if msg := checkIntArith('-', a.AsInt, b.AsInt); msg != "" {
+						return vm.runtimeError(c, ip, "%s", msg)
+					}
+				}
 				vm.push(value.NewInt(a.AsInt - b.AsInt))
 			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_FLOAT {
 				vm.push(value.NewFloat(a.AsFloat - b.AsFloat))
@@ -3982,17 +3006,41 @@ func (vm *VM) run(minFrameCount int) error {
 				vm.push(value.NewFloat(float64(a.AsInt) - b.AsFloat))
 			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_INT {
 				vm.push(value.NewFloat(a.AsFloat - float64(b.AsInt)))
+			} else if x, y, ok := bigIntOperands(a, b); ok {
+				vm.push(value.NewBigInt(new(big.Int).Sub(x, y)))
+			} else if x, y, ok := decimalOperands(a, b); ok {
+				vm.push(decimalSub(x, y))
+			} else if r, ok, err := ndarrayArith('-', a, b); ok {
+				if err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
+				vm.push(r)
 			} else {
 				return vm.runtimeError(c, ip, "operands must be numbers")
 			}
+			if vm.Config.CheckedArithmetic {
+				if msg := checkFloatResult('-', a, b, vm.peek(0)); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 		case chunk.OP_SUB_INT:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.Config.CheckedArithmetic {
+				if msg := checkIntArith('-', a.AsInt, b.AsInt); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 			vm.push(value.NewInt(a.AsInt - b.AsInt))
 		case chunk.OP_MULTIPLY:
 			b := vm.pop()
 			a := vm.pop()
 			if a.Type == value.VAL_INT && b.Type == value.VAL_INT {
+				if vm.Config.CheckedArithmetic {
+					if msg := checkIntArith('*', a.AsInt, b.AsInt); msg != "" {
+						return vm.runtimeError(c, ip, "%s", msg)
+					}
+				}
 				vm.push(value.NewInt(a.AsInt * b.AsInt))
 			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_FLOAT {
 				vm.push(value.NewFloat(a.AsFloat * b.AsFloat))
@@ -4000,12 +3048,31 @@ func (vm *VM) run(minFrameCount int) error {
 				vm.push(value.NewFloat(float64(a.AsInt) * b.AsFloat))
 			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_INT {
 				vm.push(value.NewFloat(a.AsFloat * float64(b.AsInt)))
+			} else if x, y, ok := bigIntOperands(a, b); ok {
+				vm.push(value.NewBigInt(new(big.Int).Mul(x, y)))
+			} else if x, y, ok := decimalOperands(a, b); ok {
+				vm.push(decimalMul(x, y))
+			} else if r, ok, err := ndarrayArith('*', a, b); ok {
+				if err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
+				vm.push(r)
 			} else {
 				return vm.runtimeError(c, ip, "operands must be numbers")
 			}
+			if vm.Config.CheckedArithmetic {
+				if msg := checkFloatResult('*', a, b, vm.peek(0)); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 		case chunk.OP_MUL_INT:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.Config.CheckedArithmetic {
+				if msg := checkIntArith('*', a.AsInt, b.AsInt); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 			vm.push(value.NewInt(a.AsInt * b.AsInt))
 		case chunk.OP_DIVIDE:
 			b := vm.pop()
@@ -4014,6 +3081,11 @@ func (vm *VM) run(minFrameCount int) error {
 				if b.AsInt == 0 {
 					return vm.runtimeError(c, ip, "division by zero")
 				}
+				if vm.Config.CheckedArithmetic {
+					if msg := checkIntArith('/', a.AsInt, b.AsInt); msg != "" {
+						return vm.runtimeError(c, ip, "%s", msg)
+					}
+				}
 				vm.push(value.NewInt(a.AsInt / b.AsInt))
 			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_FLOAT {
 				if b.AsFloat == 0 {
@@ -4030,23 +3102,83 @@ func (vm *VM) run(minFrameCount int) error {
 					return vm.runtimeError(c, ip, "division by zero")
 				}
 				vm.push(value.NewFloat(a.AsFloat / float64(b.AsInt)))
+			} else if x, y, ok := bigIntOperands(a, b); ok {
+				if y.Sign() == 0 {
+					return vm.runtimeError(c, ip, "division by zero")
+				}
+				vm.push(value.NewBigInt(new(big.Int).Quo(x, y)))
+			} else if x, y, ok := decimalOperands(a, b); ok {
+				if y.Value.Sign() == 0 {
+					return vm.runtimeError(c, ip, "division by zero")
+				}
+				vm.push(decimalDiv(x, y))
+			} else if r, ok, err := ndarrayArith('/', a, b); ok {
+				if err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
+				vm.push(r)
 			} else {
 				return vm.runtimeError(c, ip, "operands must be numbers")
 			}
+			if vm.Config.CheckedArithmetic {
+				if msg := checkFloatResult('/', a, b, vm.peek(0)); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 		case chunk.OP_DIV_INT:
 			b := vm.pop()
 			a := vm.pop()
 			if b.AsInt == 0 {
 				return vm.runtimeError(c, ip, "division by zero")
 			}
+			if vm.Config.CheckedArithmetic {
+				if msg := checkIntArith('/', a.AsInt, b.AsInt); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 			vm.push(value.NewInt(a.AsInt / b.AsInt))
+		case chunk.OP_ADD_FLOAT, chunk.OP_SUB_FLOAT, chunk.OP_MUL_FLOAT, chunk.OP_DIV_FLOAT:
+			// The operands are typed float; an int can only reach here
+			// through any, and is converted
+			b := vm.pop()
+			a := vm.pop()
+			x, okA := scalarOperand(a)
+			y, okB := scalarOperand(b)
+			if !okA || !okB {
+				return vm.runtimeError(c, ip, "operands must be numbers")
+			}
+			var op byte
+			var r float64
+			switch instruction {
+			case chunk.OP_ADD_FLOAT:
+				op, r = '+', x+y
+			case chunk.OP_SUB_FLOAT:
+				op, r = '-', x-y
+			case chunk.OP_MUL_FLOAT:
+				op, r = '*', x*y
+			default:
+				if y == 0 {
+					return vm.runtimeError(c, ip, "division by zero")
+				}
+				op, r = '/', x/y
+			}
+			vm.push(value.NewFloat(r))
+			if vm.Config.CheckedArithmetic {
+				if msg := checkFloatResult(op, a, b, vm.peek(0)); msg != "" {
+					return vm.runtimeError(c, ip, "%s", msg)
+				}
+			}
 		case chunk.OP_LEN:
 			val := vm.pop()
 			if val.Type == value.VAL_OBJ {
 				if arr, ok := val.Obj.(*value.ObjArray); ok {
-					vm.push(value.NewInt(int64(len(arr.Elements))))
+					vm.push(value.NewInt(int64(arr.Len())))
 				} else if m, ok := val.Obj.(*value.ObjMap); ok {
-					vm.push(value.NewInt(int64(len(m.Data))))
+					vm.push(value.NewInt(int64(m.Len())))
+				} else if buf, ok := val.Obj.(*value.ObjBuffer); ok {
+					vm.push(value.NewInt(int64(len(buf.Data))))
+				} else if set, ok := val.Obj.(*value.ObjSet); ok {
+					vm.push(value.NewInt(int64(set.Len())))
 				} else if s, ok := val.Obj.(string); ok {
 					vm.push(value.NewInt(int64(utf8.RuneCountInString(s))))
 				} else {
@@ -4089,7 +3221,10 @@ func (vm *VM) run(minFrameCount int) error {
 				}
 			}
 
-			chosenIndex, recvVal, recvOK := reflect.Select(cases)
+			chosenIndex, recvVal, recvOK, sent := selectOn(cases)
+			if !sent {
+				return vm.runtimeError(c, ip, "select: send on closed channel")
+			}
 
 			vm.push(value.NewInt(int64(chosenIndex)))
 
@@ -4114,6 +3249,11 @@ func (vm *VM) run(minFrameCount int) error {
 					return vm.runtimeError(c, ip, "modulo by zero")
 				}
 				vm.push(value.NewInt(a.AsInt % b.AsInt))
+			} else if x, y, ok := bigIntOperands(a, b); ok {
+				if y.Sign() == 0 {
+					return vm.runtimeError(c, ip, "modulo by zero")
+				}
+				vm.push(value.NewBigInt(new(big.Int).Rem(x, y)))
 			} else {
 				return vm.runtimeError(c, ip, "operands for %% must be integers")
 			}
@@ -4229,9 +3369,18 @@ func (vm *VM) run(minFrameCount int) error {
 		case chunk.OP_NEGATE:
 			v := vm.pop()
 			if v.Type == value.VAL_INT {
+				if vm.Config.CheckedArithmetic && v.AsInt == math.MinInt64 {
+					return vm.runtimeError(c, ip, "integer overflow: -(%d)", v.AsInt)
+				}
 				vm.push(value.NewInt(-v.AsInt))
 			} else if v.Type == value.VAL_FLOAT {
 				vm.push(value.NewFloat(-v.AsFloat))
+			} else if n, ok := v.Obj.(*value.ObjBigInt); ok {
+				vm.push(value.NewBigInt(new(big.Int).Neg(n.Value)))
+			} else if d, ok := v.Obj.(*value.ObjDecimal); ok {
+				vm.push(value.NewDecimal(new(big.Rat).Neg(d.Value), d.Scale))
+			} else if n, ok := v.Obj.(*value.ObjNDArray); ok {
+				vm.push(value.NewNDArray(ndarray.ApplyScalar('*', n.Array, -1, false)))
 			} else {
 				return vm.runtimeError(c, ip, "operand must be number")
 			}
@@ -4264,44 +3413,22 @@ func (vm *VM) run(minFrameCount int) error {
 				return vm.runtimeError(c, ip, "zeros size must be integer")
 			}
 			count := int(countVal.AsInt)
-			elements := make([]value.Value, count)
-			for i := 0; i < count; i++ {
-				elements[i] = value.NewInt(0)
+			if count < 0 {
+				return vm.runtimeError(c, ip, "zeros size must not be negative, got %d", count)
 			}
-			vm.push(value.NewArray(elements))
-		case chunk.OP_GREATER:
+			vm.push(value.NewIntArray(make([]int64, count)))
+		case chunk.OP_GREATER, chunk.OP_GREATER_EQUAL, chunk.OP_LESS, chunk.OP_LESS_EQUAL:
 			b := vm.pop()
 			a := vm.pop()
-			// Only supporting int/float comparison for now
-			if a.Type == value.VAL_INT && b.Type == value.VAL_INT {
-				vm.push(value.NewBool(a.AsInt > b.AsInt))
-			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_FLOAT {
-				vm.push(value.NewBool(a.AsFloat > b.AsFloat))
-			} else if a.Type == value.VAL_INT && b.Type == value.VAL_FLOAT {
-				vm.push(value.NewBool(float64(a.AsInt) > b.AsFloat))
-			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_INT {
-				vm.push(value.NewBool(a.AsFloat > float64(b.AsInt)))
-			} else {
-				return vm.runtimeError(c, ip, "operands must be numbers")
+			result, ok := compareValues(instruction, a, b)
+			if !ok {
+				return vm.runtimeError(c, ip, "operands must be two numbers or two strings, got %s and %s", value.TypeName(a), value.TypeName(b))
 			}
+			vm.push(value.NewBool(result))
 		case chunk.OP_GREATER_INT:
 			b := vm.pop()
 			a := vm.pop()
 			vm.push(value.NewBool(a.AsInt > b.AsInt))
-		case chunk.OP_LESS:
-			b := vm.pop()
-			a := vm.pop()
-			if a.Type == value.VAL_INT && b.Type == value.VAL_INT {
-				vm.push(value.NewBool(a.AsInt < b.AsInt))
-			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_FLOAT {
-				vm.push(value.NewBool(a.AsFloat < b.AsFloat))
-			} else if a.Type == value.VAL_INT && b.Type == value.VAL_FLOAT {
-				vm.push(value.NewBool(float64(a.AsInt) < b.AsFloat))
-			} else if a.Type == value.VAL_FLOAT && b.Type == value.VAL_INT {
-				vm.push(value.NewBool(a.AsFloat < float64(b.AsInt)))
-			} else {
-				return vm.runtimeError(c, ip, "operands must be numbers")
-			}
 		case chunk.OP_LESS_INT:
 			// Inline pop/pop/push
 			vm.stack[vm.stackTop-2] = value.NewBool(vm.stack[vm.stackTop-2].AsInt < vm.stack[vm.stackTop-1].AsInt)
@@ -4315,10 +3442,6 @@ func (vm *VM) run(minFrameCount int) error {
 			b := vm.pop()
 			a := vm.pop()
 			vm.push(value.NewBool(a.AsInt == b.AsInt))
-		case chunk.OP_PRINT:
-			v := vm.pop()
-			fmt.Println(v)
-
 		case chunk.OP_CALL:
 			argCount := int(c.Code[ip])
 			ip++
@@ -4390,12 +3513,18 @@ func (vm *VM) run(minFrameCount int) error {
 				vm.stack[i] = value.Value{}
 			}
 
-			// 3. Decrement frame count
+			// 3. Pop the frame, clearing it so the closure can be collected
+			slots := calleeFrame.Slots
+			*calleeFrame = CallFrame{}
 			vm.frameCount--
+			// Returning from inside try blocks leaves them
+			for len(vm.tries) > 0 && vm.tries[len(vm.tries)-1].frameCount > vm.frameCount {
+				vm.tries = vm.tries[:len(vm.tries)-1]
+			}
 
 			// 4. Update current frame pointer
 			if vm.frameCount > 0 {
-				vm.currentFrame = vm.frames[vm.frameCount-1]
+				vm.currentFrame = &vm.frames[vm.frameCount-1]
 			} else {
 				vm.currentFrame = nil
 			}
@@ -4405,16 +3534,16 @@ func (vm *VM) run(minFrameCount int) error {
 				// We are exiting the run loop.
 				// We need to place result at the location expected by the caller.
 				// The caller expects the function and args to be consumed, and result pushed.
-				// calleeFrame.Slots is the start of the window (Function object).
-				vm.stackTop = calleeFrame.Slots
+				// slots is the start of the window (Function object).
+				vm.stackTop = slots
 				vm.push(result)
 				return nil
 			}
 
 			// 6. Restore execution context
 			frame = vm.currentFrame
-			vm.stackTop = calleeFrame.Slots // Drop args/locals/function from stackTop
-			vm.push(result)                 // Push result replacing the function
+			vm.stackTop = slots // Drop args/locals/function from stackTop
+			vm.push(result)     // Push result replacing the function
 
 			c = frame.Closure.Function.Chunk.(*chunk.Chunk)
 			ip = frame.IP
@@ -4434,51 +3563,76 @@ func (vm *VM) run(minFrameCount int) error {
 			ip += 2
 
 			// Map expects keys and values on stack: K1, V1, K2, V2...
+			// Read them bottom-up so keys keep their source order.
 			mapObj := value.NewMap()
-			mapData := mapObj.Obj.(*value.ObjMap).Data
+			m := mapObj.Obj.(*value.ObjMap)
+			base := vm.stackTop - 2*count
 
 			for i := 0; i < count; i++ {
-				val := vm.pop()
-				keyVal := vm.pop()
-
-				var key interface{}
-				if keyVal.Type == value.VAL_INT {
-					key = keyVal.AsInt
-				} else if keyVal.Type == value.VAL_OBJ {
-					if str, ok := keyVal.Obj.(string); ok {
-						key = str
-					} else {
-						return vm.runtimeError(c, ip, "map key must be int or string")
-					}
-				} else {
-					return vm.runtimeError(c, ip, "map key must be int or string")
+				keyVal := vm.stack[base+2*i]
+				val := vm.stack[base+2*i+1]
+
+				key, err := keyVal.HashKey()
+				if err != nil {
+					return vm.runtimeError(c, ip, "invalid map key: %s", err)
 				}
-				mapData[key] = val
+				m.Set(key, val)
+			}
+			for vm.stackTop > base {
+				vm.pop()
 			}
 			vm.push(mapObj)
 
 		case chunk.OP_DUP:
 			vm.push(vm.peek(0))
 
+		case chunk.OP_OVER:
+			vm.push(vm.peek(1))
+
 		case chunk.OP_IMPORT:
 			index := c.Code[ip]
 			ip++
 			nameConstant := c.Constants[index]
 			moduleName := nameConstant.Obj.(string)
 
-			// Check cache
-			if mod, ok := vm.GetModule(moduleName); ok {
+			frame.IP = ip // The module runs in a frame above this one
+			mod, err := vm.ImportModule(moduleName)
+			if err != nil {
+				return vm.importError(c, ip, moduleName, err)
+			}
+			vm.push(mod)
+
+			frame = vm.currentFrame
+
+		case chunk.OP_IMPORT_LAZY:
+			moduleName := c.Constants[c.Code[ip]].Obj.(string)
+			ip++
+			if mod, ok := vm.shared.Modules.get(moduleName); ok {
 				vm.push(mod)
 			} else {
-				mod, err := vm.loadModule(moduleName)
-				if err != nil {
-					return vm.runtimeError(c, ip, "failed to import module '%s': %v", moduleName, err)
-				}
-				vm.SetModule(moduleName, mod)
-				vm.push(mod)
+				vm.push(value.Value{Type: value.VAL_OBJ, Obj: &lazyModule{name: moduleName}})
 			}
 
-			frame = vm.currentFrame
+		case chunk.OP_IMPORT_PLUGIN:
+			target := c.Constants[c.Code[ip]].Obj.(string)
+			bind := c.Constants[c.Code[ip+1]].Obj.(string)
+			ip += 2
+			mod, err := vm.importPlugin(target, bind)
+			if err != nil {
+				return vm.runtimeError(c, ip, "failed to load plugin '%s': %v", target, err)
+			}
+			vm.push(mod)
+
+		case chunk.OP_TRY:
+			offset := int(c.Code[ip])<<8 | int(c.Code[ip+1])
+			ip += 2
+			vm.tries = append(vm.tries, tryHandler{frameCount: vm.frameCount, stackTop: vm.stackTop, catchIP: ip + offset})
+
+		case chunk.OP_END_TRY:
+			vm.tries = vm.tries[:len(vm.tries)-1]
+
+		case chunk.OP_THROW:
+			return vm.throw(c, ip, vm.pop())
 
 		case chunk.OP_IMPORT_FROM_ALL:
 			modVal := vm.pop()
@@ -4501,28 +3655,31 @@ func (vm *VM) run(minFrameCount int) error {
 			collectionVal := vm.pop()
 
 			if collectionVal.Type == value.VAL_OBJ {
+				if buf, ok := collectionVal.Obj.(*value.ObjBuffer); ok {
+					if indexVal.Type != value.VAL_INT {
+						return vm.runtimeError(c, ip, "buffer index must be integer")
+					}
+					idx := int(indexVal.AsInt)
+					if idx < 0 || idx >= len(buf.Data) {
+						return vm.runtimeError(c, ip, "buffer index out of bounds")
+					}
+					vm.push(value.NewInt(int64(buf.Data[idx])))
+					continue
+				}
 				if arr, ok := collectionVal.Obj.(*value.ObjArray); ok {
 					if indexVal.Type != value.VAL_INT {
 						return vm.runtimeError(c, ip, "array index must be integer")
 					}
 					idx := int(indexVal.AsInt)
-					if idx < 0 || idx >= len(arr.Elements) {
+					if idx < 0 || idx >= arr.Len() {
 						return vm.runtimeError(c, ip, "array index out of bounds")
 					}
-					vm.push(arr.Elements[idx])
+					vm.push(arr.At(idx))
 					continue
 				} else if mapObj, ok := collectionVal.Obj.(*value.ObjMap); ok {
-					var key interface{}
-					if indexVal.Type == value.VAL_INT {
-						key = indexVal.AsInt
-					} else if indexVal.Type == value.VAL_OBJ {
-						if str, ok := indexVal.Obj.(string); ok {
-							key = str
-						} else {
-							return vm.runtimeError(c, ip, "map key must be int or string")
-						}
-					} else {
-						return vm.runtimeError(c, ip, "map key must be int or string")
+					key, err := indexVal.HashKey()
+					if err != nil {
+						return vm.runtimeError(c, ip, "invalid map key: %s", err)
 					}
 
 					val, ok := mapObj.Data[key]
@@ -4537,12 +3694,11 @@ func (vm *VM) run(minFrameCount int) error {
 					if indexVal.Type != value.VAL_INT {
 						return vm.runtimeError(c, ip, "string index must be integer")
 					}
-					idx := int(indexVal.AsInt)
-					runes := []rune(str) // Expensive but correct for now
-					if idx < 0 || idx >= len(runes) {
+					r, ok := value.RuneAt(str, int(indexVal.AsInt))
+					if !ok {
 						return vm.runtimeError(c, ip, "string index out of bounds")
 					}
-					vm.push(value.NewString(string(runes[idx])))
+					vm.push(value.NewChar(r))
 					continue
 				}
 			}
@@ -4559,7 +3715,7 @@ func (vm *VM) run(minFrameCount int) error {
 				vm.push(value.NewInt(int64(str[idx])))
 				continue
 			}
-			return vm.runtimeError(c, ip, "cannot index non-array/map/bytes")
+			return vm.runtimeError(c, ip, "cannot index non-array/map/bytes/buffer")
 
 		case chunk.OP_SET_INDEX:
 			val := vm.pop()
@@ -4567,106 +3723,167 @@ func (vm *VM) run(minFrameCount int) error {
 			collectionVal := vm.pop() // The array/map itself is on stack (pointer)
 
 			if collectionVal.Type == value.VAL_OBJ {
+				if buf, ok := collectionVal.Obj.(*value.ObjBuffer); ok {
+					if indexVal.Type != value.VAL_INT {
+						return vm.runtimeError(c, ip, "buffer index must be integer")
+					}
+					idx := int(indexVal.AsInt)
+					if idx < 0 || idx >= len(buf.Data) {
+						return vm.runtimeError(c, ip, "buffer index out of bounds")
+					}
+					if val.Type != value.VAL_INT || val.AsInt < 0 || val.AsInt > 255 {
+						return vm.runtimeError(c, ip, "buffer element must be an integer between 0 and 255")
+					}
+					buf.Data[idx] = byte(val.AsInt)
+					vm.push(val)
+					continue
+				}
+				if err := value.CheckMutable(collectionVal); err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
 				if arr, ok := collectionVal.Obj.(*value.ObjArray); ok {
 					if indexVal.Type != value.VAL_INT {
 						return vm.runtimeError(c, ip, "array index must be integer")
 					}
 					idx := int(indexVal.AsInt)
-					if idx < 0 || idx >= len(arr.Elements) {
+					if idx < 0 || idx >= arr.Len() {
 						return vm.runtimeError(c, ip, "array index out of bounds")
 					}
-					arr.Elements[idx] = val
+					arr.Set(idx, val)
 					vm.push(val) // Assignment expression result
 					continue
 				} else if mapObj, ok := collectionVal.Obj.(*value.ObjMap); ok {
-					var key interface{}
-					if indexVal.Type == value.VAL_INT {
-						key = indexVal.AsInt
-					} else if indexVal.Type == value.VAL_OBJ {
-						if str, ok := indexVal.Obj.(string); ok {
-							key = str
-						} else {
-							return vm.runtimeError(c, ip, "map key must be int or string")
-						}
-					} else {
-						return vm.runtimeError(c, ip, "map key must be int or string")
+					key, err := indexVal.HashKey()
+					if err != nil {
+						return vm.runtimeError(c, ip, "invalid map key: %s", err)
 					}
-					mapObj.Data[key] = val
+					mapObj.Set(key, val)
 					vm.push(val)
 					continue
 				}
 			}
-			return vm.runtimeError(c, ip, "cannot set index on non-array/map")
-
-		case chunk.OP_GET_PROPERTY:
-			index := c.Code[ip]
-			ip++
-			nameVal := c.Constants[index]
-			name := nameVal.Obj.(string)
-
-			instanceVal := vm.pop()
+			return vm.runtimeError(c, ip, "cannot set index on non-array/map/buffer")
 
-			// Auto-dereference if instance is a ref
-			if instanceVal.Type == value.VAL_REF {
-				ref := instanceVal.Obj.(*value.ObjRef)
-				switch ref.RefType {
-				case value.REF_GLOBAL:
-					v, ok := frame.Globals[ref.Name]
-					if !ok {
-						v, ok = vm.GetGlobal(ref.Name)
-						if !ok {
-							return vm.runtimeError(c, ip, "undefined global variable '%s'", ref.Name)
-						}
-					}
-					instanceVal = v
-				case value.REF_UPVALUE:
-					instanceVal = *ref.Upvalue.Location
-				case value.REF_PTR:
-					instanceVal = *ref.Ptr
-				case value.REF_PROPERTY:
-					if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-						if v, ok := inst.Fields[ref.Name]; ok {
-							instanceVal = v
-						} else {
-							instanceVal = value.NewNull()
-						}
-					}
-				case value.REF_INDEX:
-					if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
-						idx := int(ref.Index.AsInt)
-						if idx >= 0 && idx < len(arr.Elements) {
-							instanceVal = arr.Elements[idx]
-						}
-					}
-				}
+		case chunk.OP_GET_INDEX_ARRAY:
+			indexVal := vm.pop()
+			arr, ok := vm.pop().Obj.(*value.ObjArray)
+			if !ok {
+				return vm.runtimeError(c, ip, "cannot index non-array/map/bytes/buffer")
+			}
+			if indexVal.Type != value.VAL_INT {
+				return vm.runtimeError(c, ip, "array index must be integer")
+			}
+			idx := int(indexVal.AsInt)
+			switch {
+			case idx < 0 || idx >= arr.Len():
+				return vm.runtimeError(c, ip, "array index out of bounds")
+			case arr.Kind == value.ElemInts:
+				vm.push(value.NewInt(arr.Ints[idx]))
+			case arr.Kind == value.ElemFloats:
+				vm.push(value.NewFloat(arr.Floats[idx]))
+			default:
+				vm.push(arr.Elements[idx])
 			}
 
-			if instanceVal.Type != value.VAL_OBJ {
-				return vm.runtimeError(c, ip, "only instances/maps have properties")
+		case chunk.OP_SET_INDEX_ARRAY:
+			val := vm.pop()
+			indexVal := vm.pop()
+			arrVal := vm.pop()
+			arr, ok := arrVal.Obj.(*value.ObjArray)
+			if !ok {
+				return vm.runtimeError(c, ip, "cannot set index on non-array/map/buffer")
 			}
+			if err := value.CheckMutable(arrVal); err != nil {
+				return vm.runtimeError(c, ip, "%s", err)
+			}
+			if indexVal.Type != value.VAL_INT {
+				return vm.runtimeError(c, ip, "array index must be integer")
+			}
+			idx := int(indexVal.AsInt)
+			if idx < 0 || idx >= arr.Len() {
+				return vm.runtimeError(c, ip, "array index out of bounds")
+			}
+			arr.Set(idx, val)
+			vm.push(val)
 
-			if instance, ok := instanceVal.Obj.(*value.ObjInstance); ok {
-				val, ok := instance.Fields[name]
-				if !ok {
-					return vm.runtimeError(c, ip, "undefined property '%s'", name)
-				}
-				vm.push(val)
-			} else if mapObj, ok := instanceVal.Obj.(*value.ObjMap); ok {
-				// Allow accessing map keys as properties (for modules)
-				val, ok := mapObj.Data[name]
-				if !ok {
-					return vm.runtimeError(c, ip, "undefined property '%s' in module/map", name)
+		case chunk.OP_SPECIALIZE:
+			kind := value.ElemKind(c.Code[ip])
+			ip++
+			// Frozen arrays keep their elements as they are: a float[]
+			// would turn their ints into floats
+			if arr, ok := vm.peek(0).Obj.(*value.ObjArray); ok && !arr.Frozen {
+				arr.Specialize(kind)
+			}
+
+		case chunk.OP_GET_PROPERTY:
+			name := c.Constants[c.Code[ip]].Obj.(string)
+			cache := &c.Caches[int(c.Code[ip+1])<<8|int(c.Code[ip+2])]
+			ip += 3
+
+			receiver, err := vm.derefReceiver(frame, c, ip, vm.pop())
+			if err != nil {
+				return err
+			}
+			if instance, ok := receiver.Obj.(*value.ObjInstance); ok {
+				if slot := fieldSlot(cache, instance, name); slot >= 0 {
+					vm.push(instance.Fields[slot])
+					break
 				}
-				vm.push(val)
+			}
+			val, err := vm.getProperty(frame, c, ip, receiver, name)
+			if err != nil {
+				return err
+			}
+			vm.push(val)
+
+		case chunk.OP_INVOKE:
+			name := c.Constants[c.Code[ip]].Obj.(string)
+			argCount := int(c.Code[ip+1])
+			ip += 2
+
+			frame.IP = ip // Save current instruction pointer to the frame before call
+
+			receiver, err := vm.derefReceiver(frame, c, ip, vm.peek(argCount))
+			if err != nil {
+				return err
+			}
+			calleeSlot := vm.stackTop - argCount - 1
+			var ok bool
+			if method := findMethod(receiver, name); method != nil {
+				// The receiver stays in slot 0 as self
+				vm.stack[calleeSlot] = receiver
+				ok, err = vm.call(method, argCount, c, ip)
 			} else {
-				return vm.runtimeError(c, ip, "only instances and maps have properties")
+				// A field or module member holding a function
+				var callee value.Value
+				if callee, err = vm.getProperty(frame, c, ip, receiver, name); err != nil {
+					return err
+				}
+				vm.stack[calleeSlot] = callee
+				ok, err = vm.callValue(callee, argCount, c, ip)
+			}
+			if !ok {
+				return err
 			}
+			// Update cached frame
+			frame = vm.currentFrame
+			c = frame.Closure.Function.Chunk.(*chunk.Chunk)
+			ip = frame.IP
 
-		case chunk.OP_SET_PROPERTY:
-			index := c.Code[ip]
+		case chunk.OP_METHOD:
+			name := c.Constants[c.Code[ip]].Obj.(string)
 			ip++
-			nameVal := c.Constants[index]
-			name := nameVal.Obj.(string)
+			method := vm.pop().Obj.(*value.ObjClosure)
+			structDef := vm.peek(0).Obj.(*value.ObjStruct)
+			if structDef.Methods == nil {
+				structDef.Methods = make(map[string]*value.ObjClosure)
+			}
+			structDef.Methods[name] = method
+
+		case chunk.OP_SET_PROPERTY:
+			name := c.Constants[c.Code[ip]].Obj.(string)
+			cache := &c.Caches[int(c.Code[ip+1])<<8|int(c.Code[ip+2])]
+			ip += 3
 
 			val := vm.pop()
 			instanceVal := vm.pop()
@@ -4681,7 +3898,7 @@ func (vm *VM) run(minFrameCount int) error {
 					if !ok {
 						v, ok = vm.GetGlobal(ref.Name)
 						if !ok {
-							return vm.runtimeError(c, ip, "undefined global variable '%s'", ref.Name)
+							return vm.undefinedGlobal(c, ip, frame.Globals, ref.Name)
 						}
 					}
 					instanceVal = v
@@ -4691,7 +3908,7 @@ func (vm *VM) run(minFrameCount int) error {
 					instanceVal = *ref.Ptr
 				case value.REF_PROPERTY:
 					if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-						if v, ok := inst.Fields[ref.Name]; ok {
+						if v, ok := inst.Get(ref.Name); ok {
 							instanceVal = v
 						} else {
 							instanceVal = value.NewNull()
@@ -4700,8 +3917,8 @@ func (vm *VM) run(minFrameCount int) error {
 				case value.REF_INDEX:
 					if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
 						idx := int(ref.Index.AsInt)
-						if idx >= 0 && idx < len(arr.Elements) {
-							instanceVal = arr.Elements[idx]
+						if idx >= 0 && idx < arr.Len() {
+							instanceVal = arr.At(idx)
 						}
 					}
 				}
@@ -4714,8 +3931,15 @@ func (vm *VM) run(minFrameCount int) error {
 			if !ok {
 				return vm.runtimeError(c, ip, "only instances have properties")
 			}
+			if instance.Frozen {
+				return vm.runtimeError(c, ip, "%s", value.CheckMutable(instanceVal))
+			}
 
-			instance.Fields[name] = val
+			slot := fieldSlot(cache, instance, name)
+			if slot < 0 {
+				return vm.runtimeError(c, ip, "struct %s has no field '%s'%s", instance.Struct.Name, name, didYouMean(name, fieldNames(instance)))
+			}
+			instance.Fields[slot] = val
 			vm.push(val)
 
 		case chunk.OP_SET_PROPERTY_DEREF:
@@ -4737,7 +3961,7 @@ func (vm *VM) run(minFrameCount int) error {
 					if !ok {
 						v, ok = vm.GetGlobal(ref.Name)
 						if !ok {
-							return vm.runtimeError(c, ip, "undefined global variable '%s'", ref.Name)
+							return vm.undefinedGlobal(c, ip, frame.Globals, ref.Name)
 						}
 					}
 					instanceVal = v
@@ -4747,7 +3971,7 @@ func (vm *VM) run(minFrameCount int) error {
 					instanceVal = *ref.Ptr
 				case value.REF_PROPERTY:
 					if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-						if v, ok := inst.Fields[ref.Name]; ok {
+						if v, ok := inst.Get(ref.Name); ok {
 							instanceVal = v
 						}
 					}
@@ -4763,9 +3987,9 @@ func (vm *VM) run(minFrameCount int) error {
 			}
 
 			// Get Field - EXPECTING REFERENCE
-			fieldVal, ok := instance.Fields[name]
+			fieldVal, ok := instance.Get(name)
 			if !ok {
-				return vm.runtimeError(c, ip, "undefined property '%s'", name)
+				return vm.runtimeError(c, ip, "undefined property '%s'%s", name, didYouMean(name, fieldNames(instance)))
 			}
 
 			if fieldVal.Type != value.VAL_REF {
@@ -4786,19 +4010,25 @@ func (vm *VM) run(minFrameCount int) error {
 			case value.REF_PTR:
 				*ref.Ptr = val
 			case value.REF_PROPERTY:
+				if err := value.CheckMutable(ref.Container); err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
 				if targetInst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
-					targetInst.Fields[ref.Name] = val
+					targetInst.Set(ref.Name, val)
 				} else {
 					return vm.runtimeError(c, ip, "Target is not an instance")
 				}
 			case value.REF_INDEX:
 				// ... (Dup logic) ...
+				if err := value.CheckMutable(ref.Container); err != nil {
+					return vm.runtimeError(c, ip, "%s", err)
+				}
 				if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
 					idx := int(ref.Index.AsInt)
-					if idx < 0 || idx >= len(arr.Elements) {
+					if idx < 0 || idx >= arr.Len() {
 						return vm.runtimeError(c, ip, "Index out of bounds")
 					}
-					arr.Elements[idx] = val
+					arr.Set(idx, val)
 				}
 			}
 			vm.push(val)
@@ -4817,12 +4047,151 @@ func (vm *VM) run(minFrameCount int) error {
 	}
 }
 
+// importError reports that importing the module called name at ip failed.
+func (vm *VM) importError(c *chunk.Chunk, ip int, name string, err error) error {
+	var exit *ExitError
+	if errors.As(err, &exit) {
+		return exit
+	}
+	// A runtime error in the module already traces back to here
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		return rerr
+	}
+	return vm.runtimeError(c, ip, "failed to import module '%s': %v", name, err)
+}
+
+// resolveLazy returns the module v stands in for when it is a lazyModule,
+// loading the module if this is its first use, and v itself otherwise.
+func (vm *VM) resolveLazy(frame *CallFrame, c *chunk.Chunk, ip int, v value.Value) (value.Value, error) {
+	lazy, ok := v.Obj.(*lazyModule)
+	if !ok {
+		return v, nil
+	}
+	if mod := lazy.mod.Load(); mod != nil {
+		return *mod, nil
+	}
+	frame.IP = ip // The module runs in a frame above this one
+	mod, err := vm.ImportModule(lazy.name)
+	if err != nil {
+		return v, vm.importError(c, ip, lazy.name, err)
+	}
+	lazy.mod.Store(&mod)
+	return mod, nil
+}
+
+// derefReceiver follows a reference to the value whose property is read.
+func (vm *VM) derefReceiver(frame *CallFrame, c *chunk.Chunk, ip int, v value.Value) (value.Value, error) {
+	if v.Type != value.VAL_REF {
+		return v, nil
+	}
+	ref := v.Obj.(*value.ObjRef)
+	switch ref.RefType {
+	case value.REF_GLOBAL:
+		g, ok := frame.Globals[ref.Name]
+		if !ok {
+			g, ok = vm.GetGlobal(ref.Name)
+			if !ok {
+				return v, vm.undefinedGlobal(c, ip, frame.Globals, ref.Name)
+			}
+		}
+		return g, nil
+	case value.REF_UPVALUE:
+		return *ref.Upvalue.Location, nil
+	case value.REF_PTR:
+		return *ref.Ptr, nil
+	case value.REF_PROPERTY:
+		if inst, ok := ref.Container.Obj.(*value.ObjInstance); ok {
+			if f, ok := inst.Get(ref.Name); ok {
+				return f, nil
+			}
+			return value.NewNull(), nil
+		}
+	case value.REF_INDEX:
+		if arr, ok := ref.Container.Obj.(*value.ObjArray); ok {
+			idx := int(ref.Index.AsInt)
+			if idx >= 0 && idx < arr.Len() {
+				return arr.At(idx), nil
+			}
+		}
+	}
+	return v, nil
+}
+
+// getProperty reads the field of an instance or the key of a map or
+// module.
+func (vm *VM) getProperty(frame *CallFrame, c *chunk.Chunk, ip int, instanceVal value.Value, name string) (value.Value, error) {
+	instanceVal, err := vm.derefReceiver(frame, c, ip, instanceVal)
+	if err != nil {
+		return instanceVal, err
+	}
+	if instanceVal, err = vm.resolveLazy(frame, c, ip, instanceVal); err != nil {
+		return instanceVal, err
+	}
+
+	if instanceVal.Type != value.VAL_OBJ {
+		return instanceVal, vm.runtimeError(c, ip, "only instances/maps have properties")
+	}
+
+	if instance, ok := instanceVal.Obj.(*value.ObjInstance); ok {
+		val, ok := instance.Get(name)
+		if !ok {
+			if _, isMethod := instance.Struct.Methods[name]; isMethod {
+				return val, vm.runtimeError(c, ip, "method '%s' of %s can only be called, as in x.%s()", name, instance.Struct.Name, name)
+			}
+			return val, vm.runtimeError(c, ip, "undefined property '%s'%s", name, didYouMean(name, memberNames(instance)))
+		}
+		return val, nil
+	} else if mapObj, ok := instanceVal.Obj.(*value.ObjMap); ok {
+		// Allow accessing map keys as properties (for modules)
+		val, ok := mapObj.Data[name]
+		if !ok {
+			return val, vm.runtimeError(c, ip, "undefined property '%s' in module/map%s", name, didYouMean(name, mapKeyNames(mapObj)))
+		}
+		// A module the module imported
+		return vm.resolveLazy(frame, c, ip, val)
+	} else if p, ok := instanceVal.Obj.(*pluginModule); ok {
+		return vm.pluginMethod(p, name), nil
+	} else if e, ok := instanceVal.Obj.(*value.ObjError); ok {
+		return vm.errorMember(c, ip, e, name)
+	}
+	return instanceVal, vm.runtimeError(c, ip, "only instances and maps have properties")
+}
+
+// fieldSlot finds the field called name in instance through the inline
+// cache of one property instruction, refilling the cache when the instance
+// is of another struct than last time. It returns -1 if there is no such
+// field.
+func fieldSlot(cache *atomic.Pointer[chunk.PropertyCache], instance *value.ObjInstance, name string) int {
+	if pc := cache.Load(); pc != nil && pc.Struct == instance.Struct {
+		return pc.Slot
+	}
+	slot := instance.Struct.Slot(name)
+	if slot >= 0 {
+		cache.Store(&chunk.PropertyCache{Struct: instance.Struct, Slot: slot})
+	}
+	return slot
+}
+
+// findMethod finds the method called name of an instance. A field of the
+// same name wins, as it would for a property read.
+func findMethod(receiver value.Value, name string) *value.ObjClosure {
+	instance, ok := receiver.Obj.(*value.ObjInstance)
+	if !ok || instance.Struct.Methods == nil {
+		return nil
+	}
+	if _, isField := instance.Get(name); isField {
+		return nil
+	}
+	return instance.Struct.Methods[name]
+}
+
 func (vm *VM) callValue(callee value.Value, argCount int, c *chunk.Chunk, ip int) (bool, error) {
 	if callee.Type == value.VAL_OBJ {
 		if structDef, ok := callee.Obj.(*value.ObjStruct); ok {
 			// Instantiate
 			if argCount != len(structDef.Fields) {
-				return false, vm.runtimeError(c, ip, "expected %d arguments for struct %s but got %d", len(structDef.Fields), structDef.Name, argCount)
+				return false, vm.runtimeError(c, ip, "struct %s expects %d arguments but got %d", structDef.Name, len(structDef.Fields), argCount)
 			}
 
 			instance := value.NewInstance(structDef)
@@ -4832,7 +4201,7 @@ func (vm *VM) callValue(callee value.Value, argCount int, c *chunk.Chunk, ip int
 			for i := 0; i < argCount; i++ {
 				arg := vm.peek(argCount - 1 - i)
 				fieldName := structDef.Fields[i]
-				instObj.Fields[fieldName] = arg
+				instObj.Set(fieldName, arg)
 			}
 
 			// Pop args AND callee (struct def)
@@ -4850,11 +4219,17 @@ func (vm *VM) callValue(callee value.Value, argCount int, c *chunk.Chunk, ip int
 		args := vm.stack[vm.stackTop-argCount : vm.stackTop]
 		// fmt.Printf("Calling native %s with args: %v\n", native.Name, args)
 		result := native.Fn(args)
+		if nerr, ok := result.Obj.(*value.NativeError); ok {
+			return false, vm.runtimeError(c, ip, "%s: %s", native.Name, nerr.Message)
+		}
+		if exit, ok := result.Obj.(*ExitError); ok {
+			return false, exit
+		}
 		vm.stackTop -= argCount + 1 // args + function
 		vm.push(result)
 		return true, nil
 	}
-	return false, vm.runtimeError(c, ip, "can only call functions and classes")
+	return false, vm.runtimeError(c, ip, "can only call functions and structs, got %s", value.TypeName(callee))
 }
 
 func (vm *VM) call(closure *value.ObjClosure, argCount int, c *chunk.Chunk, ip int) (bool, error) {
@@ -4863,11 +4238,11 @@ func (vm *VM) call(closure *value.ObjClosure, argCount int, c *chunk.Chunk, ip i
 	fn := closure.Function
 
 	if argCount != fn.Arity {
-		return false, vm.runtimeError(c, ip, "expected %d arguments but got %d", fn.Arity, argCount)
+		return false, vm.runtimeError(c, ip, "function '%s' expects %d arguments but got %d", fn.Name, fn.Arity, argCount)
 	}
 
 	if vm.frameCount == FramesMax {
-		return false, vm.runtimeError(c, ip, "stack overflow")
+		return false, vm.runtimeError(c, ip, "stack overflow calling '%s' (more than %d nested calls)", fn.Name, FramesMax)
 	}
 
 	// Handle Pass-by-Value (Copy) for non-ref parameters
@@ -4884,40 +4259,109 @@ func (vm *VM) call(closure *value.ObjClosure, argCount int, c *chunk.Chunk, ip i
 		}
 	}
 
-	frame := &CallFrame{
+	// Push new frame
+	frame := &vm.frames[vm.frameCount]
+	*frame = CallFrame{
 		Closure: closure,
 		IP:      0,
 		Slots:   vm.stackTop - argCount - 1, // Start of locals window (fn + args)
 		Globals: closure.Globals,
 	}
-	// Push new frame
-	vm.frames[vm.frameCount] = frame
 	vm.frameCount++
 	vm.currentFrame = frame
 	return true, nil
 }
 
+// bufferAppend appends a byte (int), bytes, string, int array or another
+// buffer to buf.
+// groupThousands inserts sep between groups of three integer digits.
+func groupThousands(num, sep string) string {
+	sign := ""
+	if strings.HasPrefix(num, "-") {
+		sign, num = "-", num[1:]
+	}
+	intPart, frac := num, ""
+	if i := strings.Index(num, "."); i >= 0 {
+		intPart, frac = num[:i], num[i:]
+	}
+	var sb strings.Builder
+	for i, ch := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteRune(ch)
+	}
+	return sign + sb.String() + frac
+}
+
+// newSetFrom builds a set from vals, skipping values that cannot be hashed.
+func newSetFrom(vals []value.Value) value.Value {
+	result := value.NewSet()
+	set := result.Obj.(*value.ObjSet)
+	for _, v := range vals {
+		set.Add(v)
+	}
+	return result
+}
+
+func setArgs(args []value.Value) (*value.ObjSet, *value.ObjSet, bool) {
+	if len(args) != 2 {
+		return nil, nil, false
+	}
+	a, okA := args[0].Obj.(*value.ObjSet)
+	b, okB := args[1].Obj.(*value.ObjSet)
+	return a, b, okA && okB
+}
+
+func bufferAppend(buf *value.ObjBuffer, item value.Value) {
+	switch item.Type {
+	case value.VAL_INT:
+		buf.Data = append(buf.Data, byte(item.AsInt))
+	case value.VAL_BYTES:
+		buf.Data = append(buf.Data, item.Obj.(string)...)
+	case value.VAL_OBJ:
+		switch o := item.Obj.(type) {
+		case string:
+			buf.Data = append(buf.Data, o...)
+		case *value.ObjBuffer:
+			buf.Data = append(buf.Data, o.Data...)
+		case *value.ObjArray:
+			for _, el := range o.Values() {
+				if el.Type == value.VAL_INT {
+					buf.Data = append(buf.Data, byte(el.AsInt))
+				}
+			}
+		}
+	}
+}
+
 func (vm *VM) copyValue(v value.Value) value.Value {
 	if v.Type != value.VAL_OBJ {
 		return v
 	}
 	switch obj := v.Obj.(type) {
 	case *value.ObjArray:
-		newElems := make([]value.Value, len(obj.Elements))
-		copy(newElems, obj.Elements)
-		return value.Value{Type: value.VAL_OBJ, Obj: &value.ObjArray{Elements: newElems}}
+		copied := obj.Slice(0, obj.Len())
+		copied.Frozen = obj.Frozen
+		return value.Value{Type: value.VAL_OBJ, Obj: copied}
 	case *value.ObjMap:
-		newData := make(map[interface{}]value.Value)
-		for k, val := range obj.Data {
-			newData[k] = val
+		newMap := &value.ObjMap{Data: make(map[interface{}]value.Value, obj.Len()), Keys: make([]interface{}, 0, obj.Len())}
+		for _, k := range obj.Keys {
+			newMap.Set(k, obj.Data[k])
 		}
-		return value.Value{Type: value.VAL_OBJ, Obj: &value.ObjMap{Data: newData}}
+		newMap.Frozen = obj.Frozen
+		return value.Value{Type: value.VAL_OBJ, Obj: newMap}
 	case *value.ObjInstance:
-		newFields := make(map[string]value.Value)
-		for k, val := range obj.Fields {
-			newFields[k] = val
-		}
-		return value.Value{Type: value.VAL_OBJ, Obj: &value.ObjInstance{Struct: obj.Struct, Fields: newFields}}
+		newFields := append([]value.Value(nil), obj.Fields...)
+		return value.Value{Type: value.VAL_OBJ, Obj: &value.ObjInstance{Struct: obj.Struct, Fields: newFields, Frozen: obj.Frozen}}
+	case *value.ObjBuffer:
+		return value.NewBuffer(append([]byte(nil), obj.Data...))
+	case *value.ObjNDArray:
+		return value.NewNDArray(obj.Array.Copy())
+	case *value.ObjSet:
+		copied := newSetFrom(obj.Values())
+		copied.Obj.(*value.ObjSet).Frozen = obj.Frozen
+		return copied
 	default:
 		return v
 	}
@@ -4934,6 +4378,12 @@ func isFalsey(v value.Value) bool {
 }
 
 func valuesEqual(a, b value.Value) bool {
+	if x, y, ok := bigIntOperands(a, b); ok {
+		return x.Cmp(y) == 0
+	}
+	if x, y, ok := decimalOperands(a, b); ok {
+		return x.Value.Cmp(y.Value) == 0
+	}
 	if a.Type == b.Type {
 		switch a.Type {
 		case value.VAL_BOOL:
@@ -4964,6 +4414,222 @@ func valuesEqual(a, b value.Value) bool {
 	return false
 }
 
+// bigIntOperands returns both operands as big integers when at least one is
+// a bigint and the other is a bigint or an int.
+// compareValues applies the ordering comparison op to a and b: numbers of
+// any kind by value, and strings byte by byte. ok is false for operands
+// that have no order. Any comparison with NaN is false.
+func compareValues(op chunk.OpCode, a, b value.Value) (result bool, ok bool) {
+	var cmp int
+	switch {
+	case a.Type == value.VAL_INT && b.Type == value.VAL_INT:
+		cmp = cmpOrdered(a.AsInt, b.AsInt)
+	case (a.Type == value.VAL_INT || a.Type == value.VAL_FLOAT) && (b.Type == value.VAL_INT || b.Type == value.VAL_FLOAT):
+		x, _ := scalarOperand(a)
+		y, _ := scalarOperand(b)
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return false, true
+		}
+		cmp = cmpOrdered(x, y)
+	default:
+		if x, y, isBig := bigIntOperands(a, b); isBig {
+			cmp = x.Cmp(y)
+		} else if x, y, isDec := decimalOperands(a, b); isDec {
+			cmp = x.Value.Cmp(y.Value)
+		} else if x, okA := a.Obj.(string); okA {
+			y, okB := b.Obj.(string)
+			if !okB {
+				return false, false
+			}
+			cmp = strings.Compare(x, y)
+		} else {
+			return false, false
+		}
+	}
+	switch op {
+	case chunk.OP_GREATER:
+		return cmp > 0, true
+	case chunk.OP_GREATER_EQUAL:
+		return cmp >= 0, true
+	case chunk.OP_LESS:
+		return cmp < 0, true
+	default:
+		return cmp <= 0, true
+	}
+}
+
+func cmpOrdered[T int64 | float64](x, y T) int {
+	if x < y {
+		return -1
+	}
+	if x > y {
+		return 1
+	}
+	return 0
+}
+
+func bigIntOperands(a, b value.Value) (*big.Int, *big.Int, bool) {
+	_, okA := a.Obj.(*value.ObjBigInt)
+	_, okB := b.Obj.(*value.ObjBigInt)
+	if !okA && !okB {
+		return nil, nil, false
+	}
+	x, okA := toBigInt(a)
+	y, okB := toBigInt(b)
+	return x, y, okA && okB
+}
+
+// checkIntArith reports integer overflow for a op b, and integer divisions
+// whose quotient is negative and inexact: those truncate toward zero
+// (-7 / 2 is -3), which is rarely what was meant.
+func checkIntArith(op byte, a, b int64) string {
+	overflow := false
+	switch op {
+	case '+':
+		r := a + b
+		overflow = (a > 0 && b > 0 && r < 0) || (a < 0 && b < 0 && r >= 0)
+	case '-':
+		r := a - b
+		overflow = (a >= 0 && b < 0 && r < 0) || (a < 0 && b > 0 && r >= 0)
+	case '*':
+		if a != 0 && b != 0 {
+			r := a * b
+			overflow = r/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64)
+		}
+	case '/':
+		if a == math.MinInt64 && b == -1 {
+			overflow = true
+		} else if b != 0 && a%b != 0 && (a < 0) != (b < 0) {
+			return fmt.Sprintf("integer division %d / %d truncates toward zero to %d (use float division if a fraction is expected)", a, b, a/b)
+		}
+	}
+	if overflow {
+		return fmt.Sprintf("integer overflow: %d %c %d", a, op, b)
+	}
+	return ""
+}
+
+// checkFloatResult reports a NaN result, or an infinite result computed
+// from finite operands.
+func checkFloatResult(op byte, a, b, result value.Value) string {
+	if result.Type != value.VAL_FLOAT {
+		return ""
+	}
+	if math.IsNaN(result.AsFloat) {
+		return fmt.Sprintf("NaN result: %s %c %s", a, op, b)
+	}
+	if math.IsInf(result.AsFloat, 0) && !isInfValue(a) && !isInfValue(b) {
+		return fmt.Sprintf("float overflow: %s %c %s", a, op, b)
+	}
+	return ""
+}
+
+func isInfValue(v value.Value) bool {
+	return v.Type == value.VAL_FLOAT && math.IsInf(v.AsFloat, 0)
+}
+
+// decimalOperands returns both operands as decimals when at least one is a
+// decimal and the other is a decimal, int or bigint. Floats are rejected:
+// mixing them in would bring back binary rounding errors.
+func decimalOperands(a, b value.Value) (*value.ObjDecimal, *value.ObjDecimal, bool) {
+	_, okA := a.Obj.(*value.ObjDecimal)
+	_, okB := b.Obj.(*value.ObjDecimal)
+	if !okA && !okB {
+		return nil, nil, false
+	}
+	x, okA := toDecimal(a)
+	y, okB := toDecimal(b)
+	return x, y, okA && okB
+}
+
+func toDecimal(v value.Value) (*value.ObjDecimal, bool) {
+	if d, ok := v.Obj.(*value.ObjDecimal); ok {
+		return d, true
+	}
+	if n, ok := toBigInt(v); ok {
+		return &value.ObjDecimal{Value: new(big.Rat).SetInt(n), Scale: 0}, true
+	}
+	return nil, false
+}
+
+// DecimalDivisionScale is the number of fractional digits kept when a
+// decimal quotient does not terminate (1/3).
+const DecimalDivisionScale = 16
+
+func decimalAdd(x, y *value.ObjDecimal) value.Value {
+	return value.NewDecimal(new(big.Rat).Add(x.Value, y.Value), maxInt(x.Scale, y.Scale))
+}
+
+func decimalSub(x, y *value.ObjDecimal) value.Value {
+	return value.NewDecimal(new(big.Rat).Sub(x.Value, y.Value), maxInt(x.Scale, y.Scale))
+}
+
+func decimalMul(x, y *value.ObjDecimal) value.Value {
+	return value.NewDecimal(new(big.Rat).Mul(x.Value, y.Value), x.Scale+y.Scale)
+}
+
+// decimalDiv keeps exact quotients and rounds the others (half-even) to
+// DecimalDivisionScale digits, never printing fewer digits than the operands.
+func decimalDiv(x, y *value.ObjDecimal) value.Value {
+	minScale := maxInt(x.Scale, y.Scale)
+	q := new(big.Rat).Quo(x.Value, y.Value)
+	if scale := value.MinScale(q, minScale); scale >= 0 && scale <= maxInt(minScale, DecimalDivisionScale) {
+		return value.NewDecimal(q, scale)
+	}
+	q, _ = value.RoundRat(q, maxInt(minScale, DecimalDivisionScale), "half_even")
+	return value.NewDecimal(q, value.MinScale(q, minScale))
+}
+
+// ndarrayArith applies op element by element when either operand is an
+// ndarray and the other is an ndarray of the same shape, an int or a float.
+// Division follows IEEE rules, so dividing by zero gives Inf or NaN.
+func ndarrayArith(op byte, a, b value.Value) (value.Value, bool, error) {
+	x, okA := a.Obj.(*value.ObjNDArray)
+	y, okB := b.Obj.(*value.ObjNDArray)
+	if okA && okB {
+		r, err := ndarray.Apply(op, x.Array, y.Array)
+		if err != nil {
+			return value.Value{}, true, err
+		}
+		return value.NewNDArray(r), true, nil
+	}
+	if s, ok := scalarOperand(b); okA && ok {
+		return value.NewNDArray(ndarray.ApplyScalar(op, x.Array, s, false)), true, nil
+	}
+	if s, ok := scalarOperand(a); okB && ok {
+		return value.NewNDArray(ndarray.ApplyScalar(op, y.Array, s, true)), true, nil
+	}
+	return value.Value{}, false, nil
+}
+
+func scalarOperand(v value.Value) (float64, bool) {
+	switch v.Type {
+	case value.VAL_INT:
+		return float64(v.AsInt), true
+	case value.VAL_FLOAT:
+		return v.AsFloat, true
+	}
+	return 0, false
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// toBigInt converts a bigint or int value.
+func toBigInt(v value.Value) (*big.Int, bool) {
+	if n, ok := v.Obj.(*value.ObjBigInt); ok {
+		return n.Value, true
+	}
+	if v.Type == value.VAL_INT {
+		return big.NewInt(v.AsInt), true
+	}
+	return nil, false
+}
+
 func (vm *VM) readConstant() value.Value {
 	// Assumes 1 byte operand for constant index
 	index := vm.chunk.Code[vm.ip]
@@ -4971,10 +4637,12 @@ func (vm *VM) readConstant() value.Value {
 	return vm.chunk.Constants[index]
 }
 
+// stackSlack is the room run keeps free on the stack before each
+// instruction; no single instruction pushes more than this, so push never
+// has to check for overflow.
+const stackSlack = 8
+
 func (vm *VM) push(v value.Value) {
-	if vm.stackTop >= StackMax {
-		panic("Stack overflow")
-	}
 	vm.stack[vm.stackTop] = v
 	vm.stackTop++
 }
@@ -4986,9 +4654,40 @@ func (vm *VM) pop() value.Value {
 	return val
 }
 
+// errModuleNotFound is returned by loadScriptModule when no source file,
+// directory or embedded stdlib module matches the name.
+var errModuleNotFound = errors.New("module not found")
+
+// loadModule loads the script module name and adds the members of the
+// native module of the same name that the script does not define. A
+// registered native module needs no script counterpart.
 func (vm *VM) loadModule(name string) (value.Value, error) {
-	// Convert dot notation to path path separator
-	pathName := strings.ReplaceAll(name, ".", string(filepath.Separator))
+	natives, hasNatives := vm.nativeModule(name)
+	mod, err := vm.loadScriptModule(name)
+	if !hasNatives {
+		return mod, err
+	}
+	if errors.Is(err, errModuleNotFound) {
+		return value.Value{Type: value.VAL_OBJ, Obj: natives}, nil
+	}
+	if err != nil {
+		return mod, err
+	}
+	if m, ok := mod.Obj.(*value.ObjMap); ok {
+		for _, k := range natives.Keys {
+			if _, exists := m.Data[k]; !exists {
+				m.Set(k, natives.Data[k])
+			}
+		}
+	}
+	return mod, nil
+}
+
+func (vm *VM) loadScriptModule(name string) (value.Value, error) {
+	// Convert dot notation to path path separator, mapping packages from
+	// noxy.mod to their directory under noxy_libs
+	configs, _ := vm.projectModFiles()
+	pathName := modulePathName(name, configs)
 
 	// Search paths candidates (File .nx OR Directory)
 	// We prefer file over directory if both exist? usually explicit file wins.
@@ -5002,6 +4701,11 @@ func (vm *VM) loadModule(name string) (value.Value, error) {
 	checkLocations := func(suffix string) bool {
 		candidates := []string{}
 
+		// Local replacements from noxy.mod win over everything else
+		if replaced, ok := vm.replacedModulePath(suffix); ok {
+			candidates = append(candidates, replaced)
+		}
+
 		// 0. NOXY_PATH Environment Variable
 		noxyPath := os.Getenv("NOXY_PATH")
 		if noxyPath != "" {
@@ -5029,6 +4733,11 @@ func (vm *VM) loadModule(name string) (value.Value, error) {
 		candidates = append(candidates, filepath.Join("stdlib", suffix))
 		candidates = append(candidates, suffix)
 
+		// 3. Dependencies of a project that is not vendored come from the cache
+		if cached, ok := vm.cachedModulePath(suffix); ok {
+			candidates = append(candidates, cached)
+		}
+
 		for _, p := range candidates {
 			// fmt.Printf("Checking path: %s\n", p)
 			info, err := os.Stat(p)
@@ -5064,17 +4773,15 @@ func (vm *VM) loadModule(name string) (value.Value, error) {
 		content, err := stdlib.FS.ReadFile(embedPath)
 		if err == nil {
 			// Found in embedded stdlib!
-			l := lexer.New(string(content))
-			p := parser.New(l)
-			prog := p.ParseProgram()
-			if len(p.Errors()) > 0 {
-				return value.NewNull(), fmt.Errorf("parse error in embedded module %s: %v", name, p.Errors())
-			}
-			c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), name)
-			chunk, _, err := c.Compile(prog)
+			chunk, err := vm.compileModule(string(content), name, "embedded module "+name)
 			if err != nil {
 				return value.NewNull(), err
 			}
+			if vm.Config.PreloadModules {
+				if err := vm.preloadImports(chunk); err != nil {
+					return value.NewNull(), err
+				}
+			}
 			moduleGlobals := make(map[string]value.Value)
 			modFn := &value.ObjFunction{
 				Name:    name,
@@ -5096,7 +4803,7 @@ func (vm *VM) loadModule(name string) (value.Value, error) {
 			return value.NewMapWithData(moduleGlobals), nil
 		}
 
-		return value.NewNull(), fmt.Errorf("module not found: %s", name)
+		return value.NewNull(), fmt.Errorf("%w: %s", errModuleNotFound, name)
 	}
 
 	// Case 1: Directory Import (Implicit Module)
@@ -5105,6 +4812,10 @@ func (vm *VM) loadModule(name string) (value.Value, error) {
 		// If found, load that file directly instead of treating dir as a map of files.
 		// Removed mod.nx and lib.nx to avoid shadowing directory contents when those files exist as children.
 		baseName := filepath.Base(path)
+		// Cached packages live in <repo>@<version>
+		if i := strings.Index(baseName, "@"); i > 0 {
+			baseName = baseName[:i]
+		}
 		candidates := []string{baseName + ".nx", "main.nx"}
 
 		for _, cand := range candidates {
@@ -5157,19 +4868,17 @@ FileImport:
 		return value.NewNull(), err
 	}
 
-	l := lexer.New(string(content))
-	p := parser.New(l)
-	prog := p.ParseProgram()
-	if len(p.Errors()) > 0 {
-		return value.NewNull(), fmt.Errorf("parse error in module %s: %v", name, p.Errors())
-	}
-
-	c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), path)
-	chunk, _, err := c.Compile(prog)
+	chunk, err := vm.compileModule(string(content), path, "module "+name)
 	if err != nil {
 		return value.NewNull(), err
 	}
 
+	if vm.Config.PreloadModules {
+		if err := vm.preloadImports(chunk); err != nil {
+			return value.NewNull(), err
+		}
+	}
+
 	// Create isolated Module Globals
 	moduleGlobals := make(map[string]value.Value)
 
@@ -5204,6 +4913,59 @@ FileImport:
 	return value.NewMapWithData(moduleGlobals), nil
 }
 
+// compileModule compiles a module's source. With Config.ModuleCache set,
+// the compiled chunk is stored under a hash of the source, file name, VM
+// version and strict setting and reused until the source changes.
+func (vm *VM) compileModule(source, fileName, label string) (*chunk.Chunk, error) {
+	var cachePath string
+	if vm.Config.ModuleCache != "" {
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00%d\x00%s\x00%t\x00", version.Version, chunk.FormatVersion, fileName, vm.Config.Strict)
+		io.WriteString(h, source)
+		cachePath = filepath.Join(vm.Config.ModuleCache, hex.EncodeToString(h.Sum(nil))+".nxc")
+
+		if data, err := os.ReadFile(cachePath); err == nil {
+			if c, err := chunk.Deserialize(data); err == nil {
+				return c, nil
+			}
+		}
+	}
+
+	l := lexer.New(source)
+	p := parser.New(l)
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, fmt.Errorf("parse error in %s: %v", label, p.Errors())
+	}
+
+	c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), fileName)
+	c.Strict = vm.Config.Strict
+	compiled, _, err := c.Compile(prog)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cache is an optimization: failing to write it is not an error.
+	// Chunks with embedded files are not cached, as the key only covers the
+	// source.
+	if cachePath != "" && len(c.Embedded()) == 0 {
+		if data, err := compiled.Serialize(); err == nil {
+			if os.MkdirAll(vm.Config.ModuleCache, 0755) == nil {
+				// Write then rename, so concurrent imports never read a
+				// partial file
+				if tmp, err := os.CreateTemp(vm.Config.ModuleCache, "*.tmp"); err == nil {
+					_, werr := tmp.Write(data)
+					if tmp.Close() == nil && werr == nil {
+						os.Rename(tmp.Name(), cachePath)
+					}
+					os.Remove(tmp.Name())
+				}
+			}
+		}
+	}
+	return compiled, nil
+}
+
 func (vm *VM) peek(distance int) value.Value {
 	return vm.stack[vm.stackTop-1-distance]
 }
@@ -5252,3 +5014,28 @@ func (vm *VM) closeUpvalue(slot *value.Value) {
 		curr = curr.Next
 	}
 }
+
+// sendOn sends v on ch and reports whether it could: Go cannot tell that a
+// channel is closed before sending on it (another thread may close it in
+// between), so the send-on-closed panic is turned into false here.
+func sendOn(ch chan value.Value, v value.Value) (sent bool) {
+	defer func() {
+		if recover() != nil {
+			sent = false
+		}
+	}()
+	ch <- v
+	return true
+}
+
+// selectOn runs reflect.Select, reporting sent == false instead of
+// panicking when the chosen send case's channel is closed.
+func selectOn(cases []reflect.SelectCase) (chosen int, recv reflect.Value, recvOK bool, sent bool) {
+	defer func() {
+		if recover() != nil {
+			sent = false
+		}
+	}()
+	chosen, recv, recvOK = reflect.Select(cases)
+	return chosen, recv, recvOK, true
+}
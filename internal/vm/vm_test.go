@@ -1,12 +1,34 @@
 package vm
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"noxy-vm/internal/ast"
+	"noxy-vm/internal/chunk"
 	"noxy-vm/internal/compiler"
 	"noxy-vm/internal/lexer"
 	"noxy-vm/internal/parser"
+	"noxy-vm/internal/pkgmanager"
+	"noxy-vm/internal/plugin"
 	"noxy-vm/internal/value"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -114,5 +136,3034 @@ func testExpectedObject(t *testing.T, expected interface{}, actual value.Value)
 		if actual.AsBool != expectedVal {
 			t.Errorf("object has wrong value. got=%t, want=%t", actual.AsBool, expectedVal)
 		}
+	case string:
+		if actual.String() != expectedVal {
+			t.Errorf("object has wrong value. got=%q, want=%q", actual.String(), expectedVal)
+		}
+	}
+}
+
+func TestByteBuffer(t *testing.T) {
+	tests := []vmTestCase{
+		{`length(buffer_new(16))`, 0},
+		{`buffer_to_string(buffer_append(buffer_from("ab"), 99))`, "abc"},
+		{`buffer_to_string(buffer_append(buffer_from(b"x"), buffer_from([121, 122])))`, "xyz"},
+		{`buffer_from([1, 2, 3])[2]`, 3},
+		{`length(slice(buffer_from("hello"), 1, 3))`, 2},
+		{`buffer_to_bytes(buffer_from("hi"))`, `b"hi"`},
+		{`buffer_set(buffer_from("a"), 0, 98)`, true},
+		{`buffer_set(buffer_from("a"), 1, 98)`, false},
+		{`to_str(buffer_from("raw"))`, "raw"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestModulePathName(t *testing.T) {
+	config := pkgmanager.NewModuleConfig()
+	config.Require["github.com/user/noxy-json"] = "v1.0.0"
+	config.Aliases["json"] = "github.com/user/noxy-json"
+	configs := []*pkgmanager.ModuleConfig{config}
+
+	sep := string(filepath.Separator)
+	tests := map[string]string{
+		"json":                               "github_com/user/noxy-json",
+		"json.util.math":                     "github_com/user/noxy-json/util/math",
+		"github_com.user.noxy_json":          "github_com/user/noxy-json",
+		"github_com.user.noxy_json.encoding": "github_com/user/noxy-json/encoding",
+		"github_com.user.noxy_jsonx":         "github_com/user/noxy_jsonx",
+		"time":                               "time",
+	}
+	for name, want := range tests {
+		want = strings.ReplaceAll(want, "/", sep)
+		if got := modulePathName(name, configs); got != want {
+			t.Errorf("modulePathName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestImportFromPackageCache(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("NOXY_CACHE", filepath.Join(dir, "cache"))
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "lib.nx"), []byte("func answer() -> int\n    return 42\nend\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := pkgmanager.HashDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := pkgmanager.CachePath("example.com/user/lib", "v1.0.0", hash)
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(src, cached); err != nil {
+		t.Fatal(err)
+	}
+
+	project := filepath.Join(dir, "app")
+	if err := os.MkdirAll(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(project, "noxy.mod"), []byte("module app\nrequire example.com/user/lib v1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func() (string, error) {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New("use example_com.user.lib.lib as lib\ntest_report(lib.answer())")).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		machine := NewWithConfig(VMConfig{RootPath: project})
+		var report string
+		machine.DefineNative("test_report", func(args []value.Value) value.Value {
+			report = args[0].String()
+			return value.NewNull()
+		})
+		return report, machine.Interpret(bytecode)
+	}
+
+	// Without a noxy.sum entry the cached copy can't be verified
+	if _, err := run(); err == nil {
+		t.Errorf("imported a cached package that noxy.sum does not list")
+	}
+
+	sum := "example.com/user/lib v1.0.0 " + hash + "\n"
+	if err := os.WriteFile(filepath.Join(project, pkgmanager.SumFileName), []byte(sum), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := run(); err != nil || got != "42" {
+		t.Errorf("import from the cache returned %q (%v)", got, err)
+	}
+
+	// A vendored project never looks at the cache
+	vendorList := filepath.Join(project, "noxy_libs", pkgmanager.VendorListName)
+	if err := os.MkdirAll(filepath.Dir(vendorList), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(vendorList, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(); err == nil {
+		t.Errorf("vendored project imported a package from the cache")
+	}
+}
+
+func TestModuleCache(t *testing.T) {
+	dir := t.TempDir()
+	modSrc := "struct Point\n    x: int\n    y: int\nend\n\nfunc make_adder(n: int) -> func\n    func add(x: int) -> int\n        return x + n\n    end\n    return add\nend\n\nfunc scale(p: Point, k: float) -> float\n    return (p.x + p.y) * k\nend\n"
+	if err := os.WriteFile(filepath.Join(dir, "geo.nx"), []byte(modSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := filepath.Join(dir, ModuleCacheDir)
+
+	run := func() value.Value {
+		l := lexer.New("use geo\nlet add: func = geo.make_adder(10)\ntest_report(f\"{add(7)} {geo.scale(geo.Point(1, 2), 2.0)}\")")
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("parser errors: %v", p.Errors())
+		}
+		bytecode, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		machine := NewWithConfig(VMConfig{RootPath: dir, ModuleCache: cache})
+		var captured value.Value
+		machine.DefineNative("test_report", func(args []value.Value) value.Value {
+			captured = args[0]
+			return value.NewNull()
+		})
+		if err := machine.Interpret(bytecode); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		return captured
+	}
+
+	first := run()
+	entries, err := os.ReadDir(cache)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one cached module, got %v (%v)", entries, err)
+	}
+	if first.String() != "17 6.0" {
+		t.Fatalf("unexpected result %s", first)
+	}
+	second := run()
+	if first.String() != second.String() {
+		t.Errorf("cached module result %s differs from %s", second, first)
+	}
+}
+
+func TestSerializedScript(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "util.nx"), []byte("func twice(n: int) -> int\n    return n * 2\nend\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := `use util
+struct Point
+    x: float
+    y: float
+
+    func move(self, dx: float)
+        self.x = self.x + dx
+    end
+end
+
+func make_adder(n: int) -> func(int) -> int
+    func add(m: int) -> int
+        return m + n
+    end
+    return add
+end
+
+let p: Point = Point(1.5, 2.0)
+p.move(1.0)
+let k: int = 5
+let add: func(int) -> int = make_adder(10)
+test_report(f"{p.x} {util.twice(add(k))} {[1, 2][0]} {null}")
+let a: int[] = [1]
+let v: int = a[5]`
+	bytecode, _, err := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), "main.nx").Compile(parser.New(lexer.New(src)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := bytecode.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chunk.IsSerialized(data) || chunk.IsSerialized([]byte(src)) {
+		t.Fatal("IsSerialized does not tell compiled chunks from source")
+	}
+	bytecode, err = chunk.Deserialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine := NewWithConfig(VMConfig{RootPath: dir})
+	var got value.Value
+	machine.DefineNative("test_report", func(args []value.Value) value.Value {
+		got = args[0]
+		return value.NewNull()
+	})
+	err = machine.Interpret(bytecode)
+	testExpectedObject(t, "2.5 30 1 null", got)
+	// Runtime errors still point into the source file
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.File != "main.nx" || rtErr.Line != 24 {
+		t.Errorf("expected a runtime error at main.nx:24, got %v", err)
+	}
+}
+
+func TestConcurrentImport(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "slow.nx"), []byte("let id: int = loaded()\ntime_sleep(20)\n"), 0644)
+	// Selecting members loads a module at once
+	os.WriteFile(filepath.Join(dir, "a.nx"), []byte("use b select *\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.nx"), []byte("use a select *\n"), 0644)
+
+	machine := NewWithConfig(VMConfig{RootPath: dir})
+	var loads atomic.Int64
+	machine.DefineNative("loaded", func(args []value.Value) value.Value {
+		return value.NewInt(loads.Add(1))
+	})
+
+	// Every thread gets the module loaded by the first one
+	mods := make([]value.Value, 8)
+	var wg sync.WaitGroup
+	for i := range mods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mod, err := NewWithShared(machine.shared, machine.Config).ImportModule("slow")
+			if err != nil {
+				t.Error(err)
+			}
+			mods[i] = mod
+		}(i)
+	}
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("module top-level code ran %d times", n)
+	}
+	for _, mod := range mods[1:] {
+		if mod.Obj != mods[0].Obj {
+			t.Fatal("threads got different module objects")
+		}
+	}
+
+	_, err := machine.ImportModule("a")
+	if err == nil || !strings.Contains(err.Error(), "import cycle: 'a' is imported while it is still being loaded") {
+		t.Errorf("expected an import cycle error, got %v", err)
+	}
+}
+
+func TestLazyImport(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "greet.nx"), []byte("mark(\"greet\")\nfunc hi() -> string\n    return \"hi\"\nend\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "outer.nx"), []byte("mark(\"outer\")\nuse greet\nfunc relay() -> string\n    return greet.hi()\nend\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "pkg"), 0755)
+	os.WriteFile(filepath.Join(dir, "pkg", "sub.nx"), []byte("mark(\"pkg.sub\")\nlet v: int = 7\n"), 0644)
+
+	var marks []string
+	var report string
+	run := func(src string, cfg VMConfig) (*VM, error) {
+		program := parser.New(lexer.New(src)).ParseProgram()
+		bytecode, _, err := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), "main.nx").Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		marks, report = nil, ""
+		machine := NewWithConfig(cfg)
+		machine.DefineNative("mark", func(args []value.Value) value.Value {
+			marks = append(marks, args[0].String())
+			return value.NewNull()
+		})
+		machine.DefineNative("test_report", func(args []value.Value) value.Value {
+			report = args[0].String()
+			return value.NewNull()
+		})
+		return machine, machine.Interpret(bytecode)
+	}
+
+	// Modules load on first use, including one reached through another
+	// module's members
+	src := "use greet\nuse outer as o\nuse pkg.sub\nmark(\"main\")\ntest_report(f\"{o.relay()} {o.greet.hi()} {sub.v}\")"
+	if _, err := run(src, VMConfig{RootPath: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if report != "hi hi 7" || fmt.Sprint(marks) != "[main outer greet pkg.sub]" {
+		t.Errorf("lazy: report %q, load order %v", report, marks)
+	}
+
+	// With PreloadModules everything loads before the first statement,
+	// also when the modules come from the cache
+	cfg := VMConfig{RootPath: dir, PreloadModules: true, ModuleCache: filepath.Join(dir, ModuleCacheDir)}
+	for range 2 {
+		if _, err := run(src, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if report != "hi hi 7" || fmt.Sprint(marks) != "[greet outer pkg.sub main]" {
+			t.Errorf("preload: report %q, load order %v", report, marks)
+		}
+	}
+
+	// A missing module is reported where it is first used, or at its use
+	// statement before anything runs when preloading
+	src = "use nosuch\nmark(\"main\")\nlet x: any = nosuch.f"
+	for _, tc := range []struct {
+		preload bool
+		want    string
+		marks   string
+	}{
+		{false, "[main.nx:line 3] failed to import module 'nosuch'", "[main]"},
+		{true, "[main.nx:line 1] failed to import module 'nosuch'", "[]"},
+	} {
+		_, err := run(src, VMConfig{RootPath: dir, PreloadModules: tc.preload})
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) || fmt.Sprint(marks) != tc.marks {
+			t.Errorf("preload %t: error %v, marks %v", tc.preload, err, marks)
+		}
+	}
+
+	// Stubbing a member loads the module first
+	machine, err := run("use greet", VMConfig{RootPath: dir})
+	if err != nil || len(marks) != 0 {
+		t.Fatalf("use alone loaded %v (%v)", marks, err)
+	}
+	stubbed := value.NewNative("hi", func(args []value.Value) value.Value { return value.NewString("stubbed") })
+	if err := machine.Stub("greet.hi", stubbed); err != nil {
+		t.Fatal(err)
+	}
+	bytecode, _, err := compiler.New().Compile(parser.New(lexer.New("test_report(greet.hi())")).ParseProgram())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := machine.Interpret(bytecode); err != nil || report != "stubbed" {
+		t.Errorf("stubbed member returned %q (%v)", report, err)
+	}
+}
+
+func TestUsePlugin(t *testing.T) {
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req plugin.PluginRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(plugin.PluginResponse{Result: map[string]interface{}{"method": req.Method, "params": req.Params}})
+	}))
+	defer server.Close()
+
+	// A plugin named in noxy.mod is reached under its name
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "noxy.mod"), []byte("module app\n\nplugin dyn "+server.URL+"\n"), 0644)
+
+	src := fmt.Sprintf(`use plugin "%s" as echo
+use plugin "dyn" as ddb
+let r: any = echo.put_item("users", {"id": 1})
+let put: func = ddb.get_item
+test_report(f"{r[\"method\"]} {r[\"params\"]} {put(\"k\")[\"method\"]} {ddb}")`, server.URL)
+	captured := runVmProgram(t, src, VMConfig{RootPath: dir})
+	testExpectedObject(t, `put_item ["users", {"id": 1}] get_item <plugin dyn>`, captured)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("plugin got %d requests, want 2", n)
+	}
+
+	// A plugin that cannot be started fails at its use statement
+	program := parser.New(lexer.New("use plugin \"nosuch\"\nnosuch.ping()")).ParseProgram()
+	c, _, err := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), "main.nx").Compile(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewWithConfig(VMConfig{RootPath: dir, WorkDir: dir}).Interpret(c)
+	if err == nil || err.Error() != "[main.nx:line 1] failed to load plugin 'nosuch': command not found: noxy-plugin-nosuch" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.nx")
+	os.WriteFile(lib, []byte("func boom() -> int\n    let a: any = null\n    return a + 1\nend\nlet x: int = boom()\n"), 0644)
+
+	src := `func inner(x: int) -> int
+    let a: any = "s"
+    return a - x
+end
+
+func outer(n: int) -> int
+    let f: func = func(y: int) -> int
+        return inner(y)
+    end
+    return f(n)
+end
+
+outer(1)`
+	tests := []struct {
+		src   string
+		stack []StackEntry
+		want  string
+	}{
+		{src, []StackEntry{
+			{"inner", "main.nx", 3},
+			{"anonymous", "main.nx", 8},
+			{"outer", "main.nx", 10},
+			{"script", "main.nx", 13},
+		}, "[main.nx:line 3] operands must be numbers\nStack trace (most recent call first):\n    at inner (main.nx:3)\n"},
+		// A module's error traces through its top level to its first use
+		{"let n: int = 1\nuse lib\nlet y: any = lib.x", []StackEntry{
+			{"boom", lib, 3},
+			{"lib", lib, 5},
+			{"script", "main.nx", 3},
+		}, "[" + lib + ":line 3] "},
+		// Lines come from the expression, not the statement it is in
+		{"let xs: any[] = [\n    1,\n    [1][3]\n]", []StackEntry{{"script", "main.nx", 3}}, "[main.nx:line 3] "},
+		// An error at the top level has no trace
+		{"let a: any = [1]\nlet b: int = a[5]", []StackEntry{{"script", "main.nx", 2}}, "[main.nx:line 2] array index out of bounds"},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), "main.nx").Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewWithConfig(VMConfig{RootPath: dir}).Interpret(c)
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) {
+			t.Fatalf("%s: got %v, want a RuntimeError", tc.src, err)
+		}
+		if fmt.Sprint(rerr.Stack) != fmt.Sprint(tc.stack) {
+			t.Errorf("%s: stack = %v, want %v", tc.src, rerr.Stack, tc.stack)
+		}
+		if !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("%s: error = %q, want it to start with %q", tc.src, err, tc.want)
+		}
+		if len(tc.stack) == 1 && strings.Contains(err.Error(), "Stack trace") {
+			t.Errorf("%s: top-level error has a trace: %q", tc.src, err)
+		}
+	}
+}
+
+func TestPluginRegistry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result": "pong"}`))
+	}))
+	defer server.Close()
+
+	src := fmt.Sprintf(`use sys
+test_report(f"{sys.load_plugin(\"echo\", \"%s\")} {echo_request(\"ping\")}")`, server.URL)
+	a := NewWithConfig(VMConfig{})
+	b := NewWithConfig(VMConfig{})
+	var got []string
+	for _, machine := range []*VM{a, b} {
+		machine.DefineNative("test_report", func(args []value.Value) value.Value {
+			got = append(got, args[0].String())
+			return value.NewNull()
+		})
+	}
+	c, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Interpret(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plugins belong to the VM that loaded them
+	if _, ok := b.shared.Plugins.Get("echo"); ok {
+		t.Error("plugin loaded by one VM is visible to another")
+	}
+	a.Close()
+	if _, ok := a.shared.Plugins.Get("echo"); ok {
+		t.Error("Close left the plugin loaded")
+	}
+
+	// After Reset the plugin can be loaded again
+	a.Reset()
+	if err := a.Interpret(c); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "true pong,true pong" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestStringBuilder(t *testing.T) {
+	tests := []vmTestCase{
+		{`sb_to_string(sb_append(sb_new(), "a", 1, true, b"!"))`, "a1true!"},
+		{`sb_len(sb_append(sb_new(8), "héllo"))`, 6},
+		{`sb_to_string(sb_new())`, ""},
+	}
+
+	runVmTests(t, tests)
+}
+
+func compileBenchProgram(b *testing.B, src string) *chunk.Chunk {
+	l := lexer.New(src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		b.Fatalf("parser errors: %v", p.Errors())
+	}
+	bytecode, _, err := compiler.New().Compile(program)
+	if err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	return bytecode
+}
+
+const benchPieces = 5000
+
+// BenchmarkStringConcat builds a string with s = s + part, which copies the
+// whole string on every iteration.
+func BenchmarkStringConcat(b *testing.B) {
+	src := fmt.Sprintf(`
+let s: string = ""
+let i: int = 0
+while i < %d do
+    s = s + "piece "
+    i = i + 1
+end
+`, benchPieces)
+	bytecode := compileBenchProgram(b, src)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := New().Interpret(bytecode); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStringBuilder builds the same string with sb_append.
+func BenchmarkStringBuilder(b *testing.B) {
+	src := fmt.Sprintf(`
+let sb: string_builder = sb_new()
+let i: int = 0
+while i < %d do
+    sb_append(sb, "piece ")
+    i = i + 1
+end
+let s: string = sb_to_string(sb)
+`, benchPieces)
+	bytecode := compileBenchProgram(b, src)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := New().Interpret(bytecode); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFib makes about 30,000 calls that each do little work, so it
+// mostly measures the cost of a call and return.
+func BenchmarkFib(b *testing.B) {
+	bytecode := compileBenchProgram(b, `
+func fib(n: int) -> int
+    if n < 2 then
+        return n
+    end
+    return fib(n - 1) + fib(n - 2)
+end
+let r: int = fib(20)
+`)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := New().Interpret(bytecode); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTreeRecursion builds and walks a binary tree of depth 12,
+// mixing calls with allocation.
+func BenchmarkTreeRecursion(b *testing.B) {
+	bytecode := compileBenchProgram(b, `
+struct Node
+    left: any
+    right: any
+end
+func build(depth: int) -> Node
+    if depth == 0 then
+        return Node(null, null)
+    end
+    return Node(build(depth - 1), build(depth - 1))
+end
+func count(node: any) -> int
+    if node == null then
+        return 0
+    end
+    return 1 + count(node.left) + count(node.right)
+end
+let r: int = count(build(12))
+`)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := New().Interpret(bytecode); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStructMapConversion(t *testing.T) {
+	dir := t.TempDir()
+	modSrc := "struct Address\n    city: string\nend\n\nstruct Person\n    name: string\n    age: int\n    home: Address\n    past: Address[]\n    label: func(int) -> string[]\nend\n"
+	if err := os.WriteFile(filepath.Join(dir, "people.nx"), []byte(modSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := `use people
+let m: map[string, any] = {"name": "Ana", "age": 30.0, "home": {"city": "Recife"}, "past": [{"city": "Olinda"}]}
+let p: people.Person = from_map(people.Person, m)
+let back: map[string, any] = to_map(p)
+test_report(f"{p.age} {p.home.city} {p.past[0].city} {back[\"home\"][\"city\"]} {from_map(people.Person, {\"age\": \"x\"})} {from_map(people.Person, {}).name == \"\"} {p.label == null}")`
+	captured := runVmProgram(t, src, VMConfig{RootPath: dir})
+	testExpectedObject(t, "30 Recife Olinda Recife null true true", captured)
+}
+
+// runVmProgram runs a whole program and returns the value it passed to
+// test_report.
+func runVmProgram(t *testing.T, src string, config VMConfig) value.Value {
+	t.Helper()
+	l := lexer.New(src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	bytecode, _, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	machine := NewWithConfig(config)
+	var captured value.Value
+	machine.DefineNative("test_report", func(args []value.Value) value.Value {
+		captured = args[0]
+		return value.NewNull()
+	})
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return captured
+}
+
+func TestMapInsertionOrder(t *testing.T) {
+	tests := []vmTestCase{
+		{`{"zeta": 1, "alpha": 2, "mid": 3}`, `{"zeta": 1, "alpha": 2, "mid": 3}`},
+		{`{3: "c", 1: "a", 2: "b"}`, `{3: "c", 1: "a", 2: "b"}`},
+		{`keys({"b": 1, "a": 2, "c": 3})`, `["b", "a", "c"]`},
+		{`{"a": 1, "a": 2}`, `{"a": 2}`},
+		{`json_parse("{\"y\": 1, \"x\": 2}")`, `{"x": 2, "y": 1}`},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSet(t *testing.T) {
+	tests := []vmTestCase{
+		{`set_new([3, 1, 3, 2])`, "set{3, 1, 2}"},
+		{`length(set_new(["a", "b", "a"]))`, 2},
+		{`set_add(set_new([1]), 1)`, false},
+		{`set_add(set_new([1]), 2)`, true},
+		{`set_contains(set_new(["x"]), "x")`, true},
+		{`set_remove(set_new([1]), 2)`, false},
+		{`set_union(set_new([1, 2]), set_new([2, 3]))`, "set{1, 2, 3}"},
+		{`set_intersection(set_new([1, 2, 3]), set_new([3, 2]))`, "set{2, 3}"},
+		{`set_difference(set_new([1, 2, 3]), set_new([2]))`, "set{1, 3}"},
+		{`set_to_array(set_new([2, 1]))`, "[2, 1]"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBigInt(t *testing.T) {
+	tests := []vmTestCase{
+		{`bigint(9223372036854775807) + 1`, "9223372036854775808"},
+		{`bigint("123456789012345678901234567890") * 10`, "1234567890123456789012345678900"},
+		{`bigint_pow(bigint(2), 64) - 1`, "18446744073709551615"},
+		{`bigint_pow(3, 200, 1000)`, "1"},
+		{`bigint(-7) / 2`, "-3"},
+		{`bigint(-7) % 2`, "-1"},
+		{`bigint(5) > 4`, true},
+		{`bigint("0xff") == 255`, true},
+		{`to_int(bigint(12)) + 1`, 13},
+		{`bigint("12x")`, "null"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []vmTestCase{
+		{`decimal("0.1") + decimal("0.2") == decimal("0.3")`, true},
+		{`decimal("19.99") * 3`, "59.97"},
+		{`decimal("10.00") / 4`, "2.50"},
+		{`decimal(2) / 3`, "0.6666666666666667"},
+		{`decimal("1.10") - decimal("0.1")`, "1.00"},
+		{`decimal(0.1)`, "0.1"},
+		{`decimal_round(decimal("2.345"), 2)`, "2.34"},
+		{`decimal_round(decimal("2.345"), 2, "half_up")`, "2.35"},
+		{`decimal_round(decimal("-1.21"), 1, "floor")`, "-1.3"},
+		{`decimal_round(decimal("1.21"), 1, "up")`, "1.3"},
+		{`decimal_format(decimal("1234567.5"), 2, ",")`, "1,234,567.50"},
+		{`decimal("12.5") > 12`, true},
+		{`decimal("not a number")`, "null"},
+		{`currency_format(decimal("1234.565"), "BRL", "pt-BR")`, "R$ 1.234,56"},
+		{`currency_format(decimal("-1234.5"), "USD")`, "-$1,234.50"},
+		{`currency_format(decimal("99.9"), "USD", "pt_BR")`, "US$ 99,90"},
+		{`currency_format(1234567, "EUR", "de-DE")`, "1.234.567,00 €"},
+		{`currency_format(decimal("1500.5"), "JPY", "ja-JP")`, "¥1,500"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatPrinting(t *testing.T) {
+	tests := []vmTestCase{
+		{`1.5`, "1.5"},
+		{`2.0`, "2.0"},
+		{`0.1 + 0.2`, "0.30000000000000004"},
+		{`1.0 / 3.0`, "0.3333333333333333"},
+		{`-0.25`, "-0.25"},
+		{`0.00001`, "1e-05"},
+		{`100000000000000000000.0`, "1e+20"},
+		{`to_str(3.0)`, "3.0"},
+		{`float_format(3.14159, 2)`, "3.14"},
+		{`float_format(2.5, 0)`, "2"},
+		{`float_format(7, 3)`, "7.000"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestComparison(t *testing.T) {
+	tests := []vmTestCase{
+		{`[1.5 < 2.0, 2.5 > 2.0, 1.5 <= 1.5, 1.5 >= 2.0]`, "[true, true, true, false]"},
+		{`[1 < 1.5, 2.0 > 1, 2 >= 2.0, 3.0 <= 2]`, "[true, true, true, false]"},
+		{`[3 > 2, 2 >= 3, 2 <= 2]`, "[true, false, true]"},
+		{`["apple" < "banana", "b" <= "a", "abc" > "ab", "" >= ""]`, "[true, false, true, true]"},
+		{`["Z" < "a", "é" > "z"]`, "[true, true]"},
+	}
+	runVmTests(t, tests)
+
+	// Every ordering comparison with NaN is false, including >= and <=
+	got := runVmProgram(t, `let n: float = to_float("nan")
+test_report([n < 1.0, n > 1.0, n <= 1.0, n >= 1.0, n >= n])`, VMConfig{})
+	testExpectedObject(t, "[false, false, false, false, false]", got)
+
+	for _, src := range []string{`1 < "a"`, `"a" >= 1.0`, `[1] < [2]`} {
+		program := parser.New(lexer.New(src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), "operands must be two numbers or two strings") {
+			t.Errorf("%s: got %v, want a comparison error", src, err)
+		}
+	}
+}
+
+func TestCommonValues(t *testing.T) {
+	tests := []vmTestCase{
+		{`["héllo"[0], "héllo"[1], "héllo"[4], "日本"[1]]`, `["h", "é", "o", "本"]`},
+		{`"abc"[1] == "b"`, true},
+		{`{300: "a", -5: "b", 70000: "c"}[300]`, "a"},
+		{`{300: "a", -5: "b", 70000: "c"}[-5]`, "b"},
+		{`{300: "a", -5: "b", 70000: "c"}[70000.0]`, "c"},
+	}
+	runVmTests(t, tests)
+
+	got := runVmProgram(t, `use strings select char_at, from_char_code
+test_report([char_at("héllo", 1), char_at("abc", 3), char_at("abc", -1), from_char_code(65), from_char_code(233)])`, VMConfig{})
+	testExpectedObject(t, `["é", "", "", "A", "é"]`, got)
+
+	for _, src := range []string{`"héllo"[5]`, `"abc"[-1]`} {
+		program := parser.New(lexer.New(src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), "string index out of bounds") {
+			t.Errorf("%s: got %v, want an out of bounds error", src, err)
+		}
+	}
+
+	// One-byte strings and small int keys come from tables
+	allocs := testing.AllocsPerRun(100, func() {
+		value.NewString("x")
+		value.NewChar('y')
+		value.NewInt(1000).HashKey()
+		value.NewString("key").HashKey()
+	})
+	if allocs != 0 {
+		t.Errorf("common values allocated %v times", allocs)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tests := []vmTestCase{
+		{`[1, "a", 2.0]`, `[1, "a", 2.0]`},
+		{`set_new(["x"])`, `set{"x"}`},
+		{`inspect([1, 2])`, "[\n  1,\n  2\n]"},
+		{`inspect({"k": [1]}, "\t")`, "{\n\t\"k\": [\n\t\t1\n\t]\n}"},
+		{`inspect([], 4)`, "[]"},
+		{`inspect("top")`, "top"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestInstancePrinting(t *testing.T) {
+	src := `struct Node
+    name: string
+    next: any
+    tags: map[string, int]
+end
+let a: Node = Node("a", null, {"x": 1})
+let b: Node = Node("b", a, {})
+a.next = b
+test_report(to_str(b))`
+	testExpectedObject(t, `Node(name: "b", next: Node(name: "a", next: <cycle>, tags: {"x": 1}), tags: {})`, runVmProgram(t, src, VMConfig{}))
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	tests := map[string]string{
+		"let a: int = 9223372036854775807\nlet b: int = a + 1":                                                       "integer overflow: 9223372036854775807 + 1",
+		"let a: int = -9223372036854775807\nlet b: int = a - 2":                                                      "integer overflow",
+		"let a: int = 4611686018427387904\nlet b: int = a * 2":                                                       "integer overflow",
+		"let a: int = -7\nlet b: int = a / 2":                                                                        "truncates toward zero to -3",
+		"let a: float = 1.0\nlet b: float = 0.0 - a * to_float(\"nan\")":                                             "NaN result",
+		"let a: float = 10.0\nlet b: float = a\nlet i: int = 0\nwhile i < 400 do\n    b = b * a\n    i = i + 1\nend": "float overflow",
+	}
+	for src, want := range tests {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if err := New().Interpret(bytecode); err != nil {
+			t.Errorf("unchecked VM failed on %q: %s", src, err)
+		}
+		err = NewWithConfig(VMConfig{RootPath: ".", CheckedArithmetic: true}).Interpret(bytecode)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("checked VM on %q: got %v, want error containing %q", src, err, want)
+		}
+	}
+
+	// Exact divisions and positive truncation are allowed
+	for _, src := range []string{"let a: int = -8\nlet b: int = a / 2", "let a: int = 7\nlet b: int = a / 2"} {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if err := NewWithConfig(VMConfig{RootPath: ".", CheckedArithmetic: true}).Interpret(bytecode); err != nil {
+			t.Errorf("checked VM on %q: %s", src, err)
+		}
+	}
+}
+
+func TestMapKeyHashing(t *testing.T) {
+	tests := []vmTestCase{
+		{`{1.5: "a", 2.5: "b"}[2.5]`, "b"},
+		{`{true: 1, false: 0}[false]`, 0},
+		{`{b"k": 1}`, `{b"k": 1}`},
+		{`has_key({b"k": 1}, "k")`, false},
+		{`has_key({1: "x"}, 1.0)`, true},
+		{`keys({2.0: "x"})`, "[2]"},
+		{`length(set_new([1, 1.0, true, "1", b"1"]))`, 4},
+	}
+	runVmTests(t, tests)
+
+	src := `struct P
+    x: int
+    y: string
+end
+let m: map[P, int] = {}
+m[P(1, "a")] = 10
+m[P(1, "a")] = m[P(1, "a")] + 1
+test_report(f"{length(m)} {m[P(1, \"a\")]} {has_key(m, P(2, \"a\"))} {keys(m)[0].y}")`
+	testExpectedObject(t, "1 11 false a", runVmProgram(t, src, VMConfig{}))
+
+	bytecode, _, err := compiler.New().Compile(parser.New(lexer.New("let m: map[any, int] = {}\nm[[1]] = 1")).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if err := New().Interpret(bytecode); err == nil || !strings.Contains(err.Error(), "unhashable type array") {
+		t.Errorf("expected unhashable key error, got %v", err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	tests := []vmTestCase{
+		{`is_frozen(freeze([1, 2]))`, true},
+		{`is_frozen([1, 2])`, false},
+		{`is_frozen(freeze({"a": [1]})["a"])`, true},
+		{`freeze(5)`, 5},
+	}
+	runVmTests(t, tests)
+
+	errors := map[string]string{
+		"let a: int[] = freeze([1, 2])\na[0] = 3":                                               "cannot modify frozen array",
+		"let a: int[] = freeze([1, 2])\nappend(a, 3)":                                           "append: cannot modify frozen array",
+		"let m: map[string, int] = freeze({\"k\": 1})\nm[\"k\"] = 2":                            "cannot modify frozen map",
+		"let m: map[string, int] = freeze({\"k\": 1})\ndelete(m, \"k\")":                        "delete: cannot modify frozen map",
+		"struct P\n    x: int\nend\nlet p: P = freeze(P(1))\np.x = 2":                           "cannot modify frozen P instance",
+		"let m: map[string, any] = freeze({\"list\": [1]})\nlet l: int[] = m[\"list\"]\npop(l)": "pop: cannot modify frozen array",
+	}
+	for src, want := range errors {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if err := New().Interpret(bytecode); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want error containing %q", src, err, want)
+		}
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	tests := map[string]string{
+		// Called through values of unknown type, so only the VM can tell
+		"func f(a: int) -> int\n    return a\nend\nlet g: func = f\nlet x: any = g(1, 2)": "[:line 5] function 'f' expects 1 arguments but got 2",
+		"struct P\n    x: int\nend\nlet q: any = P\nlet p: any = q(1, 2)":                 "[:line 5] struct P expects 1 arguments but got 2",
+		"let x: int = 3\nlet y: any = x(1)":                                               "[:line 2] can only call functions and structs, got int",
+		"func f() -> int\n    return f()\nend\nlet x: int = f()":                          "[:line 2] stack overflow calling 'f'",
+		"func g(a: int)\nend\nspawn(g)":                                                   "[:line 3] spawn: function 'g' expects 1 arguments but got 0",
+	}
+	for src, want := range tests {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error on %q: %s", src, err)
+		}
+		if err := New().Interpret(bytecode); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	tests := map[string]string{
+		"let n: int = lenght([1])":                                              "undefined global variable 'lenght'; did you mean 'length'?",
+		"let total: int = 1\nlet x: int = totl + 1":                             "undefined global variable 'totl'; did you mean 'total'?",
+		"struct P\n    name: string\nend\nlet p: any = P(\"a\")\nprint(p.nmae)": "undefined property 'nmae'; did you mean 'name'?",
+		"use strings\nprint(strings.contian(\"ab\", \"a\"))":                    "did you mean 'contains'?",
+		"let x: int = qqqqq":                                                    "undefined global variable 'qqqqq'",
+	}
+	for src, want := range tests {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error on %q: %s", src, err)
+		}
+		err = NewWithConfig(VMConfig{RootPath: "../.."}).Interpret(bytecode)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+	if hint := didYouMean("qqqqq", []string{"print", "length"}); hint != "" {
+		t.Errorf("expected no hint for an unrelated name, got %q", hint)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	tests := map[string]string{
+		"let m: map[string, any] = {\"k\": \"v\"}\nlet n: int = m[\"k\"]":               "strict: 'n' is declared int but got string",
+		"let n: int = 0\nlet a: any[] = [\"s\"]\nn = a[0]":                              "strict: 'n' is declared int but got string",
+		"func f(x: any) -> int\n    return x\nend\nlet n: int = f(\"s\")":               "strict: return value of 'f' is declared int but got string",
+		"func f(x: int) -> int\n    return x\nend\nlet g: any = f\nlet n: any = g(1.5)": "strict: parameter 'x' of 'f' is declared int but got float",
+		"let a: any[] = [1, \"s\"]\nlet b: int[] = a":                                   "strict: 'b' is declared int[] but got array",
+	}
+	for src, want := range tests {
+		program := parser.New(lexer.New(src)).ParseProgram()
+		lenient, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error on %q: %s", src, err)
+		}
+		if err := New().Interpret(lenient); err != nil {
+			t.Errorf("non-strict run of %q failed: %s", src, err)
+		}
+
+		c := compiler.New()
+		c.Strict = true
+		strict, _, err := c.Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error on %q: %s", src, err)
+		}
+		if err := New().Interpret(strict); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %q, got %v", want, src, err)
+		}
+	}
+
+	src := `struct P
+    x: int
+end
+func mk(x: int) -> P
+    return P(x)
+end
+let ps: P[] = [mk(1), mk(2)]
+let m: map[string, P] = {"a": ps[1]}
+test_report(m["a"].x)`
+	l := lexer.New(src)
+	p := parser.New(l)
+	c := compiler.New()
+	c.Strict = true
+	bytecode, _, err := c.Compile(p.ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New()
+	var got value.Value
+	machine.DefineNative("test_report", func(args []value.Value) value.Value {
+		got = args[0]
+		return value.NewNull()
+	})
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("well-typed strict program failed: %s", err)
+	}
+	testExpectedObject(t, 2, got)
+}
+
+func TestPrintNatives(t *testing.T) {
+	src := `print("a", 1)
+iprint("b", 2)
+print_raw("c", 3, "\n")
+print_err("oops", true)
+flush()`
+	bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var out, errOut bytes.Buffer
+	buffered := bufio.NewWriter(&out)
+	machine := NewWithConfig(VMConfig{Stdout: buffered, Stderr: &errOut})
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got, want := out.String(), "a 1\nb 2c3\n"; got != want {
+		t.Errorf("stdout = %q, want %q (flush should empty the buffer)", got, want)
+	}
+	if got, want := errOut.String(), "oops true\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+}
+
+func TestEmbedFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "templates", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "templates", "index.html"), []byte("<h1>hi</h1>"), 0644)
+	os.WriteFile(filepath.Join(dir, "templates", "sub", "a.txt"), []byte("a\nb"), 0644)
+
+	src := `use io
+embed "templates"
+let f: io.File = io.open_embedded("templates/index.html")
+let lines: string[] = io.read_lines(io.open_embedded("templates/sub/a.txt")).data
+test_report(f"{io.read(f).data} {lines} {io.list_embedded()} {io.open_embedded(\"nope\").open}")`
+	program := parser.New(lexer.New(src)).ParseProgram()
+	c := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), filepath.Join(dir, "main.nx"))
+	bytecode, _, err := c.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if got := c.Embedded(); len(got) != 2 || got[0] != "templates/index.html" {
+		t.Errorf("Embedded() = %v", got)
+	}
+
+	// The contents live in the chunk, so the files are no longer needed
+	os.RemoveAll(filepath.Join(dir, "templates"))
+	data, err := bytecode.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytecode, err = chunk.Deserialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine := New()
+	var got value.Value
+	machine.DefineNative("test_report", func(args []value.Value) value.Value {
+		got = args[0]
+		return value.NewNull()
+	})
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, `<h1>hi</h1> ["a", "b"] ["templates/index.html", "templates/sub/a.txt"] false`, got)
+
+	_, _, err = compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), filepath.Join(dir, "main.nx")).Compile(parser.New(lexer.New(`embed "templates/*"`)).ParseProgram())
+	if err == nil || !strings.Contains(err.Error(), "matches no files") {
+		t.Errorf("expected an error for a pattern without matches, got %v", err)
+	}
+}
+
+func TestHttpRouter(t *testing.T) {
+	src := `use http_parser select *
+use http_router select *
+
+func req(method: string, path: string, query: string, auth: string) -> HttpRequest
+    let headers: string[64]
+    headers[0] = "Authorization: " + auth
+    return HttpRequest(method, path, query, "HTTP/1.1", headers, 1, to_bytes("{\"n\": 2}"))
+end
+
+let seen: string[] = []
+let r: Router = new_router()
+use_middleware(ref r, func(ctx: Context, next: func) -> HttpResponse
+    let res: HttpResponse = next(ctx)
+    append(seen, ctx.request.path + "=" + to_str(res.status_code))
+    return res
+end)
+use_middleware(ref r, bearer_auth("secret"))
+on_get(ref r, "/users/:id/posts/:post", func(ctx: Context) -> HttpResponse
+    return json_response(200, {"id": param(ctx, "id"), "post": param(ctx, "post"), "q": query_param(ctx, "q")})
+end)
+on_post(ref r, "/echo", func(ctx: Context) -> HttpResponse
+    let data: map[string, any] = read_json(ctx)
+    return json_response(201, {"n": data["n"]})
+end)
+on_get(ref r, "/static/*", func(ctx: Context) -> HttpResponse
+    return json_response(200, param(ctx, "*"))
+end)
+
+let out: string[] = []
+let handler: func = router_handler(r)
+let requests: HttpRequest[] = [
+    req("GET", "/users/7/posts/9", "q=a+b%21", "Bearer secret"),
+    req("POST", "/echo", "", "Bearer secret"),
+    req("GET", "/static/css/site.css", "", "Bearer secret"),
+    req("GET", "/missing", "", "Bearer secret"),
+    req("DELETE", "/echo", "", "Bearer secret"),
+    req("GET", "/echo", "", "Bearer wrong")
+]
+for rq in requests do
+    let res: HttpResponse = handler(rq)
+    append(out, to_str(res.status_code) + " " + to_str(res.body))
+end
+test_report(f"{out} {seen}")`
+	got := runVmProgram(t, src, VMConfig{})
+	want := `["200 {\"id\":\"7\",\"post\":\"9\",\"q\":\"a b!\"}", "201 {\"n\":2}", "200 \"css/site.css\"", "404 Not Found", "405 Method Not Allowed", "401 Unauthorized"] ` +
+		`["/users/7/posts/9=200", "/echo=201", "/static/css/site.css=200", "/missing=404", "/echo=405", "/echo=401"]`
+	testExpectedObject(t, want, got)
+}
+
+func TestHttpServeStatic(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "public", "my docs"), 0755)
+	os.WriteFile(filepath.Join(dir, "public", "index.html"), []byte("<h1>home</h1>"), 0644)
+	os.WriteFile(filepath.Join(dir, "public", "my docs", "a.txt"), []byte("0123456789"), 0644)
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644)
+
+	src := `use http_parser select *
+use http_server select *
+
+let handler: func = http_serve_static("` + filepath.ToSlash(filepath.Join(dir, "public")) + `")
+
+func fetch(method: string, path: string, range: string) -> string
+    let headers: string[64]
+    headers[0] = "Range: " + range
+    let res: HttpResponse = handler(HttpRequest(method, path, "", "HTTP/1.1", headers, 1, to_bytes("")))
+    return to_str(res.status_code) + " " + get_header(res.headers, res.header_count, "Content-Type") + " " + get_header(res.headers, res.header_count, "Content-Range") + " " + to_str(res.body)
+end
+
+test_report([
+    fetch("GET", "/", ""),
+    fetch("GET", "/my%20docs/a.txt", "bytes=2-4"),
+    fetch("GET", "/my%20docs/a.txt", "bytes=-3"),
+    fetch("GET", "/my%20docs/a.txt", "bytes=20-"),
+    fetch("HEAD", "/my%20docs/a.txt", ""),
+    fetch("GET", "/../secret.txt", ""),
+    fetch("GET", "/%2e%2e/secret.txt", ""),
+    fetch("GET", "/..%2fsecret.txt", ""),
+    fetch("GET", "/nope.css", ""),
+    fetch("POST", "/", "")
+])`
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, `["200 text/html; charset=utf-8  <h1>home</h1>", `+
+		`"206 text/plain; charset=utf-8 bytes 2-4/10 234", `+
+		`"206 text/plain; charset=utf-8 bytes 7-9/10 789", `+
+		`"416 text/plain; charset=utf-8 bytes */10 ", `+
+		`"200 text/plain; charset=utf-8  ", `+
+		`"403 text/plain  Forbidden", "403 text/plain  Forbidden", "403 text/plain  Forbidden", `+
+		`"404 text/plain  Not Found", "405 text/plain  Method Not Allowed"]`, got)
+}
+
+func TestHttpCookiesAndSessions(t *testing.T) {
+	src := `use http_parser select *
+use http_server select *
+use http_router select *
+
+func req(path: string, cookie: string) -> HttpRequest
+    let headers: string[64]
+    headers[0] = "Cookie: theme=dark; " + cookie
+    return HttpRequest("GET", path, "", "HTTP/1.1", headers, 1, to_bytes(""))
+end
+
+// The session cookie a response sets, as a request would send it back
+func returned(res: HttpResponse) -> string
+    let set: string = get_header(res.headers, res.header_count, "Set-Cookie")
+    return substring(set, 0, index_of(set, ";"))
+end
+
+let r: Router = new_router()
+use_middleware(ref r, sessions("s3cret", 3600))
+on_get(ref r, "/login", func(ctx: Context) -> HttpResponse
+    let s: map[string, any] = session(ctx)
+    s["user"] = "ana"
+    return response_text("hi")
+end)
+on_get(ref r, "/me", func(ctx: Context) -> HttpResponse
+    let s: map[string, any] = session(ctx)
+    if !has_key(s, "user") then
+        return response_error(401, "anonymous")
+    end
+    return response_text(s["user"] + " " + get_cookie(ctx.request.headers, ctx.request.header_count, "theme"))
+end)
+on_get(ref r, "/logout", func(ctx: Context) -> HttpResponse
+    delete(session(ctx), "user")
+    return response_text("bye")
+end)
+
+let login: HttpResponse = dispatch(r, req("/login", ""))
+let cookie: string = returned(login)
+let me: HttpResponse = dispatch(r, req("/me", cookie))
+let forged: HttpResponse = dispatch(r, req("/me", "noxy_session=" + http_sign(json_dumps({"data": {"user": "bob"}, "exp": time_now() + 60}), "guess")))
+let other_key: map[string, any] = load_session(req("/me", cookie), "other")
+let logout: HttpResponse = dispatch(r, req("/logout", cookie))
+
+test_report([
+    to_str(me.status_code) + " " + to_str(me.body),
+    to_str(forged.status_code),
+    to_str(length(other_key)),
+    get_header(logout.headers, logout.header_count, "Set-Cookie"),
+    get_header(me.headers, me.header_count, "Set-Cookie"),
+    http_format_cookie("id", "a b;c", {"path": "/", "max_age": 60, "secure": true, "same_site": "strict"}),
+    to_str(http_parse_cookies("a=1; b=\"2\"; a=3; bad; =x"))
+])`
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, `["200 ana dark", "401", "0", `+
+		`"noxy_session=; Path=/; Max-Age=0; HttpOnly; SameSite=Lax", "", `+
+		`"id=\"a bc\"; Path=/; Max-Age=60; Secure; SameSite=Strict", `+
+		`"{\"a\": \"1\", \"b\": \"2\"}"]`, got)
+
+	bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(`http_format_cookie("a b", "v", {"path": "/"})`)).ParseProgram())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := New().Interpret(bytecode); err == nil || !strings.Contains(err.Error(), "invalid cookie name") {
+		t.Errorf("expected an invalid cookie name error, got %v", err)
+	}
+}
+
+func TestUrlModule(t *testing.T) {
+	src := `use url
+use http_parser select *
+let u: map[string, any] = url.parse("https://bob@example.com:8443/a%20b/c?x=1&y=two+words&x=2#top")
+let bare: HttpUrl = parse_url("example.com/x%20y?q=1")
+test_report([
+    f"{u[\"scheme\"]} {u[\"user\"]} {u[\"host\"]} {u[\"port\"]} {u[\"path\"]} {u[\"raw_path\"]} {u[\"params\"]} {u[\"fragment\"]}",
+    to_str(url.parse("http://example.com")["port"]) + " " + to_str(url.parse("http://a b/") == null),
+    url.query_build({"q": "a b&c", "tag": ["x", "y"], "n": 3}),
+    to_str(url.query_parse("?k=v&k=w&e")),
+    url_encode("a b/") + " " + url_decode("a+b%2F") + " " + url_path_encode("a b/c") + " " + url_path_decode("a+b%20c"),
+    f"{bare.host} {bare.port} {bare.path} {bare.query} {bare.valid}"
+])`
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, `["https bob example.com 8443 /a b/c /a%20b/c {\"x\": \"1\", \"y\": \"two words\"} top", `+
+		`"80 true", "q=a+b%26c&tag=x&tag=y&n=3", "{\"k\": \"v\", \"e\": \"\"}", `+
+		`"a+b%2F a b/ a%20b%2Fc a+b c", "example.com 80 /x%20y q=1 true"]`, got)
+}
+
+func TestHttpForms(t *testing.T) {
+	body := "--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\nMy photo\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"photo\"; filename=\"../cat.png\"\r\n" +
+		"Content-Type: image/png\r\n\r\n\x89PNG\r\n\x1a\npixels\r\n" +
+		"--XyZ--\r\n"
+	src := `use http_parser select *
+use http_server select *
+use io
+
+func req(content_type: string, body: bytes) -> HttpRequest
+    let headers: string[64]
+    headers[0] = "Content-Type: " + content_type
+    return HttpRequest("POST", "/", "", "HTTP/1.1", headers, 1, body)
+end
+
+let form: map[string, any] = parse_form(req("multipart/form-data; boundary=XyZ", test_body()))
+let file: map[string, any] = form["files"][0]
+let saved: string = io.read(io.open(file["path"], "r")).data
+io.remove(file["path"])
+let urlencoded: map[string, any] = parse_form(req("application/x-www-form-urlencoded", to_bytes("a=1&b=x+y")))
+test_report([
+    to_str(form["fields"]),
+    f"{file[\"field\"]} {file[\"filename\"]} {file[\"size\"]} {file[\"content_type\"]} {http_sniff_type(to_bytes(saved))}",
+    to_str(urlencoded["fields"]),
+    http_mime_type("site.CSS") + " " + http_mime_type("noext") + " " + http_sniff_type(to_bytes("<html><body>"))
+])`
+	l := lexer.New(src)
+	bytecode, _, err := compiler.New().Compile(parser.New(l).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New()
+	var got value.Value
+	machine.DefineNative("test_body", func(args []value.Value) value.Value { return value.NewBytes(body) })
+	machine.DefineNative("test_report", func(args []value.Value) value.Value {
+		got = args[0]
+		return value.NewNull()
+	})
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, `["{\"title\": \"My photo\"}", "photo cat.png 14 image/png image/png", `+
+		`"{\"a\": \"1\", \"b\": \"x y\"}", "text/css; charset=utf-8 application/octet-stream text/html; charset=utf-8"]`, got)
+
+	bytecode, _, _ = compiler.New().Compile(parser.New(lexer.New(`http_parse_multipart(to_bytes("x"), "text/plain")`)).ParseProgram())
+	if err := New().Interpret(bytecode); err == nil || !strings.Contains(err.Error(), "is not multipart/form-data") {
+		t.Errorf("expected a content type error, got %v", err)
+	}
+}
+
+func TestJwt(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	privPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}))
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+
+	src := `use jwt
+use strings select *
+let hs: string = jwt.sign({"sub": "ana", "admin": true}, "secret", "HS256")
+let rs: string = jwt.sign({"sub": "bob", "exp": time_now() + 60}, test_key("private"), "RS256")
+let forged: SplitResult = split(jwt.sign({"sub": "eve", "admin": true}, "guess"), ".")
+let real: SplitResult = split(hs, ".")
+// An HS256 token keyed with the public key must not pass RS256 verification
+let confused: string = jwt.sign({"sub": "eve"}, test_key("public"), "HS256")
+test_report([
+    to_str(jwt.verify(hs, "secret")),
+    to_str(jwt.verify(hs, "wrong")),
+    to_str(jwt.verify(real.parts[0] + "." + forged.parts[1] + "." + real.parts[2], "secret")),
+    to_str(jwt.verify(jwt.sign({"exp": time_now() - 1}, "secret"), "secret")),
+    to_str(jwt.verify(jwt.sign({"nbf": time_now() + 60}, "secret"), "secret")),
+    jwt.verify(rs, test_key("public"))["sub"],
+    jwt.verify(rs, test_key("private"))["sub"],
+    to_str(jwt.verify(rs, "secret")),
+    to_str(jwt.verify(confused, test_key("public"))),
+    to_str(jwt.verify("not.a.token", "secret"))
+])`
+	bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New()
+	var got value.Value
+	machine.DefineNative("test_key", func(args []value.Value) value.Value {
+		if args[0].String() == "private" {
+			return value.NewString(privPEM)
+		}
+		return value.NewString(pubPEM)
+	})
+	machine.DefineNative("test_report", func(args []value.Value) value.Value {
+		got = args[0]
+		return value.NewNull()
+	})
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, `["{\"admin\": true, \"sub\": \"ana\"}", "null", "null", "null", "null", "bob", "bob", "null", "null", "null"]`, got)
+
+	for src, want := range map[string]string{
+		`jwt_sign({"a": 1}, "k", "none")`:  "unsupported algorithm",
+		`jwt_sign({"a": 1}, "k", "RS256")`: "RS256 needs a PEM encoded RSA private key",
+		`jwt_sign([1], "k")`:               "claims must be a map or struct",
+	} {
+		bytecode, _, _ := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err := New().Interpret(bytecode); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q for %s, got %v", want, src, err)
+		}
+	}
+}
+
+func TestNetPipe(t *testing.T) {
+	// The upstream answers with what it reads, upper-cased, until EOF
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			conn.Write(bytes.ToUpper(buf[:n]))
+		}
+	}()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyPort := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	src := fmt.Sprintf(`use net select *
+
+func forward(inbound: Socket, c: any) -> void
+    let upstream: Socket = connect("127.0.0.1", %d)
+    let res: NetResult = pipe(inbound, upstream)
+    chan_send(c, res)
+end
+
+let server: Socket = listen("127.0.0.1", %d)
+let client: Socket = connect("127.0.0.1", %d)
+let done: any = make_chan(1)
+spawn(forward, accept(server), done)
+
+socket_send(client, to_bytes("hello"))
+let reply: NetResult = socket_recv(client, 64)
+socket_close(client)
+let res: NetResult = chan_recv(done)
+socket_close(server)
+test_report(f"{to_str(reply.data)} {res.ok} {res.count} {pipe(server, server).error}")`, upstream.Addr().(*net.TCPAddr).Port, proxyPort, proxyPort)
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, "HELLO true 10 invalid socket", got)
+}
+
+func TestNetSendAll(t *testing.T) {
+	// A peer that accepts but never reads, so the send buffers fill up
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer stalled.Close()
+	go func() {
+		conn, err := stalled.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(2 * time.Second)
+		}
+	}()
+
+	src := fmt.Sprintf(`use net select *
+use strings select *
+let sock: Socket = connect("127.0.0.1", %d)
+let small: NetResult = socket_send_all(sock, to_bytes("ping"), 1000)
+let big: bytes = to_bytes(repeat("x", 64 * 1024 * 1024))
+let slow: NetResult = socket_send_all(sock, big, 100)
+socket_close(sock)
+let closed: NetResult = socket_send_all(sock, to_bytes("x"), 0)
+test_report(f"{small.ok} {small.count} {slow.ok} {slow.error} {slow.count > 0 && slow.count < length(big)} {closed.error}")`, stalled.Addr().(*net.TCPAddr).Port)
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, "true 4 false timeout true closed", got)
+}
+
+func TestNetIPv6(t *testing.T) {
+	probe, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	src := fmt.Sprintf(`use net select *
+let server: Socket = listen("::1", %d)
+let client: Socket = connect("[::1]", %d)
+let conn: Socket = accept(server)
+socket_send(client, to_bytes("hi"))
+let r: NetResult = socket_recv(conn, 16)
+socket_close(client)
+socket_close(conn)
+socket_close(server)
+test_report(f"{server.open} {client.open} {to_str(r.data)}")`, port, port)
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, "true true hi", got)
+}
+
+func TestNetListenLimits(t *testing.T) {
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	src := fmt.Sprintf(`use net select *
+
+func accept_one(server: Socket, c: any) -> void
+    chan_send(c, accept(server).open)
+end
+
+let server: Socket = listen_with("127.0.0.1", %d, {"max_conns": 1, "on_full": "reject", "idle_timeout_ms": 200})
+let c1: Socket = connect("127.0.0.1", %d)
+let a1: Socket = accept(server)
+let c2: Socket = connect("127.0.0.1", %d)
+let done: any = make_chan(1)
+spawn(accept_one, server, done)
+
+// c2 finds the listener full and is hung up on
+let rejected: NetResult = socket_recv(c2, 16)
+// a1 never hears from c1, so it is dropped, freeing the slot
+let idle: NetResult = socket_recv(a1, 16)
+let c3: Socket = connect("127.0.0.1", %d)
+let accepted: bool = chan_recv(done)
+socket_close(server)
+
+// With on_full "wait", accept waits for a slot until the listener closes
+let waiting: Socket = listen_with("127.0.0.1", %d, {"max_conns": 1})
+let c4: Socket = connect("127.0.0.1", %d)
+let a4: Socket = accept(waiting)
+spawn(accept_one, waiting, done)
+time_sleep(50)
+socket_close(waiting)
+test_report(f"{rejected.ok} {rejected.count} {idle.error} {accepted} {chan_recv(done)}")`, port, port, port, port, port, port)
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, "true 0 idle timeout true false", got)
+
+	bytecode, _, _ := compiler.New().Compile(parser.New(lexer.New(`net_listen("127.0.0.1", 0, {"max_con": 1})`)).ParseProgram())
+	if err := New().Interpret(bytecode); err == nil || !strings.Contains(err.Error(), "unknown listen option 'max_con'") {
+		t.Errorf("expected an unknown option error, got %v", err)
+	}
+}
+
+func TestTraceLines(t *testing.T) {
+	src := `func add(a: int, b: int) -> int
+    return a + b
+end
+let x: int = add(1, 2)
+let y: int = add(x, 3)`
+	program := parser.New(lexer.New(src)).ParseProgram()
+	bytecode, _, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	machine := NewWithConfig(VMConfig{Trace: TraceLines, TraceFunc: "add", TraceLimit: 1})
+	var events []TraceEvent
+	machine.SetTraceHook(func(ev TraceEvent) { events = append(events, ev) })
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event under the limit, got %d: %v", len(events), events)
+	}
+	if events[0].Function != "add" || events[0].Stack != nil {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+}
+
+func TestCloseReleasesHandles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.ToSlash(filepath.Join(dir, "out.txt"))
+	src := fmt.Sprintf(`struct File
+    fd: int
+    path: string
+    mode: string
+    open: bool
+end
+let f: File = io_open(%q, "w", File)
+io_write(f, "data")`, path)
+	bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New()
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	open := machine.OpenResources()
+	if len(open) != 1 || !strings.HasPrefix(open[0], "file 1 (") {
+		t.Fatalf("expected one open file, got %v", open)
+	}
+	machine.Close()
+	if open := machine.OpenResources(); len(open) != 0 {
+		t.Errorf("handles still open after Close: %v", open)
+	}
+}
+
+func TestWorkDir(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	os.Mkdir(filepath.Join(dirs[0], "sub"), 0755)
+	processDir, _ := os.Getwd()
+
+	src := `use io
+use sys
+let f: File = io.open("out.txt", "w")
+io.write(f, "x")
+io.close(f)
+let moved: bool = sys.chdir("sub")
+let missing: bool = sys.chdir("missing")
+test_report(f"{moved} {missing} {sys.getcwd()} {io.exists(\"out.txt\")}")`
+	want := []string{
+		fmt.Sprintf("true false %s false", filepath.Join(dirs[0], "sub")),
+		fmt.Sprintf("false false %s true", dirs[1]),
 	}
+	for i, dir := range dirs {
+		machine := NewWithConfig(VMConfig{WorkDir: dir})
+		var captured value.Value
+		machine.DefineNative("test_report", func(args []value.Value) value.Value {
+			captured = args[0]
+			return value.NewNull()
+		})
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if err := machine.Interpret(bytecode); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, want[i], captured)
+		if _, err := os.Stat(filepath.Join(dir, "out.txt")); err != nil {
+			t.Errorf("relative io.open did not write into the work dir: %s", err)
+		}
+
+		machine.Reset()
+		if cwd, _ := machine.Getwd(); cwd != dir {
+			t.Errorf("Reset left the working directory at %s", cwd)
+		}
+	}
+	if cwd, _ := os.Getwd(); cwd != processDir {
+		t.Errorf("sys.chdir moved the process to %s", cwd)
+	}
+}
+
+func TestSysExit(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "quits.nx"), []byte("use sys\nsys.exit(4)\n"), 0644)
+
+	run := func(src string) (*VM, error) {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		machine := NewWithConfig(VMConfig{RootPath: dir, WorkDir: dir})
+		machine.DefineNative("test_report", func(args []value.Value) value.Value {
+			t.Errorf("code after sys.exit ran")
+			return value.NewNull()
+		})
+		return machine, machine.Interpret(bytecode)
+	}
+
+	// sys.exit unwinds nested calls and leaves cleanup to the embedder
+	machine, err := run(`use io
+use sys
+func stop(code: int) -> void
+    sys.exit(code)
+end
+let f: File = io.open("out.txt", "w")
+io.write(f, "partial")
+stop(3)
+test_report(1)`)
+	var exit *ExitError
+	if !errors.As(err, &exit) || exit.Code != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+	if open := machine.OpenResources(); len(open) != 1 {
+		t.Fatalf("expected the file to be open until Close, got %v", open)
+	}
+	machine.Close()
+	if open := machine.OpenResources(); len(open) != 0 {
+		t.Errorf("handles still open after Close: %v", open)
+	}
+
+	// Exiting from a module's top-level code is not an import error
+	_, err = run("use quits\nlet q: any = quits\ntest_report(1)")
+	if !errors.As(err, &exit) || exit.Code != 4 {
+		t.Errorf("expected exit status 4 from the module, got %v", err)
+	}
+}
+
+func TestResetBetweenRuns(t *testing.T) {
+	compile := func(src string) *chunk.Chunk {
+		bytecode, _, err := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), "test").Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		return bytecode
+	}
+
+	machine := New()
+	var captured value.Value
+	machine.DefineNative("test_report", func(args []value.Value) value.Value {
+		captured = args[0]
+		return value.NewNull()
+	})
+
+	if err := machine.Interpret(compile("let counter: int = 41")); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := machine.Interpret(compile("test_report(counter + 1)")); err != nil {
+		t.Fatalf("second run should see earlier globals: %s", err)
+	}
+	testExpectedObject(t, 42, captured)
+
+	machine.Reset()
+	if _, ok := machine.GetGlobal("counter"); ok {
+		t.Errorf("Reset kept script global 'counter'")
+	}
+	if err := machine.Interpret(compile("test_report(length([1, 2]))")); err != nil {
+		t.Fatalf("natives should survive Reset: %s", err)
+	}
+	testExpectedObject(t, 2, captured)
+}
+
+func TestNativeModules(t *testing.T) {
+	tests := []vmTestCase{
+		{`json_dumps([1])`, "[1]"},
+	}
+	runVmTests(t, tests)
+
+	src := `use json
+use strings
+use time
+use geometry
+test_report(f"{json.dumps([1, 2])} {strings.contains(\"noxy\", \"ox\")} {time.now_ms() > 0} {geometry.area(3, 4)}")`
+	machine := New()
+	machine.RegisterModule("geometry", map[string]value.NativeFunc{
+		"area": func(args []value.Value) value.Value {
+			return value.NewInt(args[0].AsInt * args[1].AsInt)
+		},
+	})
+	bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var captured value.Value
+	machine.DefineNative("test_report", func(args []value.Value) value.Value {
+		captured = args[0]
+		return value.NewNull()
+	})
+	if err := machine.Interpret(bytecode); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, "[1,2] true true 12", captured)
+	if _, ok := machine.GetGlobal("geometry_area"); !ok {
+		t.Errorf("expected flat alias geometry_area")
+	}
+}
+
+func TestStub(t *testing.T) {
+	src := `use testing
+use time
+use strings select to_upper
+
+func greet(name: string) -> string
+    return "hi " + to_upper(name)
+end
+
+let out: string = ""
+let fake_clock: func = func() -> int
+    return 42
+end
+let shout: func = func(s: string) -> string
+    return s + "!"
+end
+
+// A native stubbed by its global name is seen through its module wrapper
+let seen: any = testing.with_stub("time_now", fake_clock, func() -> int
+    return time.now()
+end)
+out = out + to_str(seen) + " " + to_str(time.now() != 42)
+
+// Names imported with select are stubbed too, and stubs nest
+testing.with_stub("strings_to_upper", shout, func() -> void
+    out = out + " " + greet("ana")
+    testing.with_stub("greet", shout, func() -> void
+        out = out + " " + greet("bo")
+    end)
+    out = out + " " + greet("cy")
+end)
+out = out + " " + greet("di")
+
+// A body that throws still puts the original back, and the error escapes
+try
+    testing.with_stub("greet", shout, func() -> void
+        throw error("boom", 3)
+    end)
+catch e
+    out = out + " " + e.message + to_str(e.code)
+end
+out = out + " " + greet("ed")
+test_report(out)`
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, "42 true hi ana! bo! hi cy! hi DI boom3 hi ED", got)
+
+	for _, tc := range []struct{ src, want string }{
+		{`use testing
+testing.stub("nope", func() -> void end)`, "cannot stub 'nope': not defined"},
+		{`use testing
+let n: int = 1
+testing.stub("n", func() -> void end)`, "it is a int, not a function"},
+		{`use testing
+testing.unstub("print")`, "'print' is not stubbed"},
+	} {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got %v, want an error containing %q", err, tc.want)
+		}
+	}
+}
+
+func TestRuntimeErrorsInsteadOfPanics(t *testing.T) {
+	// Enough parameters that 60 nested calls fill the value stack before
+	// the frame limit is reached
+	var params, args []string
+	for i := 0; i < 40; i++ {
+		params = append(params, fmt.Sprintf("p%d: int", i))
+		args = append(args, fmt.Sprintf("%d", i))
+	}
+	deep := fmt.Sprintf(`func f(n: int, %s) -> int
+    if n == 0 then return 0 end
+    return f(n - 1, %s)
+end
+print(f(60, %s))`, strings.Join(params, ", "), strings.Join(args, ", "), strings.Join(args, ", "))
+
+	tests := []struct{ src, want string }{
+		{deep, "stack overflow (more than 2040 values on the stack)"},
+		{`let c: any = make_chan(1)
+chan_close(c)
+chan_send(c, 1)`, "chan_send: send on closed channel"},
+		{`let c: any = make_chan(1)
+chan_close(c)
+when
+    case chan_send(c, 1) then
+        print("sent")
+end`, "select: send on closed channel"},
+		{`make_chan(-1)`, "make_chan: buffer size must not be negative"},
+		{`let w: any = make_wg()
+wg_add(w, 1)
+wg_done(w)
+wg_done(w)`, "wg_done: called more times than wg_add added"},
+		{`let w: any = make_wg()
+wg_add(w, -1)`, "wg_add: negative counter"},
+		{`print(zeros(-1))`, "zeros size must not be negative"},
+		{`print(strings_repeat("ab", -1))`, "strings.repeat: negative count -1"},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got %v, want an error containing %q", err, tc.want)
+		}
+	}
+
+	// Out-of-range arguments that used to panic now give ordinary results
+	src := `use time
+let sub: string = strings_substring("abc", 1, -2)
+let parsed: any = time_parse(5, time.DateTime)
+test_report(f"[{sub}] {parsed}")`
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, "[] null", got)
+}
+
+func TestNativeArgumentErrors(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{`let n: any = 3
+strings_repeat(n, "x")`, "[:line 2] strings.repeat: expects (string, int), got (int, string)"},
+		{`strings_to_upper()`, "strings.to_upper: expects (string), got ()"},
+		{`strings_contains("a", "b", "c")`, "strings.contains: expects (string, string), got (string, string, string)"},
+		{`to_int("abc")`, `to_int: cannot convert "abc" to int`},
+		{`to_float(true)`, "to_float: cannot convert bool to float"},
+		{`to_int(bigint("99999999999999999999"))`, "to_int: 99999999999999999999 does not fit in an int"},
+		{`length(5)`, "length: expects (string|bytes|array|map|set|buffer), got (int)"},
+		{`let m: map[string, int] = {"a": 1}
+append(m, 1)`, "append: expects (array|buffer, any), got (map, int)"},
+		{`slice([1, 2], "0", 1)`, "slice: expects (string|bytes|array|buffer, int, int), got (array, string, int)"},
+		{`inspect([1], 2.5)`, "inspect: expects (any, int|string?), got (array, float)"},
+		{`to_bytes([1, "x"])`, "to_bytes: element 1 is string, not int"},
+		{`chan_recv(make_wg())`, "chan_recv: expects (channel), got (waitgroup)"},
+		{`currency_format(1.5, "USD")`, "currency_format: expects (decimal|int|bigint, string, string?), got (float, string)"},
+		{`currency_format(1, "XYZ")`, `currency_format: unknown currency "XYZ"`},
+		{`currency_format(1, "USD", "xx-YY")`, `currency_format: unknown locale "xx-YY"`},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+
+	// Optional parameters may be left out
+	got := runVmProgram(t, `test_report(f"{inspect(1)} {to_int(\"12\")} {float_format(2, 1)} {to_bytes(b\"ok\")}")`, VMConfig{})
+	testExpectedObject(t, "1 12 2.0 ok", got)
+}
+
+func TestFStringInterpolation(t *testing.T) {
+	got := runVmProgram(t, `let m: map[string, string] = {"k": "v{}"}
+let n: int = 2
+test_report(f"{{n}} = {n} {m["k"]} {m[\"k\"]} {"}" + '"'} {length({"a": {"b": n}})}}}")`, VMConfig{})
+	testExpectedObject(t, `{n} = 2 v{} v{} }" 1}`, got)
+}
+
+func TestBytesNatives(t *testing.T) {
+	got := runVmProgram(t, `let msg: bytes = b"\x01\x02ab\r\ncd\r\n"
+let parts: bytes[] = bytes_split(msg, b"\r\n")
+let header: bytes = bytes_pack_u16be(258) + bytes_pack_u32le(7)
+test_report(f"{bytes_find(msg, b"\r\n")} {bytes_find(msg, b"\r\n", 6)} {bytes_find(msg, b"x")} {length(parts)} {parts[1]} {bytes_to_array(slice(msg, 0, 3))} {header == b"\x01\x02\x07\x00\x00\x00"} {bytes_unpack_u16be(msg)} {bytes_unpack_u16le(msg)} {bytes_unpack_u32le(header, 2)} {bytes_unpack_u32be(b"\xff\xff\xff\xff")}")`, VMConfig{})
+	testExpectedObject(t, "4 8 -1 3 cd [1, 2, 97] true 258 513 7 4294967295", got)
+
+	tests := []struct{ src, want string }{
+		{`bytes_pack_u16le(65536)`, "bytes.pack_u16le: 65536 does not fit in 2 bytes"},
+		{`bytes_pack_u32be(-1)`, "bytes.pack_u32be: -1 does not fit in 4 bytes"},
+		{`bytes_unpack_u32be(b"abc", 0)`, "bytes.unpack_u32be: need 4 bytes at offset 0, have 3"},
+		{`bytes_find(b"abc", b"c", 9)`, "bytes.find: start 9 out of range for 3 bytes"},
+		{`bytes_split("a,b", ",")`, "bytes.split: expects (bytes, bytes), got (string, string)"},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestBinaryPack(t *testing.T) {
+	got := runVmProgram(t, `use binary
+let data: bytes = binary.pack("<HhI?3sxd", [513, -2, 70000, true, "abcd", 1.5])
+let be: bytes = binary.pack("!Hb", [513, -1])
+let vals: any[] = binary.unpack("<HhI?3sxd", data)
+let big: any[] = binary.unpack(">Q", binary.pack(">Q", [bigint("18446744073709551615")]))
+test_report(f"{length(data)} {binary.size("<HhI?3sxd")} {be == b"\x02\x01\xff"} {vals} {big[0]} {binary.unpack("2B", b"\x00\x01\x02", 1)} {binary_unpack("<f", binary_pack("<f", [2]))}")`, VMConfig{})
+	testExpectedObject(t, `21 21 true [513, -2, 70000, true, "abc", 1.5] 18446744073709551615 [1, 2] [2.0]`, got)
+
+	tests := []struct{ src, want string }{
+		{`binary_pack("<H", [65536])`, "binary.pack: value 0: 65536 does not fit in 'H'"},
+		{`binary_pack("<HH", [1])`, "binary.pack: format packs 2 values, got 1"},
+		{`binary_pack("<i", ["1"])`, "binary.pack: value 0: 'i' cannot pack string"},
+		{`binary_pack("<z", [1])`, "binary.pack: unknown format code 'z'"},
+		{`binary_unpack("<I", b"abc")`, "binary.unpack: format needs 4 bytes at offset 0, have 3"},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	got := runVmProgram(t, `use checksum
+test_report(f"{checksum.crc32("hello")} {checksum.crc32(b"lo", checksum.crc32("hel"))} {checksum.crc32c("123456789")} {checksum.adler32(b"hello")} {checksum.fnv32a("hello")} {checksum_fnv64a("hello")}")`, VMConfig{})
+	testExpectedObject(t, "907060870 907060870 3808858755 103547413 1335831723 -6615550055289275125", got)
+}
+
+func TestSemverNatives(t *testing.T) {
+	got := runVmProgram(t, `use semver
+let v: map[string, any] = semver.parse("v1.4.2-rc.1+exp")
+test_report(f"{v["major"]}.{v["minor"]}.{v["patch"]} {v["pre"]} {v["build"]} {semver.parse("1.2") == null} {semver.compare("1.0.0-rc.2", "1.0.0-rc.10")} {semver.satisfies("1.4.2", "^1.2.0")} {semver_satisfies("2.0.0", ">=1.0.0 <2.0.0")}")`, VMConfig{})
+	testExpectedObject(t, "1.4.2 rc.1 exp true -1 true false", got)
+
+	tests := []struct{ src, want string }{
+		{`semver_compare("1.0", "1.0.0")`, `semver.compare: invalid version "1.0"`},
+		{`semver_satisfies("1.0.0", "^1.a")`, `semver.satisfies: invalid range "^1.a"`},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestConfigLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.toml"), []byte(`# service settings
+name = "api"
+tags = ["a", "b"]
+
+[db]
+host = "db.internal"
+port = 5433
+
+[headers]
+X-Team = 'core'
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "app.json"), []byte(`{"db": {"port": "x"}}`), 0644)
+	t.Setenv("APP_DB_PORT", "6000")
+	t.Setenv("APP_RATIO", "0.5")
+	t.Setenv("APP_NAME", "from-env")
+
+	got := runVmProgram(t, `use config
+let defaults: map[string, any] = {
+    "name": "svc", "debug": false, "ratio": 0.1, "tags": ["x"],
+    "db": {"host": "localhost", "port": 5432, "max_conns": 10},
+    "headers": {}
+}
+let cfg: map[string, any] = config.load(defaults, {"file": "app.toml", "env_prefix": "APP_"})
+test_report(f"{cfg} {defaults["db"]["port"]}")`, VMConfig{WorkDir: dir, Args: []string{"input.txt", "--db.max-conns=20", "--debug", "--name", "from-flag"}})
+	testExpectedObject(t, `{"name": "from-flag", "debug": true, "ratio": 0.5, "tags": ["a", "b"], "db": {"host": "db.internal", "port": 6000, "max_conns": 20}, "headers": {"X-Team": "core"}} 5432`, got)
+
+	tests := []struct{ src, want string }{
+		{`config_load({"port": 1}, {"args": ["--prot=2"]})`, "config.load: unknown flag --prot"},
+		{`config_load({"port": 1}, {"args": ["--port", "x"]})`, `config.load: flag --port: "x" is not an int`},
+		{`config_load({"debug": true}, {"args": ["--no-debug", "--port"]})`, "config.load: unknown flag --port"},
+		{`config_load({"db": {"host": ""}}, {"file": "app.toml"})`, "config.load: app.toml: unknown key db.port"},
+		{`config_load({"db": {"port": 1}}, {"file": "app.json"})`, "config.load: app.json: db.port: expected int, got string"},
+		{`config_load({}, {"file": "missing.toml"})`, "no such file"},
+		{`config_load({}, {"fiel": "app.toml"})`, "config.load: unknown option fiel"},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewWithConfig(VMConfig{WorkDir: dir}).Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+
+	// An optional file may be missing
+	got = runVmProgram(t, `test_report(config_load({"a": 1}, {"file": "missing.toml", "file_required": false}))`, VMConfig{WorkDir: dir})
+	testExpectedObject(t, `{"a": 1}`, got)
+}
+
+func TestFlagParse(t *testing.T) {
+	const defs = `use flag
+flag.usage("Usage: resize [flags] <image>")
+flag_int("width", 800, "target width")
+flag_string("format", "png", "output format")
+flag_bool("verbose", false, "log each step")
+flag_float("quality", 0.9)
+`
+	got := runVmProgram(t, defs+`let opts: map[string, any] = flag.parse(sys_argv())
+test_report(f"{opts} {flag.args()}")`, VMConfig{Args: []string{"a.jpg", "--width=10", "-verbose", "--format", "jpg", "--", "--b"}})
+	testExpectedObject(t, `{"width": 10, "format": "jpg", "verbose": true, "quality": 0.9} ["a.jpg", "--b"]`, got)
+
+	got = runVmProgram(t, defs+`test_report(flag.parse(["--verbose", "--no-verbose", "-quality", "0.5"]))`, VMConfig{Args: []string{"--width=1"}})
+	testExpectedObject(t, `{"width": 800, "format": "png", "verbose": false, "quality": 0.5}`, got)
+
+	run := func(args ...string) (string, string, error) {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(defs + "flag.parse()\nprint(\"parsed\")")).ParseProgram())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout, stderr bytes.Buffer
+		err = NewWithConfig(VMConfig{Args: args, Stdout: &stdout, Stderr: &stderr}).Interpret(bytecode)
+		return stdout.String(), stderr.String(), err
+	}
+	const help = `Usage: resize [flags] <image>
+
+Flags:
+  --width int      target width (default 800)
+  --format string  output format (default "png")
+  --verbose        log each step
+  --quality float  (default 0.9)
+  -h, --help       show this help
+`
+	var exit *ExitError
+	stdout, _, err := run("x", "--help")
+	if !errors.As(err, &exit) || exit.Code != 0 || stdout != help {
+		t.Errorf("--help: got %v and output\n%s", err, stdout)
+	}
+	for _, tc := range []struct{ arg, msg string }{
+		{"--width=wide", `invalid value "wide" for flag --width: expected int`},
+		{"--size=1", "unknown flag: --size=1"},
+		{"--format", "flag needs a value: --format"},
+	} {
+		_, stderr, err := run(tc.arg)
+		if !errors.As(err, &exit) || exit.Code != 2 || stderr != tc.msg+"\n"+help {
+			t.Errorf("%s: got %v and stderr\n%s", tc.arg, err, stderr)
+		}
+	}
+}
+
+func TestSysPipe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	got := runVmProgram(t, `use sys
+test_report(sys.pipe(["printf", "b\\na\\nb\\n"], ["sort"], ["uniq", "-c"]))`, VMConfig{})
+	testExpectedObject(t, `{"output": "      1 a\n      2 b\n", "stderr": "", "exit_codes": [0, 0, 0], "exit_code": 0, "ok": true}`, got)
+
+	got = runVmProgram(t, `use sys
+let r: map[string, any] = sys.pipe(["tr", "a-z", "A-Z"], {"input": "it's a 'quoted' $HOME"})
+test_report(r["output"])`, VMConfig{})
+	testExpectedObject(t, `IT'S A 'QUOTED' $HOME`, got)
+
+	// Commands run in the script's working directory
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "words.txt"), []byte("x\ny\nx\n"), 0644)
+	got = runVmProgram(t, `use sys
+let r: map[string, any] = sys.pipe(["grep", "-c", "x", "words.txt"])
+test_report(r["output"])`, VMConfig{WorkDir: dir})
+	testExpectedObject(t, "2\n", got)
+
+	// A command that cannot start exits with 127; the others still run
+	got = runVmProgram(t, `use sys
+let r: map[string, any] = sys.pipe(["noxy-no-such-command"], ["cat"], ["sh", "-c", "exit 3"])
+test_report(f"{r["exit_codes"]} {r["exit_code"]} {r["ok"]}")`, VMConfig{})
+	testExpectedObject(t, "[127, 0, 3] 3 false", got)
+
+	for src, want := range map[string]string{
+		`sys.pipe()`:                      "expects at least one command",
+		`sys.pipe([])`:                    "command 1 must be a non-empty string array",
+		`sys.pipe(["ls"], "wc")`:          "command 2 must be a non-empty string array",
+		`sys.pipe(["ls", 1])`:             "command 1: argument 1 is int",
+		`sys.pipe(["ls"], {"stdin": ""})`: "unknown option stdin",
+	} {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New("use sys\n" + src)).ParseProgram())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(bytecode)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	got := runVmProgram(t, `use scheduler
+let log: string = ""
+let every: int = scheduler.schedule("* * * * * *", func() -> void
+    log = log + "s"
+end)
+scheduler.schedule("@yearly", func() -> void
+    log = log + "y"
+end)
+let due: int = scheduler.next_run()
+let ran: int = scheduler.tick(due)
+let later: bool = scheduler.next_run() > due
+scheduler.unschedule(every)
+test_report(f"{ran} {log} {later} {scheduler.tick(due + 5)}")`, VMConfig{})
+	testExpectedObject(t, "1 s true 0", got)
+
+	// run() sleeps until a job is due and returns after stop()
+	start := time.Now()
+	got = runVmProgram(t, `use scheduler
+let runs: int = 0
+scheduler.schedule("* * * * * *", func() -> void
+    runs = runs + 1
+    scheduler.stop()
+end)
+scheduler.run()
+test_report(runs)`, VMConfig{})
+	testExpectedObject(t, int64(1), got)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("run took %s", elapsed)
+	}
+
+	for src, want := range map[string]string{
+		`cron.next("* * *")`:        `expected 5 or 6 fields in "* * *", got 3`,
+		`cron.next("0 0 30 feb *")`: `"0 0 30 feb *" never matches`,
+		`cron.next("@often", 0)`:    `unknown macro "@often"`,
+		`use scheduler
+scheduler.schedule("61 * * * *", func() -> void end)`: "minute 61 out of range 0-59",
+	} {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New("use cron\n" + src)).ParseProgram())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(bytecode)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	got := runVmProgram(t, `use image
+let img: image = image.new(20, 10, "#ffffff")
+image.fill_rect(img, 2, 2, 4, 4, [0, 0, 255])
+image.draw_rect(img, 10, 0, 5, 5, "#f00")
+image.fill_rect(img, 18, 8, 10, 10, "#00000080")
+image.set_pixel(img, 19, 0, "#ff000080")
+image.draw_line(img, 0, 9, 9, 9, "#0f0")
+let back: image = image.decode(image.encode_png(img))
+test_report([
+    image.get_pixel(back, 3, 3), image.get_pixel(back, 10, 4), image.get_pixel(back, 12, 2),
+    image.get_pixel(back, 19, 9), image.get_pixel(back, 19, 0), image.get_pixel(back, 9, 9),
+    [image.width(back), image.height(back)]
+])`, VMConfig{})
+	testExpectedObject(t, "[[0, 0, 255, 255], [255, 0, 0, 255], [255, 255, 255, 255], [127, 127, 127, 255], [255, 0, 0, 128], [0, 255, 0, 255], [20, 10]]", got)
+
+	// Text is drawn in a 5x7 font on a 6x8 grid
+	got = runVmProgram(t, `use image
+let img: image = image.new(12, 8)
+image.draw_text(img, 0, 0, "T", "#000", 2)
+let row: string = ""
+let x: int = 0
+while x < 12 do
+    row = row + to_str(image.get_pixel(img, x, 0)[3] / 255)
+    x = x + 1
+end
+test_report(f"{row} {image.text_size("Hi", 2)} {image.text_size("a\nbcd")}")`, VMConfig{})
+	testExpectedObject(t, "111111111100 [22, 14] [17, 15]", got)
+
+	// Shrinking averages; crop copies
+	got = runVmProgram(t, `use image
+let img: image = image.new(4, 2, "#000")
+image.fill_rect(img, 2, 0, 2, 2, "#fff")
+let jpeg: bytes = image.encode_jpeg(img, 80)
+test_report([
+    image.get_pixel(image.resize(img, 1, 1), 0, 0),
+    image.get_pixel(image.crop(img, 1, 0, 2, 1), 1, 0),
+    image.width(image.decode(jpeg))
+])`, VMConfig{})
+	testExpectedObject(t, "[[128, 128, 128, 255], [255, 255, 255, 255], 4]", got)
+
+	for src, want := range map[string]string{
+		`image.new(0, 10)`:                                       "invalid image size 0x10",
+		`image.new(100000, 100000)`:                              "invalid image size 100000x100000",
+		`image.new(2, 2, "red")`:                                 `invalid color "red"`,
+		`image.new(2, 2, [1, 2])`:                                "a color array has 3 or 4 elements, got 2",
+		`image.new(2, 2, [1, 2, 300])`:                           "color components must be ints 0-255, got 300",
+		`image.get_pixel(image.new(2, 2), 2, 0)`:                 "(2, 0) is outside the 2x2 image",
+		`image.decode(b"not an image")`:                          "unknown format",
+		`image.crop(image.new(2, 2), 5, 5, 1, 1)`:                "the rectangle is outside the 2x2 image",
+		`image.encode_jpeg(image.new(2, 2), 0)`:                  "quality must be 1-100, got 0",
+		`image.draw_text(image.new(2, 2), 0, 0, "x", "#000", 0)`: "scale must be 1-64, got 0",
+	} {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New("use image\n" + src)).ParseProgram())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(bytecode)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestBarcodes(t *testing.T) {
+	got := runVmProgram(t, `use qr
+use image
+let rows: string[] = qr.matrix("HELLO WORLD", "Q")
+let img: image = qr.encode("HELLO WORLD", {"scale": 2, "border": 1, "color": "#00f"})
+test_report([
+    length(rows), rows[0], rows[6],
+    [image.width(img), image.height(img)],
+    image.get_pixel(img, 0, 0), image.get_pixel(img, 2, 2)
+])`, VMConfig{})
+	testExpectedObject(t, `[21, "111111101100001111111", "111111101010101111111", [46, 46], [255, 255, 255, 255], [0, 0, 255, 255]]`, got)
+
+	// Start B, "H", "I", the checksum and the stop code
+	got = runVmProgram(t, `use code128
+use image
+use strings
+let bars: string = code128.pattern("HI")
+let img: image = code128.encode("HI", {"scale": 1, "height": 5, "border": 0})
+test_report([
+    length(bars), strings.substring(bars, 0, 11),
+    [image.width(img), image.height(img)],
+    image.get_pixel(img, 0, 4)[0], image.get_pixel(img, 2, 4)[0]
+])`, VMConfig{})
+	testExpectedObject(t, `[57, "11010010000", [57, 5], 0, 255]`, got)
+
+	for src, want := range map[string]string{
+		`qr.encode("x", {"level": "Z"})`:            `invalid error correction level "Z"`,
+		`qr.encode("x", {"height": 5})`:             `unknown option "height"`,
+		`qr.encode("x", {"scale": 0})`:              `option "scale" must be an int from 1 to 64, got 0`,
+		`qr.matrix(strings.repeat("x", 3000), "H")`: "too long for a QR code at level H",
+		`code128.pattern("")`:                       "nothing to encode",
+		`code128.encode("a\tb")`:                    "cannot be encoded in Code 128",
+		`code128.encode("x", {"color": "black"})`:   `invalid color "black"`,
+	} {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New("use qr\nuse code128\nuse strings\n" + src)).ParseProgram())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(bytecode)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	got := runVmProgram(t, `use markdown
+let doc: string = "# Notes\n\n- **bold** and `+"`code`"+`\n- [site](https://x.io)\n\n<b>raw</b>"
+test_report(markdown.to_html(doc))`, VMConfig{})
+	testExpectedObject(t, `<h1 id="notes">Notes</h1>
+<ul>
+<li><strong>bold</strong> and <code>code</code></li>
+<li><a href="https://x.io">site</a></li>
+</ul>
+<p>&lt;b&gt;raw&lt;/b&gt;</p>
+`, got)
+
+	got = runVmProgram(t, `use markdown
+use http_parser select *
+use http_router select *
+let r: Router = new_router()
+on_get(ref r, "/doc", func(ctx: Context) -> HttpResponse
+    return html_response(200, markdown_to_html("*hi*"))
+end)
+let headers: string[64]
+let res: HttpResponse = router_handler(r)(HttpRequest("GET", "/doc", "", "HTTP/1.1", headers, 0, to_bytes("")))
+test_report(f"{res.status_code} {get_header(res.headers, res.header_count, "Content-Type")} {to_str(res.body)}")`, VMConfig{})
+	testExpectedObject(t, "200 text/html; charset=utf-8 <p><em>hi</em></p>\n", got)
+}
+
+func TestStats(t *testing.T) {
+	tests := []vmTestCase{
+		{`stats_sum([1, 2, 3])`, 6},
+		{`stats_sum([])`, 0},
+		{`stats_sum([0.1, 0.2, 0.3])`, "0.6"},
+		{`stats_sum([10000000000000000.0, 1.0, -10000000000000000.0])`, "1.0"},
+		{`stats_mean([1, 2, 3, 4])`, "2.5"},
+		{`stats_median([5, 1, 3])`, "3.0"},
+		{`stats_median([4, 1, 3, 2])`, "2.5"},
+		{`stats_stddev([2, 4, 4, 4, 5, 5, 7, 9])`, "2.0"},
+		{`stats_stddev([1, 2, 3, 4], true)`, "1.2909944487358056"},
+		// A large offset does not swamp a small spread
+		{`stats_stddev([1000000000.0 + 4, 1000000000.0 + 7, 1000000000.0 + 13, 1000000000.0 + 16], true)`, "5.477225575051661"},
+		{`stats_percentile([15, 20, 35, 40, 50], 40)`, "29.0"},
+		{`stats_percentile([3, 1, 2], 100)`, "3.0"},
+		{`stats_percentile([7], 0)`, "7.0"},
+		{`stats_histogram([1, 2, 2, 3, 4, 5], 4)["counts"]`, "[1, 2, 1, 2]"},
+		{`stats_histogram([1, 2, 2, 3, 4, 5], 4)["edges"]`, "[1.0, 2.0, 3.0, 4.0, 5.0]"},
+		{`stats_histogram([0.1, 0.3, 0.7, 1.5, -1], 2, 0, 1)["counts"]`, "[2, 1]"},
+		{`stats_histogram([3, 3], 2)["edges"]`, "[2.5, 3.0, 3.5]"},
+	}
+	runVmTests(t, tests)
+
+	errs := []struct{ src, want string }{
+		{`stats_mean([])`, "stats.mean: the array is empty"},
+		{`stats_sum([1, "2"])`, "stats.sum: element 1 is string, not a number"},
+		{`stats_sum([9223372036854775807, 1])`, "stats.sum: the sum overflows int at element 1"},
+		{`stats_stddev([1], true)`, "needs at least 2 elements"},
+		{`stats_percentile([1, 2], 101)`, "percentile must be from 0 to 100, got 101"},
+		{`stats_histogram([1], 0)`, "bins must be from 1 to 100000, got 0"},
+		{`stats_histogram([1], 2, 0)`, "pass both lo and hi, or neither"},
+		{`stats_histogram([1], 2, 1, 1)`, "lo must be less than hi"},
+	}
+	for _, tc := range errs {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestNDArray(t *testing.T) {
+	tests := []vmTestCase{
+		{`ndarray_from([[1, 2], [3, 4]])`, "ndarray([[1.0, 2.0], [3.0, 4.0]])"},
+		{`ndarray_new([2, 3], 1)`, "ndarray([[1.0, 1.0, 1.0], [1.0, 1.0, 1.0]])"},
+		{`ndarray_shape(ndarray_new([2, 3]))`, "[2, 3]"},
+		{`ndarray_from([1, 2, 3]) * 2 + ndarray_from([1, 1, 1])`, "ndarray([3.0, 5.0, 7.0])"},
+		{`1 - ndarray_from([1, 2]) / 4`, "ndarray([0.75, 0.5])"},
+		{`-ndarray_from([1, 0])`, "ndarray([-1.0, -0.0])"},
+		{`ndarray_from([1, -1]) / 0`, "ndarray([+Inf, -Inf])"},
+		{`ndarray_dot(ndarray_from([[1, 2], [3, 4]]), ndarray_from([[5, 6], [7, 8]]))`, "ndarray([[19.0, 22.0], [43.0, 50.0]])"},
+		{`ndarray_dot(ndarray_from([[1, 2], [3, 4]]), ndarray_from([1, 1]))`, "ndarray([3.0, 7.0])"},
+		{`ndarray_dot(ndarray_from([1, 2, 3]), ndarray_from([4, 5, 6]))`, "32.0"},
+		{`ndarray_transpose(ndarray_from([[1, 2, 3], [4, 5, 6]]))`, "ndarray([[1.0, 4.0], [2.0, 5.0], [3.0, 6.0]])"},
+		{`ndarray_slice(ndarray_from([[1, 2], [3, 4], [5, 6]]), 1, 3)`, "ndarray([[3.0, 4.0], [5.0, 6.0]])"},
+		{`ndarray_slice(ndarray_from([[1, 2], [3, 4]]), 1, 2, 1)`, "ndarray([[2.0], [4.0]])"},
+		{`ndarray_reshape(ndarray_from([1, 2, 3, 4]), [2, 2])`, "ndarray([[1.0, 2.0], [3.0, 4.0]])"},
+		{`ndarray_to_array(ndarray_from([[1, 2]]))`, "[[1.0, 2.0]]"},
+		{`ndarray_get(ndarray_from([[1, 2], [3, 4]]), 1, 0)`, "3.0"},
+		{`fmt("%T", ndarray_new(1))`, "ndarray"},
+	}
+	runVmTests(t, tests)
+
+	// set changes the array in place, but arguments are copies
+	got := runVmProgram(t, `let m: ndarray = ndarray_new([2, 2])
+ndarray_set(m, 0, 1, 5)
+func clear(a: ndarray) -> void
+    ndarray_set(a, 0, 1, 0)
+end
+clear(m)
+let doubled: ndarray = 2 * m
+test_report(ndarray_get(doubled, 0, 1))`, VMConfig{})
+	testExpectedObject(t, "10.0", got)
+
+	errs := []struct{ src, want string }{
+		{`ndarray_from([[1, 2], [3]])`, "xs[1] has 1 elements, not 2"},
+		{`ndarray_from([1, "a"])`, "xs[1] is string, not a number"},
+		{`ndarray_new([2, -1])`, "dimensions must not be negative"},
+		{`ndarray_new(1) + ndarray_new(2)`, "shapes [1] and [2] do not match"},
+		{`ndarray_new(1) + "a"`, "operands must be"},
+		{`ndarray_dot(ndarray_new([2, 3]), ndarray_new([2, 3]))`, "shapes [2, 3] and [2, 3] are not aligned"},
+		{`ndarray_dot(ndarray_new(2), ndarray_new(3))`, "two vectors of the same length"},
+		{`ndarray_get(ndarray_new([2, 2]), 2, 0)`, "index 2 is out of range for axis 0 of length 2"},
+		{`ndarray_get(ndarray_new([2, 2]), 0)`, "takes 2 indexes, got 1"},
+		{`ndarray_set(ndarray_new(2), 0.5, 1)`, "index 0 is float, not int"},
+		{`ndarray_slice(ndarray_new(2), 1, 3)`, "slice 1:3 is out of range"},
+		{`ndarray_reshape(ndarray_new(6), [4])`, "cannot reshape 6 elements"},
+	}
+	for _, tc := range errs {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestTypedArrays(t *testing.T) {
+	// int[] and float[] keep unboxed storage; ints stored in a float[]
+	// become floats
+	got := runVmProgram(t, `let xs: float[] = [1, 2.5]
+append(xs, 3)
+let ys: int[] = zeros(3)
+ys[1] = 7
+append(ys, 4)
+let last: int = pop(ys)
+let total: float = 0.0
+for x in xs do
+    total = total + x
+end
+func scale(v: float[], k: float) -> float[]
+    for i in 0..length(v) do
+        v[i] = v[i] * k
+    end
+    return v
+end
+test_report([xs, ys, last, total, slice(xs, 1, 3), scale(xs, 2.0), xs, contains(ys, 7)])`, VMConfig{})
+	testExpectedObject(t, "[[1.0, 2.5, 3.0], [0, 7, 0], 4, 6.5, [2.5, 3.0], [2.0, 5.0, 6.0], [1.0, 2.5, 3.0], true]", got)
+
+	// Storing another type through an any alias makes the array generic
+	// without losing the aliasing
+	got = runVmProgram(t, `let xs: int[] = [1, 2]
+let a: any = xs
+a[0] = "one"
+test_report([xs, length(xs)])`, VMConfig{})
+	testExpectedObject(t, `[["one", 2], 2]`, got)
+
+	errs := []struct{ src, want string }{
+		{"let xs: int[] = [1, 2]\nxs[2]", "index out of bounds"},
+		{"let xs: float[] = [1.0]\nxs[-2] = 1.0", "index out of bounds"},
+		{"let xs: int[] = freeze([1, 2])\nxs[0] = 3", "frozen"},
+		{"let x: float = 1.0\nlet y: float = 0.0\nx / y", "division by zero"},
+	}
+	for _, tc := range errs {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = New().Interpret(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	// Each call makes a new variable; closures over one share it
+	got := runVmProgram(t, `func make_counter() -> func[]
+    let n: int = 0
+    let inc: func = func() -> int
+        n = n + 1
+        return n
+    end
+    let get: func = func() -> int
+        return n
+    end
+    return [inc, get]
+end
+let a: func[] = make_counter()
+let b: func[] = make_counter()
+a[0]()
+a[0]()
+b[0]()
+func outer() -> func
+    let x: int = 1
+    return func() -> func
+        return func() -> int
+            x = x * 10
+            return x
+        end
+    end
+end
+let deep: func = outer()()
+deep()
+test_report([a[1](), b[1](), deep()])`, VMConfig{})
+	testExpectedObject(t, "[2, 1, 100]", got)
+
+	// Every iteration has its own variables, including the one that
+	// breaks out of the loop
+	got = runVmProgram(t, `let fs: func[] = []
+for i in 0..3 do
+    append(fs, func() -> int
+        return i
+    end)
+end
+for x in [10, 20, 30] do
+    let y: int = x
+    append(fs, func() -> int
+        return y
+    end)
+    if x == 20 then
+        break
+    end
+end
+let k: int = 0
+while true do
+    let v: int = k * 100
+    append(fs, func() -> int
+        return v
+    end)
+    if k == 1 then
+        break
+    end
+    k = k + 1
+end
+let clobber: int = -1
+let out: int[] = []
+for f in fs do
+    append(out, f())
+end
+test_report(out)`, VMConfig{})
+	testExpectedObject(t, "[0, 1, 2, 10, 20, 0, 100]", got)
+
+	// Local functions can call themselves and each other; nested ones do
+	// not leak out as globals
+	got = runVmProgram(t, `func parity(n: int) -> string
+    func is_even(k: int) -> bool
+        if k == 0 then
+            return true
+        end
+        return is_odd(k - 1)
+    end
+    func is_odd(k: int) -> bool
+        if k == 0 then
+            return false
+        end
+        return is_even(k - 1)
+    end
+    let fact: func = func(k: int) -> int
+        if k <= 1 then
+            return 1
+        end
+        return k * fact(k - 1)
+    end
+    if is_even(n) then
+        return f"even {fact(n)}"
+    end
+    return f"odd {fact(n)}"
+end
+func bump(x: ref int) -> func
+    return func()
+        *x = x + 1
+    end
+end
+let v: int = 1
+bump(ref v)()
+test_report(f"{parity(4)} {parity(5)} {v}")`, VMConfig{})
+	testExpectedObject(t, "even 24 odd 120 2", got)
+
+	for src, want := range map[string]string{
+		"func f()\n    func g()\n    end\nend\nf()\ng()":                                           "undefined global variable 'g'",
+		"func f() -> func\n    let n: int = 0\n    return func()\n        n = \"x\"\n    end\nend": "type mismatch in assignment to 'n': expected int, got string",
+	} {
+		bytecode, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err == nil {
+			err = New().Interpret(bytecode)
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestElif(t *testing.T) {
+	got := runVmProgram(t, `func classify(x: int) -> string
+    if x < 0 then
+        return "neg"
+    elif x == 0 then
+        return "zero"
+    elif x < 10 then
+        if x == 5 then
+            return "five"
+        end
+        return "small"
+    else
+        return "big"
+    end
+end
+let out: string = ""
+for x in [-1, 0, 5, 3, 20] do
+    out = out + classify(x) + " "
+end
+// Without an else, no branch may run; after the chain, execution continues
+let y: int = 7
+if y == 1 then
+    out = out + "one"
+elif y == 2 then
+    out = out + "two"
+end
+let i: int = 0
+while true do
+    i = i + 1
+    if i % 2 == 0 then
+        out = out + "e"
+    elif i > 4 then
+        break
+    elif i == 3 then
+        out = out + "3"
+    end
+end
+test_report(out)`, VMConfig{})
+	testExpectedObject(t, "neg zero five small big e3e", got)
+}
+
+func TestForIn(t *testing.T) {
+	got := runVmProgram(t, `let out: string = ""
+let n: int = 4
+for i in 0..n do
+    out = out + to_str(i)
+    i = 10
+end
+for i in 3..1 do
+    out = out + "never"
+end
+let lo: any = 7
+for i in lo..lo + 2 do
+    out = out + to_str(i)
+end
+out = out + " "
+for x in [1, 2, 3, 4] do
+    if x == 3 then
+        break
+    end
+    out = out + to_str(x)
+end
+for k in {"a": 1, "b": 2} do
+    for c in "xyz" do
+        if c == "y" then
+            break
+        end
+        out = out + k + c
+    end
+end
+for i in 0..100 do
+    if i * i > 10 then
+        out = out + f" {i}"
+        break
+    end
+end
+test_report(out)`, VMConfig{})
+	testExpectedObject(t, "012378 12axbx 4", got)
+
+	for src, want := range map[string]string{
+		"for i in 0..1.5 do\nend":       "range bounds must be int, got float in 0..1.5",
+		"for i in \"a\"..\"c\" do\nend": "range bounds must be int, got string in a..c",
+	} {
+		_, _, err := compiler.New().Compile(parser.New(lexer.New(src)).ParseProgram())
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", src, err, want)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	// self is the instance itself, so methods can update it, including
+	// through refs, array elements and any
+	got := runVmProgram(t, `struct Point
+    x: int
+    y: int
+
+    func norm1(self) -> int
+        return self.x + self.y
+    end
+
+    func move(self, dx: int, dy: int)
+        self.x = self.x + dx
+        self.y = self.y + dy
+    end
+
+    func plus(self, o: Point) -> Point
+        return Point(self.x + o.x, self.y + o.y)
+    end
+end
+func shift(p: ref Point)
+    p.move(100, 0)
+end
+let p: Point = Point(1, 2)
+p.move(1, 1)
+let ps: Point[] = [Point(0, 0), Point(5, 5)]
+ps[1].move(1, 0)
+shift(ref p)
+let a: any = p
+test_report([p.norm1(), p.plus(Point(1, 1)).plus(ps[1]).norm1(), ps[1].x, a.norm1()])`, VMConfig{})
+	testExpectedObject(t, "[105, 118, 6, 105]", got)
+
+	// Methods call each other through self, see the variables around a
+	// local struct, and a field holding a function is called like one
+	got = runVmProgram(t, `func total(base: int) -> int
+    struct Acc
+        v: int
+        step: func
+
+        func twice(self, n: int) -> Acc
+            return self.add(n).add(n)
+        end
+
+        func add(self, n: int) -> Acc
+            return Acc(self.v + self.step(n) + base, self.step)
+        end
+    end
+    return Acc(0, func(n: int) -> int return n * 10 end).twice(5).v
+end
+struct Node
+    val: int
+    next: any
+
+    func sum(self) -> int
+        if self.next == null then
+            return self.val
+        end
+        return self.val + self.next.sum()
+    end
+end
+use strings
+test_report([total(1), total(100), Node(1, Node(2, Node(3, null))).sum(), strings.repeat("ab", 2)])`, VMConfig{})
+	testExpectedObject(t, `[102, 300, 6, "abab"]`, got)
+
+	tests := []struct{ src, want string }{
+		{`struct P
+    x: int
+    func x(self) end
+end`, "struct P already has a field or method 'x'"},
+		{`struct P
+    x: int
+    func f(self) end
+end
+let p: P = P(1)
+p.g()`, "struct P has no field or method 'g'"},
+		{`struct P
+    x: int
+    func f(self) end
+end
+let p: P = P(1)
+let f: any = p.f`, "method 'f' of struct P can only be called, as in x.f()"},
+		{`struct P
+    x: int
+    func fly(self) end
+end
+let p: any = P(1)
+p.fyl()`, "undefined property 'fyl'; did you mean 'fly'?"},
+		{`struct P
+    x: int
+    func fly(self, n: int) end
+end
+P(1).fly()`, "function 'P.fly' expects 1 arguments but got 0"},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.src)).ParseProgram()
+		c, _, err := compiler.New().Compile(program)
+		if err == nil {
+			err = New().Interpret(c)
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+func TestPropertyCache(t *testing.T) {
+	// One property instruction sees instances of structs that keep the
+	// field at different slots, so its cache must follow the struct
+	got := runVmProgram(t, `struct A
+    name: string
+    n: int
+end
+struct B
+    n: int
+    extra: bool
+    name: string
+end
+func bump(x: any) -> string
+    x.n = x.n + 1
+    return f"{x.name}{x.n}"
+end
+let a: A = A("a", 1)
+let b: B = B(10, true, "b")
+let names: string[] = []
+for i in 0..3 do
+    append(names, bump(a))
+    append(names, bump(b))
+end
+test_report([names, a.n, b.extra])`, VMConfig{})
+	testExpectedObject(t, `[["a2", "b11", "a2", "b11", "a2", "b11"], 1, true]`, got)
+
+	// Fields are fixed by the struct, even through any
+	program := parser.New(lexer.New("struct P\n    x: int\nend\nlet p: any = P(1)\np.xx = 2")).ParseProgram()
+	c, _, err := compiler.New().Compile(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = New().Interpret(c)
+	if want := "struct P has no field 'xx'; did you mean 'x'?"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want an error containing %q", err, want)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// Errors raised in called functions unwind to the try
+		{`func at(xs: int[], i: int) -> int
+    return xs[i]
+end
+let out: string = ""
+try
+    at([1, 2], 5)
+    out = "not caught"
+catch e
+    out = e.message
+end
+test_report(out)`, "array index out of bounds"},
+		{`let out: string = ""
+try
+    throw error("not found", 404)
+catch e
+    out = f"{e.code} {e.message} {is_error(e)} {e}"
+end
+test_report(out)`, "404 not found true error: not found (code 404)"},
+		// A thrown string becomes an error with code 0
+		{`let out: string = ""
+try
+    throw "bad input"
+catch e
+    out = f"{e.message} {e.code}"
+end
+test_report(out)`, "bad input 0"},
+		// Rethrowing reaches the outer try with the same error
+		{`let out: string = ""
+try
+    try
+        to_int("x")
+    catch e
+        throw e
+    end
+catch outer
+    out = outer.message
+end
+test_report(out)`, `to_int: cannot convert "x" to int`},
+		// break and return leave a try without disturbing later errors
+		{`func first(xs: int[]) -> int
+    for x in xs do
+        try
+            return x
+        catch
+        end
+    end
+    return 0
+end
+let n: int = 0
+while true do
+    try
+        break
+    catch
+    end
+end
+let out: string = "none"
+try
+    n = first([7])
+    throw error("after", 1)
+catch e
+    out = f"{n} {e.message}"
+end
+test_report(out)`, "7 after"},
+		{`let e: error = error("x")
+test_report(f"{is_error(e)} {is_error(\"x\")} {is_error(null)}")`, "true false false"},
+	}
+	for _, tt := range tests {
+		testExpectedObject(t, tt.want, runVmProgram(t, tt.src, VMConfig{}))
+	}
+
+	// An uncaught throw is a runtime error at the throw
+	program := parser.New(lexer.New("let x: int = 1\nthrow error(\"fatal\", 2)")).ParseProgram()
+	c, _, err := compiler.NewWithState(make(map[string]ast.NoxyType), make(map[string]*ast.StructStatement), "main.nx").Compile(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = New().Interpret(c)
+	if err == nil || err.Error() != "[main.nx:line 2] fatal (code 2)" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(plugin.PluginResponse{Error: "no such item", Code: 404})
+	}))
+	defer server.Close()
+
+	// Failing natives and plugin methods return errors rather than null
+	dir := t.TempDir()
+	src := fmt.Sprintf(`use io
+use sqlite
+use plugin "%s" as store
+let f: File = io.open("out.txt", "w")
+io.close(f)
+let w: any = io.write(f, "late")
+let db: any = sqlite.open(":memory:")
+let stmt: any = sqlite.prepare(db, "SELEC 1")
+let r: any = store.get_item("k")
+test_report(f"{w.message} | {is_error(stmt)} | {r.message} {r.code}")`, server.URL)
+	captured := runVmProgram(t, src, VMConfig{RootPath: dir, WorkDir: dir})
+	testExpectedObject(t, "out.txt is not open | true | no such item 404", captured)
+}
+
+func TestResultStructs(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "in.txt"), []byte("a\nb"), 0644)
+
+	// io, net and sqlite all return their module's result struct
+	src := `use io
+use net
+use sqlite
+let f: File = io.open("in.txt", "r")
+let lines: IOLinesResult = io.read_lines(f)
+io.close(f)
+let closed: IOResult = io.read(f)
+let sock: Socket = net.connect("127.0.0.1", 1)
+let sent: NetResult = net.socket_send(sock, to_bytes("x"))
+let db: Database = sqlite.open(":memory:")
+let made: ExecResult = sqlite.exec(db, "CREATE TABLE t (n INTEGER)")
+let bad: ExecResult = sqlite.exec(db, "INSERT INTO nope VALUES (1)")
+sqlite.exec(db, "INSERT INTO t VALUES (7)")
+let q: QueryResult = sqlite.query(db, "SELECT n FROM t")
+test_report(f"{lines.ok} {lines.data} | {closed.ok} {closed.error} | {sent.ok} {sent.error} | {made.ok} {bad.ok} {length(bad.error) > 0} | {q.ok} {q.row_count} {q.rows[0].values} | {sent}")`
+	got := runVmProgram(t, src, VMConfig{RootPath: dir, WorkDir: dir})
+	testExpectedObject(t, `true ["a", "b"] | false File not open | false invalid socket | true false true | true 1 [7] | NetResult(ok: false, data: b"", count: 0, error: "invalid socket")`, got)
+
+	// Scripts calling the natives the old way still get the old shapes
+	src = `use sqlite select *
+let db: Database = sqlite_open(":memory:", Database(0, false))
+let r: ExecResult = sqlite_exec(db, "SELECT 1", ExecResult(false, "", 0, 0))
+let m: any = net_recv({"fd": -1}, 10)
+test_report(f"{r.ok} {m}")`
+	got = runVmProgram(t, src, VMConfig{RootPath: dir, WorkDir: dir})
+	testExpectedObject(t, `true {"count": 0, "data": b"", "error": "invalid socket", "ok": false}`, got)
+}
+
+func TestCoalesce(t *testing.T) {
+	// The right side is only evaluated when the left is null
+	src := `let counts: map[string, int] = {}
+for w in ["a", "b", "a"] do
+    counts[w] = (counts[w] ?? 0) + 1
+end
+let calls: int = 0
+func fallback() -> string
+    calls = calls + 1
+    return "fallback"
+end
+let name: string = null
+let first: string = name ?? fallback()
+let second: string = "set" ?? fallback()
+let falsy: bool = false ?? true
+test_report(f"{counts} {first} {second} {calls} {falsy} {null ?? null ?? 3}")`
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, `{"a": 2, "b": 1} fallback set 1 false 3`, got)
+}
+
+func TestComparisonChain(t *testing.T) {
+	// Middle operands are evaluated once, and the chain stops at the
+	// first comparison that fails
+	src := `let calls: string = ""
+func v(n: int) -> int
+    calls = calls + to_str(n)
+    return n
+end
+func in_range(i: int, n: int) -> bool
+    return 0 <= i < n
+end
+let a: bool = v(1) < v(2) <= v(2) < v(3)
+let b: bool = v(4) > v(5) > v(6)
+let c: bool = 1.5 < 2 < 2.5
+let d: bool = "a" < "b" < "b"
+test_report(f"{a} {b} {c} {d} {calls} {in_range(3, 5)} {in_range(5, 5)} {in_range(-1, 5)}")`
+	got := runVmProgram(t, src, VMConfig{})
+	testExpectedObject(t, "true false true false 122345 true false false", got)
 }
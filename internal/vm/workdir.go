@@ -0,0 +1,71 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// absWorkDir makes VMConfig.WorkDir absolute, so a later change of the
+// process working directory does not move it.
+func absWorkDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// workDir returns the script's working directory, or "" when it is the
+// process working directory.
+func (vm *VM) workDir() string {
+	vm.shared.GlobalsLock.RLock()
+	defer vm.shared.GlobalsLock.RUnlock()
+	return vm.shared.WorkDir
+}
+
+// Getwd returns the script's working directory as an absolute path.
+func (vm *VM) Getwd() (string, error) {
+	if dir := vm.workDir(); dir != "" {
+		return dir, nil
+	}
+	return os.Getwd()
+}
+
+// ResolvePath makes a relative path relative to the script's working
+// directory. Absolute paths, and every path while the script uses the
+// process working directory, are returned unchanged.
+func (vm *VM) ResolvePath(path string) string {
+	dir := vm.workDir()
+	if dir == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Chdir changes the script's working directory to dir, which must exist.
+// Only this VM and the threads it spawned see the change; the process
+// working directory stays where it was.
+func (vm *VM) Chdir(dir string) error {
+	cwd, err := vm.Getwd()
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cwd, dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	vm.shared.GlobalsLock.Lock()
+	defer vm.shared.GlobalsLock.Unlock()
+	vm.shared.WorkDir = filepath.Clean(dir)
+	return nil
+}
@@ -4,14 +4,20 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
 	"github.com/google/uuid"
 )
 
@@ -30,6 +36,10 @@ type PluginResponse struct {
 var (
 	Clients     = make(map[string]*dynamodb.Client)
 	ClientsLock sync.Mutex
+
+	// LastError describes the most recent failed request, or is nil if the
+	// last request succeeded. Requests are handled one at a time.
+	LastError map[string]interface{}
 )
 
 func main() {
@@ -59,6 +69,9 @@ func main() {
 		if err != nil {
 			response.Error = err.Error()
 		}
+		if req.Method != "last_error" {
+			LastError = describeError(err)
+		}
 
 		if err := encoder.Encode(response); err != nil {
 			// Panic or log?
@@ -75,6 +88,8 @@ func handleRequest(req PluginRequest) (interface{}, error) {
 	switch req.Method {
 	case "connect":
 		return handleConnect(req.Params)
+	case "last_error":
+		return LastError, nil
 	case "put_item":
 		return handlePutItem(req.Params)
 	case "get_item":
@@ -87,6 +102,18 @@ func handleRequest(req PluginRequest) (interface{}, error) {
 		return handleScan(req.Params)
 	case "query":
 		return handleQuery(req.Params)
+	case "transact_write":
+		return handleTransactWrite(req.Params)
+	case "transact_get":
+		return handleTransactGet(req.Params)
+	case "create_table":
+		return handleCreateTable(req.Params)
+	case "delete_table":
+		return handleDeleteTable(req.Params)
+	case "describe_table":
+		return handleDescribeTable(req.Params)
+	case "update_ttl":
+		return handleUpdateTTL(req.Params)
 	default:
 		return nil, fmt.Errorf("unknown method: %s", req.Method)
 	}
@@ -109,12 +136,54 @@ func handleConnect(params []interface{}) (interface{}, error) {
 		region = r
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	loadOpts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+
+	if profile, ok := options["profile"].(string); ok && profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(profile))
+	}
+
+	// Static credentials take precedence over the default provider chain.
+	accessKey, _ := options["access_key"].(string)
+	secretKey, _ := options["secret_key"].(string)
+	sessionToken, _ := options["session_token"].(string)
+	if accessKey != "" || secretKey != "" {
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("access_key and secret_key must be given together")
+		}
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(accessKey, secretKey, sessionToken),
+		))
+	}
+
+	// Retry settings: max_attempts (total tries, 1 disables retries) and
+	// max_backoff_ms (upper bound on the delay between attempts).
+	maxAttempts, _ := options["max_attempts"].(float64)
+	maxBackoff, _ := options["max_backoff_ms"].(float64)
+	if maxAttempts > 0 || maxBackoff > 0 {
+		loadOpts = append(loadOpts, config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				if maxAttempts > 0 {
+					o.MaxAttempts = int(maxAttempts)
+				}
+				if maxBackoff > 0 {
+					o.MaxBackoff = time.Duration(maxBackoff) * time.Millisecond
+				}
+			})
+		}))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load aws config: %v", err)
 	}
 
-	client := dynamodb.NewFromConfig(cfg)
+	// endpoint_url points the client at DynamoDB Local, LocalStack, etc.
+	endpoint, _ := options["endpoint_url"].(string)
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
 	clientId := uuid.New().String()
 
 	ClientsLock.Lock()
@@ -291,7 +360,7 @@ func handleUpdateItem(params []interface{}) (interface{}, error) {
 }
 
 func handleScan(params []interface{}) (interface{}, error) {
-	// Params: [clientId, tableName, limit?]
+	// Params: [clientId, tableName, options?]
 	if len(params) < 2 {
 		return nil, fmt.Errorf("expected client_id, table")
 	}
@@ -304,9 +373,26 @@ func handleScan(params []interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("client not found: %s", clientId)
 	}
 
-	// Basic scan, maybe add filter expressions later if requested
+	opts, err := parseReadOptions(params, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	avVals, err := attributevalue.MarshalMap(opts.values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal scan values: %v", err)
+	}
+
 	in := &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
+		TableName:                aws.String(tableName),
+		FilterExpression:         opts.filter,
+		ProjectionExpression:     opts.projection,
+		IndexName:                opts.index,
+		Limit:                    opts.limit,
+		ExpressionAttributeNames: opts.names,
+	}
+	if len(avVals) > 0 {
+		in.ExpressionAttributeValues = avVals
 	}
 
 	out, err := client.Scan(context.TODO(), in)
@@ -323,7 +409,7 @@ func handleScan(params []interface{}) (interface{}, error) {
 }
 
 func handleQuery(params []interface{}) (interface{}, error) {
-	// Params: [clientId, tableName, keyConditionExpr, exprAttrValues]
+	// Params: [clientId, tableName, keyConditionExpr, exprAttrValues, options?]
 	if len(params) < 4 {
 		return nil, fmt.Errorf("expected client_id, table, keyCondition, exprValues")
 	}
@@ -341,6 +427,17 @@ func handleQuery(params []interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("client not found: %s", clientId)
 	}
 
+	opts, err := parseReadOptions(params, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	// Values used by the filter share the placeholder namespace with the
+	// key condition, so merge them into a single map.
+	for k, v := range opts.values {
+		valMap[k] = v
+	}
+
 	avVals, err := attributevalue.MarshalMap(valMap)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal query values: %v", err)
@@ -350,6 +447,12 @@ func handleQuery(params []interface{}) (interface{}, error) {
 		TableName:                 aws.String(tableName),
 		KeyConditionExpression:    aws.String(keyCond),
 		ExpressionAttributeValues: avVals,
+		FilterExpression:          opts.filter,
+		ProjectionExpression:      opts.projection,
+		IndexName:                 opts.index,
+		Limit:                     opts.limit,
+		ScanIndexForward:          opts.scanForward,
+		ExpressionAttributeNames:  opts.names,
 	}
 
 	out, err := client.Query(context.TODO(), in)
@@ -365,6 +468,519 @@ func handleQuery(params []interface{}) (interface{}, error) {
 	return items, nil
 }
 
+func handleTransactWrite(params []interface{}) (interface{}, error) {
+	// Params: [clientId, ops]
+	// Each op: {type: "put"|"update"|"delete"|"condition_check", table, item|key,
+	//           update_expression?, condition_expression?,
+	//           expression_attribute_names?, expression_attribute_values?}
+	if len(params) < 2 {
+		return nil, fmt.Errorf("expected client_id, ops")
+	}
+
+	clientId, _ := params[0].(string)
+	ops, ok := params[1].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ops must be an array")
+	}
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	items := make([]types.TransactWriteItem, 0, len(ops))
+	for i, raw := range ops {
+		op, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("op %d must be a map", i)
+		}
+		item, err := buildTransactWriteItem(op)
+		if err != nil {
+			return nil, fmt.Errorf("op %d: %v", i, err)
+		}
+		items = append(items, item)
+	}
+
+	_, err := client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func buildTransactWriteItem(op map[string]interface{}) (types.TransactWriteItem, error) {
+	opType, _ := op["type"].(string)
+	tableName, _ := op["table"].(string)
+	if tableName == "" {
+		return types.TransactWriteItem{}, fmt.Errorf("missing table")
+	}
+
+	var cond *string
+	if c, ok := op["condition_expression"].(string); ok && c != "" {
+		cond = aws.String(c)
+	}
+
+	names, err := toNameMap(op["expression_attribute_names"])
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+
+	var avVals map[string]types.AttributeValue
+	if v, ok := op["expression_attribute_values"].(map[string]interface{}); ok && len(v) > 0 {
+		avVals, err = attributevalue.MarshalMap(v)
+		if err != nil {
+			return types.TransactWriteItem{}, fmt.Errorf("failed to marshal expr values: %v", err)
+		}
+	}
+
+	marshalField := func(field string) (map[string]types.AttributeValue, error) {
+		m, ok := op[field].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a map", field)
+		}
+		av, err := attributevalue.MarshalMap(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s: %v", field, err)
+		}
+		return av, nil
+	}
+
+	switch opType {
+	case "put":
+		av, err := marshalField("item")
+		if err != nil {
+			return types.TransactWriteItem{}, err
+		}
+		return types.TransactWriteItem{Put: &types.Put{
+			TableName:                 aws.String(tableName),
+			Item:                      av,
+			ConditionExpression:       cond,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: avVals,
+		}}, nil
+	case "update":
+		avKey, err := marshalField("key")
+		if err != nil {
+			return types.TransactWriteItem{}, err
+		}
+		updateExpr, _ := op["update_expression"].(string)
+		if updateExpr == "" {
+			return types.TransactWriteItem{}, fmt.Errorf("missing update_expression")
+		}
+		return types.TransactWriteItem{Update: &types.Update{
+			TableName:                 aws.String(tableName),
+			Key:                       avKey,
+			UpdateExpression:          aws.String(updateExpr),
+			ConditionExpression:       cond,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: avVals,
+		}}, nil
+	case "delete":
+		avKey, err := marshalField("key")
+		if err != nil {
+			return types.TransactWriteItem{}, err
+		}
+		return types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(tableName),
+			Key:                       avKey,
+			ConditionExpression:       cond,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: avVals,
+		}}, nil
+	case "condition_check":
+		avKey, err := marshalField("key")
+		if err != nil {
+			return types.TransactWriteItem{}, err
+		}
+		if cond == nil {
+			return types.TransactWriteItem{}, fmt.Errorf("condition_check requires condition_expression")
+		}
+		return types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+			TableName:                 aws.String(tableName),
+			Key:                       avKey,
+			ConditionExpression:       cond,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: avVals,
+		}}, nil
+	default:
+		return types.TransactWriteItem{}, fmt.Errorf("unknown op type: %s", opType)
+	}
+}
+
+func handleTransactGet(params []interface{}) (interface{}, error) {
+	// Params: [clientId, gets]
+	// Each get: {table, key, projection_expression?}
+	if len(params) < 2 {
+		return nil, fmt.Errorf("expected client_id, gets")
+	}
+
+	clientId, _ := params[0].(string)
+	gets, ok := params[1].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("gets must be an array")
+	}
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	items := make([]types.TransactGetItem, 0, len(gets))
+	for i, raw := range gets {
+		g, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("get %d must be a map", i)
+		}
+		tableName, _ := g["table"].(string)
+		keyMap, ok := g["key"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("get %d: key must be a map", i)
+		}
+		avKey, err := attributevalue.MarshalMap(keyMap)
+		if err != nil {
+			return nil, fmt.Errorf("get %d: failed to marshal key: %v", i, err)
+		}
+		get := &types.Get{
+			TableName: aws.String(tableName),
+			Key:       avKey,
+		}
+		if p, ok := g["projection_expression"].(string); ok && p != "" {
+			get.ProjectionExpression = aws.String(p)
+		}
+		items = append(items, types.TransactGetItem{Get: get})
+	}
+
+	out, err := client.TransactGetItems(context.TODO(), &dynamodb.TransactGetItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// One entry per requested key, in order; missing items are null.
+	results := make([]interface{}, len(out.Responses))
+	for i, r := range out.Responses {
+		if r.Item == nil {
+			continue
+		}
+		var resMap map[string]interface{}
+		if err := attributevalue.UnmarshalMap(r.Item, &resMap); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal result: %v", err)
+		}
+		results[i] = resMap
+	}
+
+	return results, nil
+}
+
+// tableWaitTimeout bounds how long create_table/delete_table block when
+// asked to wait for the table to become ready (or disappear).
+const tableWaitTimeout = 5 * time.Minute
+
+func handleCreateTable(params []interface{}) (interface{}, error) {
+	// Params: [clientId, tableName, spec]
+	// spec: {partition_key, partition_key_type?, sort_key?, sort_key_type?,
+	//        billing_mode?, read_capacity?, write_capacity?, wait?}
+	if len(params) < 3 {
+		return nil, fmt.Errorf("expected client_id, table, spec")
+	}
+
+	clientId, _ := params[0].(string)
+	tableName, _ := params[1].(string)
+	spec, ok := params[2].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("spec must be a map")
+	}
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	pk, _ := spec["partition_key"].(string)
+	if pk == "" {
+		return nil, fmt.Errorf("spec.partition_key is required")
+	}
+
+	in := &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+	}
+
+	addKey := func(name, typeField string, keyType types.KeyType) {
+		attrType := "S"
+		if t, ok := spec[typeField].(string); ok && t != "" {
+			attrType = t
+		}
+		in.AttributeDefinitions = append(in.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: types.ScalarAttributeType(attrType),
+		})
+		in.KeySchema = append(in.KeySchema, types.KeySchemaElement{
+			AttributeName: aws.String(name),
+			KeyType:       keyType,
+		})
+	}
+
+	addKey(pk, "partition_key_type", types.KeyTypeHash)
+	if sk, ok := spec["sort_key"].(string); ok && sk != "" {
+		addKey(sk, "sort_key_type", types.KeyTypeRange)
+	}
+
+	billing := "PAY_PER_REQUEST"
+	if b, ok := spec["billing_mode"].(string); ok && b != "" {
+		billing = b
+	}
+	in.BillingMode = types.BillingMode(billing)
+	if in.BillingMode == types.BillingModeProvisioned {
+		rcu, _ := spec["read_capacity"].(float64)
+		wcu, _ := spec["write_capacity"].(float64)
+		if rcu <= 0 || wcu <= 0 {
+			return nil, fmt.Errorf("provisioned tables require read_capacity and write_capacity")
+		}
+		in.ProvisionedThroughput = &types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(int64(rcu)),
+			WriteCapacityUnits: aws.Int64(int64(wcu)),
+		}
+	}
+
+	if _, err := client.CreateTable(context.TODO(), in); err != nil {
+		return nil, err
+	}
+
+	if wait, _ := spec["wait"].(bool); wait {
+		waiter := dynamodb.NewTableExistsWaiter(client)
+		err := waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		}, tableWaitTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("waiting for table %s: %v", tableName, err)
+		}
+	}
+
+	return true, nil
+}
+
+func handleDeleteTable(params []interface{}) (interface{}, error) {
+	// Params: [clientId, tableName, wait?]
+	if len(params) < 2 {
+		return nil, fmt.Errorf("expected client_id, table")
+	}
+
+	clientId, _ := params[0].(string)
+	tableName, _ := params[1].(string)
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	_, err := client.DeleteTable(context.TODO(), &dynamodb.DeleteTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params) > 2 {
+		if wait, _ := params[2].(bool); wait {
+			waiter := dynamodb.NewTableNotExistsWaiter(client)
+			err := waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{
+				TableName: aws.String(tableName),
+			}, tableWaitTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("waiting for table %s deletion: %v", tableName, err)
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func handleDescribeTable(params []interface{}) (interface{}, error) {
+	// Params: [clientId, tableName]
+	if len(params) < 2 {
+		return nil, fmt.Errorf("expected client_id, table")
+	}
+
+	clientId, _ := params[0].(string)
+	tableName, _ := params[1].(string)
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	out, err := client.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t := out.Table
+	keys := make([]interface{}, 0, len(t.KeySchema))
+	for _, k := range t.KeySchema {
+		keys = append(keys, map[string]interface{}{
+			"name": aws.ToString(k.AttributeName),
+			"type": string(k.KeyType),
+		})
+	}
+
+	indexes := make([]interface{}, 0, len(t.GlobalSecondaryIndexes))
+	for _, gsi := range t.GlobalSecondaryIndexes {
+		indexes = append(indexes, aws.ToString(gsi.IndexName))
+	}
+
+	billing := ""
+	if t.BillingModeSummary != nil {
+		billing = string(t.BillingModeSummary.BillingMode)
+	}
+
+	return map[string]interface{}{
+		"name":         aws.ToString(t.TableName),
+		"arn":          aws.ToString(t.TableArn),
+		"status":       string(t.TableStatus),
+		"item_count":   aws.ToInt64(t.ItemCount),
+		"size_bytes":   aws.ToInt64(t.TableSizeBytes),
+		"billing_mode": billing,
+		"key_schema":   keys,
+		"indexes":      indexes,
+	}, nil
+}
+
+func handleUpdateTTL(params []interface{}) (interface{}, error) {
+	// Params: [clientId, tableName, attributeName, enabled]
+	if len(params) < 4 {
+		return nil, fmt.Errorf("expected client_id, table, attribute, enabled")
+	}
+
+	clientId, _ := params[0].(string)
+	tableName, _ := params[1].(string)
+	attr, _ := params[2].(string)
+	enabled, _ := params[3].(bool)
+
+	client := getClient(clientId)
+	if client == nil {
+		return nil, fmt.Errorf("client not found: %s", clientId)
+	}
+
+	_, err := client.UpdateTimeToLive(context.TODO(), &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(attr),
+			Enabled:       aws.Bool(enabled),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+// describeError converts an error into the {code, message, retryable} map
+// returned by last_error. AWS API errors keep their service error code
+// (e.g. ConditionalCheckFailedException); anything else is reported as
+// "PluginError".
+func describeError(err error) map[string]interface{} {
+	if err == nil {
+		return nil
+	}
+
+	code := "PluginError"
+	message := err.Error()
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+		message = apiErr.ErrorMessage()
+	}
+
+	retryable := retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+	if _, ok := retry.DefaultThrottleErrorCodes[code]; ok {
+		retryable = true
+	}
+
+	return map[string]interface{}{
+		"code":      code,
+		"message":   message,
+		"retryable": retryable,
+	}
+}
+
+// readOptions holds the optional settings shared by scan and query.
+type readOptions struct {
+	filter      *string
+	projection  *string
+	index       *string
+	limit       *int32
+	scanForward *bool
+	names       map[string]string
+	values      map[string]interface{}
+}
+
+// parseReadOptions reads the options map at params[pos], if present.
+// Supported keys: filter_expression, projection_expression, index_name,
+// scan_index_forward, limit, expression_attribute_names and
+// expression_attribute_values.
+func parseReadOptions(params []interface{}, pos int) (*readOptions, error) {
+	opts := &readOptions{values: make(map[string]interface{})}
+	if len(params) <= pos || params[pos] == nil {
+		return opts, nil
+	}
+
+	options, ok := params[pos].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("options must be a map")
+	}
+
+	if v, ok := options["filter_expression"].(string); ok && v != "" {
+		opts.filter = aws.String(v)
+	}
+	if v, ok := options["projection_expression"].(string); ok && v != "" {
+		opts.projection = aws.String(v)
+	}
+	if v, ok := options["index_name"].(string); ok && v != "" {
+		opts.index = aws.String(v)
+	}
+	if v, ok := options["scan_index_forward"].(bool); ok {
+		opts.scanForward = aws.Bool(v)
+	}
+	if v, ok := options["limit"].(float64); ok && v > 0 {
+		opts.limit = aws.Int32(int32(v))
+	}
+	names, err := toNameMap(options["expression_attribute_names"])
+	if err != nil {
+		return nil, err
+	}
+	opts.names = names
+	if v, ok := options["expression_attribute_values"].(map[string]interface{}); ok {
+		opts.values = v
+	}
+
+	return opts, nil
+}
+
+// toNameMap converts an expression_attribute_names value into the
+// map[string]string the SDK expects. A missing value yields nil.
+func toNameMap(raw interface{}) (map[string]string, error) {
+	v, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	names := make(map[string]string, len(v))
+	for k, name := range v {
+		s, ok := name.(string)
+		if !ok {
+			return nil, fmt.Errorf("attribute name for %s must be a string", k)
+		}
+		names[k] = s
+	}
+	return names, nil
+}
+
 func getClient(id string) *dynamodb.Client {
 	ClientsLock.Lock()
 	defer ClientsLock.Unlock()
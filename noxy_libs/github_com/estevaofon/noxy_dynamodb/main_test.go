@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeDynamoDB answers every request with one item and records the body of
+// the last one.
+type fakeDynamoDB struct {
+	target string
+	body   map[string]interface{}
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.target = r.Header.Get("X-Amz-Target")
+	f.body = nil
+	json.NewDecoder(r.Body).Decode(&f.body)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.Write([]byte(`{"Items": [{"id": {"S": "a1"}, "status": {"S": "open"}}], "Count": 1}`))
+}
+
+// connectFake starts a fake DynamoDB endpoint and returns it with the id
+// of a client connected to it.
+func connectFake(t *testing.T) (*fakeDynamoDB, string) {
+	t.Helper()
+	// Keep the user's AWS configuration out of the test
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	fake := &fakeDynamoDB{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	id, err := handleConnect([]interface{}{map[string]interface{}{
+		"endpoint_url": server.URL,
+		"access_key":   "test",
+		"secret_key":   "test",
+		"max_attempts": float64(1),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, id.(string)
+}
+
+func TestScanOptions(t *testing.T) {
+	fake, id := connectFake(t)
+
+	items, err := handleScan([]interface{}{id, "tasks", map[string]interface{}{
+		"filter_expression":           "#s = :s",
+		"projection_expression":       "id, #s",
+		"index_name":                  "by_status",
+		"limit":                       float64(5),
+		"expression_attribute_names":  map[string]interface{}{"#s": "status"},
+		"expression_attribute_values": map[string]interface{}{":s": "open"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.target != "DynamoDB_20120810.Scan" {
+		t.Errorf("sent %s, want a Scan", fake.target)
+	}
+	want := map[string]interface{}{
+		"TableName":                 "tasks",
+		"FilterExpression":          "#s = :s",
+		"ProjectionExpression":      "id, #s",
+		"IndexName":                 "by_status",
+		"Limit":                     float64(5),
+		"ExpressionAttributeNames":  map[string]interface{}{"#s": "status"},
+		"ExpressionAttributeValues": map[string]interface{}{":s": map[string]interface{}{"S": "open"}},
+	}
+	if !reflect.DeepEqual(fake.body, want) {
+		t.Errorf("scan request\n%v\nwant\n%v", fake.body, want)
+	}
+	wantItems := []map[string]interface{}{{"id": "a1", "status": "open"}}
+	if !reflect.DeepEqual(items, wantItems) {
+		t.Errorf("scan returned %v, want %v", items, wantItems)
+	}
+
+	// Without options only the table is sent
+	if _, err := handleScan([]interface{}{id, "tasks"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"TableName": "tasks"}; !reflect.DeepEqual(fake.body, want) {
+		t.Errorf("plain scan request %v, want %v", fake.body, want)
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	fake, id := connectFake(t)
+
+	_, err := handleQuery([]interface{}{id, "tasks", "owner = :o", map[string]interface{}{":o": "ana"}, map[string]interface{}{
+		"filter_expression":           "priority > :min",
+		"index_name":                  "by_owner",
+		"scan_index_forward":          false,
+		"limit":                       float64(10),
+		"expression_attribute_values": map[string]interface{}{":min": float64(3)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.target != "DynamoDB_20120810.Query" {
+		t.Errorf("sent %s, want a Query", fake.target)
+	}
+	want := map[string]interface{}{
+		"TableName":              "tasks",
+		"KeyConditionExpression": "owner = :o",
+		"FilterExpression":       "priority > :min",
+		"IndexName":              "by_owner",
+		"ScanIndexForward":       false,
+		"Limit":                  float64(10),
+		// Filter values are merged with the key condition's
+		"ExpressionAttributeValues": map[string]interface{}{
+			":o":   map[string]interface{}{"S": "ana"},
+			":min": map[string]interface{}{"N": "3"},
+		},
+	}
+	if !reflect.DeepEqual(fake.body, want) {
+		t.Errorf("query request\n%v\nwant\n%v", fake.body, want)
+	}
+}
+
+func TestReadOptionErrors(t *testing.T) {
+	_, id := connectFake(t)
+
+	tests := []struct {
+		params []interface{}
+		want   string
+	}{
+		{[]interface{}{id, "tasks", "status = open"}, "options must be a map"},
+		{[]interface{}{id, "tasks", map[string]interface{}{
+			"expression_attribute_names": map[string]interface{}{"#s": float64(1)},
+		}}, "attribute name for #s must be a string"},
+		{[]interface{}{id, "tasks", "pk = :pk", map[string]interface{}{}, "x"}, "options must be a map"},
+	}
+	for _, tt := range tests {
+		var err error
+		if len(tt.params) == 3 {
+			_, err = handleScan(tt.params)
+		} else {
+			_, err = handleQuery(tt.params)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: got %v, want an error containing %q", tt.params[2:], err, tt.want)
+		}
+	}
+}
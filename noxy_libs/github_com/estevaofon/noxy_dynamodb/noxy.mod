@@ -0,0 +1,6 @@
+module noxy_dynamodb
+
+description DynamoDB client for Noxy (Go plugin)
+
+build bash build_plugin.sh
+build.windows powershell -ExecutionPolicy Bypass -File build_plugin.ps1